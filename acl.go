@@ -0,0 +1,882 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/utils"
+)
+
+// Key permissions, a key pattern grants reading, writing or both
+const (
+	ACL_READ_PERMISSION = 1 << iota
+	ACL_WRITE_PERMISSION
+	ACL_ALL_PERMISSION = ACL_READ_PERMISSION | ACL_WRITE_PERMISSION
+)
+
+// Reasons of the ACL LOG entries
+const (
+	ACL_DENIED_CMD     = "command"
+	ACL_DENIED_KEY     = "key"
+	ACL_DENIED_CHANNEL = "channel"
+	ACL_DENIED_AUTH    = "auth"
+)
+
+const (
+	ACLLOG_MAX_LEN        = 128              // entries kept by ACL LOG
+	ACLLOG_GROUPING_DELAY = 60 * time.Second // a denial this close to a similar one is counted in it
+)
+
+// commandCategories are the ACL categories, a command belongs to the one of
+// its group and to the ones derived from its flags
+var commandCategories = []string{
+	"keyspace", "read", "write", "string", "list", "sortedset", "pubsub",
+	"admin", "fast", "slow", "blocking", "dangerous", "connection", "transaction",
+	"scripting",
+}
+
+// categories returns the ACL categories of a command
+func (spec *commandSpec) categories() []string {
+	var categories []string
+	if spec.group != "" {
+		categories = append(categories, spec.group)
+	}
+	if spec.flags&CMD_WRITE != 0 {
+		categories = append(categories, "write")
+	}
+	if spec.flags&CMD_READONLY != 0 {
+		categories = append(categories, "read")
+	}
+	if spec.flags&CMD_ADMIN != 0 {
+		categories = append(categories, "admin", "dangerous")
+	}
+	if spec.flags&CMD_PUBSUB != 0 {
+		categories = append(categories, "pubsub")
+	}
+	if spec.flags&CMD_BLOCKING != 0 {
+		categories = append(categories, "blocking")
+	}
+	if spec.flags&CMD_FAST != 0 {
+		categories = append(categories, "fast")
+	} else {
+		categories = append(categories, "slow")
+	}
+	return categories
+}
+
+// commandsInCategory returns the names of the commands of a category, or
+// of all of them for "all"
+func commandsInCategory(category string) []string {
+	var names []string
+	for name, spec := range commandTable {
+		if category == "all" || slices.Contains(spec.categories(), category) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+type keyPattern struct {
+	pattern string
+	flags   int // ACL_READ_PERMISSION and/or ACL_WRITE_PERMISSION
+}
+
+func (kp keyPattern) String() string {
+	switch kp.flags {
+	case ACL_READ_PERMISSION:
+		return "%R~" + kp.pattern
+	case ACL_WRITE_PERMISSION:
+		return "%W~" + kp.pattern
+	}
+	return "~" + kp.pattern
+}
+
+// aclUser is a user clients authenticate as, with what it may run and access
+type aclUser struct {
+	name         string
+	enabled      bool
+	nopass       bool            // any password authenticates
+	passwords    []string        // SHA-256 digests in hex
+	commands     map[string]bool // allowed commands
+	commandRules []string        // the command rules applied since the last +@all or -@all, to describe them
+	keys         []keyPattern
+	channels     []string // channel patterns
+}
+
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:         name,
+		commands:     make(map[string]bool),
+		commandRules: []string{"-@all"},
+	}
+}
+
+func (u *aclUser) duplicate() *aclUser {
+	dup := *u
+	dup.passwords = slices.Clone(u.passwords)
+	dup.commands = make(map[string]bool, len(u.commands))
+	for name := range u.commands {
+		dup.commands[name] = true
+	}
+	dup.commandRules = slices.Clone(u.commandRules)
+	dup.keys = slices.Clone(u.keys)
+	dup.channels = slices.Clone(u.channels)
+	return &dup
+}
+
+var (
+	errACLSyntax          = errors.New("Syntax error")
+	errACLUnknownCommand  = errors.New("Unknown command or category name in ACL")
+	errACLNoSuchPassword  = errors.New("The password you are trying to remove from the user does not exist")
+	errACLInvalidHash     = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errACLDefaultDeletion = errors.New("ERR The 'default' user cannot be removed")
+)
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func validHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if !('0' <= hash[i] && hash[i] <= '9') && !('a' <= hash[i] && hash[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// setRule applies a single ACL SETUSER rule to the user
+func (u *aclUser) setRule(rule string) error {
+	if rule == "" {
+		return errACLSyntax
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass, u.passwords = true, nil
+	case "resetpass":
+		u.nopass, u.passwords = false, nil
+	case "allkeys":
+		u.keys = []keyPattern{{pattern: "*", flags: ACL_ALL_PERMISSION}}
+	case "resetkeys":
+		u.keys = nil
+	case "allchannels":
+		u.channels = []string{"*"}
+	case "resetchannels":
+		u.channels = nil
+	case "allcommands":
+		return u.setRule("+@all")
+	case "nocommands":
+		return u.setRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.setRule(r)
+		}
+	default:
+		return u.setPrefixedRule(rule)
+	}
+	return nil
+}
+
+func (u *aclUser) setPrefixedRule(rule string) error {
+	arg := rule[1:]
+	switch rule[0] {
+	case '>':
+		u.addPassword(hashPassword(arg))
+	case '#':
+		if !validHash(arg) {
+			return errACLInvalidHash
+		}
+		u.addPassword(arg)
+	case '<', '!':
+		hash := arg
+		if rule[0] == '<' {
+			hash = hashPassword(arg)
+		}
+		i := slices.Index(u.passwords, hash)
+		if i < 0 {
+			return errACLNoSuchPassword
+		}
+		u.passwords = slices.Delete(u.passwords, i, i+1)
+	case '~':
+		u.addKeyPattern(arg, ACL_ALL_PERMISSION)
+	case '%':
+		flags := 0
+		i := 0
+		for ; i < len(arg) && arg[i] != '~'; i++ {
+			switch arg[i] {
+			case 'R', 'r':
+				flags |= ACL_READ_PERMISSION
+			case 'W', 'w':
+				flags |= ACL_WRITE_PERMISSION
+			default:
+				return errACLSyntax
+			}
+		}
+		if flags == 0 || i == len(arg) {
+			return errACLSyntax
+		}
+		u.addKeyPattern(arg[i+1:], flags)
+	case '&':
+		if !slices.Contains(u.channels, arg) {
+			u.channels = append(u.channels, arg)
+		}
+	case '+', '-':
+		return u.setCommandRule(rule[0] == '+', strings.ToLower(arg))
+	default:
+		return errACLSyntax
+	}
+	return nil
+}
+
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+	if !slices.Contains(u.passwords, hash) {
+		u.passwords = append(u.passwords, hash)
+	}
+}
+
+func (u *aclUser) addKeyPattern(pattern string, flags int) {
+	for i := range u.keys {
+		if u.keys[i].pattern == pattern {
+			u.keys[i].flags |= flags
+			return
+		}
+	}
+	u.keys = append(u.keys, keyPattern{pattern: pattern, flags: flags})
+}
+
+// setCommandRule allows or denies a command, or a category given as @name
+func (u *aclUser) setCommandRule(allow bool, name string) error {
+	var names []string
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		if category != "all" && !slices.Contains(commandCategories, category) {
+			return errACLUnknownCommand
+		}
+		names = commandsInCategory(category)
+	} else {
+		if lookupCommand(name) == nil {
+			return errACLUnknownCommand
+		}
+		names = []string{strings.ToUpper(name)}
+	}
+	for _, n := range names {
+		if allow {
+			u.commands[n] = true
+		} else {
+			delete(u.commands, n)
+		}
+	}
+
+	rule := "-"
+	if allow {
+		rule = "+"
+	}
+	rule += name
+	if name == "@all" {
+		u.commandRules = []string{rule}
+	} else {
+		u.commandRules = append(u.commandRules, rule)
+	}
+	return nil
+}
+
+// description returns the rules recreating the user, as ACL LIST shows them
+func (u *aclUser) description() string {
+	rules := []string{"user", u.name}
+	if u.enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if u.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, hash := range u.passwords {
+		rules = append(rules, "#"+hash)
+	}
+	for _, kp := range u.keys {
+		rules = append(rules, kp.String())
+	}
+	if slices.Equal(u.channels, []string{"*"}) {
+		rules = append(rules, "&*")
+	} else {
+		rules = append(rules, "resetchannels")
+		for _, channel := range u.channels {
+			rules = append(rules, "&"+channel)
+		}
+	}
+	rules = append(rules, u.commandRules...)
+	return strings.Join(rules, " ")
+}
+
+// checkKey reports if the user may access key as asked by a "R", "W" or
+// "RW" key access
+func (u *aclUser) checkKey(key string, access string) bool {
+	flags := 0
+	if strings.Contains(access, "R") {
+		flags |= ACL_READ_PERMISSION
+	}
+	if strings.Contains(access, "W") {
+		flags |= ACL_WRITE_PERMISSION
+	}
+	for _, kp := range u.keys {
+		if kp.flags&flags == flags && utils.StringMatch(kp.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkChannel reports if the user may use a channel. A pattern subscribed
+// to must be one of the allowed patterns, not just match one.
+func (u *aclUser) checkChannel(channel string, isPattern bool) bool {
+	for _, allowed := range u.channels {
+		if allowed == "*" || allowed == channel || (!isPattern && utils.StringMatch(allowed, channel, false)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCommand returns why the user can't run a command, and the command,
+// key or channel denied. The reason is empty if it can.
+func (u *aclUser) checkCommand(name string, args []string) (string, string) {
+	name = strings.ToUpper(name)
+	spec := lookupCommand(name)
+	if spec.flags&CMD_NOAUTH != 0 {
+		return "", ""
+	}
+	if !u.commands[name] {
+		return ACL_DENIED_CMD, strings.ToLower(name)
+	}
+	keys := spec.keys(append([]string{name}, args...))
+	for i, key := range keys {
+		access := spec.keyAccess
+		if spec.destAccess != "" && i == len(keys)-1 {
+			access = spec.destAccess
+		}
+		if !u.checkKey(key, access) {
+			return ACL_DENIED_KEY, key
+		}
+	}
+
+	var channels []string
+	isPattern := false
+	switch name {
+	case "PUBLISH", "SPUBLISH":
+		channels = args[:1]
+	case "SUBSCRIBE", "SSUBSCRIBE":
+		channels = args
+	case "PSUBSCRIBE":
+		channels, isPattern = args, true
+	}
+	for _, channel := range channels {
+		if !u.checkChannel(channel, isPattern) {
+			return ACL_DENIED_CHANNEL, channel
+		}
+	}
+	return "", ""
+}
+
+// aclLogEntry is a denied command or authentication, with the ones like it
+type aclLogEntry struct {
+	count      int
+	reason     string
+	context    string // toplevel or multi
+	object     string // the command, key or channel denied
+	username   string
+	clientInfo string
+	entryID    int64
+	created    time.Time
+	updated    time.Time
+}
+
+// acl holds the users and the log of denials
+var acl = struct {
+	mu          sync.RWMutex
+	users       map[string]*aclUser
+	log         []*aclLogEntry // newest first
+	nextEntryID int64
+	file        string // users are saved to and loaded from it, empty if none
+}{
+	users: map[string]*aclUser{"default": newDefaultUser()},
+}
+
+// newDefaultUser returns the user clients start as: it runs everything, with
+// requirepass as its password
+func newDefaultUser() *aclUser {
+	u := newACLUser("default")
+	for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "allcommands"} {
+		u.setRule(rule)
+	}
+	return u
+}
+
+// lookupUser returns a user by name, nil if there is none
+func lookupUser(name string) *aclUser {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	return acl.users[name]
+}
+
+// defaultUserRequiresAuth reports if clients must authenticate, which is
+// when the default user can't be used without a password
+func defaultUserRequiresAuth() bool {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	u := acl.users["default"]
+	return !u.nopass || !u.enabled
+}
+
+// setDefaultUserPassword makes password the only one of the default user,
+// no password at all if empty
+func setDefaultUserPassword(password string) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	u := acl.users["default"]
+	u.setRule("resetpass")
+	if password == "" {
+		u.setRule("nopass")
+	} else {
+		u.setRule(">" + password)
+	}
+}
+
+// checkUserPassword reports if password authenticates the user
+func checkUserPassword(username string, password string) bool {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	u := acl.users[username]
+	if u == nil || !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	ok := false
+	for _, expected := range u.passwords {
+		// compared in constant time, and always to every password
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// addACLLogEntry logs a denial. A denial like one logged less than
+// ACLLOG_GROUPING_DELAY ago counts in it.
+func addACLLogEntry(c *Client, reason string, object string, username string) {
+	context := "toplevel"
+	if c.flags&CLIENT_MULTI != 0 {
+		context = "multi"
+	}
+	now := time.Now()
+	info := c.info()
+
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	for i, entry := range acl.log {
+		if entry.reason == reason && entry.context == context && entry.object == object &&
+			entry.username == username && now.Sub(entry.updated) < ACLLOG_GROUPING_DELAY {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = info
+			copy(acl.log[1:i+1], acl.log[:i])
+			acl.log[0] = entry
+			return
+		}
+	}
+	acl.nextEntryID++
+	entry := &aclLogEntry{
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: info,
+		entryID:    acl.nextEntryID - 1,
+		created:    now,
+		updated:    now,
+	}
+	acl.log = append([]*aclLogEntry{entry}, acl.log...)
+	if len(acl.log) > ACLLOG_MAX_LEN {
+		acl.log = acl.log[:ACLLOG_MAX_LEN]
+	}
+}
+
+// checkPermission returns the NOPERM error for a command the user of the
+// client can't run, and logs it
+func (c *Client) checkPermission(name string, args []string) error {
+	if c.flags&CLIENT_MASTER != 0 {
+		return nil // the master checked the permissions
+	}
+	username := c.username()
+	u := lookupUser(username)
+	if u == nil {
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", username, strings.ToLower(name))
+	}
+	acl.mu.RLock()
+	reason, object := u.checkCommand(name, args)
+	acl.mu.RUnlock()
+
+	switch reason {
+	case "":
+		return nil
+	case ACL_DENIED_CMD:
+		addACLLogEntry(c, reason, object, username)
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", username, object)
+	case ACL_DENIED_KEY:
+		addACLLogEntry(c, reason, object, username)
+		return errors.New("NOPERM No permissions to access a key")
+	default:
+		addACLLogEntry(c, reason, object, username)
+		return errors.New("NOPERM No permissions to access a channel")
+	}
+}
+
+// parseACLRules applies rules to a copy of u, or to a new user if u is nil.
+// The user is only returned if every rule applies.
+func parseACLRules(name string, u *aclUser, rules []string) (*aclUser, error) {
+	if u == nil {
+		u = newACLUser(name)
+	} else {
+		u = u.duplicate()
+	}
+	for _, rule := range rules {
+		if err := u.setRule(rule); err != nil {
+			return nil, fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	return u, nil
+}
+
+// loadACLFile reads the users of an ACL file, one "user name rules..." per
+// line. Nothing changes if a line is invalid. The default user is reset to
+// its defaults if the file doesn't define it.
+func loadACLFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("ERR Error loading ACLs, opening file '%s': %v", path, err)
+	}
+	defer f.Close()
+
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		words := strings.Fields(line)
+		if words[0] != "user" || len(words) < 2 {
+			return fmt.Errorf("ERR %s:%d: line should start with user keyword", path, n)
+		}
+		name := words[1]
+		if _, exists := users[name]; exists {
+			return fmt.Errorf("ERR %s:%d: duplicate user '%s' found", path, n, name)
+		}
+		u := newACLUser(name)
+		for _, rule := range words[2:] {
+			if err := u.setRule(rule); err != nil {
+				return fmt.Errorf("ERR %s:%d: %v. Use ACL LOAD after fixing it", path, n, err)
+			}
+		}
+		users[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ERR Error loading ACLs from '%s': %v", path, err)
+	}
+	if users["default"] == nil {
+		users["default"] = newDefaultUser()
+	}
+
+	acl.mu.Lock()
+	acl.users = users
+	acl.mu.Unlock()
+	disconnectClients(func(c *Client) bool { return users[c.user] == nil })
+	return nil
+}
+
+// saveACLFile writes every user to the ACL file, replacing it at once
+func saveACLFile(path string) error {
+	acl.mu.RLock()
+	names := make([]string, 0, len(acl.users))
+	for name := range acl.users {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var content strings.Builder
+	for _, name := range names {
+		content.WriteString(acl.users[name].description())
+		content.WriteString("\n")
+	}
+	acl.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-acl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// acl handles the ACL subcommands
+func (c *Client) acl(args []string) {
+	w := c.writer()
+	sub := strings.ToUpper(args[0])
+	args = args[1:]
+	switch {
+	case sub == "SETUSER" && len(args) >= 1:
+		c.aclSetUser(args[0], args[1:])
+	case sub == "GETUSER" && len(args) == 1:
+		aclGetUser(w, args[0])
+	case sub == "DELUSER" && len(args) >= 1:
+		aclDelUser(w, args)
+	case sub == "LIST" && len(args) == 0:
+		acl.mu.RLock()
+		descriptions := make([]string, 0, len(acl.users))
+		for _, u := range acl.users {
+			descriptions = append(descriptions, u.description())
+		}
+		acl.mu.RUnlock()
+		slices.Sort(descriptions)
+		w.WriteArrayLen(len(descriptions))
+		for _, description := range descriptions {
+			w.WriteBulkString(description)
+		}
+	case sub == "USERS" && len(args) == 0:
+		acl.mu.RLock()
+		names := make([]string, 0, len(acl.users))
+		for name := range acl.users {
+			names = append(names, name)
+		}
+		acl.mu.RUnlock()
+		slices.Sort(names)
+		w.WriteArrayLen(len(names))
+		for _, name := range names {
+			w.WriteBulkString(name)
+		}
+	case sub == "WHOAMI" && len(args) == 0:
+		w.WriteBulkString(c.username())
+	case sub == "CAT" && len(args) <= 1:
+		names := commandCategories
+		if len(args) == 1 {
+			category := strings.ToLower(args[0])
+			if !slices.Contains(commandCategories, category) {
+				w.WriteError(fmt.Sprintf("ERR Unknown category '%s'", args[0]))
+				return
+			}
+			names = commandsInCategory(category)
+			for i := range names {
+				names[i] = strings.ToLower(names[i])
+			}
+		}
+		w.WriteArrayLen(len(names))
+		for _, name := range names {
+			w.WriteBulkString(name)
+		}
+	case sub == "LOG" && len(args) <= 1:
+		aclLog(w, args)
+	case sub == "SAVE" && len(args) == 0, sub == "LOAD" && len(args) == 0:
+		if acl.file == "" {
+			w.WriteError("ERR This instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a configuration file set) in order to store users in the configuration.")
+			return
+		}
+		if sub == "LOAD" {
+			if err := loadACLFile(acl.file); err != nil {
+				w.WriteError(err.Error())
+				return
+			}
+		} else if err := saveACLFile(acl.file); err != nil {
+			w.WriteError(fmt.Sprintf("ERR There was an error trying to save the ACLs. Please check the server logs for more information: %v", err))
+			return
+		}
+		w.WriteSimpleString("OK")
+	case sub == "HELP" && len(args) == 0:
+		lines := []string{
+			"ACL <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CAT [<category>]",
+			"    List all commands that belong to <category>, or all command categories",
+			"    when no category is specified.",
+			"DELUSER <username> [<username> ...]",
+			"    Delete a list of users.",
+			"GETUSER <username>",
+			"    Get the user's details.",
+			"LIST",
+			"    Show users details in config file format.",
+			"LOAD",
+			"    Reload users from the ACL file.",
+			"LOG [<count> | RESET]",
+			"    Show the ACL log entries.",
+			"SAVE",
+			"    Save the current config to the ACL file.",
+			"SETUSER <username> <attribute> [<attribute> ...]",
+			"    Create or modify a user with the specified attributes.",
+			"USERS",
+			"    List all the registered usernames.",
+			"WHOAMI",
+			"    Return the current connection username.",
+		}
+		w.WriteArrayLen(len(lines))
+		for _, line := range lines {
+			w.WriteSimpleString(line)
+		}
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try ACL HELP.", strings.ToLower(sub)))
+	}
+}
+
+func (c *Client) aclSetUser(name string, rules []string) {
+	w := c.writer()
+	acl.mu.Lock()
+	u, err := parseACLRules(name, acl.users[name], rules)
+	if err == nil {
+		acl.users[name] = u
+	}
+	acl.mu.Unlock()
+	if err != nil {
+		w.WriteError(err.Error())
+		return
+	}
+	w.WriteSimpleString("OK")
+}
+
+func aclGetUser(w *resp.Writer, name string) {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	u := acl.users[name]
+	if u == nil {
+		w.WriteNull()
+		return
+	}
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	keys := make([]string, len(u.keys))
+	for i, kp := range u.keys {
+		keys[i] = kp.String()
+	}
+	channels := make([]string, len(u.channels))
+	for i, channel := range u.channels {
+		channels[i] = "&" + channel
+	}
+
+	w.WriteMapLen(5)
+	w.WriteBulkString("flags")
+	w.WriteSetLen(len(flags))
+	for _, flag := range flags {
+		w.WriteBulkString(flag)
+	}
+	w.WriteBulkString("passwords")
+	w.WriteArrayLen(len(u.passwords))
+	for _, hash := range u.passwords {
+		w.WriteBulkString(hash)
+	}
+	w.WriteBulkString("commands")
+	w.WriteBulkString(strings.Join(u.commandRules, " "))
+	w.WriteBulkString("keys")
+	w.WriteBulkString(strings.Join(keys, " "))
+	w.WriteBulkString("channels")
+	w.WriteBulkString(strings.Join(channels, " "))
+}
+
+func aclDelUser(w *resp.Writer, names []string) {
+	if slices.Contains(names, "default") {
+		w.WriteError(errACLDefaultDeletion.Error())
+		return
+	}
+	deleted := map[string]bool{}
+	acl.mu.Lock()
+	for _, name := range names {
+		if _, exists := acl.users[name]; exists {
+			delete(acl.users, name)
+			deleted[name] = true
+		}
+	}
+	acl.mu.Unlock()
+	// the clients authenticated as a deleted user are gone with it
+	disconnectClients(func(c *Client) bool { return deleted[c.user] })
+	w.WriteInteger(int64(len(deleted)))
+}
+
+// aclLog handles ACL LOG [count|RESET]
+func aclLog(w *resp.Writer, args []string) {
+	count := 10
+	if len(args) == 1 {
+		if strings.EqualFold(args[0], "RESET") {
+			acl.mu.Lock()
+			acl.log = nil
+			acl.mu.Unlock()
+			w.WriteSimpleString("OK")
+			return
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			w.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	entries := acl.log[:min(count, len(acl.log))]
+	now := time.Now()
+	w.WriteArrayLen(len(entries))
+	for _, entry := range entries {
+		w.WriteMapLen(10)
+		w.WriteBulkString("count")
+		w.WriteInteger(int64(entry.count))
+		w.WriteBulkString("reason")
+		w.WriteBulkString(entry.reason)
+		w.WriteBulkString("context")
+		w.WriteBulkString(entry.context)
+		w.WriteBulkString("object")
+		w.WriteBulkString(entry.object)
+		w.WriteBulkString("username")
+		w.WriteBulkString(entry.username)
+		w.WriteBulkString("age-seconds")
+		w.WriteDouble(float64(now.Sub(entry.created).Milliseconds()) / 1000)
+		w.WriteBulkString("client-info")
+		w.WriteBulkString(entry.clientInfo)
+		w.WriteBulkString("entry-id")
+		w.WriteInteger(entry.entryID)
+		w.WriteBulkString("timestamp-created")
+		w.WriteInteger(entry.created.UnixMilli())
+		w.WriteBulkString("timestamp-last-updated")
+		w.WriteInteger(entry.updated.UnixMilli())
+	}
+}
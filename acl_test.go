@@ -0,0 +1,266 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// resetACL leaves only the default user, with no log, once the test is over
+func resetACL(t *testing.T) {
+	t.Cleanup(func() {
+		acl.mu.Lock()
+		acl.users = map[string]*aclUser{"default": newDefaultUser()}
+		acl.log = nil
+		acl.file = ""
+		acl.mu.Unlock()
+	})
+}
+
+func TestACLRules(t *testing.T) {
+	tests := []struct {
+		rules       []string
+		description string
+	}{
+		{nil, "user alice off resetchannels -@all"},
+		{[]string{"on", ">secret", "~cache:*", "%R~users:*", "%W~logs:*", "&news.*", "+@read", "-ttl"},
+			"user alice on #2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b ~cache:* %R~users:* %W~logs:* resetchannels &news.* -@all +@read -ttl"},
+		{[]string{"allkeys", "allchannels", "allcommands", "nopass", "on"}, "user alice on nopass ~* &* +@all"},
+		{[]string{"allcommands", "-@dangerous", "%R~k", "%W~k"}, "user alice off ~k resetchannels +@all -@dangerous"},
+		{[]string{"on", ">a", ">b", "<a", "~x", "reset"}, "user alice off resetchannels -@all"},
+	}
+	for _, tt := range tests {
+		u, err := parseACLRules("alice", nil, tt.rules)
+		if err != nil {
+			t.Fatalf("%v: expected no error, got %v", tt.rules, err)
+		}
+		if description := u.description(); description != tt.description {
+			t.Errorf("%v: expected %q, got %q", tt.rules, tt.description, description)
+		}
+		// the description recreates the user
+		again, err := parseACLRules("alice", nil, strings.Fields(u.description())[2:])
+		if err != nil || again.description() != u.description() {
+			t.Errorf("%v: expected the description to recreate the user, got %v %v", tt.rules, again, err)
+		}
+	}
+
+	errors := map[string]string{
+		"+nosuch":  "Unknown command or category name in ACL",
+		"-@nosuch": "Unknown command or category name in ACL",
+		"<missing": "The password you are trying to remove from the user does not exist",
+		"#abc":     "The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters",
+		"%X~k":     "Syntax error",
+		"%R":       "Syntax error",
+		"bogus":    "Syntax error",
+	}
+	for rule, expected := range errors {
+		u := newACLUser("alice")
+		_, err := parseACLRules("alice", u, []string{"on", rule})
+		if err == nil || !strings.Contains(err.Error(), "'"+rule+"': "+expected) {
+			t.Errorf("%q: expected error %q, got %v", rule, expected, err)
+		}
+		if u.enabled {
+			t.Errorf("%q: expected a failed SETUSER to leave the user unchanged", rule)
+		}
+	}
+}
+
+func TestACLCategories(t *testing.T) {
+	read := commandsInCategory("read")
+	for _, name := range []string{"GET", "LRANGE", "ZRANGE", "EXISTS"} {
+		if !slices.Contains(read, name) {
+			t.Errorf("Expected %s in @read, got %v", name, read)
+		}
+	}
+	if slices.Contains(read, "SET") {
+		t.Errorf("Expected SET not in @read")
+	}
+	dangerous := commandsInCategory("dangerous")
+	for _, name := range []string{"ACL", "BGSAVE", "CLIENT", "REPLICAOF"} {
+		if !slices.Contains(dangerous, name) {
+			t.Errorf("Expected %s in @dangerous, got %v", name, dangerous)
+		}
+	}
+	if slices.Contains(dangerous, "GET") {
+		t.Errorf("Expected GET not in @dangerous")
+	}
+	if blocking := commandsInCategory("blocking"); !slices.Equal(blocking, []string{"BLMOVE", "BLPOP", "BRPOP", "BZPOPMIN", "WAIT", "WAITAOF"}) {
+		t.Errorf("Expected the blocking commands in @blocking, got %v", blocking)
+	}
+}
+
+func TestACLCheckCommand(t *testing.T) {
+	u, err := parseACLRules("alice", nil, []string{"on", "+@all", "-bgsave", "%R~r:*", "%W~w:*", "~rw:*", "&news.*", "&exact"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		command string
+		reason  string
+		object  string
+	}{
+		{"GET r:1", "", ""},
+		{"GET w:1", "key", "w:1"},
+		{"SET w:1 v", "", ""},
+		{"SET r:1 v", "key", "r:1"},
+		{"LPOP w:1", "key", "w:1"},
+		{"LPOP rw:1", "", ""},
+		{"COPY rw:1 rw:2", "", ""},
+		{"COPY r:1 w:2", "", ""},
+		{"COPY w:1 w:2", "key", "w:1"},
+		{"COPY r:1 r:2", "key", "r:2"},
+		{"LMOVE rw:1 w:2 LEFT RIGHT", "", ""},
+		{"LMOVE r:1 w:2 LEFT RIGHT", "key", "r:1"},
+		{"BLMOVE rw:1 r:2 LEFT RIGHT 0", "key", "r:2"},
+		{"DEL w:1 rw:1 other", "key", "other"},
+		{"BLPOP rw:1 rw:2 0", "", ""},
+		{"BGSAVE", "command", "bgsave"},
+		{"AUTH pass", "", ""},
+		{"OBJECT HELP", "", ""},
+		{"MEMORY USAGE r:1", "", ""},
+		{"PUBLISH news.today hi", "", ""},
+		{"PUBLISH sports hi", "channel", "sports"},
+		{"SUBSCRIBE exact news.x sports", "channel", "sports"},
+		{"PSUBSCRIBE news.*", "", ""},
+		{"PSUBSCRIBE news.t*", "channel", "news.t*"},
+	}
+	for _, tt := range tests {
+		words := strings.Fields(tt.command)
+		reason, object := u.checkCommand(words[0], words[1:])
+		if reason != tt.reason || object != tt.object {
+			t.Errorf("%s: expected %q %q, got %q %q", tt.command, tt.reason, tt.object, reason, object)
+		}
+	}
+}
+
+func TestACL(t *testing.T) {
+	resetACL(t)
+	addr := startServer(t)
+
+	t.Run("users", func(t *testing.T) {
+		admin := dial(t, addr)
+		admin.send("ACL", "SETUSER", "alice", "on", ">pass", "~app:*", "&app.*", "+@read", "+@write", "-del")
+		admin.expect("+OK\r\n")
+		admin.send("ACL", "SETUSER", "alice", "+nosuch")
+		admin.expect("-ERR Error in ACL SETUSER modifier '+nosuch': Unknown command or category name in ACL\r\n")
+		admin.send("ACL", "USERS")
+		admin.expect("*2\r\n$5\r\nalice\r\n$7\r\ndefault\r\n")
+		admin.send("ACL", "LIST")
+		description := "user alice on #d74ff0ee8da3b9806b18c877dbf29bbde50b5bd8e4dad7a3a725000feb82e8f1 ~app:* resetchannels &app.* -@all +@read +@write -del"
+		admin.expect("*2\r\n$" + strconv.Itoa(len(description)) + "\r\n" + description + "\r\n$34\r\nuser default on nopass ~* &* +@all\r\n")
+		admin.send("ACL", "GETUSER", "alice")
+		admin.expect("*10\r\n$5\r\nflags\r\n*1\r\n$2\r\non\r\n$9\r\npasswords\r\n*1\r\n$64\r\nd74ff0ee8da3b9806b18c877dbf29bbde50b5bd8e4dad7a3a725000feb82e8f1\r\n" +
+			"$8\r\ncommands\r\n$25\r\n-@all +@read +@write -del\r\n$4\r\nkeys\r\n$6\r\n~app:*\r\n$8\r\nchannels\r\n$6\r\n&app.*\r\n")
+		admin.send("ACL", "GETUSER", "nobody")
+		admin.expect("$-1\r\n")
+		admin.send("ACL", "WHOAMI")
+		admin.expect("$7\r\ndefault\r\n")
+	})
+
+	t.Run("permissions", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("AUTH", "alice", "wrong")
+		c.expect("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+		c.send("AUTH", "alice", "pass")
+		c.expect("+OK\r\n")
+		c.send("ACL", "WHOAMI")
+		c.expect("-NOPERM User alice has no permissions to run the 'acl' command\r\n")
+		c.send("SET", "app:key", "value")
+		c.expect("+OK\r\n")
+		c.send("GET", "other")
+		c.expect("-NOPERM No permissions to access a key\r\n")
+		c.send("DEL", "app:key")
+		c.expect("-NOPERM User alice has no permissions to run the 'del' command\r\n")
+		c.send("MULTI")
+		c.expect("-NOPERM User alice has no permissions to run the 'multi' command\r\n")
+	})
+
+	t.Run("log", func(t *testing.T) {
+		admin := dial(t, addr)
+		admin.send("ACL", "LOG", "1")
+		admin.expect("*1\r\n*20\r\n$5\r\ncount\r\n:1\r\n$6\r\nreason\r\n$7\r\ncommand\r\n$7\r\ncontext\r\n$8\r\ntoplevel\r\n$6\r\nobject\r\n$5\r\nmulti\r\n$8\r\nusername\r\n$5\r\nalice\r\n")
+		acl.mu.RLock()
+		var reasons []string
+		for _, entry := range acl.log {
+			reasons = append(reasons, entry.reason+":"+entry.object)
+		}
+		acl.mu.RUnlock()
+		expected := []string{"command:multi", "command:del", "key:other", "command:acl", "auth:AUTH"}
+		if !slices.Equal(reasons, expected) {
+			t.Errorf("Expected the log %v, got %v", expected, reasons)
+		}
+		// the rest of the entry is left unread
+		reset := dial(t, addr)
+		reset.send("ACL", "LOG", "RESET")
+		reset.expect("+OK\r\n")
+	})
+
+	t.Run("similar denials are grouped", func(t *testing.T) {
+		c := NewClient(kvStore, nil, io.Discard)
+		run(c, "AUTH alice pass", "GET secret", "GET secret", "GET secret")
+		acl.mu.RLock()
+		defer acl.mu.RUnlock()
+		if len(acl.log) != 1 || acl.log[0].count != 3 {
+			t.Errorf("Expected a single entry counted 3 times, got %v", acl.log)
+		}
+	})
+
+	t.Run("deleted users are disconnected", func(t *testing.T) {
+		admin := dial(t, addr)
+		c := dial(t, addr)
+		c.send("AUTH", "alice", "pass")
+		c.expect("+OK\r\n")
+		admin.send("ACL", "DELUSER", "alice", "nobody")
+		admin.expect(":1\r\n")
+		if _, err := c.reader.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the connection to be closed, got %v", err)
+		}
+		admin.send("ACL", "DELUSER", "default")
+		admin.expect("-ERR The 'default' user cannot be removed\r\n")
+	})
+
+	t.Run("save and load", func(t *testing.T) {
+		admin := dial(t, addr)
+		admin.send("ACL", "SAVE")
+		admin.expect("-ERR This instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a configuration file set) in order to store users in the configuration.\r\n")
+
+		acl.file = filepath.Join(t.TempDir(), "users.acl")
+		admin.send("ACL", "SETUSER", "bob", "on", "nopass", "%R~*", "+get")
+		admin.expect("+OK\r\n")
+		admin.send("ACL", "SAVE")
+		admin.expect("+OK\r\n")
+		data, err := os.ReadFile(acl.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "user bob on nopass %R~* resetchannels -@all +get\nuser default on nopass ~* &* +@all\n"
+		if string(data) != expected {
+			t.Errorf("Expected the ACL file %q, got %q", expected, data)
+		}
+
+		admin.send("ACL", "DELUSER", "bob")
+		admin.expect(":1\r\n")
+		admin.send("ACL", "LOAD")
+		admin.expect("+OK\r\n")
+		admin.send("ACL", "USERS")
+		admin.expect("*2\r\n$3\r\nbob\r\n$7\r\ndefault\r\n")
+
+		os.WriteFile(acl.file, []byte("user carol on\nuser carol off\n"), 0644)
+		admin.send("ACL", "LOAD")
+		admin.expect("-ERR " + acl.file + ":2: duplicate user 'carol' found\r\n")
+		admin.send("ACL", "USERS")
+		admin.expect("*2\r\n$3\r\nbob\r\n$7\r\ndefault\r\n")
+	})
+
+	t.Run("categories", func(t *testing.T) {
+		admin := dial(t, addr)
+		admin.send("ACL", "CAT", "blocking")
+		admin.expect("*6\r\n$6\r\nblmove\r\n$5\r\nblpop\r\n$5\r\nbrpop\r\n$8\r\nbzpopmin\r\n$4\r\nwait\r\n$7\r\nwaitaof\r\n")
+		admin.send("ACL", "CAT", "nosuch")
+		admin.expect("-ERR Unknown category 'nosuch'\r\n")
+	})
+}
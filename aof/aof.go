@@ -4,21 +4,115 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/shubhdevelop/YAKVS/parser"
 )
 
+// Fsync policies of the AOF, as appendfsync in Redis
+const (
+	AOF_FSYNC_ALWAYS   = "always"   // every write is fsynced before the command replies
+	AOF_FSYNC_EVERYSEC = "everysec" // the server fsyncs every second
+	AOF_FSYNC_NO       = "no"       // the OS decides when the writes reach the disk
+)
+
 type AOFManager struct {
 	writeFile *os.File
 	readFile  *os.File
 	filename  string
+
+	mu            sync.Mutex
+	fsyncPolicy   string
+	offset        int64         // bytes appended since startup
+	fsyncedOffset int64         // bytes appended since startup known to be on disk
+	size          int64         // bytes of the file
+	baseSize      int64         // bytes of the file at startup or after the last rewrite
+	rewriteTime   time.Duration // how long the last rewrite took, -1 before any
+
+	fsyncLatencyHook func(time.Duration, bool) // see SetFsyncLatencyHook
 }
 
 func NewAOFManager(filename string) *AOFManager {
 	return &AOFManager{
-		filename: filename,
+		filename:    filename,
+		fsyncPolicy: AOF_FSYNC_ALWAYS,
+		rewriteTime: -1,
+	}
+}
+
+// CheckFsyncPolicy reports if policy is one of the AOF_FSYNC_ constants
+func CheckFsyncPolicy(policy string) error {
+	switch policy {
+	case AOF_FSYNC_ALWAYS, AOF_FSYNC_EVERYSEC, AOF_FSYNC_NO:
+		return nil
+	}
+	return fmt.Errorf("invalid fsync policy %q, expected always, everysec or no", policy)
+}
+
+// SetFsyncPolicy sets when the writes are fsynced, see the AOF_FSYNC_
+// constants
+func (aof *AOFManager) SetFsyncPolicy(policy string) error {
+	if err := CheckFsyncPolicy(policy); err != nil {
+		return err
 	}
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.fsyncPolicy = policy
+	return nil
+}
+
+// SetFsyncLatencyHook sets a function called with the time every fsync
+// took, and whether it was the fsync of a write with appendfsync always.
+// It must be set before the AOF is written to.
+func (aof *AOFManager) SetFsyncLatencyHook(hook func(latency time.Duration, always bool)) {
+	aof.fsyncLatencyHook = hook
+}
+
+// FsyncPolicy returns when the writes are fsynced
+func (aof *AOFManager) FsyncPolicy() string {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.fsyncPolicy
+}
+
+// Offsets returns the number of bytes appended since startup, and how many
+// of them were fsynced
+func (aof *AOFManager) Offsets() (int64, int64) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.offset, aof.fsyncedOffset
+}
+
+// Fsync flushes what was appended to the disk. The appends go on while
+// the file is fsynced.
+func (aof *AOFManager) Fsync() error {
+	aof.mu.Lock()
+	offset, file := aof.offset, aof.writeFile
+	upToDate := offset == aof.fsyncedOffset
+	aof.mu.Unlock()
+	if file == nil || upToDate {
+		return nil
+	}
+	start := time.Now()
+	if err := file.Sync(); err != nil {
+		aof.mu.Lock()
+		rewritten := aof.writeFile != file
+		aof.mu.Unlock()
+		if rewritten {
+			return nil // closed by a rewrite, which fsynced what it replaced
+		}
+		return fmt.Errorf("failed to fsync AOF file: %v", err)
+	}
+	if aof.fsyncLatencyHook != nil {
+		aof.fsyncLatencyHook(time.Since(start), false)
+	}
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.fsyncedOffset = max(aof.fsyncedOffset, offset)
+	return nil
 }
 
 
@@ -29,6 +123,9 @@ func (aof *AOFManager) Initialize() error {
 		return fmt.Errorf("error opening write file: %v", err)
 	}
 	aof.writeFile = writeFile
+	if info, err := writeFile.Stat(); err == nil {
+		aof.size, aof.baseSize = info.Size(), info.Size()
+	}
 
 	// Open file for reading
 	readFile, err := os.Open(aof.filename)
@@ -65,13 +162,56 @@ func (aof *AOFManager) WriteCommand(command string) error {
 		return fmt.Errorf("write file not initialized")
 	}
 	
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
 	_, err := aof.writeFile.WriteString(command)
 	if err != nil {
 		return fmt.Errorf("failed to write to AOF file: %v", err)
 	}
+	aof.offset += int64(len(command))
+	aof.size += int64(len(command))
+	if aof.fsyncPolicy != AOF_FSYNC_ALWAYS {
+		return nil
+	}
 	
 	// Flush to ensure data is written to disk
-	return aof.writeFile.Sync()
+	start := time.Now()
+	if err := aof.writeFile.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync AOF file: %v", err)
+	}
+	if aof.fsyncLatencyHook != nil {
+		aof.fsyncLatencyHook(time.Since(start), true)
+	}
+	aof.fsyncedOffset = aof.offset
+	return nil
+}
+
+// AppendCommand appends cmd to the AOF as a RESP array
+func (aof *AOFManager) AppendCommand(cmd *parser.Command) error {
+	return aof.WriteCommand(EncodeCommand(cmd))
+}
+
+// AppendTransaction appends cmds wrapped in MULTI/EXEC with a single write,
+// so a crash can't persist half of a transaction
+func (aof *AOFManager) AppendTransaction(cmds []*parser.Command) error {
+	var buf strings.Builder
+	buf.WriteString(EncodeCommand(&parser.Command{Name: "MULTI"}))
+	for _, cmd := range cmds {
+		buf.WriteString(EncodeCommand(cmd))
+	}
+	buf.WriteString(EncodeCommand(&parser.Command{Name: "EXEC"}))
+	return aof.WriteCommand(buf.String())
+}
+
+// EncodeCommand returns cmd as a RESP array of bulk strings
+func EncodeCommand(cmd *parser.Command) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("*%d\r\n", len(cmd.Args)+1))
+	buf.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(cmd.Name), cmd.Name))
+	for _, arg := range cmd.Args {
+		buf.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	return buf.String()
 }
 
 func (aof *AOFManager) ReadAndExecuteCommands(executeFunc func(*parser.Command)) error {
@@ -89,11 +229,15 @@ func (aof *AOFManager) ReadAndExecuteCommands(executeFunc func(*parser.Command))
 	}
 	
 	// Parse the entire file as RESP commands
-	parser := parser.NewStreamingParser(fileContent)
+	respParser := parser.NewStreamingParser(fileContent)
 	
-	// Parse all commands in the file
+	// Parse all commands in the file. Commands between MULTI and EXEC are
+	// only executed once EXEC is read: a transaction cut short by a crash
+	// is dropped as a whole.
+	var transaction []*parser.Command
+	inTransaction := false
 	for {
-		command, err := parser.ParseCommand()
+		command, err := respParser.ParseCommand()
 		if err == io.EOF {
 			break
 		}
@@ -101,25 +245,166 @@ func (aof *AOFManager) ReadAndExecuteCommands(executeFunc func(*parser.Command))
 			fmt.Printf("Error parsing RESP command: %v\n", err)
 			break
 		}
-		executeFunc(command)
+		switch strings.ToUpper(command.Name) {
+		case "MULTI":
+			inTransaction = true
+			transaction = transaction[:0]
+		case "EXEC":
+			for _, queued := range transaction {
+				executeFunc(queued)
+			}
+			inTransaction = false
+			transaction = transaction[:0]
+		default:
+			if inTransaction {
+				transaction = append(transaction, command)
+			} else {
+				executeFunc(command)
+			}
+		}
 	}
-	
+	if inTransaction {
+		fmt.Println("Reverting incomplete MULTI/EXEC transaction in AOF file")
+	}
+
 	return nil
 }
 
+// persistentCommands are the commands that modify data
+var persistentCommands = map[string]bool{
+	"SET":      true,
+	"DEL":      true,
+	"EXPIRE":   true,
+	"EXPIREAT": true,
+	"PERSIST":  true,
+	"INCRBY":   true,
+	"DECRBY":   true,
+	"COPY":     true,
+	"LPUSH":    true,
+	"RPUSH":    true,
+	"LPOP":     true,
+	"RPOP":     true,
+	"LMOVE":    true,
+	"ZADD":     true,
+	"ZPOPMIN":  true,
+	"RESTORE":  true,
+	"FUNCTION": true,
+	// Add more commands that modify data as needed
+}
+
+// ShouldPersistCommand reports if the command modifies data, the commands
+// selected are appended to the AOF and streamed to the replicas
+func ShouldPersistCommand(commandName string) bool {
+	return persistentCommands[strings.ToUpper(commandName)]
+}
+
 func (aof *AOFManager) ShouldPersistCommand(commandName string) bool {
-	// Only persist commands that modify data
-	persistentCommands := map[string]bool{
-		"SET": true,
-		"DEL": true,
-		"EXPIRE": true,
-		"EXPIREAT": true,
-		"PERSIST": true,
-		"INCRBY": true,
-		"DECRBY": true,
-		// Add more commands that modify data as needed
+	return ShouldPersistCommand(commandName)
+}
+
+// Rewrite replaces the content of the AOF with commands, like the snapshot
+// a replica gets from its master
+func (aof *AOFManager) Rewrite(commands []byte) error {
+	return aof.RewriteFrom(func(w io.Writer) error {
+		_, err := w.Write(commands)
+		return err
+	})
+}
+
+// RewriteFrom replaces the content of the AOF with what write writes. It
+// is written to a temporary file renamed over the AOF once fsynced, so the
+// AOF is never left empty or partial: on an error or a crash it is the old
+// one. The appends wait for the rewrite.
+func (aof *AOFManager) RewriteFrom(write func(w io.Writer) error) error {
+	if aof.writeFile == nil {
+		return fmt.Errorf("write file not initialized")
 	}
-	return persistentCommands[strings.ToUpper(commandName)]
+	start := time.Now()
+	dir := filepath.Dir(aof.filename)
+	temp, err := os.CreateTemp(dir, "temp-rewrite-*.aof")
+	if err != nil {
+		return fmt.Errorf("failed to create the rewritten AOF file: %v", err)
+	}
+	defer os.Remove(temp.Name()) // fails once renamed
+	size, err := writeTempFile(temp, write)
+	if err != nil {
+		return err
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if err := os.Rename(temp.Name(), aof.filename); err != nil {
+		return fmt.Errorf("failed to rename the rewritten AOF file: %v", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	writeFile, err := os.OpenFile(aof.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening write file: %v", err)
+	}
+	aof.writeFile.Close()
+	aof.writeFile = writeFile
+	aof.offset += size
+	aof.fsyncedOffset = aof.offset
+	aof.size, aof.baseSize = size, size
+	aof.rewriteTime = time.Since(start)
+	return nil
+}
+
+// writeTempFile writes the content of a rewrite to temp, fsyncs and closes
+// it. It returns the size written.
+func writeTempFile(temp *os.File, write func(w io.Writer) error) (int64, error) {
+	defer temp.Close()
+	w := &countingWriter{w: temp}
+	if err := write(w); err != nil {
+		return 0, fmt.Errorf("failed to write the rewritten AOF file: %v", err)
+	}
+	if err := temp.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to fsync the rewritten AOF file: %v", err)
+	}
+	return w.n, temp.Close()
+}
+
+// syncDir fsyncs a directory, so a file renamed in it stays renamed after a
+// crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open the AOF directory: %v", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync the AOF directory: %v", err)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Sizes returns the size of the file, and its size at startup or after the
+// last rewrite
+func (aof *AOFManager) Sizes() (int64, int64) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.size, aof.baseSize
+}
+
+// LastRewriteTime returns how long the last rewrite took, -1 if the file
+// was never rewritten
+func (aof *AOFManager) LastRewriteTime() time.Duration {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.rewriteTime
 }
 
 func (aof *AOFManager) GetWriteFile() *os.File {
@@ -0,0 +1,70 @@
+package aof
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+)
+
+func TestRewrite(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.aof")
+	manager := NewAOFManager(filename)
+	if err := manager.Initialize(); err != nil {
+		t.Fatalf("Error initializing AOF manager: %v", err)
+	}
+	defer manager.Close()
+	set := func(key string) *parser.Command {
+		return &parser.Command{Name: "SET", Args: []string{key, "1"}}
+	}
+	manager.AppendCommand(set("old"))
+	old := EncodeCommand(set("old"))
+
+	expectContent := func(expected string) {
+		t.Helper()
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Error reading AOF file: %v", err)
+		}
+		if string(content) != expected {
+			t.Errorf("Expected the AOF %q, got %q", expected, content)
+		}
+	}
+
+	t.Run("a failed rewrite leaves the AOF as it was", func(t *testing.T) {
+		err := manager.RewriteFrom(func(w io.Writer) error {
+			io.WriteString(w, EncodeCommand(set("partial")))
+			return errors.New("connection lost")
+		})
+		if err == nil {
+			t.Fatal("Expected the rewrite to fail")
+		}
+		expectContent(old)
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("Expected the temporary file removed, got %v", entries)
+		}
+
+		manager.AppendCommand(set("after"))
+		old += EncodeCommand(set("after"))
+		expectContent(old)
+	})
+
+	t.Run("a rewrite replaces the AOF", func(t *testing.T) {
+		snapshot := EncodeCommand(set("snapshot"))
+		if err := manager.Rewrite([]byte(snapshot)); err != nil {
+			t.Fatalf("Error rewriting the AOF: %v", err)
+		}
+		manager.AppendCommand(set("appended"))
+		expectContent(snapshot + EncodeCommand(set("appended")))
+		if size, baseSize := manager.Sizes(); baseSize != int64(len(snapshot)) || size != baseSize+int64(len(EncodeCommand(set("appended")))) {
+			t.Errorf("Unexpected sizes %d %d", size, baseSize)
+		}
+		if err := manager.Fsync(); err != nil {
+			t.Errorf("Error fsyncing the rewritten AOF: %v", err)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+
+// requirePass is the password of the default user as set by requirepass,
+// empty for no password. The password checked is the one of the user, see
+// acl.go.
+var requirePass atomic.Pointer[string]
+
+func setRequirePass(password string) {
+	requirePass.Store(&password)
+	setDefaultUserPassword(password)
+}
+
+func getRequirePass() string {
+	if password := requirePass.Load(); password != nil {
+		return *password
+	}
+	return ""
+}
+
+// authenticate authenticates the client as username if password is one of
+// its passwords. Failures are logged in the ACL LOG.
+func (c *Client) authenticate(username string, password string) error {
+	if !checkUserPassword(username, password) {
+		addACLLogEntry(c, ACL_DENIED_AUTH, "AUTH", username)
+		return errWrongPass
+	}
+	c.setUsername(username)
+	c.authenticated = true
+	return nil
+}
+
+// authRequired reports if the client must authenticate before running
+// commands other than AUTH, HELLO and QUIT: the default user it started as
+// couldn't be used without a password when it connected. Like in Redis, the
+// clients already connected aren't affected by a password set later.
+func (c *Client) authRequired() bool {
+	return !c.authenticated
+}
+
+// auth handles AUTH [username] password
+func (c *Client) auth(args []string) {
+	w := c.writer()
+	if len(args) > 2 {
+		w.WriteError("ERR syntax error")
+		return
+	}
+	username, password := "default", args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	} else if !defaultUserRequiresAuth() {
+		w.WriteError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+	if err := c.authenticate(username, password); err != nil {
+		w.WriteError(err.Error())
+		return
+	}
+	w.WriteSimpleString("OK")
+}
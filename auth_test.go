@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	addr := startServer(t)
+
+	t.Run("without requirepass", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("GET", "auth:key")
+		c.expect("$-1\r\n")
+		c.send("AUTH", "anything")
+		c.expect("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n")
+		c.send("AUTH", "default", "anything")
+		c.expect("+OK\r\n")
+	})
+
+	connected := dial(t, addr)
+	connected.send("PING")
+	connected.expect("+PONG\r\n")
+	setRequirePass("s3cret")
+	t.Cleanup(func() { setRequirePass("") })
+
+	t.Run("clients connected before keep running commands", func(t *testing.T) {
+		connected.send("GET", "auth:key")
+		connected.expect("$-1\r\n")
+	})
+
+	t.Run("commands need authentication", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("SET", "auth:key", "value")
+		c.expect("-NOAUTH Authentication required.\r\n")
+		c.send("PING")
+		c.expect("-NOAUTH Authentication required.\r\n")
+		c.send("NOSUCH")
+		c.expect("-ERR unknown command 'NOSUCH'\r\n")
+		c.send("HELLO", "3")
+		c.expect("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n")
+		c.send("QUIT")
+		c.expect("+OK\r\n")
+	})
+
+	t.Run("legacy AUTH password", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("AUTH", "wrong")
+		c.expect("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+		c.send("AUTH", "s3cret")
+		c.expect("+OK\r\n")
+		c.send("SET", "auth:key", "value")
+		c.expect("+OK\r\n")
+	})
+
+	t.Run("AUTH username password", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("AUTH", "alice", "s3cret")
+		c.expect("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+		c.send("AUTH", "default", "s3cret", "extra")
+		c.expect("-ERR syntax error\r\n")
+		c.send("AUTH", "default", "s3cret")
+		c.expect("+OK\r\n")
+		c.send("GET", "auth:key")
+		c.expect("$5\r\nvalue\r\n")
+	})
+
+	t.Run("requests are limited until AUTH", func(t *testing.T) {
+		c := dial(t, addr)
+		c.conn.Write([]byte("*1\r\n$20000\r\n"))
+		c.expect("-ERR Protocol error: unauthenticated bulk length\r\n")
+		if _, err := c.reader.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the connection to be closed, got %v", err)
+		}
+
+		c = dial(t, addr)
+		c.conn.Write([]byte("*11\r\n"))
+		c.expect("-ERR Protocol error: unauthenticated multibulk length\r\n")
+
+		// the command pipelined after AUTH isn't limited anymore
+		c = dial(t, addr)
+		value := strings.Repeat("v", 20000)
+		c.conn.Write([]byte("*2\r\n$4\r\nAUTH\r\n$6\r\ns3cret\r\n*3\r\n$3\r\nSET\r\n$8\r\nauth:big\r\n$20000\r\n" + value + "\r\n"))
+		c.expect("+OK\r\n+OK\r\n")
+	})
+
+	t.Run("HELLO AUTH", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("HELLO", "3", "AUTH", "default", "wrong")
+		c.expect("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+		c.send("HELLO", "3", "AUTH", "default", "s3cret")
+		c.expectHello("3")
+		c.send("GET", "auth:missing")
+		c.expect("_\r\n")
+	})
+}
+
+func TestCheckUserPassword(t *testing.T) {
+	setRequirePass("s3cret")
+	defer setRequirePass("")
+	for _, tt := range []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"default", "s3cret", true},
+		{"default", "s3cre", false},
+		{"default", "s3cret!", false},
+		{"default", "", false},
+		{"other", "s3cret", false},
+	} {
+		if ok := checkUserPassword(tt.username, tt.password); ok != tt.ok {
+			t.Errorf("checkUserPassword(%q, %q): expected %v, got %v", tt.username, tt.password, tt.ok, ok)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/command"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+var (
+	errTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("ERR timeout is negative")
+	errSyntax          = errors.New("ERR syntax error")
+)
+
+// blockingOp is a parsed BLPOP, BRPOP, BLMOVE or BZPOPMIN
+type blockingOp struct {
+	name    string
+	args    []string
+	keys    []string      // the keys the client waits on, in order
+	timeout time.Duration // 0 waits forever
+}
+
+func parseBlockingCommand(cmd *parser.Command) (*blockingOp, error) {
+	op := &blockingOp{name: strings.ToUpper(cmd.Name), args: cmd.Args}
+	last := len(cmd.Args) - 1
+	seconds, err := strconv.ParseFloat(cmd.Args[last], 64)
+	if err != nil || math.IsNaN(seconds) || seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return nil, errTimeoutNotFloat
+	}
+	if seconds < 0 {
+		return nil, errTimeoutNegative
+	}
+	op.timeout = time.Duration(seconds * float64(time.Second))
+
+	if op.name == "BLMOVE" {
+		_, ok1 := command.ParseListEnd(cmd.Args[2])
+		_, ok2 := command.ParseListEnd(cmd.Args[3])
+		if !ok1 || !ok2 {
+			return nil, errSyntax
+		}
+		op.keys = cmd.Args[:1]
+	} else {
+		op.keys = cmd.Args[:last]
+	}
+	return op, nil
+}
+
+// serve runs the non blocking form of the command: it returns false if
+// none of the keys holds anything to pop. Otherwise the reply is written,
+// along with the command to propagate in place of the blocking one.
+func (op *blockingOp) serve(s *store.Store, w *resp.Writer) (bool, *parser.Command) {
+	switch op.name {
+	case "BLMOVE":
+		from, _ := command.ParseListEnd(op.args[2])
+		to, _ := command.ParseListEnd(op.args[3])
+		value, moved, err := s.ListMove(op.args[0], op.args[1], from, to)
+		if err != nil {
+			w.WriteError(err.Error())
+			return true, nil
+		}
+		if !moved {
+			return false, nil
+		}
+		w.WriteBulkString(value)
+		return true, &parser.Command{Name: "LMOVE", Args: op.args[:4]}
+	case "BZPOPMIN":
+		for _, key := range op.keys {
+			members, err := s.ZPopMin(key, 1)
+			if err != nil {
+				w.WriteError(err.Error())
+				return true, nil
+			}
+			if len(members) > 0 {
+				w.WriteArrayLen(3)
+				w.WriteBulkString(key)
+				w.WriteBulkString(members[0].Member)
+				w.WriteDouble(members[0].Score)
+				return true, &parser.Command{Name: "ZPOPMIN", Args: []string{key}}
+			}
+		}
+	default:
+		where, popName := store.LIST_HEAD, "LPOP"
+		if op.name == "BRPOP" {
+			where, popName = store.LIST_TAIL, "RPOP"
+		}
+		for _, key := range op.keys {
+			values, err := s.Pop(key, 1, where)
+			if err != nil {
+				w.WriteError(err.Error())
+				return true, nil
+			}
+			if len(values) > 0 {
+				w.WriteArrayLen(2)
+				w.WriteBulkString(key)
+				w.WriteBulkString(values[0])
+				return true, &parser.Command{Name: popName, Args: []string{key}}
+			}
+		}
+	}
+	return false, nil
+}
+
+// writeTimeout writes the reply of a client that wasn't served
+func (op *blockingOp) writeTimeout(w *resp.Writer) {
+	if op.name == "BLMOVE" {
+		w.WriteNull()
+	} else {
+		w.WriteNullArray()
+	}
+}
+
+// blockedClient is a client waiting for one of its keys to serve it
+type blockedClient struct {
+	client *Client
+	op     *blockingOp
+	reply  bytes.Buffer  // written by the client serving it
+	served chan struct{} // closed once served
+	done   bool          // served or given up, guarded by blocking.mu
+}
+
+// writer returns a writer for the reply, in the protocol of the client
+func (bc *blockedClient) writer() *resp.Writer {
+	return resp.NewWriter(&bc.reply, int(bc.client.resp.Load()))
+}
+
+// blocking tracks the clients blocked on every key, in the order they
+// blocked. A key modified while clients wait is marked ready, and once the
+// command modifying it is over the clients blocked on it are served.
+var blocking = struct {
+	mu      sync.Mutex
+	keys    map[string][]*blockedClient
+	waiting atomic.Int64 // clients blocked or about to be, keys are only marked ready while there are some
+
+	readyMu  sync.Mutex // taken with shards locked, nothing else is locked under it
+	ready    []string
+	readySet map[string]struct{}
+}{
+	keys:     make(map[string][]*blockedClient),
+	readySet: make(map[string]struct{}),
+}
+
+// signalKeyAsReady is the modified key hook of the store, it marks the key
+// ready if clients may be blocked on it
+func signalKeyAsReady(key string) {
+	if blocking.waiting.Load() == 0 {
+		return
+	}
+	blocking.readyMu.Lock()
+	defer blocking.readyMu.Unlock()
+	if _, ready := blocking.readySet[key]; !ready {
+		blocking.readySet[key] = struct{}{}
+		blocking.ready = append(blocking.ready, key)
+	}
+}
+
+// takeReadyKeys returns the keys marked ready since the last call
+func takeReadyKeys() []string {
+	blocking.readyMu.Lock()
+	defer blocking.readyMu.Unlock()
+	keys := blocking.ready
+	blocking.ready = nil
+	clear(blocking.readySet)
+	return keys
+}
+
+// unblock forgets a blocked client, blocking.mu must be held
+func unblock(bc *blockedClient) {
+	for _, key := range bc.op.keys {
+		clients := slices.DeleteFunc(blocking.keys[key], func(other *blockedClient) bool {
+			return other == bc
+		})
+		if len(clients) == 0 {
+			delete(blocking.keys, key)
+		} else {
+			blocking.keys[key] = clients
+		}
+	}
+	bc.done = true
+	blocking.waiting.Add(-1)
+}
+
+// block runs a blocking command: the client is served right away if one of
+// its keys holds something to pop, otherwise it waits until a write to one
+// of them serves it, the timeout elapses or the connection is gone.
+func (c *Client) block(cmd *parser.Command) {
+	op, err := parseBlockingCommand(cmd)
+	if err != nil {
+		c.writer().WriteError(err.Error())
+		return
+	}
+
+	// counted as waiting before trying, so a write racing with the attempt
+	// marks its key ready and gets handled once the client is registered
+	blocking.waiting.Add(1)
+	execMu.RLock()
+	propagateMu.Lock()
+	blocking.mu.Lock()
+	if served, propagate := op.serve(c.store, c.writer()); served {
+		c.propagate(propagate)
+		blocking.mu.Unlock()
+		propagateMu.Unlock()
+		execMu.RUnlock()
+		blocking.waiting.Add(-1)
+		return
+	}
+	bc := &blockedClient{client: c, op: op, served: make(chan struct{})}
+	for _, key := range op.keys {
+		blocking.keys[key] = append(blocking.keys[key], bc)
+	}
+	blocking.mu.Unlock()
+	propagateMu.Unlock()
+	execMu.RUnlock()
+	c.setBlocked(true)
+	defer c.setBlocked(false)
+
+	var timeout <-chan time.Time
+	if op.timeout > 0 {
+		timer := time.NewTimer(op.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-bc.served:
+	case <-timeout:
+	case <-c.gone:
+	}
+
+	blocking.mu.Lock()
+	if !bc.done {
+		unblock(bc)
+		op.writeTimeout(bc.writer())
+	}
+	blocking.mu.Unlock()
+	c.reply.Write(bc.reply.Bytes())
+}
+
+// serveNow runs a blocking command without blocking, as in a transaction
+// where nothing else can push in the meantime. It returns the command to
+// propagate.
+func (c *Client) serveNow(cmd *parser.Command) *parser.Command {
+	op, err := parseBlockingCommand(cmd)
+	if err != nil {
+		c.writer().WriteError(err.Error())
+		return nil
+	}
+	served, propagate := op.serve(c.store, c.writer())
+	if !served {
+		op.writeTimeout(c.writer())
+	}
+	return propagate
+}
+
+// handleClientsBlockedOnKeys serves the clients blocked on the keys marked
+// ready, first blocked first served. A key deleted or overwritten by another
+// type wakes its clients too: they keep waiting on a deleted key and get a
+// WRONGTYPE error for a value they can't pop. Serving a client can make
+// another key ready (BLMOVE pushes), so it goes on until no key is left.
+func handleClientsBlockedOnKeys() {
+	if blocking.waiting.Load() == 0 {
+		return
+	}
+	execMu.RLock()
+	defer execMu.RUnlock()
+	propagateMu.Lock()
+	defer propagateMu.Unlock()
+	blocking.mu.Lock()
+	defer blocking.mu.Unlock()
+
+	for {
+		keys := takeReadyKeys()
+		if len(keys) == 0 {
+			return
+		}
+		for _, key := range keys {
+			for _, bc := range slices.Clone(blocking.keys[key]) {
+				if bc.done {
+					continue // served from an earlier key
+				}
+				served, propagate := bc.op.serve(bc.client.store, bc.writer())
+				if !served {
+					continue
+				}
+				bc.client.propagate(propagate)
+				unblock(bc)
+				close(bc.served)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// waitBlocked waits until n clients are blocked on key
+func waitBlocked(t *testing.T, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		blocking.mu.Lock()
+		blocked := len(blocking.keys[key])
+		blocking.mu.Unlock()
+		if blocked == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d clients blocked on %s", n, key)
+}
+
+func TestBlockingCommands(t *testing.T) {
+	addr := startServer(t)
+
+	t.Run("served right away when the key holds data", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("RPUSH", "blk:now", "a", "b")
+		c.expect(":2\r\n")
+		c.send("BRPOP", "blk:none", "blk:now", "0")
+		c.expect("*2\r\n$7\r\nblk:now\r\n$1\r\nb\r\n")
+	})
+
+	t.Run("a push serves a blocked client", func(t *testing.T) {
+		waiter := dial(t, addr)
+		pusher := dial(t, addr)
+		start := time.Now()
+		waiter.send("BLPOP", "blk:push", "0")
+		waitBlocked(t, "blk:push", 1)
+		time.Sleep(50 * time.Millisecond)
+		pusher.send("LPUSH", "blk:push", "job")
+		pusher.expect(":1\r\n")
+		waiter.expect("*2\r\n$8\r\nblk:push\r\n$3\r\njob\r\n")
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("Expected BLPOP to wait for the push, it returned after %v", elapsed)
+		}
+		pusher.send("LLEN", "blk:push")
+		pusher.expect(":0\r\n")
+	})
+
+	t.Run("fractional timeout", func(t *testing.T) {
+		c := dial(t, addr)
+		start := time.Now()
+		c.send("BLPOP", "blk:timeout", "0.15")
+		c.expect("*-1\r\n")
+		elapsed := time.Since(start)
+		if elapsed < 150*time.Millisecond || elapsed > time.Second {
+			t.Errorf("Expected BLPOP to time out after 150ms, it took %v", elapsed)
+		}
+		c.send("BLMOVE", "blk:timeout", "blk:dst", "LEFT", "LEFT", "0.05")
+		c.expect("$-1\r\n")
+		waitBlocked(t, "blk:timeout", 0)
+	})
+
+	t.Run("invalid timeouts", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("BLPOP", "blk:bad", "soon")
+		c.expect("-ERR timeout is not a float or out of range\r\n")
+		c.send("BZPOPMIN", "blk:bad", "-1")
+		c.expect("-ERR timeout is negative\r\n")
+		c.send("BLMOVE", "blk:bad", "blk:dst", "UP", "LEFT", "0")
+		c.expect("-ERR syntax error\r\n")
+	})
+
+	t.Run("waiters are served in FIFO order", func(t *testing.T) {
+		first := dial(t, addr)
+		second := dial(t, addr)
+		pusher := dial(t, addr)
+		first.send("BLPOP", "blk:fifo", "5")
+		waitBlocked(t, "blk:fifo", 1)
+		second.send("BLPOP", "blk:fifo", "5")
+		waitBlocked(t, "blk:fifo", 2)
+
+		pusher.send("RPUSH", "blk:fifo", "one")
+		pusher.expect(":1\r\n")
+		first.expect("*2\r\n$8\r\nblk:fifo\r\n$3\r\none\r\n")
+		waitBlocked(t, "blk:fifo", 1)
+		pusher.send("RPUSH", "blk:fifo", "two", "three")
+		pusher.expect(":2\r\n")
+		second.expect("*2\r\n$8\r\nblk:fifo\r\n$3\r\ntwo\r\n")
+		pusher.send("LRANGE", "blk:fifo", "0", "-1")
+		pusher.expect("*1\r\n$5\r\nthree\r\n")
+	})
+
+	t.Run("one push serves as many clients as it has elements", func(t *testing.T) {
+		first := dial(t, addr)
+		second := dial(t, addr)
+		pusher := dial(t, addr)
+		first.send("BRPOP", "blk:many", "5")
+		waitBlocked(t, "blk:many", 1)
+		second.send("BRPOP", "blk:many", "5")
+		waitBlocked(t, "blk:many", 2)
+		pusher.send("RPUSH", "blk:many", "a", "b")
+		pusher.expect(":2\r\n")
+		first.expect("*2\r\n$8\r\nblk:many\r\n$1\r\nb\r\n")
+		second.expect("*2\r\n$8\r\nblk:many\r\n$1\r\na\r\n")
+	})
+
+	t.Run("BLMOVE chains into a client blocked on the destination", func(t *testing.T) {
+		mover := dial(t, addr)
+		consumer := dial(t, addr)
+		pusher := dial(t, addr)
+		mover.send("BLMOVE", "blk:src", "blk:dst", "RIGHT", "LEFT", "5")
+		waitBlocked(t, "blk:src", 1)
+		consumer.send("BLPOP", "blk:dst", "5")
+		waitBlocked(t, "blk:dst", 1)
+		pusher.send("RPUSH", "blk:src", "x")
+		pusher.expect(":1\r\n")
+		mover.expect("$1\r\nx\r\n")
+		consumer.expect("*2\r\n$7\r\nblk:dst\r\n$1\r\nx\r\n")
+	})
+
+	t.Run("BZPOPMIN", func(t *testing.T) {
+		waiter := dial(t, addr)
+		pusher := dial(t, addr)
+		waiter.send("BZPOPMIN", "blk:zset", "5")
+		waitBlocked(t, "blk:zset", 1)
+		pusher.send("ZADD", "blk:zset", "2", "b", "1.5", "a")
+		pusher.expect(":2\r\n")
+		waiter.expect("*3\r\n$8\r\nblk:zset\r\n$1\r\na\r\n$3\r\n1.5\r\n")
+	})
+
+	t.Run("no blocking inside MULTI", func(t *testing.T) {
+		c := dial(t, addr)
+		start := time.Now()
+		c.send("MULTI")
+		c.expect("+OK\r\n")
+		c.send("BLPOP", "blk:multi", "0")
+		c.expect("+QUEUED\r\n")
+		c.send("RPUSH", "blk:multi", "a")
+		c.expect("+QUEUED\r\n")
+		c.send("BLPOP", "blk:multi", "0")
+		c.expect("+QUEUED\r\n")
+		c.send("EXEC")
+		c.expect("*3\r\n*-1\r\n:1\r\n*2\r\n$9\r\nblk:multi\r\n$1\r\na\r\n")
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected EXEC not to block, it took %v", elapsed)
+		}
+	})
+
+	t.Run("a type change wakes the client with an error", func(t *testing.T) {
+		waiter := dial(t, addr)
+		writer := dial(t, addr)
+		waiter.send("BLPOP", "blk:type", "5")
+		waitBlocked(t, "blk:type", 1)
+		writer.send("SET", "blk:type", "string")
+		writer.expect("+OK\r\n")
+		waiter.expect("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+		waitBlocked(t, "blk:type", 0)
+	})
+
+	t.Run("a deleted key leaves the client blocked", func(t *testing.T) {
+		waiter := dial(t, addr)
+		writer := dial(t, addr)
+		waiter.send("BLPOP", "blk:del", "5")
+		waitBlocked(t, "blk:del", 1)
+		writer.send("MULTI")
+		writer.expect("+OK\r\n")
+		writer.send("RPUSH", "blk:del", "gone")
+		writer.expect("+QUEUED\r\n")
+		writer.send("DEL", "blk:del")
+		writer.expect("+QUEUED\r\n")
+		writer.send("EXEC")
+		writer.expect("*2\r\n:1\r\n+OK\r\n")
+		waitBlocked(t, "blk:del", 1)
+
+		writer.send("RPUSH", "blk:del", "kept")
+		writer.expect(":1\r\n")
+		waiter.expect("*2\r\n$7\r\nblk:del\r\n$4\r\nkept\r\n")
+	})
+
+	t.Run("a closed connection unblocks the client", func(t *testing.T) {
+		waiter := dial(t, addr)
+		waiter.send("BLPOP", "blk:closed", "0")
+		waitBlocked(t, "blk:closed", 1)
+		waiter.conn.Close()
+		waitBlocked(t, "blk:closed", 0)
+	})
+}
+
+func TestBlockingAOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocking.aof")
+	manager := aof.NewAOFManager(path)
+	if err := manager.Initialize(); err != nil {
+		t.Fatalf("Error initializing AOF: %v", err)
+	}
+	s := store.NewStore()
+	s.SetModifiedKeyHook(signalKeyAsReady)
+	waiter := NewClient(s, manager, io.Discard)
+	pusher := NewClient(s, manager, io.Discard)
+
+	served := make(chan struct{})
+	go func() {
+		run(waiter, "BLPOP blkaof:list 5")
+		close(served)
+	}()
+	waitBlocked(t, "blkaof:list", 1)
+	run(pusher, "RPUSH blkaof:list a b")
+	<-served
+	run(waiter, "MULTI", "BZPOPMIN blkaof:zset 0", "EXEC")
+	manager.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading AOF: %v", err)
+	}
+	// the blocking command is persisted as the pop it resulted in, and not
+	// at all when nothing was popped
+	content := string(data)
+	expected := "*4\r\n$5\r\nRPUSH\r\n$11\r\nblkaof:list\r\n$1\r\na\r\n$1\r\nb\r\n*2\r\n$4\r\nLPOP\r\n$11\r\nblkaof:list\r\n"
+	if content != expected {
+		t.Errorf("Expected AOF %q, got %q", expected, content)
+	}
+	if strings.Contains(content, "BLPOP") || strings.Contains(content, "MULTI") {
+		t.Errorf("Expected no blocking command nor empty transaction in the AOF, got %q", content)
+	}
+}
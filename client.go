@@ -0,0 +1,632 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// Client flags
+const (
+	CLIENT_MULTI             = 1 << iota // in a MULTI block, commands are queued
+	CLIENT_DIRTY_EXEC                    // a command was rejected while queuing, EXEC must fail
+	CLIENT_CLOSE_AFTER_REPLY             // QUIT was sent, close once the reply is written
+	CLIENT_REPLY_OFF                     // CLIENT REPLY OFF, replies are dropped
+	CLIENT_REPLY_SKIP_NEXT               // CLIENT REPLY SKIP, the next reply is dropped
+	CLIENT_REPLY_SKIP                    // the reply of the command being processed is dropped
+	CLIENT_MASTER                        // applies the stream of the master of this replica
+	CLIENT_REPLICA                       // a replica of this server, gets the stream of write commands
+	CLIENT_ASKING                        // sent ASKING, the next command may use a slot being imported
+	CLIENT_SCRIPT                        // runs the commands of a script, see scriptClient
+	CLIENT_MONITOR                       // gets every command processed, see monitor.go
+)
+
+// nextClientID numbers the clients in the order they connect
+var nextClientID atomic.Int64
+
+// clients holds the network clients by id
+var clients = struct {
+	mu   sync.Mutex
+	byID map[int64]*Client
+}{byID: make(map[int64]*Client)}
+
+// execMu makes EXEC atomic: single commands run under the read lock and a
+// transaction runs under the write lock, so nothing interleaves with it
+var execMu sync.RWMutex
+
+// propagateMu orders the writes: a command modifying the dataset runs and
+// is propagated holding it, so the AOF and the replicas get the writes in
+// the order they were made. It is taken after execMu.
+var propagateMu sync.Mutex
+
+// Client holds the state of a connection, the prompt is a single client
+type Client struct {
+	store *store.Store
+	aof   *aof.AOFManager // nil when commands aren't persisted
+	out   io.Writer       // where replies and pub/sub messages go
+	conn  *connWriter     // the connection of network clients, nil for the prompt
+	gone  <-chan struct{} // closed once the connection is gone, nil for the prompt
+	reply bytes.Buffer    // reply of the command being processed
+	resp  atomic.Int32    // protocol version, 2 or 3
+	flags int
+	id    int64
+
+	createdAt time.Time
+	queryBuf  atomic.Int64 // bytes read from the connection but not parsed yet
+
+	authenticated bool // by AUTH or HELLO, or connected while the default user needed no password
+
+	// time spent blocked by the command being processed, see setBlocked
+	blockedSince time.Time
+	blockedTime  time.Duration
+
+	// what CLIENT LIST shows of the client, guarded by clients.mu
+	name       string // set by CLIENT SETNAME and HELLO SETNAME
+	user       string // the ACL user
+	lastCmd    string
+	lastActive time.Time
+	listed     clientListing
+
+	// transaction
+	queue   []*parser.Command // commands queued since MULTI
+	watcher *store.Watcher
+
+	// replication, see replication.go
+	listeningPort int // announced by a replica with REPLCONF listening-port
+
+	// durability of the writes of the client, see wait.go
+	woff   int64 // replication offset after its last write
+	aofOff int64 // AOF offset after its last write
+
+	// pub/sub, see pubsub.go
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+}
+
+func NewClient(s *store.Store, aofManager *aof.AOFManager, out io.Writer) *Client {
+	c := &Client{
+		store:         s,
+		aof:           aofManager,
+		out:           out,
+		watcher:       store.NewWatcher(),
+		channels:      make(map[string]struct{}),
+		patterns:      make(map[string]struct{}),
+		shardChannels: make(map[string]struct{}),
+		id:            nextClientID.Add(1),
+		createdAt:     time.Now(),
+		user:          "default",
+		authenticated: !defaultUserRequiresAuth(),
+		lastCmd:       "NULL",
+		lastActive:    time.Now(),
+		listed:        clientListing{multi: -1},
+	}
+	c.resp.Store(2)
+	return c
+}
+
+// ProcessCommand runs cmd for the client and sends the reply
+func (c *Client) ProcessCommand(cmd *parser.Command) {
+	c.touch(cmd)
+	if c.flags&CLIENT_REPLY_SKIP_NEXT != 0 {
+		c.flags = c.flags&^CLIENT_REPLY_SKIP_NEXT | CLIENT_REPLY_SKIP
+	}
+	c.processCommand(cmd)
+	if c.flags&CLIENT_MULTI == 0 && !strings.EqualFold(cmd.Name, "ASKING") {
+		c.flags &^= CLIENT_ASKING
+	}
+	handleClientsBlockedOnKeys()
+	c.updateListing()
+	c.flush()
+	c.flags &^= CLIENT_REPLY_SKIP
+}
+
+// writer returns a writer for the reply, in the protocol of the client
+func (c *Client) writer() *resp.Writer {
+	return resp.NewWriter(&c.reply, int(c.resp.Load()))
+}
+
+// flush sends the reply of the processed command with a single write, so
+// pub/sub messages can't end up in the middle of it. The reply is dropped
+// when CLIENT REPLY turned it off.
+func (c *Client) flush() {
+	if c.reply.Len() == 0 {
+		return
+	}
+	if c.flags&(CLIENT_REPLY_OFF|CLIENT_REPLY_SKIP) != 0 {
+		c.reply.Reset()
+		return
+	}
+	c.out.Write(c.reply.Bytes())
+	c.reply.Reset()
+}
+
+func (c *Client) processCommand(cmd *parser.Command) {
+	name := strings.ToUpper(cmd.Name)
+	if err := checkArity(cmd); err != nil {
+		if c.flags&CLIENT_MULTI != 0 {
+			c.flags |= CLIENT_DIRTY_EXEC
+		}
+		fmt.Fprintf(&c.reply, "-%v\r\n", err)
+		commandRejected(name)
+		return
+	}
+
+	if c.authRequired() && lookupCommand(name).flags&CMD_NOAUTH == 0 {
+		if c.flags&CLIENT_MULTI != 0 {
+			c.flags |= CLIENT_DIRTY_EXEC
+		}
+		fmt.Fprint(&c.reply, "-NOAUTH Authentication required.\r\n")
+		commandRejected(name)
+		return
+	}
+	if err := c.checkPermission(name, cmd.Args); err != nil {
+		if c.flags&CLIENT_MULTI != 0 {
+			c.flags |= CLIENT_DIRTY_EXEC
+		}
+		fmt.Fprintf(&c.reply, "-%v\r\n", err)
+		commandRejected(name)
+		return
+	}
+	if c.flags&CLIENT_MASTER == 0 && lookupCommand(name).flags&CMD_WRITE != 0 && isReadOnlyReplica() {
+		if c.flags&CLIENT_MULTI != 0 {
+			c.flags |= CLIENT_DIRTY_EXEC
+		}
+		fmt.Fprint(&c.reply, "-READONLY You can't write against a read only replica.\r\n")
+		commandRejected(name)
+		return
+	}
+	if clusterEnabled() && c.flags&CLIENT_MASTER == 0 {
+		// EXEC is redirected for the keys of the whole transaction
+		cmds := []*parser.Command{cmd}
+		if name == "EXEC" && c.flags&CLIENT_MULTI != 0 {
+			cmds = c.queue
+		}
+		if redirect := c.clusterRedirect(cmds); redirect != "" {
+			if name == "EXEC" {
+				c.resetTransaction()
+			} else if c.flags&CLIENT_MULTI != 0 {
+				c.flags |= CLIENT_DIRTY_EXEC
+			}
+			fmt.Fprintf(&c.reply, "-%s\r\n", redirect)
+			commandRejected(name)
+			return
+		}
+	}
+
+	// a RESP2 connection can't tell replies from messages once subscribed
+	if c.resp.Load() == 2 && c.subscriptionCount() > 0 && !subscriberCommands[name] {
+		fmt.Fprintf(&c.reply, "-ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n", strings.ToLower(cmd.Name))
+		commandRejected(name)
+		return
+	}
+
+	if !c.waitUnpaused(name) {
+		return // the connection is gone
+	}
+	if !c.waitScript(name, cmd.Args) {
+		if c.flags&CLIENT_MULTI != 0 {
+			c.flags |= CLIENT_DIRTY_EXEC
+		}
+		commandRejected(name)
+		return
+	}
+
+	if c.flags&CLIENT_MULTI != 0 && name != "EXEC" && name != "DISCARD" && name != "MULTI" && name != "WATCH" && name != "QUIT" {
+		c.queueCommand(cmd)
+		return
+	}
+
+	// the commands not going through call are accounted here
+	c.blockedTime = 0
+	start, replyStart := time.Now(), c.reply.Len()
+	switch name {
+	case "MULTI":
+		c.multi()
+	case "EXEC":
+		c.exec()
+	case "DISCARD":
+		c.discard()
+	case "WATCH":
+		c.watch(cmd.Args)
+	case "PSYNC", "SYNC":
+		// takes the locks it needs to snapshot the dataset
+		c.psync(name, cmd.Args)
+	case "BLPOP", "BRPOP", "BLMOVE", "BZPOPMIN":
+		c.block(cmd)
+	case "WAIT":
+		c.wait(cmd.Args, true)
+	case "WAITAOF":
+		c.waitAOF(cmd.Args, true)
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		// a script runs alone, like a transaction
+		execMu.Lock()
+		c.propagate(c.call(cmd)...)
+		execMu.Unlock()
+		return
+	case "SCRIPT", "FUNCTION":
+		// SCRIPT KILL and FUNCTION KILL stop the script holding execMu
+		c.callAndPropagate(cmd)
+		return
+	default:
+		execMu.RLock()
+		c.callAndPropagate(cmd)
+		execMu.RUnlock()
+		return
+	}
+	c.recordCall(cmd, start, replyStart)
+}
+
+// call executes cmd, the commands working on the client itself are handled
+// here and the others by ExecuteCommand. It returns the commands to
+// propagate, which differ from cmd for the blocking commands and are the
+// write commands they ran for the scripts. The call is accounted for INFO
+// commandstats.
+func (c *Client) call(cmd *parser.Command) []*parser.Command {
+	name := strings.ToUpper(cmd.Name)
+	defer c.recordCall(cmd, time.Now(), c.reply.Len())
+	switch name {
+	case "UNWATCH":
+		c.store.UnwatchAll(c.watcher)
+		fmt.Fprint(&c.reply, "+OK\r\n")
+	case "PING":
+		c.ping(cmd.Args)
+	case "HELLO":
+		c.hello(cmd.Args)
+	case "AUTH":
+		c.auth(cmd.Args)
+	case "ACL":
+		c.acl(cmd.Args)
+	case "CLIENT":
+		c.client(cmd.Args)
+	case "INFO":
+		infoCommand(c.writer(), cmd.Args)
+	case "CONFIG":
+		configCommand(c.writer(), cmd.Args)
+	case "SLOWLOG":
+		slowlogCommand(c.writer(), cmd.Args)
+	case "LATENCY":
+		latencyCommand(c.writer(), cmd.Args)
+	case "MONITOR":
+		c.monitor()
+	case "REPLICAOF", "SLAVEOF":
+		c.replicaof(cmd.Args)
+	case "REPLCONF":
+		c.replconf(cmd.Args)
+	case "SENTINEL":
+		c.sentinelCommand(cmd.Args)
+	case "CLUSTER":
+		c.clusterCommand(cmd.Args)
+	case "ASKING":
+		if !clusterEnabled() {
+			fmt.Fprint(&c.reply, "-ERR This instance has cluster support disabled\r\n")
+			break
+		}
+		c.flags |= CLIENT_ASKING
+		fmt.Fprint(&c.reply, "+OK\r\n")
+	case "MIGRATE":
+		// propagates the deletion of the keys moved itself
+		c.migrate(cmd.Args)
+		return nil
+	case "RESTORE-ASKING":
+		ExecuteCommand(cmd, c.store, c.writer())
+		return append(takeEvictions(), &parser.Command{Name: "RESTORE", Args: cmd.Args})
+	case "QUIT":
+		c.flags |= CLIENT_CLOSE_AFTER_REPLY
+		fmt.Fprint(&c.reply, "+OK\r\n")
+	case "SUBSCRIBE":
+		c.subscribe(cmd.Args)
+	case "PSUBSCRIBE":
+		c.psubscribe(cmd.Args)
+	case "SSUBSCRIBE":
+		c.ssubscribe(cmd.Args)
+	case "UNSUBSCRIBE":
+		c.unsubscribe(cmd.Args)
+	case "PUNSUBSCRIBE":
+		c.punsubscribe(cmd.Args)
+	case "SUNSUBSCRIBE":
+		c.sunsubscribe(cmd.Args)
+	case "BLPOP", "BRPOP", "BLMOVE", "BZPOPMIN":
+		// called by EXEC, which can't wait for other clients to push
+		return []*parser.Command{c.serveNow(cmd)}
+	case "WAIT":
+		// called by EXEC too, which replies with the acknowledgements so far
+		c.wait(cmd.Args, false)
+	case "WAITAOF":
+		c.waitAOF(cmd.Args, false)
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO":
+		return c.eval(name, cmd.Args)
+	case "FCALL", "FCALL_RO":
+		return c.fcall(name, cmd.Args)
+	case "SCRIPT":
+		c.scriptCommand(cmd.Args)
+		return nil
+	case "FUNCTION":
+		// only the changes to the libraries are propagated
+		if !functionCommand(c.writer(), cmd.Args) {
+			return nil
+		}
+	default:
+		ExecuteCommand(cmd, c.store, c.writer())
+		return append(takeEvictions(), cmd)
+	}
+	return []*parser.Command{cmd}
+}
+
+// callAndPropagate runs cmd and propagates it, holding propagateMu for the
+// writes. The transactions and the scripts don't need it, nothing else runs
+// with them.
+func (c *Client) callAndPropagate(cmd *parser.Command) {
+	if isWrite(cmd.Name) || aof.ShouldPersistCommand(cmd.Name) {
+		propagateMu.Lock()
+		defer propagateMu.Unlock()
+	}
+	c.propagate(c.call(cmd)...)
+}
+
+// evictions holds the keys evicted since they were last propagated. Only
+// the writes evict, holding propagateMu or running alone under execMu, so
+// the keys are the ones evicted before the running command.
+var evictions struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+// addEviction is the evicted key hook of the store
+func addEviction(key string) {
+	evictions.mu.Lock()
+	defer evictions.mu.Unlock()
+	evictions.keys = append(evictions.keys, key)
+}
+
+// takeEvictions returns a DEL for every key evicted since the last call,
+// to propagate before the command which evicted them
+func takeEvictions() []*parser.Command {
+	evictions.mu.Lock()
+	defer evictions.mu.Unlock()
+	dels := make([]*parser.Command, len(evictions.keys))
+	for i, key := range evictions.keys {
+		dels[i] = &parser.Command{Name: "DEL", Args: []string{key}}
+	}
+	evictions.keys = nil
+	return dels
+}
+
+// freeMemoryIfNeeded evicts keys outside of a command, to queue one in a
+// transaction, and propagates the evictions right away
+func (c *Client) freeMemoryIfNeeded() error {
+	execMu.RLock()
+	defer execMu.RUnlock()
+	propagateMu.Lock()
+	defer propagateMu.Unlock()
+	err := c.store.FreeMemoryIfNeeded()
+	c.propagate(takeEvictions()...)
+	return err
+}
+
+// propagate appends the commands modifying the dataset to the AOF and
+// streams them to the replicas, several as a transaction
+func (c *Client) propagate(cmds ...*parser.Command) {
+	var writes []*parser.Command
+	for _, cmd := range cmds {
+		if cmd != nil && aof.ShouldPersistCommand(cmd.Name) {
+			writes = append(writes, cmd)
+		}
+	}
+	if len(writes) > 1 {
+		c.propagateTransaction(writes)
+		return
+	}
+	if len(writes) == 0 {
+		return
+	}
+	cmd := writes[0]
+	if c.aof != nil {
+		if err := c.aof.AppendCommand(cmd); err != nil {
+			log.Fatalf("failed to write to AOF file: %v", err)
+		}
+		c.aofOff, _ = c.aof.Offsets()
+	}
+	// a replica streams what its master sent as is, see masterLink.stream
+	if c.flags&CLIENT_MASTER == 0 {
+		c.woff = feedReplicas(cmd)
+	}
+}
+
+func (c *Client) ping(args []string) {
+	if len(args) > 1 {
+		fmt.Fprint(&c.reply, "-ERR wrong number of arguments for 'ping' command\r\n")
+		return
+	}
+	message := ""
+	if len(args) == 1 {
+		message = args[0]
+	}
+	// subscribed RESP2 clients get an array, like a message
+	if c.resp.Load() == 2 && c.subscriptionCount() > 0 {
+		fmt.Fprintf(&c.reply, "*2\r\n$4\r\npong\r\n$%d\r\n%s\r\n", len(message), message)
+	} else if len(args) == 1 {
+		fmt.Fprintf(&c.reply, "$%d\r\n%s\r\n", len(message), message)
+	} else {
+		fmt.Fprint(&c.reply, "+PONG\r\n")
+	}
+}
+
+func (c *Client) multi() {
+	if c.flags&CLIENT_MULTI != 0 {
+		fmt.Fprint(&c.reply, "-ERR MULTI calls can not be nested\r\n")
+		return
+	}
+	c.flags |= CLIENT_MULTI
+	fmt.Fprint(&c.reply, "+OK\r\n")
+}
+
+// queueCommand validates cmd and adds it to the transaction. A command that
+// can't run flags the transaction so EXEC refuses to run any of it.
+func (c *Client) queueCommand(cmd *parser.Command) {
+	name := strings.ToUpper(cmd.Name)
+	if subscriberCommands[name] && name != "PING" || name == "PSYNC" || name == "SYNC" {
+		c.flags |= CLIENT_DIRTY_EXEC
+		fmt.Fprint(&c.reply, "-ERR Command not allowed inside a transaction\r\n")
+		return
+	}
+	if isDenyOOM(name) {
+		if err := c.freeMemoryIfNeeded(); err != nil {
+			c.flags |= CLIENT_DIRTY_EXEC
+			fmt.Fprintf(&c.reply, "-%v\r\n", err)
+			return
+		}
+	}
+	c.queue = append(c.queue, cmd)
+	fmt.Fprint(&c.reply, "+QUEUED\r\n")
+}
+
+// exec runs the queued commands with nothing else interleaving, replying
+// with an array holding the reply of every command. The transaction is
+// aborted with a null array if a watched key was modified.
+func (c *Client) exec() {
+	if c.flags&CLIENT_MULTI == 0 {
+		fmt.Fprint(&c.reply, "-ERR EXEC without MULTI\r\n")
+		return
+	}
+	defer c.resetTransaction()
+
+	if c.flags&CLIENT_DIRTY_EXEC != 0 {
+		fmt.Fprint(&c.reply, "-EXECABORT Transaction discarded because of previous errors.\r\n")
+		return
+	}
+
+	execMu.Lock()
+	defer execMu.Unlock()
+
+	if c.watcher.IsDirty() {
+		c.writer().WriteNullArray()
+		return
+	}
+
+	fmt.Fprintf(&c.reply, "*%d\r\n", len(c.queue))
+	var writes []*parser.Command
+	for _, cmd := range c.queue {
+		// the permissions may have changed since the command was queued
+		if err := c.checkPermission(cmd.Name, cmd.Args); err != nil {
+			fmt.Fprintf(&c.reply, "-%v\r\n", err)
+			continue
+		}
+		for _, propagate := range c.call(cmd) {
+			if propagate != nil && aof.ShouldPersistCommand(propagate.Name) {
+				writes = append(writes, propagate)
+			}
+		}
+	}
+	if len(writes) > 0 {
+		c.propagateTransaction(writes)
+	}
+}
+
+// propagateTransaction appends write commands to the AOF and streams them
+// to the replicas wrapped in MULTI/EXEC, with a single write before
+// anything else can run
+func (c *Client) propagateTransaction(writes []*parser.Command) {
+	if c.aof != nil {
+		if err := c.aof.AppendTransaction(writes); err != nil {
+			log.Fatalf("failed to write to AOF file: %v", err)
+		}
+		c.aofOff, _ = c.aof.Offsets()
+	}
+	if c.flags&CLIENT_MASTER == 0 {
+		c.woff = feedTransaction(writes)
+	}
+}
+
+func (c *Client) discard() {
+	if c.flags&CLIENT_MULTI == 0 {
+		fmt.Fprint(&c.reply, "-ERR DISCARD without MULTI\r\n")
+		return
+	}
+	c.resetTransaction()
+	fmt.Fprint(&c.reply, "+OK\r\n")
+}
+
+func (c *Client) watch(keys []string) {
+	if c.flags&CLIENT_MULTI != 0 {
+		fmt.Fprint(&c.reply, "-ERR WATCH inside MULTI is not allowed\r\n")
+		return
+	}
+	for _, key := range keys {
+		c.store.Watch(key, c.watcher)
+	}
+	fmt.Fprint(&c.reply, "+OK\r\n")
+}
+
+// resetTransaction leaves MULTI, dropping the queue and the watched keys
+func (c *Client) resetTransaction() {
+	c.queue = nil
+	c.flags &^= CLIENT_MULTI | CLIENT_DIRTY_EXEC
+	c.store.UnwatchAll(c.watcher)
+}
+
+// username returns the name of the ACL user of the client
+func (c *Client) username() string {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	return c.user
+}
+
+func (c *Client) setUsername(name string) {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	c.user = name
+}
+
+// registerClient makes a network client visible to the others
+func registerClient(c *Client) {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	clients.byID[c.id] = c
+	serverStats.connections.Add(1)
+}
+
+// disconnectClients closes the connection of the network clients matching
+// and returns their number, match is called with clients.mu held
+func disconnectClients(match func(c *Client) bool) int {
+	clients.mu.Lock()
+	var matching []*Client
+	for _, c := range clients.byID {
+		if match(c) {
+			matching = append(matching, c)
+		}
+	}
+	clients.mu.Unlock()
+	for _, c := range matching {
+		c.conn.Close()
+	}
+	return len(matching)
+}
+
+// Close releases what the client holds once its connection is gone
+func (c *Client) Close() {
+	clients.mu.Lock()
+	delete(clients.byID, c.id)
+	clients.mu.Unlock()
+	if c.flags&CLIENT_REPLICA != 0 {
+		removeReplica(c)
+	}
+	if c.flags&CLIENT_MONITOR != 0 {
+		removeMonitor(c)
+	}
+	c.pubsubUnsubscribeAll()
+	c.store.UnwatchAll(c.watcher)
+	if c.conn != nil {
+		c.conn.CloseWhenDrained()
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+// Pause modes of CLIENT PAUSE
+const (
+	PAUSE_NONE  = iota
+	PAUSE_WRITE // the commands that may modify the dataset wait
+	PAUSE_ALL   // every command waits
+)
+
+// pause holds the clients back while a CLIENT PAUSE is in progress
+var pause = struct {
+	mu       sync.Mutex
+	mode     int
+	end      time.Time
+	unpaused chan struct{} // closed by CLIENT UNPAUSE
+}{unpaused: make(chan struct{})}
+
+// containerCommands are shown with their subcommand by CLIENT LIST
+var containerCommands = map[string]bool{
+	"ACL":     true,
+	"CLIENT":  true,
+	"CONFIG":  true,
+	"LATENCY": true,
+	"MEMORY":  true,
+	"OBJECT":  true,
+	"PUBSUB":  true,
+	"SLOWLOG": true,
+}
+
+// clientListing is the state of a client CLIENT LIST shows besides its
+// name and user, guarded by clients.mu like them
+type clientListing struct {
+	sub, psub, ssub int
+	multi           int  // commands queued, -1 outside MULTI
+	blocked         bool // waiting for a key or the end of a pause
+	noEvict         bool
+	replica         bool // gets the stream of write commands
+	monitor         bool // gets every command processed
+}
+
+// touch records cmd as the last command of the client, which is active now
+func (c *Client) touch(cmd *parser.Command) {
+	name := strings.ToLower(cmd.Name)
+	if lookupCommand(name) == nil {
+		name = "NULL"
+	} else if containerCommands[strings.ToUpper(name)] && len(cmd.Args) > 0 {
+		name += "|" + strings.ToLower(cmd.Args[0])
+	}
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	c.lastCmd = name
+	c.lastActive = time.Now()
+}
+
+// updateListing shares the state the client changed while processing a
+// command with the others
+func (c *Client) updateListing() {
+	multi := -1
+	if c.flags&CLIENT_MULTI != 0 {
+		multi = len(c.queue)
+	}
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	c.listed.sub = len(c.channels)
+	c.listed.psub = len(c.patterns)
+	c.listed.ssub = len(c.shardChannels)
+	c.listed.multi = multi
+}
+
+// setBlocked marks the client as blocked or not. The time spent blocked
+// isn't accounted to the command, like in Redis.
+func (c *Client) setBlocked(blocked bool) {
+	if blocked {
+		c.blockedSince = time.Now()
+	} else {
+		c.blockedTime += time.Since(c.blockedSince)
+	}
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	c.listed.blocked = blocked
+}
+
+func (c *Client) setName(name string) {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	c.name = name
+}
+
+func (c *Client) getName() string {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	return c.name
+}
+
+// info describes the client as a line of CLIENT LIST, for CLIENT INFO and
+// logs like the ACL LOG
+func (c *Client) info() string {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	return c.describe(time.Now())
+}
+
+// describe returns the CLIENT LIST line of the client, without its newline.
+// clients.mu must be held.
+func (c *Client) describe(now time.Time) string {
+	addr, laddr, omem := "", "", 0
+	if c.conn != nil {
+		addr = c.conn.RemoteAddr().String()
+		laddr = c.conn.LocalAddr().String()
+		omem = c.conn.Pending()
+	}
+	flags := ""
+	if c.listed.replica {
+		flags += "S"
+	}
+	if c.listed.monitor {
+		flags += "O"
+	}
+	if c.listed.multi >= 0 {
+		flags += "x"
+	}
+	if c.listed.blocked {
+		flags += "b"
+	}
+	if c.listed.sub+c.listed.psub+c.listed.ssub > 0 {
+		flags += "P"
+	}
+	if c.listed.noEvict {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d ssub=%d multi=%d qbuf=%d omem=%d cmd=%s user=%s resp=%d",
+		c.id, addr, laddr, c.name,
+		int64(now.Sub(c.createdAt).Seconds()), int64(now.Sub(c.lastActive).Seconds()),
+		flags, c.listed.sub, c.listed.psub, c.listed.ssub, c.listed.multi,
+		c.queryBuf.Load(), omem, c.lastCmd, c.user, c.resp.Load())
+}
+
+// clientFilter selects the clients of CLIENT KILL, unset fields match any
+type clientFilter struct {
+	id   int64
+	addr *string
+	user *string
+}
+
+// match reports if c is selected, clients.mu must be held
+func (f *clientFilter) match(c *Client) bool {
+	if f.id != 0 && c.id != f.id {
+		return false
+	}
+	if f.addr != nil && (c.conn == nil || c.conn.RemoteAddr().String() != *f.addr) {
+		return false
+	}
+	if f.user != nil && c.user != *f.user {
+		return false
+	}
+	return true
+}
+
+// client handles CLIENT <subcommand> [args...]
+func (c *Client) client(args []string) {
+	w := c.writer()
+	sub := strings.ToUpper(args[0])
+	args = args[1:]
+	switch {
+	case sub == "ID" && len(args) == 0:
+		w.WriteInteger(c.id)
+	case sub == "INFO" && len(args) == 0:
+		w.WriteVerbatim("txt", c.info()+"\n")
+	case sub == "LIST":
+		clientList(w, args)
+	case sub == "SETNAME" && len(args) == 1:
+		if !validClientName(args[0]) {
+			w.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		c.setName(args[0])
+		w.WriteSimpleString("OK")
+	case sub == "GETNAME" && len(args) == 0:
+		if name := c.getName(); name != "" {
+			w.WriteBulkString(name)
+		} else {
+			w.WriteNull()
+		}
+	case sub == "KILL" && len(args) >= 1:
+		c.clientKill(args)
+	case sub == "PAUSE" && (len(args) == 1 || len(args) == 2):
+		clientPause(w, args)
+	case sub == "UNPAUSE" && len(args) == 0:
+		unpauseClients()
+		w.WriteSimpleString("OK")
+	case sub == "NO-EVICT" && len(args) == 1:
+		var noEvict bool
+		switch strings.ToUpper(args[0]) {
+		case "ON":
+			noEvict = true
+		case "OFF":
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+		clients.mu.Lock()
+		c.listed.noEvict = noEvict
+		clients.mu.Unlock()
+		w.WriteSimpleString("OK")
+	case sub == "REPLY" && len(args) == 1:
+		switch strings.ToUpper(args[0]) {
+		case "ON":
+			c.flags &^= CLIENT_REPLY_OFF | CLIENT_REPLY_SKIP_NEXT
+			w.WriteSimpleString("OK")
+		case "OFF":
+			c.flags |= CLIENT_REPLY_OFF
+		case "SKIP":
+			if c.flags&CLIENT_REPLY_OFF == 0 {
+				c.flags |= CLIENT_REPLY_SKIP_NEXT
+			}
+		default:
+			w.WriteError("ERR syntax error")
+		}
+	case sub == "HELP" && len(args) == 0:
+		lines := []string{
+			"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GETNAME",
+			"    Return the name of the current connection.",
+			"ID",
+			"    Return the ID of the current connection.",
+			"INFO",
+			"    Return information about the current client connection.",
+			"KILL <ip:port>",
+			"    Kill connection made from <ip:port>.",
+			"KILL <option> <value> [<option> <value> [...]]",
+			"    Kill connections. Options are:",
+			"    * ADDR (<ip:port>)",
+			"      Kill connection made from <ip:port>",
+			"    * ID <client-id>",
+			"      Kill connections by client id.",
+			"    * USER <username>",
+			"      Kill connections authenticated by <username>.",
+			"    * SKIPME (YES|NO)",
+			"      Skip killing current connection (default: yes).",
+			"LIST [TYPE (NORMAL|PUBSUB|REPLICA)] [ID <client-id> [<client-id> ...]]",
+			"    Return information about client connections.",
+			"NO-EVICT (ON|OFF)",
+			"    Protect the current client connection from eviction.",
+			"PAUSE <timeout> [WRITE|ALL]",
+			"    Suspend all, or just write, clients for <timeout> milliseconds.",
+			"REPLY (ON|OFF|SKIP)",
+			"    Control the replies sent to the current connection.",
+			"SETNAME <name>",
+			"    Assign the name <name> to the current connection.",
+			"UNPAUSE",
+			"    Stop the current client pause, resuming traffic.",
+		}
+		w.WriteArrayLen(len(lines))
+		for _, line := range lines {
+			w.WriteSimpleString(line)
+		}
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.", strings.ToLower(sub)))
+	}
+}
+
+// clientList handles CLIENT LIST [TYPE type] [ID id [id ...]]
+func clientList(w *resp.Writer, args []string) {
+	var clientType string
+	var ids []int64
+	for i := 0; i < len(args); {
+		option := strings.ToUpper(args[i])
+		switch {
+		case option == "TYPE" && i+1 < len(args):
+			clientType = strings.ToLower(args[i+1])
+			if !slices.Contains([]string{"normal", "pubsub", "master", "replica", "slave"}, clientType) {
+				w.WriteError(fmt.Sprintf("ERR Unknown client type '%s'", args[i+1]))
+				return
+			}
+			i += 2
+		case option == "ID" && i+1 < len(args):
+			for _, arg := range args[i+1:] {
+				id, err := strconv.ParseInt(arg, 10, 64)
+				if err != nil || id <= 0 {
+					w.WriteError("ERR Invalid client ID")
+					return
+				}
+				ids = append(ids, id)
+			}
+			i = len(args)
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	clients.mu.Lock()
+	listed := make([]*Client, 0, len(clients.byID))
+	for _, c := range clients.byID {
+		if ids != nil && !slices.Contains(ids, c.id) {
+			continue
+		}
+		subscribed := c.listed.sub+c.listed.psub+c.listed.ssub > 0
+		switch clientType {
+		case "normal":
+			if subscribed || c.listed.replica {
+				continue
+			}
+		case "pubsub":
+			if !subscribed {
+				continue
+			}
+		case "replica", "slave":
+			if !c.listed.replica {
+				continue
+			}
+		case "master":
+			continue // the link to the master isn't listed
+		}
+		listed = append(listed, c)
+	}
+	slices.SortFunc(listed, func(a, b *Client) int { return int(a.id - b.id) })
+	var b strings.Builder
+	now := time.Now()
+	for _, c := range listed {
+		b.WriteString(c.describe(now))
+		b.WriteByte('\n')
+	}
+	clients.mu.Unlock()
+	w.WriteVerbatim("txt", b.String())
+}
+
+// clientKill handles CLIENT KILL addr and CLIENT KILL option value [...].
+// The client itself is closed once it got the reply.
+func (c *Client) clientKill(args []string) {
+	w := c.writer()
+	var filter clientFilter
+	skipMe := true
+	oldForm := len(args) == 1
+	if oldForm {
+		filter.addr = &args[0]
+		skipMe = false
+	} else {
+		if len(args)%2 != 0 {
+			w.WriteError("ERR syntax error")
+			return
+		}
+		for i := 0; i < len(args); i += 2 {
+			value := args[i+1]
+			switch strings.ToUpper(args[i]) {
+			case "ID":
+				id, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || id <= 0 {
+					w.WriteError("ERR client-id should be greater than 0")
+					return
+				}
+				filter.id = id
+			case "ADDR":
+				filter.addr = &args[i+1]
+			case "USER":
+				if lookupUser(value) == nil {
+					w.WriteError(fmt.Sprintf("ERR No such user '%s'", value))
+					return
+				}
+				filter.user = &args[i+1]
+			case "SKIPME":
+				switch strings.ToLower(value) {
+				case "yes":
+					skipMe = true
+				case "no":
+					skipMe = false
+				default:
+					w.WriteError("ERR syntax error")
+					return
+				}
+			default:
+				w.WriteError("ERR syntax error")
+				return
+			}
+		}
+	}
+
+	killSelf := false
+	killed := disconnectClients(func(other *Client) bool {
+		if !filter.match(other) {
+			return false
+		}
+		if other == c {
+			killSelf = !skipMe
+			return false
+		}
+		return true
+	})
+	if killSelf {
+		killed++
+		c.flags |= CLIENT_CLOSE_AFTER_REPLY
+	}
+
+	if !oldForm {
+		w.WriteInteger(int64(killed))
+	} else if killed == 0 {
+		w.WriteError("ERR No such client")
+	} else {
+		w.WriteSimpleString("OK")
+	}
+}
+
+// clientPause handles CLIENT PAUSE timeout [WRITE|ALL]
+func clientPause(w *resp.Writer, args []string) {
+	timeout, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		w.WriteError("ERR timeout is not an integer or out of range")
+		return
+	}
+	if timeout < 0 {
+		w.WriteError("ERR timeout is negative")
+		return
+	}
+	mode := PAUSE_ALL
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "WRITE":
+			mode = PAUSE_WRITE
+		case "ALL":
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+	pauseClients(mode, time.Now().Add(time.Duration(timeout)*time.Millisecond))
+	w.WriteSimpleString("OK")
+}
+
+// pauseClients pauses the clients until end. A pause in progress is only
+// made longer or more restrictive.
+func pauseClients(mode int, end time.Time) {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	if pause.mode == PAUSE_NONE || !time.Now().Before(pause.end) {
+		pause.mode, pause.end = mode, end
+		return
+	}
+	pause.mode = max(pause.mode, mode)
+	if end.After(pause.end) {
+		pause.end = end
+	}
+}
+
+// unpauseClients ends the pause in progress, waking the waiting clients
+func unpauseClients() {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	pause.mode = PAUSE_NONE
+	close(pause.unpaused)
+	pause.unpaused = make(chan struct{})
+}
+
+// clientsPaused reports if a pause is in progress, the keys don't expire
+// in the background meanwhile so the dataset doesn't change
+func clientsPaused() bool {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	return pause.mode != PAUSE_NONE && time.Now().Before(pause.end)
+}
+
+// waitUnpaused holds the command back while a pause covering it is in
+// progress. It returns false if the connection is gone meanwhile.
+func (c *Client) waitUnpaused(name string) bool {
+	if c.flags&(CLIENT_MASTER|CLIENT_REPLICA) != 0 {
+		return true // the replication goes on
+	}
+	blocked := false
+	defer func() {
+		if blocked {
+			c.setBlocked(false)
+		}
+	}()
+	for {
+		pause.mu.Lock()
+		mode, wait, unpaused := pause.mode, time.Until(pause.end), pause.unpaused
+		pause.mu.Unlock()
+		if mode == PAUSE_NONE || wait <= 0 || mode == PAUSE_WRITE && !c.mayModify(name) {
+			return true
+		}
+		if !blocked {
+			blocked = true
+			c.setBlocked(true)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-unpaused:
+		case <-timer.C:
+		case <-c.gone:
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
+
+// mayModify reports if the command may modify the dataset or be propagated,
+// EXEC when a queued command may
+func (c *Client) mayModify(name string) bool {
+	if name == "EXEC" {
+		for _, cmd := range c.queue {
+			if c.mayModify(strings.ToUpper(cmd.Name)) {
+				return true
+			}
+		}
+		return false
+	}
+	switch name {
+	case "PUBLISH", "SPUBLISH", "EVAL", "EVALSHA", "FCALL":
+		return true // the scripts may write, unlike their _RO variants
+	}
+	spec := lookupCommand(name)
+	return spec != nil && spec.flags&CMD_WRITE != 0
+}
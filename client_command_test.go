@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readBulk reads a bulk string of any length
+func (c *testConn) readBulk() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.reader.ReadString('\n')
+	if err != nil || line[0] != '$' {
+		c.t.Fatalf("Expected a bulk string, got %q: %v", line, err)
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(c.reader, buf); err != nil {
+		c.t.Fatalf("Error reading a bulk string: %v", err)
+	}
+	return string(buf[:n])
+}
+
+// clientID returns the id of the connection
+func (c *testConn) clientID() int64 {
+	c.t.Helper()
+	c.send("CLIENT", "ID")
+	line, err := c.reader.ReadString('\n')
+	if err != nil || line[0] != ':' {
+		c.t.Fatalf("Expected an integer, got %q: %v", line, err)
+	}
+	id, _ := strconv.ParseInt(strings.TrimSpace(line[1:]), 10, 64)
+	return id
+}
+
+// expectClosed waits for the server to close the connection
+func (c *testConn) expectClosed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if b, err := c.reader.ReadByte(); err != io.EOF {
+		c.t.Fatalf("Expected the connection to be closed, got %q: %v", b, err)
+	}
+}
+
+// waitPaused waits until a client is held back by CLIENT PAUSE
+func waitPaused(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		clients.mu.Lock()
+		for _, c := range clients.byID {
+			if c.listed.blocked {
+				clients.mu.Unlock()
+				return
+			}
+		}
+		clients.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Expected a client to be paused")
+}
+
+// listFields parses the CLIENT LIST lines into their fields, by id
+func listFields(list string) map[string]map[string]string {
+	byID := make(map[string]map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		fields := make(map[string]string)
+		for _, field := range strings.Fields(scanner.Text()) {
+			key, value, _ := strings.Cut(field, "=")
+			fields[key] = value
+		}
+		byID[fields["id"]] = fields
+	}
+	return byID
+}
+
+func TestClientCommand(t *testing.T) {
+	resetACL(t)
+	addr := startServer(t)
+
+	t.Run("names and info", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("CLIENT", "GETNAME")
+		c.expect("$-1\r\n")
+		c.send("CLIENT", "SETNAME", "bad name")
+		c.expect("-ERR Client names cannot contain spaces, newlines or special characters.\r\n")
+		c.send("CLIENT", "SETNAME", "worker")
+		c.expect("+OK\r\n")
+		c.send("CLIENT", "GETNAME")
+		c.expect("$6\r\nworker\r\n")
+
+		id := c.clientID()
+		c.send("CLIENT", "INFO")
+		info := listFields(c.readBulk())[strconv.FormatInt(id, 10)]
+		expected := map[string]string{"name": "worker", "flags": "N", "db": "0", "multi": "-1", "cmd": "client|info", "user": "default", "resp": "2", "addr": c.conn.LocalAddr().String()}
+		for key, value := range expected {
+			if info[key] != value {
+				t.Errorf("Expected %s=%s, got %v", key, value, info)
+			}
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		c := dial(t, addr)
+		subscriber := dial(t, addr)
+		subscriberID := subscriber.clientID()
+		subscriber.send("SUBSCRIBE", "news")
+		subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+		id := c.clientID()
+		c.send("MULTI")
+		c.expect("+OK\r\n")
+		c.send("SET", "k", "v")
+		c.expect("+QUEUED\r\n")
+
+		c.send("CLIENT", "LIST")
+		c.expect("+QUEUED\r\n")
+		c.send("EXEC")
+		c.expect("*2\r\n+OK\r\n")
+		all := listFields(c.readBulk())
+		if all[strconv.FormatInt(subscriberID, 10)]["flags"] != "P" || all[strconv.FormatInt(subscriberID, 10)]["sub"] != "1" {
+			t.Errorf("Expected the subscriber to be listed, got %v", all)
+		}
+		if all[strconv.FormatInt(id, 10)]["flags"] != "x" || all[strconv.FormatInt(id, 10)]["multi"] != "2" {
+			t.Errorf("Expected the client in MULTI to be listed, got %v", all)
+		}
+
+		c.send("CLIENT", "LIST", "TYPE", "pubsub")
+		if listed := listFields(c.readBulk()); len(listed) != 1 || listed[strconv.FormatInt(subscriberID, 10)] == nil {
+			t.Errorf("Expected only the subscriber, got %v", listed)
+		}
+		c.send("CLIENT", "LIST", "ID", strconv.FormatInt(id, 10), "999999")
+		if listed := listFields(c.readBulk()); len(listed) != 1 || listed[strconv.FormatInt(id, 10)]["cmd"] != "client|list" {
+			t.Errorf("Expected only the client itself, got %v", listed)
+		}
+		c.send("CLIENT", "LIST", "TYPE", "nosuch")
+		c.expect("-ERR Unknown client type 'nosuch'\r\n")
+		c.send("CLIENT", "LIST", "ID", "x")
+		c.expect("-ERR Invalid client ID\r\n")
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		c := dial(t, addr)
+		victim := dial(t, addr)
+		c.send("CLIENT", "KILL", "ID", strconv.FormatInt(victim.clientID(), 10))
+		c.expect(":1\r\n")
+		victim.expectClosed()
+
+		victim = dial(t, addr)
+		victim.send("PING")
+		victim.expect("+PONG\r\n")
+		c.send("CLIENT", "KILL", victim.conn.LocalAddr().String())
+		c.expect("+OK\r\n")
+		victim.expectClosed()
+		c.send("CLIENT", "KILL", victim.conn.LocalAddr().String())
+		c.expect("-ERR No such client\r\n")
+
+		c.send("ACL", "SETUSER", "killme", "on", "nopass", "+@all", "~*")
+		c.expect("+OK\r\n")
+		victim = dial(t, addr)
+		victim.send("AUTH", "killme", "x")
+		victim.expect("+OK\r\n")
+		c.send("CLIENT", "KILL", "USER", "killme")
+		c.expect(":1\r\n")
+		victim.expectClosed()
+		c.send("CLIENT", "KILL", "USER", "nobody")
+		c.expect("-ERR No such user 'nobody'\r\n")
+
+		id := strconv.FormatInt(c.clientID(), 10)
+		c.send("CLIENT", "KILL", "ID", id)
+		c.expect(":0\r\n")
+		c.send("CLIENT", "KILL", "ID", "0")
+		c.expect("-ERR client-id should be greater than 0\r\n")
+		c.send("CLIENT", "KILL", "ID", id, "SKIPME", "maybe")
+		c.expect("-ERR syntax error\r\n")
+		c.send("CLIENT", "KILL", "ID", id, "SKIPME", "no")
+		c.expect(":1\r\n")
+		c.expectClosed()
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("CLIENT", "REPLY", "OFF")
+		c.send("SET", "reply", "off")
+		c.send("CLIENT", "REPLY", "ON")
+		c.expect("+OK\r\n")
+		c.send("CLIENT", "REPLY", "SKIP")
+		c.send("GET", "reply")
+		c.send("GET", "reply")
+		c.expect("$3\r\noff\r\n")
+		c.send("CLIENT", "REPLY", "MAYBE")
+		c.expect("-ERR syntax error\r\n")
+	})
+
+	t.Run("no-evict", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("CLIENT", "NO-EVICT", "ON")
+		c.expect("+OK\r\n")
+		c.send("CLIENT", "INFO")
+		if info := c.readBulk(); !strings.Contains(info, " flags=e ") {
+			t.Errorf("Expected the no-evict flag, got %q", info)
+		}
+		c.send("CLIENT", "NO-EVICT", "MAYBE")
+		c.expect("-ERR syntax error\r\n")
+	})
+
+	t.Run("pause writes", func(t *testing.T) {
+		t.Cleanup(unpauseClients)
+		admin := dial(t, addr)
+		c := dial(t, addr)
+		admin.send("CLIENT", "PAUSE", "10000", "WRITE")
+		admin.expect("+OK\r\n")
+		c.send("GET", "paused")
+		c.expect("$-1\r\n")
+		c.send("SET", "paused", "1")
+		waitPaused(t)
+		if kvStore.Exists("paused") {
+			t.Error("Expected the write to wait for the end of the pause")
+		}
+		admin.send("CLIENT", "UNPAUSE")
+		admin.expect("+OK\r\n")
+		c.expect("+OK\r\n")
+	})
+
+	t.Run("pause all", func(t *testing.T) {
+		t.Cleanup(unpauseClients)
+		admin := dial(t, addr)
+		c := dial(t, addr)
+		start := time.Now()
+		admin.send("CLIENT", "PAUSE", "100")
+		admin.expect("+OK\r\n")
+		c.send("PING")
+		c.expect("+PONG\r\n")
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Expected PING to wait for the end of the pause, it took %v", elapsed)
+		}
+		admin.send("CLIENT", "PAUSE", "-1")
+		admin.expect("-ERR timeout is negative\r\n")
+		admin.send("CLIENT", "PAUSE", "1", "READS")
+		admin.expect("-ERR syntax error\r\n")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("CLIENT", "NOSUCH")
+		c.expect(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.\r\n", "nosuch"))
+		c.send("CLIENT", "ID", "extra")
+		c.expect("-ERR unknown subcommand or wrong number of arguments for 'id'. Try CLIENT HELP.\r\n")
+	})
+}
+
+func TestPauseClients(t *testing.T) {
+	t.Cleanup(unpauseClients)
+	end := time.Now().Add(time.Hour)
+	pauseClients(PAUSE_ALL, end)
+	pauseClients(PAUSE_WRITE, time.Now().Add(time.Minute))
+	if pause.mode != PAUSE_ALL || !pause.end.Equal(end) {
+		t.Errorf("Expected a pause in progress not to be shortened or relaxed, got mode %d until %v", pause.mode, pause.end)
+	}
+	if !clientsPaused() {
+		t.Error("Expected the clients to be paused")
+	}
+	unpauseClients()
+	if clientsPaused() {
+		t.Error("Expected the clients not to be paused anymore")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// run sends every command line to the client
+func run(c *Client, lines ...string) {
+	for _, line := range lines {
+		parts := strings.Fields(line)
+		c.ProcessCommand(&parser.Command{Name: parts[0], Args: parts[1:]})
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	t.Run("EXEC runs the queued commands", func(t *testing.T) {
+		s := store.NewStore()
+		c := NewClient(s, nil, io.Discard)
+		run(c, "MULTI", "SET a 1", "INCRBY a 5", "SET b x")
+		if s.Exists("a") {
+			t.Error("Expected queued commands not to run before EXEC")
+		}
+		run(c, "EXEC")
+		if value := s.GetValue("a"); value != 6 {
+			t.Errorf("Expected a to be 6, got %v", value)
+		}
+		if value := s.GetValue("b"); value != "x" {
+			t.Errorf("Expected b to be x, got %v", value)
+		}
+		if c.flags&CLIENT_MULTI != 0 || len(c.queue) != 0 {
+			t.Error("Expected the transaction to be over after EXEC")
+		}
+	})
+
+	t.Run("queuing errors abort EXEC", func(t *testing.T) {
+		s := store.NewStore()
+		c := NewClient(s, nil, io.Discard)
+		run(c, "MULTI", "SET a 1", "INCRBY a", "NOSUCHCOMMAND", "SET b 2", "EXEC")
+		if s.Exists("a") || s.Exists("b") {
+			t.Error("Expected no command of an aborted transaction to run")
+		}
+		if c.flags != 0 {
+			t.Errorf("Expected flags to be reset, got %d", c.flags)
+		}
+	})
+
+	t.Run("a failing command gets an error in the EXEC reply", func(t *testing.T) {
+		s := store.NewStore()
+		var out bytes.Buffer
+		c := NewClient(s, nil, &out)
+		run(c, "MULTI")
+		out.Reset()
+		run(c, "SET t 1", "INCRBY t x", "INCRBY t 2")
+		out.Reset()
+		run(c, "EXEC")
+
+		reply, err := parser.ReadValue(bufio.NewReader(&out))
+		if err != nil {
+			t.Fatalf("Error reading the EXEC reply: %v", err)
+		}
+		if reply.Type != parser.Array || len(reply.Elems) != 3 {
+			t.Fatalf("Expected an array of 3 replies, got %+v", reply)
+		}
+		if e := reply.Elems[0]; e.Type != parser.SimpleString || e.Str != "OK" {
+			t.Errorf("Expected OK for SET, got %+v", e)
+		}
+		if e := reply.Elems[1]; e.Type != parser.SimpleError || e.Str != "ERR value is not an integer or out of range" {
+			t.Errorf("Expected an error for INCRBY t x, got %+v", e)
+		}
+		if e := reply.Elems[2]; e.Type != parser.Integer || e.Int != 3 {
+			t.Errorf("Expected 3 for INCRBY t 2, got %+v", e)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Expected nothing after the EXEC reply, got %q", out.String())
+		}
+	})
+
+	t.Run("DISCARD drops the queue", func(t *testing.T) {
+		s := store.NewStore()
+		c := NewClient(s, nil, io.Discard)
+		run(c, "MULTI", "SET a 1", "DISCARD", "SET b 2")
+		if s.Exists("a") {
+			t.Error("Expected discarded command not to run")
+		}
+		if !s.Exists("b") {
+			t.Error("Expected commands after DISCARD to run right away")
+		}
+	})
+
+	t.Run("WATCH aborts EXEC when a key is modified", func(t *testing.T) {
+		s := store.NewStore()
+		c := NewClient(s, nil, io.Discard)
+		other := NewClient(s, nil, io.Discard)
+		run(c, "SET counter 1", "WATCH counter", "MULTI", "INCRBY counter 10")
+		run(other, "INCRBY counter 1")
+		run(c, "EXEC")
+		if value := s.GetValue("counter"); value != 2 {
+			t.Errorf("Expected the transaction to be aborted, counter is %v", value)
+		}
+
+		// keys are unwatched after EXEC, the next transaction goes through
+		run(other, "SET counter 5")
+		run(c, "MULTI", "INCRBY counter 10", "EXEC")
+		if value := s.GetValue("counter"); value != 15 {
+			t.Errorf("Expected counter to be 15, got %v", value)
+		}
+	})
+
+	t.Run("WATCH lets EXEC run when nothing changed", func(t *testing.T) {
+		s := store.NewStore()
+		c := NewClient(s, nil, io.Discard)
+		other := NewClient(s, nil, io.Discard)
+		run(c, "SET counter 1", "WATCH counter", "MULTI", "INCRBY counter 10")
+		run(other, "GET counter", "SET unrelated 1")
+		run(c, "EXEC")
+		if value := s.GetValue("counter"); value != 11 {
+			t.Errorf("Expected counter to be 11, got %v", value)
+		}
+	})
+
+	t.Run("UNWATCH forgets the watched keys", func(t *testing.T) {
+		s := store.NewStore()
+		c := NewClient(s, nil, io.Discard)
+		other := NewClient(s, nil, io.Discard)
+		run(c, "WATCH k", "UNWATCH")
+		run(other, "SET k 1")
+		run(c, "MULTI", "SET k 2", "EXEC")
+		if value := s.GetValue("k"); value != 2 {
+			t.Errorf("Expected k to be 2, got %v", value)
+		}
+	})
+}
+
+func TestTransactionAOF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	manager := aof.NewAOFManager(filename)
+	if err := manager.Initialize(); err != nil {
+		t.Fatalf("Error initializing AOF manager: %v", err)
+	}
+	c := NewClient(store.NewStore(), manager, io.Discard)
+	run(c, "SET a 1", "MULTI", "SET b 2", "GET b", "INCRBY a 1", "EXEC")
+	manager.Close()
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Error reading AOF file: %v", err)
+	}
+	if !strings.Contains(string(content), "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nb") {
+		t.Errorf("Expected the transaction to be wrapped in MULTI/EXEC, got %q", content)
+	}
+	if strings.Contains(string(content), "GET") {
+		t.Errorf("Expected read commands not to be persisted, got %q", content)
+	}
+
+	replay := func() *store.Store {
+		s := store.NewStore()
+		m := aof.NewAOFManager(filename)
+		if err := m.Initialize(); err != nil {
+			t.Fatalf("Error initializing AOF manager: %v", err)
+		}
+		defer m.Close()
+		m.ReadAndExecuteCommands(func(cmd *parser.Command) {
+			ExecuteCommand(cmd, s, io.Discard)
+		})
+		return s
+	}
+
+	s := replay()
+	if s.GetValue("a") != 2 || s.GetValue("b") != 2 {
+		t.Errorf("Expected a=2 and b=2 after replay, got a=%v b=%v", s.GetValue("a"), s.GetValue("b"))
+	}
+
+	// a crash in the middle of a transaction: the partial one is dropped
+	truncated := string(content) + "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$1\r\n3\r\n"
+	if err := os.WriteFile(filename, []byte(truncated), 0644); err != nil {
+		t.Fatalf("Error writing AOF file: %v", err)
+	}
+	s = replay()
+	if s.Exists("c") {
+		t.Error("Expected the incomplete transaction to be dropped")
+	}
+	if s.GetValue("b") != 2 {
+		t.Error("Expected complete transactions to be replayed")
+	}
+}
+
+func TestPropagationOrder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	manager := aof.NewAOFManager(filename)
+	if err := manager.Initialize(); err != nil {
+		t.Fatalf("Error initializing AOF manager: %v", err)
+	}
+	s := store.NewStore()
+
+	// the clients write the same keys at once, the AOF must end up with
+	// the writes in the order the store got them
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := NewClient(s, manager, io.Discard)
+			for j := 0; j < 200; j++ {
+				value := strconv.Itoa(i*1000 + j)
+				run(c, "SET order:a "+value, "SET order:b "+value, "RPUSH order:list "+value)
+			}
+		}(i)
+	}
+	wg.Wait()
+	manager.Close()
+
+	replayed := store.NewStore()
+	m := aof.NewAOFManager(filename)
+	if err := m.Initialize(); err != nil {
+		t.Fatalf("Error initializing AOF manager: %v", err)
+	}
+	defer m.Close()
+	m.ReadAndExecuteCommands(func(cmd *parser.Command) {
+		ExecuteCommand(cmd, replayed, io.Discard)
+	})
+	for _, key := range []string{"order:a", "order:b"} {
+		if value, expected := replayed.GetValue(key), s.GetValue(key); value != expected {
+			t.Errorf("Expected %s to be %v after replay, got %v", key, expected, value)
+		}
+	}
+	list, _ := s.ListRange("order:list", 0, -1)
+	replayedList, _ := replayed.ListRange("order:list", 0, -1)
+	if !slices.Equal(list, replayedList) {
+		t.Error("Expected the list replayed in the order it was pushed")
+	}
+}
@@ -0,0 +1,1629 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/snapshot"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// Flags of the nodes of a cluster
+const (
+	CLUSTER_NODE_MYSELF    = 1 << iota // this node
+	CLUSTER_NODE_MASTER                // serves hash slots, every node is a master
+	CLUSTER_NODE_PFAIL                 // possibly failing: no pong for the node timeout
+	CLUSTER_NODE_FAIL                  // failing: a majority of the masters agree
+	CLUSTER_NODE_HANDSHAKE             // met but not added yet, its ID is unknown
+	CLUSTER_NODE_MEET                  // gets MEET instead of PING, so it adds this node
+)
+
+// Types of the messages of the cluster bus
+const (
+	CLUSTERMSG_TYPE_PING = "PING"
+	CLUSTERMSG_TYPE_PONG = "PONG" // the reply to every message
+	CLUSTERMSG_TYPE_MEET = "MEET" // a PING asking the receiver to add the sender
+	CLUSTERMSG_TYPE_FAIL = "FAIL" // a node is failing, with the agreement of a majority
+)
+
+const (
+	CLUSTER_PORT_INCR                 = 10000 // the bus port of a node is its port plus this
+	CLUSTER_NODE_TIMEOUT_DEFAULT      = 15 * time.Second
+	CLUSTER_PING_PERIOD               = time.Second
+	CLUSTER_FAIL_REPORT_VALIDITY_MULT = 2           // failure reports are valid for twice the node timeout
+	CLUSTER_BLACKLIST_TTL             = time.Minute // a forgotten node can't be added again for
+	CLUSTER_CONFIG_FILE_DEFAULT       = "nodes.conf"
+)
+
+/*
+cluster holds the state of a server run with -cluster-enabled.
+
+The keyspace is split into CLUSTER_SLOTS hash slots and every slot is served
+by a node: a command is only run by the node serving the slot of its keys,
+the other nodes redirect the client to it with a MOVED error. A command
+with keys in several slots is refused with CROSSSLOT.
+
+The nodes are connected by the cluster bus, on their port plus
+CLUSTER_PORT_INCR. Every node pings the others every second and they reply
+with a PONG. Both messages carry the slots of the sender and its
+configuration epoch, plus gossip about a few other nodes: this is how the
+nodes discover each other once CLUSTER MEET introduced them, and agree on
+who serves a slot. A slot claimed by two nodes goes to the one with the
+largest configuration epoch.
+
+A node not replying for the node timeout is possibly failing (PFAIL) and
+the nodes tell each other in the gossip. Once a majority of the masters
+report it, it is failing (FAIL) and a FAIL message makes every node agree.
+The cluster is down while a slot is unassigned or served by a failing node.
+
+A slot moves from a node to another with its keys while it is served: the
+target is told it is IMPORTING the slot and the source it is MIGRATING it,
+MIGRATE then moves the keys one batch after the other. Meanwhile the source
+serves the keys it still has and redirects the commands about the other
+ones to the target with an ASK error, the target only serves them to a
+client that sent ASKING first. Once the keys are moved, CLUSTER SETSLOT
+NODE assigns the slot to the target, which takes a new configuration epoch
+so the other nodes learn about it.
+
+The configuration of the node (its ID, the nodes it knows and the slots
+they serve) is saved to the cluster config file on every change.
+*/
+var cluster = struct {
+	mu           sync.Mutex
+	enabled      bool // set at startup
+	myself       *clusterNode
+	nodes        map[string]*clusterNode // by ID, myself and the handshakes included
+	currentEpoch uint64
+	slots        [store.CLUSTER_SLOTS]*clusterNode
+	migrating    [store.CLUSTER_SLOTS]*clusterNode // node the slot is moving to
+	importing    [store.CLUSTER_SLOTS]*clusterNode // node the slot is moving from
+	state        string                            // "ok" or "fail"
+	configFile   string
+	nodeTimeout  time.Duration
+	blacklist    map[string]time.Time // forgotten nodes, until the time they may be added again
+
+	// CLUSTER INFO stats, by message type
+	messagesSent     map[string]int64
+	messagesReceived map[string]int64
+}{
+	nodes:            make(map[string]*clusterNode),
+	state:            "fail",
+	nodeTimeout:      CLUSTER_NODE_TIMEOUT_DEFAULT,
+	blacklist:        make(map[string]time.Time),
+	messagesSent:     make(map[string]int64),
+	messagesReceived: make(map[string]int64),
+}
+
+// clusterNode is a node of the cluster as known by this one
+type clusterNode struct {
+	id           string
+	flags        int
+	ip           string
+	port         int
+	busPort      int
+	configEpoch  uint64
+	replOffset   int64
+	ctime        time.Time // when it was added, a handshake times out
+	pingSent     time.Time // zero when no PING waits for a PONG
+	pongReceived time.Time
+	lastMessage  time.Time // last message sent to it
+	waiting      bool      // a message sent to it waits for its reply
+	failTime     time.Time
+	failReports  map[string]time.Time // when the masters reporting it as failing did
+	link         *clusterLink
+	removed      bool // forgotten, replies to what was sent to it are dropped
+}
+
+// clusterLink is the bus connection to a node, the messages are sent one
+// after the other and each gets a PONG
+type clusterLink struct {
+	mu        sync.Mutex
+	conn      net.Conn
+	reader    *bufio.Reader
+	localIP   string
+	connected atomic.Bool
+}
+
+// clusterGossip is what a message tells about another node
+type clusterGossip struct {
+	id      string
+	ip      string
+	port    int
+	busPort int
+	flags   int
+}
+
+// clusterMsg is a message of the cluster bus. It is sent as a command: the
+// type, the header fields, then 5 words per gossip entry.
+type clusterMsg struct {
+	typ          string
+	sender       string
+	port         int
+	busPort      int
+	flags        int
+	currentEpoch uint64
+	configEpoch  uint64
+	offset       int64
+	slots        string // bitmap of the slots of the sender
+	failing      string // the failing node of a FAIL message
+	gossip       []clusterGossip
+}
+
+const clusterMsgHeaderWords = 10
+
+func (msg *clusterMsg) encode() *parser.Command {
+	args := []string{
+		msg.sender,
+		strconv.Itoa(msg.port),
+		strconv.Itoa(msg.busPort),
+		strconv.Itoa(msg.flags),
+		strconv.FormatUint(msg.currentEpoch, 10),
+		strconv.FormatUint(msg.configEpoch, 10),
+		strconv.FormatInt(msg.offset, 10),
+		msg.slots,
+		msg.failing,
+	}
+	for _, g := range msg.gossip {
+		args = append(args, g.id, g.ip, strconv.Itoa(g.port), strconv.Itoa(g.busPort), strconv.Itoa(g.flags))
+	}
+	return &parser.Command{Name: msg.typ, Args: args}
+}
+
+var errBadClusterMsg = errors.New("malformed cluster bus message")
+
+func decodeClusterMsg(words []string) (*clusterMsg, error) {
+	if len(words) < clusterMsgHeaderWords || (len(words)-clusterMsgHeaderWords)%5 != 0 {
+		return nil, errBadClusterMsg
+	}
+	msg := &clusterMsg{typ: words[0], sender: words[1], slots: words[8], failing: words[9]}
+	var errs [6]error
+	msg.port, errs[0] = strconv.Atoi(words[2])
+	msg.busPort, errs[1] = strconv.Atoi(words[3])
+	msg.flags, errs[2] = strconv.Atoi(words[4])
+	msg.currentEpoch, errs[3] = strconv.ParseUint(words[5], 10, 64)
+	msg.configEpoch, errs[4] = strconv.ParseUint(words[6], 10, 64)
+	msg.offset, errs[5] = strconv.ParseInt(words[7], 10, 64)
+	if errors.Join(errs[:]...) != nil || len(msg.slots) != store.CLUSTER_SLOTS/8 {
+		return nil, errBadClusterMsg
+	}
+	for i := clusterMsgHeaderWords; i < len(words); i += 5 {
+		g := clusterGossip{id: words[i], ip: words[i+1]}
+		var err1, err2, err3 error
+		g.port, err1 = strconv.Atoi(words[i+2])
+		g.busPort, err2 = strconv.Atoi(words[i+3])
+		g.flags, err3 = strconv.Atoi(words[i+4])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, errBadClusterMsg
+		}
+		msg.gossip = append(msg.gossip, g)
+	}
+	return msg, nil
+}
+
+func clusterEnabled() bool {
+	return cluster.enabled
+}
+
+func newClusterNode(id string, flags int) *clusterNode {
+	return &clusterNode{
+		id:          id,
+		flags:       flags,
+		ctime:       time.Now(),
+		failReports: make(map[string]time.Time),
+		link:        &clusterLink{},
+	}
+}
+
+func (node *clusterNode) addr() string {
+	return net.JoinHostPort(node.ip, strconv.Itoa(node.port))
+}
+
+func (node *clusterNode) busAddr() string {
+	return net.JoinHostPort(node.ip, strconv.Itoa(node.busPort))
+}
+
+// numSlots returns the number of slots the node serves
+func (node *clusterNode) numSlots() int {
+	n := 0
+	for _, owner := range cluster.slots {
+		if owner == node {
+			n++
+		}
+	}
+	return n
+}
+
+// close drops the connection, the next message reconnects
+func (l *clusterLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	l.connected.Store(false)
+}
+
+// send sends a message to the node at addr and reads the PONG it replies
+func (l *clusterLink) send(addr string, timeout time.Duration, msg *parser.Command) (*parser.Value, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, "", err
+		}
+		l.conn, l.reader = conn, bufio.NewReader(conn)
+		l.localIP, _, _ = net.SplitHostPort(conn.LocalAddr().String())
+		l.connected.Store(true)
+	}
+	v, err := roundTrip(l.conn, l.reader, timeout, append([]string{msg.Name}, msg.Args...)...)
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		l.connected.Store(false)
+	}
+	return v, l.localIP, err
+}
+
+// initCluster makes the server a cluster node listening to the bus on
+// busPort, with the configuration of configFile or as a new node if there
+// is no such file
+func initCluster(configFile string, port int, busPort int, nodeTimeout time.Duration) error {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.enabled = true
+	cluster.configFile = configFile
+	cluster.nodeTimeout = nodeTimeout
+	if err := clusterLoadConfig(configFile); errors.Is(err, os.ErrNotExist) {
+		cluster.myself = newClusterNode(newReplicationID(), CLUSTER_NODE_MYSELF|CLUSTER_NODE_MASTER)
+		cluster.nodes[cluster.myself.id] = cluster.myself
+		log.Printf("No cluster configuration found, I'm %s", cluster.myself.id)
+	} else if err != nil {
+		return err
+	} else {
+		log.Printf("Node configuration loaded, I'm %s", cluster.myself.id)
+	}
+	cluster.myself.port = port
+	cluster.myself.busPort = busPort
+	clusterSaveConfig()
+	clusterUpdateState()
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cluster.myself.busPort))
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("Error accepting a cluster bus connection: %v", err)
+				return
+			}
+			go clusterHandleConnection(conn)
+		}
+	}()
+	return nil
+}
+
+// clusterHandleConnection replies to the messages other nodes send on an
+// inbound bus connection
+func clusterHandleConnection(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	localIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	for {
+		cmd, err := parser.ReadCommand(reader)
+		if err != nil {
+			return
+		}
+		msg, err := decodeClusterMsg(append([]string{cmd.Name}, cmd.Args...))
+		if err != nil {
+			log.Printf("Dropping the bus connection of %s: %v", remoteIP, err)
+			return
+		}
+		cluster.mu.Lock()
+		cluster.messagesReceived[msg.typ]++
+		clusterProcessMessage(msg, remoteIP, localIP, nil)
+		reply := clusterBuildMessage(CLUSTERMSG_TYPE_PONG, nil)
+		cluster.messagesSent[CLUSTERMSG_TYPE_PONG]++
+		cluster.mu.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(cluster.nodeTimeout))
+		if _, err := io.WriteString(conn, aof.EncodeCommand(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// clusterBuildMessage returns a message from this node to node (nil for a
+// reply), cluster.mu must be held. The gossip is about a few random nodes
+// and every node possibly failing, so the failure reports spread fast.
+func clusterBuildMessage(typ string, node *clusterNode) *parser.Command {
+	myself := cluster.myself
+	repl.mu.Lock()
+	offset := repl.offset
+	repl.mu.Unlock()
+	msg := &clusterMsg{
+		typ:          typ,
+		sender:       myself.id,
+		port:         myself.port,
+		busPort:      myself.busPort,
+		flags:        myself.flags,
+		currentEpoch: cluster.currentEpoch,
+		configEpoch:  myself.configEpoch,
+		offset:       offset,
+		slots:        string(clusterSlotsBitmap(myself)),
+	}
+
+	var candidates []*clusterNode
+	for _, n := range cluster.nodes {
+		if n != myself && n != node && n.flags&CLUSTER_NODE_HANDSHAKE == 0 && n.ip != "" {
+			candidates = append(candidates, n)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	wanted := max(3, len(cluster.nodes)/10)
+	for i, n := range candidates {
+		if i < wanted || n.flags&(CLUSTER_NODE_PFAIL|CLUSTER_NODE_FAIL) != 0 {
+			msg.gossip = append(msg.gossip, clusterGossip{id: n.id, ip: n.ip, port: n.port, busPort: n.busPort, flags: n.flags})
+		}
+	}
+	return msg.encode()
+}
+
+// clusterSlotsBitmap returns the bitmap of the slots node serves
+func clusterSlotsBitmap(node *clusterNode) []byte {
+	bitmap := make([]byte, store.CLUSTER_SLOTS/8)
+	for slot, owner := range cluster.slots {
+		if owner == node {
+			bitmap[slot/8] |= 1 << (slot % 8)
+		}
+	}
+	return bitmap
+}
+
+// clusterSendMessage sends a message to node in the background, its reply
+// is processed with cluster.mu held, which must be held by the caller
+func clusterSendMessage(node *clusterNode, typ string) {
+	now := time.Now()
+	if typ == CLUSTERMSG_TYPE_PING || typ == CLUSTERMSG_TYPE_MEET {
+		if node.pingSent.IsZero() {
+			node.pingSent = now
+		}
+	}
+	node.lastMessage = now
+	node.waiting = true
+	msg := clusterBuildMessage(typ, node)
+	cluster.messagesSent[typ]++
+	addr, timeout := node.busAddr(), cluster.nodeTimeout
+	go func() {
+		v, localIP, err := node.link.send(addr, timeout, msg)
+		cluster.mu.Lock()
+		defer cluster.mu.Unlock()
+		node.waiting = false
+		if err != nil || node.removed {
+			return
+		}
+		reply, err := decodeClusterMsg(v.Words())
+		if err != nil {
+			log.Printf("Unexpected reply of node %s: %v", node.id, err)
+			node.link.close()
+			return
+		}
+		cluster.messagesReceived[reply.typ]++
+		clusterProcessMessage(reply, "", localIP, node)
+	}()
+}
+
+// clusterProcessMessage processes a message received on an inbound
+// connection from ip, or the reply of node to a message sent to it.
+// localIP is the address of this node on the connection.
+func clusterProcessMessage(msg *clusterMsg, ip string, localIP string, node *clusterNode) {
+	myself := cluster.myself
+	now := time.Now()
+	if myself.ip == "" && localIP != "" && (msg.typ == CLUSTERMSG_TYPE_MEET || node != nil) {
+		// the address the other nodes reach this one at
+		myself.ip = localIP
+		log.Printf("IP address for this node updated to %s", myself.ip)
+		clusterSaveConfig()
+	}
+
+	sender := cluster.nodes[msg.sender]
+	if sender == myself {
+		return
+	}
+	if node != nil && node.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+		// the reply to a handshake tells the ID of the node
+		if sender != nil || cluster.blacklist[msg.sender].After(now) {
+			clusterDelNode(node)
+			return
+		}
+		delete(cluster.nodes, node.id)
+		node.id = msg.sender
+		node.flags &^= CLUSTER_NODE_HANDSHAKE | CLUSTER_NODE_MEET
+		node.flags |= CLUSTER_NODE_MASTER
+		cluster.nodes[node.id] = node
+		log.Printf("Handshake with node %s completed", node.id)
+		sender = node
+		clusterSaveConfig()
+	} else if node != nil && node != sender {
+		// another node replies at its address, it was restarted with a
+		// new configuration: its slots remain assigned to the old ID
+		log.Printf("PONG contains mismatching sender ID. About node %s, got %s", node.id, msg.sender)
+		node.link.close()
+		return
+	}
+	if sender == nil {
+		if msg.typ != CLUSTERMSG_TYPE_MEET || ip == "" || cluster.blacklist[msg.sender].After(now) {
+			return // only the known nodes are listened to
+		}
+		sender = newClusterNode(msg.sender, CLUSTER_NODE_MASTER)
+		sender.ip, sender.port, sender.busPort = ip, msg.port, msg.busPort
+		cluster.nodes[sender.id] = sender
+		log.Printf("Node %s added by its MEET", sender.id)
+		clusterSaveConfig()
+	}
+	if node != nil {
+		node.flags &^= CLUSTER_NODE_MEET
+	}
+
+	if msg.typ == CLUSTERMSG_TYPE_PONG && node != nil {
+		sender.pongReceived = now
+		sender.pingSent = time.Time{}
+		if sender.flags&CLUSTER_NODE_PFAIL != 0 {
+			sender.flags &^= CLUSTER_NODE_PFAIL
+		}
+		if sender.flags&CLUSTER_NODE_FAIL != 0 {
+			sender.flags &^= CLUSTER_NODE_FAIL
+			log.Printf("Clear FAIL state for node %s: is reachable again", sender.id)
+			clusterSaveConfig()
+		}
+	}
+	if ip != "" && (sender.ip != ip || sender.port != msg.port || sender.busPort != msg.busPort) {
+		log.Printf("Address updated for node %s, now %s:%d", sender.id, ip, msg.port)
+		sender.ip, sender.port, sender.busPort = ip, msg.port, msg.busPort
+		sender.link.close()
+		clusterSaveConfig()
+	}
+	sender.replOffset = msg.offset
+
+	if msg.currentEpoch > cluster.currentEpoch {
+		cluster.currentEpoch = msg.currentEpoch
+		clusterSaveConfig()
+	}
+	if msg.configEpoch > sender.configEpoch {
+		sender.configEpoch = msg.configEpoch
+		clusterSaveConfig()
+	}
+	clusterUpdateSlotsConfigWith(sender, msg.configEpoch, []byte(msg.slots))
+	clusterHandleConfigEpochCollision(sender)
+	clusterProcessGossipSection(sender, msg.gossip)
+
+	if msg.typ == CLUSTERMSG_TYPE_FAIL {
+		failing := cluster.nodes[msg.failing]
+		if failing != nil && failing != myself && failing.flags&CLUSTER_NODE_FAIL == 0 {
+			log.Printf("FAIL message received from %s about %s", sender.id, failing.id)
+			failing.flags = failing.flags&^CLUSTER_NODE_PFAIL | CLUSTER_NODE_FAIL
+			failing.failTime = now
+			clusterSaveConfig()
+		}
+	}
+	clusterUpdateState()
+}
+
+// clusterUpdateSlotsConfigWith gives sender the slots it claims, unless
+// they are served by a node with a larger configuration epoch. The keys of
+// the slots this node loses are deleted, it can't serve them anymore.
+func clusterUpdateSlotsConfigWith(sender *clusterNode, configEpoch uint64, slots []byte) {
+	changed := false
+	for slot := 0; slot < store.CLUSTER_SLOTS; slot++ {
+		if slots[slot/8]&(1<<(slot%8)) == 0 {
+			continue
+		}
+		owner := cluster.slots[slot]
+		if owner == sender || cluster.importing[slot] != nil {
+			// an importing slot is only assigned by CLUSTER SETSLOT
+			continue
+		}
+		if owner != nil && owner.configEpoch >= configEpoch {
+			continue
+		}
+		if owner == cluster.myself {
+			log.Printf("Slot %d lost to node %s", slot, sender.id)
+			clusterDelKeysInSlot(slot)
+		}
+		cluster.slots[slot] = sender
+		if cluster.migrating[slot] == sender {
+			cluster.migrating[slot] = nil
+		}
+		changed = true
+	}
+	if changed {
+		clusterSaveConfig()
+	}
+}
+
+// clusterDelKeysInSlot deletes the keys of a slot this node doesn't serve
+// anymore, the deletions are propagated like DELs
+func clusterDelKeysInSlot(slot int) {
+	for _, key := range kvStore.DeleteKeysInSlot(slot) {
+		del := &parser.Command{Name: "DEL", Args: []string{key}}
+		if aofManager != nil {
+			if err := aofManager.AppendCommand(del); err != nil {
+				log.Fatalf("failed to write to AOF file: %v", err)
+			}
+		}
+		feedReplicas(del)
+	}
+}
+
+// clusterHandleConfigEpochCollision gives this node a new configuration
+// epoch when the sender has the same one, so a slot claimed by both still
+// goes to a single node. Only the node with the smallest ID moves.
+func clusterHandleConfigEpochCollision(sender *clusterNode) {
+	myself := cluster.myself
+	if sender.configEpoch != myself.configEpoch || sender.id <= myself.id {
+		return
+	}
+	cluster.currentEpoch++
+	myself.configEpoch = cluster.currentEpoch
+	log.Printf("WARNING: configEpoch collision with node %s. configEpoch set to %d", sender.id, myself.configEpoch)
+	clusterSaveConfig()
+}
+
+// clusterProcessGossipSection records the failure reports of sender and
+// starts a handshake with the nodes it knows this one doesn't
+func clusterProcessGossipSection(sender *clusterNode, gossip []clusterGossip) {
+	now := time.Now()
+	for _, g := range gossip {
+		node := cluster.nodes[g.id]
+		if node != nil {
+			if node == cluster.myself {
+				continue
+			}
+			if g.flags&(CLUSTER_NODE_PFAIL|CLUSTER_NODE_FAIL) != 0 {
+				node.failReports[sender.id] = now
+				clusterMarkNodeAsFailingIfNeeded(node)
+			} else {
+				delete(node.failReports, sender.id)
+			}
+			continue
+		}
+		if g.flags&CLUSTER_NODE_FAIL == 0 && g.ip != "" && !cluster.blacklist[g.id].After(now) {
+			clusterStartHandshake(g.ip, g.port, g.busPort)
+		}
+	}
+}
+
+// clusterStartHandshake adds a node known by its address only, a MEET
+// makes it add this node and its reply tells its ID. It returns false if a
+// handshake with the address is in progress.
+func clusterStartHandshake(ip string, port int, busPort int) bool {
+	for _, node := range cluster.nodes {
+		if node.flags&CLUSTER_NODE_HANDSHAKE != 0 && node.ip == ip && node.port == port && node.busPort == busPort {
+			return false
+		}
+	}
+	node := newClusterNode(newReplicationID(), CLUSTER_NODE_HANDSHAKE|CLUSTER_NODE_MEET)
+	node.ip, node.port, node.busPort = ip, port, busPort
+	cluster.nodes[node.id] = node
+	return true
+}
+
+// clusterDelNode forgets a node and the slots it serves
+func clusterDelNode(node *clusterNode) {
+	for slot := range cluster.slots {
+		if cluster.slots[slot] == node {
+			cluster.slots[slot] = nil
+		}
+		if cluster.migrating[slot] == node {
+			cluster.migrating[slot] = nil
+		}
+		if cluster.importing[slot] == node {
+			cluster.importing[slot] = nil
+		}
+	}
+	for _, other := range cluster.nodes {
+		delete(other.failReports, node.id)
+	}
+	delete(cluster.nodes, node.id)
+	node.removed = true
+	go node.link.close()
+	clusterSaveConfig()
+}
+
+// clusterSize returns the number of masters serving slots, a majority of
+// them is needed to agree a node is failing
+func clusterSize() int {
+	serving := make(map[*clusterNode]bool)
+	for _, owner := range cluster.slots {
+		if owner != nil {
+			serving[owner] = true
+		}
+	}
+	return len(serving)
+}
+
+// clusterMarkNodeAsFailingIfNeeded flags a node possibly failing as failing
+// once a majority of the masters report it, and tells every node
+func clusterMarkNodeAsFailingIfNeeded(node *clusterNode) {
+	if node.flags&CLUSTER_NODE_PFAIL == 0 || node.flags&CLUSTER_NODE_FAIL != 0 {
+		return
+	}
+	validity := CLUSTER_FAIL_REPORT_VALIDITY_MULT * cluster.nodeTimeout
+	failures := 1 // this node thinks so too
+	for reporter, at := range node.failReports {
+		if time.Since(at) > validity || cluster.nodes[reporter] == nil {
+			delete(node.failReports, reporter)
+			continue
+		}
+		failures++
+	}
+	if failures < clusterSize()/2+1 {
+		return
+	}
+	log.Printf("Marking node %s as failing (quorum reached)", node.id)
+	node.flags = node.flags&^CLUSTER_NODE_PFAIL | CLUSTER_NODE_FAIL
+	node.failTime = time.Now()
+	node.failReports = make(map[string]time.Time)
+	for _, other := range cluster.nodes {
+		if other != cluster.myself && other.flags&CLUSTER_NODE_HANDSHAKE == 0 {
+			clusterSendFail(other, node.id)
+		}
+	}
+	clusterSaveConfig()
+}
+
+// clusterSendFail sends a FAIL message about the failing node, even if a
+// message waits for its reply
+func clusterSendFail(node *clusterNode, failing string) {
+	msg := clusterBuildMessage(CLUSTERMSG_TYPE_FAIL, node)
+	msg.Args[8] = failing
+	cluster.messagesSent[CLUSTERMSG_TYPE_FAIL]++
+	addr, timeout := node.busAddr(), cluster.nodeTimeout
+	go node.link.send(addr, timeout, msg)
+}
+
+// clusterBroadcastPing pings every node now, so they learn about a change
+// of the slots of this node without waiting for the next ping
+func clusterBroadcastPing() {
+	for _, node := range cluster.nodes {
+		if node != cluster.myself && node.flags&CLUSTER_NODE_HANDSHAKE == 0 && !node.waiting {
+			clusterSendMessage(node, CLUSTERMSG_TYPE_PING)
+		}
+	}
+}
+
+// clusterCron runs 10 times per second: it pings the nodes, flags the
+// ones not replying as possibly failing and times out the handshakes
+func clusterCron() {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	now := time.Now()
+	pingPeriod := min(CLUSTER_PING_PERIOD, cluster.nodeTimeout/2)
+	handshakeTimeout := max(cluster.nodeTimeout, time.Second)
+	for id, expire := range cluster.blacklist {
+		if now.After(expire) {
+			delete(cluster.blacklist, id)
+		}
+	}
+	for _, node := range cluster.nodes {
+		if node == cluster.myself {
+			continue
+		}
+		if node.flags&CLUSTER_NODE_HANDSHAKE != 0 && now.Sub(node.ctime) > handshakeTimeout {
+			clusterDelNode(node)
+			continue
+		}
+		if !node.waiting && now.Sub(node.lastMessage) >= pingPeriod {
+			if node.flags&CLUSTER_NODE_MEET != 0 {
+				clusterSendMessage(node, CLUSTERMSG_TYPE_MEET)
+			} else {
+				clusterSendMessage(node, CLUSTERMSG_TYPE_PING)
+			}
+		}
+		if node.flags&CLUSTER_NODE_HANDSHAKE == 0 && !node.pingSent.IsZero() && now.Sub(node.pingSent) > cluster.nodeTimeout &&
+			node.flags&(CLUSTER_NODE_PFAIL|CLUSTER_NODE_FAIL) == 0 {
+			log.Printf("*** NODE %s possibly failing", node.id)
+			node.flags |= CLUSTER_NODE_PFAIL
+			clusterMarkNodeAsFailingIfNeeded(node)
+		}
+	}
+	clusterUpdateState()
+}
+
+// clusterUpdateState computes if the cluster is ok: every slot is served
+// by a node that isn't failing, and a majority of the masters serving
+// slots are reachable from this node
+func clusterUpdateState() {
+	state := "ok"
+	reachable := make(map[*clusterNode]bool)
+	for _, owner := range cluster.slots {
+		if owner == nil || owner.flags&CLUSTER_NODE_FAIL != 0 {
+			state = "fail"
+			break
+		}
+		if owner.flags&CLUSTER_NODE_PFAIL == 0 {
+			reachable[owner] = true
+		}
+	}
+	if state == "ok" && len(reachable) < clusterSize()/2+1 {
+		state = "fail"
+	}
+	if state != cluster.state {
+		log.Printf("Cluster state changed: %s", state)
+		cluster.state = state
+	}
+}
+
+// clusterNodeFlags returns the flags of a node as shown by CLUSTER NODES
+func clusterNodeFlags(node *clusterNode) string {
+	var flags []string
+	if node.flags&CLUSTER_NODE_MYSELF != 0 {
+		flags = append(flags, "myself")
+	}
+	if node.flags&CLUSTER_NODE_MASTER != 0 {
+		flags = append(flags, "master")
+	}
+	if node.flags&CLUSTER_NODE_PFAIL != 0 {
+		flags = append(flags, "fail?")
+	}
+	if node.flags&CLUSTER_NODE_FAIL != 0 {
+		flags = append(flags, "fail")
+	}
+	if node.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+		flags = append(flags, "handshake")
+	}
+	if node.ip == "" && node.flags&CLUSTER_NODE_MYSELF == 0 {
+		flags = append(flags, "noaddr")
+	}
+	if len(flags) == 0 {
+		return "noflags"
+	}
+	return strings.Join(flags, ",")
+}
+
+// clusterSlotRanges returns the ranges of slots node serves, as pairs of
+// first and last slots
+func clusterSlotRanges(node *clusterNode) [][2]int {
+	var ranges [][2]int
+	for slot := 0; slot < store.CLUSTER_SLOTS; slot++ {
+		if cluster.slots[slot] != node {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == slot-1 {
+			ranges[n-1][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// clusterGenNodeDescription returns the line of CLUSTER NODES about node
+func clusterGenNodeDescription(node *clusterNode) string {
+	var b strings.Builder
+	linkState := "disconnected"
+	if node == cluster.myself || node.link.connected.Load() {
+		linkState = "connected"
+	}
+	fmt.Fprintf(&b, "%s %s:%d@%d %s - %d %d %d %s", node.id, node.ip, node.port, node.busPort, clusterNodeFlags(node),
+		unixMilli(node.pingSent), unixMilli(node.pongReceived), node.configEpoch, linkState)
+	for _, r := range clusterSlotRanges(node) {
+		if r[0] == r[1] {
+			fmt.Fprintf(&b, " %d", r[0])
+		} else {
+			fmt.Fprintf(&b, " %d-%d", r[0], r[1])
+		}
+	}
+	if node == cluster.myself {
+		for slot := 0; slot < store.CLUSTER_SLOTS; slot++ {
+			if target := cluster.migrating[slot]; target != nil {
+				fmt.Fprintf(&b, " [%d->-%s]", slot, target.id)
+			} else if source := cluster.importing[slot]; source != nil {
+				fmt.Fprintf(&b, " [%d-<-%s]", slot, source.id)
+			}
+		}
+	}
+	return b.String()
+}
+
+// sortedClusterNodes returns the nodes by ID, without the handshakes
+func sortedClusterNodes() []*clusterNode {
+	var nodes []*clusterNode
+	for _, node := range cluster.nodes {
+		if node.flags&CLUSTER_NODE_HANDSHAKE == 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	slices.SortFunc(nodes, func(a, b *clusterNode) int {
+		return strings.Compare(a.id, b.id)
+	})
+	return nodes
+}
+
+// clusterGenNodesDescription returns the text of CLUSTER NODES, which is
+// also the content of the config file
+func clusterGenNodesDescription() string {
+	var b strings.Builder
+	for _, node := range sortedClusterNodes() {
+		b.WriteString(clusterGenNodeDescription(node))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// clusterSaveConfig writes the configuration of the node to the config
+// file, cluster.mu must be held
+func clusterSaveConfig() {
+	if cluster.configFile == "" {
+		return
+	}
+	content := clusterGenNodesDescription() + fmt.Sprintf("vars currentEpoch %d lastVoteEpoch 0\n", cluster.currentEpoch)
+	tmp := cluster.configFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		log.Fatalf("Fatal: can't update cluster config file: %v", err)
+	}
+	if err := os.Rename(tmp, cluster.configFile); err != nil {
+		log.Fatalf("Fatal: can't update cluster config file: %v", err)
+	}
+}
+
+// clusterLoadConfig loads the configuration saved by clusterSaveConfig
+func clusterLoadConfig(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	lookup := func(id string) *clusterNode {
+		node := cluster.nodes[id]
+		if node == nil {
+			node = newClusterNode(id, 0)
+			cluster.nodes[id] = node
+		}
+		return node
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		bad := fmt.Errorf("unrecoverable error: corrupted cluster config file %s, line %d", file, i+1)
+		if fields[0] == "vars" {
+			for j := 1; j+1 < len(fields); j += 2 {
+				if fields[j] == "currentEpoch" {
+					if cluster.currentEpoch, err = strconv.ParseUint(fields[j+1], 10, 64); err != nil {
+						return bad
+					}
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return bad
+		}
+		node := lookup(fields[0])
+		host, ports, ok1 := strings.Cut(fields[1], "@")
+		sep := strings.LastIndexByte(host, ':')
+		var err1, err2, err3 error
+		if sep >= 0 {
+			node.ip = host[:sep]
+			node.port, err1 = strconv.Atoi(host[sep+1:])
+		}
+		node.busPort, err2 = strconv.Atoi(ports)
+		node.configEpoch, err3 = strconv.ParseUint(fields[6], 10, 64)
+		if !ok1 || sep < 0 || err1 != nil || err2 != nil || err3 != nil {
+			return bad
+		}
+		for _, flag := range strings.Split(fields[2], ",") {
+			switch flag {
+			case "myself":
+				node.flags |= CLUSTER_NODE_MYSELF
+				cluster.myself = node
+			case "master":
+				node.flags |= CLUSTER_NODE_MASTER
+			case "fail?":
+				node.flags |= CLUSTER_NODE_PFAIL
+			case "fail":
+				node.flags |= CLUSTER_NODE_FAIL
+			}
+		}
+		for _, slots := range fields[8:] {
+			if strings.HasPrefix(slots, "[") {
+				// [slot->-id] is migrating, [slot-<-id] importing
+				slots = strings.Trim(slots, "[]")
+				if slotArg, id, ok := strings.Cut(slots, "->-"); ok {
+					slot, err := strconv.Atoi(slotArg)
+					if err != nil || slot < 0 || slot >= store.CLUSTER_SLOTS {
+						return bad
+					}
+					cluster.migrating[slot] = lookup(id)
+				} else if slotArg, id, ok := strings.Cut(slots, "-<-"); ok {
+					slot, err := strconv.Atoi(slotArg)
+					if err != nil || slot < 0 || slot >= store.CLUSTER_SLOTS {
+						return bad
+					}
+					cluster.importing[slot] = lookup(id)
+				} else {
+					return bad
+				}
+				continue
+			}
+			first, last, isRange := strings.Cut(slots, "-")
+			if !isRange {
+				last = first
+			}
+			start, err1 := strconv.Atoi(first)
+			end, err2 := strconv.Atoi(last)
+			if err1 != nil || err2 != nil || start < 0 || end >= store.CLUSTER_SLOTS || start > end {
+				return bad
+			}
+			for slot := start; slot <= end; slot++ {
+				cluster.slots[slot] = node
+			}
+		}
+	}
+	if cluster.myself == nil {
+		return fmt.Errorf("unrecoverable error: no myself node in the cluster config file %s", file)
+	}
+	return nil
+}
+
+// clusterKeys returns the keys of a command, the channels of the sharded
+// pub/sub commands are hashed like keys
+func clusterKeys(cmd *parser.Command) []string {
+	switch strings.ToUpper(cmd.Name) {
+	case "SPUBLISH":
+		return cmd.Args[:1]
+	case "SSUBSCRIBE", "SUNSUBSCRIBE":
+		return cmd.Args
+	}
+	return lookupCommand(cmd.Name).keys(append([]string{cmd.Name}, cmd.Args...))
+}
+
+// clusterRedirect returns the error redirecting the client to the node
+// serving the keys of cmds, a command or the queue of EXEC. It is empty
+// when this node runs them.
+func (c *Client) clusterRedirect(cmds []*parser.Command) string {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	myself := cluster.myself
+	var n *clusterNode
+	slot, firstKey := -1, ""
+	multipleKeys, migrating, importing, asking := false, false, false, c.flags&CLIENT_ASKING != 0
+	missing, existing := 0, 0
+	isMigrate := false
+	for _, cmd := range cmds {
+		name := strings.ToUpper(cmd.Name)
+		isMigrate = isMigrate || name == "MIGRATE"
+		asking = asking || lookupCommand(name).flags&CMD_ASKING != 0
+		isKey := lookupCommand(name).flags&CMD_PUBSUB == 0 // not a shard channel
+		for _, key := range clusterKeys(cmd) {
+			keySlot := store.KeyHashSlot(key)
+			if slot == -1 {
+				slot, firstKey = keySlot, key
+				n = cluster.slots[slot]
+				if n == nil {
+					return "CLUSTERDOWN Hash slot not served"
+				}
+				if n == myself && cluster.migrating[slot] != nil {
+					migrating = true
+				} else if cluster.importing[slot] != nil {
+					importing = true
+				}
+			} else if keySlot != slot {
+				return "CROSSSLOT Keys in request don't hash to the same slot"
+			} else if key != firstKey {
+				multipleKeys = true
+			}
+			if (migrating || importing) && isKey {
+				if c.store.Exists(key) {
+					existing++
+				} else {
+					missing++
+				}
+			}
+		}
+	}
+	switch {
+	case slot == -1:
+		return "" // no keys, any node runs it
+	case cluster.state != "ok":
+		return "CLUSTERDOWN The cluster is down"
+	case isMigrate && (migrating || importing):
+		return ""
+	case migrating && missing > 0:
+		if existing > 0 {
+			return "TRYAGAIN Multiple keys request during rehashing of slot"
+		}
+		return fmt.Sprintf("ASK %d %s", slot, cluster.migrating[slot].addr())
+	case importing && asking:
+		if multipleKeys && missing > 0 {
+			return "TRYAGAIN Multiple keys request during rehashing of slot"
+		}
+		return ""
+	case n != myself:
+		return fmt.Sprintf("MOVED %d %s", slot, n.addr())
+	}
+	return ""
+}
+
+// getSlotOrReply parses a slot, replying with an error if it isn't one
+func getSlotOrReply(w *resp.Writer, arg string) (int, bool) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= store.CLUSTER_SLOTS {
+		w.WriteError("ERR Invalid or out of range slot")
+		return 0, false
+	}
+	return slot, true
+}
+
+// clusterSlotsOfArgs parses the slots of ADDSLOTS and DELSLOTS, or the
+// ranges of their RANGE variants
+func clusterSlotsOfArgs(w *resp.Writer, args []string, ranges bool) ([]int, bool) {
+	var slots []int
+	if !ranges {
+		for _, arg := range args {
+			slot, ok := getSlotOrReply(w, arg)
+			if !ok {
+				return nil, false
+			}
+			slots = append(slots, slot)
+		}
+	} else {
+		for i := 0; i < len(args); i += 2 {
+			start, ok1 := getSlotOrReply(w, args[i])
+			if !ok1 {
+				return nil, false
+			}
+			end, ok2 := getSlotOrReply(w, args[i+1])
+			if !ok2 {
+				return nil, false
+			}
+			if start > end {
+				w.WriteError(fmt.Sprintf("ERR start slot number %d is greater than end slot number %d", start, end))
+				return nil, false
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+	}
+	seen := make(map[int]bool)
+	for _, slot := range slots {
+		if seen[slot] {
+			w.WriteError(fmt.Sprintf("ERR Slot %d specified multiple times", slot))
+			return nil, false
+		}
+		seen[slot] = true
+	}
+	return slots, true
+}
+
+// writeClusterInfo writes the text of CLUSTER INFO
+func writeClusterInfo(b *strings.Builder) {
+	assigned, pfail, fail := 0, 0, 0
+	for _, owner := range cluster.slots {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		if owner.flags&CLUSTER_NODE_FAIL != 0 {
+			fail++
+		} else if owner.flags&CLUSTER_NODE_PFAIL != 0 {
+			pfail++
+		}
+	}
+	fmt.Fprintf(b, "cluster_state:%s\r\n", cluster.state)
+	fmt.Fprintf(b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(b, "cluster_slots_ok:%d\r\n", assigned-pfail-fail)
+	fmt.Fprintf(b, "cluster_slots_pfail:%d\r\n", pfail)
+	fmt.Fprintf(b, "cluster_slots_fail:%d\r\n", fail)
+	fmt.Fprintf(b, "cluster_known_nodes:%d\r\n", len(sortedClusterNodes()))
+	fmt.Fprintf(b, "cluster_size:%d\r\n", clusterSize())
+	fmt.Fprintf(b, "cluster_current_epoch:%d\r\n", cluster.currentEpoch)
+	fmt.Fprintf(b, "cluster_my_epoch:%d\r\n", cluster.myself.configEpoch)
+	types := []string{CLUSTERMSG_TYPE_PING, CLUSTERMSG_TYPE_PONG, CLUSTERMSG_TYPE_MEET, CLUSTERMSG_TYPE_FAIL}
+	for _, stats := range []struct {
+		direction string
+		counts    map[string]int64
+	}{{"sent", cluster.messagesSent}, {"received", cluster.messagesReceived}} {
+		total := int64(0)
+		for _, typ := range types {
+			if n := stats.counts[typ]; n > 0 {
+				fmt.Fprintf(b, "cluster_stats_messages_%s_%s:%d\r\n", strings.ToLower(typ), stats.direction, n)
+				total += n
+			}
+		}
+		fmt.Fprintf(b, "cluster_stats_messages_%s:%d\r\n", stats.direction, total)
+	}
+}
+
+// writeClusterInfoSection writes the cluster section of INFO
+func writeClusterInfoSection(b *strings.Builder) {
+	b.WriteString("# Cluster\r\n")
+	if clusterEnabled() {
+		b.WriteString("cluster_enabled:1\r\n")
+	} else {
+		b.WriteString("cluster_enabled:0\r\n")
+	}
+}
+
+// writeClusterShards writes the reply of CLUSTER SHARDS: a shard per
+// master, with its slots and the node itself as there are no replicas
+func writeClusterShards(w *resp.Writer) {
+	var masters []*clusterNode
+	for _, node := range sortedClusterNodes() {
+		if node.flags&CLUSTER_NODE_MASTER != 0 {
+			masters = append(masters, node)
+		}
+	}
+	w.WriteArrayLen(len(masters))
+	for _, node := range masters {
+		w.WriteMapLen(2)
+		w.WriteBulkString("slots")
+		ranges := clusterSlotRanges(node)
+		w.WriteArrayLen(2 * len(ranges))
+		for _, r := range ranges {
+			w.WriteInteger(int64(r[0]))
+			w.WriteInteger(int64(r[1]))
+		}
+		w.WriteBulkString("nodes")
+		w.WriteArrayLen(1)
+		w.WriteMapLen(7)
+		w.WriteBulkString("id")
+		w.WriteBulkString(node.id)
+		w.WriteBulkString("port")
+		w.WriteInteger(int64(node.port))
+		w.WriteBulkString("ip")
+		w.WriteBulkString(node.ip)
+		w.WriteBulkString("endpoint")
+		w.WriteBulkString(node.ip)
+		w.WriteBulkString("role")
+		w.WriteBulkString("master")
+		w.WriteBulkString("replication-offset")
+		if node == cluster.myself {
+			repl.mu.Lock()
+			w.WriteInteger(repl.offset)
+			repl.mu.Unlock()
+		} else {
+			w.WriteInteger(node.replOffset)
+		}
+		w.WriteBulkString("health")
+		if node.flags&CLUSTER_NODE_FAIL != 0 {
+			w.WriteBulkString("fail")
+		} else {
+			w.WriteBulkString("online")
+		}
+	}
+}
+
+// clusterCommand handles CLUSTER <subcommand>
+func (c *Client) clusterCommand(args []string) {
+	w := c.writer()
+	if !clusterEnabled() {
+		w.WriteError("ERR This instance has cluster support disabled")
+		return
+	}
+	sub := strings.ToUpper(args[0])
+	args = args[1:]
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	myself := cluster.myself
+
+	switch {
+	case sub == "MYID" && len(args) == 0:
+		w.WriteBulkString(myself.id)
+	case sub == "INFO" && len(args) == 0:
+		var b strings.Builder
+		writeClusterInfo(&b)
+		w.WriteVerbatim("txt", b.String())
+	case sub == "NODES" && len(args) == 0:
+		w.WriteVerbatim("txt", clusterGenNodesDescription())
+	case sub == "SLOTS" && len(args) == 0:
+		type slotRange struct {
+			start, end int
+			node       *clusterNode
+		}
+		var ranges []slotRange
+		for _, node := range sortedClusterNodes() {
+			for _, r := range clusterSlotRanges(node) {
+				ranges = append(ranges, slotRange{r[0], r[1], node})
+			}
+		}
+		slices.SortFunc(ranges, func(a, b slotRange) int { return a.start - b.start })
+		w.WriteArrayLen(len(ranges))
+		for _, r := range ranges {
+			w.WriteArrayLen(3)
+			w.WriteInteger(int64(r.start))
+			w.WriteInteger(int64(r.end))
+			w.WriteArrayLen(3)
+			w.WriteBulkString(r.node.ip)
+			w.WriteInteger(int64(r.node.port))
+			w.WriteBulkString(r.node.id)
+		}
+	case sub == "SHARDS" && len(args) == 0:
+		writeClusterShards(w)
+	case sub == "KEYSLOT" && len(args) == 1:
+		w.WriteInteger(int64(store.KeyHashSlot(args[0])))
+	case sub == "COUNTKEYSINSLOT" && len(args) == 1:
+		slot, err := strconv.Atoi(args[0])
+		if err != nil || slot < 0 || slot >= store.CLUSTER_SLOTS {
+			w.WriteError("ERR Invalid slot")
+			return
+		}
+		w.WriteInteger(int64(c.store.CountKeysInSlot(slot)))
+	case sub == "GETKEYSINSLOT" && len(args) == 2:
+		slot, err1 := strconv.Atoi(args[0])
+		count, err2 := strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil || slot < 0 || slot >= store.CLUSTER_SLOTS || count < 0 {
+			w.WriteError("ERR Invalid slot or number of keys")
+			return
+		}
+		keys := c.store.KeysInSlot(slot, count)
+		w.WriteArrayLen(len(keys))
+		for _, key := range keys {
+			w.WriteBulkString(key)
+		}
+	case sub == "MEET" && (len(args) == 2 || len(args) == 3):
+		port, err := strconv.Atoi(args[1])
+		busPort := port + CLUSTER_PORT_INCR
+		if len(args) == 3 && err == nil {
+			busPort, err = strconv.Atoi(args[2])
+		}
+		ip := net.ParseIP(args[0])
+		if err != nil || ip == nil || port <= 0 || port > 65535 || busPort <= 0 || busPort > 65535 {
+			w.WriteError(fmt.Sprintf("ERR Invalid node address specified: %s:%s", args[0], args[1]))
+			return
+		}
+		clusterStartHandshake(ip.String(), port, busPort)
+		w.WriteSimpleString("OK")
+	case (sub == "ADDSLOTS" || sub == "DELSLOTS") && len(args) > 0,
+		(sub == "ADDSLOTSRANGE" || sub == "DELSLOTSRANGE") && len(args) > 0 && len(args)%2 == 0:
+		slots, ok := clusterSlotsOfArgs(w, args, strings.HasSuffix(sub, "RANGE"))
+		if !ok {
+			return
+		}
+		adding := strings.HasPrefix(sub, "ADD")
+		for _, slot := range slots {
+			if adding && cluster.slots[slot] != nil {
+				w.WriteError(fmt.Sprintf("ERR Slot %d is already busy", slot))
+				return
+			}
+			if !adding && cluster.slots[slot] == nil {
+				w.WriteError(fmt.Sprintf("ERR Slot %d is already unassigned", slot))
+				return
+			}
+		}
+		for _, slot := range slots {
+			if adding {
+				cluster.slots[slot] = myself
+				cluster.importing[slot] = nil
+			} else {
+				cluster.slots[slot] = nil
+				cluster.migrating[slot] = nil
+			}
+		}
+		clusterSaveConfig()
+		clusterUpdateState()
+		w.WriteSimpleString("OK")
+	case sub == "SETSLOT" && len(args) >= 2:
+		c.clusterSetSlot(w, args)
+	case sub == "FORGET" && len(args) == 1:
+		node := cluster.nodes[args[0]]
+		switch {
+		case node == nil:
+			w.WriteError(fmt.Sprintf("ERR Unknown node %s", args[0]))
+		case node == myself:
+			w.WriteError("ERR I tried hard but I can't forget myself...")
+		default:
+			cluster.blacklist[node.id] = time.Now().Add(CLUSTER_BLACKLIST_TTL)
+			clusterDelNode(node)
+			clusterUpdateState()
+			w.WriteSimpleString("OK")
+		}
+	case sub == "COUNT-FAILURE-REPORTS" && len(args) == 1:
+		node := cluster.nodes[args[0]]
+		if node == nil {
+			w.WriteError(fmt.Sprintf("ERR Unknown node %s", args[0]))
+			return
+		}
+		w.WriteInteger(int64(len(node.failReports)))
+	case sub == "SAVECONFIG" && len(args) == 0:
+		clusterSaveConfig()
+		w.WriteSimpleString("OK")
+	case sub == "HELP" && len(args) == 0:
+		lines := []string{
+			"CLUSTER <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ADDSLOTS <slot> [<slot> ...]",
+			"    Assign slots to current node.",
+			"ADDSLOTSRANGE <start slot> <end slot> [<start slot> <end slot> ...]",
+			"    Assign slots which are between <start-slot> and <end-slot> to current node.",
+			"COUNT-FAILURE-REPORTS <node-id>",
+			"    Return number of failure reports for <node-id>.",
+			"COUNTKEYSINSLOT <slot>",
+			"    Return the number of keys in <slot>.",
+			"DELSLOTS <slot> [<slot> ...]",
+			"    Delete slots information from current node.",
+			"DELSLOTSRANGE <start slot> <end slot> [<start slot> <end slot> ...]",
+			"    Delete slots information which are between <start-slot> and <end-slot>.",
+			"FORGET <node-id>",
+			"    Remove a node from the cluster.",
+			"GETKEYSINSLOT <slot> <count>",
+			"    Return key names stored by current node in a slot.",
+			"INFO",
+			"    Return information about the cluster.",
+			"KEYSLOT <key>",
+			"    Return the hash slot for <key>.",
+			"MEET <ip> <port> [<bus-port>]",
+			"    Connect nodes into a working cluster.",
+			"MYID",
+			"    Return the node id.",
+			"NODES",
+			"    Return cluster configuration seen by node. Output format:",
+			"    <id> <ip:port@bus-port> <flags> <master> <pings> <pongs> <epoch> <link> <slot> ...",
+			"SAVECONFIG",
+			"    Force saving cluster configuration on disk.",
+			"SETSLOT <slot> <action> [<node-id>]",
+			"    Set slot state. <action> can be one of IMPORTING, MIGRATING, STABLE or NODE.",
+			"SHARDS",
+			"    Return information about slot range mappings and the nodes associated with them.",
+			"SLOTS",
+			"    Return information about slots range mappings. Each range is made of:",
+			"    start, end, master ip, master port and master id.",
+		}
+		w.WriteArrayLen(len(lines))
+		for _, line := range lines {
+			w.WriteSimpleString(line)
+		}
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLUSTER HELP.", strings.ToLower(sub)))
+	}
+}
+
+// clusterSetSlot handles CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE
+// node-id and CLUSTER SETSLOT slot STABLE, cluster.mu is held
+func (c *Client) clusterSetSlot(w *resp.Writer, args []string) {
+	myself := cluster.myself
+	slot, ok := getSlotOrReply(w, args[0])
+	if !ok {
+		return
+	}
+	action := strings.ToUpper(args[1])
+	if action == "STABLE" && len(args) == 2 {
+		cluster.migrating[slot], cluster.importing[slot] = nil, nil
+		clusterSaveConfig()
+		w.WriteSimpleString("OK")
+		return
+	}
+	if len(args) != 3 || (action != "MIGRATING" && action != "IMPORTING" && action != "NODE") {
+		w.WriteError("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+		return
+	}
+	node := cluster.nodes[args[2]]
+	if node == nil || node.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+		w.WriteError(fmt.Sprintf("ERR I don't know about node %s", args[2]))
+		return
+	}
+
+	switch action {
+	case "MIGRATING":
+		if cluster.slots[slot] != myself {
+			w.WriteError(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+			return
+		}
+		if node == myself {
+			w.WriteError("ERR I can't migrate a slot to myself")
+			return
+		}
+		cluster.migrating[slot] = node
+	case "IMPORTING":
+		if cluster.slots[slot] == myself {
+			w.WriteError(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+			return
+		}
+		if node == myself {
+			w.WriteError("ERR I can't import a slot from myself")
+			return
+		}
+		cluster.importing[slot] = node
+	case "NODE":
+		if cluster.slots[slot] == myself && node != myself && c.store.CountKeysInSlot(slot) > 0 {
+			w.WriteError(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+			return
+		}
+		if node != myself {
+			cluster.migrating[slot] = nil
+		}
+		if node == myself && cluster.importing[slot] != nil {
+			// the import is over: a new configuration epoch makes the
+			// other nodes prefer this one for the slot
+			cluster.importing[slot] = nil
+			if !clusterHasLargestConfigEpoch(myself) || myself.configEpoch == 0 {
+				cluster.currentEpoch++
+				myself.configEpoch = cluster.currentEpoch
+				log.Printf("configEpoch updated after importing slot %d: %d", slot, myself.configEpoch)
+			}
+		}
+		cluster.slots[slot] = node
+		clusterBroadcastPing()
+	}
+	clusterSaveConfig()
+	clusterUpdateState()
+	w.WriteSimpleString("OK")
+}
+
+// clusterHasLargestConfigEpoch reports if no other node has a
+// configuration epoch as large as the one of node
+func clusterHasLargestConfigEpoch(node *clusterNode) bool {
+	for _, other := range cluster.nodes {
+		if other != node && other.configEpoch >= node.configEpoch {
+			return false
+		}
+	}
+	return true
+}
+
+// migrateGetKeys returns the keys of MIGRATE host port key|"" db timeout
+// [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...]
+func migrateGetKeys(words []string) []string {
+	for i := 6; i < len(words); i++ {
+		switch strings.ToUpper(words[i]) {
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		case "KEYS":
+			if words[3] == "" {
+				return words[i+1:]
+			}
+		}
+	}
+	if len(words) > 3 && words[3] != "" {
+		return words[3:4]
+	}
+	return nil
+}
+
+// migrate handles MIGRATE: the keys are sent to the target with
+// RESTORE-ASKING and deleted unless COPY is given, a DEL is propagated
+// for every key moved
+func (c *Client) migrate(args []string) {
+	w := c.writer()
+	copyKeys, replace := false, false
+	var user, password string
+	keys := args[2:3]
+	for i := 5; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch {
+		case option == "COPY":
+			copyKeys = true
+		case option == "REPLACE":
+			replace = true
+		case option == "AUTH" && i+1 < len(args):
+			password = args[i+1]
+			i++
+		case option == "AUTH2" && i+2 < len(args):
+			user, password = args[i+1], args[i+2]
+			i += 2
+		case option == "KEYS":
+			if args[2] != "" {
+				w.WriteError("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+				return
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+	port, err1 := strconv.Atoi(args[1])
+	timeout, err2 := strconv.ParseInt(args[4], 10, 64)
+	if err1 != nil || err2 != nil {
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	if args[3] != "0" {
+		w.WriteError("ERR DB index is out of range")
+		return
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+
+	var dumps []store.KeyDump
+	for _, key := range keys {
+		if dump, exists := c.store.DumpKey(key); exists {
+			dumps = append(dumps, dump)
+		}
+	}
+	if len(dumps) == 0 {
+		w.WriteSimpleString("NOKEY")
+		return
+	}
+
+	d := time.Duration(timeout) * time.Millisecond
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(args[0], strconv.Itoa(port)), d)
+	if err != nil {
+		w.WriteError("IOERR error or timeout connecting to the client")
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	failure := ""
+	if password != "" {
+		words := []string{"AUTH", password}
+		if user != "" {
+			words = []string{"AUTH", user, password}
+		}
+		v, err := roundTrip(conn, reader, d, words...)
+		if err != nil {
+			w.WriteError("IOERR error or timeout reading to target instance")
+			return
+		}
+		if v.Type == parser.SimpleError {
+			w.WriteError("ERR Target instance replied with error: " + v.Str)
+			return
+		}
+	}
+
+	var moved []string
+	for _, dump := range dumps {
+		ttl := int64(0)
+		if dump.ExpireAt != 0 {
+			ttl = max(dump.ExpireAt*1000-time.Now().UnixMilli(), 1)
+		}
+		words := []string{"RESTORE-ASKING", dump.Key, strconv.FormatInt(ttl, 10), snapshot.EncodeValue(dump)}
+		if replace {
+			words = append(words, "REPLACE")
+		}
+		v, err := roundTrip(conn, reader, d, words...)
+		if err != nil {
+			failure = "IOERR error or timeout reading to target instance"
+			break
+		}
+		if v.Type == parser.SimpleError {
+			failure = "ERR Target instance replied with error: " + v.Str
+			break
+		}
+		moved = append(moved, dump.Key)
+	}
+	if !copyKeys {
+		for _, key := range moved {
+			c.store.DeleteValue(key)
+			c.propagate(&parser.Command{Name: "DEL", Args: []string{key}})
+		}
+	}
+	if failure != "" {
+		w.WriteError(failure)
+	} else {
+		w.WriteSimpleString("OK")
+	}
+}
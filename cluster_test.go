@@ -0,0 +1,406 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// resetCluster gives the test an empty cluster state, restored at the end
+func resetCluster(t *testing.T) {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	saved := cluster.nodes
+	cluster.nodes = make(map[string]*clusterNode)
+	cluster.myself = nil
+	t.Cleanup(func() {
+		cluster.mu.Lock()
+		defer cluster.mu.Unlock()
+		cluster.nodes, cluster.myself = saved, nil
+		cluster.currentEpoch = 0
+		cluster.configFile = ""
+		for slot := range cluster.slots {
+			cluster.slots[slot], cluster.migrating[slot], cluster.importing[slot] = nil, nil, nil
+		}
+	})
+}
+
+func TestClusterMsg(t *testing.T) {
+	msg := &clusterMsg{
+		typ:          CLUSTERMSG_TYPE_PING,
+		sender:       "a",
+		port:         7000,
+		busPort:      17000,
+		flags:        CLUSTER_NODE_MASTER,
+		currentEpoch: 3,
+		configEpoch:  2,
+		offset:       42,
+		slots:        strings.Repeat("\x00", store.CLUSTER_SLOTS/8),
+		gossip:       []clusterGossip{{id: "b", ip: "127.0.0.1", port: 7001, busPort: 17001, flags: CLUSTER_NODE_PFAIL}},
+	}
+	encoded := msg.encode()
+	decoded, err := decodeClusterMsg(append([]string{encoded.Name}, encoded.Args...))
+	if err != nil || !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("Expected %+v, got %+v (%v)", msg, decoded, err)
+	}
+	if _, err := decodeClusterMsg(append([]string{encoded.Name}, encoded.Args[:len(encoded.Args)-1]...)); err == nil {
+		t.Error("Expected a truncated gossip entry to be refused")
+	}
+}
+
+func TestClusterConfig(t *testing.T) {
+	resetCluster(t)
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.configFile = filepath.Join(t.TempDir(), "nodes.conf")
+	myself := newClusterNode("me", CLUSTER_NODE_MYSELF|CLUSTER_NODE_MASTER)
+	myself.ip, myself.port, myself.busPort, myself.configEpoch = "127.0.0.1", 7000, 17000, 1
+	other := newClusterNode("other", CLUSTER_NODE_MASTER|CLUSTER_NODE_PFAIL)
+	other.ip, other.port, other.busPort, other.configEpoch = "127.0.0.1", 7001, 27001, 2
+	cluster.myself = myself
+	cluster.nodes = map[string]*clusterNode{"me": myself, "other": other}
+	cluster.currentEpoch = 5
+	for slot := 0; slot <= 100; slot++ {
+		cluster.slots[slot] = myself
+	}
+	cluster.slots[200] = myself
+	cluster.slots[300] = other
+	cluster.migrating[200] = other
+	cluster.importing[300] = other
+	clusterSaveConfig()
+	saved := clusterGenNodesDescription()
+	if !strings.Contains(saved, "me 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-100 200 [200->-other] [300-<-other]\n") {
+		t.Errorf("Unexpected description of myself in %q", saved)
+	}
+
+	cluster.nodes = make(map[string]*clusterNode)
+	cluster.myself, cluster.currentEpoch = nil, 0
+	for slot := range cluster.slots {
+		cluster.slots[slot], cluster.migrating[slot], cluster.importing[slot] = nil, nil, nil
+	}
+	if err := clusterLoadConfig(cluster.configFile); err != nil {
+		t.Fatalf("Error loading the config: %v", err)
+	}
+	if cluster.myself == nil || cluster.myself.id != "me" || cluster.currentEpoch != 5 {
+		t.Fatalf("Expected to be me in epoch 5, got %v in %d", cluster.myself, cluster.currentEpoch)
+	}
+	other = cluster.nodes["other"]
+	if other == nil || other.busPort != 27001 || other.configEpoch != 2 || other.flags != CLUSTER_NODE_MASTER|CLUSTER_NODE_PFAIL {
+		t.Errorf("Unexpected other node %+v", other)
+	}
+	if cluster.slots[100] != cluster.myself || cluster.slots[101] != nil || cluster.slots[300] != other ||
+		cluster.migrating[200] != other || cluster.importing[300] != other {
+		t.Error("Expected the slots to be loaded")
+	}
+	if description := clusterGenNodesDescription(); description != saved {
+		t.Errorf("Expected %q, got %q", saved, description)
+	}
+
+	os.WriteFile(cluster.configFile, []byte("me 127.0.0.1 myself,master\n"), 0644)
+	if err := clusterLoadConfig(cluster.configFile); err == nil {
+		t.Error("Expected a corrupted config to be refused")
+	}
+}
+
+func TestClusterUpdateSlots(t *testing.T) {
+	resetCluster(t)
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	myself := newClusterNode("me", CLUSTER_NODE_MYSELF|CLUSTER_NODE_MASTER)
+	other := newClusterNode("other", CLUSTER_NODE_MASTER)
+	cluster.myself = myself
+	cluster.nodes = map[string]*clusterNode{"me": myself, "other": other}
+	myself.configEpoch = 2
+	cluster.slots[1], cluster.slots[3] = myself, myself
+	cluster.importing[2] = myself
+
+	bitmap := make([]byte, store.CLUSTER_SLOTS/8)
+	bitmap[0] = 0b1111 // slots 0 to 3
+	clusterUpdateSlotsConfigWith(other, 1, bitmap)
+	if cluster.slots[0] != other || cluster.slots[1] != myself || cluster.slots[2] != nil {
+		t.Error("Expected only the unassigned slot not being imported to be taken")
+	}
+	clusterUpdateSlotsConfigWith(other, 3, bitmap)
+	if cluster.slots[1] != other || cluster.slots[3] != other {
+		t.Error("Expected a larger configuration epoch to win")
+	}
+
+	// the node with the smallest ID moves on a collision
+	other.configEpoch = myself.configEpoch
+	cluster.currentEpoch = 4
+	other.id = "aaa"
+	clusterHandleConfigEpochCollision(other)
+	if myself.configEpoch != 2 {
+		t.Errorf("Expected me to keep my epoch, got %d", myself.configEpoch)
+	}
+	other.id = "zzz"
+	clusterHandleConfigEpochCollision(other)
+	if myself.configEpoch != 5 || cluster.currentEpoch != 5 {
+		t.Errorf("Expected a new epoch 5, got %d", myself.configEpoch)
+	}
+}
+
+func TestClusterFailure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts server processes")
+	}
+	var nodes []*testConn
+	var ids []string
+	var failing *os.Process
+	for i := 0; i < 3; i++ {
+		addr, busPort, process := startClusterNode(t)
+		c := dial(t, addr)
+		first, last := i*store.CLUSTER_SLOTS/3, (i+1)*store.CLUSTER_SLOTS/3-1
+		c.send("CLUSTER", "ADDSLOTSRANGE", strconv.Itoa(first), strconv.Itoa(last))
+		c.expect("+OK\r\n")
+		c.send("CLUSTER", "MYID")
+		ids = append(ids, c.readBulk())
+		if i > 0 {
+			host, port, _ := net.SplitHostPort(addr)
+			nodes[0].send("CLUSTER", "MEET", host, port, busPort)
+			nodes[0].expect("+OK\r\n")
+		}
+		nodes, failing = append(nodes, c), process
+	}
+	for _, node := range nodes {
+		eventually(t, "the nodes to meet", func() bool {
+			return len(node.clusterNodes()) == 3
+		})
+	}
+	a := nodes[0]
+	eventually(t, "the cluster to be up", func() bool {
+		a.send("CLUSTER", "INFO")
+		return strings.Contains(a.readBulk(), "cluster_state:ok\r\n")
+	})
+
+	failing.Kill()
+	eventually(t, "the nodes to agree the killed one is failing", func() bool {
+		for _, line := range a.clusterNodes() {
+			if strings.HasPrefix(line, ids[2]+" ") {
+				return strings.Contains(line, "master,fail ")
+			}
+		}
+		return false
+	})
+	a.send("CLUSTER", "INFO")
+	if info := a.readBulk(); !strings.Contains(info, "cluster_state:fail\r\n") {
+		t.Errorf("Expected the cluster to be down, got %q", info)
+	}
+	a.send("GET", "foo")
+	a.expect("-CLUSTERDOWN The cluster is down\r\n")
+}
+
+func TestClusterFailureReports(t *testing.T) {
+	resetCluster(t)
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	nodes := make([]*clusterNode, 5)
+	for i := range nodes {
+		nodes[i] = newClusterNode(strconv.Itoa(i), CLUSTER_NODE_MASTER)
+		cluster.nodes[nodes[i].id] = nodes[i]
+		cluster.slots[i] = nodes[i]
+	}
+	nodes[0].flags |= CLUSTER_NODE_MYSELF
+	cluster.myself = nodes[0]
+	failing := nodes[4]
+	failing.flags |= CLUSTER_NODE_PFAIL
+
+	clusterProcessGossipSection(nodes[1], []clusterGossip{{id: failing.id, flags: CLUSTER_NODE_PFAIL}})
+	if failing.flags&CLUSTER_NODE_FAIL != 0 {
+		t.Fatal("Expected 2 reports out of 5 not to be enough")
+	}
+	clusterProcessGossipSection(nodes[1], []clusterGossip{{id: failing.id}})
+	clusterProcessGossipSection(nodes[2], []clusterGossip{{id: failing.id, flags: CLUSTER_NODE_PFAIL}})
+	if failing.flags&CLUSTER_NODE_FAIL != 0 || len(failing.failReports) != 1 {
+		t.Fatalf("Expected a report taken back not to count, got %v", failing.failReports)
+	}
+	// the FAIL messages are sent where nothing listens
+	for _, node := range nodes[1:4] {
+		node.ip, node.busPort = "127.0.0.1", 1
+	}
+	clusterProcessGossipSection(nodes[3], []clusterGossip{{id: failing.id, flags: CLUSTER_NODE_FAIL}})
+	if failing.flags&CLUSTER_NODE_FAIL == 0 || failing.flags&CLUSTER_NODE_PFAIL != 0 {
+		t.Errorf("Expected a majority of reports to flag the node as failing, got %s", clusterNodeFlags(failing))
+	}
+	clusterUpdateState()
+	if cluster.state != "fail" {
+		t.Error("Expected the cluster to be down with a slot served by a failing node")
+	}
+}
+
+func TestMigrateGetKeys(t *testing.T) {
+	for _, test := range []struct {
+		words []string
+		keys  []string
+	}{
+		{[]string{"MIGRATE", "host", "6379", "key", "0", "1000"}, []string{"key"}},
+		{[]string{"MIGRATE", "host", "6379", "", "0", "1000", "COPY", "AUTH", "keys", "KEYS", "a", "b"}, []string{"a", "b"}},
+		{[]string{"MIGRATE", "host", "6379", "", "0", "1000", "AUTH2", "user", "KEYS", "KEYS", "a"}, []string{"a"}},
+		{[]string{"MIGRATE", "host", "6379", "", "0", "1000"}, nil},
+	} {
+		if keys := migrateGetKeys(test.words); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("Expected keys %v of %v, got %v", test.keys, test.words, keys)
+		}
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	c := dial(t, startServer(t))
+	c.send("RPUSH", "dump:list", "a", "b")
+	c.expect(":2\r\n")
+	c.send("DUMP", "dump:list")
+	payload := c.readBulk()
+	c.send("DUMP", "dump:missing")
+	c.expect("$-1\r\n")
+
+	c.send("RESTORE", "dump:list", "0", payload)
+	c.expect("-BUSYKEY Target key name already exists.\r\n")
+	c.send("RESTORE", "dump:copy", "0", payload[:len(payload)-1]+"x")
+	c.expect("-ERR DUMP payload version or checksum are wrong\r\n")
+	c.send("RESTORE", "dump:copy", "-1", payload)
+	c.expect("-ERR Invalid TTL value, must be >= 0\r\n")
+	c.send("RESTORE", "dump:copy", "10000", payload)
+	c.expect("+OK\r\n")
+	c.send("LRANGE", "dump:copy", "0", "-1")
+	c.expect("*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	c.send("TTL", "dump:copy")
+	if ttl := c.readLine(); ttl != ":9" && ttl != ":10" {
+		t.Errorf("Expected a TTL of 10 seconds, got %q", ttl)
+	}
+
+	c.send("SET", "dump:list", "other")
+	c.expect("+OK\r\n")
+	c.send("RESTORE", "dump:list", "0", payload, "REPLACE")
+	c.expect("+OK\r\n")
+	c.send("LLEN", "dump:list")
+	c.expect(":2\r\n")
+	c.send("DEL", "dump:list")
+	c.expect("+OK\r\n")
+	c.send("DEL", "dump:copy")
+	c.expect("+OK\r\n")
+
+	c.send("CLUSTER", "INFO")
+	c.expect("-ERR This instance has cluster support disabled\r\n")
+}
+
+// clusterNodes returns the lines of CLUSTER NODES
+func (c *testConn) clusterNodes() []string {
+	c.t.Helper()
+	c.send("CLUSTER", "NODES")
+	return strings.Split(strings.TrimSpace(c.readBulk()), "\n")
+}
+
+// startClusterNode runs a cluster node with a bus port of its own
+func startClusterNode(t *testing.T) (string, string, *os.Process) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error finding a free port: %v", err)
+	}
+	busPort := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	addr, process := startProcess(t, "-cluster-enabled", "-cluster-port", busPort, "-cluster-node-timeout", "1000",
+		"-cluster-config-file", filepath.Join(t.TempDir(), "nodes.conf"))
+	return addr, busPort, process
+}
+
+func TestClusterProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts server processes")
+	}
+	var nodes []*testConn
+	var addrs, busPorts, ids []string
+	for i := 0; i < 3; i++ {
+		addr, busPort, _ := startClusterNode(t)
+		c := dial(t, addr)
+		c.send("CLUSTER", "MYID")
+		nodes, addrs, busPorts, ids = append(nodes, c), append(addrs, addr), append(busPorts, busPort), append(ids, c.readBulk())
+	}
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	a.send("CLUSTER", "ADDSLOTSRANGE", "0", "8191")
+	a.expect("+OK\r\n")
+	b.send("CLUSTER", "ADDSLOTSRANGE", "8192", "16383")
+	b.expect("+OK\r\n")
+	b.send("CLUSTER", "ADDSLOTS", "8192")
+	b.expect("-ERR Slot 8192 is already busy\r\n")
+	for i := 1; i < 3; i++ {
+		host, port, _ := net.SplitHostPort(addrs[i])
+		a.send("CLUSTER", "MEET", host, port, busPorts[i])
+		a.expect("+OK\r\n")
+	}
+	for _, node := range nodes {
+		eventually(t, "the nodes to meet", func() bool {
+			return len(node.clusterNodes()) == 3 && node.info("cluster")["cluster_enabled"] == "1"
+		})
+		eventually(t, "the cluster to be up", func() bool {
+			node.send("CLUSTER", "INFO")
+			return strings.Contains(node.readBulk(), "cluster_state:ok\r\n")
+		})
+	}
+
+	// foo is in slot 12182, served by b
+	a.send("SET", "foo", "bar")
+	a.expect("-MOVED 12182 " + addrs[1] + "\r\n")
+	b.send("SET", "foo", "bar")
+	b.expect("+OK\r\n")
+	b.send("DEL", "foo", "bar")
+	b.expect("-CROSSSLOT Keys in request don't hash to the same slot\r\n")
+	b.send("MULTI")
+	b.expect("+OK\r\n")
+	b.send("GET", "bar")
+	b.expect("-MOVED 5061 " + addrs[0] + "\r\n")
+	b.send("EXEC")
+	b.expect("-EXECABORT Transaction discarded because of previous errors.\r\n")
+	b.send("CLUSTER", "COUNTKEYSINSLOT", "12182")
+	b.expect(":1\r\n")
+
+	// move the slot to c
+	c.send("CLUSTER", "SETSLOT", "12182", "IMPORTING", ids[1])
+	c.expect("+OK\r\n")
+	b.send("CLUSTER", "SETSLOT", "12182", "MIGRATING", ids[2])
+	b.expect("+OK\r\n")
+	b.send("GET", "foo")
+	b.expect("$3\r\nbar\r\n")
+	b.send("GET", "{foo}new")
+	b.expect("-ASK 12182 " + addrs[2] + "\r\n")
+	c.send("GET", "{foo}new")
+	c.expect("-MOVED 12182 " + addrs[1] + "\r\n")
+	c.send("ASKING")
+	c.expect("+OK\r\n")
+	c.send("GET", "{foo}new")
+	c.expect("$-1\r\n")
+
+	host, port, _ := net.SplitHostPort(addrs[2])
+	b.send("MIGRATE", host, port, "", "0", "5000", "KEYS", "foo")
+	b.expect("+OK\r\n")
+	b.send("MIGRATE", host, port, "", "0", "5000", "KEYS", "foo")
+	b.expect("+NOKEY\r\n")
+	b.send("GET", "foo")
+	b.expect("-ASK 12182 " + addrs[2] + "\r\n")
+	c.send("ASKING")
+	c.expect("+OK\r\n")
+	c.send("GET", "foo")
+	c.expect("$3\r\nbar\r\n")
+
+	c.send("CLUSTER", "SETSLOT", "12182", "NODE", ids[2])
+	c.expect("+OK\r\n")
+	b.send("CLUSTER", "SETSLOT", "12182", "NODE", ids[2])
+	b.expect("+OK\r\n")
+	c.send("GET", "foo")
+	c.expect("$3\r\nbar\r\n")
+	eventually(t, "the other nodes to learn the new owner", func() bool {
+		a.send("GET", "foo")
+		return a.readLine() == "-MOVED 12182 "+addrs[2]
+	})
+	for _, line := range a.clusterNodes() {
+		if strings.HasPrefix(line, ids[2]+" ") && !strings.HasSuffix(line, " connected 12182") {
+			t.Errorf("Expected c to serve slot 12182 only, got %q", line)
+		}
+	}
+}
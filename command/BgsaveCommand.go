@@ -2,6 +2,8 @@ package command
 
 import (
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/shubhdevelop/YAKVS/parser"
 	"github.com/shubhdevelop/YAKVS/store"
@@ -11,31 +13,25 @@ import (
 type BgSaveCommand struct {
 	Command *parser.Command	
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewBgsaveCommand creates a new BGSAVE command instance
-func NewBgSaveCommand(cmd *parser.Command, store *store.Store) *BgSaveCommand {
+func NewBgSaveCommand(cmd *parser.Command, store *store.Store, out io.Writer) *BgSaveCommand {
 	return &BgSaveCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the BGSAVE command
 func (dc *BgSaveCommand) Execute() {
-	if len(dc.Command.Args) < 1 {
-		fmt.Println("Error: BGSAVE doesn't require any arguments")	
+	if len(dc.Command.Args) > 1 || (len(dc.Command.Args) == 1 && !strings.EqualFold(dc.Command.Args[0], "SCHEDULE")) {
+		writeError(dc.Out, "ERR syntax error")
 		return
 	}
-
-	// value := dc.Store.Bgsave()
-		
-	// if value == nil {
-	// 	fmt.Println("$-1\r")
-	// } else {
-	// 	fmt.Println("+OK\r")
-	// }
-	fmt.Println("+OK\r")
+	fmt.Fprintln(dc.Out, "+OK\r")
 }
 
 // BgSaveCommandMeta provides metadata for the BGSAVE command
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// CopyCommand handles the COPY command
+type CopyCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewCopyCommand creates a new COPY command instance
+func NewCopyCommand(cmd *parser.Command, store *store.Store, out io.Writer) *CopyCommand {
+	return &CopyCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the COPY command
+func (cc *CopyCommand) Execute() {
+	if len(cc.Command.Args) < 2 {
+		writeArityError(cc.Out, cc.Command)
+		return
+	}
+
+	source := cc.Command.Args[0]
+	destination := cc.Command.Args[1]
+	replace := false
+	for i := 2; i < len(cc.Command.Args); i++ {
+		switch strings.ToUpper(cc.Command.Args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			// there is a single database, only DB 0 can be targeted
+			if i+1 >= len(cc.Command.Args) || cc.Command.Args[i+1] != "0" {
+				writeError(cc.Out, "ERR DB index is out of range")
+				return
+			}
+			i++
+		default:
+			writeError(cc.Out, "ERR syntax error")
+			return
+		}
+	}
+
+	if source == destination {
+		writeError(cc.Out, "ERR source and destination objects are the same")
+		return
+	}
+
+	if cc.Store.Copy(source, destination, replace) {
+		fmt.Fprintln(cc.Out, ":1\r")
+	} else {
+		fmt.Fprintln(cc.Out, ":0\r")
+	}
+}
+
+// CopyCommandMeta provides metadata for the COPY command
+type CopyCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// CopyMeta returns the command metadata
+func CopyMeta() *CopyCommandMeta {
+	return &CopyCommandMeta{
+		Name:      "COPY",
+		Syntax:    "COPY source destination [DB destination-db] [REPLACE]",
+		HelpShort: "COPY copies the value stored at source to destination",
+		HelpLong: `
+COPY copies the value stored at source, including its TTL, to destination.
+
+Both keys share the same value until one of them is modified. Without REPLACE
+the command fails if destination already exists.
+
+The command returns :1 if the value was copied, :0 otherwise.
+		`,
+		Examples: `
+>> SET k1 v1
++OK
+>> COPY k1 k2
+:1
+>> COPY k1 k2
+:0
+>> COPY k1 k2 REPLACE
+:1
+		`,
+	}
+}
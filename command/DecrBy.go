@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/shubhdevelop/YAKVS/parser"
@@ -12,20 +13,22 @@ import (
 type DecreByCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewDecreByCommand creates a new DECRBY command instance
-func NewDecreByCommand(cmd *parser.Command, store *store.Store) *DecreByCommand {
+func NewDecreByCommand(cmd *parser.Command, store *store.Store, out io.Writer) *DecreByCommand {
 	return &DecreByCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the DECRBY command
 func (sc *DecreByCommand) Execute() {
 	if len(sc.Command.Args) < 2 {
-		fmt.Println("Error: DECRBY requires 2 arguments (key, value)")
+		writeArityError(sc.Out, sc.Command)
 		return
 	}	
 
@@ -35,17 +38,21 @@ func (sc *DecreByCommand) Execute() {
 	//change the value to string 
 	valueInt, err := strconv.Atoi(value)
 	if err != nil {
-		fmt.Println("Error: DECRBY requires a valid integer value")
+		writeError(sc.Out, "ERR value is not an integer or out of range")
 		return
 	}
 	
 	newValue, err := sc.Store.DecreBy(key, valueInt)
 	if err != nil {
-		fmt.Println("Error: ", err)
+		if err == store.ErrNoSuchKey {
+			writeError(sc.Out, "ERR no such key")
+		} else {
+			writeError(sc.Out, err.Error())
+		}
 		return
 	}
 	
-	fmt.Printf(":%d\r\n", newValue)
+	fmt.Fprintf(sc.Out, ":%d\r\n", newValue)
 }
 
 // DecreByCommandMeta provides metadata for the DECRBY command
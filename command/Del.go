@@ -2,8 +2,10 @@ package command
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
 	"github.com/shubhdevelop/YAKVS/store"
 )
 
@@ -11,20 +13,22 @@ import (
 type DelCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewDelCommand creates a new DEL command instance
-func NewDelCommand(cmd *parser.Command, store *store.Store) *DelCommand {
+func NewDelCommand(cmd *parser.Command, store *store.Store, out io.Writer) *DelCommand {
 	return &DelCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the DEL command
 func (dc *DelCommand) Execute() {
 	if len(dc.Command.Args) < 1 {
-		fmt.Println("Error: DEL requires 1 argument (key)")
+		writeArityError(dc.Out, dc.Command)
 		return
 	}
 
@@ -32,16 +36,16 @@ func (dc *DelCommand) Execute() {
 	
 	// Check if key exists before attempting to delete
 	if !dc.Store.Exists(key) {
-		fmt.Println("$-1\r")
+		resp.WriterFor(dc.Out).WriteNull()
 		return
 	}
 	
 	// Actually delete the key
 	deleted := dc.Store.DeleteValue(key)
 	if deleted {
-		fmt.Println("+OK\r")
+		fmt.Fprintln(dc.Out, "+OK\r")
 	} else {
-		fmt.Println("$-1\r")
+		resp.WriterFor(dc.Out).WriteNull()
 	}
 }
 
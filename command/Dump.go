@@ -0,0 +1,69 @@
+package command
+
+import (
+	"io"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/snapshot"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// DumpCommand handles the DUMP command
+type DumpCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewDumpCommand creates a new DUMP command instance
+func NewDumpCommand(cmd *parser.Command, store *store.Store, out io.Writer) *DumpCommand {
+	return &DumpCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the DUMP command
+func (dc *DumpCommand) Execute() {
+	w := resp.WriterFor(dc.Out)
+	dump, exists := dc.Store.DumpKey(dc.Command.Args[0])
+	if !exists {
+		w.WriteNull()
+		return
+	}
+	w.WriteBulkString(snapshot.EncodeValue(dump))
+}
+
+// DumpCommandMeta provides metadata for the DUMP command
+type DumpCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// DumpMeta returns the command metadata
+func DumpMeta() *DumpCommandMeta {
+	return &DumpCommandMeta{
+		Name:      "DUMP",
+		Syntax:    "DUMP key",
+		HelpShort: "DUMP serializes the value stored at key",
+		HelpLong: `
+DUMP serializes the value stored at key, without its TTL, so RESTORE can
+recreate it, possibly on another server.
+
+The payload ends with the version of its format and a CRC64 checksum, RESTORE
+refuses a payload that doesn't match them. The command returns a null reply
+if the key does not exist.
+		`,
+		Examples: `
+>> RPUSH list a b
+:2
+>> DUMP list
+"*3\r\n$4\r\nlist\r\n$1\r\na\r\n$1\r\nb\r\n\x01\x00..."
+		`,
+	}
+}
@@ -0,0 +1,26 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+// writeError replies with an error, msg starting with its code like ERR
+func writeError(out io.Writer, msg string) {
+	resp.WriterFor(out).WriteError(msg)
+}
+
+// writeArityError replies to a command given a wrong number of arguments
+func writeArityError(out io.Writer, cmd *parser.Command) {
+	writeError(out, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name)))
+}
+
+// writeSubcommandError replies to a command given an unknown subcommand, or
+// a subcommand given a wrong number of arguments
+func writeSubcommandError(out io.Writer, cmd *parser.Command) {
+	writeError(out, fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try %s HELP.", strings.ToLower(cmd.Args[0]), strings.ToUpper(cmd.Name)))
+}
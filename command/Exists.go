@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/shubhdevelop/YAKVS/parser"
 	"github.com/shubhdevelop/YAKVS/store"
@@ -11,20 +12,22 @@ import (
 type ExistsCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewGetCommand creates a new GET command instance
-func NewExistsCommand(cmd *parser.Command, store *store.Store) *ExistsCommand {
+func NewExistsCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ExistsCommand {
 	return &ExistsCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the GET command
 func (gc *ExistsCommand) Execute() {
 	if len(gc.Command.Args) < 1 {
-		fmt.Println("Error: EXISTS requires 1 argument (key)")
+		writeArityError(gc.Out, gc.Command)
 		return
 	}
 
@@ -32,9 +35,9 @@ func (gc *ExistsCommand) Execute() {
 	value := gc.Store.Exists(key)
 	
 	if value {
-		fmt.Println(":1\r")
+		fmt.Fprintln(gc.Out, ":1\r")
 	} else {
-		fmt.Println(":0\r")
+		fmt.Fprintln(gc.Out, ":0\r")
 	}
 }
 
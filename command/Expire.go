@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 	"time"
 
@@ -13,36 +14,38 @@ import (
 type ExpireCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewGetCommand creates a new GET command instance
-func NewExpireCommand(cmd *parser.Command, store *store.Store) *ExpireCommand {
+func NewExpireCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ExpireCommand {
 	return &ExpireCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the GET command
 func (gc *ExpireCommand) Execute() {
 	if len(gc.Command.Args) < 1 {
-		fmt.Println("Error: EXPIRE requires 2 arguments (key, ttl)")
+		writeArityError(gc.Out, gc.Command)
 		return
 	}
 
 	key := gc.Command.Args[0]
 	ttl, err := strconv.ParseInt(gc.Command.Args[1], 10, 64)
 	if err != nil {
-		fmt.Println("Error parsing TTL:", err)
+		writeError(gc.Out, "ERR value is not an integer or out of range")
 		return
 	}
 	ttl = time.Now().Unix() + ttl
 	value := gc.Store.SetTTL(key, ttl) 
 	
 	if value {
-		fmt.Println("+OK\r")
+		fmt.Fprintln(gc.Out, "+OK\r")
 	} else {
-		fmt.Println(":0\r") // we expect the key to be set successfully
+		fmt.Fprintln(gc.Out, ":0\r") // we expect the key to be set successfully
 	}
 }
 
@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/shubhdevelop/YAKVS/parser"
@@ -12,35 +13,37 @@ import (
 type ExpireAtCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewGetCommand creates a new GET command instance
-func NewExpireAtCommand(cmd *parser.Command, store *store.Store) *ExpireAtCommand {
+func NewExpireAtCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ExpireAtCommand {
 	return &ExpireAtCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the GET command
 func (gc *ExpireAtCommand) Execute() {
 	if len(gc.Command.Args) < 1 {
-		fmt.Println("Error: EXPIREAT requires 1 argument (key)")
+		writeArityError(gc.Out, gc.Command)
 		return
 	}
 
 	key := gc.Command.Args[0]
 	ttl, err := strconv.ParseInt(gc.Command.Args[1], 10, 64)
 	if err != nil {
-		fmt.Println("Error parsing TTL:", err)
+		writeError(gc.Out, "ERR value is not an integer or out of range")
 		return
 	}
 	value := gc.Store.SetTTL(key, ttl) 
 	
 	if value {
-		fmt.Println("+OK\r")
+		fmt.Fprintln(gc.Out, "+OK\r")
 	} else {
-		fmt.Println(":0\r") // we expect the key to be set successfully
+		fmt.Fprintln(gc.Out, ":0\r") // we expect the key to be set successfully
 	}
 }
 
@@ -2,8 +2,10 @@ package command
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
 	"github.com/shubhdevelop/YAKVS/store"
 )
 
@@ -11,20 +13,22 @@ import (
 type GetCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewGetCommand creates a new GET command instance
-func NewGetCommand(cmd *parser.Command, store *store.Store) *GetCommand {
+func NewGetCommand(cmd *parser.Command, store *store.Store, out io.Writer) *GetCommand {
 	return &GetCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the GET command
 func (gc *GetCommand) Execute() {
 	if len(gc.Command.Args) < 1 {
-		fmt.Println("Error: GET requires 1 argument (key)")
+		writeArityError(gc.Out, gc.Command)
 		return
 	}
 
@@ -32,10 +36,14 @@ func (gc *GetCommand) Execute() {
 	value := gc.Store.GetValue(key)
 	
 	if value == nil {
-		fmt.Println("$-1\r")
+		if t := gc.Store.Type(key); t != "none" && t != "string" {
+			fmt.Fprintf(gc.Out, "-%v\r\n", store.ErrWrongType)
+			return
+		}
+		resp.WriterFor(gc.Out).WriteNull()
 	} else {
 		valueStr := fmt.Sprintf("%v", value)
-		fmt.Printf("$%d\r\n%s\r\n", len(valueStr), valueStr)
+		fmt.Fprintf(gc.Out, "$%d\r\n%s\r\n", len(valueStr), valueStr)
 	}
 }
 
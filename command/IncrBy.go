@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/shubhdevelop/YAKVS/parser"
@@ -12,20 +13,22 @@ import (
 type IncreByCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewIncreByCommand creates a new INCRBY command instance
-func NewIncreByCommand(cmd *parser.Command, store *store.Store) *IncreByCommand {
+func NewIncreByCommand(cmd *parser.Command, store *store.Store, out io.Writer) *IncreByCommand {
 	return &IncreByCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the INCRBY command
 func (sc *IncreByCommand) Execute() {
 	if len(sc.Command.Args) < 2 {
-		fmt.Println("Error: INCRBY requires 2 arguments (key, value)")
+		writeArityError(sc.Out, sc.Command)
 		return
 	}	
 
@@ -35,16 +38,20 @@ func (sc *IncreByCommand) Execute() {
 	//change the value to string 
 	valueInt, err := strconv.Atoi(value)
 	if err != nil {
-		fmt.Println("Error: INCRBY requires a valid integer value")
+		writeError(sc.Out, "ERR value is not an integer or out of range")
 		return
 	}
 	
 	newValue, err := sc.Store.IncreBy(key, valueInt)
 	if err != nil {
-		fmt.Println("Error: ", err)
+		if err == store.ErrNoSuchKey {
+			writeError(sc.Out, "ERR no such key")
+		} else {
+			writeError(sc.Out, err.Error())
+		}
 		return
 	}
-	fmt.Printf(":%d\r\n", newValue)
+	fmt.Fprintf(sc.Out, ":%d\r\n", newValue)
 }
 
 // IncreByCommandMeta provides metadata for the INCRBY command
@@ -0,0 +1,67 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// LlenCommand handles the LLEN command
+type LlenCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewLlenCommand creates a new LLEN command instance
+func NewLlenCommand(cmd *parser.Command, store *store.Store, out io.Writer) *LlenCommand {
+	return &LlenCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the LLEN command
+func (lc *LlenCommand) Execute() {
+	if len(lc.Command.Args) != 1 {
+		writeArityError(lc.Out, lc.Command)
+		return
+	}
+
+	length, err := lc.Store.ListLen(lc.Command.Args[0])
+	if err != nil {
+		fmt.Fprintf(lc.Out, "-%v\r\n", err)
+		return
+	}
+	fmt.Fprintf(lc.Out, ":%d\r\n", length)
+}
+
+// LlenCommandMeta provides metadata for the LLEN command
+type LlenCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// LlenMeta returns the command metadata
+func LlenMeta() *LlenCommandMeta {
+	return &LlenCommandMeta{
+		Name:      "LLEN",
+		Syntax:    "LLEN key",
+		HelpShort: "LLEN returns the length of a list",
+		HelpLong: `
+LLEN returns the length of the list stored at key, 0 if the key doesn't exist.
+		`,
+		Examples: `
+>> RPUSH jobs a b
+:2
+>> LLEN jobs
+:2
+		`,
+	}
+}
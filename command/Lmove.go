@@ -0,0 +1,96 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// LmoveCommand handles the LMOVE command
+type LmoveCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewLmoveCommand creates a new LMOVE command instance
+func NewLmoveCommand(cmd *parser.Command, store *store.Store, out io.Writer) *LmoveCommand {
+	return &LmoveCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// ParseListEnd parses the LEFT or RIGHT argument of LMOVE and BLMOVE
+func ParseListEnd(arg string) (int, bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return store.LIST_HEAD, true
+	case "RIGHT":
+		return store.LIST_TAIL, true
+	}
+	return 0, false
+}
+
+// Execute executes the LMOVE command
+func (lc *LmoveCommand) Execute() {
+	args := lc.Command.Args
+	if len(args) != 4 {
+		writeArityError(lc.Out, lc.Command)
+		return
+	}
+
+	from, ok1 := ParseListEnd(args[2])
+	to, ok2 := ParseListEnd(args[3])
+	if !ok1 || !ok2 {
+		writeError(lc.Out, "ERR syntax error")
+		return
+	}
+	value, moved, err := lc.Store.ListMove(args[0], args[1], from, to)
+	if err != nil {
+		fmt.Fprintf(lc.Out, "-%v\r\n", err)
+		return
+	}
+	if !moved {
+		resp.WriterFor(lc.Out).WriteNull()
+		return
+	}
+	fmt.Fprintf(lc.Out, "$%d\r\n%s\r\n", len(value), value)
+}
+
+// LmoveCommandMeta provides metadata for the LMOVE command
+type LmoveCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// LmoveMeta returns the command metadata
+func LmoveMeta() *LmoveCommandMeta {
+	return &LmoveCommandMeta{
+		Name:      "LMOVE",
+		Syntax:    "LMOVE source destination LEFT|RIGHT LEFT|RIGHT",
+		HelpShort: "LMOVE moves an element from a list to another",
+		HelpLong: `
+LMOVE atomically pops an element from the head (LEFT) or the tail (RIGHT) of
+the source list and pushes it at the head or the tail of the destination
+list. Source and destination may be the same list, which rotates it.
+
+The command returns the element moved, or nil if the source doesn't exist.
+		`,
+		Examples: `
+>> RPUSH pending a b
+:2
+>> LMOVE pending processing LEFT RIGHT
+$1
+a
+		`,
+	}
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// LrangeCommand handles the LRANGE command
+type LrangeCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewLrangeCommand creates a new LRANGE command instance
+func NewLrangeCommand(cmd *parser.Command, store *store.Store, out io.Writer) *LrangeCommand {
+	return &LrangeCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the LRANGE command
+func (lc *LrangeCommand) Execute() {
+	if len(lc.Command.Args) != 3 {
+		writeArityError(lc.Out, lc.Command)
+		return
+	}
+
+	start, err1 := strconv.Atoi(lc.Command.Args[1])
+	stop, err2 := strconv.Atoi(lc.Command.Args[2])
+	if err1 != nil || err2 != nil {
+		writeError(lc.Out, "ERR value is not an integer or out of range")
+		return
+	}
+	values, err := lc.Store.ListRange(lc.Command.Args[0], start, stop)
+	if err != nil {
+		fmt.Fprintf(lc.Out, "-%v\r\n", err)
+		return
+	}
+	fmt.Fprintf(lc.Out, "*%d\r\n", len(values))
+	for _, value := range values {
+		fmt.Fprintf(lc.Out, "$%d\r\n%s\r\n", len(value), value)
+	}
+}
+
+// LrangeCommandMeta provides metadata for the LRANGE command
+type LrangeCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// LrangeMeta returns the command metadata
+func LrangeMeta() *LrangeCommandMeta {
+	return &LrangeCommandMeta{
+		Name:      "LRANGE",
+		Syntax:    "LRANGE key start stop",
+		HelpShort: "LRANGE returns a range of elements of a list",
+		HelpLong: `
+LRANGE returns the elements of the list stored at key from index start to
+index stop, both included. Indexes start at 0, negative ones count from the
+tail: -1 is the last element.
+		`,
+		Examples: `
+>> RPUSH jobs a b c
+:3
+>> LRANGE jobs 0 -2
+*2
+a
+b
+		`,
+	}
+}
@@ -0,0 +1,194 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+// MemoryCommand handles the MEMORY command
+type MemoryCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewMemoryCommand creates a new MEMORY command instance
+func NewMemoryCommand(cmd *parser.Command, store *store.Store, out io.Writer) *MemoryCommand {
+	return &MemoryCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the MEMORY command
+func (mc *MemoryCommand) Execute() {
+	if len(mc.Command.Args) < 1 {
+		writeArityError(mc.Out, mc.Command)
+		return
+	}
+
+	switch strings.ToUpper(mc.Command.Args[0]) {
+	case "HELP":
+		printHelp(mc.Out, memoryHelp)
+	case "USAGE":
+		mc.usage()
+	case "STATS":
+		mc.stats()
+	case "DOCTOR":
+		resp.WriterFor(mc.Out).WriteVerbatim("txt", mc.doctor())
+	default:
+		writeSubcommandError(mc.Out, mc.Command)
+	}
+}
+
+func (mc *MemoryCommand) usage() {
+	args := mc.Command.Args[1:]
+	if len(args) != 1 && len(args) != 3 {
+		writeSubcommandError(mc.Out, mc.Command)
+		return
+	}
+	if len(args) == 3 {
+		// values are not nested yet, so SAMPLES is validated but has no effect
+		if strings.ToUpper(args[1]) != "SAMPLES" {
+			writeError(mc.Out, "ERR syntax error")
+			return
+		}
+		if samples, err := strconv.Atoi(args[2]); err != nil || samples < 0 {
+			writeError(mc.Out, "ERR value is not an integer or out of range")
+			return
+		}
+	}
+
+	usage, err := mc.Store.MemoryUsage(args[0])
+	printIntOrNil(mc.Out, usage, err)
+}
+
+func (mc *MemoryCommand) stats() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	keys := int64(mc.Store.KeyCount())
+	dataset := mc.Store.UsedMemory()
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = dataset / keys
+	}
+	datasetPercentage := int64(0)
+	if ms.HeapAlloc > 0 {
+		datasetPercentage = dataset * 100 / int64(ms.HeapAlloc)
+	}
+
+	stats := []struct {
+		name  string
+		value int64
+	}{
+		{"peak.allocated", mc.Store.PeakMemory()},
+		{"total.allocated", int64(ms.HeapAlloc)},
+		{"dataset.bytes", dataset},
+		{"dataset.percentage", datasetPercentage},
+		{"keys.count", keys},
+		{"keys.bytes-per-key", bytesPerKey},
+		{"expires.count", int64(mc.Store.ExpiresCount())},
+		{"maxmemory", mc.Store.GetMaxMemory()},
+		{"evicted.keys", mc.Store.EvictedKeys()},
+	}
+
+	w := resp.WriterFor(mc.Out)
+	w.WriteMapLen(len(stats))
+	for _, stat := range stats {
+		w.WriteBulkString(stat.name)
+		w.WriteInteger(stat.value)
+	}
+}
+
+// doctor looks at the memory accounting and reports anything unusual
+func (mc *MemoryCommand) doctor() string {
+	keys := mc.Store.KeyCount()
+	used := mc.Store.UsedMemory()
+	peak := mc.Store.PeakMemory()
+	maxMemory := mc.Store.GetMaxMemory()
+
+	if keys == 0 {
+		return "This instance is empty or is using very little memory, there is nothing to diagnose."
+	}
+
+	var issues []string
+	if peak > 0 && used > 0 && peak > used*3/2 {
+		issues = append(issues, fmt.Sprintf(
+			" * Peak memory: in the past this instance used more than 150%% of the memory that is currently using (peak %d bytes, now %d bytes). The dataset may have been bigger or keys may have been evicted.", peak, used))
+	}
+	if maxMemory > 0 && used*10 >= maxMemory*9 {
+		issues = append(issues, fmt.Sprintf(
+			" * Near maxmemory: the dataset uses %d of %d bytes with the %s policy. Consider raising maxmemory.", used, maxMemory, mc.Store.GetMaxMemoryPolicy()))
+	}
+	if maxMemory > 0 && mc.Store.GetMaxMemoryPolicy() == "noeviction" && used > maxMemory {
+		issues = append(issues, " * OOM: used memory is over maxmemory and the noeviction policy is set, writes are being refused.")
+	}
+	if evicted := mc.Store.EvictedKeys(); evicted > 0 {
+		issues = append(issues, fmt.Sprintf(
+			" * Evictions: %d keys were evicted to stay under maxmemory.", evicted))
+	}
+	if bytesPerKey := used / int64(keys); bytesPerKey > 1024*1024 {
+		issues = append(issues, fmt.Sprintf(
+			" * Big keys: keys use %d bytes on average, look for unusually large values with MEMORY USAGE.", bytesPerKey))
+	}
+
+	if len(issues) == 0 {
+		return "I can't find any memory issue in this instance."
+	}
+	return "I detected the following memory issues:\n\n" + strings.Join(issues, "\n")
+}
+
+// MemoryCommandMeta provides metadata for the MEMORY command
+type MemoryCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// MemoryMeta returns the command metadata
+func MemoryMeta() *MemoryCommandMeta {
+	return &MemoryCommandMeta{
+		Name:      "MEMORY",
+		Syntax:    "MEMORY USAGE key [SAMPLES count] | MEMORY STATS | MEMORY DOCTOR | MEMORY HELP",
+		HelpShort: "MEMORY reports how much memory keys and the dataset use",
+		HelpLong: `
+MEMORY reports how much memory keys and the dataset use.
+
+USAGE returns the bytes used by a key, its value and its expire entry.
+STATS returns the dataset accounting used by maxmemory as name/value pairs.
+DOCTOR returns a human readable report of possible memory problems.
+
+MEMORY USAGE returns $-1 if the key does not exist.
+		`,
+		Examples: `
+>> SET k1 v1
++OK
+>> MEMORY USAGE k1
+:108
+		`,
+	}
+}
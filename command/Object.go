@@ -0,0 +1,141 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// ObjectCommand handles the OBJECT command
+type ObjectCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewObjectCommand creates a new OBJECT command instance
+func NewObjectCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ObjectCommand {
+	return &ObjectCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the OBJECT command
+func (oc *ObjectCommand) Execute() {
+	if len(oc.Command.Args) < 1 {
+		writeArityError(oc.Out, oc.Command)
+		return
+	}
+
+	subcommand := strings.ToUpper(oc.Command.Args[0])
+	if subcommand == "HELP" {
+		printHelp(oc.Out, objectHelp)
+		return
+	}
+	if len(oc.Command.Args) != 2 {
+		writeSubcommandError(oc.Out, oc.Command)
+		return
+	}
+
+	key := oc.Command.Args[1]
+	switch subcommand {
+	case "ENCODING":
+		encoding, err := oc.Store.ObjectEncoding(key)
+		if err != nil {
+			resp.WriterFor(oc.Out).WriteNull()
+			return
+		}
+		fmt.Fprintf(oc.Out, "$%d\r\n%s\r\n", len(encoding), encoding)
+	case "REFCOUNT":
+		refcount, err := oc.Store.ObjectRefCount(key)
+		printIntOrNil(oc.Out, int64(refcount), err)
+	case "IDLETIME":
+		idle, err := oc.Store.ObjectIdleTime(key)
+		printIntOrNil(oc.Out, idle, err)
+	case "FREQ":
+		freq, err := oc.Store.ObjectFreq(key)
+		printIntOrNil(oc.Out, int64(freq), err)
+	default:
+		writeSubcommandError(oc.Out, oc.Command)
+	}
+}
+
+// printIntOrNil prints an integer reply, a nil reply for missing keys or the error
+func printIntOrNil(out io.Writer, value int64, err error) {
+	if err == store.ErrNoSuchKey {
+		resp.WriterFor(out).WriteNull()
+	} else if err != nil {
+		writeError(out, "ERR "+err.Error())
+	} else {
+		fmt.Fprintf(out, ":%d\r\n", value)
+	}
+}
+
+// printHelp prints the help lines as an array of simple strings
+func printHelp(out io.Writer, lines []string) {
+	fmt.Fprintf(out, "*%d\r\n", len(lines))
+	for _, line := range lines {
+		fmt.Fprintf(out, "+%s\r\n", line)
+	}
+}
+
+// ObjectCommandMeta provides metadata for the OBJECT command
+type ObjectCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// ObjectMeta returns the command metadata
+func ObjectMeta() *ObjectCommandMeta {
+	return &ObjectCommandMeta{
+		Name:      "OBJECT",
+		Syntax:    "OBJECT ENCODING|REFCOUNT|IDLETIME|FREQ key | OBJECT HELP",
+		HelpShort: "OBJECT inspects the internal representation of the value stored at key",
+		HelpLong: `
+OBJECT inspects the internal representation of the value stored at key.
+
+ENCODING returns int or raw, REFCOUNT the number of references to the value,
+IDLETIME the seconds since the key was last accessed (not available under an
+LFU policy) and FREQ the logarithmic access counter (only under an LFU policy).
+Accessing a key with OBJECT doesn't change its idle time or frequency.
+
+The command returns $-1 if the key does not exist.
+		`,
+		Examples: `
+>> SET k1 100
++OK
+>> OBJECT ENCODING k1
+$3
+int
+>> OBJECT IDLETIME k1
+:12
+		`,
+	}
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/shubhdevelop/YAKVS/parser"
 	"github.com/shubhdevelop/YAKVS/store"
@@ -11,20 +12,22 @@ import (
 type PersistCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewPersistCommand creates a new PERSIST command instance
-func NewPersistCommand(cmd *parser.Command, store *store.Store) *PersistCommand {
+func NewPersistCommand(cmd *parser.Command, store *store.Store, out io.Writer) *PersistCommand {
 	return &PersistCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the PERSIST command
 func (gc *PersistCommand) Execute() {
 	if len(gc.Command.Args) < 1 {
-		fmt.Println("Error: PERSIST requires 1 argument (key)")
+		writeArityError(gc.Out, gc.Command)
 		return
 	}
 
@@ -32,9 +35,9 @@ func (gc *PersistCommand) Execute() {
 	value := gc.Store.RemoveExpiry(key)
 	
 	if value {
-		fmt.Println("+OK\r")
+		fmt.Fprintln(gc.Out, "+OK\r")
 	} else {
-		fmt.Println(":0\r") // we expect the key to be set successfully
+		fmt.Fprintln(gc.Out, ":0\r") // we expect the key to be set successfully
 	}
 }
 
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// PopCommand handles the LPOP and RPOP commands
+type PopCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewPopCommand creates a new LPOP command instance
+func NewPopCommand(cmd *parser.Command, store *store.Store, out io.Writer) *PopCommand {
+	return &PopCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the LPOP command
+func (pc *PopCommand) Execute() {
+	args := pc.Command.Args
+	if len(args) < 1 || len(args) > 2 {
+		writeArityError(pc.Out, pc.Command)
+		return
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			writeError(pc.Out, "ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+
+	where := store.LIST_HEAD
+	if strings.ToUpper(pc.Command.Name) == "RPOP" {
+		where = store.LIST_TAIL
+	}
+	values, err := pc.Store.Pop(args[0], count, where)
+	if err != nil {
+		fmt.Fprintf(pc.Out, "-%v\r\n", err)
+		return
+	}
+
+	// without a count the reply is the element itself
+	if len(args) == 1 {
+		if values == nil {
+			resp.WriterFor(pc.Out).WriteNull()
+		} else {
+			fmt.Fprintf(pc.Out, "$%d\r\n%s\r\n", len(values[0]), values[0])
+		}
+		return
+	}
+	if values == nil {
+		resp.WriterFor(pc.Out).WriteNullArray()
+		return
+	}
+	fmt.Fprintf(pc.Out, "*%d\r\n", len(values))
+	for _, value := range values {
+		fmt.Fprintf(pc.Out, "$%d\r\n%s\r\n", len(value), value)
+	}
+}
+
+// PopCommandMeta provides metadata for the LPOP command
+type PopCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// PopMeta returns the command metadata
+func PopMeta() *PopCommandMeta {
+	return &PopCommandMeta{
+		Name:      "LPOP",
+		Syntax:    "LPOP key [count] | RPOP key [count]",
+		HelpShort: "LPOP removes and returns the first elements of a list, RPOP the last ones",
+		HelpLong: `
+LPOP removes and returns the first element of the list stored at key, RPOP
+the last one. The key is deleted with the last element of the list.
+
+Without a count the command returns the element, or nil if there is no key.
+With a count it returns an array of up to count elements, or a nil array if
+there is no key.
+		`,
+		Examples: `
+>> RPUSH jobs a b c
+:3
+>> LPOP jobs
+$1
+a
+>> RPOP jobs 5
+*2
+c
+b
+>> LPOP jobs
+$-1
+		`,
+	}
+}
@@ -0,0 +1,74 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/pubsub"
+)
+
+// PublishCommand handles the PUBLISH and SPUBLISH commands
+type PublishCommand struct {
+	Command *parser.Command
+	PubSub  *pubsub.PubSub
+	Out     io.Writer
+}
+
+// NewPublishCommand creates a new PUBLISH command instance
+func NewPublishCommand(cmd *parser.Command, ps *pubsub.PubSub, out io.Writer) *PublishCommand {
+	return &PublishCommand{
+		Command: cmd,
+		PubSub:  ps,
+		Out:     out,
+	}
+}
+
+// Execute executes the PUBLISH command
+func (pc *PublishCommand) Execute() {
+	if len(pc.Command.Args) != 2 {
+		writeArityError(pc.Out, pc.Command)
+		return
+	}
+
+	channel := pc.Command.Args[0]
+	message := pc.Command.Args[1]
+
+	var receivers int
+	if strings.ToUpper(pc.Command.Name) == "SPUBLISH" {
+		receivers = pc.PubSub.SPublish(channel, message)
+	} else {
+		receivers = pc.PubSub.Publish(channel, message)
+	}
+	fmt.Fprintf(pc.Out, ":%d\r\n", receivers)
+}
+
+// PublishCommandMeta provides metadata for the PUBLISH command
+type PublishCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// PublishMeta returns the command metadata
+func PublishMeta() *PublishCommandMeta {
+	return &PublishCommandMeta{
+		Name:      "PUBLISH",
+		Syntax:    "PUBLISH channel message | SPUBLISH shardchannel message",
+		HelpShort: "PUBLISH posts a message to a channel",
+		HelpLong: `
+PUBLISH posts a message to the clients subscribed to the channel, and to the
+clients subscribed to a pattern matching it. SPUBLISH posts to the clients
+subscribed to the shard channel with SSUBSCRIBE.
+
+The command returns the number of clients that received the message.
+		`,
+		Examples: `
+>> PUBLISH news hello
+:2
+		`,
+	}
+}
@@ -0,0 +1,134 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/pubsub"
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+var pubsubHelp = []string{
+	"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CHANNELS [<pattern>]",
+	"    Return the currently active channels matching a <pattern> (default: '*').",
+	"NUMPAT",
+	"    Return number of subscriptions to patterns.",
+	"NUMSUB [<channel> ...]",
+	"    Return the number of subscribers for the specified channels, excluding",
+	"    pattern subscriptions(default: no channels).",
+	"SHARDCHANNELS [<pattern>]",
+	"    Return the currently active shard level channels matching a <pattern> (default: '*').",
+	"SHARDNUMSUB [<shardchannel> ...]",
+	"    Return the number of subscribers for the specified shard level channel(s)",
+	"HELP",
+	"    Print this help.",
+}
+
+// PubsubCommand handles the PUBSUB command
+type PubsubCommand struct {
+	Command *parser.Command
+	PubSub  *pubsub.PubSub
+	Out     io.Writer
+}
+
+// NewPubsubCommand creates a new PUBSUB command instance
+func NewPubsubCommand(cmd *parser.Command, ps *pubsub.PubSub, out io.Writer) *PubsubCommand {
+	return &PubsubCommand{
+		Command: cmd,
+		PubSub:  ps,
+		Out:     out,
+	}
+}
+
+// Execute executes the PUBSUB command
+func (pc *PubsubCommand) Execute() {
+	if len(pc.Command.Args) < 1 {
+		writeArityError(pc.Out, pc.Command)
+		return
+	}
+
+	subcommand := strings.ToUpper(pc.Command.Args[0])
+	args := pc.Command.Args[1:]
+	switch subcommand {
+	case "HELP":
+		printHelp(pc.Out, pubsubHelp)
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) > 1 {
+			writeSubcommandError(pc.Out, pc.Command)
+			return
+		}
+		pattern := ""
+		if len(args) == 1 {
+			pattern = args[0]
+		}
+		var channels []string
+		if subcommand == "CHANNELS" {
+			channels = pc.PubSub.Channels(pattern)
+		} else {
+			channels = pc.PubSub.ShardChannels(pattern)
+		}
+		fmt.Fprintf(pc.Out, "*%d\r\n", len(channels))
+		for _, channel := range channels {
+			fmt.Fprintf(pc.Out, "$%d\r\n%s\r\n", len(channel), channel)
+		}
+	case "NUMSUB", "SHARDNUMSUB":
+		// a map of channel to count, a flat array of pairs for RESP2
+		w := resp.WriterFor(pc.Out)
+		w.WriteMapLen(len(args))
+		for _, channel := range args {
+			count := 0
+			if subcommand == "NUMSUB" {
+				count = pc.PubSub.NumSub(channel)
+			} else {
+				count = pc.PubSub.ShardNumSub(channel)
+			}
+			w.WriteBulkString(channel)
+			w.WriteInteger(int64(count))
+		}
+	case "NUMPAT":
+		fmt.Fprintf(pc.Out, ":%d\r\n", pc.PubSub.NumPat())
+	default:
+		writeSubcommandError(pc.Out, pc.Command)
+	}
+}
+
+// PubsubCommandMeta provides metadata for the PUBSUB command
+type PubsubCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// PubsubMeta returns the command metadata
+func PubsubMeta() *PubsubCommandMeta {
+	return &PubsubCommandMeta{
+		Name:      "PUBSUB",
+		Syntax:    "PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT | SHARDCHANNELS [pattern] | SHARDNUMSUB [shardchannel ...] | HELP",
+		HelpShort: "PUBSUB inspects the state of the Pub/Sub subsystem",
+		HelpLong: `
+PUBSUB inspects the state of the Pub/Sub subsystem.
+
+CHANNELS lists the channels with at least one subscriber, optionally only
+the ones matching a glob pattern. NUMSUB returns the number of subscribers of
+each channel, pattern subscribers are not counted. NUMPAT returns the number
+of patterns subscribed to. SHARDCHANNELS and SHARDNUMSUB do the same for the
+shard channels.
+		`,
+		Examples: `
+>> PUBSUB CHANNELS news.*
+*1
+$10
+news.sport
+>> PUBSUB NUMSUB news.sport
+*2
+$10
+news.sport
+:1
+		`,
+	}
+}
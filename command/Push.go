@@ -0,0 +1,82 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// PushCommand handles the LPUSH and RPUSH commands
+type PushCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewPushCommand creates a new LPUSH command instance
+func NewPushCommand(cmd *parser.Command, store *store.Store, out io.Writer) *PushCommand {
+	return &PushCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the LPUSH command
+func (pc *PushCommand) Execute() {
+	if len(pc.Command.Args) < 2 {
+		writeArityError(pc.Out, pc.Command)
+		return
+	}
+
+	where := store.LIST_HEAD
+	if strings.ToUpper(pc.Command.Name) == "RPUSH" {
+		where = store.LIST_TAIL
+	}
+	length, err := pc.Store.Push(pc.Command.Args[0], pc.Command.Args[1:], where)
+	if err != nil {
+		fmt.Fprintf(pc.Out, "-%v\r\n", err)
+		return
+	}
+	fmt.Fprintf(pc.Out, ":%d\r\n", length)
+}
+
+// PushCommandMeta provides metadata for the LPUSH command
+type PushCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// PushMeta returns the command metadata
+func PushMeta() *PushCommandMeta {
+	return &PushCommandMeta{
+		Name:      "LPUSH",
+		Syntax:    "LPUSH key element [element ...] | RPUSH key element [element ...]",
+		HelpShort: "LPUSH inserts elements at the head of a list, RPUSH at its tail",
+		HelpLong: `
+LPUSH inserts the elements at the head of the list stored at key, one after
+the other, so the last one ends up first. RPUSH inserts them at the tail. The
+list is created if the key doesn't exist.
+
+The command returns the length of the list after the push. Clients blocked on
+the key by BLPOP, BRPOP or BLMOVE are served right after.
+		`,
+		Examples: `
+>> RPUSH jobs a b
+:2
+>> LPUSH jobs c
+:3
+>> LRANGE jobs 0 -1
+*3
+c
+a
+b
+		`,
+	}
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/snapshot"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// RestoreCommand handles the RESTORE command, and RESTORE-ASKING which
+// MIGRATE sends to a cluster node importing the slot of the key
+type RestoreCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewRestoreCommand creates a new RESTORE command instance
+func NewRestoreCommand(cmd *parser.Command, store *store.Store, out io.Writer) *RestoreCommand {
+	return &RestoreCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the RESTORE command
+func (rc *RestoreCommand) Execute() {
+	args := rc.Command.Args
+	replace, absTTL := false, false
+	for _, option := range args[3:] {
+		switch strings.ToUpper(option) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			writeError(rc.Out, "ERR syntax error")
+			return
+		}
+	}
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		writeError(rc.Out, "ERR value is not an integer or out of range")
+		return
+	}
+	if ttl < 0 {
+		writeError(rc.Out, "ERR Invalid TTL value, must be >= 0")
+		return
+	}
+
+	dump, err := snapshot.DecodeValue(args[0], args[2])
+	if err != nil {
+		fmt.Fprintf(rc.Out, "-%v\r\n", err)
+		return
+	}
+	// the TTL is in milliseconds, expires have a resolution of a second
+	if ttl > 0 {
+		if !absTTL {
+			ttl += time.Now().UnixMilli()
+		}
+		dump.ExpireAt = (ttl + 999) / 1000
+	}
+	if err := rc.Store.Restore(dump, replace); err != nil {
+		fmt.Fprintf(rc.Out, "-%v\r\n", err)
+		return
+	}
+	fmt.Fprint(rc.Out, "+OK\r\n")
+}
+
+// RestoreCommandMeta provides metadata for the RESTORE command
+type RestoreCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// RestoreMeta returns the command metadata
+func RestoreMeta() *RestoreCommandMeta {
+	return &RestoreCommandMeta{
+		Name:      "RESTORE",
+		Syntax:    "RESTORE key ttl serialized-value [REPLACE] [ABSTTL]",
+		HelpShort: "RESTORE creates a key from a value serialized with DUMP",
+		HelpLong: `
+RESTORE creates a key from a value serialized with DUMP. The key expires after
+ttl milliseconds, or at the unix time ttl in milliseconds with ABSTTL, a ttl
+of 0 means the key doesn't expire.
+
+Without REPLACE the command fails with BUSYKEY if the key exists.
+
+The command returns OK on success.
+		`,
+		Examples: `
+>> RPUSH list a b
+:2
+>> DUMP list
+"*3\r\n$4\r\nlist\r\n$1\r\na\r\n$1\r\nb\r\n\x01\x00..."
+>> RESTORE copy 0 "*3\r\n$4\r\nlist\r\n$1\r\na\r\n$1\r\nb\r\n\x01\x00..."
++OK
+>> RESTORE copy 0 "*3\r\n$4\r\nlist\r\n$1\r\na\r\n$1\r\nb\r\n\x01\x00..."
+-BUSYKEY Target key name already exists.
+		`,
+	}
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/shubhdevelop/YAKVS/parser"
 	"github.com/shubhdevelop/YAKVS/store"
@@ -11,20 +12,22 @@ import (
 type SetCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewSetCommand creates a new SET command instance
-func NewSetCommand(cmd *parser.Command, store *store.Store) *SetCommand {
+func NewSetCommand(cmd *parser.Command, store *store.Store, out io.Writer) *SetCommand {
 	return &SetCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the SET command
 func (sc *SetCommand) Execute() {
 	if len(sc.Command.Args) < 2 {
-		fmt.Println("Error: SET requires 2 arguments (key, value)")
+		writeArityError(sc.Out, sc.Command)
 		return
 	}	
 
@@ -32,7 +35,7 @@ func (sc *SetCommand) Execute() {
 	value := sc.Command.Args[1]
 	
 	sc.Store.SetValue(key, value)
-	fmt.Println("+OK\r")
+	fmt.Fprintln(sc.Out, "+OK\r")
 }
 
 // SetCommandMeta provides metadata for the SET command
@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/shubhdevelop/YAKVS/parser"
 	"github.com/shubhdevelop/YAKVS/store"
@@ -11,20 +12,22 @@ import (
 type TtlCommand struct {
 	Command *parser.Command
 	Store   *store.Store
+	Out     io.Writer
 }
 
 // NewGetCommand creates a new GET command instance
-func NewTtlCommand(cmd *parser.Command, store *store.Store) *TtlCommand {
+func NewTtlCommand(cmd *parser.Command, store *store.Store, out io.Writer) *TtlCommand {
 	return &TtlCommand{
 		Command: cmd,
 		Store:   store,
+		Out:     out,
 	}
 }
 
 // Execute executes the GET command
 func (gc *TtlCommand) Execute() {
 	if len(gc.Command.Args) < 1 {
-		fmt.Println("Error: TTL requires 1 argument (key)")
+		writeArityError(gc.Out, gc.Command)
 		return
 	}
 
@@ -32,9 +35,9 @@ func (gc *TtlCommand) Execute() {
 	value := gc.Store.GetTTL(key)
 	
 	if value == -2 {
-		fmt.Println(":-2\r")
+		fmt.Fprintln(gc.Out, ":-2\r")
 	} else {
-		fmt.Printf(":%d\r\n", value)
+		fmt.Fprintf(gc.Out, ":%d\r\n", value)
 	}
 }
 
@@ -0,0 +1,64 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// TypeCommand handles the TYPE command
+type TypeCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewTypeCommand creates a new TYPE command instance
+func NewTypeCommand(cmd *parser.Command, store *store.Store, out io.Writer) *TypeCommand {
+	return &TypeCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the TYPE command
+func (tc *TypeCommand) Execute() {
+	if len(tc.Command.Args) != 1 {
+		writeArityError(tc.Out, tc.Command)
+		return
+	}
+	fmt.Fprintf(tc.Out, "+%s\r\n", tc.Store.Type(tc.Command.Args[0]))
+}
+
+// TypeCommandMeta provides metadata for the TYPE command
+type TypeCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// TypeMeta returns the command metadata
+func TypeMeta() *TypeCommandMeta {
+	return &TypeCommandMeta{
+		Name:      "TYPE",
+		Syntax:    "TYPE key",
+		HelpShort: "TYPE returns the type of the value stored at a key",
+		HelpLong: `
+TYPE returns the type of the value stored at key: string, list or zset, or
+none if the key doesn't exist.
+		`,
+		Examples: `
+>> SET k1 v1
+OK
+>> TYPE k1
+string
+>> TYPE k2
+none
+		`,
+	}
+}
@@ -0,0 +1,98 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// ZaddCommand handles the ZADD command
+type ZaddCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewZaddCommand creates a new ZADD command instance
+func NewZaddCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ZaddCommand {
+	return &ZaddCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// ParseScore parses a sorted set score, "inf" and "-inf" included
+func ParseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// Execute executes the ZADD command
+func (zc *ZaddCommand) Execute() {
+	args := zc.Command.Args
+	if len(args) < 3 {
+		writeArityError(zc.Out, zc.Command)
+		return
+	}
+	if len(args[1:])%2 != 0 {
+		writeError(zc.Out, "ERR syntax error")
+		return
+	}
+
+	members := make([]store.ZMember, 0, len(args[1:])/2)
+	for i := 1; i < len(args); i += 2 {
+		score, ok := ParseScore(args[i])
+		if !ok {
+			writeError(zc.Out, "ERR value is not a valid float")
+			return
+		}
+		members = append(members, store.ZMember{Member: args[i+1], Score: score})
+	}
+	added, err := zc.Store.ZAdd(args[0], members)
+	if err != nil {
+		fmt.Fprintf(zc.Out, "-%v\r\n", err)
+		return
+	}
+	fmt.Fprintf(zc.Out, ":%d\r\n", added)
+}
+
+// ZaddCommandMeta provides metadata for the ZADD command
+type ZaddCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// ZaddMeta returns the command metadata
+func ZaddMeta() *ZaddCommandMeta {
+	return &ZaddCommandMeta{
+		Name:      "ZADD",
+		Syntax:    "ZADD key score member [score member ...]",
+		HelpShort: "ZADD adds members with their scores to a sorted set",
+		HelpLong: `
+ZADD adds the members with their scores to the sorted set stored at key, the
+score of a member already in the set is updated. Scores are floating point
+numbers, inf and -inf included. The sorted set is created if the key doesn't
+exist.
+
+The command returns the number of members added, not counting the updated
+ones. Clients blocked on the key by BZPOPMIN are served right after.
+		`,
+		Examples: `
+>> ZADD ranking 10 alice 5 bob
+:2
+>> ZADD ranking 1 alice
+:0
+		`,
+	}
+}
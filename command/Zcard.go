@@ -0,0 +1,68 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// ZcardCommand handles the ZCARD command
+type ZcardCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewZcardCommand creates a new ZCARD command instance
+func NewZcardCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ZcardCommand {
+	return &ZcardCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the ZCARD command
+func (zc *ZcardCommand) Execute() {
+	if len(zc.Command.Args) != 1 {
+		writeArityError(zc.Out, zc.Command)
+		return
+	}
+
+	count, err := zc.Store.ZCard(zc.Command.Args[0])
+	if err != nil {
+		fmt.Fprintf(zc.Out, "-%v\r\n", err)
+		return
+	}
+	fmt.Fprintf(zc.Out, ":%d\r\n", count)
+}
+
+// ZcardCommandMeta provides metadata for the ZCARD command
+type ZcardCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// ZcardMeta returns the command metadata
+func ZcardMeta() *ZcardCommandMeta {
+	return &ZcardCommandMeta{
+		Name:      "ZCARD",
+		Syntax:    "ZCARD key",
+		HelpShort: "ZCARD returns the number of members of a sorted set",
+		HelpLong: `
+ZCARD returns the number of members of the sorted set stored at key, 0 if the
+key doesn't exist.
+		`,
+		Examples: `
+>> ZADD ranking 10 alice 5 bob
+:2
+>> ZCARD ranking
+:2
+		`,
+	}
+}
@@ -0,0 +1,93 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// ZpopminCommand handles the ZPOPMIN command
+type ZpopminCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewZpopminCommand creates a new ZPOPMIN command instance
+func NewZpopminCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ZpopminCommand {
+	return &ZpopminCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the ZPOPMIN command
+func (zc *ZpopminCommand) Execute() {
+	args := zc.Command.Args
+	if len(args) < 1 || len(args) > 2 {
+		writeArityError(zc.Out, zc.Command)
+		return
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			writeError(zc.Out, "ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	members, err := zc.Store.ZPopMin(args[0], count)
+	if err != nil {
+		fmt.Fprintf(zc.Out, "-%v\r\n", err)
+		return
+	}
+
+	// a flat array of member, score pairs
+	w := resp.WriterFor(zc.Out)
+	w.WriteArrayLen(len(members) * 2)
+	for _, m := range members {
+		w.WriteBulkString(m.Member)
+		w.WriteDouble(m.Score)
+	}
+}
+
+// ZpopminCommandMeta provides metadata for the ZPOPMIN command
+type ZpopminCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// ZpopminMeta returns the command metadata
+func ZpopminMeta() *ZpopminCommandMeta {
+	return &ZpopminCommandMeta{
+		Name:      "ZPOPMIN",
+		Syntax:    "ZPOPMIN key [count]",
+		HelpShort: "ZPOPMIN removes and returns the members with the lowest scores",
+		HelpLong: `
+ZPOPMIN removes and returns up to count members (1 by default) with the
+lowest scores from the sorted set stored at key. Members with the same score
+are popped in lexicographical order. The key is deleted with its last member.
+
+The command returns a flat array of member, score pairs, empty if there is
+no key.
+		`,
+		Examples: `
+>> ZADD ranking 10 alice 5 bob
+:2
+>> ZPOPMIN ranking
+*2
+bob
+5
+		`,
+	}
+}
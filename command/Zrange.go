@@ -0,0 +1,104 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// ZrangeCommand handles the ZRANGE command
+type ZrangeCommand struct {
+	Command *parser.Command
+	Store   *store.Store
+	Out     io.Writer
+}
+
+// NewZrangeCommand creates a new ZRANGE command instance
+func NewZrangeCommand(cmd *parser.Command, store *store.Store, out io.Writer) *ZrangeCommand {
+	return &ZrangeCommand{
+		Command: cmd,
+		Store:   store,
+		Out:     out,
+	}
+}
+
+// Execute executes the ZRANGE command
+func (zc *ZrangeCommand) Execute() {
+	args := zc.Command.Args
+	if len(args) < 3 || len(args) > 4 {
+		writeArityError(zc.Out, zc.Command)
+		return
+	}
+
+	withScores := false
+	if len(args) == 4 {
+		if strings.ToUpper(args[3]) != "WITHSCORES" {
+			writeError(zc.Out, "ERR syntax error")
+			return
+		}
+		withScores = true
+	}
+	start, err1 := strconv.Atoi(args[1])
+	stop, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		writeError(zc.Out, "ERR value is not an integer or out of range")
+		return
+	}
+	members, err := zc.Store.ZRange(args[0], start, stop)
+	if err != nil {
+		fmt.Fprintf(zc.Out, "-%v\r\n", err)
+		return
+	}
+
+	w := resp.WriterFor(zc.Out)
+	if withScores {
+		w.WriteArrayLen(len(members) * 2)
+	} else {
+		w.WriteArrayLen(len(members))
+	}
+	for _, m := range members {
+		w.WriteBulkString(m.Member)
+		if withScores {
+			w.WriteDouble(m.Score)
+		}
+	}
+}
+
+// ZrangeCommandMeta provides metadata for the ZRANGE command
+type ZrangeCommandMeta struct {
+	Name      string
+	Syntax    string
+	HelpShort string
+	HelpLong  string
+	Examples  string
+}
+
+// ZrangeMeta returns the command metadata
+func ZrangeMeta() *ZrangeCommandMeta {
+	return &ZrangeCommandMeta{
+		Name:      "ZRANGE",
+		Syntax:    "ZRANGE key start stop [WITHSCORES]",
+		HelpShort: "ZRANGE returns a range of members of a sorted set by rank",
+		HelpLong: `
+ZRANGE returns the members of the sorted set stored at key from rank start
+to rank stop, both included, lowest scores first. Ranks start at 0, negative
+ones count from the highest score: -1 is the last member. WITHSCORES adds the
+score after every member.
+		`,
+		Examples: `
+>> ZADD ranking 10 alice 5 bob
+:2
+>> ZRANGE ranking 0 -1 WITHSCORES
+*4
+bob
+5
+alice
+10
+		`,
+	}
+}
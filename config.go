@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/store"
+	"github.com/shubhdevelop/YAKVS/utils"
+)
+
+/*
+The configuration parameters are the flags of configFlags. They're read from
+the config file given as the first argument, then from the command line,
+which overrides the file:
+
+	yakvs yakvs.conf --port 7000
+
+The file has a directive per line, the name of a parameter and its value,
+like redis.conf. The values may be quoted, the booleans are yes or no, and
+the lines starting with # are comments:
+
+	port 7000
+	maxmemory 100mb
+	replicaof 127.0.0.1 6379
+
+CONFIG GET returns the parameters, CONFIG SET changes the ones with a setter
+in configParams at runtime, and CONFIG REWRITE writes the current values back
+to the config file, keeping its comments and layout.
+*/
+
+const CONFIG_REWRITE_SIGNATURE = "# Generated by CONFIG REWRITE"
+
+var configFlags = flag.NewFlagSet("yakvs", flag.ExitOnError)
+
+// config holds the parameters as set at startup, the ones CONFIG SET changes
+// are read from where they're applied, see configParams
+var config = struct {
+	maxMemory            *string
+	maxMemoryPolicy      *string
+	maxMemorySamples     *int
+	notifyKeyspaceEvents *string
+	port                 *int
+	requirePass          *string
+	aclFile              *string
+	pubsubLimit          *string
+	appendFilename       *string
+	appendFsync          *string
+	replicaOf            *string
+	masterAuth           *string
+	masterUser           *string
+	replicaReadOnly      *bool
+	replBacklogSize      *string
+	sentinel             *bool
+	sentinelMonitors     []string
+	downAfter            *int
+	failoverTimeout      *int
+	clusterEnabled       *bool
+	clusterConfigFile    *string
+	clusterPort          *int
+	clusterNodeTimeout   *int
+	busyReplyThreshold   *int
+	slowlogSlowerThan    *int64
+	slowlogMaxLen        *int
+	latencyThreshold     *int64
+	latencyTracking      *bool
+	latencyPercentiles   *string
+	metricsPort          *int
+}{
+	maxMemory:            configFlags.String("maxmemory", "0", "memory limit for the dataset (e.g. 100mb), 0 means no limit"),
+	maxMemoryPolicy:      configFlags.String("maxmemory-policy", "noeviction", "eviction policy used when maxmemory is reached"),
+	maxMemorySamples:     configFlags.Int("maxmemory-samples", store.MAXMEMORY_SAMPLES_DEFAULT, "keys sampled per eviction round"),
+	notifyKeyspaceEvents: configFlags.String("notify-keyspace-events", "", "keyspace notifications to publish, e.g. Ex for expired keys"),
+	port:                 configFlags.Int("port", 0, "serve clients over TCP on this port instead of running the prompt"),
+	requirePass:          configFlags.String("requirepass", "", "password clients must send with AUTH before running commands"),
+	aclFile:              configFlags.String("aclfile", "", "file the users are loaded from at startup and by ACL LOAD, and saved to by ACL SAVE"),
+	pubsubLimit:          configFlags.String("client-output-buffer-limit-pubsub", "32mb", "output a subscriber may have pending before it is disconnected, 0 means no limit"),
+	appendFilename:       configFlags.String("appendfilename", "base.aof", "file the write commands are appended to and loaded from at startup"),
+	appendFsync:          configFlags.String("appendfsync", aof.AOF_FSYNC_ALWAYS, "when the AOF is fsynced: always, everysec or no"),
+	replicaOf:            configFlags.String("replicaof", "", "\"host port\" of the master to replicate"),
+	masterAuth:           configFlags.String("masterauth", "", "password a replica authenticates to its master with"),
+	masterUser:           configFlags.String("masteruser", "", "ACL user a replica authenticates to its master as, with masterauth"),
+	replicaReadOnly:      configFlags.Bool("replica-read-only", true, "replicas refuse the write commands of their clients"),
+	replBacklogSize:      configFlags.String("repl-backlog-size", "1mb", "history of write commands kept for replicas reconnecting"),
+	sentinel:             configFlags.Bool("sentinel", false, "run as a sentinel monitoring the masters given with -sentinel-monitor"),
+	downAfter:            configFlags.Int("sentinel-down-after-milliseconds", int(SENTINEL_DOWN_AFTER_DEFAULT.Milliseconds()), "time an instance may not reply before a sentinel thinks it is down"),
+	failoverTimeout:      configFlags.Int("sentinel-failover-timeout", int(SENTINEL_FAILOVER_TIMEOUT_DEFAULT.Milliseconds()), "time a failover may take, in milliseconds"),
+	clusterEnabled:       configFlags.Bool("cluster-enabled", false, "run as a node of a cluster, serving the hash slots assigned to it"),
+	clusterConfigFile:    configFlags.String("cluster-config-file", CLUSTER_CONFIG_FILE_DEFAULT, "file a cluster node saves its configuration to and loads it from at startup"),
+	clusterPort:          configFlags.Int("cluster-port", 0, "port of the cluster bus, 0 means the port plus 10000"),
+	clusterNodeTimeout:   configFlags.Int("cluster-node-timeout", int(CLUSTER_NODE_TIMEOUT_DEFAULT.Milliseconds()), "time a cluster node may not reply before it is failing, in milliseconds"),
+	busyReplyThreshold:   configFlags.Int("busy-reply-threshold", int(SCRIPT_BUSY_REPLY_THRESHOLD_DEFAULT.Milliseconds()), "time a script runs before the other clients get -BUSY errors and it may be killed, in milliseconds"),
+	slowlogSlowerThan:    configFlags.Int64("slowlog-log-slower-than", SLOWLOG_LOG_SLOWER_THAN_DEFAULT, "time a command runs before it is added to the slow log, in microseconds, negative disables the log"),
+	slowlogMaxLen:        configFlags.Int("slowlog-max-len", SLOWLOG_MAX_LEN_DEFAULT, "number of entries the slow log keeps"),
+	latencyThreshold:     configFlags.Int64("latency-monitor-threshold", 0, "latency from which events are recorded by the latency monitor, in milliseconds, 0 disables it"),
+	latencyTracking:      configFlags.Bool("latency-tracking", true, "record the latency histogram of every command"),
+	latencyPercentiles:   configFlags.String("latency-tracking-info-percentiles", LATENCY_TRACKING_PERCENTILES_DEFAULT, "percentiles of the command latencies INFO latencystats reports"),
+	metricsPort:          configFlags.Int("metrics-port", 0, "serve the Prometheus metrics on /metrics and a health check on /healthz over HTTP on this port, 0 disables them"),
+}
+
+func init() {
+	configFlags.Func("sentinel-monitor", "\"name host port quorum\" of a master to monitor, may be repeated", func(value string) error {
+		config.sentinelMonitors = append(config.sentinelMonitors, value)
+		return nil
+	})
+}
+
+// configParam reads and changes a parameter at runtime. The parameters
+// without one keep the value they had at startup.
+type configParam struct {
+	get    func() string
+	set    func(value string) error // nil if it can't be changed at runtime
+	memory bool                     // a number of bytes, given with units like 100mb
+}
+
+var configParams = map[string]configParam{
+	"maxmemory": {memory: true,
+		get: func() string { return strconv.FormatInt(kvStore.GetMaxMemory(), 10) },
+		set: func(value string) error {
+			bytes, err := utils.ParseMemory(value)
+			if err != nil {
+				return err
+			}
+			kvStore.SetMaxMemory(bytes)
+			return nil
+		},
+	},
+	"maxmemory-policy": {
+		get: func() string { return kvStore.GetMaxMemoryPolicy() },
+		set: func(value string) error { return kvStore.SetMaxMemoryPolicy(value) },
+	},
+	"maxmemory-samples": {
+		get: func() string { return strconv.Itoa(kvStore.GetMaxMemorySamples()) },
+		set: func(value string) error {
+			samples, err := strconv.Atoi(value)
+			if err != nil || samples <= 0 {
+				return fmt.Errorf("argument must be a positive integer")
+			}
+			kvStore.SetMaxMemorySamples(samples)
+			return nil
+		},
+	},
+	"notify-keyspace-events": {
+		get: func() string { return kvStore.GetNotifyKeyspaceEvents() },
+		set: func(value string) error { return kvStore.SetNotifyKeyspaceEvents(value) },
+	},
+	"requirepass": {
+		get: getRequirePass,
+		set: func(value string) error {
+			setRequirePass(value)
+			return nil
+		},
+	},
+	"client-output-buffer-limit-pubsub": {memory: true,
+		get: func() string { return strconv.FormatInt(pubsubOutputBufferLimit.Load(), 10) },
+		set: func(value string) error {
+			bytes, err := utils.ParseMemory(value)
+			if err != nil {
+				return err
+			}
+			pubsubOutputBufferLimit.Store(bytes)
+			return nil
+		},
+	},
+	"appendfsync": {
+		get: func() string {
+			if aofManager == nil {
+				return *config.appendFsync
+			}
+			return aofManager.FsyncPolicy()
+		},
+		set: func(value string) error {
+			if aofManager == nil {
+				return aof.CheckFsyncPolicy(value)
+			}
+			return aofManager.SetFsyncPolicy(value)
+		},
+	},
+	"replicaof": {
+		get: func() string {
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			if repl.master == nil {
+				return ""
+			}
+			return fmt.Sprintf("%s %d", repl.master.host, repl.master.port)
+		},
+	},
+	"masterauth": {
+		get: func() string {
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			return repl.masterAuth
+		},
+		set: func(value string) error {
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			repl.masterAuth = value
+			return nil
+		},
+	},
+	"masteruser": {
+		get: func() string {
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			return repl.masterUser
+		},
+		set: func(value string) error {
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			repl.masterUser = value
+			return nil
+		},
+	},
+	"replica-read-only": {
+		get: func() string {
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			return configBool(repl.readOnly)
+		},
+		set: func(value string) error {
+			readOnly, err := parseConfigBool(value)
+			if err != nil {
+				return err
+			}
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			repl.readOnly = readOnly
+			return nil
+		},
+	},
+	"repl-backlog-size": {memory: true,
+		get: func() string {
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			return strconv.Itoa(repl.backlogSize)
+		},
+		set: func(value string) error {
+			bytes, err := utils.ParseMemory(value)
+			if err != nil || bytes <= 0 {
+				return fmt.Errorf("argument must be a memory value greater than 0")
+			}
+			repl.mu.Lock()
+			defer repl.mu.Unlock()
+			repl.backlogSize = int(bytes)
+			if repl.backlog != nil {
+				repl.backlog.size = repl.backlogSize
+			}
+			return nil
+		},
+	},
+	"busy-reply-threshold": {
+		get: func() string {
+			return strconv.FormatInt(time.Duration(busyReplyThreshold.Load()).Milliseconds(), 10)
+		},
+		set: func(value string) error {
+			ms, err := strconv.Atoi(value)
+			if err != nil || ms < 0 {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			busyReplyThreshold.Store(int64(time.Duration(ms) * time.Millisecond))
+			return nil
+		},
+	},
+	"slowlog-log-slower-than": {
+		get: func() string { return strconv.FormatInt(slowlogSlowerThan.Load(), 10) },
+		set: func(value string) error {
+			usec, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			slowlogSlowerThan.Store(usec)
+			return nil
+		},
+	},
+	"slowlog-max-len": {
+		get: func() string { return strconv.FormatInt(slowlogMaxLen.Load(), 10) },
+		set: func(value string) error {
+			length, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || length < 0 {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			slowlogMaxLen.Store(length)
+			slowlogTrim()
+			return nil
+		},
+	},
+	"latency-monitor-threshold": {
+		get: func() string { return strconv.FormatInt(latencyThreshold.Load(), 10) },
+		set: func(value string) error {
+			ms, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || ms < 0 {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			latencyThreshold.Store(ms)
+			return nil
+		},
+	},
+	"latency-tracking": {
+		get: func() string { return configBool(latencyTracking.Load()) },
+		set: func(value string) error {
+			tracking, err := parseConfigBool(value)
+			if err != nil {
+				return err
+			}
+			latencyTracking.Store(tracking)
+			return nil
+		},
+	},
+	"latency-tracking-info-percentiles": {
+		get: getLatencyPercentiles,
+		set: setLatencyPercentiles,
+	},
+}
+
+// applyConfig applies the parameters CONFIG SET may change, as given at
+// startup
+func applyConfig() error {
+	var err error
+	configFlags.VisitAll(func(f *flag.Flag) {
+		param := configParams[f.Name]
+		if err != nil || param.set == nil {
+			return
+		}
+		value := f.Value.String()
+		if isBoolFlag(f) {
+			value = configBool(value == "true")
+		}
+		if setErr := param.set(value); setErr != nil {
+			err = fmt.Errorf("%s: %v", f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// configState is the config file, and serializes CONFIG SET and REWRITE
+var configState struct {
+	mu   sync.Mutex
+	file string // absolute path, empty without a config file
+}
+
+// parseConfig reads the config file if the first argument is one, then
+// the flags of the command line
+func parseConfig(args []string) error {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		file, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		if err := loadConfigFile(file); err != nil {
+			return err
+		}
+		configState.file = file
+		args = args[1:]
+	}
+	return configFlags.Parse(args)
+}
+
+// loadConfigFile sets the parameters of the directives of a config file
+func loadConfigFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		name, value, err := parseConfigLine(line)
+		if err == nil && name != "" {
+			err = setConfigFlag(name, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s, at line %d: >>> '%s': %v", file, i+1, strings.TrimSpace(line), err)
+		}
+	}
+	return nil
+}
+
+// parseConfigLine returns the parameter of a line of the config file and its
+// value, the words after the name separated by a space. The name is empty
+// for a comment or an empty line.
+func parseConfigLine(line string) (string, string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", nil
+	}
+	words, err := splitConfigArgs(line)
+	if err != nil {
+		return "", "", err
+	}
+	if len(words) < 2 {
+		return "", "", fmt.Errorf("Bad directive or wrong number of arguments")
+	}
+	return strings.ToLower(words[0]), strings.Join(words[1:], " "), nil
+}
+
+// setConfigFlag sets a flag from the value of a directive, the booleans are
+// yes or no
+func setConfigFlag(name, value string) error {
+	f := configFlags.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("Bad directive or wrong number of arguments")
+	}
+	if isBoolFlag(f) {
+		b, err := parseConfigBool(value)
+		if err != nil {
+			return err
+		}
+		value = strconv.FormatBool(b)
+	}
+	return configFlags.Set(name, value)
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("argument must be 'yes' or 'no'")
+}
+
+func configBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// splitConfigArgs splits a line in words like redis.conf: "double quoted"
+// words may have escapes like \n or \x41, 'single quoted' ones only \'
+func splitConfigArgs(line string) ([]string, error) {
+	var words []string
+	for i := 0; ; {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return words, nil
+		}
+		var word strings.Builder
+		switch quote := line[i]; quote {
+		case '"', '\'':
+			i++
+			for {
+				if i == len(line) {
+					return nil, fmt.Errorf("Unbalanced quotes in configuration line")
+				}
+				c := line[i]
+				if c == quote {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					next := line[i+1]
+					if quote == '\'' {
+						if next == '\'' {
+							c, i = '\'', i+1
+						}
+					} else if next == 'x' && i+3 < len(line) && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+						n, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+						c, i = byte(n), i+3
+					} else {
+						c, i = unescapeConfigByte(next), i+1
+					}
+				}
+				word.WriteByte(c)
+				i++
+			}
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil, fmt.Errorf("Unbalanced quotes in configuration line")
+			}
+		default:
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				word.WriteByte(line[i])
+				i++
+			}
+		}
+		words = append(words, word.String())
+	}
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func unescapeConfigByte(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
+
+// quoteConfigValue writes a value for the config file, quoted unless its
+// words can be given as they are
+func quoteConfigValue(value string) string {
+	if value != "" && value == strings.Join(strings.Fields(value), " ") && !strings.ContainsAny(value, "\"'\\") {
+		return value
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// configValue returns the current value of a parameter
+func configValue(f *flag.Flag) string {
+	if param, ok := configParams[f.Name]; ok {
+		return param.get()
+	}
+	if isBoolFlag(f) {
+		return configBool(f.Value.String() == "true")
+	}
+	return f.Value.String()
+}
+
+// configDefault returns the default value of a parameter, as configValue
+// would
+func configDefault(f *flag.Flag) string {
+	if configParams[f.Name].memory {
+		if bytes, err := utils.ParseMemory(f.DefValue); err == nil {
+			return strconv.FormatInt(bytes, 10)
+		}
+	}
+	if isBoolFlag(f) {
+		return configBool(f.DefValue == "true")
+	}
+	return f.DefValue
+}
+
+// configCommand handles the CONFIG subcommands
+func configCommand(w *resp.Writer, args []string) {
+	sub := strings.ToUpper(args[0])
+	args = args[1:]
+	switch {
+	case sub == "GET" && len(args) > 0:
+		configGet(w, args)
+	case sub == "SET" && len(args) > 0:
+		if len(args)%2 != 0 {
+			w.WriteError("ERR wrong number of arguments for 'config|set' command")
+			return
+		}
+		if err := configSet(args); err != nil {
+			w.WriteError(err.Error())
+			return
+		}
+		w.WriteSimpleString("OK")
+	case sub == "RESETSTAT" && len(args) == 0:
+		resetStats()
+		w.WriteSimpleString("OK")
+	case sub == "REWRITE" && len(args) == 0:
+		if err := configRewrite(); err != nil {
+			w.WriteError(err.Error())
+			return
+		}
+		w.WriteSimpleString("OK")
+	case sub == "HELP" && len(args) == 0:
+		lines := []string{
+			"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET <pattern>",
+			"    Return parameters matching the glob-like <pattern> and their values.",
+			"SET <directive> <value>",
+			"    Set the configuration <directive> to <value>.",
+			"RESETSTAT",
+			"    Reset statistics reported by the INFO command.",
+			"REWRITE",
+			"    Rewrite the configuration file.",
+		}
+		w.WriteArrayLen(len(lines))
+		for _, line := range lines {
+			w.WriteSimpleString(line)
+		}
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CONFIG HELP.", strings.ToLower(sub)))
+	}
+}
+
+// configGet replies with the parameters matching one of the patterns and
+// their values
+func configGet(w *resp.Writer, patterns []string) {
+	var pairs []string
+	configFlags.VisitAll(func(f *flag.Flag) {
+		for _, pattern := range patterns {
+			if utils.StringMatch(pattern, f.Name, true) {
+				pairs = append(pairs, f.Name, configValue(f))
+				return
+			}
+		}
+	})
+	w.WriteMapLen(len(pairs) / 2)
+	for _, s := range pairs {
+		w.WriteBulkString(s)
+	}
+}
+
+// configSet sets the parameters of the name value pairs. They're all
+// validated and set, or none is: the ones set before a failure are reverted.
+func configSet(args []string) error {
+	configState.mu.Lock()
+	defer configState.mu.Unlock()
+	seen := make(map[string]bool)
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		f := configFlags.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i])
+		}
+		if configParams[name].set == nil {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", args[i])
+		}
+		if seen[name] {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", args[i])
+		}
+		seen[name] = true
+	}
+
+	var previous []string
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		param := configParams[name]
+		old := param.get()
+		if err := param.set(args[i+1]); err != nil {
+			for j := len(previous) - 2; j >= 0; j -= 2 {
+				configParams[previous[j]].set(previous[j+1])
+			}
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", args[i], err)
+		}
+		previous = append(previous, name, old)
+	}
+	return nil
+}
+
+// configRewrite writes the current values of the parameters to the config
+// file: the directives of the file are updated in place, the parameters
+// not in it are added at its end unless they have their default value.
+// The comments and the directives it doesn't know are kept.
+func configRewrite() error {
+	configState.mu.Lock()
+	defer configState.mu.Unlock()
+	if configState.file == "" {
+		return fmt.Errorf("ERR The server is running without a config file")
+	}
+	data, err := os.ReadFile(configState.file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+
+	var lines []string
+	written := make(map[string]bool)
+	signature := false
+	text := strings.TrimSuffix(string(data), "\n")
+	if text != "" {
+		for _, line := range strings.Split(text, "\n") {
+			if line == CONFIG_REWRITE_SIGNATURE {
+				signature = true
+			}
+			name, _, err := parseConfigLine(line)
+			f := configFlags.Lookup(name)
+			if err != nil || f == nil || name == "sentinel-monitor" {
+				lines = append(lines, line) // kept as is, sentinel-monitor may be repeated
+				continue
+			}
+			if written[name] {
+				continue // given again, the value is on the first line
+			}
+			written[name] = true
+			lines = append(lines, name+" "+quoteConfigValue(configValue(f)))
+		}
+	}
+	configFlags.VisitAll(func(f *flag.Flag) {
+		if written[f.Name] || f.Name == "sentinel-monitor" || configValue(f) == configDefault(f) {
+			return
+		}
+		if !signature {
+			lines = append(lines, CONFIG_REWRITE_SIGNATURE)
+			signature = true
+		}
+		lines = append(lines, f.Name+" "+quoteConfigValue(configValue(f)))
+	})
+
+	// replaced at once, a crash can't leave half a file
+	tmp, err := os.CreateTemp(filepath.Dir(configState.file), "temp-config-*.conf")
+	if err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	out := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		out.WriteString(line + "\n")
+	}
+	err = out.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	if info, err := os.Stat(configState.file); err == nil {
+		os.Chmod(tmp.Name(), info.Mode())
+	} else {
+		os.Chmod(tmp.Name(), 0644)
+	}
+	if err := os.Rename(tmp.Name(), configState.file); err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	return nil
+}
+
+// resetStats resets the statistics of INFO for CONFIG RESETSTAT
+func resetStats() {
+	resetServerStats()
+	resetMetrics()
+	kvStore.ResetStats()
+	repl.mu.Lock()
+	repl.syncFull, repl.syncPartialOK, repl.syncPartialError = 0, 0, 0
+	repl.mu.Unlock()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// resetConfig restores the parameters CONFIG SET may change at the end of
+// a test
+func resetConfig(t *testing.T) {
+	saved := make(map[string]string)
+	for name, param := range configParams {
+		if param.set != nil {
+			saved[name] = param.get()
+		}
+	}
+	file := configState.file
+	t.Cleanup(func() {
+		for name, value := range saved {
+			configParams[name].set(value)
+		}
+		configState.file = file
+	})
+}
+
+func TestSplitConfigArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"port 7000", []string{"port", "7000"}},
+		{"  replicaof\t127.0.0.1   6379 ", []string{"replicaof", "127.0.0.1", "6379"}},
+		{`requirepass "with space\n\x41\"" 'single \'quoted\''`, []string{"requirepass", "with space\nA\"", "single 'quoted'"}},
+		{`notify-keyspace-events ""`, []string{"notify-keyspace-events", ""}},
+	}
+	for _, test := range tests {
+		words, err := splitConfigArgs(test.line)
+		if err != nil || !reflect.DeepEqual(words, test.expected) {
+			t.Errorf("%q: expected %q, got %q %v", test.line, test.expected, words, err)
+		}
+	}
+	for _, line := range []string{`requirepass "open`, `requirepass "a"b`} {
+		if _, err := splitConfigArgs(line); err == nil {
+			t.Errorf("%q: expected unbalanced quotes", line)
+		}
+	}
+
+	for _, value := range []string{"plain", "127.0.0.1 6379", "", "two  spaces", "quote\"d", "new\nline\x01"} {
+		words, err := splitConfigArgs("name " + quoteConfigValue(value))
+		if err != nil || strings.Join(words[1:], " ") != value {
+			t.Errorf("Expected %q back from %q, got %q %v", value, quoteConfigValue(value), words, err)
+		}
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	resetConfig(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("CONFIG", "GET", "maxmemory")
+	c.expect("*2\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n")
+	c.send("CONFIG", "SET", "maxmemory", "1mb", "MAXMEMORY-POLICY", "allkeys-lru")
+	c.expect("+OK\r\n")
+	c.send("CONFIG", "GET", "maxmemory*")
+	c.expect("*6\r\n$9\r\nmaxmemory\r\n$7\r\n1048576\r\n$16\r\nmaxmemory-policy\r\n$11\r\nallkeys-lru\r\n$17\r\nmaxmemory-samples\r\n$1\r\n5\r\n")
+	c.send("CONFIG", "GET", "replica-read-only", "nosuch")
+	c.expect("*2\r\n$17\r\nreplica-read-only\r\n$3\r\nyes\r\n")
+	c.send("CONFIG", "SET", "replica-read-only", "no")
+	c.expect("+OK\r\n")
+	c.send("CONFIG", "GET", "replica-read-only")
+	c.expect("*2\r\n$17\r\nreplica-read-only\r\n$2\r\nno\r\n")
+
+	// nothing is set when a parameter fails
+	c.send("CONFIG", "SET", "maxmemory", "2mb", "maxmemory-policy", "nosuch")
+	c.expect("-ERR CONFIG SET failed (possibly related to argument 'maxmemory-policy') - invalid maxmemory policy: nosuch\r\n")
+	c.send("CONFIG", "GET", "maxmemory")
+	c.expect("*2\r\n$9\r\nmaxmemory\r\n$7\r\n1048576\r\n")
+
+	c.send("CONFIG", "SET", "port", "7000")
+	c.expect("-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n")
+	c.send("CONFIG", "SET", "nosuch", "1")
+	c.expect("-ERR Unknown option or number of arguments for CONFIG SET - 'nosuch'\r\n")
+	c.send("CONFIG", "SET", "maxmemory", "1mb", "maxmemory", "2mb")
+	c.expect("-ERR CONFIG SET failed (possibly related to argument 'maxmemory') - duplicate parameter\r\n")
+	c.send("CONFIG", "SET", "maxmemory")
+	c.expect("-ERR wrong number of arguments for 'config|set' command\r\n")
+	c.send("CONFIG", "SET", "replica-read-only", "maybe")
+	c.expect("-ERR CONFIG SET failed (possibly related to argument 'replica-read-only') - argument must be 'yes' or 'no'\r\n")
+	c.send("CONFIG", "SET", "busy-reply-threshold", "-1")
+	c.expect("-ERR CONFIG SET failed (possibly related to argument 'busy-reply-threshold') - argument couldn't be parsed into an integer\r\n")
+
+	c.send("CONFIG", "SET", "requirepass", "secret")
+	c.expect("+OK\r\n")
+	other := dial(t, addr)
+	other.send("GET", "config:key")
+	other.expect("-NOAUTH Authentication required.\r\n")
+	c.send("AUTH", "secret")
+	c.expect("+OK\r\n")
+	c.send("CONFIG", "SET", "requirepass", "")
+	c.expect("+OK\r\n")
+
+	c.send("CONFIG", "RESETSTAT")
+	c.expect("+OK\r\n")
+	if stats := c.info("stats"); stats["sync_full"] != "0" {
+		t.Errorf("Expected the stats reset, got %v", stats)
+	}
+	configState.file = ""
+	c.send("CONFIG", "REWRITE")
+	c.expect("-ERR The server is running without a config file\r\n")
+	c.send("CONFIG", "NOSUCH")
+	c.expect("-ERR unknown subcommand or wrong number of arguments for 'nosuch'. Try CONFIG HELP.\r\n")
+}
+
+func TestConfigRewrite(t *testing.T) {
+	resetConfig(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	configState.file = filepath.Join(t.TempDir(), "yakvs.conf")
+	original := "# the memory limit\nmaxmemory 100mb\n\n# unknown to this version\nsomething else\nmaxmemory 200mb\nport 7000\n"
+	if err := os.WriteFile(configState.file, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.send("CONFIG", "SET", "maxmemory", "1mb", "notify-keyspace-events", "Ex", "masterauth", "pass word")
+	c.expect("+OK\r\n")
+	c.send("CONFIG", "REWRITE")
+	c.expect("+OK\r\n")
+
+	data, err := os.ReadFile(configState.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# the memory limit\nmaxmemory 1048576\n\n# unknown to this version\nsomething else\nport 0\n" +
+		CONFIG_REWRITE_SIGNATURE + "\nmasterauth pass word\nnotify-keyspace-events xE\n"
+	if string(data) != expected {
+		t.Errorf("Expected the config file rewritten as\n%s\ngot\n%s", expected, data)
+	}
+	if info, err := os.Stat(configState.file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the permissions of the file kept, got %v %v", info.Mode(), err)
+	}
+
+	// a second rewrite changes nothing
+	c.send("CONFIG", "REWRITE")
+	c.expect("+OK\r\n")
+	if again, _ := os.ReadFile(configState.file); string(again) != expected {
+		t.Errorf("Expected the rewrite to be stable, got\n%s", again)
+	}
+}
+
+func TestConfigFileProcess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts server processes")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error finding a free port: %v", err)
+	}
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "yakvs.conf")
+	conf := "# overridden by the command line\nport 1\nmaxmemory 10mb\nreplica-read-only no\nappendfilename \"" + filepath.Join(dir, "appendonly.aof") + "\"\n"
+	if err := os.WriteFile(file, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "YAKVS_TEST_SERVER="+strings.Join([]string{file, "--port", port}, "\n"))
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Error starting the server: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr := "127.0.0.1:" + port
+	eventually(t, "the server to accept connections", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	})
+
+	c := dial(t, addr)
+	c.send("CONFIG", "GET", "port", "maxmemory", "replica-read-only")
+	c.expect("*6\r\n$9\r\nmaxmemory\r\n$8\r\n10485760\r\n$4\r\nport\r\n$" + strconv.Itoa(len(port)) + "\r\n" + port + "\r\n$17\r\nreplica-read-only\r\n$2\r\nno\r\n")
+
+	// a bad directive stops the server
+	bad := filepath.Join(dir, "bad.conf")
+	os.WriteFile(bad, []byte("port 7000\nnosuch directive\n"), 0644)
+	badCmd := exec.Command(os.Args[0])
+	badCmd.Env = append(os.Environ(), "YAKVS_TEST_SERVER="+bad)
+	out, err := badCmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "at line 2: >>> 'nosuch directive': Bad directive or wrong number of arguments") {
+		t.Errorf("Expected the server to fail on the bad directive, got %v: %s", err, out)
+	}
+}
@@ -2,87 +2,310 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/shubhdevelop/YAKVS/command"
 	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
 	"github.com/shubhdevelop/YAKVS/store"
 )
 
-func ExecuteCommand(cmd *parser.Command, store *store.Store) {
-	fmt.Println("Executing command:", cmd)
+// Command flags, as in the Redis command table
+const (
+	CMD_WRITE    = 1 << iota // may modify the dataset
+	CMD_READONLY             // only reads the dataset
+	CMD_DENYOOM              // may grow the dataset, refused when over maxmemory and nothing can be evicted
+	CMD_ADMIN                // administrative, like saving or managing users
+	CMD_PUBSUB               // publish/subscribe related
+	CMD_FAST                 // runs in O(1) or O(log N)
+	CMD_BLOCKING             // may block the client
+	CMD_NOAUTH               // runs before the client authenticates
+	CMD_ASKING               // implies ASKING, for the commands moving keys between cluster nodes
+	CMD_NOSCRIPT             // not allowed from scripts
+)
+
+// commandSpec describes a command. The arity follows the Redis convention:
+// N means exactly N words including the command name, -N means at least N.
+// The keys are the words from firstKey to lastKey every keyStep, a negative
+// lastKey counts from the end (-1 is the last word). keyAccess is what the
+// command does with them for the ACL: "R", "W" or "RW", and destAccess what
+// it does with the last one when it is a destination, like COPY's. getKeys
+// replaces the key positions for a command whose keys move, like MIGRATE's.
+type commandSpec struct {
+	arity      int
+	flags      int
+	group      string // ACL category of the data type or feature, see commandCategories
+	firstKey   int
+	lastKey    int
+	keyStep    int
+	keyAccess  string
+	destAccess string
+	getKeys    func(words []string) []string
+}
+
+var commandTable = map[string]*commandSpec{
+	"BGSAVE":         {arity: -1, flags: CMD_ADMIN},
+	"SET":            {arity: -3, flags: CMD_WRITE | CMD_DENYOOM, group: "string", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"GET":            {arity: 2, flags: CMD_READONLY | CMD_FAST, group: "string", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"INCRBY":         {arity: 3, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, group: "string", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "RW"},
+	"DECRBY":         {arity: 3, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, group: "string", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "RW"},
+	"DEL":            {arity: -2, flags: CMD_WRITE, group: "keyspace", firstKey: 1, lastKey: -1, keyStep: 1, keyAccess: "W"},
+	"EXISTS":         {arity: -2, flags: CMD_READONLY | CMD_FAST, group: "keyspace", firstKey: 1, lastKey: -1, keyStep: 1, keyAccess: "R"},
+	"TTL":            {arity: 2, flags: CMD_READONLY | CMD_FAST, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"EXPIRE":         {arity: -3, flags: CMD_WRITE | CMD_FAST, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"EXPIREAT":       {arity: -3, flags: CMD_WRITE | CMD_FAST, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"PERSIST":        {arity: 2, flags: CMD_WRITE | CMD_FAST, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"COPY":           {arity: -3, flags: CMD_WRITE | CMD_DENYOOM, group: "keyspace", firstKey: 1, lastKey: 2, keyStep: 1, keyAccess: "R", destAccess: "W"},
+	"TYPE":           {arity: 2, flags: CMD_READONLY | CMD_FAST, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"OBJECT":         {arity: -2, flags: CMD_READONLY, group: "keyspace", firstKey: 2, lastKey: 2, keyStep: 1, keyAccess: "R"},
+	"MEMORY":         {arity: -2, flags: CMD_READONLY, firstKey: 2, lastKey: 2, keyStep: 1, keyAccess: "R"},
+	"DUMP":           {arity: 2, flags: CMD_READONLY, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"RESTORE":        {arity: -4, flags: CMD_WRITE | CMD_DENYOOM, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"RESTORE-ASKING": {arity: -4, flags: CMD_WRITE | CMD_DENYOOM | CMD_ASKING, group: "keyspace", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"MIGRATE":        {arity: -6, flags: CMD_WRITE | CMD_NOSCRIPT, group: "keyspace", keyAccess: "RW", getKeys: migrateGetKeys},
+	"MULTI":          {arity: 1, flags: CMD_FAST | CMD_NOSCRIPT, group: "transaction"},
+	"EXEC":           {arity: 1, flags: CMD_NOSCRIPT, group: "transaction"},
+	"DISCARD":        {arity: 1, flags: CMD_FAST | CMD_NOSCRIPT, group: "transaction"},
+	"WATCH":          {arity: -2, flags: CMD_FAST | CMD_NOSCRIPT, group: "transaction", firstKey: 1, lastKey: -1, keyStep: 1, keyAccess: "R"},
+	"UNWATCH":        {arity: 1, flags: CMD_FAST | CMD_NOSCRIPT, group: "transaction"},
+	"PING":           {arity: -1, flags: CMD_FAST, group: "connection"},
+	"HELLO":          {arity: -1, flags: CMD_FAST | CMD_NOAUTH | CMD_NOSCRIPT, group: "connection"},
+	"AUTH":           {arity: -2, flags: CMD_FAST | CMD_NOAUTH | CMD_NOSCRIPT, group: "connection"},
+	"QUIT":           {arity: -1, flags: CMD_FAST | CMD_NOAUTH | CMD_NOSCRIPT, group: "connection"},
+	"SUBSCRIBE":      {arity: -2, flags: CMD_PUBSUB | CMD_NOSCRIPT},
+	"UNSUBSCRIBE":    {arity: -1, flags: CMD_PUBSUB | CMD_NOSCRIPT},
+	"PSUBSCRIBE":     {arity: -2, flags: CMD_PUBSUB | CMD_NOSCRIPT},
+	"PUNSUBSCRIBE":   {arity: -1, flags: CMD_PUBSUB | CMD_NOSCRIPT},
+	"SSUBSCRIBE":     {arity: -2, flags: CMD_PUBSUB | CMD_NOSCRIPT},
+	"SUNSUBSCRIBE":   {arity: -1, flags: CMD_PUBSUB | CMD_NOSCRIPT},
+	"PUBLISH":        {arity: 3, flags: CMD_PUBSUB | CMD_FAST},
+	"SPUBLISH":       {arity: 3, flags: CMD_PUBSUB | CMD_FAST},
+	"PUBSUB":         {arity: -2, flags: CMD_PUBSUB},
+	"LPUSH":          {arity: -3, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, group: "list", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"RPUSH":          {arity: -3, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, group: "list", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"LPOP":           {arity: -2, flags: CMD_WRITE | CMD_FAST, group: "list", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "RW"},
+	"RPOP":           {arity: -2, flags: CMD_WRITE | CMD_FAST, group: "list", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "RW"},
+	"LLEN":           {arity: 2, flags: CMD_READONLY | CMD_FAST, group: "list", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"LRANGE":         {arity: 4, flags: CMD_READONLY, group: "list", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"LMOVE":          {arity: 5, flags: CMD_WRITE | CMD_DENYOOM, group: "list", firstKey: 1, lastKey: 2, keyStep: 1, keyAccess: "RW", destAccess: "W"},
+	"BLPOP":          {arity: -3, flags: CMD_WRITE | CMD_BLOCKING, group: "list", firstKey: 1, lastKey: -2, keyStep: 1, keyAccess: "RW"},
+	"BRPOP":          {arity: -3, flags: CMD_WRITE | CMD_BLOCKING, group: "list", firstKey: 1, lastKey: -2, keyStep: 1, keyAccess: "RW"},
+	"BLMOVE":         {arity: 6, flags: CMD_WRITE | CMD_DENYOOM | CMD_BLOCKING, group: "list", firstKey: 1, lastKey: 2, keyStep: 1, keyAccess: "RW", destAccess: "W"},
+	"ZADD":           {arity: -4, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, group: "sortedset", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "W"},
+	"ZPOPMIN":        {arity: -2, flags: CMD_WRITE | CMD_FAST, group: "sortedset", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "RW"},
+	"ZRANGE":         {arity: -4, flags: CMD_READONLY, group: "sortedset", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"ZCARD":          {arity: 2, flags: CMD_READONLY | CMD_FAST, group: "sortedset", firstKey: 1, lastKey: 1, keyStep: 1, keyAccess: "R"},
+	"BZPOPMIN":       {arity: -3, flags: CMD_WRITE | CMD_FAST | CMD_BLOCKING, group: "sortedset", firstKey: 1, lastKey: -2, keyStep: 1, keyAccess: "RW"},
+	"ACL":            {arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"CLIENT":         {arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT, group: "connection"},
+	"INFO":           {arity: -1},
+	"CONFIG":         {arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"SLOWLOG":        {arity: -2, flags: CMD_ADMIN},
+	"LATENCY":        {arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"MONITOR":        {arity: 1, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"REPLICAOF":      {arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"SLAVEOF":        {arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"PSYNC":          {arity: -3, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"SYNC":           {arity: 1, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"REPLCONF":       {arity: -1, flags: CMD_ADMIN | CMD_NOSCRIPT},
+	"CLUSTER":        {arity: -2},
+	"ASKING":         {arity: 1, flags: CMD_FAST | CMD_NOSCRIPT, group: "connection"},
+	"WAIT":           {arity: 3, flags: CMD_BLOCKING | CMD_NOSCRIPT, group: "connection"},
+	"WAITAOF":        {arity: 4, flags: CMD_BLOCKING | CMD_NOSCRIPT, group: "connection"},
+	"EVAL":           {arity: -3, flags: CMD_NOSCRIPT, group: "scripting", keyAccess: "RW", getKeys: scriptGetKeys},
+	"EVALSHA":        {arity: -3, flags: CMD_NOSCRIPT, group: "scripting", keyAccess: "RW", getKeys: scriptGetKeys},
+	"EVAL_RO":        {arity: -3, flags: CMD_NOSCRIPT | CMD_READONLY, group: "scripting", keyAccess: "R", getKeys: scriptGetKeys},
+	"EVALSHA_RO":     {arity: -3, flags: CMD_NOSCRIPT | CMD_READONLY, group: "scripting", keyAccess: "R", getKeys: scriptGetKeys},
+	"FCALL":          {arity: -3, flags: CMD_NOSCRIPT, group: "scripting", keyAccess: "RW", getKeys: scriptGetKeys},
+	"FCALL_RO":       {arity: -3, flags: CMD_NOSCRIPT | CMD_READONLY, group: "scripting", keyAccess: "R", getKeys: scriptGetKeys},
+	"SCRIPT":         {arity: -2, flags: CMD_NOSCRIPT, group: "scripting"},
+	"FUNCTION":       {arity: -2, flags: CMD_NOSCRIPT, group: "scripting"},
+}
+
+// lookupCommand returns the spec of a command by name, nil if unknown
+func lookupCommand(name string) *commandSpec {
+	return commandTable[strings.ToUpper(name)]
+}
+
+// keys returns the keys in the words of a command, its name first
+func (spec *commandSpec) keys(words []string) []string {
+	if spec.getKeys != nil {
+		return spec.getKeys(words)
+	}
+	if spec.firstKey == 0 || spec.firstKey >= len(words) {
+		return nil
+	}
+	last := spec.lastKey
+	if last < 0 {
+		last += len(words)
+	}
+	last = min(last, len(words)-1)
+	var keys []string
+	for i := spec.firstKey; i <= last; i += spec.keyStep {
+		keys = append(keys, words[i])
+	}
+	return keys
+}
+
+// checkArity reports if cmd has a valid number of arguments for a known command
+func checkArity(cmd *parser.Command) error {
+	spec := lookupCommand(cmd.Name)
+	if spec == nil {
+		return fmt.Errorf("ERR unknown command '%s'", cmd.Name)
+	}
+	words := len(cmd.Args) + 1
+	if (spec.arity > 0 && words != spec.arity) || (spec.arity < 0 && words < -spec.arity) {
+		return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name))
+	}
+	return nil
+}
+
+// isDenyOOM reports if a command may grow the dataset
+func isDenyOOM(name string) bool {
+	spec := lookupCommand(name)
+	return spec != nil && spec.flags&CMD_DENYOOM != 0
+}
+
+// isWrite reports if a command may modify the dataset
+func isWrite(name string) bool {
+	spec := lookupCommand(name)
+	return spec != nil && spec.flags&CMD_WRITE != 0
+}
+
+// ExecuteCommand runs cmd against the store and writes its reply to out.
+// Only the writes evict keys, so the evictions are propagated in order with
+// them, see takeEvictions.
+func ExecuteCommand(cmd *parser.Command, store *store.Store, out io.Writer) {
+	if isWrite(cmd.Name) {
+		if err := store.FreeMemoryIfNeeded(); err != nil && isDenyOOM(cmd.Name) {
+			fmt.Fprintf(out, "-%v\r\n", err)
+			return
+		}
+	}
 	switch strings.ToUpper(cmd.Name) {
 	case "BGSAVE":
-		bgSaveCmd := command.NewBgSaveCommand(cmd, store)
+		bgSaveCmd := command.NewBgSaveCommand(cmd, store, out)
 		bgSaveCmd.Execute()
 	case "SET":
-		setCmd := command.NewSetCommand(cmd, store)
+		setCmd := command.NewSetCommand(cmd, store, out)
 		setCmd.Execute()
 	case "GET":
-		getCmd := command.NewGetCommand(cmd, store)
+		getCmd := command.NewGetCommand(cmd, store, out)
 		getCmd.Execute()
 	case "DEL":
-		delCmd := command.NewDelCommand(cmd, store)
+		delCmd := command.NewDelCommand(cmd, store, out)
 		delCmd.Execute()
 	case "EXISTS":
-		existsCmd := command.NewExistsCommand(cmd, store)
-		existsCmd.Execute()	
+		existsCmd := command.NewExistsCommand(cmd, store, out)
+		existsCmd.Execute()
 	case "TTL":
-		ttlCmd := command.NewTtlCommand(cmd, store)
+		ttlCmd := command.NewTtlCommand(cmd, store, out)
 		ttlCmd.Execute()
 	case "EXPIRE":
-		expireCmd := command.NewExpireCommand(cmd, store)
-		expireCmd.Execute()	
+		expireCmd := command.NewExpireCommand(cmd, store, out)
+		expireCmd.Execute()
 	case "EXPIREAT":
-		expireAtCmd := command.NewExpireAtCommand(cmd, store)
+		expireAtCmd := command.NewExpireAtCommand(cmd, store, out)
 		expireAtCmd.Execute()
 	case "PERSIST":
-		persistCmd := command.NewPersistCommand(cmd, store)
+		persistCmd := command.NewPersistCommand(cmd, store, out)
 		persistCmd.Execute()
-			return
+		return
 	case "INCRBY":
-		incrByCmd := command.NewIncreByCommand(cmd, store)
+		incrByCmd := command.NewIncreByCommand(cmd, store, out)
 		incrByCmd.Execute()
 	case "DECRBY":
-		decrByCmd := command.NewDecreByCommand(cmd, store)
+		decrByCmd := command.NewDecreByCommand(cmd, store, out)
 		decrByCmd.Execute()
+	case "COPY":
+		copyCmd := command.NewCopyCommand(cmd, store, out)
+		copyCmd.Execute()
+	case "OBJECT":
+		objectCmd := command.NewObjectCommand(cmd, store, out)
+		objectCmd.Execute()
+	case "MEMORY":
+		memoryCmd := command.NewMemoryCommand(cmd, store, out)
+		memoryCmd.Execute()
+	case "PUBLISH", "SPUBLISH":
+		publishCmd := command.NewPublishCommand(cmd, pubSub, out)
+		publishCmd.Execute()
+	case "PUBSUB":
+		pubsubCmd := command.NewPubsubCommand(cmd, pubSub, out)
+		pubsubCmd.Execute()
+	case "TYPE":
+		typeCmd := command.NewTypeCommand(cmd, store, out)
+		typeCmd.Execute()
+	case "DUMP":
+		dumpCmd := command.NewDumpCommand(cmd, store, out)
+		dumpCmd.Execute()
+	case "RESTORE", "RESTORE-ASKING":
+		restoreCmd := command.NewRestoreCommand(cmd, store, out)
+		restoreCmd.Execute()
+	case "LPUSH", "RPUSH":
+		pushCmd := command.NewPushCommand(cmd, store, out)
+		pushCmd.Execute()
+	case "LPOP", "RPOP":
+		popCmd := command.NewPopCommand(cmd, store, out)
+		popCmd.Execute()
+	case "LLEN":
+		llenCmd := command.NewLlenCommand(cmd, store, out)
+		llenCmd.Execute()
+	case "LRANGE":
+		lrangeCmd := command.NewLrangeCommand(cmd, store, out)
+		lrangeCmd.Execute()
+	case "LMOVE":
+		lmoveCmd := command.NewLmoveCommand(cmd, store, out)
+		lmoveCmd.Execute()
+	case "ZADD":
+		zaddCmd := command.NewZaddCommand(cmd, store, out)
+		zaddCmd.Execute()
+	case "ZPOPMIN":
+		zpopminCmd := command.NewZpopminCommand(cmd, store, out)
+		zpopminCmd.Execute()
+	case "ZRANGE":
+		zrangeCmd := command.NewZrangeCommand(cmd, store, out)
+		zrangeCmd.Execute()
+	case "ZCARD":
+		zcardCmd := command.NewZcardCommand(cmd, store, out)
+		zcardCmd.Execute()
+	case "FUNCTION":
+		functionCommand(resp.WriterFor(out), cmd.Args)
 	}
 }
 
-func ExecuteCommandIntegration(cmd *parser.Command, store *store.Store) {
-	fmt.Println("Executing command:", cmd)
+func ExecuteCommandIntegration(cmd *parser.Command, store *store.Store, out io.Writer) {
 	switch strings.ToUpper(cmd.Name) {
 
 	case "SET":
-		setCmd := command.NewSetCommand(cmd, store)
+		setCmd := command.NewSetCommand(cmd, store, out)
 		setCmd.Execute()
 	case "GET":
-		getCmd := command.NewGetCommand(cmd, store)
+		getCmd := command.NewGetCommand(cmd, store, out)
 		getCmd.Execute()
 	case "DEL":
-		delCmd := command.NewDelCommand(cmd, store)
+		delCmd := command.NewDelCommand(cmd, store, out)
 		delCmd.Execute()
 	case "EXISTS":
-		existsCmd := command.NewExistsCommand(cmd, store)
+		existsCmd := command.NewExistsCommand(cmd, store, out)
 		existsCmd.Execute()
 	case "TTL":
-		ttlCmd := command.NewTtlCommand(cmd, store)
+		ttlCmd := command.NewTtlCommand(cmd, store, out)
 		ttlCmd.Execute()
 	case "EXPIRE":
-		expireCmd := command.NewExpireCommand(cmd, store)
+		expireCmd := command.NewExpireCommand(cmd, store, out)
 		expireCmd.Execute()
 	case "EXPIREAT":
-		expireAtCmd := command.NewExpireAtCommand(cmd, store)
+		expireAtCmd := command.NewExpireAtCommand(cmd, store, out)
 		expireAtCmd.Execute()
 	case "PERSIST":
-		persistCmd := command.NewPersistCommand(cmd, store)
+		persistCmd := command.NewPersistCommand(cmd, store, out)
 		persistCmd.Execute()
 	case "INCRBY":
-		incrByCmd := command.NewIncreByCommand(cmd, store)
+		incrByCmd := command.NewIncreByCommand(cmd, store, out)
 		incrByCmd.Execute()
 	case "DECRBY":
-		decrByCmd := command.NewDecreByCommand(cmd, store)
+		decrByCmd := command.NewDecreByCommand(cmd, store, out)
 		decrByCmd.Execute()
 	}
 }
-
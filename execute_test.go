@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"fmt"
 	"testing"
 	"time"
@@ -322,6 +323,40 @@ func TestExecuteCommand(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "COPY command - existing key",
+			command: &parser.Command{
+				Name: "COPY",
+				Args: []string{"testkey", "copykey"},
+			},
+			setup: func(s *store.Store) {
+				s.SetValue("testkey", "testvalue")
+			},
+			verify: func(s *store.Store) {
+				if value := s.GetValue("copykey"); value != "testvalue" {
+					t.Errorf("Expected 'testvalue', got %v", value)
+				}
+				if !s.Exists("testkey") {
+					t.Error("Expected source key to still exist after COPY")
+				}
+			},
+		},
+		{
+			name: "COPY command - destination exists without REPLACE",
+			command: &parser.Command{
+				Name: "COPY",
+				Args: []string{"testkey", "copykey"},
+			},
+			setup: func(s *store.Store) {
+				s.SetValue("testkey", "testvalue")
+				s.SetValue("copykey", "othervalue")
+			},
+			verify: func(s *store.Store) {
+				if value := s.GetValue("copykey"); value != "othervalue" {
+					t.Errorf("Expected 'othervalue', got %v", value)
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -335,7 +370,7 @@ func TestExecuteCommand(t *testing.T) {
 			}
 
 			// Execute the command
-			ExecuteCommand(tt.command, testStore)
+			ExecuteCommand(tt.command, testStore, io.Discard)
 
 			// Run verification if provided
 			if tt.verify != nil {
@@ -355,7 +390,7 @@ func TestExecuteCommandIntegration(t *testing.T) {
 		ExecuteCommand(&parser.Command{
 			Name: "SET",
 			Args: []string{"integration_test", "integration_value"},
-		}, testStore)
+		}, testStore, io.Discard)
 		
 		if !testStore.Exists("integration_test") {
 			t.Error("Key should exist after SET")
@@ -365,37 +400,37 @@ func TestExecuteCommandIntegration(t *testing.T) {
 		ExecuteCommand(&parser.Command{
 			Name: "GET",
 			Args: []string{"integration_test"},
-		}, testStore)
+		}, testStore, io.Discard)
 
 		// EXISTS
 		ExecuteCommand(&parser.Command{
 			Name: "EXISTS",
 			Args: []string{"integration_test"},
-		}, testStore)
+		}, testStore, io.Discard)
 
 		// EXPIRE
 		ExecuteCommand(&parser.Command{
 			Name: "EXPIRE",
 			Args: []string{"integration_test", "7200"}, // 2 hours
-		}, testStore)
+		}, testStore, io.Discard)
 
 		// TTL
 		ExecuteCommand(&parser.Command{
 			Name: "TTL",
 			Args: []string{"integration_test"},
-		}, testStore)
+		}, testStore, io.Discard)
 
 		// DEL
 		ExecuteCommand(&parser.Command{
 			Name: "DEL",
 			Args: []string{"integration_test"},
-		}, testStore)
+		}, testStore, io.Discard)
 
 		// EXISTS (should return false now)
 		ExecuteCommand(&parser.Command{
 			Name: "EXISTS",
 			Args: []string{"integration_test"},
-		}, testStore)
+		}, testStore, io.Discard)
 
 		if testStore.Exists("integration_test") {
 			t.Error("Key should not exist after DEL")
@@ -411,7 +446,7 @@ func TestExecuteCommandEdgeCases(t *testing.T) {
 		ExecuteCommand(&parser.Command{
 			Name: "",
 			Args: []string{},
-		}, testStore)
+		}, testStore, io.Discard)
 	})
 
 	t.Run("Unknown command", func(t *testing.T) {
@@ -419,7 +454,7 @@ func TestExecuteCommandEdgeCases(t *testing.T) {
 		ExecuteCommand(&parser.Command{
 			Name: "UNKNOWN",
 			Args: []string{"arg1", "arg2"},
-		}, testStore)
+		}, testStore, io.Discard)
 	})
 
 	t.Run("Commands with insufficient arguments", func(t *testing.T) {
@@ -427,12 +462,12 @@ func TestExecuteCommandEdgeCases(t *testing.T) {
 		ExecuteCommand(&parser.Command{
 			Name: "GET",
 			Args: []string{}, // No key provided
-		}, testStore)
+		}, testStore, io.Discard)
 
 		ExecuteCommand(&parser.Command{
 			Name: "SET",
 			Args: []string{"key"}, // No value provided
-		}, testStore)
+		}, testStore, io.Discard)
 	})
 }
 
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// version is the server version reported to clients
+const version = "0.1.0"
+
+// validClientName reports if name can be used as a client name: names are
+// shown space separated by the introspection commands
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// hello handles HELLO [protover [AUTH username password] [SETNAME clientname]]:
+// it switches the connection to the protocol version asked for, and replies
+// with a map describing the server, in that version
+func (c *Client) hello(args []string) {
+	w := c.writer()
+	proto := int(c.resp.Load())
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			w.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if version != 2 && version != 3 {
+			w.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = version
+	}
+
+	// every option is checked before any is applied
+	var username, password, name string
+	auth, setName := false, false
+	for i := 1; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if remaining < 2 {
+				w.WriteError("ERR Syntax error in HELLO option 'auth'")
+				return
+			}
+			auth, username, password = true, args[i+1], args[i+2]
+			i += 2
+		case "SETNAME":
+			if remaining < 1 {
+				w.WriteError("ERR Syntax error in HELLO option 'setname'")
+				return
+			}
+			setName, name = true, args[i+1]
+			i++
+		default:
+			w.WriteError("ERR Syntax error in HELLO option '" + args[i] + "'")
+			return
+		}
+	}
+	if setName && !validClientName(name) {
+		w.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+		return
+	}
+	if auth {
+		if err := c.authenticate(username, password); err != nil {
+			w.WriteError(err.Error())
+			return
+		}
+	} else if c.authRequired() {
+		w.WriteError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+	if setName {
+		c.setName(name)
+	}
+
+	c.resp.Store(int32(proto))
+	w = c.writer()
+	w.WriteMapLen(7)
+	w.WriteBulkString("server")
+	w.WriteBulkString("yakvs")
+	w.WriteBulkString("version")
+	w.WriteBulkString(version)
+	w.WriteBulkString("proto")
+	w.WriteInteger(int64(proto))
+	w.WriteBulkString("id")
+	w.WriteInteger(c.id)
+	w.WriteBulkString("mode")
+	if sentinelEnabled() {
+		w.WriteBulkString("sentinel")
+		w.WriteBulkString("role")
+		w.WriteBulkString("master")
+	} else if clusterEnabled() {
+		w.WriteBulkString("cluster")
+		w.WriteBulkString("role")
+		w.WriteBulkString(replicationRole())
+	} else {
+		w.WriteBulkString("standalone")
+		w.WriteBulkString("role")
+		w.WriteBulkString(replicationRole())
+	}
+	w.WriteBulkString("modules")
+	w.WriteArrayLen(0)
+}
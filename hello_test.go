@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// helloReply returns the reply to HELLO for a client, id excluded
+func helloReply(proto string) (string, string) {
+	head := "$6\r\nserver\r\n$5\r\nyakvs\r\n$7\r\nversion\r\n$5\r\n" + version + "\r\n$5\r\nproto\r\n:" + proto + "\r\n$2\r\nid\r\n:"
+	tail := "$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"
+	if proto == "3" {
+		return "%7\r\n" + head, tail
+	}
+	return "*14\r\n" + head, tail
+}
+
+// expectHello reads a HELLO reply, whatever the client id
+func (c *testConn) expectHello(proto string) {
+	c.t.Helper()
+	head, tail := helloReply(proto)
+	c.expect(head)
+	id, err := c.reader.ReadString('\n')
+	if err != nil || !strings.HasSuffix(id, "\r\n") {
+		c.t.Fatalf("Error reading the client id: %q %v", id, err)
+	}
+	c.expect(tail)
+}
+
+func TestHello(t *testing.T) {
+	addr := startServer(t)
+
+	t.Run("RESP3 replies", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("HELLO", "3")
+		c.expectHello("3")
+		c.send("GET", "hello:missing")
+		c.expect("_\r\n")
+		c.send("ZADD", "hello:zset", "1.5", "a")
+		c.expect(":1\r\n")
+		c.send("ZPOPMIN", "hello:zset")
+		c.expect("*2\r\n$1\r\na\r\n,1.5\r\n")
+		c.send("PUBSUB", "NUMSUB", "hello:chan")
+		c.expect("%1\r\n$10\r\nhello:chan\r\n:0\r\n")
+	})
+
+	t.Run("back to RESP2", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("HELLO")
+		c.expectHello("2")
+		c.send("HELLO", "3")
+		c.expectHello("3")
+		c.send("HELLO", "2")
+		c.expectHello("2")
+		c.send("GET", "hello:missing")
+		c.expect("$-1\r\n")
+	})
+
+	t.Run("RESP3 subscribers get pushes and can run any command", func(t *testing.T) {
+		subscriber := dial(t, addr)
+		publisher := dial(t, addr)
+		subscriber.send("HELLO", "3")
+		subscriber.expectHello("3")
+		subscriber.send("SUBSCRIBE", "hello:news")
+		subscriber.expect(">3\r\n$9\r\nsubscribe\r\n$10\r\nhello:news\r\n:1\r\n")
+		subscriber.send("GET", "hello:missing")
+		subscriber.expect("_\r\n")
+		publisher.send("PUBLISH", "hello:news", "hi")
+		publisher.expect(":1\r\n")
+		subscriber.expect(">3\r\n$7\r\nmessage\r\n$10\r\nhello:news\r\n$2\r\nhi\r\n")
+	})
+
+	t.Run("options", func(t *testing.T) {
+		c := NewClient(kvStore, nil, io.Discard)
+		run(c, "HELLO 3 AUTH default secret SETNAME worker-1")
+		if c.resp.Load() != 3 || c.name != "worker-1" {
+			t.Errorf("Expected RESP3 and the name worker-1, got RESP%d and %q", c.resp.Load(), c.name)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("HELLO", "three")
+		c.expect("-ERR Protocol version is not an integer or out of range\r\n")
+		c.send("HELLO", "4")
+		c.expect("-NOPROTO unsupported protocol version\r\n")
+		c.send("HELLO", "3", "AUTH", "default")
+		c.expect("-ERR Syntax error in HELLO option 'auth'\r\n")
+		c.send("HELLO", "3", "SETNAME", "two words")
+		c.expect("-ERR Client names cannot contain spaces, newlines or special characters.\r\n")
+		c.send("HELLO", "3", "AUTH", "alice", "secret")
+		c.expect("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+		c.send("HELLO", "3", "VERBOSE")
+		c.expect("-ERR Syntax error in HELLO option 'VERBOSE'\r\n")
+		// a failed HELLO leaves the protocol as it was
+		c.send("GET", "hello:missing")
+		c.expect("$-1\r\n")
+	})
+}
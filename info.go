@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+// infoSection is a section of INFO, with the function writing it
+type infoSection struct {
+	name  string
+	write func(b *strings.Builder)
+	extra bool // only given by INFO all/everything or by name
+}
+
+// infoSections are the sections of INFO in order
+var infoSections = []infoSection{
+	{name: "server", write: writeServerInfo},
+	{name: "clients", write: writeClientsInfo},
+	{name: "memory", write: writeMemoryInfo},
+	{name: "persistence", write: writePersistenceInfo},
+	{name: "stats", write: writeStatsInfo},
+	{name: "replication", write: writeReplicationInfo},
+	{name: "cpu", write: writeCPUInfo},
+	{name: "commandstats", write: writeCommandStats, extra: true},
+	{name: "latencystats", write: writeLatencyStats, extra: true},
+	{name: "cluster", write: writeClusterInfoSection},
+	{name: "keyspace", write: writeKeyspaceInfo},
+}
+
+// startTime is when the server started, for the uptime
+var startTime = time.Now()
+
+// serverStats are the counters of INFO stats kept by the server, the store
+// keeps its own. CONFIG RESETSTAT resets them.
+var serverStats struct {
+	connections  atomic.Int64 // connections accepted
+	commands     atomic.Int64 // commands processed
+	errorReplies atomic.Int64 // commands replying with an error, run or rejected
+}
+
+// commandStat is the line of a command in INFO commandstats
+type commandStat struct {
+	calls    atomic.Int64
+	usec     atomic.Int64
+	rejected atomic.Int64 // refused before running, by the ACLs or a wrong arity
+	failed   atomic.Int64 // ran and replied with an error
+
+	histogram latencyHistogram // with latency-tracking, see latency.go
+}
+
+// commandStats holds the commandStat of the commands called since startup,
+// by lower case name
+var commandStats sync.Map
+
+// commandStatFor returns the commandStat of the command, nil for the
+// commands that don't exist so they can't fill the map
+func commandStatFor(name string) *commandStat {
+	name = strings.ToLower(name)
+	if stat, ok := commandStats.Load(name); ok {
+		return stat.(*commandStat)
+	}
+	if lookupCommand(name) == nil {
+		return nil
+	}
+	stat, _ := commandStats.LoadOrStore(name, &commandStat{})
+	return stat.(*commandStat)
+}
+
+// recordCall accounts a command called at start, whose reply begins at
+// replyStart of the reply of the client, logs it if it was slow and sends
+// it to the monitors. The time the client was blocked doesn't count.
+func (c *Client) recordCall(cmd *parser.Command, start time.Time, replyStart int) {
+	name := strings.ToUpper(cmd.Name)
+	duration := time.Since(start) - c.blockedTime
+	c.blockedTime = 0
+	reply := c.reply.Bytes()
+	failed := len(reply) > replyStart && (reply[replyStart] == '-' || reply[replyStart] == '!')
+
+	serverStats.commands.Add(1)
+	if failed {
+		serverStats.errorReplies.Add(1)
+	}
+	if stat := commandStatFor(name); stat != nil {
+		stat.calls.Add(1)
+		stat.usec.Add(duration.Microseconds())
+		if failed {
+			stat.failed.Add(1)
+		}
+		if latencyTracking.Load() {
+			stat.histogram.record(duration)
+		}
+	}
+
+	// EXEC isn't logged, the commands it ran are
+	if name != "EXEC" {
+		c.slowlogPushEntryIfNeeded(append([]string{cmd.Name}, cmd.Args...), duration)
+	}
+	if monitors.count.Load() > 0 {
+		c.feedMonitors(cmd)
+	}
+	event := LATENCY_EVENT_COMMAND
+	if spec := lookupCommand(name); spec != nil && spec.flags&CMD_FAST != 0 {
+		event = LATENCY_EVENT_FAST_COMMAND
+	}
+	latencyAddSampleIfNeeded(event, duration)
+}
+
+// commandRejected accounts a command refused before running
+func commandRejected(name string) {
+	serverStats.errorReplies.Add(1)
+	if stat := commandStatFor(name); stat != nil {
+		stat.rejected.Add(1)
+	}
+}
+
+// resetServerStats resets the counters of the server and of the commands
+func resetServerStats() {
+	serverStats.connections.Store(0)
+	serverStats.commands.Store(0)
+	serverStats.errorReplies.Store(0)
+	commandStats.Range(func(name, stat any) bool {
+		commandStats.Delete(name)
+		return true
+	})
+}
+
+// bytesToHuman formats a number of bytes like the *_human fields of Redis
+func bytesToHuman(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.2fK", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.2fM", float64(n)/(1024*1024))
+	default:
+		return fmt.Sprintf("%.2fG", float64(n)/(1024*1024*1024))
+	}
+}
+
+// serverMode returns the mode of the server as HELLO reports it
+func serverMode() string {
+	if sentinelEnabled() {
+		return "sentinel"
+	} else if clusterEnabled() {
+		return "cluster"
+	}
+	return "standalone"
+}
+
+func writeServerInfo(b *strings.Builder) {
+	now := time.Now()
+	uptime := int64(now.Sub(startTime).Seconds())
+	executable, _ := os.Executable()
+	configState.mu.Lock()
+	configFile := configState.file
+	configState.mu.Unlock()
+
+	b.WriteString("# Server\r\n")
+	fmt.Fprintf(b, "yakvs_version:%s\r\nyakvs_mode:%s\r\n", version, serverMode())
+	fmt.Fprintf(b, "os:%s %s\r\narch_bits:%d\r\ngo_version:%s\r\n", runtime.GOOS, runtime.GOARCH, 32<<(^uint(0)>>63), runtime.Version())
+	fmt.Fprintf(b, "process_id:%d\r\ntcp_port:%d\r\n", os.Getpid(), listeningPort)
+	fmt.Fprintf(b, "server_time_usec:%d\r\nuptime_in_seconds:%d\r\nuptime_in_days:%d\r\n", now.UnixMicro(), uptime, uptime/(24*3600))
+	fmt.Fprintf(b, "executable:%s\r\nconfig_file:%s\r\n", executable, configFile)
+}
+
+// clientCounts returns the number of clients connected, blocked and
+// subscribed, the replicas aside
+func clientCounts() (int, int, int) {
+	connected, blocked, pubsub := 0, 0, 0
+	clients.mu.Lock()
+	for _, c := range clients.byID {
+		if c.listed.replica {
+			continue
+		}
+		connected++
+		if c.listed.blocked {
+			blocked++
+		}
+		if c.listed.sub+c.listed.psub+c.listed.ssub > 0 {
+			pubsub++
+		}
+	}
+	clients.mu.Unlock()
+	return connected, blocked, pubsub
+}
+
+func writeClientsInfo(b *strings.Builder) {
+	connected, blocked, pubsub := clientCounts()
+	b.WriteString("# Clients\r\n")
+	fmt.Fprintf(b, "connected_clients:%d\r\nblocked_clients:%d\r\npubsub_clients:%d\r\n", connected, blocked, pubsub)
+}
+
+func writeMemoryInfo(b *strings.Builder) {
+	used, peak, limit := kvStore.UsedMemory(), kvStore.PeakMemory(), kvStore.GetMaxMemory()
+	b.WriteString("# Memory\r\n")
+	fmt.Fprintf(b, "used_memory:%d\r\nused_memory_human:%s\r\n", used, bytesToHuman(used))
+	fmt.Fprintf(b, "used_memory_peak:%d\r\nused_memory_peak_human:%s\r\n", peak, bytesToHuman(peak))
+	fmt.Fprintf(b, "maxmemory:%d\r\nmaxmemory_human:%s\r\nmaxmemory_policy:%s\r\n", limit, bytesToHuman(limit), kvStore.GetMaxMemoryPolicy())
+}
+
+// writePersistenceInfo writes the state of the AOF. BGSAVE doesn't write
+// a snapshot of its own, so the last save is the startup.
+func writePersistenceInfo(b *strings.Builder) {
+	b.WriteString("# Persistence\r\n")
+	// no rdb_ fields: the dataset is only persisted by the AOF
+	b.WriteString("loading:0\r\n")
+	if aofManager == nil {
+		b.WriteString("aof_enabled:0\r\n")
+		return
+	}
+	rewriteSec := int64(-1)
+	if rewrite := aofManager.LastRewriteTime(); rewrite >= 0 {
+		rewriteSec = int64(rewrite.Seconds())
+	}
+	size, baseSize := aofManager.Sizes()
+	b.WriteString("aof_enabled:1\r\naof_rewrite_in_progress:0\r\n")
+	fmt.Fprintf(b, "aof_last_rewrite_time_sec:%d\r\naof_last_bgrewrite_status:ok\r\n", rewriteSec)
+	fmt.Fprintf(b, "aof_current_size:%d\r\naof_base_size:%d\r\naof_fsync:%s\r\n", size, baseSize, aofManager.FsyncPolicy())
+}
+
+func writeStatsInfo(b *strings.Builder) {
+	b.WriteString("# Stats\r\n")
+	fmt.Fprintf(b, "total_connections_received:%d\r\ntotal_commands_processed:%d\r\n", serverStats.connections.Load(), serverStats.commands.Load())
+	fmt.Fprintf(b, "expired_keys:%d\r\nevicted_keys:%d\r\n", kvStore.ExpiredKeys(), kvStore.EvictedKeys())
+	fmt.Fprintf(b, "keyspace_hits:%d\r\nkeyspace_misses:%d\r\n", kvStore.KeyspaceHits(), kvStore.KeyspaceMisses())
+	writeReplicationStats(b)
+	fmt.Fprintf(b, "total_error_replies:%d\r\n", serverStats.errorReplies.Load())
+}
+
+func writeCPUInfo(b *strings.Builder) {
+	user, sys := processCPUTime()
+	b.WriteString("# CPU\r\n")
+	fmt.Fprintf(b, "used_cpu_sys:%.6f\r\nused_cpu_user:%.6f\r\n", sys.Seconds(), user.Seconds())
+}
+
+// writeCommandStats writes a line per command called, in name order
+func writeCommandStats(b *strings.Builder) {
+	var names []string
+	commandStats.Range(func(name, stat any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+
+	b.WriteString("# Commandstats\r\n")
+	for _, name := range names {
+		stat := commandStatFor(name)
+		calls, usec := stat.calls.Load(), stat.usec.Load()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		fmt.Fprintf(b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d\r\n",
+			name, calls, usec, perCall, stat.rejected.Load(), stat.failed.Load())
+	}
+}
+
+// writeKeyspaceInfo writes the line of the only database, once it has keys
+func writeKeyspaceInfo(b *strings.Builder) {
+	b.WriteString("# Keyspace\r\n")
+	if keys := kvStore.KeyCount(); keys > 0 {
+		fmt.Fprintf(b, "db0:keys=%d,expires=%d,avg_ttl=%d\r\n", keys, kvStore.ExpiresCount(), kvStore.AvgTTL())
+	}
+}
+
+// infoCommand handles INFO [section ...]: the default sections when none is
+// given, all of them with all or everything
+func infoCommand(w *resp.Writer, args []string) {
+	wanted := make(map[string]bool)
+	all, defaults := false, len(args) == 0
+	for _, arg := range args {
+		section := strings.ToLower(arg)
+		switch section {
+		case "all", "everything":
+			all = true
+		case "default":
+			defaults = true
+		}
+		wanted[section] = true
+	}
+	var b strings.Builder
+	for _, section := range infoSections {
+		if all || defaults && !section.extra || wanted[section.name] {
+			if b.Len() > 0 {
+				b.WriteString("\r\n")
+			}
+			section.write(&b)
+		}
+	}
+	w.WriteVerbatim("txt", b.String())
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInfoSections(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("INFO")
+	text := c.readBulk()
+	var headers []string
+	for _, line := range strings.Split(text, "\r\n") {
+		if strings.HasPrefix(line, "# ") {
+			headers = append(headers, line[2:])
+		}
+	}
+	expected := "Server Clients Memory Persistence Stats Replication CPU Cluster Keyspace"
+	if got := strings.Join(headers, " "); got != expected {
+		t.Errorf("Expected the sections %s, got %s", expected, got)
+	}
+	c.send("INFO", "all")
+	if text := c.readBulk(); !strings.Contains(text, "# Commandstats\r\n") {
+		t.Errorf("Expected the commandstats in INFO all, got %s", text)
+	}
+	c.send("INFO", "SERVER", "memory")
+	if text := c.readBulk(); !strings.HasPrefix(text, "# Server\r\n") || !strings.Contains(text, "\r\n\r\n# Memory\r\n") || strings.Contains(text, "# Clients") {
+		t.Errorf("Expected the server and memory sections, got %s", text)
+	}
+
+	server := c.info("server")
+	if server["yakvs_version"] != version || server["process_id"] == "" || server["yakvs_mode"] != "standalone" {
+		t.Errorf("Unexpected server section %v", server)
+	}
+	before, _ := strconv.Atoi(c.info("clients")["connected_clients"])
+	other := dial(t, addr)
+	other.send("PING")
+	other.expect("+PONG\r\n")
+	if after, _ := strconv.Atoi(c.info("clients")["connected_clients"]); after != before+1 {
+		t.Errorf("Expected %d connected clients, got %d", before+1, after)
+	}
+	if memory := c.info("memory"); memory["used_memory"] != strconv.FormatInt(kvStore.UsedMemory(), 10) || memory["maxmemory_policy"] == "" {
+		t.Errorf("Unexpected memory section %v", memory)
+	}
+	if persistence := c.info("persistence"); persistence["aof_enabled"] != "0" || persistence["loading"] != "0" || persistence["rdb_last_bgsave_status"] != "" {
+		t.Errorf("Unexpected persistence section %v", persistence)
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	resetConfig(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+	kvStore.Flush()
+
+	c.send("CONFIG", "RESETSTAT")
+	c.expect("+OK\r\n")
+	c.send("SET", "info:a", "1")
+	c.expect("+OK\r\n")
+	c.send("SET", "info:b", "2")
+	c.expect("+OK\r\n")
+	c.send("EXPIRE", "info:b", "100")
+	c.expect("+OK\r\n")
+	c.send("GET", "info:a")
+	c.expect("$1\r\n1\r\n")
+	c.send("GET", "info:missing")
+	c.expect("$-1\r\n")
+	c.send("LPUSH", "info:a", "x")
+	c.expect("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	c.send("INCRBY", "info:a", "x")
+	c.expect("-ERR value is not an integer or out of range\r\n")
+	c.send("GET")
+	c.expect("-ERR wrong number of arguments for 'get' command\r\n")
+
+	stats := c.info("stats")
+	if stats["keyspace_hits"] != "1" || stats["keyspace_misses"] != "1" || stats["total_error_replies"] != "3" {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+	// CONFIG RESETSTAT and the commands it ran, but not the rejected GET
+	if stats["total_commands_processed"] != "8" {
+		t.Errorf("Expected 8 commands processed, got %s", stats["total_commands_processed"])
+	}
+
+	commands := c.info("commandstats")
+	if !strings.HasPrefix(commands["cmdstat_set"], "calls=2,usec=") || !strings.HasSuffix(commands["cmdstat_set"], ",rejected_calls=0,failed_calls=0") {
+		t.Errorf("Unexpected SET stats %q", commands["cmdstat_set"])
+	}
+	if !strings.HasPrefix(commands["cmdstat_get"], "calls=2,") || !strings.HasSuffix(commands["cmdstat_get"], ",rejected_calls=1,failed_calls=0") {
+		t.Errorf("Unexpected GET stats %q", commands["cmdstat_get"])
+	}
+	if !strings.HasSuffix(commands["cmdstat_lpush"], ",rejected_calls=0,failed_calls=1") {
+		t.Errorf("Unexpected LPUSH stats %q", commands["cmdstat_lpush"])
+	}
+	if !strings.HasPrefix(commands["cmdstat_incrby"], "calls=1,") || !strings.HasSuffix(commands["cmdstat_incrby"], ",rejected_calls=0,failed_calls=1") {
+		t.Errorf("Unexpected INCRBY stats %q", commands["cmdstat_incrby"])
+	}
+
+	kvStore.ActiveExpireCycle()
+	keyspace := c.info("keyspace")
+	fields := strings.Split(keyspace["db0"], ",")
+	if len(fields) != 3 || fields[0] != "keys=2" || fields[1] != "expires=1" || !strings.HasPrefix(fields[2], "avg_ttl=9") {
+		t.Errorf("Unexpected keyspace %q", keyspace["db0"])
+	}
+
+	c.send("CONFIG", "RESETSTAT")
+	c.expect("+OK\r\n")
+	if commands := c.info("commandstats"); commands["cmdstat_set"] != "" {
+		t.Errorf("Expected the command stats reset, got %v", commands)
+	}
+}
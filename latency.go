@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+/*
+The latency monitor records the events taking longer than
+latency-monitor-threshold milliseconds: a sample per second and event, the
+highest latency of the second, is kept for the last LATENCY_TS_LEN seconds
+with spikes. LATENCY LATEST, HISTORY and DOCTOR report them.
+
+Independently, every command has a histogram of its latencies with
+latency-tracking, which LATENCY HISTOGRAM and INFO latencystats report.
+*/
+
+// The events of the latency monitor
+const (
+	LATENCY_EVENT_COMMAND          = "command"          // a command that isn't O(1)
+	LATENCY_EVENT_FAST_COMMAND     = "fast-command"     // an O(1) command
+	LATENCY_EVENT_AOF_FSYNC_ALWAYS = "aof-fsync-always" // the fsync of a write with appendfsync always
+	LATENCY_EVENT_AOF_FSYNC        = "aof-fsync"        // the fsync of the AOF every second
+	LATENCY_EVENT_EXPIRE_CYCLE     = "expire-cycle"     // deleting the expired keys in the background
+	LATENCY_EVENT_EVICTION_CYCLE   = "eviction-cycle"   // evicting keys to get under maxmemory
+)
+
+const (
+	LATENCY_TS_LEN                       = 160 // samples kept per event
+	LATENCY_TRACKING_PERCENTILES_DEFAULT = "50 99 99.9"
+
+	// LATENCY_HISTOGRAM_SUB_BITS splits every power of two of a histogram
+	// in 8 buckets, so a latency is known within 12.5%
+	LATENCY_HISTOGRAM_SUB_BITS = 3
+	LATENCY_HISTOGRAM_BUCKETS  = (64 - LATENCY_HISTOGRAM_SUB_BITS + 1) << LATENCY_HISTOGRAM_SUB_BITS
+)
+
+// latencyThreshold is the latency in milliseconds from which the events are
+// recorded, 0 disables the monitor
+var latencyThreshold atomic.Int64
+
+// latencyTracking enables the histograms of the commands
+var latencyTracking atomic.Bool
+
+// latencyPercentiles are the percentiles INFO latencystats reports
+var latencyPercentiles = struct {
+	mu     sync.Mutex
+	values []float64
+}{}
+
+func init() {
+	latencyTracking.Store(true)
+	setLatencyPercentiles(LATENCY_TRACKING_PERCENTILES_DEFAULT)
+}
+
+func getLatencyPercentiles() string {
+	latencyPercentiles.mu.Lock()
+	defer latencyPercentiles.mu.Unlock()
+	formatted := make([]string, len(latencyPercentiles.values))
+	for i, p := range latencyPercentiles.values {
+		formatted[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return strings.Join(formatted, " ")
+}
+
+func setLatencyPercentiles(value string) error {
+	var values []float64
+	for _, field := range strings.Fields(value) {
+		p, err := strconv.ParseFloat(field, 64)
+		if err != nil || p < 0 || p > 100 {
+			return fmt.Errorf("argument must be a list of percentiles between 0 and 100")
+		}
+		values = append(values, p)
+	}
+	latencyPercentiles.mu.Lock()
+	defer latencyPercentiles.mu.Unlock()
+	latencyPercentiles.values = values
+	return nil
+}
+
+// latencySample is the highest latency of an event in a second
+type latencySample struct {
+	time    int64 // unix time
+	latency int64 // milliseconds
+}
+
+// latencyEvent keeps the last samples of an event in a ring
+type latencyEvent struct {
+	samples [LATENCY_TS_LEN]latencySample
+	next    int   // where the next sample goes
+	max     int64 // highest latency ever
+}
+
+// latest returns the last sample of the event
+func (e *latencyEvent) latest() latencySample {
+	return e.samples[(e.next+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+}
+
+// history returns the samples of the event, oldest first
+func (e *latencyEvent) history() []latencySample {
+	var samples []latencySample
+	for i := 0; i < LATENCY_TS_LEN; i++ {
+		if sample := e.samples[(e.next+i)%LATENCY_TS_LEN]; sample.time != 0 {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+var latencyMonitor = struct {
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}{events: make(map[string]*latencyEvent)}
+
+// latencyAddSampleIfNeeded records the latency of an event if it reaches
+// the threshold
+func latencyAddSampleIfNeeded(event string, latency time.Duration) {
+	threshold := latencyThreshold.Load()
+	ms := latency.Milliseconds()
+	if threshold == 0 || ms < threshold {
+		return
+	}
+	now := time.Now().Unix()
+
+	latencyMonitor.mu.Lock()
+	defer latencyMonitor.mu.Unlock()
+	e := latencyMonitor.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		latencyMonitor.events[event] = e
+	}
+	e.max = max(e.max, ms)
+	if last := &e.samples[(e.next+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]; last.time == now {
+		last.latency = max(last.latency, ms)
+		return
+	}
+	e.samples[e.next] = latencySample{time: now, latency: ms}
+	e.next = (e.next + 1) % LATENCY_TS_LEN
+}
+
+// sortedLatencyEvents returns the names of the events with samples
+func sortedLatencyEvents() []string {
+	names := make([]string, 0, len(latencyMonitor.events))
+	for name := range latencyMonitor.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// latencyHistogram counts latencies in microseconds, in buckets growing
+// with the latencies
+type latencyHistogram struct {
+	counts [LATENCY_HISTOGRAM_BUCKETS]atomic.Int64
+}
+
+// latencyBucket returns the bucket of a latency
+func latencyBucket(usec uint64) int {
+	const sub = 1 << LATENCY_HISTOGRAM_SUB_BITS
+	if usec < sub {
+		return int(usec)
+	}
+	exp := bits.Len64(usec) - 1
+	return (exp-LATENCY_HISTOGRAM_SUB_BITS+1)<<LATENCY_HISTOGRAM_SUB_BITS | int(usec>>(exp-LATENCY_HISTOGRAM_SUB_BITS))&(sub-1)
+}
+
+// latencyBucketLow returns the lowest latency of a bucket
+func latencyBucketLow(bucket int) uint64 {
+	const sub = 1 << LATENCY_HISTOGRAM_SUB_BITS
+	if bucket < sub {
+		return uint64(bucket)
+	}
+	exp := bucket>>LATENCY_HISTOGRAM_SUB_BITS + LATENCY_HISTOGRAM_SUB_BITS - 1
+	return uint64(sub|bucket&(sub-1)) << (exp - LATENCY_HISTOGRAM_SUB_BITS)
+}
+
+// latencyBucketHigh returns the highest latency of a bucket
+func latencyBucketHigh(bucket int) uint64 {
+	if bucket == LATENCY_HISTOGRAM_BUCKETS-1 {
+		return math.MaxUint64
+	}
+	return latencyBucketLow(bucket+1) - 1
+}
+
+func (h *latencyHistogram) record(latency time.Duration) {
+	h.counts[latencyBucket(uint64(max(latency.Microseconds(), 0)))].Add(1)
+}
+
+// snapshot returns the counts of the buckets and their total
+func (h *latencyHistogram) snapshot() ([LATENCY_HISTOGRAM_BUCKETS]int64, int64) {
+	var counts [LATENCY_HISTOGRAM_BUCKETS]int64
+	var total int64
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+	return counts, total
+}
+
+// percentile returns the latency in microseconds under which p% of the
+// counted latencies are, the highest of its bucket
+func percentile(counts *[LATENCY_HISTOGRAM_BUCKETS]int64, total int64, p float64) uint64 {
+	target := int64(math.Ceil(p / 100 * float64(total)))
+	var seen int64
+	for i, count := range counts {
+		seen += count
+		if count > 0 && seen >= target {
+			return latencyBucketHigh(i)
+		}
+	}
+	return 0
+}
+
+// writeLatencyStats writes the percentiles of the latencies of every
+// command called, in name order
+func writeLatencyStats(b *strings.Builder) {
+	var names []string
+	commandStats.Range(func(name, stat any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	latencyPercentiles.mu.Lock()
+	percentiles := latencyPercentiles.values
+	latencyPercentiles.mu.Unlock()
+
+	b.WriteString("# Latencystats\r\n")
+	for _, name := range names {
+		counts, total := commandStatFor(name).histogram.snapshot()
+		if total == 0 {
+			continue
+		}
+		fields := make([]string, len(percentiles))
+		for i, p := range percentiles {
+			fields[i] = fmt.Sprintf("p%s=%.3f", strconv.FormatFloat(p, 'f', -1, 64), float64(percentile(&counts, total, p)))
+		}
+		fmt.Fprintf(b, "latency_percentiles_usec_%s:%s\r\n", name, strings.Join(fields, ","))
+	}
+}
+
+// latencyCommand handles LATENCY LATEST, HISTORY, RESET, DOCTOR, HISTOGRAM
+// and HELP
+func latencyCommand(w *resp.Writer, args []string) {
+	switch strings.ToUpper(args[0]) {
+	case "LATEST":
+		if len(args) != 1 {
+			break
+		}
+		latencyMonitor.mu.Lock()
+		defer latencyMonitor.mu.Unlock()
+		names := sortedLatencyEvents()
+		w.WriteArrayLen(len(names))
+		for _, name := range names {
+			e := latencyMonitor.events[name]
+			latest := e.latest()
+			w.WriteArrayLen(4)
+			w.WriteBulkString(name)
+			w.WriteInteger(latest.time)
+			w.WriteInteger(latest.latency)
+			w.WriteInteger(e.max)
+		}
+		return
+	case "HISTORY":
+		if len(args) != 2 {
+			break
+		}
+		latencyMonitor.mu.Lock()
+		defer latencyMonitor.mu.Unlock()
+		var samples []latencySample
+		if e := latencyMonitor.events[args[1]]; e != nil {
+			samples = e.history()
+		}
+		w.WriteArrayLen(len(samples))
+		for _, sample := range samples {
+			w.WriteArrayLen(2)
+			w.WriteInteger(sample.time)
+			w.WriteInteger(sample.latency)
+		}
+		return
+	case "RESET":
+		latencyMonitor.mu.Lock()
+		defer latencyMonitor.mu.Unlock()
+		reset := 0
+		if len(args) == 1 {
+			reset = len(latencyMonitor.events)
+			latencyMonitor.events = make(map[string]*latencyEvent)
+		}
+		for _, name := range args[1:] {
+			if latencyMonitor.events[name] != nil {
+				delete(latencyMonitor.events, name)
+				reset++
+			}
+		}
+		w.WriteInteger(int64(reset))
+		return
+	case "DOCTOR":
+		if len(args) != 1 {
+			break
+		}
+		w.WriteVerbatim("txt", latencyDoctor())
+		return
+	case "HISTOGRAM":
+		writeLatencyHistograms(w, args[1:])
+		return
+	case "HELP":
+		if len(args) != 1 {
+			break
+		}
+		help := []string{
+			"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"DOCTOR",
+			"    Return a human readable latency analysis report.",
+			"HISTORY <event>",
+			"    Return time-latency samples for the <event> class.",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes.",
+			"    (default: reset all data for all event classes)",
+			"HISTOGRAM [COMMAND ...]",
+			"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+			"    If no commands are specified then all histograms are replied.",
+			"HELP",
+			"    Prints this help.",
+		}
+		w.WriteArrayLen(len(help))
+		for _, line := range help {
+			w.WriteSimpleString(line)
+		}
+		return
+	}
+	w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try LATENCY HELP.", strings.ToLower(args[0])))
+}
+
+// writeLatencyHistograms writes the histograms of the commands called among
+// names, of every command called without names. A histogram maps powers of
+// two of microseconds to the number of calls that took less.
+func writeLatencyHistograms(w *resp.Writer, names []string) {
+	if len(names) == 0 {
+		commandStats.Range(func(name, stat any) bool {
+			names = append(names, name.(string))
+			return true
+		})
+	}
+	type histogram struct {
+		name   string
+		counts [LATENCY_HISTOGRAM_BUCKETS]int64
+		total  int64
+	}
+	var histograms []*histogram
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		stat, ok := commandStats.Load(name)
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		h := &histogram{name: name}
+		h.counts, h.total = stat.(*commandStat).histogram.snapshot()
+		if h.total > 0 {
+			histograms = append(histograms, h)
+		}
+	}
+	sort.Slice(histograms, func(i, j int) bool { return histograms[i].name < histograms[j].name })
+
+	w.WriteMapLen(len(histograms))
+	for _, h := range histograms {
+		// the cumulative counts under each power of two, once they change
+		var bounds, cumulative []int64
+		var under int64
+		for bucket, bound := 0, uint64(1); under < h.total; bound <<= 1 {
+			for ; latencyBucketHigh(bucket) < bound; bucket++ {
+				under += h.counts[bucket]
+			}
+			if len(cumulative) == 0 && under > 0 || len(cumulative) > 0 && under > cumulative[len(cumulative)-1] {
+				bounds = append(bounds, int64(bound))
+				cumulative = append(cumulative, under)
+			}
+		}
+		w.WriteBulkString(h.name)
+		w.WriteMapLen(2)
+		w.WriteBulkString("calls")
+		w.WriteInteger(h.total)
+		w.WriteBulkString("histogram_usec")
+		w.WriteMapLen(len(bounds))
+		for i := range bounds {
+			w.WriteInteger(bounds[i])
+			w.WriteInteger(cumulative[i])
+		}
+	}
+}
+
+// latencyDoctor analyzes the samples of the events and advises on how to
+// avoid the spikes
+func latencyDoctor() string {
+	latencyMonitor.mu.Lock()
+	defer latencyMonitor.mu.Unlock()
+	var b strings.Builder
+	names := sortedLatencyEvents()
+	if len(names) == 0 {
+		if threshold := latencyThreshold.Load(); threshold > 0 {
+			fmt.Fprintf(&b, "No latency spike was observed above the latency-monitor-threshold of %d milliseconds.\n", threshold)
+		} else {
+			b.WriteString("The latency monitor is disabled, so there is nothing to report. Enable it with CONFIG SET latency-monitor-threshold <milliseconds>.\n")
+		}
+		return b.String()
+	}
+
+	b.WriteString("Latency spikes were observed for the following events:\n\n")
+	for i, name := range names {
+		e := latencyMonitor.events[name]
+		samples := e.history()
+		var sum int64
+		for _, sample := range samples {
+			sum += sample.latency
+		}
+		avg := sum / int64(len(samples))
+		var deviation int64
+		for _, sample := range samples {
+			deviation += max(sample.latency-avg, avg-sample.latency)
+		}
+		deviation /= int64(len(samples))
+		period := "a single spike"
+		if len(samples) > 1 {
+			seconds := float64(samples[len(samples)-1].time-samples[0].time) / float64(len(samples)-1)
+			period = fmt.Sprintf("period %.2f sec", seconds)
+		}
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, %s). Worst all time event %dms.\n",
+			i+1, name, len(samples), avg, deviation, period, e.max)
+	}
+
+	b.WriteString("\nI have a few pieces of advice for you:\n\n")
+	advised := make(map[string]bool)
+	for _, name := range names {
+		var advice string
+		switch name {
+		case LATENCY_EVENT_COMMAND:
+			advice = "Check SLOWLOG GET for the slow commands: the commands working on whole big values (LRANGE, ZRANGE...) and long scripts block the other clients meanwhile."
+		case LATENCY_EVENT_FAST_COMMAND:
+			advice = "Even O(1) commands were slow, the host may be overloaded: check the CPU used in INFO cpu."
+		case LATENCY_EVENT_AOF_FSYNC_ALWAYS:
+			advice = "The disk is slow to fsync the AOF and appendfsync always waits for it on every write: appendfsync everysec moves the fsync out of the commands."
+		case LATENCY_EVENT_AOF_FSYNC:
+			advice = "The disk is slow to fsync the AOF, which the clients calling WAITAOF wait for: a faster disk, or one the AOF doesn't share, helps."
+		case LATENCY_EVENT_EXPIRE_CYCLE:
+			advice = "Many keys expire at the same time: spread their expires, adding a few random seconds to the TTLs."
+		case LATENCY_EVENT_EVICTION_CYCLE:
+			advice = "Evicting keys to stay under maxmemory takes long: raise maxmemory, or lower maxmemory-samples."
+		}
+		if advice != "" && !advised[advice] {
+			advised[advice] = true
+			fmt.Fprintf(&b, "- %s\n", advice)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	for _, usec := range []uint64{0, 1, 7, 8, 9, 15, 16, 100, 1000, 123456, 1 << 40} {
+		bucket := latencyBucket(usec)
+		if low, high := latencyBucketLow(bucket), latencyBucketHigh(bucket); usec < low || usec > high {
+			t.Errorf("%d: expected in bucket %d, from %d to %d", usec, bucket, low, high)
+		}
+		if high := latencyBucketHigh(bucket); usec >= 8 && float64(high-latencyBucketLow(bucket)+1) > float64(usec)/8+1 {
+			t.Errorf("%d: bucket %d too wide", usec, bucket)
+		}
+	}
+	for bucket := 1; bucket < LATENCY_HISTOGRAM_BUCKETS; bucket++ {
+		if latencyBucketLow(bucket) != latencyBucketHigh(bucket-1)+1 {
+			t.Fatalf("Expected bucket %d to follow bucket %d", bucket, bucket-1)
+		}
+	}
+
+	var h latencyHistogram
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+	counts, total := h.snapshot()
+	if total != 100 {
+		t.Fatalf("Expected 100 latencies, got %d", total)
+	}
+	if p := percentile(&counts, total, 50); p < 50 || p > 55 {
+		t.Errorf("Expected the median around 50, got %d", p)
+	}
+	if p := percentile(&counts, total, 100); p < 100 || p > 103 {
+		t.Errorf("Expected the maximum around 100, got %d", p)
+	}
+}
+
+func TestLatencyMonitor(t *testing.T) {
+	resetConfig(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("LATENCY", "RESET")
+	c.readLine()
+	c.send("LATENCY", "DOCTOR")
+	if text := c.readBulk(); !strings.HasPrefix(text, "The latency monitor is disabled") {
+		t.Errorf("Expected the monitor disabled, got %q", text)
+	}
+	latencyAddSampleIfNeeded(LATENCY_EVENT_EXPIRE_CYCLE, time.Second)
+	c.send("LATENCY", "LATEST")
+	c.expect("*0\r\n")
+
+	c.send("CONFIG", "SET", "latency-monitor-threshold", "100")
+	c.expect("+OK\r\n")
+	latencyAddSampleIfNeeded(LATENCY_EVENT_EXPIRE_CYCLE, 50*time.Millisecond)
+	latencyAddSampleIfNeeded(LATENCY_EVENT_EXPIRE_CYCLE, 200*time.Millisecond)
+	latencyAddSampleIfNeeded(LATENCY_EVENT_EXPIRE_CYCLE, 150*time.Millisecond)
+	latencyAddSampleIfNeeded(LATENCY_EVENT_EVICTION_CYCLE, 300*time.Millisecond)
+
+	// the samples of a second are merged, keeping the highest
+	c.send("LATENCY", "LATEST")
+	c.expect("*2\r\n")
+	c.expect("*4\r\n$14\r\neviction-cycle\r\n")
+	c.readLine()
+	c.expect(":300\r\n:300\r\n")
+	c.expect("*4\r\n$12\r\nexpire-cycle\r\n")
+	c.readLine()
+	c.expect(":200\r\n:200\r\n")
+	c.send("LATENCY", "HISTORY", "expire-cycle")
+	c.expect("*1\r\n*2\r\n")
+	c.readLine()
+	c.expect(":200\r\n")
+	c.send("LATENCY", "HISTORY", "nosuch")
+	c.expect("*0\r\n")
+
+	c.send("LATENCY", "DOCTOR")
+	if text := c.readBulk(); !strings.Contains(text, "expire-cycle: 1 latency spikes") || !strings.Contains(text, "maxmemory") {
+		t.Errorf("Unexpected report %q", text)
+	}
+	c.send("LATENCY", "RESET", "expire-cycle", "nosuch")
+	c.expect(":1\r\n")
+	c.send("LATENCY", "RESET")
+	c.expect(":1\r\n")
+	c.send("LATENCY", "NOSUCH")
+	c.expect("-ERR unknown subcommand or wrong number of arguments for 'nosuch'. Try LATENCY HELP.\r\n")
+}
+
+func TestLatencyStats(t *testing.T) {
+	resetConfig(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("CONFIG", "RESETSTAT")
+	c.expect("+OK\r\n")
+	c.send("SET", "latency:key", "value")
+	c.expect("+OK\r\n")
+	c.send("SET", "latency:key", "value")
+	c.expect("+OK\r\n")
+
+	stats := c.info("latencystats")
+	if line := stats["latency_percentiles_usec_set"]; !strings.HasPrefix(line, "p50=") || !strings.Contains(line, ",p99=") || !strings.Contains(line, ",p99.9=") {
+		t.Errorf("Unexpected SET percentiles %q", line)
+	}
+	c.send("CONFIG", "SET", "latency-tracking-info-percentiles", "90")
+	c.expect("+OK\r\n")
+	if line := c.info("latencystats")["latency_percentiles_usec_set"]; !strings.HasPrefix(line, "p90=") || strings.Contains(line, ",") {
+		t.Errorf("Expected the 90th percentile only, got %q", line)
+	}
+	c.send("CONFIG", "SET", "latency-tracking-info-percentiles", "101")
+	if line := c.readLine(); !strings.HasPrefix(line, "-ERR") {
+		t.Errorf("Expected an error, got %q", line)
+	}
+
+	c.send("LATENCY", "HISTOGRAM", "set", "nosuch")
+	c.expect("*2\r\n$3\r\nset\r\n*4\r\n$5\r\ncalls\r\n:2\r\n$14\r\nhistogram_usec\r\n")
+	if line := c.readLine(); !strings.HasPrefix(line, "*") || line == "*0" {
+		t.Errorf("Expected the buckets of the histogram, got %q", line)
+	}
+}
@@ -4,20 +4,27 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/shubhdevelop/YAKVS/aof"
 	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/pubsub"
 	"github.com/shubhdevelop/YAKVS/store"
-	"github.com/shubhdevelop/YAKVS/utils"
 )
 
 var aofManager *aof.AOFManager
 var kvStore *store.Store
+var pubSub = pubsub.New()
 
 func runPrompt() {
 	// Use regular reader for line-by-line input
 	reader := bufio.NewReader(os.Stdin)
+	client := NewClient(kvStore, aofManager, os.Stdout)
+	client.authenticated = true // the prompt is local
 
 	for {
 		fmt.Print(">> ")
@@ -40,54 +47,166 @@ func runPrompt() {
 			break
 		}
 
-		resp, err := utils.ToRESP(line[:len(line)-1])
+		// the words are split like the inline commands of the clients
+		words, err := parser.SplitArgs(line)
 		if err != nil {
-			fmt.Printf("Error converting to RESP: %v\n", err)
+			fmt.Printf("-ERR %v\r\n", err)
 			continue
 		}
-		// Try to detect if it's RESP format
-		if utils.IsRESPFormat(resp) {
-				// Preprocess input to convert literal \r\n to actual control characters
-			// processedInput := utils.PreprocessInput(resp)
-			parser := parser.NewStreamingParser([]byte(resp))
-			fmt.Println("Parsing RESP command:", resp)
-			command, err := parser.ParseCommand()
-			if err != nil {
-				fmt.Printf("Error parsing RESP command: %v\n", err)
-			}
-			// check if command should be persisted
-			if aofManager.ShouldPersistCommand(command.Name) {
-				err := aofManager.WriteCommand(resp)
-				if err != nil {
-					log.Fatalf("failed to write to AOF file: %v", err)
-				}
-			}
-			ExecuteCommand(command, kvStore)
+		if len(words) == 0 {
+			continue
 		}
+		// the client persists the commands modifying data
+		client.ProcessCommand(&parser.Command{Name: words[0], Args: words[1:]})
 	}
 }
 
 func init() {
-	// Initialize AOF manager
-	aofManager = aof.NewAOFManager("base.aof")
-	err := aofManager.Initialize()
-	if err != nil {
-		log.Fatalf("Error initializing AOF manager: %v", err)
-	}
 	// Initialize store
 	kvStore = store.NewStore()
+	kvStore.SetPublisher(pubSub)
+	kvStore.SetModifiedKeyHook(signalKeyAsReady)
+	kvStore.SetEvictedKeyHook(addEviction)
+	kvStore.SetEvictionLatencyHook(func(latency time.Duration) {
+		latencyAddSampleIfNeeded(LATENCY_EVENT_EVICTION_CYCLE, latency)
+	})
+}
+
+// serverCron runs the background tasks of the server, 10 times per second
+func serverCron() {
+	for range time.Tick(100 * time.Millisecond) {
+		// delete the expired keys nobody reads anymore, unless the
+		// dataset must not change while clients are paused
+		if !clientsPaused() {
+			start := time.Now()
+			kvStore.ActiveExpireCycle()
+			latencyAddSampleIfNeeded(LATENCY_EVENT_EXPIRE_CYCLE, time.Since(start))
+		}
+		replicationCron()
+		aofCron()
+		if clusterEnabled() {
+			clusterCron()
+		}
+	}
 }
 
 func main() {
+	if err := parseConfig(os.Args[1:]); err != nil {
+		log.Fatalf("Error loading the configuration: %v", err)
+	}
+
 	fmt.Println("YAKVS")
+	if *config.sentinel {
+		if *config.port <= 0 {
+			log.Fatal("Sentinel mode needs a -port")
+		}
+		initSentinel()
+		if err := applyConfig(); err != nil {
+			log.Fatalf("Error setting %v", err)
+		}
+		listeningPort = *config.port
+		sentinel.mu.Lock()
+		sentinel.downAfter = time.Duration(*config.downAfter) * time.Millisecond
+		sentinel.failoverTimeout = time.Duration(*config.failoverTimeout) * time.Millisecond
+		for _, monitor := range config.sentinelMonitors {
+			fields := strings.Fields(monitor)
+			if len(fields) != 4 {
+				log.Fatalf("Error parsing sentinel-monitor, expected \"name host port quorum\": %q", monitor)
+			}
+			masterPort, err1 := strconv.Atoi(fields[2])
+			quorum, err2 := strconv.Atoi(fields[3])
+			if err1 != nil || err2 != nil {
+				log.Fatalf("Error parsing sentinel-monitor, expected \"name host port quorum\": %q", monitor)
+			}
+			if err := sentinelMonitor(fields[0], fields[1], masterPort, quorum); err != nil {
+				log.Fatalf("Error monitoring %s: %v", fields[0], err)
+			}
+		}
+		sentinel.mu.Unlock()
+		go sentinelTimer()
+
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", *config.port))
+		if err != nil {
+			log.Fatalf("Error listening on port %d: %v", *config.port, err)
+		}
+		fmt.Printf("Sentinel ready to accept connections on port %d\n", *config.port)
+		log.Fatal(serve(ln))
+	}
+	if *config.clusterEnabled {
+		if *config.port <= 0 {
+			log.Fatal("Cluster mode needs a -port")
+		}
+		if *config.replicaOf != "" {
+			log.Fatal("replicaof directive not allowed in cluster mode")
+		}
+		kvStore.EnableSlotIndex()
+	}
+	// Initialize AOF manager
+	aofManager = aof.NewAOFManager(*config.appendFilename)
+	aofManager.SetFsyncLatencyHook(func(latency time.Duration, always bool) {
+		if always {
+			latencyAddSampleIfNeeded(LATENCY_EVENT_AOF_FSYNC_ALWAYS, latency)
+		} else {
+			latencyAddSampleIfNeeded(LATENCY_EVENT_AOF_FSYNC, latency)
+		}
+		metrics.fsyncDuration.record(latency)
+	})
+	if err := aofManager.Initialize(); err != nil {
+		log.Fatalf("Error initializing AOF manager: %v", err)
+	}
 	// Read and execute commands from AOF file
 	err := aofManager.ReadAndExecuteCommands(func(cmd *parser.Command) {
-		ExecuteCommand(cmd, kvStore)
+		ExecuteCommand(cmd, kvStore, os.Stdout)
 	})
 	if err != nil {
 		log.Fatalf("Error reading AOF file: %v", err)
 	}
 
+	// limits are applied after loading so the AOF is never partially evicted
+	if err := applyConfig(); err != nil {
+		log.Fatalf("Error setting %v", err)
+	}
+	acl.file = *config.aclFile
+	if acl.file != "" {
+		if _, err := os.Stat(acl.file); err == nil {
+			if err := loadACLFile(acl.file); err != nil {
+				log.Fatalf("Error loading ACL file: %v", err)
+			}
+		}
+	}
+	listeningPort = *config.port
+	if *config.replicaOf != "" {
+		host, masterPort, ok := strings.Cut(*config.replicaOf, " ")
+		portNumber, err := strconv.Atoi(masterPort)
+		if !ok || err != nil {
+			log.Fatalf("Error parsing replicaof, expected \"host port\": %q", *config.replicaOf)
+		}
+		replicationSetMaster(host, portNumber)
+	}
+	if *config.clusterEnabled {
+		timeout := time.Duration(*config.clusterNodeTimeout) * time.Millisecond
+		busPort := *config.clusterPort
+		if busPort == 0 {
+			busPort = *config.port + CLUSTER_PORT_INCR
+		}
+		if err := initCluster(*config.clusterConfigFile, *config.port, busPort, timeout); err != nil {
+			log.Fatalf("Error initializing cluster: %v", err)
+		}
+	}
+
+	go serverCron()
+	if *config.metricsPort > 0 {
+		startMetrics(*config.metricsPort)
+	}
+
+	if *config.port > 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", *config.port))
+		if err != nil {
+			log.Fatalf("Error listening on port %d: %v", *config.port, err)
+		}
+		fmt.Printf("Ready to accept connections on port %d\n", *config.port)
+		log.Fatal(serve(ln))
+	}
 	runPrompt()
 	defer aofManager.Close()
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+With metrics-port the server serves over HTTP:
+
+	/metrics  the metrics in the Prometheus text format
+	/healthz  OK while the server runs
+
+The metrics are read from the counters INFO reports, only the durations
+of the fsyncs and snapshots are kept for them alone.
+*/
+
+// metricsDurationBuckets are the upper bounds of the buckets of the
+// duration histograms, in seconds
+var metricsDurationBuckets = [...]float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// durationHistogram counts durations in the buckets of
+// metricsDurationBuckets, the last count being the durations above them
+type durationHistogram struct {
+	counts [len(metricsDurationBuckets) + 1]atomic.Int64
+	sum    atomic.Int64 // nanoseconds
+}
+
+func (h *durationHistogram) record(d time.Duration) {
+	i := sort.SearchFloat64s(metricsDurationBuckets[:], d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+var metrics struct {
+	fsyncDuration        durationHistogram // every fsync of the AOF
+	snapshotSaveDuration durationHistogram // writing a snapshot for a replica
+	snapshotLoadDuration durationHistogram // loading the snapshot of the master
+}
+
+// resetMetrics resets the durations, CONFIG RESETSTAT calls it
+func resetMetrics() {
+	for _, h := range []*durationHistogram{&metrics.fsyncDuration, &metrics.snapshotSaveDuration, &metrics.snapshotLoadDuration} {
+		for i := range h.counts {
+			h.counts[i].Store(0)
+		}
+		h.sum.Store(0)
+	}
+}
+
+// serveMetrics serves the metrics and the health check until the listener
+// is closed
+func serveMetrics(ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, "OK\n")
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return server.Serve(ln)
+}
+
+// startMetrics listens on the port of the metrics, and serves them in the
+// background
+func startMetrics(port int) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Fatalf("Error listening on metrics port %d: %v", port, err)
+	}
+	fmt.Printf("Serving metrics on port %d\n", port)
+	go func() {
+		log.Fatal(serveMetrics(ln))
+	}()
+}
+
+// metricsWriter writes metrics in the Prometheus text format
+type metricsWriter struct {
+	w io.Writer
+}
+
+// header writes the help and type of a metric
+func (m metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a value of a metric, labels being name and value pairs
+func (m metricsWriter) sample(name string, value float64, labels ...string) {
+	io.WriteString(m.w, name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+		}
+		io.WriteString(m.w, "{"+strings.Join(pairs, ",")+"}")
+	}
+	fmt.Fprintf(m.w, " %s\n", formatMetricValue(value))
+}
+
+// metric writes a metric with a single value
+func (m metricsWriter) metric(name, typ, help string, value float64) {
+	m.header(name, typ, help)
+	m.sample(name, value)
+}
+
+// histogram writes the samples of a histogram, the header of the metric
+// must be written before
+func (m metricsWriter) histogram(name string, h *durationHistogram, labels ...string) {
+	var cumulative int64
+	for i, bound := range metricsDurationBuckets {
+		cumulative += h.counts[i].Load()
+		m.sample(name+"_bucket", float64(cumulative), append(labels, "le", formatMetricValue(bound))...)
+	}
+	cumulative += h.counts[len(metricsDurationBuckets)].Load()
+	m.sample(name+"_bucket", float64(cumulative), append(labels, "le", "+Inf")...)
+	m.sample(name+"_sum", time.Duration(h.sum.Load()).Seconds(), labels...)
+	m.sample(name+"_count", float64(cumulative), labels...)
+}
+
+// escapeLabelValue escapes the backslashes, quotes and newlines of a label
+// value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writeMetrics writes every metric of the server
+func writeMetrics(w io.Writer) {
+	m := metricsWriter{w}
+	connected, blocked, _ := clientCounts()
+
+	m.metric("yakvs_uptime_seconds", "gauge", "Seconds since the server started.", time.Since(startTime).Seconds())
+	m.metric("yakvs_connected_clients", "gauge", "Clients connected, replicas excluded.", float64(connected))
+	m.metric("yakvs_blocked_clients", "gauge", "Clients blocked by a blocking command.", float64(blocked))
+	m.metric("yakvs_connections_received_total", "counter", "Connections accepted.", float64(serverStats.connections.Load()))
+	m.metric("yakvs_commands_processed_total", "counter", "Commands processed.", float64(serverStats.commands.Load()))
+	m.metric("yakvs_error_replies_total", "counter", "Commands replying with an error, run or rejected.", float64(serverStats.errorReplies.Load()))
+
+	var names []string
+	commandStats.Range(func(name, stat any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	m.header("yakvs_commands_total", "counter", "Calls of each command.")
+	for _, name := range names {
+		m.sample("yakvs_commands_total", float64(commandStatFor(name).calls.Load()), "cmd", name)
+	}
+	m.header("yakvs_commands_duration_seconds_total", "counter", "Time spent running each command.")
+	for _, name := range names {
+		m.sample("yakvs_commands_duration_seconds_total", float64(commandStatFor(name).usec.Load())/1e6, "cmd", name)
+	}
+	m.header("yakvs_commands_errors_total", "counter", "Calls of each command rejected before running or failed.")
+	for _, name := range names {
+		stat := commandStatFor(name)
+		m.sample("yakvs_commands_errors_total", float64(stat.rejected.Load()), "cmd", name, "reason", "rejected")
+		m.sample("yakvs_commands_errors_total", float64(stat.failed.Load()), "cmd", name, "reason", "failed")
+	}
+
+	m.header("yakvs_db_keys", "gauge", "Keys of each database.")
+	m.sample("yakvs_db_keys", float64(kvStore.KeyCount()), "db", "0")
+	m.header("yakvs_db_keys_expiring", "gauge", "Keys with an expire of each database.")
+	m.sample("yakvs_db_keys_expiring", float64(kvStore.ExpiresCount()), "db", "0")
+	m.metric("yakvs_expired_keys_total", "counter", "Keys deleted when they expired.", float64(kvStore.ExpiredKeys()))
+	m.metric("yakvs_evicted_keys_total", "counter", "Keys evicted to stay under maxmemory.", float64(kvStore.EvictedKeys()))
+	m.metric("yakvs_keyspace_hits_total", "counter", "Lookups of existing keys.", float64(kvStore.KeyspaceHits()))
+	m.metric("yakvs_keyspace_misses_total", "counter", "Lookups of missing keys.", float64(kvStore.KeyspaceMisses()))
+	m.metric("yakvs_memory_used_bytes", "gauge", "Memory used by the dataset.", float64(kvStore.UsedMemory()))
+	m.metric("yakvs_memory_max_bytes", "gauge", "The maxmemory limit, 0 without one.", float64(kvStore.GetMaxMemory()))
+
+	if aofManager != nil {
+		size, baseSize := aofManager.Sizes()
+		m.metric("yakvs_aof_size_bytes", "gauge", "Size of the AOF.", float64(size))
+		m.metric("yakvs_aof_base_size_bytes", "gauge", "Size of the AOF at startup or after the last rewrite.", float64(baseSize))
+	}
+	m.header("yakvs_aof_fsync_duration_seconds", "histogram", "Duration of the fsyncs of the AOF.")
+	m.histogram("yakvs_aof_fsync_duration_seconds", &metrics.fsyncDuration)
+	m.header("yakvs_snapshot_duration_seconds", "histogram", "Duration of the snapshots written for replicas and loaded from the master.")
+	m.histogram("yakvs_snapshot_duration_seconds", &metrics.snapshotSaveDuration, "op", "save")
+	m.histogram("yakvs_snapshot_duration_seconds", &metrics.snapshotLoadDuration, "op", "load")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getMetrics returns the body of a page of the metrics server
+func getMetrics(t *testing.T, addr, path string) string {
+	t.Helper()
+	res, err := http.Get("http://" + addr + path)
+	if err != nil {
+		t.Fatalf("Error getting %s: %v", path, err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected %s to be served, got %s", path, res.Status)
+	}
+	return string(body)
+}
+
+func TestDurationHistogram(t *testing.T) {
+	var h durationHistogram
+	h.record(50 * time.Microsecond)
+	h.record(time.Millisecond)
+	h.record(3 * time.Millisecond)
+	h.record(time.Minute)
+
+	var b strings.Builder
+	m := metricsWriter{&b}
+	m.histogram("test_seconds", &h, "op", `a"b`)
+	lines := strings.Split(b.String(), "\n")
+	expected := map[string]bool{
+		`test_seconds_bucket{op="a\"b",le="0.0001"} 1`: true,
+		`test_seconds_bucket{op="a\"b",le="0.001"} 2`:  true,
+		`test_seconds_bucket{op="a\"b",le="0.005"} 3`:  true,
+		`test_seconds_bucket{op="a\"b",le="10"} 3`:     true,
+		`test_seconds_bucket{op="a\"b",le="+Inf"} 4`:   true,
+		`test_seconds_sum{op="a\"b"} 60.00405`:         true,
+		`test_seconds_count{op="a\"b"} 4`:              true,
+	}
+	for _, line := range lines {
+		delete(expected, line)
+	}
+	if len(expected) > 0 {
+		t.Errorf("Expected the lines %v, got %s", expected, b.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	kvStore.Flush()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	go serveMetrics(ln)
+	t.Cleanup(func() { ln.Close() })
+
+	c.send("CONFIG", "RESETSTAT")
+	c.expect("+OK\r\n")
+	c.send("SET", "metrics:a", "1")
+	c.expect("+OK\r\n")
+	c.send("SET", "metrics:b", "2")
+	c.expect("+OK\r\n")
+	c.send("EXPIRE", "metrics:b", "100")
+	c.expect("+OK\r\n")
+	c.send("LPUSH", "metrics:a", "x")
+	c.expect("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	c.send("INCRBY", "metrics:a", "x")
+	c.expect("-ERR value is not an integer or out of range\r\n")
+
+	text := getMetrics(t, ln.Addr().String(), "/metrics")
+	for _, line := range []string{
+		"# TYPE yakvs_commands_total counter",
+		`yakvs_commands_total{cmd="set"} 2`,
+		`yakvs_commands_errors_total{cmd="lpush",reason="failed"} 1`,
+		`yakvs_commands_errors_total{cmd="incrby",reason="failed"} 1`,
+		"yakvs_error_replies_total 2",
+		`yakvs_db_keys{db="0"} 2`,
+		`yakvs_db_keys_expiring{db="0"} 1`,
+		"# TYPE yakvs_aof_fsync_duration_seconds histogram",
+		`yakvs_snapshot_duration_seconds_count{op="save"} 0`,
+	} {
+		if !strings.Contains(text, "\n"+line+"\n") {
+			t.Errorf("Expected %q in the metrics, got %s", line, text)
+		}
+	}
+	if !strings.Contains(text, "\nyakvs_connected_clients ") || !strings.Contains(text, "\nyakvs_memory_used_bytes ") {
+		t.Errorf("Expected the clients and memory in the metrics, got %s", text)
+	}
+
+	if body := getMetrics(t, ln.Addr().String(), "/healthz"); body != "OK\n" {
+		t.Errorf("Expected OK, got %q", body)
+	}
+}
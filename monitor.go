@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+)
+
+// MONITOR_OUTPUT_BUFFER_LIMIT is the number of bytes a monitor may have
+// waiting to be sent before it is disconnected, the hard limit of the
+// replicas in Redis
+const MONITOR_OUTPUT_BUFFER_LIMIT = 256 * 1024 * 1024
+
+// monitors are the clients which sent MONITOR, they get a line for every
+// command processed
+var monitors = struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+	count   atomic.Int32 // len(clients), so the commands don't take mu without monitors
+}{clients: make(map[*Client]struct{})}
+
+// monitor handles MONITOR: the reply goes straight to the connection, so
+// it can't be overtaken by the first command monitored
+func (c *Client) monitor() {
+	if c.flags&CLIENT_MULTI != 0 {
+		fmt.Fprint(&c.reply, "-ERR MONITOR isn't allowed for DENY BLOCKING client\r\n")
+		return
+	}
+	fmt.Fprint(&c.reply, "+OK\r\n")
+	if c.conn == nil || c.flags&CLIENT_MONITOR != 0 {
+		return // the prompt has no connection to stream to
+	}
+	c.flush()
+	c.flags |= CLIENT_MONITOR
+
+	monitors.mu.Lock()
+	monitors.clients[c] = struct{}{}
+	monitors.count.Store(int32(len(monitors.clients)))
+	monitors.mu.Unlock()
+	clients.mu.Lock()
+	c.listed.monitor = true
+	clients.mu.Unlock()
+}
+
+// removeMonitor stops sending the commands to c
+func removeMonitor(c *Client) {
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+	delete(monitors.clients, c)
+	monitors.count.Store(int32(len(monitors.clients)))
+}
+
+// feedMonitors sends the command c ran to the monitors, like Redis:
+//
+//	+1700000000.123456 [0 127.0.0.1:50000] "set" "key" "value"
+//
+// The administrative commands aren't sent, and the passwords are redacted.
+func (c *Client) feedMonitors(cmd *parser.Command) {
+	if spec := lookupCommand(cmd.Name); spec == nil || spec.flags&CMD_ADMIN != 0 {
+		return
+	}
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "+%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, c.monitorAddr())
+	for _, arg := range redactArgs(append([]string{cmd.Name}, cmd.Args...)) {
+		b.WriteByte(' ')
+		b.WriteString(quoteMonitorArg(arg))
+	}
+	b.WriteString("\r\n")
+	line := []byte(b.String())
+
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+	for m := range monitors.clients {
+		m.out.Write(line)
+		if m.conn.Pending() > MONITOR_OUTPUT_BUFFER_LIMIT {
+			log.Printf("Client %s closed for overcoming of output buffer limits.", m.conn.RemoteAddr())
+			m.conn.Close()
+		}
+	}
+}
+
+// monitorAddr returns where the commands of c come from
+func (c *Client) monitorAddr() string {
+	switch {
+	case c.flags&CLIENT_SCRIPT != 0:
+		return "lua"
+	case c.flags&CLIENT_MASTER != 0:
+		return "master"
+	case c.conn == nil:
+		return "local"
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// quoteMonitorArg quotes an argument with the escapes of C strings, the
+// bytes that aren't printable given in hexadecimal
+func quoteMonitorArg(arg string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; {
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\a':
+			b.WriteString(`\a`)
+		case c == '\b':
+			b.WriteString(`\b`)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestQuoteMonitorArg(t *testing.T) {
+	tests := map[string]string{
+		"value":          `"value"`,
+		"":               `""`,
+		`say "hi"\`:      `"say \"hi\"\\"`,
+		"a\r\nb\t\a\b":   `"a\r\nb\t\a\b"`,
+		"\x00\x7f\xff é": `"\x00\x7f\xff \xc3\xa9"`,
+	}
+	for arg, expected := range tests {
+		if quoted := quoteMonitorArg(arg); quoted != expected {
+			t.Errorf("%q: expected %s, got %s", arg, expected, quoted)
+		}
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	addr := startServer(t)
+	m := dial(t, addr)
+	c := dial(t, addr)
+
+	m.send("MONITOR")
+	m.expect("+OK\r\n")
+
+	c.send("SET", "monitor:key", "with space\n")
+	c.expect("+OK\r\n")
+	line := m.readLine()
+	pattern := regexp.MustCompile(`^\+\d+\.\d{6} \[0 ([^\]]+)\] "SET" "monitor:key" "with space\\n"$`)
+	match := pattern.FindStringSubmatch(line)
+	if match == nil || match[1] != c.conn.LocalAddr().String() {
+		t.Fatalf("Unexpected line %q", line)
+	}
+
+	// the administrative commands aren't shown, the passwords are redacted
+	c.send("CONFIG", "GET", "maxmemory")
+	c.readLine()
+	c.readBulk()
+	c.readBulk()
+	c.send("AUTH", "secret")
+	c.readLine()
+	if line := m.readLine(); !strings.HasSuffix(line, `] "AUTH" "(redacted)"`) {
+		t.Errorf("Expected AUTH redacted, got %q", line)
+	}
+
+	c.send("MULTI")
+	c.expect("+OK\r\n")
+	c.send("GET", "monitor:key")
+	c.expect("+QUEUED\r\n")
+	c.send("EXEC")
+	c.expect("*1\r\n$11\r\nwith space\n\r\n")
+	c.send("EVAL", "return redis.call('GET', KEYS[1])", "1", "monitor:key")
+	c.expect("$11\r\nwith space\n\r\n")
+	for _, expected := range []string{`"MULTI"`, `"GET" "monitor:key"`, `"EXEC"`, `[0 lua] "GET" "monitor:key"`, `"EVAL"`} {
+		if line := m.readLine(); !strings.Contains(line, expected) {
+			t.Errorf("Expected %s, got %q", expected, line)
+		}
+	}
+
+	c.send("CLIENT", "LIST")
+	if list := c.readBulk(); !strings.Contains(list, " flags=O ") {
+		t.Errorf("Expected the monitor flagged, got %s", list)
+	}
+	c.send("MULTI")
+	c.expect("+OK\r\n")
+	c.send("MONITOR")
+	c.expect("+QUEUED\r\n")
+	c.send("EXEC")
+	c.expect("*1\r\n-ERR MONITOR isn't allowed for DENY BLOCKING client\r\n")
+	m.readLine() // MULTI
+	m.readLine() // EXEC
+
+	m.conn.Close()
+	eventually(t, "the monitor removed", func() bool { return monitors.count.Load() == 0 })
+}
@@ -25,7 +25,7 @@ func (p *StreamingParser) ParseCommand() (*Command, error) {
 	}
 
 	switch p.buf[p.pos] {
-		case '*', '~', '>', '%': 
+		case '*', '~', '>', '%', '|':
 			return p.ParseArray(), nil
 		case '$':
 			return p.parseBulkString(), nil
@@ -35,82 +35,19 @@ func (p *StreamingParser) ParseCommand() (*Command, error) {
 }
 
 
+// ParseArray parses an aggregate value as a command: its first word is the
+// name and the others the arguments, nested values and maps flattened in
+// order (see Value.Words)
 func (p *StreamingParser) ParseArray() *Command {
-	p.pos++ // skip '*'
-	arraySizeBytes, err := p.readUntilCRLF()
+	value, err := p.ParseValue()
 	if err != nil {
 		log.Fatal(err)
 	}
-	arraySize, err := strconv.Atoi(string(arraySizeBytes))
-	if err != nil {
-		log.Fatal(err)
+	words := value.Words()
+	if len(words) == 0 {
+		return &Command{Name: "", Args: []string{}}
 	}
-
-	command := &Command{
-		Name: "",
-		Args: make([]string, 0, arraySize),
-	}
-
-	for i := 0; i < arraySize; i++ {
-		if p.pos >= p.len {
-			log.Fatal("unexpected end of input while parsing array")
-		}
-		switch p.buf[p.pos] {
-		case '*', '~', '>', '%': // Array
-			subCommand := p.ParseArray()
-			if i == 0 {
-				command.Name = subCommand.Name
-				command.Args = append(command.Args, subCommand.Args...)
-			} else {
-				// For nested arrays, add the name as an argument, then add all args
-				command.Args = append(command.Args, subCommand.Name)
-				command.Args = append(command.Args, subCommand.Args...)
-			}
-		case ':': // Integer
-			integerStr := p.ParseInteger()
-			if i == 0 {
-				command.Name = integerStr.Name
-			} else {
-				command.Args = append(command.Args, integerStr.Name)
-			}
-		case '$': // Bulk string
-			bulkStr := p.parseBulkString()
-			if i == 0 {
-				command.Name = bulkStr.Name
-			} else {
-				command.Args = append(command.Args, bulkStr.Name)
-			}
-		case '-', '+':
-			simpleStr := p.parseSimpleString()
-			if i == 0 {
-				command.Name = simpleStr.Name
-			} else {
-				command.Args = append(command.Args, simpleStr.Name)
-			}
-		case '#': // Boolean
-			boolStr := p.ParseBoolean()
-			if i == 0 {
-				command.Name = boolStr.Name
-			} else {
-				command.Args = append(command.Args, boolStr.Name)
-			}
-		case '!': // Blob error
-			blobErr := p.parseBlobError()
-			if i == 0 {
-				command.Name = blobErr.Name
-			} else {
-				command.Args = append(command.Args, blobErr.Name)
-			}
-		case '_': // Null
-			nullStr := p.ParseNull()
-			if i == 0 {
-				command.Name = nullStr.Name
-			} else {
-				command.Args = append(command.Args, nullStr.Name)
-			}
-		}
-	}
-	return command
+	return &Command{Name: words[0], Args: words[1:]}
 }
 func (p *StreamingParser) readUntilCRLF() ([]byte, error) {
 	curr := p.pos
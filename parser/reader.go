@@ -0,0 +1,262 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// ErrProtocol is wrapped by the errors returned for malformed input
+var ErrProtocol = errors.New("Protocol error")
+
+const (
+	MAX_MULTIBULK_LEN = 1024 * 1024       // max number of arguments of a command
+	MAX_BULK_LEN      = 512 * 1024 * 1024 // max size of an argument
+	MAX_INLINE_LEN    = 64 * 1024         // max size of an inline command
+
+	// the limits of the clients which didn't authenticate yet, as in Redis
+	MAX_UNAUTH_MULTIBULK_LEN = 10
+	MAX_UNAUTH_BULK_LEN      = 16 * 1024
+
+	// the memory taken ahead of the input, so a length announced by a client
+	// isn't allocated before it is sent
+	MAX_PREALLOC_ARGS = 1024
+	BULK_READ_CHUNK   = 64 * 1024
+)
+
+// ReadCommand reads a command sent by a client, a RESP array of bulk
+// strings or an inline command: a line of space separated words, like one
+// typed in telnet. Unlike StreamingParser it reads from a connection, so it
+// doesn't need the whole input upfront. Malformed input returns an error
+// wrapping ErrProtocol, after which the connection can't be trusted anymore.
+func ReadCommand(r *bufio.Reader) (*Command, error) {
+	return readCommand(r, MAX_MULTIBULK_LEN, MAX_BULK_LEN, "invalid")
+}
+
+// ReadUnauthenticatedCommand reads a command like ReadCommand, for a client
+// which didn't authenticate yet: it may only send a few small arguments.
+func ReadUnauthenticatedCommand(r *bufio.Reader) (*Command, error) {
+	return readCommand(r, MAX_UNAUTH_MULTIBULK_LEN, MAX_UNAUTH_BULK_LEN, "unauthenticated")
+}
+
+// readCommand reads a command with at most maxArgs arguments of maxBulk
+// bytes, the lengths above them are reported as what
+func readCommand(r *bufio.Reader, maxArgs int, maxBulk int, what string) (*Command, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		return readInlineCommand(r)
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+	if count > maxArgs {
+		return nil, fmt.Errorf("%w: %s multibulk length", ErrProtocol, what)
+	}
+	if count <= 0 {
+		return &Command{Args: []string{}}, nil
+	}
+
+	words := make([]string, 0, min(count, MAX_PREALLOC_ARGS))
+	for i := 0; i < count; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%c'", ErrProtocol, line[0])
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+		if size > maxBulk {
+			return nil, fmt.Errorf("%w: %s bulk length", ErrProtocol, what)
+		}
+		word, err := readBulk(r, size)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+	}
+	return &Command{Name: words[0], Args: words[1:]}, nil
+}
+
+// readBulk reads a bulk string of size bytes and its CRLF. The buffer grows
+// as the bytes arrive, by BULK_READ_CHUNK at most.
+func readBulk(r *bufio.Reader, size int) (string, error) {
+	buf := make([]byte, 0, min(size+2, BULK_READ_CHUNK))
+	for len(buf) < size+2 {
+		n := min(size+2-len(buf), BULK_READ_CHUNK)
+		buf = slices.Grow(buf, n)
+		if _, err := io.ReadFull(r, buf[len(buf):len(buf)+n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		buf = buf[:len(buf)+n]
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return "", fmt.Errorf("%w: expected CRLF after bulk string", ErrProtocol)
+	}
+	return string(buf[:size]), nil
+}
+
+// readLine reads a CRLF terminated line, without the CRLF
+func readLine(r lineReader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: too big request line", ErrProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("%w: invalid line ending", ErrProtocol)
+	}
+	return string(line[:len(line)-2]), nil
+}
+
+// readInlineCommand reads a command sent as a line of words, ending with LF
+// or CRLF. An empty line is an empty command.
+func readInlineCommand(r *bufio.Reader) (*Command, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > MAX_INLINE_LEN {
+			return nil, fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF // the line started but didn't end
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+
+	words, err := SplitArgs(string(line))
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return &Command{Args: []string{}}, nil
+	}
+	return &Command{Name: words[0], Args: words[1:]}, nil
+}
+
+// SplitArgs splits a line into words the way redis-cli does. Words are
+// separated by spaces, a word in double quotes can hold spaces and the
+// escapes \n, \r, \t, \b, \a and \xHH, one in single quotes only \'. A
+// closing quote must end the word.
+func SplitArgs(line string) ([]string, error) {
+	errUnbalanced := fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+	words := []string{}
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return words, nil
+		}
+
+		var word []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; i++ {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, errUnbalanced
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					word = append(word, hexValue(line[i+2])<<4|hexValue(line[i+3]))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						word = append(word, '\n')
+					case 'r':
+						word = append(word, '\r')
+					case 't':
+						word = append(word, '\t')
+					case 'b':
+						word = append(word, '\b')
+					case 'a':
+						word = append(word, '\a')
+					default:
+						word = append(word, line[i])
+					}
+				case c == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalanced
+					}
+					done = true
+				default:
+					word = append(word, c)
+				}
+			case inSingle:
+				if i == len(line) {
+					return nil, errUnbalanced
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					word = append(word, '\'')
+					i++
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalanced
+					}
+					done = true
+				default:
+					word = append(word, c)
+				}
+			case i == len(line) || isSpace(line[i]):
+				done = true
+			case line[i] == '"':
+				inDouble = true
+			case line[i] == '\'':
+				inSingle = true
+			default:
+				word = append(word, line[i])
+			}
+		}
+		words = append(words, string(word))
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f' || c == 0
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	}
+	return c - 'a' + 10
+}
@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	t.Run("reads pipelined commands", func(t *testing.T) {
+		input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n*1\r\n$4\r\nPING\r\n"
+		r := bufio.NewReader(strings.NewReader(input))
+
+		cmd, err := ReadCommand(r)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cmd.Name != "SET" || len(cmd.Args) != 2 || cmd.Args[0] != "key" || cmd.Args[1] != "va\r\nl" {
+			t.Errorf("Expected SET key va\\r\\nl, got %v", cmd)
+		}
+
+		cmd, err = ReadCommand(r)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cmd.Name != "PING" || len(cmd.Args) != 0 {
+			t.Errorf("Expected PING, got %v", cmd)
+		}
+
+		if _, err := ReadCommand(r); err != io.EOF {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("reads commands split across reads", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		go func() {
+			for _, chunk := range []string{"*2\r\n$3\r", "\nGET\r\n$", "3\r\nke", "y\r\n"} {
+				pw.Write([]byte(chunk))
+			}
+			pw.Close()
+		}()
+		cmd, err := ReadCommand(bufio.NewReader(pr))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cmd.Name != "GET" || len(cmd.Args) != 1 || cmd.Args[0] != "key" {
+			t.Errorf("Expected GET key, got %v", cmd)
+		}
+	})
+
+	t.Run("truncated input", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$3\r\nke"))
+		if _, err := ReadCommand(r); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+		}
+	})
+
+	t.Run("reads big bulk strings", func(t *testing.T) {
+		value := strings.Repeat("v", 3*BULK_READ_CHUNK+5)
+		input := "*2\r\n$4\r\nECHO\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+		cmd, err := ReadCommand(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(cmd.Args) != 1 || cmd.Args[0] != value {
+			t.Errorf("Expected the value read whole, got %d bytes", len(cmd.Args[0]))
+		}
+	})
+
+	t.Run("announced lengths aren't allocated upfront", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("*1\r\n$" + strconv.Itoa(MAX_BULK_LEN) + "\r\nshort"))
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := ReadCommand(r); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+		}
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1024*1024 {
+			t.Errorf("Expected a small allocation for a short input, got %d bytes", allocated)
+		}
+	})
+
+	t.Run("unauthenticated limits", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("*2\r\n$4\r\nAUTH\r\n$6\r\nsecret\r\n"))
+		if cmd, err := ReadUnauthenticatedCommand(r); err != nil || cmd.Name != "AUTH" {
+			t.Errorf("Expected AUTH, got %v %v", cmd, err)
+		}
+		for input, expected := range map[string]string{
+			"*11\r\n":                    "Protocol error: unauthenticated multibulk length",
+			"*1\r\n$16385\r\n":           "Protocol error: unauthenticated bulk length",
+			"*1048577\r\n":               "Protocol error: unauthenticated multibulk length",
+			"*1\r\n$536870913\r\nxx\r\n": "Protocol error: unauthenticated bulk length",
+		} {
+			_, err := ReadUnauthenticatedCommand(bufio.NewReader(strings.NewReader(input)))
+			if err == nil || err.Error() != expected {
+				t.Errorf("%q: expected %q, got %v", input, expected, err)
+			}
+		}
+	})
+
+	protocolErrors := []struct {
+		name  string
+		input string
+	}{
+		{"invalid multibulk length", "*abc\r\n"},
+		{"not a bulk string", "*1\r\n:1\r\n"},
+		{"invalid bulk length", "*1\r\n$-5\r\n"},
+		{"missing CRLF after bulk", "*1\r\n$3\r\nGETXX"},
+		{"bare LF", "*1\n"},
+		{"unbalanced inline quotes", "SET \"a b\r\n"},
+		{"too big inline command", strings.Repeat("x", MAX_INLINE_LEN+1) + "\r\n"},
+	}
+	for _, tt := range protocolErrors {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, ErrProtocol) {
+				t.Errorf("Expected a protocol error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadInlineCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PING\r\n\r\nset key \"va lue\"\nGET 'it\\'s'\r\n+OK\r\n"))
+	expected := [][]string{{"PING"}, {""}, {"set", "key", "va lue"}, {"GET", "it's"}, {"+OK"}}
+	for _, words := range expected {
+		cmd, err := ReadCommand(r)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if got := append([]string{cmd.Name}, cmd.Args...); !slices.Equal(got, words) {
+			t.Errorf("Expected %q, got %q", words, got)
+		}
+	}
+	if _, err := ReadCommand(r); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	r = bufio.NewReader(strings.NewReader("PING"))
+	if _, err := ReadCommand(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF for a line without LF, got %v", err)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"", []string{}},
+		{"   ", []string{}},
+		{"SET  key\tvalue ", []string{"SET", "key", "value"}},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key ""`, []string{"SET", "key", ""}},
+		{`"\x41\x6a\n\r\t\b\a\"\\\q"`, []string{"Aj\n\r\t\b\a\"\\q"}},
+		{`"\xZZ"`, []string{"xZZ"}},
+		{`'single \'quoted\' \n'`, []string{`single 'quoted' \n`}},
+		{`mixed"quotes"`, []string{"mixedquotes"}},
+	}
+	for _, tt := range tests {
+		words, err := SplitArgs(tt.line)
+		if err != nil {
+			t.Errorf("%q: expected no error, got %v", tt.line, err)
+			continue
+		}
+		if !slices.Equal(words, tt.expected) {
+			t.Errorf("%q: expected %q, got %q", tt.line, tt.expected, words)
+		}
+	}
+
+	for _, line := range []string{`"open`, `'open`, `"closed"x`, `'closed'x`} {
+		if _, err := SplitArgs(line); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%q: expected a protocol error, got %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+// Type is a RESP type, named after the byte its values start with
+type Type byte
+
+const (
+	SimpleString Type = '+'
+	SimpleError  Type = '-'
+	Integer      Type = ':'
+	BulkString   Type = '$'
+	Array        Type = '*'
+	// RESP3
+	Null      Type = '_'
+	Boolean   Type = '#'
+	Double    Type = ','
+	BigNumber Type = '('
+	BlobError Type = '!'
+	Verbatim  Type = '='
+	Map       Type = '%'
+	Set       Type = '~'
+	Attribute Type = '|'
+	Push      Type = '>'
+)
+
+// Value is a decoded RESP value. Type tells which of the fields is set:
+// Str for the string types (and the text of a verbatim string, with its
+// format in Format), Int, Float, Big or Bool for the numbers and booleans,
+// Elems for arrays, sets and pushes, and Pairs for maps and attributes.
+type Value struct {
+	Type   Type
+	Str    string
+	Format string
+	Int    int64
+	Float  float64
+	Big    *big.Int
+	Bool   bool
+	Null   bool     // a RESP2 null bulk string or null array
+	Elems  []*Value // array, set or push elements
+	Pairs  []Pair   // map or attribute entries, in the order they came
+	Attrs  []Pair   // the attribute sent before the value, if any
+}
+
+// Pair is an entry of a map or an attribute
+type Pair struct {
+	Key   *Value
+	Value *Value
+}
+
+// streamEnd is the type of the line closing a streamed aggregate
+const streamEnd Type = '.'
+
+func protocolError(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrProtocol}, args...)...)
+}
+
+// ReadValue reads a RESP2 or RESP3 value and everything it holds. Streamed
+// strings and aggregates (a length of '?') are read whole, so they encode
+// back as regular ones. Malformed input returns an error wrapping ErrProtocol.
+func ReadValue(r *bufio.Reader) (*Value, error) {
+	return readTopValue(r)
+}
+
+func readTopValue(r lineReader) (*Value, error) {
+	v, err := readValue(r)
+	if err == nil && v.Type == streamEnd {
+		return nil, protocolError("unexpected end of stream")
+	}
+	return v, err
+}
+
+// readValue is ReadValue, except that the end of a streamed aggregate is
+// returned as a value of type streamEnd
+func readValue(r lineReader) (*Value, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	v := &Value{Type: Type(line[0])}
+	body := line[1:]
+
+	switch v.Type {
+	case SimpleString, SimpleError:
+		v.Str = body
+	case Integer:
+		if v.Int, err = strconv.ParseInt(body, 10, 64); err != nil {
+			return nil, protocolError("invalid integer")
+		}
+	case Null, streamEnd:
+		if body != "" {
+			return nil, protocolError("invalid null")
+		}
+	case Boolean:
+		if body != "t" && body != "f" {
+			return nil, protocolError("invalid boolean")
+		}
+		v.Bool = body == "t"
+	case Double:
+		if v.Float, err = strconv.ParseFloat(body, 64); err != nil && !isRangeError(err) {
+			return nil, protocolError("invalid double")
+		}
+	case BigNumber:
+		var ok bool
+		if v.Big, ok = new(big.Int).SetString(body, 10); !ok {
+			return nil, protocolError("invalid big number")
+		}
+	case BulkString, BlobError, Verbatim:
+		if err := readString(r, v, body); err != nil {
+			return nil, err
+		}
+	case Array, Set, Push, Map, Attribute:
+		if err := readAggregate(r, v, body); err != nil {
+			return nil, err
+		}
+		if v.Type == Attribute {
+			// an attribute describes the value following it
+			next, err := readTopValue(r)
+			if err != nil {
+				return nil, err
+			}
+			next.Attrs = v.Pairs
+			return next, nil
+		}
+	default:
+		return nil, protocolError("unknown type '%c'", line[0])
+	}
+	return v, nil
+}
+
+func isRangeError(err error) bool {
+	numErr, ok := err.(*strconv.NumError)
+	return ok && numErr.Err == strconv.ErrRange
+}
+
+// readString reads the content of a bulk string, blob error or verbatim
+// string, given the length on its first line
+func readString(r lineReader, v *Value, length string) error {
+	if length == "?" && v.Type == BulkString {
+		return readStreamedString(r, v)
+	}
+	size, err := strconv.Atoi(length)
+	if err == nil && size == -1 && v.Type == BulkString {
+		v.Null = true
+		return nil
+	}
+	if err != nil || size < 0 || size > MAX_BULK_LEN {
+		return protocolError("invalid bulk length")
+	}
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return protocolError("expected CRLF after bulk string")
+	}
+	v.Str = string(buf[:size])
+	if v.Type == Verbatim {
+		if size < 4 || v.Str[3] != ':' {
+			return protocolError("invalid verbatim string")
+		}
+		v.Format, v.Str = v.Str[:3], v.Str[4:]
+	}
+	return nil
+}
+
+// readStreamedString reads the chunks of a streamed bulk string, up to the
+// empty one ending it
+func readStreamedString(r lineReader, v *Value) error {
+	var content bytes.Buffer
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if line[0] != ';' {
+			return protocolError("expected ';', got '%c'", line[0])
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || content.Len()+size > MAX_BULK_LEN {
+			return protocolError("invalid chunk length")
+		}
+		if size == 0 {
+			v.Str = content.String()
+			return nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return protocolError("expected CRLF after chunk")
+		}
+		content.Write(buf[:size])
+	}
+}
+
+// readAggregate reads the elements of an array, set or push, or the
+// entries of a map or attribute, given their number on its first line
+func readAggregate(r lineReader, v *Value, length string) error {
+	paired := v.Type == Map || v.Type == Attribute
+	count := -1 // streamed, up to the end line
+	if length != "?" || v.Type == Attribute {
+		n, err := strconv.Atoi(length)
+		if err == nil && n == -1 && v.Type == Array {
+			v.Null = true
+			return nil
+		}
+		if err != nil || n < 0 || n > MAX_MULTIBULK_LEN {
+			return protocolError("invalid multibulk length")
+		}
+		count = n
+		if paired {
+			v.Pairs = make([]Pair, 0, n)
+		} else {
+			v.Elems = make([]*Value, 0, n)
+		}
+	}
+
+	for i := 0; count < 0 || i < count; i++ {
+		elem, err := readValue(r)
+		if err != nil {
+			return err
+		}
+		if elem.Type == streamEnd {
+			if count >= 0 {
+				return protocolError("unexpected end of stream")
+			}
+			return nil
+		}
+		if !paired {
+			v.Elems = append(v.Elems, elem)
+			continue
+		}
+		value, err := readTopValue(r)
+		if err != nil {
+			return err
+		}
+		v.Pairs = append(v.Pairs, Pair{Key: elem, Value: value})
+	}
+	return nil
+}
+
+// lineReader is what values are read from: a *bufio.Reader, or the input of
+// a StreamingParser
+type lineReader interface {
+	io.Reader
+	ReadSlice(delim byte) ([]byte, error)
+}
+
+// streamingReader reads the input of a StreamingParser from its position
+type streamingReader struct {
+	p *StreamingParser
+}
+
+func (sr streamingReader) Read(b []byte) (int, error) {
+	if sr.p.pos >= sr.p.len {
+		return 0, io.ErrUnexpectedEOF // the value started but didn't end
+	}
+	n := copy(b, sr.p.buf[sr.p.pos:sr.p.len])
+	sr.p.pos += n
+	return n, nil
+}
+
+func (sr streamingReader) ReadSlice(delim byte) ([]byte, error) {
+	rest := sr.p.buf[sr.p.pos:sr.p.len]
+	i := bytes.IndexByte(rest, delim)
+	if i < 0 {
+		sr.p.pos = sr.p.len
+		return rest, io.ErrUnexpectedEOF
+	}
+	sr.p.pos += i + 1
+	return rest[:i+1], nil
+}
+
+// ParseValue parses the next value of the input, like ReadValue
+func (p *StreamingParser) ParseValue() (*Value, error) {
+	if p.pos >= p.len {
+		return nil, io.EOF
+	}
+	return readTopValue(streamingReader{p})
+}
+
+// AppendValue appends the RESP encoding of v to b. A value read by
+// ReadValue encodes back to the bytes it was read from, unless it was
+// streamed or its double was not in its shortest form.
+func AppendValue(b []byte, v *Value) []byte {
+	if len(v.Attrs) > 0 {
+		b = appendPairs(b, Attribute, v.Attrs)
+	}
+	b = append(b, byte(v.Type))
+	switch v.Type {
+	case SimpleString, SimpleError:
+		b = append(b, v.Str...)
+	case Integer:
+		b = strconv.AppendInt(b, v.Int, 10)
+	case Boolean:
+		if v.Bool {
+			b = append(b, 't')
+		} else {
+			b = append(b, 'f')
+		}
+	case Double:
+		b = append(b, resp.FormatDouble(v.Float)...)
+	case BigNumber:
+		b = v.Big.Append(b, 10)
+	case BulkString, BlobError:
+		if v.Null {
+			return append(b, "-1\r\n"...)
+		}
+		b = strconv.AppendInt(b, int64(len(v.Str)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, v.Str...)
+	case Verbatim:
+		b = strconv.AppendInt(b, int64(len(v.Format)+1+len(v.Str)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, v.Format...)
+		b = append(b, ':')
+		b = append(b, v.Str...)
+	case Array, Set, Push:
+		if v.Null {
+			return append(b, "-1\r\n"...)
+		}
+		b = strconv.AppendInt(b, int64(len(v.Elems)), 10)
+		b = append(b, "\r\n"...)
+		for _, elem := range v.Elems {
+			b = AppendValue(b, elem)
+		}
+		return b
+	case Map:
+		return appendPairs(b[:len(b)-1], Map, v.Pairs)
+	}
+	return append(b, "\r\n"...)
+}
+
+func appendPairs(b []byte, t Type, pairs []Pair) []byte {
+	b = append(b, byte(t))
+	b = strconv.AppendInt(b, int64(len(pairs)), 10)
+	b = append(b, "\r\n"...)
+	for _, pair := range pairs {
+		b = AppendValue(b, pair.Key)
+		b = AppendValue(b, pair.Value)
+	}
+	return b
+}
+
+// WriteValue writes the RESP encoding of v to w
+func WriteValue(w io.Writer, v *Value) error {
+	_, err := w.Write(AppendValue(nil, v))
+	return err
+}
+
+// Words returns the text of the scalars v holds, in order: map keys come
+// before their values and attributes are left out. It is how a value sent
+// as a command is read as one.
+func (v *Value) Words() []string {
+	switch v.Type {
+	case Array, Set, Push:
+		words := make([]string, 0, len(v.Elems))
+		for _, elem := range v.Elems {
+			words = append(words, elem.Words()...)
+		}
+		return words
+	case Map:
+		words := make([]string, 0, 2*len(v.Pairs))
+		for _, pair := range v.Pairs {
+			words = append(words, pair.Key.Words()...)
+			words = append(words, pair.Value.Words()...)
+		}
+		return words
+	case Integer:
+		return []string{strconv.FormatInt(v.Int, 10)}
+	case Double:
+		return []string{resp.FormatDouble(v.Float)}
+	case BigNumber:
+		return []string{v.Big.String()}
+	case Boolean:
+		if v.Bool {
+			return []string{"t"}
+		}
+		return []string{"f"}
+	case Null:
+		return []string{"null"}
+	}
+	if v.Null {
+		return []string{"null"}
+	}
+	return []string{v.Str}
+}
@@ -0,0 +1,242 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+func bulk(s string) *Value {
+	return &Value{Type: BulkString, Str: s}
+}
+
+func bigNumber(s string) *Value {
+	n, _ := new(big.Int).SetString(s, 10)
+	return &Value{Type: BigNumber, Big: n}
+}
+
+func TestReadValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected *Value
+	}{
+		{"simple string", "+OK\r\n", &Value{Type: SimpleString, Str: "OK"}},
+		{"empty simple string", "+\r\n", &Value{Type: SimpleString}},
+		{"error", "-ERR oops\r\n", &Value{Type: SimpleError, Str: "ERR oops"}},
+		{"integer", ":-42\r\n", &Value{Type: Integer, Int: -42}},
+		{"bulk string", "$5\r\nva\r\nl\r\n", bulk("va\r\nl")},
+		{"empty bulk string", "$0\r\n\r\n", bulk("")},
+		{"null bulk string", "$-1\r\n", &Value{Type: BulkString, Null: true}},
+		{"array", "*2\r\n$3\r\nGET\r\n:1\r\n", &Value{Type: Array, Elems: []*Value{bulk("GET"), {Type: Integer, Int: 1}}}},
+		{"empty array", "*0\r\n", &Value{Type: Array, Elems: []*Value{}}},
+		{"null array", "*-1\r\n", &Value{Type: Array, Null: true}},
+		{"null", "_\r\n", &Value{Type: Null}},
+		{"true", "#t\r\n", &Value{Type: Boolean, Bool: true}},
+		{"false", "#f\r\n", &Value{Type: Boolean}},
+		{"double", ",1.5\r\n", &Value{Type: Double, Float: 1.5}},
+		{"exponent double", ",1e+21\r\n", &Value{Type: Double, Float: 1e21}},
+		{"infinite double", ",-inf\r\n", &Value{Type: Double, Float: math.Inf(-1)}},
+		{"big number", "(3492890328409238509324850943850943825024385\r\n", bigNumber("3492890328409238509324850943850943825024385")},
+		{"blob error", "!21\r\nSYNTAX invalid syntax\r\n", &Value{Type: BlobError, Str: "SYNTAX invalid syntax"}},
+		{"verbatim string", "=15\r\ntxt:Some string\r\n", &Value{Type: Verbatim, Format: "txt", Str: "Some string"}},
+		{"map", "%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n", &Value{Type: Map, Pairs: []Pair{
+			{&Value{Type: SimpleString, Str: "first"}, &Value{Type: Integer, Int: 1}},
+			{&Value{Type: SimpleString, Str: "second"}, &Value{Type: Integer, Int: 2}},
+		}}},
+		{"set", "~2\r\n$1\r\na\r\n#f\r\n", &Value{Type: Set, Elems: []*Value{bulk("a"), {Type: Boolean}}}},
+		{"push", ">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n", &Value{Type: Push, Elems: []*Value{bulk("message"), bulk("news"), bulk("hi")}}},
+		{"nested aggregates", "*2\r\n%1\r\n$1\r\nk\r\n~1\r\n_\r\n*-1\r\n", &Value{Type: Array, Elems: []*Value{
+			{Type: Map, Pairs: []Pair{{bulk("k"), &Value{Type: Set, Elems: []*Value{{Type: Null}}}}}},
+			{Type: Array, Null: true},
+		}}},
+		{"attribute", "|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.1923\r\n*1\r\n:2039123\r\n", &Value{
+			Type:  Array,
+			Elems: []*Value{{Type: Integer, Int: 2039123}},
+			Attrs: []Pair{{&Value{Type: SimpleString, Str: "key-popularity"}, &Value{Type: Map, Pairs: []Pair{
+				{bulk("a"), &Value{Type: Double, Float: 0.1923}},
+			}}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := ReadValue(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(v, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, v)
+			}
+			// and back to the same bytes
+			if encoded := string(AppendValue(nil, v)); encoded != tt.input {
+				t.Errorf("Expected %q encoded, got %q", tt.input, encoded)
+			}
+		})
+	}
+}
+
+func TestReadStreamedValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		encoded string
+	}{
+		{"string", "$?\r\n;4\r\nHell\r\n;6\r\no worl\r\n;1\r\nd\r\n;0\r\n", "$11\r\nHello world\r\n"},
+		{"array", "*?\r\n:1\r\n$1\r\na\r\n.\r\n", "*2\r\n:1\r\n$1\r\na\r\n"},
+		{"map", "%?\r\n+a\r\n:1\r\n.\r\n", "%1\r\n+a\r\n:1\r\n"},
+		{"empty set", "~?\r\n.\r\n", "~0\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := ReadValue(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if encoded := string(AppendValue(nil, v)); encoded != tt.encoded {
+				t.Errorf("Expected %q, got %q", tt.encoded, encoded)
+			}
+		})
+	}
+}
+
+func TestReadValueErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown type", "@1\r\n"},
+		{"invalid integer", ":one\r\n"},
+		{"invalid double", ",1.5.5\r\n"},
+		{"invalid big number", "(12a\r\n"},
+		{"invalid boolean", "#x\r\n"},
+		{"invalid null", "_x\r\n"},
+		{"bulk string too long", "$3\r\nabcd\r\n"},
+		{"negative bulk length", "$-2\r\n"},
+		{"null set", "~-1\r\n"},
+		{"verbatim without format", "=3\r\ntxt\r\n"},
+		{"streamed attribute", "|?\r\n"},
+		{"end outside of a stream", ".\r\n"},
+		{"end inside a sized array", "*2\r\n:1\r\n.\r\n"},
+		{"bad chunk", "$?\r\n+4\r\n"},
+		{"invalid line ending", "+OK\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadValue(bufio.NewReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, ErrProtocol) {
+				t.Errorf("Expected a protocol error, got %v", err)
+			}
+		})
+	}
+
+	t.Run("truncated input", func(t *testing.T) {
+		_, err := ReadValue(bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n")))
+		if err != io.EOF {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+	})
+}
+
+// TestValueRoundTrip encodes replies with resp.Writer, the encoder of the
+// server, in both protocols and checks they decode to what was written
+func TestValueRoundTrip(t *testing.T) {
+	write := func(w *resp.Writer) {
+		w.WriteMapLen(2)
+		w.WriteBulkString("scores")
+		w.WriteArrayLen(3)
+		w.WriteDouble(1.5)
+		w.WriteDouble(math.Inf(1))
+		w.WriteBigNumber("123456789012345678901234567890")
+		w.WriteBulkString("flags")
+		w.WriteSetLen(3)
+		w.WriteBool(true)
+		w.WriteNull()
+		w.WriteVerbatim("txt", "hi")
+		w.WritePushLen(2)
+		w.WriteSimpleString("OK")
+		w.WriteError("ERR oops")
+	}
+	expected := map[int][]*Value{
+		3: {
+			{Type: Map, Pairs: []Pair{
+				{bulk("scores"), &Value{Type: Array, Elems: []*Value{
+					{Type: Double, Float: 1.5}, {Type: Double, Float: math.Inf(1)}, bigNumber("123456789012345678901234567890"),
+				}}},
+				{bulk("flags"), &Value{Type: Set, Elems: []*Value{
+					{Type: Boolean, Bool: true}, {Type: Null}, {Type: Verbatim, Format: "txt", Str: "hi"},
+				}}},
+			}},
+			{Type: Push, Elems: []*Value{{Type: SimpleString, Str: "OK"}, {Type: SimpleError, Str: "ERR oops"}}},
+		},
+		2: {
+			{Type: Array, Elems: []*Value{
+				bulk("scores"), {Type: Array, Elems: []*Value{bulk("1.5"), bulk("inf"), bulk("123456789012345678901234567890")}},
+				bulk("flags"), {Type: Array, Elems: []*Value{{Type: Integer, Int: 1}, {Type: BulkString, Null: true}, bulk("hi")}},
+			}},
+			{Type: Array, Elems: []*Value{{Type: SimpleString, Str: "OK"}, {Type: SimpleError, Str: "ERR oops"}}},
+		},
+	}
+
+	for proto, values := range expected {
+		var buf bytes.Buffer
+		write(resp.NewWriter(&buf, proto))
+		written := buf.String()
+
+		p := NewStreamingParser(buf.Bytes())
+		var encoded []byte
+		for i, want := range values {
+			v, err := p.ParseValue()
+			if err != nil {
+				t.Fatalf("RESP%d: expected no error, got %v", proto, err)
+			}
+			if !reflect.DeepEqual(v, want) {
+				t.Errorf("RESP%d value %d: expected %+v, got %+v", proto, i, want, v)
+			}
+			encoded = AppendValue(encoded, v)
+		}
+		if _, err := p.ParseValue(); err != io.EOF {
+			t.Errorf("RESP%d: expected io.EOF after the last value, got %v", proto, err)
+		}
+		if string(encoded) != written {
+			t.Errorf("RESP%d: expected %q encoded, got %q", proto, written, encoded)
+		}
+	}
+}
+
+func TestParseValueTruncated(t *testing.T) {
+	p := NewStreamingParser([]byte("*2\r\n$3\r\nGET\r\n$3\r\nke"))
+	if _, err := p.ParseValue(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"map pairs", "*2\r\n$4\r\nHSET\r\n%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n,2.5\r\n", []string{"HSET", "a", "1", "b", "2.5"}},
+		{"scalars", "~4\r\n#t\r\n_\r\n(12345678901234567890\r\n=7\r\ntxt:abc\r\n", []string{"t", "null", "12345678901234567890", "abc"}},
+		{"attributes are left out", "|1\r\n+ttl\r\n:3\r\n*1\r\n$4\r\nPING\r\n", []string{"PING"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewStreamingParser([]byte(tt.input)).ParseValue()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if words := v.Words(); !reflect.DeepEqual(words, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, words)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"sync/atomic"
+
+	"github.com/shubhdevelop/YAKVS/pubsub"
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+// subscriberCommands are the only commands a RESP2 client may send while
+// subscribed, anything else would be mistaken for a message
+var subscriberCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"SSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"SUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+const PUBSUB_OUTPUT_BUFFER_LIMIT_DEFAULT = 32 * 1024 * 1024
+
+// pubsubOutputBufferLimit is the number of bytes a subscriber may have
+// waiting to be sent before it is disconnected, 0 means no limit
+var pubsubOutputBufferLimit atomic.Int64
+
+func init() {
+	pubsubOutputBufferLimit.Store(PUBSUB_OUTPUT_BUFFER_LIMIT_DEFAULT)
+}
+
+// subscriptionCount returns the number of channels and patterns the client
+// is subscribed to, shard channels excluded
+func (c *Client) subscriptionCount() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// writeSubscription sends the confirmation of a (un)subscription. It goes
+// straight to the connection, so it can't be overtaken by a message
+// published on the channel just subscribed to.
+func (c *Client) writeSubscription(kind string, channel *string, count int) {
+	var buf bytes.Buffer
+	w := resp.NewWriter(&buf, int(c.resp.Load()))
+	w.WritePushLen(3)
+	w.WriteBulkString(kind)
+	if channel == nil {
+		w.WriteNull()
+	} else {
+		w.WriteBulkString(*channel)
+	}
+	w.WriteInteger(int64(count))
+	c.out.Write(buf.Bytes())
+}
+
+func (c *Client) subscribe(channels []string) {
+	c.flush()
+	for i := range channels {
+		_, subscribed := c.channels[channels[i]]
+		c.channels[channels[i]] = struct{}{}
+		c.writeSubscription("subscribe", &channels[i], c.subscriptionCount())
+		if !subscribed {
+			pubSub.Subscribe(c, channels[i])
+		}
+	}
+}
+
+func (c *Client) psubscribe(patterns []string) {
+	c.flush()
+	for i := range patterns {
+		_, subscribed := c.patterns[patterns[i]]
+		c.patterns[patterns[i]] = struct{}{}
+		c.writeSubscription("psubscribe", &patterns[i], c.subscriptionCount())
+		if !subscribed {
+			pubSub.PSubscribe(c, patterns[i])
+		}
+	}
+}
+
+func (c *Client) ssubscribe(channels []string) {
+	c.flush()
+	for i := range channels {
+		_, subscribed := c.shardChannels[channels[i]]
+		c.shardChannels[channels[i]] = struct{}{}
+		c.writeSubscription("ssubscribe", &channels[i], len(c.shardChannels))
+		if !subscribed {
+			pubSub.SSubscribe(c, channels[i])
+		}
+	}
+}
+
+// unsubscribe unsubscribes from the channels, from all of them if none is given
+func (c *Client) unsubscribe(channels []string) {
+	c.flush()
+	if len(channels) == 0 {
+		channels = keysOf(c.channels)
+		if len(channels) == 0 {
+			c.writeSubscription("unsubscribe", nil, c.subscriptionCount())
+			return
+		}
+	}
+	for i := range channels {
+		delete(c.channels, channels[i])
+		pubSub.Unsubscribe(c, channels[i])
+		c.writeSubscription("unsubscribe", &channels[i], c.subscriptionCount())
+	}
+}
+
+func (c *Client) punsubscribe(patterns []string) {
+	c.flush()
+	if len(patterns) == 0 {
+		patterns = keysOf(c.patterns)
+		if len(patterns) == 0 {
+			c.writeSubscription("punsubscribe", nil, c.subscriptionCount())
+			return
+		}
+	}
+	for i := range patterns {
+		delete(c.patterns, patterns[i])
+		pubSub.PUnsubscribe(c, patterns[i])
+		c.writeSubscription("punsubscribe", &patterns[i], c.subscriptionCount())
+	}
+}
+
+func (c *Client) sunsubscribe(channels []string) {
+	c.flush()
+	if len(channels) == 0 {
+		channels = keysOf(c.shardChannels)
+		if len(channels) == 0 {
+			c.writeSubscription("sunsubscribe", nil, len(c.shardChannels))
+			return
+		}
+	}
+	for i := range channels {
+		delete(c.shardChannels, channels[i])
+		pubSub.SUnsubscribe(c, channels[i])
+		c.writeSubscription("sunsubscribe", &channels[i], len(c.shardChannels))
+	}
+}
+
+// pubsubUnsubscribeAll drops every subscription without notifying the
+// client, for clients going away
+func (c *Client) pubsubUnsubscribeAll() {
+	for channel := range c.channels {
+		pubSub.Unsubscribe(c, channel)
+	}
+	for pattern := range c.patterns {
+		pubSub.PUnsubscribe(c, pattern)
+	}
+	for channel := range c.shardChannels {
+		pubSub.SUnsubscribe(c, channel)
+	}
+	c.channels = make(map[string]struct{})
+	c.patterns = make(map[string]struct{})
+	c.shardChannels = make(map[string]struct{})
+}
+
+// Deliver sends a published message to the client. It runs on the
+// publisher's goroutine: a subscriber too slow to read what it is sent is
+// disconnected rather than slowing the publisher down or using unbounded
+// memory.
+func (c *Client) Deliver(msg pubsub.Message) {
+	var buf bytes.Buffer
+	w := resp.NewWriter(&buf, int(c.resp.Load()))
+	if msg.Kind == pubsub.KindPMessage {
+		w.WritePushLen(4)
+		w.WriteBulkString(msg.Kind)
+		w.WriteBulkString(msg.Pattern)
+	} else {
+		w.WritePushLen(3)
+		w.WriteBulkString(msg.Kind)
+	}
+	w.WriteBulkString(msg.Channel)
+	w.WriteBulkString(msg.Payload)
+	c.out.Write(buf.Bytes())
+
+	if limit := pubsubOutputBufferLimit.Load(); c.conn != nil && limit > 0 && int64(c.conn.Pending()) > limit {
+		log.Printf("Client %s closed for overcoming of output buffer limits.", c.conn.RemoteAddr())
+		c.conn.Close()
+	}
+}
+
+func keysOf(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
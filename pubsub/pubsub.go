@@ -0,0 +1,204 @@
+package pubsub
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/shubhdevelop/YAKVS/utils"
+)
+
+// Message kinds, as sent to the subscribers
+const (
+	KindMessage  = "message"  // published on a channel the subscriber subscribed to
+	KindPMessage = "pmessage" // published on a channel matching one of its patterns
+	KindSMessage = "smessage" // published on a shard channel
+)
+
+// Message is a message published on a channel
+type Message struct {
+	Kind    string
+	Pattern string // the matching pattern, only set for pmessage
+	Channel string
+	Payload string
+}
+
+// Subscriber receives the messages published on its channels. Deliver is
+// called with the registry locked, so it must not block.
+type Subscriber interface {
+	Deliver(msg Message)
+}
+
+// registry maps a channel (or pattern) to its subscribers
+type registry map[string]map[Subscriber]struct{}
+
+func (r registry) add(name string, s Subscriber) bool {
+	subscribers, exists := r[name]
+	if !exists {
+		subscribers = make(map[Subscriber]struct{})
+		r[name] = subscribers
+	}
+	if _, subscribed := subscribers[s]; subscribed {
+		return false
+	}
+	subscribers[s] = struct{}{}
+	return true
+}
+
+func (r registry) remove(name string, s Subscriber) bool {
+	subscribers, exists := r[name]
+	if !exists {
+		return false
+	}
+	if _, subscribed := subscribers[s]; !subscribed {
+		return false
+	}
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(r, name)
+	}
+	return true
+}
+
+// names returns the sorted names matching the glob pattern, all of them if
+// pattern is empty
+func (r registry) names(pattern string) []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		if pattern == "" || utils.StringMatch(pattern, name, false) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PubSub routes published messages to the subscribers. It is safe for
+// concurrent use.
+type PubSub struct {
+	mu            sync.RWMutex
+	channels      registry
+	patterns      registry
+	shardChannels registry
+}
+
+func New() *PubSub {
+	return &PubSub{
+		channels:      make(registry),
+		patterns:      make(registry),
+		shardChannels: make(registry),
+	}
+}
+
+// Subscribe subscribes s to channel. It returns false if s already was.
+func (ps *PubSub) Subscribe(s Subscriber, channel string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.channels.add(channel, s)
+}
+
+// Unsubscribe unsubscribes s from channel. It returns false if s wasn't subscribed.
+func (ps *PubSub) Unsubscribe(s Subscriber, channel string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.channels.remove(channel, s)
+}
+
+// PSubscribe subscribes s to the channels matching the glob pattern
+func (ps *PubSub) PSubscribe(s Subscriber, pattern string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.patterns.add(pattern, s)
+}
+
+// PUnsubscribe unsubscribes s from pattern
+func (ps *PubSub) PUnsubscribe(s Subscriber, pattern string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.patterns.remove(pattern, s)
+}
+
+// SSubscribe subscribes s to a shard channel
+func (ps *PubSub) SSubscribe(s Subscriber, channel string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.shardChannels.add(channel, s)
+}
+
+// SUnsubscribe unsubscribes s from a shard channel
+func (ps *PubSub) SUnsubscribe(s Subscriber, channel string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.shardChannels.remove(channel, s)
+}
+
+// Publish sends payload to the subscribers of channel and of the patterns
+// matching it. It returns the number of messages delivered.
+func (ps *PubSub) Publish(channel string, payload string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	receivers := 0
+	for s := range ps.channels[channel] {
+		s.Deliver(Message{Kind: KindMessage, Channel: channel, Payload: payload})
+		receivers++
+	}
+	for pattern, subscribers := range ps.patterns {
+		if !utils.StringMatch(pattern, channel, false) {
+			continue
+		}
+		for s := range subscribers {
+			s.Deliver(Message{Kind: KindPMessage, Pattern: pattern, Channel: channel, Payload: payload})
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// SPublish sends payload to the subscribers of a shard channel
+func (ps *PubSub) SPublish(channel string, payload string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	receivers := 0
+	for s := range ps.shardChannels[channel] {
+		s.Deliver(Message{Kind: KindSMessage, Channel: channel, Payload: payload})
+		receivers++
+	}
+	return receivers
+}
+
+// Channels returns the channels with at least one subscriber matching the
+// glob pattern, all of them if pattern is empty
+func (ps *PubSub) Channels(pattern string) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.channels.names(pattern)
+}
+
+// ShardChannels is Channels for shard channels
+func (ps *PubSub) ShardChannels(pattern string) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.shardChannels.names(pattern)
+}
+
+// NumSub returns the number of subscribers of channel, patterns excluded
+func (ps *PubSub) NumSub(channel string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.channels[channel])
+}
+
+// ShardNumSub returns the number of subscribers of a shard channel
+func (ps *PubSub) ShardNumSub(channel string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.shardChannels[channel])
+}
+
+// NumPat returns the number of patterns subscribed to
+func (ps *PubSub) NumPat() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.patterns)
+}
@@ -0,0 +1,96 @@
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+)
+
+type fakeSubscriber struct {
+	messages []Message
+}
+
+func (f *fakeSubscriber) Deliver(msg Message) {
+	f.messages = append(f.messages, msg)
+}
+
+func TestPublish(t *testing.T) {
+	ps := New()
+	exact := &fakeSubscriber{}
+	pattern := &fakeSubscriber{}
+	shard := &fakeSubscriber{}
+
+	ps.Subscribe(exact, "news.sport")
+	ps.PSubscribe(pattern, "news.*")
+	ps.SSubscribe(shard, "news.sport")
+
+	if receivers := ps.Publish("news.sport", "goal"); receivers != 2 {
+		t.Errorf("Expected 2 receivers, got %d", receivers)
+	}
+	if receivers := ps.Publish("weather", "rain"); receivers != 0 {
+		t.Errorf("Expected 0 receivers, got %d", receivers)
+	}
+	if receivers := ps.SPublish("news.sport", "match over"); receivers != 1 {
+		t.Errorf("Expected 1 receiver, got %d", receivers)
+	}
+
+	expected := []Message{{Kind: KindMessage, Channel: "news.sport", Payload: "goal"}}
+	if !reflect.DeepEqual(exact.messages, expected) {
+		t.Errorf("Expected %v, got %v", expected, exact.messages)
+	}
+	expected = []Message{{Kind: KindPMessage, Pattern: "news.*", Channel: "news.sport", Payload: "goal"}}
+	if !reflect.DeepEqual(pattern.messages, expected) {
+		t.Errorf("Expected %v, got %v", expected, pattern.messages)
+	}
+	expected = []Message{{Kind: KindSMessage, Channel: "news.sport", Payload: "match over"}}
+	if !reflect.DeepEqual(shard.messages, expected) {
+		t.Errorf("Expected %v, got %v", expected, shard.messages)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	ps := New()
+	a := &fakeSubscriber{}
+	b := &fakeSubscriber{}
+
+	if !ps.Subscribe(a, "ch1") || ps.Subscribe(a, "ch1") {
+		t.Error("Expected only the first subscription to be new")
+	}
+	ps.Subscribe(b, "ch1")
+	ps.Subscribe(b, "ch2")
+	ps.PSubscribe(a, "ch*")
+	ps.PSubscribe(b, "ch*")
+	ps.SSubscribe(a, "shard1")
+
+	if channels := ps.Channels(""); !reflect.DeepEqual(channels, []string{"ch1", "ch2"}) {
+		t.Errorf("Expected [ch1 ch2], got %v", channels)
+	}
+	if channels := ps.Channels("*2"); !reflect.DeepEqual(channels, []string{"ch2"}) {
+		t.Errorf("Expected [ch2], got %v", channels)
+	}
+	if channels := ps.ShardChannels(""); !reflect.DeepEqual(channels, []string{"shard1"}) {
+		t.Errorf("Expected [shard1], got %v", channels)
+	}
+	if n := ps.NumSub("ch1"); n != 2 {
+		t.Errorf("Expected 2 subscribers, got %d", n)
+	}
+	if n := ps.NumPat(); n != 1 {
+		t.Errorf("Expected 1 pattern, got %d", n)
+	}
+	if n := ps.ShardNumSub("shard1"); n != 1 {
+		t.Errorf("Expected 1 shard subscriber, got %d", n)
+	}
+
+	if !ps.Unsubscribe(a, "ch1") || ps.Unsubscribe(a, "ch1") {
+		t.Error("Expected only the first unsubscription to succeed")
+	}
+	ps.Unsubscribe(b, "ch1")
+	ps.PUnsubscribe(a, "ch*")
+	ps.PUnsubscribe(b, "ch*")
+	ps.SUnsubscribe(a, "shard1")
+	if channels := ps.Channels(""); !reflect.DeepEqual(channels, []string{"ch2"}) {
+		t.Errorf("Expected channels without subscribers to be removed, got %v", channels)
+	}
+	if ps.NumPat() != 0 || ps.ShardNumSub("shard1") != 0 {
+		t.Error("Expected patterns and shard channels to be removed")
+	}
+}
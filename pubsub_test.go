@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// syncBuffer collects what is sent to a client, publishers may write to it
+// from other goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Take returns what was written so far and resets the buffer
+func (b *syncBuffer) Take() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.buf.String()
+	b.buf.Reset()
+	return s
+}
+
+func newTestClient(s *store.Store) (*Client, *syncBuffer) {
+	out := &syncBuffer{}
+	return NewClient(s, nil, out), out
+}
+
+func TestPubSub(t *testing.T) {
+	s := store.NewStore()
+
+	t.Run("SUBSCRIBE and PUBLISH", func(t *testing.T) {
+		subscriber, subOut := newTestClient(s)
+		publisher, pubOut := newTestClient(s)
+		defer subscriber.Close()
+
+		run(subscriber, "SUBSCRIBE ps1.a ps1.b")
+		expected := "*3\r\n$9\r\nsubscribe\r\n$5\r\nps1.a\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$5\r\nps1.b\r\n:2\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+
+		run(publisher, "PUBLISH ps1.a hello", "PUBLISH ps1.nobody hello")
+		if got := pubOut.Take(); got != ":1\r\n:0\r\n" {
+			t.Errorf("Expected receiver counts 1 and 0, got %q", got)
+		}
+		expected = "*3\r\n$7\r\nmessage\r\n$5\r\nps1.a\r\n$5\r\nhello\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+
+		run(subscriber, "UNSUBSCRIBE ps1.a")
+		expected = "*3\r\n$11\r\nunsubscribe\r\n$5\r\nps1.a\r\n:1\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(publisher, "PUBLISH ps1.a hello")
+		if got := pubOut.Take(); got != ":0\r\n" {
+			t.Errorf("Expected no receiver after UNSUBSCRIBE, got %q", got)
+		}
+	})
+
+	t.Run("PSUBSCRIBE", func(t *testing.T) {
+		subscriber, subOut := newTestClient(s)
+		publisher, _ := newTestClient(s)
+		defer subscriber.Close()
+
+		run(subscriber, "PSUBSCRIBE ps2.*")
+		subOut.Take()
+		run(publisher, "PUBLISH ps2.x hi")
+		expected := "*4\r\n$8\r\npmessage\r\n$5\r\nps2.*\r\n$5\r\nps2.x\r\n$2\r\nhi\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("SSUBSCRIBE and SPUBLISH", func(t *testing.T) {
+		subscriber, subOut := newTestClient(s)
+		publisher, pubOut := newTestClient(s)
+		defer subscriber.Close()
+
+		run(subscriber, "SSUBSCRIBE ps3")
+		expected := "*3\r\n$10\r\nssubscribe\r\n$3\r\nps3\r\n:1\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(publisher, "PUBLISH ps3 a", "SPUBLISH ps3 b")
+		if got := pubOut.Take(); got != ":0\r\n:1\r\n" {
+			t.Errorf("Expected shard channels to only get SPUBLISH, got %q", got)
+		}
+		expected = "*3\r\n$8\r\nsmessage\r\n$3\r\nps3\r\n$1\r\nb\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("RESP3 clients get push frames and may run any command", func(t *testing.T) {
+		subscriber, subOut := newTestClient(s)
+		publisher, _ := newTestClient(s)
+		defer subscriber.Close()
+		subscriber.resp.Store(3)
+
+		run(subscriber, "SUBSCRIBE ps4")
+		expected := ">3\r\n$9\r\nsubscribe\r\n$3\r\nps4\r\n:1\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(publisher, "PUBLISH ps4 hi")
+		expected = ">3\r\n$7\r\nmessage\r\n$3\r\nps4\r\n$2\r\nhi\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(subscriber, "SET ps4key v")
+		if got := subOut.Take(); got != "+OK\r\n" {
+			t.Errorf("Expected SET to run, got %q", got)
+		}
+	})
+
+	t.Run("RESP2 subscribers can only run subscriber commands", func(t *testing.T) {
+		subscriber, subOut := newTestClient(s)
+		defer subscriber.Close()
+
+		run(subscriber, "SUBSCRIBE ps5")
+		subOut.Take()
+		run(subscriber, "GET ps5key")
+		expected := "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(subscriber, "PING")
+		if got := subOut.Take(); got != "*2\r\n$4\r\npong\r\n$0\r\n\r\n" {
+			t.Errorf("Expected PING to reply with an array, got %q", got)
+		}
+
+		run(subscriber, "UNSUBSCRIBE", "UNSUBSCRIBE")
+		expected = "*3\r\n$11\r\nunsubscribe\r\n$3\r\nps5\r\n:0\r\n*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n"
+		if got := subOut.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(subscriber, "PING")
+		if got := subOut.Take(); got != "+PONG\r\n" {
+			t.Errorf("Expected a regular PONG once unsubscribed, got %q", got)
+		}
+	})
+
+	t.Run("PUBSUB introspection", func(t *testing.T) {
+		a, _ := newTestClient(s)
+		b, _ := newTestClient(s)
+		c, out := newTestClient(s)
+		defer a.Close()
+		defer b.Close()
+
+		run(a, "SUBSCRIBE ps6.x ps6.y", "PSUBSCRIBE ps6.*")
+		run(b, "SUBSCRIBE ps6.x", "SSUBSCRIBE ps6.shard")
+
+		run(c, "PUBSUB CHANNELS ps6.*")
+		expected := "*2\r\n$5\r\nps6.x\r\n$5\r\nps6.y\r\n"
+		if got := out.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(c, "PUBSUB NUMSUB ps6.x ps6.z")
+		expected = "*4\r\n$5\r\nps6.x\r\n:2\r\n$5\r\nps6.z\r\n:0\r\n"
+		if got := out.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+		run(c, "PUBSUB SHARDNUMSUB ps6.shard")
+		expected = "*2\r\n$9\r\nps6.shard\r\n:1\r\n"
+		if got := out.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+
+		// a client going away drops its subscriptions
+		a.Close()
+		run(c, "PUBSUB NUMSUB ps6.x")
+		expected = "*2\r\n$5\r\nps6.x\r\n:1\r\n"
+		if got := out.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("SUBSCRIBE is not allowed in MULTI", func(t *testing.T) {
+		c, out := newTestClient(s)
+		run(c, "MULTI", "SUBSCRIBE ps7", "EXEC")
+		expected := "+OK\r\n-ERR Command not allowed inside a transaction\r\n-EXECABORT Transaction discarded because of previous errors.\r\n"
+		if got := out.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("keyspace notifications", func(t *testing.T) {
+		s := store.NewStore()
+		s.SetPublisher(pubSub)
+		s.SetNotifyKeyspaceEvents("KEx")
+		subscriber, out := newTestClient(s)
+		defer subscriber.Close()
+
+		run(subscriber, "PSUBSCRIBE __key*__:*")
+		out.Take()
+		s.SetValue("session:1", "data")
+		s.SetTTL("session:1", time.Now().Unix()-1)
+		s.ActiveExpireCycle()
+		expected := "*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$24\r\n__keyspace@0__:session:1\r\n$7\r\nexpired\r\n" +
+			"*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$22\r\n__keyevent@0__:expired\r\n$9\r\nsession:1\r\n"
+		if got := out.Take(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+}
@@ -0,0 +1,806 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/snapshot"
+)
+
+// States of the link of a replica with its master
+const (
+	REPL_STATE_CONNECT    = iota // waiting to connect
+	REPL_STATE_CONNECTING        // connected, in the handshake
+	REPL_STATE_TRANSFER          // receiving the snapshot
+	REPL_STATE_CONNECTED         // receiving the write commands
+)
+
+const (
+	REPL_BACKLOG_SIZE_DEFAULT = 1024 * 1024
+	REPL_PING_PERIOD          = 10 * time.Second // masters ping their replicas so silent links are noticed
+	REPL_ACK_PERIOD           = time.Second      // replicas acknowledge the offset they reached
+	REPL_TIMEOUT              = 60 * time.Second // a link silent for longer is dropped
+	REPL_RETRY_PERIOD         = time.Second      // a replica reconnects to its master after
+)
+
+// replicationIDNone is shown for a missing replication ID
+const replicationIDNone = "0000000000000000000000000000000000000000"
+
+/*
+repl holds the replication state of the server.
+
+The write commands form the history of the dataset, it is identified by the
+replication ID and the offset is the number of bytes of commands in it. A
+master streams its history to the replicas and keeps the end of it in the
+backlog, a replica reconnecting after a brief disconnect asks for the history
+from its offset on (PSYNC) and only gets a snapshot of the dataset followed
+by the stream when the backlog doesn't have it anymore.
+
+A replica takes the ID and the offset of its master and streams what it gets
+as is to its own replicas, so once promoted by REPLICAOF NO ONE it can
+continue the history of the replicas of its old master: it gets a new ID
+and keeps the old one as id2, valid up to the offset it was promoted at.
+*/
+var repl = struct {
+	mu           sync.Mutex
+	id           string
+	id2          string
+	secondOffset int64 // first offset id2 isn't valid for, -1 without id2
+	offset       int64
+	backlog      *backlog // nil until a replica syncs
+	backlogSize  int
+	replicas     []*replica
+	master       *masterLink // nil for a master
+	readOnly     bool        // replicas refuse the write commands of their clients
+	masterUser   string      // authenticates to the master with AUTH if set
+	masterAuth   string
+	lastPing     time.Time
+	lastAck      time.Time
+
+	// INFO stats
+	syncFull         int64
+	syncPartialOK    int64
+	syncPartialError int64
+}{
+	id:           newReplicationID(),
+	secondOffset: -1,
+	backlogSize:  REPL_BACKLOG_SIZE_DEFAULT,
+	readOnly:     true,
+}
+
+// replApplyMu is held while a replica applies a command of its master: the
+// snapshot a replica of the replica gets then matches the offset
+var replApplyMu sync.Mutex
+
+// listeningPort is the port the replicas announce to their master, so it
+// can be listed by INFO replication
+var listeningPort int
+
+func newReplicationID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatalf("Error generating a replication ID: %v", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// backlog keeps the end of the history. The bytes are appended and the
+// buffer is trimmed to its size once it holds twice as much.
+type backlog struct {
+	buf  []byte
+	size int
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{size: size}
+}
+
+func (b *backlog) feed(p []byte) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > 2*b.size {
+		b.buf = append([]byte(nil), b.buf[len(b.buf)-b.size:]...)
+	}
+}
+
+// histlen returns the number of bytes of history kept
+func (b *backlog) histlen() int64 {
+	return int64(min(len(b.buf), b.size))
+}
+
+// since returns the history from offset on, end being the offset of its
+// last byte. It returns false if the backlog doesn't go back that far.
+func (b *backlog) since(offset int64, end int64) ([]byte, bool) {
+	n := end - offset + 1
+	if n < 0 || n > b.histlen() {
+		return nil, false
+	}
+	return b.buf[int64(len(b.buf))-n:], true
+}
+
+// replica is a replica connected to this server
+type replica struct {
+	client       *Client
+	port         int // the port it listens on, from REPLCONF listening-port
+	ackOffset    int64
+	aofAckOffset int64 // offset its AOF was fsynced at, for WAITAOF
+	ackTime      time.Time
+}
+
+// masterLink is the connection of a replica to its master, it connects
+// again until it is stopped
+type masterLink struct {
+	host    string
+	port    int
+	stop    chan struct{} // closed by stopLocked
+	writeMu sync.Mutex    // serializes the writes to conn
+
+	// guarded by repl.mu
+	state     int
+	conn      net.Conn
+	lastIO    time.Time
+	downSince time.Time
+}
+
+func (m *masterLink) addr() string {
+	return net.JoinHostPort(m.host, strconv.Itoa(m.port))
+}
+
+// stopLocked stops the link, repl.mu must be held
+func (m *masterLink) stopLocked() {
+	close(m.stop)
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
+
+// feedLocked appends p to the history, sending it to the replicas. repl.mu
+// must be held.
+func feedLocked(p []byte) {
+	if repl.backlog == nil {
+		return // nobody to send the history to
+	}
+	repl.backlog.feed(p)
+	repl.offset += int64(len(p))
+	for _, r := range repl.replicas {
+		r.client.out.Write(p)
+	}
+}
+
+// feedReplicas appends a write command to the history, it returns the
+// offset of the history once it's added
+func feedReplicas(cmd *parser.Command) int64 {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog != nil {
+		feedLocked([]byte(aof.EncodeCommand(replicationCommand(cmd))))
+	}
+	return repl.offset
+}
+
+// feedTransaction appends the write commands of a transaction to the
+// history, wrapped in MULTI/EXEC so the replicas apply them atomically.
+// It returns the offset of the history once they're added.
+func feedTransaction(cmds []*parser.Command) int64 {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog == nil {
+		return repl.offset
+	}
+	var buf strings.Builder
+	buf.WriteString(aof.EncodeCommand(&parser.Command{Name: "MULTI"}))
+	for _, cmd := range cmds {
+		buf.WriteString(aof.EncodeCommand(replicationCommand(cmd)))
+	}
+	buf.WriteString(aof.EncodeCommand(&parser.Command{Name: "EXEC"}))
+	feedLocked([]byte(buf.String()))
+	return repl.offset
+}
+
+// replicationCommand returns the command the replicas run for cmd: an
+// EXPIRE becomes an EXPIREAT and a RESTORE with a TTL gets ABSTTL, so the
+// key expires at the same time
+func replicationCommand(cmd *parser.Command) *parser.Command {
+	if strings.EqualFold(cmd.Name, "RESTORE") {
+		return absoluteRestore(cmd)
+	}
+	if !strings.EqualFold(cmd.Name, "EXPIRE") || len(cmd.Args) != 2 {
+		return cmd
+	}
+	seconds, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		return cmd
+	}
+	at := strconv.FormatInt(time.Now().Unix()+seconds, 10)
+	return &parser.Command{Name: "EXPIREAT", Args: []string{cmd.Args[0], at}}
+}
+
+// absoluteRestore returns RESTORE key ttl payload [options] with the TTL
+// as a unix time in milliseconds
+func absoluteRestore(cmd *parser.Command) *parser.Command {
+	ttl, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil || ttl == 0 {
+		return cmd
+	}
+	for _, option := range cmd.Args[3:] {
+		if strings.EqualFold(option, "ABSTTL") {
+			return cmd
+		}
+	}
+	args := append([]string{cmd.Args[0], strconv.FormatInt(time.Now().UnixMilli()+ttl, 10)}, cmd.Args[2:]...)
+	return &parser.Command{Name: cmd.Name, Args: append(args, "ABSTTL")}
+}
+
+// canContinueLocked reports if a replica asking for the history of id from
+// offset on can get it from the backlog, repl.mu must be held
+func canContinueLocked(id string, offset int64) bool {
+	if repl.backlog == nil {
+		return false
+	}
+	if id != repl.id && (id != repl.id2 || offset > repl.secondOffset) {
+		return false
+	}
+	_, ok := repl.backlog.since(offset, repl.offset)
+	return ok
+}
+
+// psync handles PSYNC replicationid offset and SYNC: the connection becomes
+// a replica, that gets the history it lacks from the backlog when possible
+// and a snapshot of the dataset otherwise
+func (c *Client) psync(name string, args []string) {
+	w := c.writer()
+	if c.conn == nil {
+		w.WriteError("ERR Replication is only possible over a network connection")
+		return
+	}
+	if c.flags&CLIENT_REPLICA != 0 {
+		return // already a replica
+	}
+
+	replApplyMu.Lock()
+	defer replApplyMu.Unlock()
+	execMu.Lock()
+	defer execMu.Unlock()
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+
+	if m := repl.master; m != nil && m.state != REPL_STATE_CONNECTED {
+		w.WriteError("NOMASTERLINK Can't SYNC while not connected with my master")
+		return
+	}
+
+	addr := c.conn.RemoteAddr().String()
+	partial := false
+	if name == "PSYNC" {
+		offset, err := strconv.ParseInt(args[1], 10, 64)
+		partial = err == nil && canContinueLocked(args[0], offset)
+		if partial {
+			history, _ := repl.backlog.since(offset, repl.offset)
+			fmt.Fprintf(c.out, "+CONTINUE %s\r\n", repl.id)
+			c.out.Write(history)
+			repl.syncPartialOK++
+			log.Printf("Partial resynchronization request from %s accepted, sending %d bytes of backlog starting from offset %d", addr, len(history), offset)
+		} else if args[0] != "?" {
+			repl.syncPartialError++
+		}
+	}
+	if !partial {
+		var buf bytes.Buffer
+		start := time.Now()
+		if err := snapshot.Write(&buf, c.store); err != nil {
+			w.WriteError(fmt.Sprintf("ERR Error writing the snapshot: %v", err))
+			return
+		}
+		writeFunctions(&buf)
+		metrics.snapshotSaveDuration.record(time.Since(start))
+		if repl.backlog == nil {
+			repl.backlog = newBacklog(repl.backlogSize)
+		}
+		if name == "PSYNC" {
+			fmt.Fprintf(c.out, "+FULLRESYNC %s %d\r\n", repl.id, repl.offset)
+		}
+		fmt.Fprintf(c.out, "$%d\r\n", buf.Len())
+		c.out.Write(buf.Bytes())
+		repl.syncFull++
+		log.Printf("Full resynchronization requested by %s, sent a snapshot of %d bytes", addr, buf.Len())
+	}
+
+	c.flags |= CLIENT_REPLICA
+	repl.replicas = append(repl.replicas, &replica{client: c, port: c.listeningPort, ackTime: time.Now()})
+	clients.mu.Lock()
+	c.listed.replica = true
+	clients.mu.Unlock()
+}
+
+// removeReplica forgets a replica once its connection is gone
+func removeReplica(c *Client) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	for i, r := range repl.replicas {
+		if r.client == c {
+			repl.replicas = append(repl.replicas[:i], repl.replicas[i+1:]...)
+			return
+		}
+	}
+}
+
+// disconnectReplicasLocked closes the connection of the replicas, so they
+// sync again. repl.mu must be held.
+func disconnectReplicasLocked() {
+	for _, r := range repl.replicas {
+		r.client.conn.Close()
+	}
+}
+
+// replconf handles REPLCONF option value [option value ...], sent by the
+// replicas to their master
+func (c *Client) replconf(args []string) {
+	w := c.writer()
+	if len(args)%2 != 0 {
+		w.WriteError("ERR syntax error")
+		return
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				w.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+			c.listeningPort = port
+		case "ack":
+			// ACK offset [FACK aofoffset], acknowledgements get no reply
+			offset, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return
+			}
+			aofOffset := int64(-1)
+			if i+3 < len(args) && strings.EqualFold(args[i+2], "fack") {
+				if fack, err := strconv.ParseInt(args[i+3], 10, 64); err == nil {
+					aofOffset = fack
+				}
+			}
+			acknowledge(c, offset, aofOffset)
+			return
+		case "getack":
+			return // only sent by a master, see masterLink.stream
+		case "capa", "ip-address":
+			// nothing to do
+		default:
+			w.WriteError(fmt.Sprintf("ERR Unrecognized REPLCONF option: %s", args[i]))
+			return
+		}
+	}
+	w.WriteSimpleString("OK")
+}
+
+// acknowledge records the offset a replica reached and the one its AOF
+// was fsynced at (-1 if it didn't tell), waking the clients in WAIT
+func acknowledge(c *Client, offset int64, aofOffset int64) {
+	repl.mu.Lock()
+	for _, r := range repl.replicas {
+		if r.client == c {
+			r.ackOffset = offset
+			if aofOffset >= 0 {
+				r.aofAckOffset = aofOffset
+			}
+			r.ackTime = time.Now()
+		}
+	}
+	repl.mu.Unlock()
+	signalWaiters()
+}
+
+// replicaof handles REPLICAOF host port and REPLICAOF NO ONE
+func (c *Client) replicaof(args []string) {
+	w := c.writer()
+	if clusterEnabled() {
+		w.WriteError("ERR REPLICAOF not allowed in cluster mode.")
+		return
+	}
+	if strings.EqualFold(args[0], "no") && strings.EqualFold(args[1], "one") {
+		if replicationUnsetMaster() {
+			log.Print("MASTER MODE enabled")
+		}
+		w.WriteSimpleString("OK")
+		return
+	}
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		w.WriteError("ERR Invalid master port")
+		return
+	}
+	if !replicationSetMaster(args[0], port) {
+		w.WriteSimpleString("OK Already connected to specified master")
+		return
+	}
+	log.Printf("REPLICAOF %s:%d enabled", args[0], port)
+	w.WriteSimpleString("OK")
+}
+
+// replicationSetMaster makes the server a replica of host:port. It returns
+// false if it already is.
+func replicationSetMaster(host string, port int) bool {
+	repl.mu.Lock()
+	if m := repl.master; m != nil {
+		if m.host == host && m.port == port {
+			repl.mu.Unlock()
+			return false
+		}
+		m.stopLocked()
+	}
+	m := &masterLink{host: host, port: port, stop: make(chan struct{}), downSince: time.Now()}
+	repl.master = m
+	repl.mu.Unlock()
+	go m.run()
+	return true
+}
+
+// replicationUnsetMaster turns a replica into a master continuing the
+// history of its old master. It returns false if the server is a master.
+func replicationUnsetMaster() bool {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.master == nil {
+		return false
+	}
+	repl.master.stopLocked()
+	repl.master = nil
+	repl.id2, repl.secondOffset = repl.id, repl.offset+1
+	repl.id = newReplicationID()
+	// they continue with the new ID once reconnected
+	disconnectReplicasLocked()
+	return true
+}
+
+// replicationRole returns "master" or "replica"
+func replicationRole() string {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.master != nil {
+		return "replica"
+	}
+	return "master"
+}
+
+// isReadOnlyReplica reports if the write commands of the clients are refused
+func isReadOnlyReplica() bool {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	return repl.master != nil && repl.readOnly
+}
+
+// run keeps the replica synchronized until the link is stopped
+func (m *masterLink) run() {
+	for {
+		err := m.sync()
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+		log.Printf("Connection with master %s lost: %v", m.addr(), err)
+		repl.mu.Lock()
+		if m.state == REPL_STATE_CONNECTED {
+			m.downSince = time.Now()
+		}
+		m.state = REPL_STATE_CONNECT
+		m.conn = nil
+		repl.mu.Unlock()
+
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(REPL_RETRY_PERIOD):
+		}
+	}
+}
+
+// send writes a command to the master
+func (m *masterLink) send(conn net.Conn, words ...string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(REPL_TIMEOUT))
+	_, err := io.WriteString(conn, aof.EncodeCommand(&parser.Command{Name: words[0], Args: words[1:]}))
+	return err
+}
+
+// readLine reads a line of the master without its CRLF
+func readLine(conn net.Conn, reader *bufio.Reader) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(REPL_TIMEOUT))
+	line, err := reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// command sends a command of the handshake and reads its reply
+func (m *masterLink) command(conn net.Conn, reader *bufio.Reader, words ...string) (string, error) {
+	if err := m.send(conn, words...); err != nil {
+		return "", err
+	}
+	line, err := readLine(conn, reader)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "-") {
+		return "", fmt.Errorf("%s failed: %s", words[0], line[1:])
+	}
+	return line, nil
+}
+
+// sync connects to the master, synchronizes the dataset and applies the
+// stream of write commands until the connection is lost
+func (m *masterLink) sync() error {
+	conn, err := net.DialTimeout("tcp", m.addr(), REPL_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	repl.mu.Lock()
+	select {
+	case <-m.stop:
+		repl.mu.Unlock()
+		return errors.New("replication stopped")
+	default:
+	}
+	m.conn, m.state, m.lastIO = conn, REPL_STATE_CONNECTING, time.Now()
+	id, offset := repl.id, repl.offset
+	user, password := repl.masterUser, repl.masterAuth
+	repl.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	if _, err := m.command(conn, reader, "PING"); err != nil && !strings.Contains(err.Error(), "NOAUTH") {
+		return err
+	}
+	if password != "" {
+		words := []string{"AUTH", password}
+		if user != "" {
+			words = []string{"AUTH", user, password}
+		}
+		if _, err := m.command(conn, reader, words...); err != nil {
+			return err
+		}
+	}
+	if listeningPort > 0 {
+		if _, err := m.command(conn, reader, "REPLCONF", "listening-port", strconv.Itoa(listeningPort)); err != nil {
+			return err
+		}
+	}
+	if _, err := m.command(conn, reader, "REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+	reply, err := m.command(conn, reader, "PSYNC", id, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+
+	switch fields := strings.Fields(reply); {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset in %q", reply)
+		}
+		repl.mu.Lock()
+		m.state = REPL_STATE_TRANSFER
+		repl.mu.Unlock()
+		header, err := readLine(conn, reader)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+		if err != nil || !strings.HasPrefix(header, "$") {
+			return fmt.Errorf("invalid snapshot header %q", header)
+		}
+		data := make([]byte, size)
+		conn.SetReadDeadline(time.Now().Add(REPL_TIMEOUT))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return err
+		}
+		if err := loadSnapshot(fields[1], masterOffset, data); err != nil {
+			return err
+		}
+		log.Printf("MASTER <-> REPLICA sync: loaded a snapshot of %d bytes", size)
+	case len(fields) > 0 && fields[0] == "+CONTINUE":
+		repl.mu.Lock()
+		if len(fields) == 2 && fields[1] != repl.id {
+			// the master was promoted meanwhile
+			repl.id2, repl.secondOffset = repl.id, repl.offset+1
+			repl.id = fields[1]
+			disconnectReplicasLocked()
+		}
+		if repl.backlog == nil {
+			repl.backlog = newBacklog(repl.backlogSize)
+		}
+		repl.mu.Unlock()
+		log.Print("MASTER <-> REPLICA sync: master accepted a partial resynchronization")
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC: %q", reply)
+	}
+
+	repl.mu.Lock()
+	m.state, m.lastIO = REPL_STATE_CONNECTED, time.Now()
+	repl.mu.Unlock()
+	return m.stream(conn, reader)
+}
+
+// loadSnapshot replaces the dataset with the snapshot of the master, and
+// takes its history
+func loadSnapshot(id string, offset int64, data []byte) error {
+	replApplyMu.Lock()
+	defer replApplyMu.Unlock()
+	execMu.Lock()
+	defer execMu.Unlock()
+
+	start := time.Now()
+	kvStore.Flush()
+	flushFunctions()
+	err := snapshot.Read(data, func(cmd *parser.Command) {
+		ExecuteCommand(cmd, kvStore, io.Discard)
+	})
+	if err != nil {
+		return err
+	}
+	metrics.snapshotLoadDuration.record(time.Since(start))
+	if aofManager != nil {
+		if err := aofManager.Rewrite(data); err != nil {
+			log.Fatalf("failed to rewrite the AOF file: %v", err)
+		}
+	}
+
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	repl.id, repl.offset = id, offset
+	repl.id2, repl.secondOffset = "", -1
+	repl.backlog = newBacklog(repl.backlogSize)
+	// their history is no longer ours
+	disconnectReplicasLocked()
+	return nil
+}
+
+// stream applies the write commands of the master, and sends them as is
+// to the replicas of the replica. A transaction is only added to the
+// history once it is applied as a whole.
+func (m *masterLink) stream(conn net.Conn, reader *bufio.Reader) error {
+	client := NewClient(kvStore, aofManager, io.Discard)
+	client.flags |= CLIENT_MASTER
+	client.authenticated = true
+	defer client.Close()
+
+	var pending []byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(REPL_TIMEOUT))
+		cmd, err := parser.ReadCommand(reader)
+		if err != nil {
+			return err
+		}
+		repl.mu.Lock()
+		m.lastIO = time.Now()
+		repl.mu.Unlock()
+		if cmd.Name == "" {
+			continue
+		}
+
+		getAck := strings.EqualFold(cmd.Name, "REPLCONF") && len(cmd.Args) > 0 && strings.EqualFold(cmd.Args[0], "GETACK")
+		replApplyMu.Lock()
+		if !getAck {
+			client.ProcessCommand(cmd)
+		}
+		pending = append(pending, aof.EncodeCommand(cmd)...)
+		if client.flags&CLIENT_MULTI == 0 {
+			repl.mu.Lock()
+			feedLocked(pending)
+			repl.mu.Unlock()
+			pending = pending[:0]
+		}
+		replApplyMu.Unlock()
+		if getAck {
+			m.sendAck()
+		}
+	}
+}
+
+// sendAck tells the master the offset the replica reached, and the one
+// its AOF was fsynced at
+func (m *masterLink) sendAck() {
+	repl.mu.Lock()
+	conn, state, offset := m.conn, m.state, repl.offset
+	repl.mu.Unlock()
+	if conn != nil && state == REPL_STATE_CONNECTED {
+		m.send(conn, "REPLCONF", "ACK", strconv.FormatInt(offset, 10), "FACK", strconv.FormatInt(aofFsyncedReplOffset(), 10))
+	}
+}
+
+// replicationCron runs the periodic tasks of the replication: masters ping
+// their replicas and drop the silent ones, replicas acknowledge their offset
+func replicationCron() {
+	now := time.Now()
+	repl.mu.Lock()
+	if repl.master == nil && len(repl.replicas) > 0 && now.Sub(repl.lastPing) >= REPL_PING_PERIOD {
+		repl.lastPing = now
+		feedLocked([]byte(aof.EncodeCommand(&parser.Command{Name: "PING"})))
+	}
+	for _, r := range repl.replicas {
+		if now.Sub(r.ackTime) > REPL_TIMEOUT {
+			log.Printf("Disconnecting timedout replica %s", r.client.conn.RemoteAddr())
+			r.client.conn.Close()
+		}
+	}
+	master := repl.master
+	ack := master != nil && now.Sub(repl.lastAck) >= REPL_ACK_PERIOD
+	if ack {
+		repl.lastAck = now
+	}
+	repl.mu.Unlock()
+	if ack {
+		master.sendAck()
+	}
+}
+
+// writeReplicationInfo writes the replication section of INFO
+func writeReplicationInfo(b *strings.Builder) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	now := time.Now()
+	b.WriteString("# Replication\r\n")
+	if m := repl.master; m != nil {
+		fmt.Fprintf(b, "role:slave\r\nmaster_host:%s\r\nmaster_port:%d\r\n", m.host, m.port)
+		status, lastIO := "down", int64(-1)
+		if m.state == REPL_STATE_CONNECTED {
+			status, lastIO = "up", int64(now.Sub(m.lastIO).Seconds())
+		}
+		syncing := 0
+		if m.state == REPL_STATE_TRANSFER {
+			syncing = 1
+		}
+		fmt.Fprintf(b, "master_link_status:%s\r\nmaster_last_io_seconds_ago:%d\r\nmaster_sync_in_progress:%d\r\n", status, lastIO, syncing)
+		fmt.Fprintf(b, "slave_read_repl_offset:%d\r\nslave_repl_offset:%d\r\n", repl.offset, repl.offset)
+		if m.state != REPL_STATE_CONNECTED {
+			fmt.Fprintf(b, "master_link_down_since_seconds:%d\r\n", int64(now.Sub(m.downSince).Seconds()))
+		}
+		readOnly := 0
+		if repl.readOnly {
+			readOnly = 1
+		}
+		fmt.Fprintf(b, "slave_read_only:%d\r\n", readOnly)
+	} else {
+		b.WriteString("role:master\r\n")
+	}
+	fmt.Fprintf(b, "connected_slaves:%d\r\n", len(repl.replicas))
+	for i, r := range repl.replicas {
+		host, _, _ := net.SplitHostPort(r.client.conn.RemoteAddr().String())
+		fmt.Fprintf(b, "slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d\r\n", i, host, r.port, r.ackOffset, int64(now.Sub(r.ackTime).Seconds()))
+	}
+	id2 := repl.id2
+	if id2 == "" {
+		id2 = replicationIDNone
+	}
+	fmt.Fprintf(b, "master_replid:%s\r\nmaster_replid2:%s\r\n", repl.id, id2)
+	fmt.Fprintf(b, "master_repl_offset:%d\r\nsecond_repl_offset:%d\r\n", repl.offset, repl.secondOffset)
+	if repl.backlog != nil {
+		histlen := repl.backlog.histlen()
+		fmt.Fprintf(b, "repl_backlog_active:1\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:%d\r\nrepl_backlog_histlen:%d\r\n",
+			repl.backlogSize, repl.offset-histlen+1, histlen)
+	} else {
+		fmt.Fprintf(b, "repl_backlog_active:0\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:0\r\nrepl_backlog_histlen:0\r\n", repl.backlogSize)
+	}
+}
+
+// writeReplicationStats writes the synchronizations counted by INFO stats
+func writeReplicationStats(b *strings.Builder) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	fmt.Fprintf(b, "sync_full:%d\r\nsync_partial_ok:%d\r\nsync_partial_err:%d\r\n", repl.syncFull, repl.syncPartialOK, repl.syncPartialError)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+)
+
+// TestMain runs the server instead of the tests when YAKVS_TEST_SERVER
+// holds its arguments, one per line, so tests can start server processes
+func TestMain(m *testing.M) {
+	if args := os.Getenv("YAKVS_TEST_SERVER"); args != "" {
+		os.Args = append(os.Args[:1], strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// startProcess runs a server in a process of its own and returns its
+// address, the process is killed at the end of the test if still running
+func startProcess(t *testing.T, args ...string) (string, *os.Process) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error finding a free port: %v", err)
+	}
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	args = append([]string{"-port", port, "-appendfilename", filepath.Join(t.TempDir(), "appendonly.aof")}, args...)
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "YAKVS_TEST_SERVER="+strings.Join(args, "\n"))
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Error starting the server: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addr := "127.0.0.1:" + port
+	eventually(t, "the server to accept connections", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	})
+	return addr, cmd.Process
+}
+
+// eventually waits for condition to hold
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	eventuallyWithin(t, 10*time.Second, what, condition)
+}
+
+func eventuallyWithin(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// resetReplication makes the server a master without replicas again
+func resetReplication(t *testing.T) {
+	t.Cleanup(func() {
+		replicationUnsetMaster()
+		repl.mu.Lock()
+		defer repl.mu.Unlock()
+		repl.backlog = nil
+		repl.offset = 0
+		repl.id2, repl.secondOffset = "", -1
+		repl.syncFull, repl.syncPartialOK, repl.syncPartialError = 0, 0, 0
+	})
+}
+
+// readLine reads a line without its CRLF
+func (c *testConn) readLine() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Error reading a line: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// readSnapshot reads the payload of a full resync, sent without a CRLF
+func (c *testConn) readSnapshot() string {
+	c.t.Helper()
+	header := c.readLine()
+	n, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+	if err != nil || !strings.HasPrefix(header, "$") {
+		c.t.Fatalf("Expected a snapshot header, got %q", header)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.reader, buf); err != nil {
+		c.t.Fatalf("Error reading the snapshot: %v", err)
+	}
+	return string(buf)
+}
+
+// info returns the fields of an INFO section
+func (c *testConn) info(section string) map[string]string {
+	c.t.Helper()
+	c.send("INFO", section)
+	fields := make(map[string]string)
+	for _, line := range strings.Split(c.readBulk(), "\r\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// expectCommand reads a command of the replication stream
+func (c *testConn) expectCommand(words ...string) {
+	c.t.Helper()
+	c.expect(aof.EncodeCommand(&parser.Command{Name: words[0], Args: words[1:]}))
+}
+
+func TestBacklog(t *testing.T) {
+	b := newBacklog(8)
+	b.feed([]byte("abcdef"))
+	if history, ok := b.since(3, 6); !ok || string(history) != "cdef" {
+		t.Errorf("Expected cdef from offset 3, got %q %v", history, ok)
+	}
+	if history, ok := b.since(7, 6); !ok || len(history) != 0 {
+		t.Errorf("Expected nothing past the end, got %q %v", history, ok)
+	}
+	b.feed([]byte("ghijklmnop"))
+	if b.histlen() != 8 {
+		t.Errorf("Expected the history to be trimmed to 8 bytes, got %d", b.histlen())
+	}
+	if _, ok := b.since(8, 16); ok {
+		t.Error("Expected offset 8 to be gone")
+	}
+	if history, ok := b.since(9, 16); !ok || string(history) != "ijklmnop" {
+		t.Errorf("Expected ijklmnop from offset 9, got %q %v", history, ok)
+	}
+	if _, ok := b.since(18, 16); ok {
+		t.Error("Expected an offset after the end to be refused")
+	}
+}
+
+func TestReplicationCommand(t *testing.T) {
+	cmd := replicationCommand(&parser.Command{Name: "expire", Args: []string{"k", "100"}})
+	at, _ := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if cmd.Name != "EXPIREAT" || cmd.Args[0] != "k" || at < time.Now().Unix()+99 || at > time.Now().Unix()+100 {
+		t.Errorf("Expected EXPIRE to become an EXPIREAT, got %v", cmd)
+	}
+	set := &parser.Command{Name: "SET", Args: []string{"k", "v"}}
+	if replicationCommand(set) != set {
+		t.Error("Expected SET to be streamed as is")
+	}
+}
+
+func TestMasterSync(t *testing.T) {
+	resetACL(t)
+	resetReplication(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.send("SET", "repl:before", "snapshot")
+	c.expect("+OK\r\n")
+
+	// a replica, by hand
+	r := dial(t, addr)
+	r.send("REPLCONF", "listening-port", "7777")
+	r.expect("+OK\r\n")
+	r.send("REPLCONF", "capa", "psync2")
+	r.expect("+OK\r\n")
+	r.send("PSYNC", "?", "-1")
+	fields := strings.Fields(r.readLine())
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" || fields[2] != "0" {
+		t.Fatalf("Expected a full resync from offset 0, got %v", fields)
+	}
+	id := fields[1]
+	if !strings.Contains(r.readSnapshot(), aof.EncodeCommand(&parser.Command{Name: "SET", Args: []string{"repl:before", "snapshot"}})) {
+		t.Error("Expected the snapshot to hold the key set before")
+	}
+
+	c.send("SET", "repl:after", "stream")
+	c.expect("+OK\r\n")
+	r.expectCommand("SET", "repl:after", "stream")
+	c.send("GET", "repl:after")
+	c.expect("$6\r\nstream\r\n")
+	c.send("EXPIRE", "repl:after", "100")
+	c.expect("+OK\r\n")
+	r.expectCommand("EXPIREAT", "repl:after", strconv.FormatInt(time.Now().Unix()+100, 10))
+	c.send("MULTI")
+	c.expect("+OK\r\n")
+	c.send("RPUSH", "repl:list", "a")
+	c.expect("+QUEUED\r\n")
+	c.send("EXEC")
+	c.expect("*1\r\n:1\r\n")
+	r.expectCommand("MULTI")
+	r.expectCommand("RPUSH", "repl:list", "a")
+	r.expectCommand("EXEC")
+
+	info := c.info("replication")
+	offset, _ := strconv.ParseInt(info["master_repl_offset"], 10, 64)
+	if info["role"] != "master" || info["connected_slaves"] != "1" || info["master_replid"] != id || offset == 0 {
+		t.Errorf("Unexpected replication info %v", info)
+	}
+	r.send("REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+	eventually(t, "the acknowledgement", func() bool {
+		return strings.Contains(c.info("replication")["slave0"], fmt.Sprintf("port=7777,state=online,offset=%d,", offset))
+	})
+	c.send("CLIENT", "LIST", "TYPE", "replica")
+	if list := c.readBulk(); !strings.Contains(list, " flags=S ") || strings.Count(list, "\n") != 1 {
+		t.Errorf("Expected the replica to be listed, got %q", list)
+	}
+
+	t.Run("partial resync", func(t *testing.T) {
+		r.conn.Close()
+		eventually(t, "the replica to be gone", func() bool { return c.info("replication")["connected_slaves"] == "0" })
+		c.send("SET", "repl:missed", "1")
+		c.expect("+OK\r\n")
+
+		r := dial(t, addr)
+		r.send("PSYNC", id, strconv.FormatInt(offset+1, 10))
+		r.expect("+CONTINUE " + id + "\r\n")
+		r.expectCommand("SET", "repl:missed", "1")
+		if stats := c.info("stats"); stats["sync_full"] != "1" || stats["sync_partial_ok"] != "1" {
+			t.Errorf("Unexpected sync stats %v", stats)
+		}
+	})
+
+	t.Run("unknown history", func(t *testing.T) {
+		r := dial(t, addr)
+		r.send("PSYNC", newReplicationID(), "1")
+		if line := r.readLine(); !strings.HasPrefix(line, "+FULLRESYNC "+id+" ") {
+			t.Errorf("Expected a full resync, got %q", line)
+		}
+		if stats := c.info("stats"); stats["sync_partial_err"] != "1" {
+			t.Errorf("Unexpected sync stats %v", stats)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c.send("REPLCONF", "listening-port")
+		c.expect("-ERR syntax error\r\n")
+		c.send("REPLCONF", "nosuch", "1")
+		c.expect("-ERR Unrecognized REPLCONF option: nosuch\r\n")
+		c.send("MULTI")
+		c.expect("+OK\r\n")
+		c.send("PSYNC", "?", "-1")
+		c.expect("-ERR Command not allowed inside a transaction\r\n")
+		c.send("DISCARD")
+		c.expect("+OK\r\n")
+		c.send("REPLICAOF", "localhost", "port")
+		c.expect("-ERR Invalid master port\r\n")
+	})
+}
+
+func TestEvictionPropagation(t *testing.T) {
+	resetConfig(t)
+	resetReplication(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+	kvStore.Flush()
+
+	r := dial(t, addr)
+	r.send("PSYNC", "?", "-1")
+	r.readLine()
+	r.readSnapshot()
+
+	value := strings.Repeat("x", 1024)
+	c.send("CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	c.expect("+OK\r\n")
+	c.send("SET", "evict:0", value)
+	c.expect("+OK\r\n")
+	c.send("SET", "evict:1", value)
+	c.expect("+OK\r\n")
+	// a single key fits, the next write evicts one of the two
+	c.send("CONFIG", "SET", "maxmemory", strconv.FormatInt(kvStore.UsedMemory()*2/3, 10))
+	c.expect("+OK\r\n")
+	c.send("SET", "evict:2", value)
+	c.expect("+OK\r\n")
+
+	r.expectCommand("SET", "evict:0", value)
+	r.expectCommand("SET", "evict:1", value)
+	r.expectCommand("MULTI")
+	del, err := parser.ReadValue(r.reader)
+	if err != nil {
+		t.Fatalf("Error reading the replication stream: %v", err)
+	}
+	words := del.Words()
+	if len(words) != 2 || words[0] != "DEL" || (words[1] != "evict:0" && words[1] != "evict:1") {
+		t.Fatalf("Expected the evicted key deleted, got %v", words)
+	}
+	if kvStore.Exists(words[1]) {
+		t.Errorf("Expected %s to be evicted", words[1])
+	}
+	r.expectCommand("SET", "evict:2", value)
+	r.expectCommand("EXEC")
+}
+
+func TestReplicaOf(t *testing.T) {
+	resetACL(t)
+	resetReplication(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+	id := c.info("replication")["master_replid"]
+
+	// nothing listens on port 1, the link stays down
+	c.send("REPLICAOF", "127.0.0.1", "1")
+	c.expect("+OK\r\n")
+	c.send("REPLICAOF", "127.0.0.1", "1")
+	c.expect("+OK Already connected to specified master\r\n")
+	info := c.info("replication")
+	if info["role"] != "slave" || info["master_host"] != "127.0.0.1" || info["master_port"] != "1" || info["master_link_status"] != "down" || info["slave_read_only"] != "1" {
+		t.Errorf("Unexpected replication info %v", info)
+	}
+	c.send("SET", "repl:readonly", "1")
+	c.expect("-READONLY You can't write against a read only replica.\r\n")
+	c.send("GET", "repl:readonly")
+	c.expect("$-1\r\n")
+	c.send("MULTI")
+	c.expect("+OK\r\n")
+	c.send("SET", "repl:readonly", "1")
+	c.expect("-READONLY You can't write against a read only replica.\r\n")
+	c.send("EXEC")
+	c.expect("-EXECABORT Transaction discarded because of previous errors.\r\n")
+	r := dial(t, addr)
+	r.send("PSYNC", "?", "-1")
+	r.expect("-NOMASTERLINK Can't SYNC while not connected with my master\r\n")
+
+	c.send("SLAVEOF", "no", "one")
+	c.expect("+OK\r\n")
+	info = c.info("replication")
+	if info["role"] != "master" || info["master_replid2"] != id || info["master_replid"] == id || info["second_repl_offset"] != "1" {
+		t.Errorf("Expected a new replication ID, the old one as the second, got %v", info)
+	}
+	c.send("SET", "repl:readonly", "1")
+	c.expect("+OK\r\n")
+}
+
+func TestReplicationProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts server processes")
+	}
+	master, _ := startProcess(t)
+	m := dial(t, master)
+	m.send("SET", "before", "sync")
+	m.expect("+OK\r\n")
+	m.send("RPUSH", "list", "a", "b")
+	m.expect(":2\r\n")
+
+	host, port, _ := net.SplitHostPort(master)
+	replicaAddr, _ := startProcess(t, "-replicaof", host+" "+port)
+	r := dial(t, replicaAddr)
+	eventually(t, "the link to be up", func() bool {
+		return r.info("replication")["master_link_status"] == "up"
+	})
+	r.send("GET", "before")
+	r.expect("$4\r\nsync\r\n")
+	r.send("LRANGE", "list", "0", "-1")
+	r.expect("*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+
+	m.send("SET", "after", "stream")
+	m.expect("+OK\r\n")
+	eventually(t, "the write to be replicated", func() bool {
+		r.send("GET", "after")
+		return r.readBulkOrNull() == "stream"
+	})
+	r.send("SET", "after", "replica")
+	r.expect("-READONLY You can't write against a read only replica.\r\n")
+	if info := m.info("replication"); info["connected_slaves"] != "1" || !strings.Contains(info["slave0"], "port="+strings.Split(replicaAddr, ":")[1]) {
+		t.Errorf("Expected the replica to be listed, got %v", info)
+	}
+
+	// a brief disconnect only needs the backlog
+	m.send("CLIENT", "LIST", "TYPE", "replica")
+	fields := listFields(m.readBulk())
+	if len(fields) != 1 {
+		t.Fatalf("Expected a replica, got %v", fields)
+	}
+	for id := range fields {
+		m.send("CLIENT", "KILL", "ID", id)
+		m.expect(":1\r\n")
+	}
+	m.send("SET", "during", "disconnect")
+	m.expect("+OK\r\n")
+	eventually(t, "the replica to catch up", func() bool {
+		r.send("GET", "during")
+		return r.readBulkOrNull() == "disconnect"
+	})
+	if stats := m.info("stats"); stats["sync_full"] != "1" || stats["sync_partial_ok"] != "1" {
+		t.Errorf("Expected a full sync then a partial one, got %v", stats)
+	}
+
+	r.send("REPLICAOF", "NO", "ONE")
+	r.expect("+OK\r\n")
+	r.send("SET", "after", "promoted")
+	r.expect("+OK\r\n")
+}
+
+// readBulkOrNull reads a bulk string or a null, returned as ""
+func (c *testConn) readBulkOrNull() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, _ := c.reader.Peek(3)
+	if string(b) == "$-1" {
+		c.readLine()
+		return ""
+	}
+	return c.readBulk()
+}
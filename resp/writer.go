@@ -0,0 +1,170 @@
+// Package resp encodes replies in the protocol version chosen by the client.
+//
+// RESP3 has types of its own (maps, sets, doubles, booleans...) that RESP2
+// clients don't understand, so every one of them has a RESP2 fallback: a map
+// is sent as a flat array of key/value pairs, a double as a bulk string, a
+// boolean as the integer 1 or 0, and so on. Commands write the reply they
+// mean and the writer picks the encoding.
+package resp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Writer writes replies to w for a client speaking proto, 2 or 3. It is an
+// io.Writer too, so raw RESP can still be written through it.
+type Writer struct {
+	w     io.Writer
+	proto int
+}
+
+func NewWriter(w io.Writer, proto int) *Writer {
+	return &Writer{w: w, proto: proto}
+}
+
+// WriterFor returns out if it is a Writer already, otherwise a RESP2 writer
+// on out. Commands get their output as an io.Writer and use it to find the
+// protocol of the client.
+func WriterFor(out io.Writer) *Writer {
+	if w, ok := out.(*Writer); ok {
+		return w
+	}
+	return NewWriter(out, 2)
+}
+
+// Proto returns the protocol version the replies are encoded for
+func (w *Writer) Proto() int {
+	return w.proto
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *Writer) WriteSimpleString(s string) {
+	fmt.Fprintf(w.w, "+%s\r\n", s)
+}
+
+// WriteError writes an error, msg starts with its code like "ERR" or "WRONGTYPE"
+func (w *Writer) WriteError(msg string) {
+	fmt.Fprintf(w.w, "-%s\r\n", msg)
+}
+
+func (w *Writer) WriteInteger(n int64) {
+	fmt.Fprintf(w.w, ":%d\r\n", n)
+}
+
+func (w *Writer) WriteBulkString(s string) {
+	fmt.Fprintf(w.w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func (w *Writer) WriteArrayLen(n int) {
+	fmt.Fprintf(w.w, "*%d\r\n", n)
+}
+
+// WriteNull writes the null value, a null bulk string for RESP2
+func (w *Writer) WriteNull() {
+	if w.proto == 3 {
+		io.WriteString(w.w, "_\r\n")
+	} else {
+		io.WriteString(w.w, "$-1\r\n")
+	}
+}
+
+// WriteNullArray writes the null value where an array was expected, a null
+// array for RESP2
+func (w *Writer) WriteNullArray() {
+	if w.proto == 3 {
+		io.WriteString(w.w, "_\r\n")
+	} else {
+		io.WriteString(w.w, "*-1\r\n")
+	}
+}
+
+// WriteMapLen starts a map of n key/value pairs, an array of 2n elements for RESP2
+func (w *Writer) WriteMapLen(n int) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, "%%%d\r\n", n)
+	} else {
+		fmt.Fprintf(w.w, "*%d\r\n", 2*n)
+	}
+}
+
+// WriteSetLen starts a set of n elements, an array for RESP2
+func (w *Writer) WriteSetLen(n int) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, "~%d\r\n", n)
+	} else {
+		fmt.Fprintf(w.w, "*%d\r\n", n)
+	}
+}
+
+// WritePushLen starts an out of band push of n elements, like a pub/sub
+// message, an array for RESP2
+func (w *Writer) WritePushLen(n int) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, ">%d\r\n", n)
+	} else {
+		fmt.Fprintf(w.w, "*%d\r\n", n)
+	}
+}
+
+// WriteDouble writes a floating point number, a bulk string for RESP2
+func (w *Writer) WriteDouble(f float64) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, ",%s\r\n", FormatDouble(f))
+	} else {
+		w.WriteBulkString(FormatDouble(f))
+	}
+}
+
+// WriteBigNumber writes an integer too large for 64 bits given in decimal,
+// a bulk string for RESP2
+func (w *Writer) WriteBigNumber(n string) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, "(%s\r\n", n)
+	} else {
+		w.WriteBulkString(n)
+	}
+}
+
+// WriteBool writes a boolean, the integer 1 or 0 for RESP2
+func (w *Writer) WriteBool(b bool) {
+	switch {
+	case w.proto == 3 && b:
+		io.WriteString(w.w, "#t\r\n")
+	case w.proto == 3:
+		io.WriteString(w.w, "#f\r\n")
+	case b:
+		w.WriteInteger(1)
+	default:
+		w.WriteInteger(0)
+	}
+}
+
+// WriteVerbatim writes text meant for humans with its format, "txt" or
+// "mkd", a bulk string for RESP2
+func (w *Writer) WriteVerbatim(format string, s string) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, "=%d\r\n%s:%s\r\n", len(s)+4, format, s)
+	} else {
+		w.WriteBulkString(s)
+	}
+}
+
+// FormatDouble formats a double the way Redis replies with it: the shortest
+// representation reading back to the same value, and inf, -inf or nan
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
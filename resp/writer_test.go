@@ -0,0 +1,65 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *Writer)
+		resp2 string
+		resp3 string
+	}{
+		{"simple string", func(w *Writer) { w.WriteSimpleString("OK") }, "+OK\r\n", "+OK\r\n"},
+		{"error", func(w *Writer) { w.WriteError("ERR oops") }, "-ERR oops\r\n", "-ERR oops\r\n"},
+		{"integer", func(w *Writer) { w.WriteInteger(-42) }, ":-42\r\n", ":-42\r\n"},
+		{"bulk string", func(w *Writer) { w.WriteBulkString("hi") }, "$2\r\nhi\r\n", "$2\r\nhi\r\n"},
+		{"null", func(w *Writer) { w.WriteNull() }, "$-1\r\n", "_\r\n"},
+		{"null array", func(w *Writer) { w.WriteNullArray() }, "*-1\r\n", "_\r\n"},
+		{"map", func(w *Writer) {
+			w.WriteMapLen(1)
+			w.WriteBulkString("k")
+			w.WriteInteger(1)
+		}, "*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{"set", func(w *Writer) {
+			w.WriteSetLen(1)
+			w.WriteBulkString("a")
+		}, "*1\r\n$1\r\na\r\n", "~1\r\n$1\r\na\r\n"},
+		{"push", func(w *Writer) { w.WritePushLen(3) }, "*3\r\n", ">3\r\n"},
+		{"double", func(w *Writer) { w.WriteDouble(1.5) }, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"integral double", func(w *Writer) { w.WriteDouble(3) }, "$1\r\n3\r\n", ",3\r\n"},
+		{"infinite double", func(w *Writer) { w.WriteDouble(math.Inf(-1)) }, "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"big number", func(w *Writer) { w.WriteBigNumber("3492890328409238509324850943850943825024385") },
+			"$43\r\n3492890328409238509324850943850943825024385\r\n", "(3492890328409238509324850943850943825024385\r\n"},
+		{"true", func(w *Writer) { w.WriteBool(true) }, ":1\r\n", "#t\r\n"},
+		{"false", func(w *Writer) { w.WriteBool(false) }, ":0\r\n", "#f\r\n"},
+		{"verbatim", func(w *Writer) { w.WriteVerbatim("txt", "Some string") }, "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+	}
+	for _, tt := range tests {
+		for _, proto := range []int{2, 3} {
+			var buf bytes.Buffer
+			tt.write(NewWriter(&buf, proto))
+			expected := tt.resp2
+			if proto == 3 {
+				expected = tt.resp3
+			}
+			if buf.String() != expected {
+				t.Errorf("%s (RESP%d): expected %q, got %q", tt.name, proto, expected, buf.String())
+			}
+		}
+	}
+}
+
+func TestWriterFor(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 3)
+	if WriterFor(w) != w {
+		t.Error("Expected WriterFor to return the writer itself")
+	}
+	if proto := WriterFor(&buf).Proto(); proto != 2 {
+		t.Errorf("Expected a RESP2 writer for a plain io.Writer, got RESP%d", proto)
+	}
+}
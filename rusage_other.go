@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// processCPUTime returns the user and system CPU time used by the process,
+// which isn't known on this platform
+func processCPUTime() (time.Duration, time.Duration) {
+	return 0, 0
+}
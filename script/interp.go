@@ -0,0 +1,735 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// MAX_CALL_DEPTH bounds the nesting of the calls, deeper recursions fail
+// with a stack overflow
+const MAX_CALL_DEPTH = 200
+
+// INTERRUPT_PERIOD is the number of loop iterations and calls between two
+// checks of State.Interrupt
+const INTERRUPT_PERIOD = 1000
+
+// State runs functions with its globals, a call at a time
+type State struct {
+	Globals *Table
+
+	// Strict makes reading a global that isn't set an error, like
+	// assigning a global of a frozen Globals
+	Strict bool
+
+	// Interrupt is called while the scripts run, an error it returns
+	// stops them
+	Interrupt func() error
+
+	stringLib *Table // what strings are indexed with, as in ("x"):upper()
+	depth     int
+	steps     int
+
+	// where the function of the host being called was called from, see
+	// Where
+	chunk string
+	line  int
+}
+
+// NewState returns a state with the base, string, table and math
+// libraries in its globals
+func NewState() *State {
+	s := &State{Globals: NewTable()}
+	openBase(s)
+	return s
+}
+
+// Load compiles the source of a chunk into a function taking its
+// arguments as '...', chunk names it in the errors. The function can be
+// called by any state.
+func Load(chunk, src string) (*Function, error) {
+	proto, err := parse(chunk, src)
+	if err != nil {
+		return nil, err
+	}
+	return &Function{name: proto.name, proto: proto, chunk: chunk}, nil
+}
+
+// Call calls a function with args and returns what it returned. The
+// errors are of type *Error.
+func (s *State) Call(fn *Function, args ...Value) ([]Value, error) {
+	return s.call(fn, args)
+}
+
+// Where returns the position of the running script, as "chunk:line: "
+func (s *State) Where() string {
+	if s.chunk == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d: ", s.chunk, s.line)
+}
+
+// Errorf returns an error raised at the position of the running script
+func (s *State) Errorf(format string, args ...any) error {
+	return &Error{Value: s.Where() + fmt.Sprintf(format, args...), Line: s.line}
+}
+
+// tick counts a step of the scripts and calls Interrupt once in a while
+func (s *State) tick() error {
+	s.steps++
+	if s.steps%INTERRUPT_PERIOD != 0 || s.Interrupt == nil {
+		return nil
+	}
+	if err := s.Interrupt(); err != nil {
+		if e, ok := err.(*Error); ok {
+			return e
+		}
+		return &Error{Value: err.Error(), Fatal: true}
+	}
+	return nil
+}
+
+func (s *State) call(fn *Function, args []Value) ([]Value, error) {
+	if err := s.tick(); err != nil {
+		return nil, err
+	}
+	if s.depth >= MAX_CALL_DEPTH {
+		return nil, s.Errorf("stack overflow")
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+
+	if fn.native != nil {
+		rets, err := fn.native(s, args)
+		if err != nil {
+			if _, ok := err.(*Error); !ok {
+				err = &Error{Value: s.Where() + err.Error(), Line: s.line}
+			}
+			return nil, err
+		}
+		return rets, nil
+	}
+
+	sc := &scope{parent: fn.env}
+	for i, param := range fn.proto.params {
+		var v Value
+		if i < len(args) {
+			v = args[i]
+		}
+		sc.declare(param, v)
+	}
+	fr := &frame{fn: fn}
+	if fn.proto.vararg && len(args) > len(fn.proto.params) {
+		fr.varargs = args[len(fn.proto.params):]
+	}
+	if _, err := s.execBlock(fn.proto.body, sc, fr); err != nil {
+		return nil, err
+	}
+	return fr.ret, nil
+}
+
+// scope holds the locals of a block, the closures keep the cells of the
+// ones they use
+type scope struct {
+	parent *scope
+	names  []string
+	cells  []*Value
+}
+
+func (sc *scope) declare(name string, v Value) {
+	sc.names = append(sc.names, name)
+	sc.cells = append(sc.cells, &v)
+}
+
+// lookup returns the cell of a local, nil for a global. The last
+// declaration wins, as a local can shadow another in the same block.
+func (sc *scope) lookup(name string) *Value {
+	for ; sc != nil; sc = sc.parent {
+		for i := len(sc.names) - 1; i >= 0; i-- {
+			if sc.names[i] == name {
+				return sc.cells[i]
+			}
+		}
+	}
+	return nil
+}
+
+// frame is a call of a function of a script
+type frame struct {
+	fn      *Function
+	varargs []Value
+	ret     []Value
+}
+
+func (fr *frame) errorf(line int, format string, args ...any) error {
+	return &Error{Value: fmt.Sprintf("%s:%d: %s", fr.fn.chunk, line, fmt.Sprintf(format, args...)), Line: line}
+}
+
+// flow tells how a block ended
+type flow int
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowReturn
+)
+
+func (s *State) execBlock(b *block, sc *scope, fr *frame) (flow, error) {
+	for _, st := range b.stmts {
+		if f, err := s.exec(st, sc, fr); err != nil || f != flowNormal {
+			return f, err
+		}
+	}
+	return flowNormal, nil
+}
+
+func (s *State) exec(st stmt, sc *scope, fr *frame) (flow, error) {
+	switch st := st.(type) {
+	case *localStmt:
+		values, err := s.evalList(st.exprs, sc, fr)
+		if err != nil {
+			return flowNormal, err
+		}
+		for i, name := range st.names {
+			var v Value
+			if i < len(values) {
+				v = values[i]
+			}
+			sc.declare(name, v)
+		}
+	case *localFunctionStmt:
+		sc.declare(st.name, nil)
+		*sc.lookup(st.name) = &Function{name: st.name, proto: st.fn, env: sc, chunk: fr.fn.chunk}
+	case *assignStmt:
+		return flowNormal, s.assign(st, sc, fr)
+	case *callStmt:
+		_, err := s.evalMulti(st.call, sc, fr)
+		return flowNormal, err
+	case *doStmt:
+		return s.execBlock(st.body, &scope{parent: sc}, fr)
+	case *whileStmt:
+		for {
+			if err := s.tick(); err != nil {
+				return flowNormal, err
+			}
+			cond, err := s.eval(st.cond, sc, fr)
+			if err != nil || !Truthy(cond) {
+				return flowNormal, err
+			}
+			f, err := s.execBlock(st.body, &scope{parent: sc}, fr)
+			if err != nil || f == flowReturn {
+				return f, err
+			}
+			if f == flowBreak {
+				return flowNormal, nil
+			}
+		}
+	case *repeatStmt:
+		for {
+			if err := s.tick(); err != nil {
+				return flowNormal, err
+			}
+			// the condition sees the locals of the body
+			body := &scope{parent: sc}
+			f, err := s.execBlock(st.body, body, fr)
+			if err != nil || f == flowReturn {
+				return f, err
+			}
+			if f == flowBreak {
+				return flowNormal, nil
+			}
+			cond, err := s.eval(st.cond, body, fr)
+			if err != nil || Truthy(cond) {
+				return flowNormal, err
+			}
+		}
+	case *ifStmt:
+		for i, c := range st.conds {
+			cond, err := s.eval(c, sc, fr)
+			if err != nil {
+				return flowNormal, err
+			}
+			if Truthy(cond) {
+				return s.execBlock(st.blocks[i], &scope{parent: sc}, fr)
+			}
+		}
+		if st.elseBody != nil {
+			return s.execBlock(st.elseBody, &scope{parent: sc}, fr)
+		}
+	case *numForStmt:
+		return s.numericFor(st, sc, fr)
+	case *genForStmt:
+		return s.genericFor(st, sc, fr)
+	case *returnStmt:
+		values, err := s.evalList(st.exprs, sc, fr)
+		if err != nil {
+			return flowNormal, err
+		}
+		fr.ret = values
+		return flowReturn, nil
+	case *breakStmt:
+		return flowBreak, nil
+	}
+	return flowNormal, nil
+}
+
+func (s *State) numericFor(st *numForStmt, sc *scope, fr *frame) (flow, error) {
+	bounds := []expr{st.start, st.limit, st.step}
+	names := []string{"initial", "limit", "step"}
+	values := []float64{0, 0, 1}
+	for i, e := range bounds {
+		if e == nil {
+			continue
+		}
+		v, err := s.eval(e, sc, fr)
+		if err != nil {
+			return flowNormal, err
+		}
+		n, ok := ToNumber(v)
+		if !ok {
+			return flowNormal, fr.errorf(st.line, "'for' %s value must be a number", names[i])
+		}
+		values[i] = n
+	}
+	start, limit, step := values[0], values[1], values[2]
+	for i := start; step > 0 && i <= limit || step <= 0 && i >= limit; i += step {
+		if err := s.tick(); err != nil {
+			return flowNormal, err
+		}
+		body := &scope{parent: sc}
+		body.declare(st.name, i)
+		f, err := s.execBlock(st.body, body, fr)
+		if err != nil || f == flowReturn {
+			return f, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil
+}
+
+func (s *State) genericFor(st *genForStmt, sc *scope, fr *frame) (flow, error) {
+	values, err := s.evalList(st.exprs, sc, fr)
+	if err != nil {
+		return flowNormal, err
+	}
+	values = append(values, nil, nil, nil)
+	iter, state, control := values[0], values[1], values[2]
+	fn, ok := iter.(*Function)
+	if !ok {
+		return flowNormal, fr.errorf(st.line, "attempt to call a %s value", TypeName(iter))
+	}
+	for {
+		s.chunk, s.line = fr.fn.chunk, st.line
+		rets, err := s.call(fn, []Value{state, control})
+		if err != nil {
+			return flowNormal, err
+		}
+		if len(rets) == 0 || rets[0] == nil {
+			return flowNormal, nil
+		}
+		control = rets[0]
+		body := &scope{parent: sc}
+		for i, name := range st.names {
+			var v Value
+			if i < len(rets) {
+				v = rets[i]
+			}
+			body.declare(name, v)
+		}
+		f, err := s.execBlock(st.body, body, fr)
+		if err != nil || f == flowReturn {
+			return f, err
+		}
+		if f == flowBreak {
+			return flowNormal, nil
+		}
+	}
+}
+
+func (s *State) assign(st *assignStmt, sc *scope, fr *frame) error {
+	// the tables and keys are evaluated before the values are assigned
+	type target struct {
+		cell  *Value
+		name  string
+		table *Table
+		key   Value
+	}
+	targets := make([]target, len(st.targets))
+	for i, e := range st.targets {
+		switch e := e.(type) {
+		case *nameExpr:
+			targets[i] = target{cell: sc.lookup(e.name), name: e.name}
+		case *indexExpr:
+			obj, err := s.eval(e.obj, sc, fr)
+			if err != nil {
+				return err
+			}
+			t, ok := obj.(*Table)
+			if !ok {
+				return fr.errorf(e.line, "attempt to index %s", describe(e.obj, sc, obj))
+			}
+			key, err := s.eval(e.key, sc, fr)
+			if err != nil {
+				return err
+			}
+			targets[i] = target{table: t, key: key}
+		}
+	}
+	values, err := s.evalList(st.exprs, sc, fr)
+	if err != nil {
+		return err
+	}
+	for i, t := range targets {
+		var v Value
+		if i < len(values) {
+			v = values[i]
+		}
+		switch {
+		case t.cell != nil:
+			*t.cell = v
+		case t.table != nil:
+			if err := s.setIndex(t.table, t.key, v, fr, st.line); err != nil {
+				return err
+			}
+		default:
+			if err := s.setIndex(s.Globals, t.name, v, fr, st.line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *State) setIndex(t *Table, key, v Value, fr *frame, line int) error {
+	if t.readOnly {
+		return fr.errorf(line, "Attempt to modify a readonly table")
+	}
+	if key == nil {
+		return fr.errorf(line, "table index is nil")
+	}
+	if n, ok := key.(float64); ok && math.IsNaN(n) {
+		return fr.errorf(line, "table index is NaN")
+	}
+	t.Set(key, v)
+	return nil
+}
+
+// describe names what an expression is in the errors, like "local 'x'
+// (a nil value)", or gives the type of its value
+func describe(e expr, sc *scope, v Value) string {
+	switch e := e.(type) {
+	case *nameExpr:
+		if sc.lookup(e.name) != nil {
+			return fmt.Sprintf("local '%s' (a %s value)", e.name, TypeName(v))
+		}
+		return fmt.Sprintf("global '%s' (a %s value)", e.name, TypeName(v))
+	case *indexExpr:
+		if c, ok := e.key.(*constExpr); ok {
+			if key, ok := c.value.(string); ok {
+				return fmt.Sprintf("field '%s' (a %s value)", key, TypeName(v))
+			}
+		}
+	case *methodCallExpr:
+		return fmt.Sprintf("method '%s' (a %s value)", e.name, TypeName(v))
+	}
+	return fmt.Sprintf("a %s value", TypeName(v))
+}
+
+// evalList evaluates a list of expressions, the last one expanded to all
+// its values
+func (s *State) evalList(exprs []expr, sc *scope, fr *frame) ([]Value, error) {
+	var values []Value
+	for i, e := range exprs {
+		if i == len(exprs)-1 {
+			rest, err := s.evalMulti(e, sc, fr)
+			if err != nil {
+				return nil, err
+			}
+			return append(values, rest...), nil
+		}
+		v, err := s.eval(e, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// evalMulti evaluates an expression to all its values, several for the
+// calls and '...'
+func (s *State) evalMulti(e expr, sc *scope, fr *frame) ([]Value, error) {
+	switch e := e.(type) {
+	case *varargExpr:
+		return append([]Value(nil), fr.varargs...), nil
+	case *callExpr:
+		fnValue, err := s.eval(e.fn, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := fnValue.(*Function)
+		if !ok {
+			return nil, fr.errorf(e.line, "attempt to call %s", describe(e.fn, sc, fnValue))
+		}
+		args, err := s.evalList(e.args, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		s.chunk, s.line = fr.fn.chunk, e.line
+		return s.call(fn, args)
+	case *methodCallExpr:
+		obj, err := s.eval(e.obj, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		method, err := s.index(obj, e.name, e.obj, sc, fr, e.line)
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := method.(*Function)
+		if !ok {
+			return nil, fr.errorf(e.line, "attempt to call %s", describe(e, sc, method))
+		}
+		args, err := s.evalList(e.args, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		s.chunk, s.line = fr.fn.chunk, e.line
+		return s.call(fn, append([]Value{obj}, args...))
+	}
+	v, err := s.eval(e, sc, fr)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{v}, nil
+}
+
+// eval evaluates an expression to a single value
+func (s *State) eval(e expr, sc *scope, fr *frame) (Value, error) {
+	switch e := e.(type) {
+	case *constExpr:
+		return e.value, nil
+	case *nameExpr:
+		if cell := sc.lookup(e.name); cell != nil {
+			return *cell, nil
+		}
+		v := s.Globals.Get(e.name)
+		if v == nil && s.Strict {
+			return nil, fr.errorf(e.line, "Script attempted to access nonexistent global variable '%s'", e.name)
+		}
+		return v, nil
+	case *indexExpr:
+		obj, err := s.eval(e.obj, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s.eval(e.key, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		return s.index(obj, key, e.obj, sc, fr, e.line)
+	case *functionExpr:
+		return &Function{name: e.name, proto: e, env: sc, chunk: fr.fn.chunk}, nil
+	case *parenExpr:
+		return s.eval(e.e, sc, fr)
+	case *tableExpr:
+		return s.table(e, sc, fr)
+	case *unopExpr:
+		return s.unop(e, sc, fr)
+	case *binopExpr:
+		return s.binop(e, sc, fr)
+	}
+	values, err := s.evalMulti(e, sc, fr)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// index returns obj[key], the strings are indexed with the string library
+func (s *State) index(obj, key Value, objExpr expr, sc *scope, fr *frame, line int) (Value, error) {
+	switch obj := obj.(type) {
+	case *Table:
+		return obj.Get(key), nil
+	case string:
+		if s.stringLib != nil {
+			return s.stringLib.Get(key), nil
+		}
+	}
+	return nil, fr.errorf(line, "attempt to index %s", describe(objExpr, sc, obj))
+}
+
+func (s *State) table(e *tableExpr, sc *scope, fr *frame) (Value, error) {
+	t := NewTable()
+	n := 0
+	for i, item := range e.items {
+		if item.key == nil {
+			// the last positional field is expanded
+			if i == len(e.items)-1 {
+				values, err := s.evalMulti(item.value, sc, fr)
+				if err != nil {
+					return nil, err
+				}
+				for _, v := range values {
+					n++
+					t.Set(float64(n), v)
+				}
+				break
+			}
+			v, err := s.eval(item.value, sc, fr)
+			if err != nil {
+				return nil, err
+			}
+			n++
+			t.Set(float64(n), v)
+			continue
+		}
+		key, err := s.eval(item.key, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		v, err := s.eval(item.value, sc, fr)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.setIndex(t, key, v, fr, e.line); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (s *State) unop(e *unopExpr, sc *scope, fr *frame) (Value, error) {
+	v, err := s.eval(e.e, sc, fr)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "not":
+		return !Truthy(v), nil
+	case "-":
+		n, ok := ToNumber(v)
+		if !ok {
+			return nil, fr.errorf(e.line, "attempt to perform arithmetic on %s", describe(e.e, sc, v))
+		}
+		return -n, nil
+	}
+	switch v := v.(type) {
+	case string:
+		return float64(len(v)), nil
+	case *Table:
+		return float64(v.Len()), nil
+	}
+	return nil, fr.errorf(e.line, "attempt to get length of %s", describe(e.e, sc, v))
+}
+
+func (s *State) binop(e *binopExpr, sc *scope, fr *frame) (Value, error) {
+	l, err := s.eval(e.l, sc, fr)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "and":
+		if !Truthy(l) {
+			return l, nil
+		}
+		return s.eval(e.r, sc, fr)
+	case "or":
+		if Truthy(l) {
+			return l, nil
+		}
+		return s.eval(e.r, sc, fr)
+	}
+	r, err := s.eval(e.r, sc, fr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return l == r, nil
+	case "~=":
+		return l != r, nil
+	case "<", "<=", ">", ">=":
+		return compare(e.op, l, r, fr, e.line)
+	case "..":
+		ls, lok := ToString(l)
+		rs, rok := ToString(r)
+		if !lok {
+			return nil, fr.errorf(e.line, "attempt to concatenate %s", describe(e.l, sc, l))
+		}
+		if !rok {
+			return nil, fr.errorf(e.line, "attempt to concatenate %s", describe(e.r, sc, r))
+		}
+		return ls + rs, nil
+	}
+
+	a, ok := ToNumber(l)
+	if !ok {
+		return nil, fr.errorf(e.line, "attempt to perform arithmetic on %s", describe(e.l, sc, l))
+	}
+	b, ok := ToNumber(r)
+	if !ok {
+		return nil, fr.errorf(e.line, "attempt to perform arithmetic on %s", describe(e.r, sc, r))
+	}
+	switch e.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case "%":
+		return a - math.Floor(a/b)*b, nil
+	}
+	return math.Pow(a, b), nil
+}
+
+// compare compares two numbers or two strings
+func compare(op string, l, r Value, fr *frame, line int) (Value, error) {
+	var less, equal bool
+	switch a := l.(type) {
+	case float64:
+		b, ok := r.(float64)
+		if !ok {
+			return nil, compareError(l, r, fr, line)
+		}
+		less, equal = a < b, a == b
+	case string:
+		b, ok := r.(string)
+		if !ok {
+			return nil, compareError(l, r, fr, line)
+		}
+		less, equal = a < b, a == b
+	default:
+		return nil, compareError(l, r, fr, line)
+	}
+	switch op {
+	case "<":
+		return less, nil
+	case "<=":
+		return less || equal, nil
+	case ">":
+		return !less && !equal, nil
+	}
+	return !less, nil
+}
+
+func compareError(l, r Value, fr *frame, line int) error {
+	if TypeName(l) == TypeName(r) {
+		return fr.errorf(line, "attempt to compare two %s values", TypeName(l))
+	}
+	return fr.errorf(line, "attempt to compare %s with %s", TypeName(l), TypeName(r))
+}
+
+// callerName names a function of the host in the errors of its arguments
+func callerName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
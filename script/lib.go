@@ -0,0 +1,776 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openBase sets the base functions and the string, table and math
+// libraries in the globals
+func openBase(s *State) {
+	g := s.Globals
+	g.Set("_G", g)
+	register(g, "", map[string]func(*State, []Value) ([]Value, error){
+		"assert":   baseAssert,
+		"error":    baseError,
+		"pcall":    basePcall,
+		"type":     baseType,
+		"tostring": baseTostring,
+		"tonumber": baseTonumber,
+		"ipairs":   baseIpairs,
+		"pairs":    basePairs,
+		"next":     baseNext,
+		"select":   baseSelect,
+		"unpack":   tableUnpack,
+		"rawget":   baseRawget,
+		"rawset":   baseRawset,
+		"rawequal": baseRawequal,
+	})
+
+	s.stringLib = NewTable()
+	register(s.stringLib, "string.", map[string]func(*State, []Value) ([]Value, error){
+		"len":     stringLen,
+		"sub":     stringSub,
+		"upper":   stringUpper,
+		"lower":   stringLower,
+		"rep":     stringRep,
+		"reverse": stringReverse,
+		"byte":    stringByte,
+		"char":    stringChar,
+		"format":  stringFormat,
+		"find":    stringFind,
+		"match":   stringMatch,
+		"gmatch":  stringGmatch,
+		"gsub":    stringGsub,
+	})
+	s.stringLib.Freeze()
+	g.Set("string", s.stringLib)
+
+	table := NewTable()
+	register(table, "table.", map[string]func(*State, []Value) ([]Value, error){
+		"insert": tableInsert,
+		"remove": tableRemove,
+		"concat": tableConcat,
+		"sort":   tableSort,
+		"unpack": tableUnpack,
+		"getn":   tableGetn,
+	})
+	table.Freeze()
+	g.Set("table", table)
+
+	mathLib := NewTable()
+	register(mathLib, "math.", map[string]func(*State, []Value) ([]Value, error){
+		"floor": mathFunc(math.Floor),
+		"ceil":  mathFunc(math.Ceil),
+		"abs":   mathFunc(math.Abs),
+		"sqrt":  mathFunc(math.Sqrt),
+		"exp":   mathFunc(math.Exp),
+		"log":   mathFunc(math.Log),
+		"max":   mathMax,
+		"min":   mathMin,
+		"pow":   mathPow,
+		"fmod":  mathFmod,
+		"modf":  mathModf,
+	})
+	mathLib.Set("huge", math.Inf(1))
+	mathLib.Set("pi", math.Pi)
+	mathLib.Freeze()
+	g.Set("math", mathLib)
+}
+
+// register sets functions of the host in a table, prefix completes their
+// name
+func register(t *Table, prefix string, fns map[string]func(*State, []Value) ([]Value, error)) {
+	names := make([]string, 0, len(fns))
+	for name := range fns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.Set(name, NewFunction(prefix+name, fns[name]))
+	}
+}
+
+// ArgError returns the error of a bad argument of a function of the host
+func ArgError(s *State, n int, fname, format string, args ...any) error {
+	return s.Errorf("bad argument #%d to '%s' (%s)", n, callerName(fname), fmt.Sprintf(format, args...))
+}
+
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// CheckString returns the argument i of a function of the host as a
+// string, numbers are converted
+func CheckString(s *State, args []Value, i int, fname string) (string, error) {
+	v := arg(args, i)
+	str, ok := ToString(v)
+	if !ok {
+		return "", ArgError(s, i+1, fname, "string expected, got %s", typeNameOrNone(args, i))
+	}
+	return str, nil
+}
+
+func checkNumber(s *State, args []Value, i int, fname string) (float64, error) {
+	n, ok := ToNumber(arg(args, i))
+	if !ok {
+		return 0, ArgError(s, i+1, fname, "number expected, got %s", typeNameOrNone(args, i))
+	}
+	return n, nil
+}
+
+func checkInt(s *State, args []Value, i int, fname string) (int, error) {
+	n, err := checkNumber(s, args, i, fname)
+	return int(n), err
+}
+
+func optInt(s *State, args []Value, i int, fname string, def int) (int, error) {
+	if arg(args, i) == nil {
+		return def, nil
+	}
+	return checkInt(s, args, i, fname)
+}
+
+func checkTable(s *State, args []Value, i int, fname string) (*Table, error) {
+	t, ok := arg(args, i).(*Table)
+	if !ok {
+		return nil, ArgError(s, i+1, fname, "table expected, got %s", typeNameOrNone(args, i))
+	}
+	return t, nil
+}
+
+func typeNameOrNone(args []Value, i int) string {
+	if i >= len(args) {
+		return "no value"
+	}
+	return TypeName(args[i])
+}
+
+func baseAssert(s *State, args []Value) ([]Value, error) {
+	if Truthy(arg(args, 0)) {
+		return args, nil
+	}
+	if msg := arg(args, 1); msg != nil {
+		return nil, &Error{Value: msg, Line: s.line}
+	}
+	return nil, s.Errorf("assertion failed!")
+}
+
+// baseError raises its argument, a string gets the position of the
+// caller at level 1 (the default)
+func baseError(s *State, args []Value) ([]Value, error) {
+	v := arg(args, 0)
+	level, err := optInt(s, args, 1, "error", 1)
+	if err != nil {
+		return nil, err
+	}
+	if msg, ok := v.(string); ok && level > 0 {
+		v = s.Where() + msg
+	}
+	return nil, &Error{Value: v, Line: s.line}
+}
+
+func basePcall(s *State, args []Value) ([]Value, error) {
+	fn, ok := arg(args, 0).(*Function)
+	if !ok {
+		return []Value{false, fmt.Sprintf("attempt to call a %s value", typeNameOrNone(args, 0))}, nil
+	}
+	rets, err := s.call(fn, args[1:])
+	if err != nil {
+		e := err.(*Error)
+		if e.Fatal {
+			return nil, e
+		}
+		return []Value{false, e.Value}, nil
+	}
+	return append([]Value{true}, rets...), nil
+}
+
+func baseType(s *State, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, ArgError(s, 1, "type", "value expected")
+	}
+	return []Value{TypeName(args[0])}, nil
+}
+
+func baseTostring(s *State, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, ArgError(s, 1, "tostring", "value expected")
+	}
+	return []Value{tostring(args[0])}, nil
+}
+
+// tostring converts any value to a string, as tostring
+func tostring(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	case *Table:
+		return fmt.Sprintf("table: %p", v)
+	case *Function:
+		return fmt.Sprintf("function: %p", v)
+	}
+	return fmt.Sprint(v)
+}
+
+func baseTonumber(s *State, args []Value) ([]Value, error) {
+	base, err := optInt(s, args, 1, "tonumber", 10)
+	if err != nil {
+		return nil, err
+	}
+	if base == 10 {
+		if n, ok := ToNumber(arg(args, 0)); ok {
+			return []Value{n}, nil
+		}
+		return []Value{nil}, nil
+	}
+	if base < 2 || base > 36 {
+		return nil, ArgError(s, 2, "tonumber", "base out of range")
+	}
+	str, err := CheckString(s, args, 0, "tonumber")
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(str), base, 64)
+	if err != nil {
+		return []Value{nil}, nil
+	}
+	return []Value{float64(n)}, nil
+}
+
+func baseIpairs(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "ipairs")
+	if err != nil {
+		return nil, err
+	}
+	iter := NewFunction("ipairs_iterator", func(s *State, args []Value) ([]Value, error) {
+		i, _ := ToNumber(arg(args, 1))
+		v := t.Get(i + 1)
+		if v == nil {
+			return []Value{nil}, nil
+		}
+		return []Value{i + 1, v}, nil
+	})
+	return []Value{iter, t, 0.0}, nil
+}
+
+func basePairs(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "pairs")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{s.Globals.Get("next"), t, nil}, nil
+}
+
+func baseNext(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "next")
+	if err != nil {
+		return nil, err
+	}
+	k, v, ok := t.Next(arg(args, 1))
+	if !ok {
+		return nil, s.Errorf("invalid key to 'next'")
+	}
+	if k == nil {
+		return []Value{nil}, nil
+	}
+	return []Value{k, v}, nil
+}
+
+func baseSelect(s *State, args []Value) ([]Value, error) {
+	if arg(args, 0) == "#" {
+		return []Value{float64(len(args) - 1)}, nil
+	}
+	n, err := checkInt(s, args, 0, "select")
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		n += len(args)
+	}
+	if n < 1 {
+		return nil, ArgError(s, 1, "select", "index out of range")
+	}
+	if n >= len(args) {
+		return nil, nil
+	}
+	return args[n:], nil
+}
+
+func baseRawget(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "rawget")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{t.Get(arg(args, 1))}, nil
+}
+
+func baseRawset(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "rawset")
+	if err != nil {
+		return nil, err
+	}
+	if t.readOnly {
+		return nil, s.Errorf("Attempt to modify a readonly table")
+	}
+	if arg(args, 1) == nil {
+		return nil, s.Errorf("table index is nil")
+	}
+	t.Set(args[1], arg(args, 2))
+	return []Value{t}, nil
+}
+
+func baseRawequal(s *State, args []Value) ([]Value, error) {
+	return []Value{arg(args, 0) == arg(args, 1)}, nil
+}
+
+// stringRange converts the 1-based, possibly negative, positions i and j
+// of a string of length n to a slice range
+func stringRange(i, j, n int) (int, int) {
+	if i < 0 {
+		i = max(n+i+1, 1)
+	} else if i == 0 {
+		i = 1
+	}
+	if j < 0 {
+		j = n + j + 1
+	} else if j > n {
+		j = n
+	}
+	if i > j {
+		return 0, 0
+	}
+	return i - 1, j
+}
+
+func stringLen(s *State, args []Value) ([]Value, error) {
+	str, err := CheckString(s, args, 0, "len")
+	return []Value{float64(len(str))}, err
+}
+
+func stringSub(s *State, args []Value) ([]Value, error) {
+	str, err := CheckString(s, args, 0, "sub")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(s, args, 1, "sub", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(s, args, 2, "sub", -1)
+	if err != nil {
+		return nil, err
+	}
+	start, end := stringRange(i, j, len(str))
+	return []Value{str[start:end]}, nil
+}
+
+func stringUpper(s *State, args []Value) ([]Value, error) {
+	str, err := CheckString(s, args, 0, "upper")
+	return []Value{strings.ToUpper(str)}, err
+}
+
+func stringLower(s *State, args []Value) ([]Value, error) {
+	str, err := CheckString(s, args, 0, "lower")
+	return []Value{strings.ToLower(str)}, err
+}
+
+func stringRep(s *State, args []Value) ([]Value, error) {
+	str, err := CheckString(s, args, 0, "rep")
+	if err != nil {
+		return nil, err
+	}
+	n, err := checkInt(s, args, 1, "rep")
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return []Value{""}, nil
+	}
+	if len(str)*n > 512*1024*1024 {
+		return nil, s.Errorf("resulting string too large")
+	}
+	return []Value{strings.Repeat(str, n)}, nil
+}
+
+func stringReverse(s *State, args []Value) ([]Value, error) {
+	str, err := CheckString(s, args, 0, "reverse")
+	if err != nil {
+		return nil, err
+	}
+	b := []byte(str)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return []Value{string(b)}, nil
+}
+
+func stringByte(s *State, args []Value) ([]Value, error) {
+	str, err := CheckString(s, args, 0, "byte")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(s, args, 1, "byte", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(s, args, 2, "byte", i)
+	if err != nil {
+		return nil, err
+	}
+	start, end := stringRange(i, j, len(str))
+	var bytes []Value
+	for _, c := range []byte(str[start:end]) {
+		bytes = append(bytes, float64(c))
+	}
+	return bytes, nil
+}
+
+func stringChar(s *State, args []Value) ([]Value, error) {
+	b := make([]byte, len(args))
+	for i := range args {
+		c, err := checkInt(s, args, i, "char")
+		if err != nil {
+			return nil, err
+		}
+		if c < 0 || c > 255 {
+			return nil, ArgError(s, i+1, "char", "invalid value")
+		}
+		b[i] = byte(c)
+	}
+	return []Value{string(b)}, nil
+}
+
+// stringFormat formats like C's printf, with the conversions of Lua
+func stringFormat(s *State, args []Value) ([]Value, error) {
+	format, err := CheckString(s, args, 0, "format")
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	n := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		// flags, width and precision
+		start := i
+		for i < len(format) && strings.IndexByte("-+ #0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i >= len(format) {
+			return nil, s.Errorf("invalid option '%%' to 'format'")
+		}
+		spec := "%" + format[start:i]
+		if n >= len(args) {
+			return nil, ArgError(s, n+1, "format", "no value")
+		}
+		switch verb := format[i]; verb {
+		case 'd', 'i':
+			v, err := checkNumber(s, args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"d", int64(v))
+		case 'c':
+			v, err := checkInt(s, args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(byte(v))
+		case 'x', 'X', 'o':
+			v, err := checkNumber(s, args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+string(verb), int64(v))
+		case 'e', 'E', 'f', 'g', 'G':
+			v, err := checkNumber(s, args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+string(verb), v)
+		case 's':
+			fmt.Fprintf(&b, spec+"s", tostring(args[n]))
+		case 'q':
+			str, err := CheckString(s, args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(quoteString(str))
+		default:
+			return nil, s.Errorf("invalid option '%%%c' to 'format'", verb)
+		}
+		n++
+	}
+	return []Value{b.String()}, nil
+}
+
+// quoteString quotes a string so it can be read back by the interpreter
+func quoteString(str string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case 0:
+			b.WriteString("\\000")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func tableInsert(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "insert")
+	if err != nil {
+		return nil, err
+	}
+	if t.readOnly {
+		return nil, s.Errorf("Attempt to modify a readonly table")
+	}
+	switch len(args) {
+	case 2:
+		t.Append(args[1])
+	case 3:
+		pos, err := checkInt(s, args, 1, "insert")
+		if err != nil {
+			return nil, err
+		}
+		n := t.Len()
+		if pos < 1 || pos > n+1 {
+			return nil, ArgError(s, 2, "insert", "position out of bounds")
+		}
+		for i := n; i >= pos; i-- {
+			t.Set(float64(i+1), t.Get(float64(i)))
+		}
+		t.Set(float64(pos), args[2])
+	default:
+		return nil, s.Errorf("wrong number of arguments to 'insert'")
+	}
+	return nil, nil
+}
+
+func tableRemove(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "remove")
+	if err != nil {
+		return nil, err
+	}
+	if t.readOnly {
+		return nil, s.Errorf("Attempt to modify a readonly table")
+	}
+	n := t.Len()
+	pos, err := optInt(s, args, 1, "remove", n)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return []Value{nil}, nil
+	}
+	if pos < 1 || pos > n {
+		return nil, ArgError(s, 2, "remove", "position out of bounds")
+	}
+	v := t.Get(float64(pos))
+	for i := pos; i < n; i++ {
+		t.Set(float64(i), t.Get(float64(i+1)))
+	}
+	t.Set(float64(n), nil)
+	return []Value{v}, nil
+}
+
+func tableConcat(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "concat")
+	if err != nil {
+		return nil, err
+	}
+	sep := ""
+	if arg(args, 1) != nil {
+		if sep, err = CheckString(s, args, 1, "concat"); err != nil {
+			return nil, err
+		}
+	}
+	i, err := optInt(s, args, 2, "concat", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(s, args, 3, "concat", t.Len())
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for ; i <= j; i++ {
+		str, ok := ToString(t.Get(float64(i)))
+		if !ok {
+			return nil, s.Errorf("invalid value (at index %d) in table for 'concat'", i)
+		}
+		parts = append(parts, str)
+	}
+	return []Value{strings.Join(parts, sep)}, nil
+}
+
+func tableSort(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "sort")
+	if err != nil {
+		return nil, err
+	}
+	if t.readOnly {
+		return nil, s.Errorf("Attempt to modify a readonly table")
+	}
+	less, hasLess := arg(args, 1).(*Function)
+	if arg(args, 1) != nil && !hasLess {
+		return nil, ArgError(s, 2, "sort", "function expected, got %s", TypeName(args[1]))
+	}
+	values := make([]Value, t.Len())
+	for i := range values {
+		values[i] = t.Get(float64(i + 1))
+	}
+	var sortErr error
+	sort.SliceStable(values, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		if hasLess {
+			rets, err := s.call(less, []Value{values[i], values[j]})
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			return len(rets) > 0 && Truthy(rets[0])
+		}
+		result, err := compare("<", values[i], values[j], &frame{fn: &Function{chunk: s.chunk}}, s.line)
+		if err != nil {
+			sortErr = err
+			return false
+		}
+		return result.(bool)
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+	for i, v := range values {
+		t.Set(float64(i+1), v)
+	}
+	return nil, nil
+}
+
+func tableUnpack(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "unpack")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(s, args, 1, "unpack", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(s, args, 2, "unpack", t.Len())
+	if err != nil {
+		return nil, err
+	}
+	if j-i >= 1<<20 {
+		return nil, s.Errorf("too many results to unpack")
+	}
+	var values []Value
+	for ; i <= j; i++ {
+		values = append(values, t.Get(float64(i)))
+	}
+	return values, nil
+}
+
+func tableGetn(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(s, args, 0, "getn")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{float64(t.Len())}, nil
+}
+
+// mathFunc makes a function of the math library from one of Go's
+func mathFunc(fn func(float64) float64) func(*State, []Value) ([]Value, error) {
+	return func(s *State, args []Value) ([]Value, error) {
+		n, err := checkNumber(s, args, 0, "math")
+		return []Value{fn(n)}, err
+	}
+}
+
+func mathMax(s *State, args []Value) ([]Value, error) {
+	result, err := checkNumber(s, args, 0, "max")
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(args); i++ {
+		n, err := checkNumber(s, args, i, "max")
+		if err != nil {
+			return nil, err
+		}
+		result = math.Max(result, n)
+	}
+	return []Value{result}, nil
+}
+
+func mathMin(s *State, args []Value) ([]Value, error) {
+	result, err := checkNumber(s, args, 0, "min")
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(args); i++ {
+		n, err := checkNumber(s, args, i, "min")
+		if err != nil {
+			return nil, err
+		}
+		result = math.Min(result, n)
+	}
+	return []Value{result}, nil
+}
+
+func mathPow(s *State, args []Value) ([]Value, error) {
+	x, err := checkNumber(s, args, 0, "pow")
+	if err != nil {
+		return nil, err
+	}
+	y, err := checkNumber(s, args, 1, "pow")
+	return []Value{math.Pow(x, y)}, err
+}
+
+func mathFmod(s *State, args []Value) ([]Value, error) {
+	x, err := checkNumber(s, args, 0, "fmod")
+	if err != nil {
+		return nil, err
+	}
+	y, err := checkNumber(s, args, 1, "fmod")
+	return []Value{math.Mod(x, y)}, err
+}
+
+func mathModf(s *State, args []Value) ([]Value, error) {
+	x, err := checkNumber(s, args, 0, "modf")
+	if err != nil {
+		return nil, err
+	}
+	i, frac := math.Modf(x)
+	return []Value{i, frac}, nil
+}
@@ -0,0 +1,775 @@
+package script
+
+import "fmt"
+
+// The syntax tree of a chunk. The expressions that can produce several
+// values (calls and '...') are expanded when last in a list.
+
+type expr interface{}
+
+type (
+	constExpr  struct{ value Value }
+	varargExpr struct{}
+	nameExpr   struct {
+		name string
+		line int
+	}
+	indexExpr struct {
+		obj, key expr
+		line     int
+	}
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+	methodCallExpr struct {
+		obj  expr
+		name string
+		args []expr
+		line int
+	}
+	functionExpr struct {
+		params []string
+		vararg bool
+		body   *block
+		name   string
+	}
+	binopExpr struct {
+		op   string
+		l, r expr
+		line int
+	}
+	unopExpr struct {
+		op   string
+		e    expr
+		line int
+	}
+	tableExpr struct {
+		items []tableItem
+		line  int
+	}
+	parenExpr struct{ e expr }
+)
+
+// tableItem is a field of a table constructor, key is nil for the
+// positional ones
+type tableItem struct {
+	key, value expr
+}
+
+type stmt interface{}
+
+type (
+	localStmt struct {
+		names []string
+		exprs []expr
+	}
+	localFunctionStmt struct {
+		name string
+		fn   *functionExpr
+	}
+	assignStmt struct {
+		targets []expr
+		exprs   []expr
+		line    int
+	}
+	callStmt  struct{ call expr }
+	doStmt    struct{ body *block }
+	whileStmt struct {
+		cond expr
+		body *block
+	}
+	repeatStmt struct {
+		body *block
+		cond expr
+	}
+	ifStmt struct {
+		conds    []expr
+		blocks   []*block
+		elseBody *block // nil without an else
+	}
+	numForStmt struct {
+		name               string
+		start, limit, step expr // step is nil when not given
+		body               *block
+		line               int
+	}
+	genForStmt struct {
+		names []string
+		exprs []expr
+		body  *block
+		line  int
+	}
+	returnStmt struct{ exprs []expr }
+	breakStmt  struct{}
+)
+
+type block struct {
+	stmts []stmt
+}
+
+// parser builds the syntax tree of a chunk from its tokens, with one token
+// of lookahead
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// parse parses the source of a chunk as the body of a vararg function
+func parse(chunk, src string) (*functionExpr, error) {
+	p := &parser{lex: &lexer{src: src, line: 1, chunk: chunk}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.typ != tokEOF {
+		return nil, p.errorf("'<eof>' expected near '%s'", p.tok.text)
+	}
+	return &functionExpr{vararg: true, body: body, name: "main chunk"}, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Value: fmt.Sprintf("%s:%d: %s", p.lex.chunk, p.tok.line, fmt.Sprintf(format, args...)), Line: p.tok.line}
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// is reports if the current token is the symbol or keyword s
+func (p *parser) is(s string) bool {
+	return p.tok.typ == tokSymbol && p.tok.text == s
+}
+
+// accept skips the symbol s if it's the current token
+func (p *parser) accept(s string) (bool, error) {
+	if !p.is(s) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(s string) error {
+	if !p.is(s) {
+		return p.errorf("'%s' expected near '%s'", s, p.near())
+	}
+	return p.advance()
+}
+
+// near describes the current token for the errors
+func (p *parser) near() string {
+	if p.tok.typ == tokEOF {
+		return "<eof>"
+	}
+	if p.tok.typ == tokNumber {
+		return formatNumber(p.tok.num)
+	}
+	return p.tok.text
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.typ != tokName {
+		return "", p.errorf("<name> expected near '%s'", p.near())
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+// blockEnd reports if the current token closes a block
+func (p *parser) blockEnd() bool {
+	return p.tok.typ == tokEOF || p.is("end") || p.is("else") || p.is("elseif") || p.is("until")
+}
+
+func (p *parser) block() (*block, error) {
+	b := &block{}
+	for !p.blockEnd() {
+		if p.is("return") || p.is("break") {
+			s, err := p.lastStatement()
+			if err != nil {
+				return nil, err
+			}
+			b.stmts = append(b.stmts, s)
+			if !p.blockEnd() {
+				return nil, p.errorf("'end' expected near '%s'", p.near())
+			}
+			break
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			b.stmts = append(b.stmts, s)
+		}
+	}
+	return b, nil
+}
+
+func (p *parser) lastStatement() (stmt, error) {
+	if ok, err := p.accept("break"); ok || err != nil {
+		if _, err := p.accept(";"); err != nil {
+			return nil, err
+		}
+		return &breakStmt{}, err
+	}
+	if err := p.advance(); err != nil { // return
+		return nil, err
+	}
+	s := &returnStmt{}
+	if !p.blockEnd() && !p.is(";") {
+		exprs, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		s.exprs = exprs
+	}
+	_, err := p.accept(";")
+	return s, err
+}
+
+func (p *parser) statement() (stmt, error) {
+	line := p.tok.line
+	switch {
+	case p.is(";"):
+		return nil, p.advance()
+	case p.is("do"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		body, err := p.blockUntil("end")
+		return &doStmt{body: body}, err
+	case p.is("while"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		body, err := p.blockUntil("end")
+		return &whileStmt{cond: cond, body: body}, err
+	case p.is("repeat"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		body, err := p.blockUntil("until")
+		if err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		return &repeatStmt{body: body, cond: cond}, err
+	case p.is("if"):
+		return p.ifStatement()
+	case p.is("for"):
+		return p.forStatement()
+	case p.is("function"):
+		return p.functionStatement()
+	case p.is("local"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if ok, err := p.accept("function"); err != nil {
+			return nil, err
+		} else if ok {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn, err := p.functionBody(name)
+			return &localFunctionStmt{name: name, fn: fn}, err
+		}
+		s := &localStmt{}
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			s.names = append(s.names, name)
+			if ok, err := p.accept(","); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+		if ok, err := p.accept("="); err != nil {
+			return nil, err
+		} else if ok {
+			exprs, err := p.exprList()
+			if err != nil {
+				return nil, err
+			}
+			s.exprs = exprs
+		}
+		return s, nil
+	}
+
+	// an assignment or a call
+	e, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("=") && !p.is(",") {
+		switch e.(type) {
+		case *callExpr, *methodCallExpr:
+			return &callStmt{call: e}, nil
+		}
+		return nil, p.errorf("syntax error near '%s'", p.near())
+	}
+	s := &assignStmt{targets: []expr{e}, line: line}
+	for {
+		switch s.targets[len(s.targets)-1].(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			return nil, p.errorf("syntax error near '%s'", p.near())
+		}
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+		target, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		s.targets = append(s.targets, target)
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	s.exprs, err = p.exprList()
+	return s, err
+}
+
+// blockUntil parses a block closed by the keyword end
+func (p *parser) blockUntil(end string) (*block, error) {
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return body, p.expect(end)
+}
+
+func (p *parser) ifStatement() (stmt, error) {
+	s := &ifStmt{}
+	for {
+		if err := p.advance(); err != nil { // if or elseif
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.conds = append(s.conds, cond)
+		s.blocks = append(s.blocks, body)
+		if !p.is("elseif") {
+			break
+		}
+	}
+	if ok, err := p.accept("else"); err != nil {
+		return nil, err
+	} else if ok {
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.elseBody = body
+	}
+	return s, p.expect("end")
+}
+
+func (p *parser) forStatement() (stmt, error) {
+	line := p.tok.line
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.accept("="); err != nil {
+		return nil, err
+	} else if ok {
+		s := &numForStmt{name: name, line: line}
+		if s.start, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if s.limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if ok {
+			if s.step, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		s.body, err = p.blockUntil("end")
+		return s, err
+	}
+
+	s := &genForStmt{names: []string{name}, line: line}
+	for {
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		s.names = append(s.names, name)
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	if s.exprs, err = p.exprList(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	s.body, err = p.blockUntil("end")
+	return s, err
+}
+
+// functionStatement parses function a.b.c:m() ... end, an assignment
+func (p *parser) functionStatement() (stmt, error) {
+	line := p.tok.line
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	var target expr = &nameExpr{name: name, line: line}
+	fullName := name
+	method := false
+	for p.is(".") || p.is(":") {
+		method = p.is(":")
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		key, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		target = &indexExpr{obj: target, key: &constExpr{value: key}, line: line}
+		fullName += "." + key
+		if method {
+			break
+		}
+	}
+	fn, err := p.functionBody(fullName)
+	if err != nil {
+		return nil, err
+	}
+	if method {
+		fn.params = append([]string{"self"}, fn.params...)
+	}
+	return &assignStmt{targets: []expr{target}, exprs: []expr{fn}, line: line}, nil
+}
+
+// functionBody parses the parameters and the body of a function
+func (p *parser) functionBody(name string) (*functionExpr, error) {
+	fn := &functionExpr{name: name}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.is(")") {
+		if ok, err := p.accept("..."); err != nil {
+			return nil, err
+		} else if ok {
+			fn.vararg = true
+			break
+		}
+		param, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		fn.params = append(fn.params, param)
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	body, err := p.blockUntil("end")
+	fn.body = body
+	return fn, err
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	var exprs []expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if !ok {
+			return exprs, nil
+		}
+	}
+}
+
+// binaryPriority holds the left and right priorities of the binary
+// operators, a right priority lower than the left one associates right
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {5, 4},
+	"+":  {6, 6}, "-": {6, 6},
+	"*": {7, 7}, "/": {7, 7}, "%": {7, 7},
+	"^": {10, 9},
+}
+
+const unaryPriority = 8
+
+func (p *parser) expr() (expr, error) {
+	return p.subExpr(0)
+}
+
+// subExpr parses an expression whose binary operators bind tighter than
+// limit
+func (p *parser) subExpr(limit int) (expr, error) {
+	var e expr
+	var err error
+	if p.is("not") || p.is("-") || p.is("#") {
+		op, line := p.tok.text, p.tok.line
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.subExpr(unaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		e = &unopExpr{op: op, e: operand, line: line}
+	} else if e, err = p.simpleExpr(); err != nil {
+		return nil, err
+	}
+	for p.tok.typ == tokSymbol {
+		priority, ok := binaryPriority[p.tok.text]
+		if !ok || priority[0] <= limit {
+			break
+		}
+		op, line := p.tok.text, p.tok.line
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		r, err := p.subExpr(priority[1])
+		if err != nil {
+			return nil, err
+		}
+		e = &binopExpr{op: op, l: e, r: r, line: line}
+	}
+	return e, nil
+}
+
+func (p *parser) simpleExpr() (expr, error) {
+	tok := p.tok
+	switch {
+	case tok.typ == tokNumber:
+		return &constExpr{value: tok.num}, p.advance()
+	case tok.typ == tokString:
+		return &constExpr{value: tok.text}, p.advance()
+	case p.is("nil"):
+		return &constExpr{}, p.advance()
+	case p.is("true"):
+		return &constExpr{value: true}, p.advance()
+	case p.is("false"):
+		return &constExpr{value: false}, p.advance()
+	case p.is("..."):
+		return &varargExpr{}, p.advance()
+	case p.is("{"):
+		return p.tableConstructor()
+	case p.is("function"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return p.functionBody("anonymous")
+	}
+	return p.suffixedExpr()
+}
+
+// suffixedExpr parses a name or a parenthesized expression followed by
+// indexes and calls
+func (p *parser) suffixedExpr() (expr, error) {
+	var e expr
+	line := p.tok.line
+	switch {
+	case p.tok.typ == tokName:
+		e = &nameExpr{name: p.tok.text, line: line}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	case p.is("("):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		e = &parenExpr{e: inner}
+	default:
+		return nil, p.errorf("unexpected symbol near '%s'", p.near())
+	}
+
+	for {
+		line := p.tok.line
+		switch {
+		case p.is("."):
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			key, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			e = &indexExpr{obj: e, key: &constExpr{value: key}, line: line}
+		case p.is("["):
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			key, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{obj: e, key: key, line: line}
+		case p.is(":"):
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &methodCallExpr{obj: e, name: name, args: args, line: line}
+		case p.is("(") || p.is("{") || p.tok.typ == tokString:
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &callExpr{fn: e, args: args, line: line}
+		default:
+			return e, nil
+		}
+	}
+}
+
+// callArgs parses (args), a table constructor or a string
+func (p *parser) callArgs() ([]expr, error) {
+	switch {
+	case p.tok.typ == tokString:
+		s := p.tok.text
+		return []expr{&constExpr{value: s}}, p.advance()
+	case p.is("{"):
+		t, err := p.tableConstructor()
+		return []expr{t}, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if ok, err := p.accept(")"); ok || err != nil {
+		return nil, err
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return args, p.expect(")")
+}
+
+func (p *parser) tableConstructor() (expr, error) {
+	t := &tableExpr{line: p.tok.line}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.is("}") {
+		var item tableItem
+		var err error
+		switch {
+		case p.is("["):
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if item.key, err = p.expr(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+		case p.tok.typ == tokName:
+			// name = value, or an expression starting with a name
+			save := *p.lex
+			tok := p.tok
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if p.is("=") {
+				item.key = &constExpr{value: tok.text}
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+			} else {
+				*p.lex, p.tok = save, tok
+			}
+		}
+		if item.value, err = p.expr(); err != nil {
+			return nil, err
+		}
+		t.items = append(t.items, item)
+		if !p.is(",") && !p.is(";") {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return t, p.expect("}")
+}
@@ -0,0 +1,567 @@
+package script
+
+import (
+	"strings"
+)
+
+// The patterns of string.find, match, gmatch and gsub: character classes
+// (%a, %d, ..., [set]), the quantifiers *, +, - and ?, anchors, captures
+// (including position captures ()) and %b.
+
+const (
+	MAX_CAPTURES    = 32
+	capUnfinished   = -1
+	capPosition     = -2
+	maxMatchDepth   = 200
+	patternSpecials = "^$*+?.([%-"
+)
+
+type matchState struct {
+	src, pat string
+	level    int
+	capture  [MAX_CAPTURES]struct{ start, len int }
+	depth    int
+	s        *State
+}
+
+func (ms *matchState) errorf(format string, args ...any) error {
+	return ms.s.Errorf(format, args...)
+}
+
+// classEnd returns the position after the character class at p
+func (ms *matchState) classEnd(p int) (int, error) {
+	c := ms.pat[p]
+	p++
+	if c == '%' {
+		if p >= len(ms.pat) {
+			return 0, ms.errorf("malformed pattern (ends with '%%')")
+		}
+		return p + 1, nil
+	}
+	if c == '[' {
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		for {
+			// a ']' first is part of the set
+			if p >= len(ms.pat) {
+				return 0, ms.errorf("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' {
+				if p >= len(ms.pat) {
+					return 0, ms.errorf("malformed pattern (missing ']')")
+				}
+				p++
+			}
+			if p < len(ms.pat) && ms.pat[p] == ']' {
+				return p + 1, nil
+			}
+		}
+	}
+	return p, nil
+}
+
+// matchClass reports if c is in the class %cl
+func matchClass(c byte, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = isLetter(c) && c != '_'
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = isDigit(c)
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 32 && c < 127 && !isLetter(c) && !isDigit(c) || c == '_'
+	case 's':
+		res = c == ' ' || c >= '\t' && c <= '\r'
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = isLetter(c) && c != '_' || isDigit(c)
+	case 'x':
+		res = isHexDigit(c)
+	default:
+		return cl == c
+	}
+	if cl >= 'A' && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// matchSet reports if c is in the set pat[p:ec], p being on its '[' and
+// ec on its ']'
+func (ms *matchState) matchSet(c byte, p, ec int) bool {
+	negate := false
+	p++
+	if ms.pat[p] == '^' {
+		negate = true
+		p++
+	}
+	for ; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%' && p+1 < ec:
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return !negate
+			}
+		case p+2 < ec && ms.pat[p+1] == '-':
+			if ms.pat[p] <= c && c <= ms.pat[p+2] {
+				return !negate
+			}
+			p += 2
+		case ms.pat[p] == c:
+			return !negate
+		}
+	}
+	return negate
+}
+
+// singleMatch reports if the character at s matches the class at p
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchSet(c, p, ep-1)
+	}
+	return ms.pat[p] == c
+}
+
+// match matches the pattern from p against the source from s, it returns
+// the end of the match or -1
+func (ms *matchState) match(s, p int) (int, error) {
+	ms.depth++
+	defer func() { ms.depth-- }()
+	if ms.depth > maxMatchDepth {
+		return -1, ms.errorf("pattern too complex")
+	}
+	for {
+		if p >= len(ms.pat) {
+			return s, nil
+		}
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s, nil
+				}
+				return -1, nil
+			}
+		case '%':
+			if p+1 < len(ms.pat) {
+				switch ms.pat[p+1] {
+				case 'b':
+					return ms.matchBalance(s, p+2)
+				case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+					l := int(ms.pat[p+1] - '1')
+					if l >= ms.level || ms.capture[l].len == capUnfinished {
+						return -1, ms.errorf("invalid capture index")
+					}
+					captured := ms.src[ms.capture[l].start : ms.capture[l].start+ms.capture[l].len]
+					if !strings.HasPrefix(ms.src[s:], captured) {
+						return -1, nil
+					}
+					s += len(captured)
+					p += 2
+					continue
+				}
+			}
+		}
+
+		ep, err := ms.classEnd(p)
+		if err != nil {
+			return -1, err
+		}
+		var quantifier byte
+		if ep < len(ms.pat) {
+			quantifier = ms.pat[ep]
+		}
+		switch quantifier {
+		case '?':
+			if ms.singleMatch(s, p, ep) {
+				if res, err := ms.match(s+1, ep+1); res >= 0 || err != nil {
+					return res, err
+				}
+			}
+			p = ep + 1
+			continue
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '+':
+			if !ms.singleMatch(s, p, ep) {
+				return -1, nil
+			}
+			return ms.maxExpand(s+1, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1, nil
+		}
+		s, p = s+1, ep
+	}
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) (int, error) {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if res, err := ms.match(s+i, ep+1); res >= 0 || err != nil {
+			return res, err
+		}
+	}
+	return -1, nil
+}
+
+func (ms *matchState) minExpand(s, p, ep int) (int, error) {
+	for {
+		if res, err := ms.match(s, ep+1); res >= 0 || err != nil {
+			return res, err
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1, nil
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) (int, error) {
+	if ms.level >= MAX_CAPTURES {
+		return -1, ms.errorf("too many captures")
+	}
+	ms.capture[ms.level].start = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res, err := ms.match(s, p)
+	if res < 0 {
+		ms.level--
+	}
+	return res, err
+}
+
+func (ms *matchState) endCapture(s, p int) (int, error) {
+	l := -1
+	for i := ms.level - 1; i >= 0; i-- {
+		if ms.capture[i].len == capUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		return -1, ms.errorf("invalid pattern capture")
+	}
+	ms.capture[l].len = s - ms.capture[l].start
+	res, err := ms.match(s, p)
+	if res < 0 {
+		ms.capture[l].len = capUnfinished
+	}
+	return res, err
+}
+
+func (ms *matchState) matchBalance(s, p int) (int, error) {
+	if p+1 >= len(ms.pat) {
+		return -1, ms.errorf("missing arguments to '%%b'")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1, nil
+	}
+	open, close := ms.pat[p], ms.pat[p+1]
+	depth := 1
+	for i := s + 1; i < len(ms.src); i++ {
+		switch ms.src[i] {
+		case close:
+			depth--
+			if depth == 0 {
+				return ms.match(i+1, p+2)
+			}
+		case open:
+			depth++
+		}
+	}
+	return -1, nil
+}
+
+// captureValue returns the capture i, the whole match from s to e when
+// the pattern has no captures
+func (ms *matchState) captureValue(i, s, e int) (Value, error) {
+	if i >= ms.level {
+		if i == 0 {
+			return ms.src[s:e], nil
+		}
+		return nil, ms.errorf("invalid capture index")
+	}
+	c := ms.capture[i]
+	if c.len == capUnfinished {
+		return nil, ms.errorf("unfinished capture")
+	}
+	if c.len == capPosition {
+		return float64(c.start + 1), nil
+	}
+	return ms.src[c.start : c.start+c.len], nil
+}
+
+func (ms *matchState) captures(s, e int, wholeIfNone bool) ([]Value, error) {
+	n := ms.level
+	if n == 0 && wholeIfNone {
+		n = 1
+	}
+	values := make([]Value, n)
+	for i := range values {
+		v, err := ms.captureValue(i, s, e)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// find looks for the pattern in src from init, it returns the start and
+// end of the first match, start being -1 if there's none
+func (ms *matchState) find(init int) (int, int, error) {
+	p := 0
+	anchor := len(ms.pat) > 0 && ms.pat[0] == '^'
+	if anchor {
+		p = 1
+	}
+	for s := init; s <= len(ms.src); s++ {
+		ms.level = 0
+		e, err := ms.match(s, p)
+		if err != nil {
+			return -1, -1, err
+		}
+		if e >= 0 {
+			return s, e, nil
+		}
+		if anchor {
+			break
+		}
+	}
+	return -1, -1, nil
+}
+
+func findAux(s *State, args []Value, find bool) ([]Value, error) {
+	fname := "match"
+	if find {
+		fname = "find"
+	}
+	src, err := CheckString(s, args, 0, fname)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := CheckString(s, args, 1, fname)
+	if err != nil {
+		return nil, err
+	}
+	init, err := optInt(s, args, 2, fname, 1)
+	if err != nil {
+		return nil, err
+	}
+	if init < 0 {
+		init = max(len(src)+init+1, 1)
+	} else if init == 0 {
+		init = 1
+	}
+	if init > len(src)+1 {
+		return []Value{nil}, nil
+	}
+	init--
+	if find && (Truthy(arg(args, 3)) || !strings.ContainsAny(pat, patternSpecials)) {
+		i := strings.Index(src[init:], pat)
+		if i < 0 {
+			return []Value{nil}, nil
+		}
+		return []Value{float64(init + i + 1), float64(init + i + len(pat))}, nil
+	}
+	ms := &matchState{src: src, pat: pat, s: s}
+	start, end, err := ms.find(init)
+	if err != nil || start < 0 {
+		return []Value{nil}, err
+	}
+	captures, err := ms.captures(start, end, !find)
+	if err != nil {
+		return nil, err
+	}
+	if find {
+		return append([]Value{float64(start + 1), float64(end)}, captures...), nil
+	}
+	return captures, nil
+}
+
+func stringFind(s *State, args []Value) ([]Value, error) {
+	return findAux(s, args, true)
+}
+
+func stringMatch(s *State, args []Value) ([]Value, error) {
+	return findAux(s, args, false)
+}
+
+func stringGmatch(s *State, args []Value) ([]Value, error) {
+	src, err := CheckString(s, args, 0, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+	pat, err := CheckString(s, args, 1, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	iter := NewFunction("gmatch_iterator", func(s *State, _ []Value) ([]Value, error) {
+		ms := &matchState{src: src, pat: pat, s: s}
+		for ; pos <= len(src); pos++ {
+			ms.level = 0
+			e, err := ms.match(pos, 0)
+			if err != nil {
+				return nil, err
+			}
+			if e < 0 {
+				continue
+			}
+			start := pos
+			if e == pos {
+				pos++ // an empty match
+			} else {
+				pos = e
+			}
+			return ms.captures(start, e, true)
+		}
+		return []Value{nil}, nil
+	})
+	return []Value{iter}, nil
+}
+
+func stringGsub(s *State, args []Value) ([]Value, error) {
+	src, err := CheckString(s, args, 0, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	pat, err := CheckString(s, args, 1, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	repl := arg(args, 2)
+	switch repl.(type) {
+	case string, float64, *Table, *Function:
+	default:
+		return nil, ArgError(s, 3, "gsub", "string/function/table expected")
+	}
+	maxN, err := optInt(s, args, 3, "gsub", len(src)+1)
+	if err != nil {
+		return nil, err
+	}
+
+	anchor := len(pat) > 0 && pat[0] == '^'
+	p := 0
+	if anchor {
+		p = 1
+	}
+	ms := &matchState{src: src, pat: pat, s: s}
+	var b strings.Builder
+	pos, n := 0, 0
+	for n < maxN {
+		ms.level = 0
+		e, err := ms.match(pos, p)
+		if err != nil {
+			return nil, err
+		}
+		if e >= 0 {
+			n++
+			if err := ms.addValue(&b, pos, e, repl); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case e >= 0 && e > pos:
+			pos = e
+		case pos < len(src):
+			b.WriteByte(src[pos])
+			pos++
+		default:
+			pos = len(src) + 1
+		}
+		if pos > len(src) || anchor {
+			break
+		}
+	}
+	if pos < len(src) {
+		b.WriteString(src[pos:])
+	}
+	return []Value{b.String(), float64(n)}, nil
+}
+
+// addValue writes the replacement of the match from s to e
+func (ms *matchState) addValue(b *strings.Builder, s, e int, repl Value) error {
+	var v Value
+	switch repl := repl.(type) {
+	case *Function:
+		captures, err := ms.captures(s, e, true)
+		if err != nil {
+			return err
+		}
+		rets, err := ms.s.call(repl, captures)
+		if err != nil {
+			return err
+		}
+		v = arg(rets, 0)
+	case *Table:
+		key, err := ms.captureValue(0, s, e)
+		if err != nil {
+			return err
+		}
+		v = repl.Get(key)
+	default:
+		str, _ := ToString(repl)
+		for i := 0; i < len(str); i++ {
+			if str[i] != '%' || i+1 == len(str) {
+				b.WriteByte(str[i])
+				continue
+			}
+			i++
+			switch c := str[i]; {
+			case c == '0':
+				b.WriteString(ms.src[s:e])
+			case isDigit(c):
+				captured, err := ms.captureValue(int(c-'1'), s, e)
+				if err != nil {
+					return err
+				}
+				captureString, _ := ToString(captured)
+				b.WriteString(captureString)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return nil
+	}
+	if !Truthy(v) {
+		b.WriteString(ms.src[s:e]) // keeps the original
+		return nil
+	}
+	str, ok := ToString(v)
+	if !ok {
+		return ms.errorf("invalid replacement value (a %s)", TypeName(v))
+	}
+	b.WriteString(str)
+	return nil
+}
@@ -0,0 +1,203 @@
+package script
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, src string, args ...Value) []Value {
+	t.Helper()
+	s := NewState()
+	fn, err := Load("test", src)
+	if err != nil {
+		t.Fatalf("Error loading %q: %v", src, err)
+	}
+	rets, err := s.Call(fn, args...)
+	if err != nil {
+		t.Fatalf("Error running %q: %v", src, err)
+	}
+	return rets
+}
+
+func runError(t *testing.T, src string) string {
+	t.Helper()
+	s := NewState()
+	s.Strict = true
+	fn, err := Load("test", src)
+	if err == nil {
+		_, err = s.Call(fn)
+	}
+	if err == nil {
+		t.Fatalf("Expected %q to fail", src)
+	}
+	return err.Error()
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected []Value
+	}{
+		{"return 1 + 2 * 3", []Value{7.0}},
+		{"return (1 + 2) * 3, 2 ^ 3 ^ 2, 7 % 3, -7 % 3", []Value{9.0, 512.0, 1.0, 2.0}},
+		{"return 'a' .. 'b' .. 1, '10' + 1, 0x10", []Value{"ab1", 11.0, 16.0}},
+		{"return 1 < 2, 'a' < 'b', 1 == 1.0, 'x' ~= 'x', not nil", []Value{true, true, true, false, true}},
+		{"return nil or 'default', false and 1, 1 and 2", []Value{"default", false, 2.0}},
+		{"return #'abc', #{1, 2, 3}", []Value{3.0, 3.0}},
+		{"local a, b = 1 return a, b", []Value{1.0, nil}},
+		{"local a, b = 1, 2 a, b = b, a return a, b", []Value{2.0, 1.0}},
+		{"local t = {} t.x = 1 t['y'] = 2 return t.x + t.y", []Value{3.0}},
+		{"local n = 0 for i = 1, 10 do n = n + i end return n", []Value{55.0}},
+		{"local n = 0 for i = 10, 1, -2 do n = n + i end return n", []Value{30.0}},
+		{"local n = 0 while true do n = n + 1 if n == 5 then break end end return n", []Value{5.0}},
+		{"local n = 0 repeat local m = n n = n + 1 until m >= 3 return n", []Value{4.0}},
+		{"local x = 5 if x < 3 then return 'a' elseif x < 10 then return 'b' else return 'c' end", []Value{"b"}},
+		{"local function fact(n) if n <= 1 then return 1 end return n * fact(n - 1) end return fact(10)", []Value{3628800.0}},
+		{"local function f(...) return select('#', ...), ... end return f(1, nil, 3)", []Value{3.0, 1.0, nil, 3.0}},
+		{"local function f() return 1, 2 end return ({f(), f()})[3], (f())", []Value{2.0, 1.0}},
+		{"return ...", []Value{"arg1", "arg2"}},
+		{"local fs = {} for i = 1, 3 do fs[i] = function() return i end end return fs[1]() + fs[3]()", []Value{4.0}},
+		{"local counter = 0 local function inc() counter = counter + 1 end inc() inc() return counter", []Value{2.0}},
+		{"local t = {10, 20, 30, x = 1} local s = 0 for i, v in ipairs(t) do s = s + i * v end return s", []Value{140.0}},
+		{"local t = {a = 1, b = 2, 3} local keys = {} for k in pairs(t) do keys[#keys + 1] = tostring(k) end return table.concat(keys, ',')", []Value{"1,a,b"}},
+		{"local obj = {n = 1} function obj:add(m) self.n = self.n + m return self end return obj:add(2):add(3).n", []Value{6.0}},
+		{"return ('hello'):upper(), string.sub('hello', 2, -2), string.rep('ab', 3, '')", []Value{"HELLO", "ell", "ababab"}},
+		{"return string.format('%d %s %5.2f %x %q', 42, 'str', 3.14159, 255, 'a\"b')", []Value{`42 str  3.14 ff "a\"b"`}},
+		{"return tostring(nil), tostring(1.5), tostring(10), tonumber('0x1f'), tonumber('z', 36), tonumber('x')", []Value{"nil", "1.5", "10", 31.0, 35.0, nil}},
+		{"local t = {3, 1, 2} table.sort(t) return table.concat(t, ' ')", []Value{"1 2 3"}},
+		{"local t = {3, 1, 2} table.sort(t, function(a, b) return a > b end) return unpack(t)", []Value{3.0, 2.0, 1.0}},
+		{"local t = {1, 2} table.insert(t, 3) table.insert(t, 1, 0) return table.remove(t), table.remove(t, 1), #t", []Value{3.0, 0.0, 2.0}},
+		{"return math.floor(3.7), math.max(1, 5, 3), math.min(4, 2), math.huge > 1e308", []Value{3.0, 5.0, 2.0, true}},
+		{"return pcall(error, 'boom', 0)", []Value{false, "boom"}},
+		{"return pcall(error, {code = 1})", nil}, // checked below
+		{"return pcall(function(a) return a * 2 end, 21)", []Value{true, 42.0}},
+		{"return type(nil), type({}), type(print or type), type('')", []Value{"nil", "table", "function", "string"}},
+		{"--[[ long\ncomment ]] return [[long\nstring]] -- trailing", []Value{"long\nstring"}},
+		{"local t = {n = 1} t.n = nil return next(t)", []Value{nil}},
+		{"local s = 'x' return 1e2, .5, 'a\\tb\\65'", []Value{100.0, 0.5, "a\tbA"}},
+	}
+	for _, test := range tests {
+		rets := run(t, test.src, "arg1", "arg2")
+		if test.expected == nil {
+			continue
+		}
+		if !reflect.DeepEqual(rets, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.src, test.expected, rets)
+		}
+	}
+
+	rets := run(t, "return pcall(error, {code = 1})")
+	if tbl, ok := rets[1].(*Table); rets[0] != false || !ok || tbl.Get("code") != 1.0 {
+		t.Errorf("Expected pcall to return the table raised, got %v", rets)
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected []Value
+	}{
+		{"return string.find('hello world', 'o w')", []Value{5.0, 7.0}},
+		{"return string.find('a.b', '.', 1, true)", []Value{2.0, 2.0}},
+		{"return string.find('key:123', '(%a+):(%d+)')", []Value{1.0, 7.0, "key", "123"}},
+		{"return string.match('  trim  ', '^%s*(.-)%s*$')", []Value{"trim"}},
+		{"return string.match('2024-01-15', '(%d+)-(%d+)-(%d+)')", []Value{"2024", "01", "15"}},
+		{"return string.match('hello', '()ll()')", []Value{3.0, 5.0}},
+		{"return string.match('f(a(b)c)d', '%b()')", []Value{"(a(b)c)"}},
+		{"return string.match('abc', '^b')", []Value{nil}},
+		{"return string.match('x = [abc]', '%[([^%]]*)%]')", []Value{"abc"}},
+		{"return string.gsub('hello world', 'o', '0')", []Value{"hell0 w0rld", 2.0}},
+		{"return string.gsub('hello world', '(%w+)', '<%1>')", []Value{"<hello> <world>", 2.0}},
+		{"return string.gsub('abc', '', '-')", []Value{"-a-b-c-", 4.0}},
+		{"return string.gsub('$name is $age', '%$(%w+)', {name = 'bob', age = 3})", []Value{"bob is 3", 2.0}},
+		{"return string.gsub('a b c', '%w', string.upper, 2)", []Value{"A B c", 2.0}},
+		{"local words = {} for w in string.gmatch('one two  three', '%a+') do words[#words + 1] = w end return table.concat(words, '|')", []Value{"one|two|three"}},
+		{"local t = {} for k, v in string.gmatch('a=1, b=2', '(%w+)=(%w+)') do t[#t + 1] = k .. v end return unpack(t)", []Value{"a1", "b2"}},
+	}
+	for _, test := range tests {
+		rets := run(t, test.src)
+		if !reflect.DeepEqual(rets, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.src, test.expected, rets)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"return 1 +", "test:1: unexpected symbol near '<eof>'"},
+		{"x = = 1", "test:1: unexpected symbol near '='"},
+		{"if true then", "test:1: 'end' expected near '<eof>'"},
+		{"return 'abc", "test:1: unfinished string"},
+		{"\nlocal t = nil\nreturn t.x", "test:3: attempt to index local 't' (a nil value)"},
+		{"return undefined", "test:1: Script attempted to access nonexistent global variable 'undefined'"},
+		{"local a = {} return a + 1", "test:1: attempt to perform arithmetic on local 'a' (a table value)"},
+		{"local a = {} return a.b.c", "test:1: attempt to index field 'b' (a nil value)"},
+		{"local f return f()", "test:1: attempt to call local 'f' (a nil value)"},
+		{"return 1 < 'x'", "test:1: attempt to compare number with string"},
+		{"return {} .. 'x'", "test:1: attempt to concatenate a table value"},
+		{"error('custom')", "test:1: custom"},
+		{"error('no position', 0)", "no position"},
+		{"string.sub()", "test:1: bad argument #1 to 'sub' (string expected, got no value)"},
+		{"local function f() return f() + 1 end return f()", "test:1: stack overflow"},
+		{"string.x = 1", "test:1: Attempt to modify a readonly table"},
+		{"local t = {} t[nil] = 1", "test:1: table index is nil"},
+	}
+	for _, test := range tests {
+		if msg := runError(t, test.src); msg != test.expected {
+			t.Errorf("%q: expected error %q, got %q", test.src, test.expected, msg)
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	s := NewState()
+	calls := 0
+	killed := errors.New("killed")
+	s.Interrupt = func() error {
+		calls++
+		if calls == 3 {
+			return killed
+		}
+		return nil
+	}
+	fn, err := Load("test", "while true do pcall(function() end) end")
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	_, err = s.Call(fn)
+	var e *Error
+	if !errors.As(err, &e) || !e.Fatal || !strings.Contains(e.Error(), "killed") {
+		t.Errorf("Expected the script to be interrupted, got %v", err)
+	}
+}
+
+func TestTable(t *testing.T) {
+	tbl := NewTable()
+	tbl.Set(2.0, "b")
+	tbl.Set("k", "v")
+	tbl.Set(1.0, "a")
+	if tbl.Len() != 2 {
+		t.Errorf("Expected the keys 1 and 2 in the array, got a length of %d", tbl.Len())
+	}
+	var keys []Value
+	for k, _, _ := tbl.Next(nil); k != nil; k, _, _ = tbl.Next(k) {
+		keys = append(keys, k)
+	}
+	if !reflect.DeepEqual(keys, []Value{1.0, 2.0, "k"}) {
+		t.Errorf("Expected the keys in order, got %v", keys)
+	}
+	tbl.Set(2.0, nil)
+	tbl.Set("k", nil)
+	if tbl.Len() != 1 || tbl.Get("k") != nil {
+		t.Errorf("Expected the keys removed, got a length of %d and k = %v", tbl.Len(), tbl.Get("k"))
+	}
+	for i := 0; i < 100; i++ {
+		tbl.Set(formatNumber(float64(i)), i)
+		tbl.Set(formatNumber(float64(i)), nil)
+	}
+	if len(tbl.entries) > 10 {
+		t.Errorf("Expected the removed entries to be dropped, %d are kept", len(tbl.entries))
+	}
+}
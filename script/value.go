@@ -0,0 +1,280 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is a value of a script: nil, a bool, a float64, a string, a
+// *Table or a *Function
+type Value any
+
+// Function is a function of a script, or one of the host called by it
+type Function struct {
+	name   string
+	proto  *functionExpr
+	env    *scope // the scope the function was created in, for its upvalues
+	chunk  string
+	native func(s *State, args []Value) ([]Value, error)
+}
+
+// NewFunction returns a function of the host scripts can call, it returns
+// the values of the call or an error raised in the script
+func NewFunction(name string, fn func(s *State, args []Value) ([]Value, error)) *Function {
+	return &Function{name: name, native: fn}
+}
+
+// Name returns the name the function was defined with
+func (f *Function) Name() string {
+	return f.name
+}
+
+// Error is an error raised by a script, with error() or by the
+// interpreter. Value is what was raised, usually a message prefixed by
+// where it happened.
+type Error struct {
+	Value Value
+	Line  int  // line the error was raised at, 0 if unknown
+	Fatal bool // can't be caught by pcall, like a script being killed
+}
+
+func (e *Error) Error() string {
+	if s, ok := ToString(e.Value); ok {
+		return s
+	}
+	return fmt.Sprintf("(error object is a %s value)", TypeName(e.Value))
+}
+
+// TypeName returns the type of v, as the type function
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function:
+		return "function"
+	}
+	return "userdata"
+}
+
+// Truthy reports if v is neither nil nor false
+func Truthy(v Value) bool {
+	return v != nil && v != false
+}
+
+// ToString converts a string or a number to a string, like the
+// concatenation does
+func ToString(v Value) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return formatNumber(v), true
+	}
+	return "", false
+}
+
+// ToNumber converts a number or a numeric string to a number, like the
+// arithmetic does
+func ToNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		return parseNumber(v)
+	}
+	return 0, false
+}
+
+// parseNumber parses a decimal or hexadecimal number, with spaces around
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		n, err := strconv.ParseUint(s[2:], 16, 64)
+		return float64(n), err == nil
+	}
+	if s == "" || strings.ContainsAny(s, "_xXpP") || strings.EqualFold(s, "inf") || strings.EqualFold(s, "nan") ||
+		strings.EqualFold(s, "+inf") || strings.EqualFold(s, "-inf") || strings.EqualFold(s, "infinity") {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil || isRangeError(err)
+}
+
+func isRangeError(err error) bool {
+	numErr, ok := err.(*strconv.NumError)
+	return ok && numErr.Err == strconv.ErrRange
+}
+
+// formatNumber formats a number as %.14g, integers without a fraction
+func formatNumber(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	case n == math.Trunc(n) && math.Abs(n) < 1e15:
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// Table is the table of the scripts. The values of the keys 1 to n are
+// kept in a slice, the other ones in the order they were added so the
+// traversals are deterministic.
+type Table struct {
+	array    []Value
+	hash     map[Value]int // index of the entry of a key
+	entries  []tableEntry
+	removed  int // entries whose value was set to nil
+	readOnly bool
+}
+
+type tableEntry struct {
+	key, value Value
+}
+
+// NewTable returns an empty table
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Freeze makes the table read-only for the scripts, its fields can only be
+// set by the host
+func (t *Table) Freeze() {
+	t.readOnly = true
+}
+
+// Get returns the value of a key, nil if it isn't set
+func (t *Table) Get(key Value) Value {
+	if n, ok := key.(float64); ok && n >= 1 && n <= float64(len(t.array)) && n == math.Trunc(n) {
+		return t.array[int(n)-1]
+	}
+	if i, ok := t.hash[key]; ok {
+		return t.entries[i].value
+	}
+	return nil
+}
+
+// Set sets the value of a key, nil removes it. The key can't be nil or NaN.
+func (t *Table) Set(key, value Value) {
+	if n, ok := key.(float64); ok && n >= 1 && n == math.Trunc(n) && n <= float64(len(t.array)+1) {
+		i := int(n)
+		if i <= len(t.array) {
+			t.array[i-1] = value
+			for len(t.array) > 0 && t.array[len(t.array)-1] == nil {
+				t.array = t.array[:len(t.array)-1]
+			}
+			return
+		}
+		if value != nil {
+			t.array = append(t.array, value)
+			t.removeHashKey(n)
+			// the following keys may be set already
+			for {
+				next := float64(len(t.array) + 1)
+				v := t.Get(next)
+				if v == nil {
+					break
+				}
+				t.array = append(t.array, v)
+				t.removeHashKey(next)
+			}
+			return
+		}
+	}
+	if i, ok := t.hash[key]; ok {
+		if t.entries[i].value != nil && value == nil {
+			t.removed++
+		} else if t.entries[i].value == nil && value != nil {
+			t.removed--
+		}
+		t.entries[i].value = value
+		return
+	}
+	if value == nil {
+		return
+	}
+	if t.removed > len(t.entries)/2 {
+		t.compact()
+	}
+	if t.hash == nil {
+		t.hash = make(map[Value]int)
+	}
+	t.hash[key] = len(t.entries)
+	t.entries = append(t.entries, tableEntry{key: key, value: value})
+}
+
+func (t *Table) removeHashKey(key Value) {
+	if i, ok := t.hash[key]; ok && t.entries[i].value != nil {
+		t.entries[i].value = nil
+		t.removed++
+	}
+}
+
+// compact drops the entries of the keys removed, adding a key during a
+// traversal is what makes it undefined
+func (t *Table) compact() {
+	entries := t.entries[:0]
+	for _, e := range t.entries {
+		if e.value != nil {
+			entries = append(entries, e)
+		} else {
+			delete(t.hash, e.key)
+		}
+	}
+	for i := len(entries); i < len(t.entries); i++ {
+		t.entries[i] = tableEntry{}
+	}
+	t.entries, t.removed = entries, 0
+	for i, e := range t.entries {
+		t.hash[e.key] = i
+	}
+}
+
+// Len returns the length of the table, the number of its keys from 1 on
+func (t *Table) Len() int {
+	return len(t.array)
+}
+
+// Append sets the key after the last one
+func (t *Table) Append(value Value) {
+	t.Set(float64(len(t.array)+1), value)
+}
+
+// Next returns the key and the value after key in a traversal of the
+// table, nil to start. ok is false if key isn't in the table.
+func (t *Table) Next(key Value) (Value, Value, bool) {
+	i := 0 // position in the array, then in the entries after it
+	if key != nil {
+		if n, isNum := key.(float64); isNum && n >= 1 && n <= float64(len(t.array)) && n == math.Trunc(n) {
+			i = int(n)
+		} else if j, ok := t.hash[key]; ok {
+			i = len(t.array) + j + 1
+		} else {
+			return nil, nil, false
+		}
+	}
+	for ; i < len(t.array); i++ {
+		if t.array[i] != nil {
+			return float64(i + 1), t.array[i], true
+		}
+	}
+	for j := i - len(t.array); j < len(t.entries); j++ {
+		if e := t.entries[j]; e.value != nil {
+			return e.key, e.value, true
+		}
+	}
+	return nil, nil, true
+}
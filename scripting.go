@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+	"github.com/shubhdevelop/YAKVS/script"
+	"github.com/shubhdevelop/YAKVS/store"
+	"github.com/shubhdevelop/YAKVS/utils"
+)
+
+/*
+Scripts run server-side with EVAL, or as functions of a library loaded with
+FUNCTION LOAD and called with FCALL. They're written in a subset of Lua run
+by the interpreter of the script package, and call commands with
+redis.call and redis.pcall.
+
+A script runs alone: it holds execMu like a transaction, and the other
+clients wait for it. Once it ran for longer than busy-reply-threshold they
+get a -BUSY error instead, and SCRIPT KILL (or FUNCTION KILL) stops it
+unless it already wrote to the dataset.
+
+The effects of a script are propagated rather than the script: the write
+commands it ran reach the AOF and the replicas as a transaction, so they
+don't need the script, and replay the same writes whatever it computed.
+*/
+
+const (
+	SCRIPT_BUSY_REPLY_THRESHOLD_DEFAULT = 5 * time.Second
+	SCRIPT_FUNCTION_LOAD_TIMEOUT        = 500 * time.Millisecond
+	SCRIPT_MAX_REPLY_DEPTH              = 100 // nesting of the tables a script replies with
+
+	SCRIPT_LOG_DEBUG   = 0
+	SCRIPT_LOG_VERBOSE = 1
+	SCRIPT_LOG_NOTICE  = 2
+	SCRIPT_LOG_WARNING = 3
+)
+
+// busyReplyThreshold is how long a script runs before the other clients
+// get -BUSY errors, a time.Duration from busy-reply-threshold
+var busyReplyThreshold atomic.Int64
+
+func init() {
+	busyReplyThreshold.Store(int64(SCRIPT_BUSY_REPLY_THRESHOLD_DEFAULT))
+}
+
+// scriptFlags are the flags of a script, from the shebang of EVAL or
+// the registration of a function
+var scriptFlags = map[string]bool{
+	"no-writes": true, "allow-oom": true, "allow-stale": true,
+	"no-cluster": true, "allow-cross-slot-keys": true,
+}
+
+// scripts caches the scripts of EVAL and SCRIPT LOAD by SHA1
+var scripts = struct {
+	mu    sync.Mutex
+	bySHA map[string]*cachedScript
+}{bySHA: make(map[string]*cachedScript)}
+
+type cachedScript struct {
+	body     string
+	fn       *script.Function
+	readOnly bool // #!lua flags=no-writes
+}
+
+// functions holds the libraries of FUNCTION LOAD
+var functions = struct {
+	mu        sync.Mutex
+	libraries map[string]*functionLibrary
+	byName    map[string]*scriptFunction
+}{
+	libraries: make(map[string]*functionLibrary),
+	byName:    make(map[string]*scriptFunction),
+}
+
+type functionLibrary struct {
+	name      string
+	code      string
+	functions []*scriptFunction // in the order they were registered
+}
+
+type scriptFunction struct {
+	name        string
+	description string
+	flags       []string
+	fn          *script.Function
+	library     *functionLibrary
+}
+
+func (f *scriptFunction) hasFlag(flag string) bool {
+	for _, fl := range f.flags {
+		if fl == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptRun is a running script
+type scriptRun struct {
+	caller   *Client
+	client   *Client // runs the commands of the script, see scriptClient
+	name     string  // the SHA1 of the script or the name of the function
+	function bool
+	readOnly bool
+	start    time.Time
+	wrote    atomic.Bool // ran a write command, it can't be killed anymore
+	killed   atomic.Bool
+	slow     bool // logged as slow already
+	done     chan struct{}
+	effects  []*parser.Command
+}
+
+// running is the script running, one runs at a time
+var running = struct {
+	mu  sync.Mutex
+	run *scriptRun
+}{}
+
+func sha1hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseShebang splits the "#!engine name=... flags=..." first line of a
+// script or library from its body, the line is kept empty so the line
+// numbers don't change
+func parseShebang(code string) (engine string, params map[string]string, body string, ok bool) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", nil, code, false
+	}
+	line, rest, _ := strings.Cut(code, "\n")
+	fields := strings.Fields(line[2:])
+	params = make(map[string]string)
+	if len(fields) > 0 {
+		engine = fields[0]
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			params[key] = value
+		}
+	}
+	return engine, params, "\n" + rest, true
+}
+
+// compileScript compiles the script of EVAL or SCRIPT LOAD and caches it
+func compileScript(body string) (string, *cachedScript, error) {
+	sha := sha1hex(body)
+	scripts.mu.Lock()
+	cached := scripts.bySHA[sha]
+	scripts.mu.Unlock()
+	if cached != nil {
+		return sha, cached, nil
+	}
+
+	cached = &cachedScript{body: body}
+	engine, params, src, hasShebang := parseShebang(body)
+	if hasShebang {
+		if !strings.EqualFold(engine, "lua") {
+			return "", nil, fmt.Errorf("ERR Unexpected engine in script shebang: %s", engine)
+		}
+		for key, value := range params {
+			if key != "flags" {
+				return "", nil, fmt.Errorf("ERR Unknown lua shebang option: %s", key)
+			}
+			for _, flag := range strings.Split(value, ",") {
+				if flag == "" {
+					continue
+				}
+				if !scriptFlags[flag] {
+					return "", nil, fmt.Errorf("ERR Unexpected flag in script shebang: %s", flag)
+				}
+				cached.readOnly = cached.readOnly || flag == "no-writes"
+			}
+		}
+	}
+	fn, err := script.Load("user_script", src)
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+	cached.fn = fn
+	scripts.mu.Lock()
+	scripts.bySHA[sha] = cached
+	scripts.mu.Unlock()
+	return sha, cached, nil
+}
+
+// oneLine makes a message fit an error reply
+func oneLine(msg string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(msg)
+}
+
+// scriptKeysAndArgs splits the "numkeys key... arg..." arguments of EVAL
+// and FCALL
+func scriptKeysAndArgs(args []string) ([]string, []string, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, fmt.Errorf("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, fmt.Errorf("ERR Number of keys can't be greater than number of args")
+	}
+	return args[1 : 1+numKeys], args[1+numKeys:], nil
+}
+
+// scriptGetKeys returns the keys of EVAL and FCALL, given after numkeys
+func scriptGetKeys(words []string) []string {
+	if len(words) < 3 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(words[2])
+	if err != nil || numKeys <= 0 || numKeys > len(words)-3 {
+		return nil
+	}
+	return words[3 : 3+numKeys]
+}
+
+// eval handles EVAL, EVALSHA and their read-only variants, it returns the
+// write commands the script ran
+func (c *Client) eval(name string, args []string) []*parser.Command {
+	w := c.writer()
+	keys, argv, err := scriptKeysAndArgs(args[1:])
+	if err != nil {
+		w.WriteError(err.Error())
+		return nil
+	}
+
+	var sha string
+	var cached *cachedScript
+	if name == "EVAL" || name == "EVAL_RO" {
+		sha, cached, err = compileScript(args[0])
+		if err != nil {
+			w.WriteError(err.Error())
+			return nil
+		}
+	} else {
+		sha = strings.ToLower(args[0])
+		scripts.mu.Lock()
+		cached = scripts.bySHA[sha]
+		scripts.mu.Unlock()
+		if cached == nil {
+			w.WriteError("NOSCRIPT No matching script. Please use EVAL.")
+			return nil
+		}
+	}
+
+	keysTable, argvTable := stringsTable(keys), stringsTable(argv)
+	return c.runScript(sha, cached.fn, false, cached.readOnly || strings.HasSuffix(name, "_RO"), func(s *script.State) {
+		s.Globals.Set("KEYS", keysTable)
+		s.Globals.Set("ARGV", argvTable)
+	}, nil)
+}
+
+// fcall handles FCALL and FCALL_RO, it returns the write commands the
+// function ran
+func (c *Client) fcall(name string, args []string) []*parser.Command {
+	w := c.writer()
+	keys, argv, err := scriptKeysAndArgs(args[1:])
+	if err != nil {
+		w.WriteError(err.Error())
+		return nil
+	}
+	functions.mu.Lock()
+	f := functions.byName[args[0]]
+	functions.mu.Unlock()
+	if f == nil {
+		w.WriteError("ERR Function not found")
+		return nil
+	}
+	readOnly := f.hasFlag("no-writes")
+	if name == "FCALL_RO" && !readOnly {
+		w.WriteError("ERR Can not execute a script with write flag using *_ro command.")
+		return nil
+	}
+	return c.runScript(f.name, f.fn, true, readOnly, nil, []script.Value{stringsTable(keys), stringsTable(argv)})
+}
+
+// stringsTable returns the strings as an array of the scripts
+func stringsTable(values []string) *script.Table {
+	t := script.NewTable()
+	for _, v := range values {
+		t.Append(v)
+	}
+	return t
+}
+
+// runScript runs a script or a function for the client and replies with
+// what it returns. setup adds globals to the state it runs in. It returns
+// the write commands the script ran, even if it failed after them.
+func (c *Client) runScript(name string, fn *script.Function, function, readOnly bool, setup func(*script.State), args []script.Value) []*parser.Command {
+	w := c.writer()
+	run := &scriptRun{
+		caller:   c,
+		client:   c.scriptClient(),
+		name:     name,
+		function: function,
+		readOnly: readOnly,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+	}
+	s := script.NewState()
+	s.Strict = true
+	s.Interrupt = run.interrupt
+	s.Globals.Set("redis", run.redisLib())
+	if setup != nil {
+		setup(s)
+	}
+	s.Globals.Freeze()
+
+	running.mu.Lock()
+	running.run = run
+	running.mu.Unlock()
+	defer func() {
+		running.mu.Lock()
+		running.run = nil
+		running.mu.Unlock()
+		close(run.done)
+	}()
+
+	rets, err := s.Call(fn, args...)
+	if err != nil {
+		w.WriteError(run.errorReply(err))
+		return run.effects
+	}
+	var ret script.Value
+	if len(rets) > 0 {
+		ret = rets[0]
+	}
+	writeScriptValue(w, ret, 0)
+	return run.effects
+}
+
+// scriptClient returns the client the commands of a script run as: it
+// has the user of c, and replies in RESP2 to the script
+func (c *Client) scriptClient() *Client {
+	sc := &Client{
+		store:         c.store,
+		out:           io.Discard,
+		watcher:       store.NewWatcher(),
+		channels:      make(map[string]struct{}),
+		patterns:      make(map[string]struct{}),
+		shardChannels: make(map[string]struct{}),
+		id:            c.id,
+		createdAt:     time.Now(),
+		user:          c.username(),
+		authenticated: true,
+		flags:         c.flags&CLIENT_MASTER | CLIENT_SCRIPT,
+	}
+	sc.resp.Store(2)
+	return sc
+}
+
+// interrupt is called while the script runs, it logs a slow script once
+// and stops a killed one
+func (r *scriptRun) interrupt() error {
+	if !r.slow && time.Since(r.start) >= time.Duration(busyReplyThreshold.Load()) {
+		r.slow = true
+		log.Printf("Slow script detected: still in execution after %d milliseconds. You can try killing the script using the %s command. Script name is: %s.",
+			time.Since(r.start).Milliseconds(), r.killCommand(), r.name)
+	}
+	if r.killed.Load() {
+		return &script.Error{Value: errorTable(fmt.Sprintf("ERR Script killed by user with %s...", r.killCommand())), Fatal: true}
+	}
+	return nil
+}
+
+// killCommand is the command stopping the script
+func (r *scriptRun) killCommand() string {
+	if r.function {
+		return "FUNCTION KILL"
+	}
+	return "SCRIPT KILL"
+}
+
+// errorReply returns the error reply of a script that failed: the error of
+// a command or of redis.error_reply as is, the others with where they
+// were raised
+func (r *scriptRun) errorReply(err error) string {
+	e, ok := err.(*script.Error)
+	if !ok {
+		return "ERR " + oneLine(err.Error())
+	}
+	if t, ok := e.Value.(*script.Table); ok {
+		if msg, ok := t.Get("err").(string); ok {
+			return oneLine(msg)
+		}
+	}
+	msg := oneLine(e.Error())
+	if e.Line > 0 {
+		chunk := "user_script"
+		if r.function {
+			chunk = "user_function"
+		}
+		return fmt.Sprintf("ERR %s script: %s, on @%s:%d.", msg, r.name, chunk, e.Line)
+	}
+	return "ERR " + msg
+}
+
+func errorTable(msg string) *script.Table {
+	t := script.NewTable()
+	t.Set("err", msg)
+	return t
+}
+
+func statusTable(msg string) *script.Table {
+	t := script.NewTable()
+	t.Set("ok", msg)
+	return t
+}
+
+// redisLib returns the redis table of the scripts, the API into the
+// commands
+func (r *scriptRun) redisLib() *script.Table {
+	lib := newRedisLib()
+	lib.Set("call", script.NewFunction("redis.call", func(s *script.State, args []script.Value) ([]script.Value, error) {
+		return r.call(s, args, false)
+	}))
+	lib.Set("pcall", script.NewFunction("redis.pcall", func(s *script.State, args []script.Value) ([]script.Value, error) {
+		return r.call(s, args, true)
+	}))
+	lib.Freeze()
+	return lib
+}
+
+// newRedisLib returns the part of the redis table that doesn't call
+// commands, the one library code gets when loaded
+func newRedisLib() *script.Table {
+	lib := script.NewTable()
+	lib.Set("error_reply", script.NewFunction("redis.error_reply", func(s *script.State, args []script.Value) ([]script.Value, error) {
+		msg, err := script.CheckString(s, args, 0, "error_reply")
+		if err != nil {
+			return nil, err
+		}
+		return []script.Value{errorTable(msg)}, nil
+	}))
+	lib.Set("status_reply", script.NewFunction("redis.status_reply", func(s *script.State, args []script.Value) ([]script.Value, error) {
+		msg, err := script.CheckString(s, args, 0, "status_reply")
+		if err != nil {
+			return nil, err
+		}
+		return []script.Value{statusTable(msg)}, nil
+	}))
+	lib.Set("sha1hex", script.NewFunction("redis.sha1hex", func(s *script.State, args []script.Value) ([]script.Value, error) {
+		str, err := script.CheckString(s, args, 0, "sha1hex")
+		if err != nil {
+			return nil, err
+		}
+		return []script.Value{sha1hex(str)}, nil
+	}))
+	lib.Set("log", script.NewFunction("redis.log", func(s *script.State, args []script.Value) ([]script.Value, error) {
+		if len(args) < 2 {
+			return nil, s.Errorf("redis.log() requires two arguments or more.")
+		}
+		level, ok := script.ToNumber(args[0])
+		if !ok || level < SCRIPT_LOG_DEBUG || level > SCRIPT_LOG_WARNING {
+			return nil, s.Errorf("Invalid debug level.")
+		}
+		var parts []string
+		for i := 1; i < len(args); i++ {
+			part, err := script.CheckString(s, args, i, "log")
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		log.Print(strings.Join(parts, " "))
+		return nil, nil
+	}))
+	lib.Set("LOG_DEBUG", float64(SCRIPT_LOG_DEBUG))
+	lib.Set("LOG_VERBOSE", float64(SCRIPT_LOG_VERBOSE))
+	lib.Set("LOG_NOTICE", float64(SCRIPT_LOG_NOTICE))
+	lib.Set("LOG_WARNING", float64(SCRIPT_LOG_WARNING))
+	return lib
+}
+
+// call runs a command for redis.call, or redis.pcall when protected: the
+// errors are returned as a table instead of raised
+func (r *scriptRun) call(s *script.State, args []script.Value, protected bool) ([]script.Value, error) {
+	fail := func(msg string) ([]script.Value, error) {
+		if protected {
+			return []script.Value{errorTable(msg)}, nil
+		}
+		return nil, &script.Error{Value: errorTable(msg)}
+	}
+	if len(args) == 0 {
+		return fail("ERR Please specify at least one argument for this redis lib call")
+	}
+	words := make([]string, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			words[i] = arg
+		case float64:
+			words[i] = strconv.FormatFloat(arg, 'f', -1, 64)
+			if arg == math.Trunc(arg) && math.Abs(arg) < 1e18 {
+				words[i] = strconv.FormatInt(int64(arg), 10)
+			}
+		default:
+			return fail("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmd := &parser.Command{Name: words[0], Args: words[1:]}
+	name := strings.ToUpper(cmd.Name)
+
+	spec := lookupCommand(name)
+	if spec == nil {
+		return fail("ERR Unknown Redis command called from script")
+	}
+	if spec.flags&CMD_NOSCRIPT != 0 {
+		return fail("ERR This Redis command is not allowed from script")
+	}
+	if err := checkArity(cmd); err != nil {
+		return fail("ERR Wrong number of args calling Redis command from script")
+	}
+	if err := r.caller.checkPermission(name, cmd.Args); err != nil {
+		return fail(err.Error())
+	}
+	if spec.flags&CMD_WRITE != 0 {
+		if r.readOnly {
+			return fail("ERR Write commands are not allowed from read-only scripts.")
+		}
+		if r.caller.flags&CLIENT_MASTER == 0 && isReadOnlyReplica() {
+			return fail("READONLY You can't write against a read only replica.")
+		}
+	}
+	if clusterEnabled() && r.caller.flags&CLIENT_MASTER == 0 && r.client.clusterRedirect([]*parser.Command{cmd}) != "" {
+		return fail("ERR Script attempted to access a non local key in a cluster node script")
+	}
+
+	for _, effect := range r.client.call(cmd) {
+		if effect != nil && aof.ShouldPersistCommand(effect.Name) {
+			r.effects = append(r.effects, effect)
+			r.wrote.Store(true)
+		}
+	}
+	reply := bytes.Clone(r.client.reply.Bytes())
+	r.client.reply.Reset()
+	v, err := parser.ReadValue(bufio.NewReader(bytes.NewReader(reply)))
+	if err != nil {
+		return fail(fmt.Sprintf("ERR Error reading the reply of the command: %v", err))
+	}
+	if v.Type == parser.SimpleError || v.Type == parser.BlobError {
+		return fail(v.Str)
+	}
+	return []script.Value{replyToScript(v)}, nil
+}
+
+// replyToScript converts the reply of a command to a value of the scripts,
+// as in Redis: integers are numbers, nulls are false, status replies and
+// errors are tables with an ok or err field
+func replyToScript(v *parser.Value) script.Value {
+	switch v.Type {
+	case parser.SimpleString:
+		return statusTable(v.Str)
+	case parser.SimpleError, parser.BlobError:
+		return errorTable(v.Str)
+	case parser.Integer:
+		return float64(v.Int)
+	case parser.BulkString, parser.Verbatim:
+		if v.Null {
+			return false
+		}
+		return v.Str
+	case parser.Double:
+		return v.Float
+	case parser.Boolean:
+		return v.Bool
+	case parser.Null:
+		return false
+	case parser.Array, parser.Set, parser.Push:
+		if v.Null {
+			return false
+		}
+		t := script.NewTable()
+		for _, elem := range v.Elems {
+			t.Append(replyToScript(elem))
+		}
+		return t
+	case parser.Map:
+		t := script.NewTable()
+		for _, pair := range v.Pairs {
+			if key := replyToScript(pair.Key); key != false {
+				t.Set(key, replyToScript(pair.Value))
+			}
+		}
+		return t
+	}
+	return false
+}
+
+// writeScriptValue replies with a value a script returned: numbers are
+// truncated to integers, nil and false are null, tables with an ok or err
+// field are status replies and errors, the other tables arrays up to their
+// first nil
+func writeScriptValue(w *resp.Writer, v script.Value, depth int) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteNull()
+	case bool:
+		if v {
+			w.WriteInteger(1)
+		} else {
+			w.WriteNull()
+		}
+	case float64:
+		w.WriteInteger(int64(v))
+	case string:
+		w.WriteBulkString(v)
+	case *script.Table:
+		if msg, ok := v.Get("err").(string); ok {
+			w.WriteError(oneLine(msg))
+			return
+		}
+		if msg, ok := v.Get("ok").(string); ok {
+			w.WriteSimpleString(oneLine(msg))
+			return
+		}
+		if depth >= SCRIPT_MAX_REPLY_DEPTH {
+			w.WriteError("ERR reached lua stack limit")
+			return
+		}
+		n := 0
+		for v.Get(float64(n+1)) != nil {
+			n++
+		}
+		w.WriteArrayLen(n)
+		for i := 1; i <= n; i++ {
+			writeScriptValue(w, v.Get(float64(i)), depth+1)
+		}
+	default:
+		w.WriteNull()
+	}
+}
+
+// waitScript holds back the commands of the other clients while a script
+// runs. Once it ran for longer than busy-reply-threshold they get a -BUSY
+// error instead, except the ones stopping it. It returns false if the
+// command mustn't run.
+func (c *Client) waitScript(name string, args []string) bool {
+	if c.flags&(CLIENT_MASTER|CLIENT_REPLICA) != 0 {
+		return true // the replication goes on once the script is done
+	}
+	if (name == "SCRIPT" || name == "FUNCTION") && len(args) > 0 && strings.EqualFold(args[0], "kill") {
+		return true
+	}
+	for {
+		running.mu.Lock()
+		run := running.run
+		running.mu.Unlock()
+		if run == nil || run.caller == c {
+			return true
+		}
+		wait := time.Until(run.start.Add(time.Duration(busyReplyThreshold.Load())))
+		if wait <= 0 {
+			c.writer().WriteError(fmt.Sprintf("BUSY Redis is busy running a script. You can only call %s or SHUTDOWN NOSAVE.", run.killCommand()))
+			return false
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-run.done:
+		case <-timer.C:
+		case <-c.gone:
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
+
+// killScript stops the script running for SCRIPT KILL, or the function for
+// FUNCTION KILL
+func killScript(w *resp.Writer, function bool) {
+	running.mu.Lock()
+	run := running.run
+	running.mu.Unlock()
+	if run == nil || run.function != function {
+		w.WriteError("NOTBUSY No scripts in execution right now.")
+		return
+	}
+	if run.wrote.Load() {
+		w.WriteError("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+		return
+	}
+	run.killed.Store(true)
+	w.WriteSimpleString("OK")
+}
+
+// scriptCommand handles the SCRIPT subcommands
+func (c *Client) scriptCommand(args []string) {
+	w := c.writer()
+	sub := strings.ToUpper(args[0])
+	switch {
+	case sub == "LOAD" && len(args) == 2:
+		sha, _, err := compileScript(args[1])
+		if err != nil {
+			w.WriteError(err.Error())
+			return
+		}
+		w.WriteBulkString(sha)
+	case sub == "EXISTS" && len(args) >= 2:
+		w.WriteArrayLen(len(args) - 1)
+		scripts.mu.Lock()
+		defer scripts.mu.Unlock()
+		for _, sha := range args[1:] {
+			if scripts.bySHA[strings.ToLower(sha)] != nil {
+				w.WriteInteger(1)
+			} else {
+				w.WriteInteger(0)
+			}
+		}
+	case sub == "FLUSH" && len(args) <= 2:
+		if len(args) == 2 && !strings.EqualFold(args[1], "sync") && !strings.EqualFold(args[1], "async") {
+			w.WriteError("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+			return
+		}
+		scripts.mu.Lock()
+		scripts.bySHA = make(map[string]*cachedScript)
+		scripts.mu.Unlock()
+		w.WriteSimpleString("OK")
+	case sub == "KILL" && len(args) == 1:
+		killScript(w, false)
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SCRIPT HELP.", strings.ToLower(sub)))
+	}
+}
+
+// functionCommand handles the FUNCTION subcommands, it reports if the
+// libraries changed and the command must be propagated
+func functionCommand(w *resp.Writer, args []string) bool {
+	sub := strings.ToUpper(args[0])
+	switch {
+	case sub == "LOAD" && len(args) >= 2:
+		replace := false
+		code := args[len(args)-1]
+		for _, arg := range args[1 : len(args)-1] {
+			if !strings.EqualFold(arg, "replace") {
+				w.WriteError(fmt.Sprintf("ERR Unknown option given: %s", arg))
+				return false
+			}
+			replace = true
+		}
+		name, err := loadLibrary(code, replace)
+		if err != nil {
+			w.WriteError(err.Error())
+			return false
+		}
+		w.WriteBulkString(name)
+		return true
+	case sub == "LIST":
+		functionList(w, args[1:])
+	case sub == "DELETE" && len(args) == 2:
+		functions.mu.Lock()
+		defer functions.mu.Unlock()
+		lib := functions.libraries[args[1]]
+		if lib == nil {
+			w.WriteError("ERR Library not found")
+			return false
+		}
+		removeLibraryLocked(lib)
+		w.WriteSimpleString("OK")
+		return true
+	case sub == "FLUSH" && len(args) <= 2:
+		if len(args) == 2 && !strings.EqualFold(args[1], "sync") && !strings.EqualFold(args[1], "async") {
+			w.WriteError("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+			return false
+		}
+		flushFunctions()
+		w.WriteSimpleString("OK")
+		return true
+	case sub == "KILL" && len(args) == 1:
+		killScript(w, true)
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try FUNCTION HELP.", strings.ToLower(sub)))
+	}
+	return false
+}
+
+// flushFunctions deletes every library
+func flushFunctions() {
+	functions.mu.Lock()
+	defer functions.mu.Unlock()
+	functions.libraries = make(map[string]*functionLibrary)
+	functions.byName = make(map[string]*scriptFunction)
+}
+
+func removeLibraryLocked(lib *functionLibrary) {
+	for _, f := range lib.functions {
+		delete(functions.byName, f.name)
+	}
+	delete(functions.libraries, lib.name)
+}
+
+// validFunctionName reports if a library or function name only has
+// letters, digits and underscores
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// loadLibrary runs the code of a library, which registers its functions
+// with redis.register_function, and adds it. It returns its name.
+func loadLibrary(code string, replace bool) (string, error) {
+	engine, params, src, ok := parseShebang(code)
+	if !ok {
+		return "", fmt.Errorf("ERR Missing library metadata")
+	}
+	if !strings.EqualFold(engine, "lua") {
+		return "", fmt.Errorf("ERR Engine '%s' not found", engine)
+	}
+	name := params["name"]
+	for key := range params {
+		if key != "name" {
+			return "", fmt.Errorf("ERR Invalid metadata value given: %s", key)
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("ERR Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", fmt.Errorf("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	functions.mu.Lock()
+	exists := functions.libraries[name] != nil
+	functions.mu.Unlock()
+	if exists && !replace {
+		return "", fmt.Errorf("ERR Library '%s' already exists", name)
+	}
+
+	fn, err := script.Load("user_function", src)
+	if err != nil {
+		return "", fmt.Errorf("ERR Error compiling function: %s", oneLine(err.Error()))
+	}
+	lib := &functionLibrary{name: name, code: code}
+	s := script.NewState()
+	s.Strict = true
+	start := time.Now()
+	s.Interrupt = func() error {
+		if time.Since(start) > SCRIPT_FUNCTION_LOAD_TIMEOUT {
+			return &script.Error{Value: "FUNCTION LOAD timeout", Fatal: true}
+		}
+		return nil
+	}
+	redisLib := newRedisLib()
+	redisLib.Set("register_function", script.NewFunction("redis.register_function", func(s *script.State, args []script.Value) ([]script.Value, error) {
+		f, err := registeredFunction(s, args)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range lib.functions {
+			if other.name == f.name {
+				return nil, s.Errorf("Function already exists in the library")
+			}
+		}
+		f.library = lib
+		lib.functions = append(lib.functions, f)
+		return nil, nil
+	}))
+	redisLib.Freeze()
+	s.Globals.Set("redis", redisLib)
+	s.Globals.Freeze()
+	if _, err := s.Call(fn); err != nil {
+		return "", fmt.Errorf("ERR Error registering functions: %s", oneLine(err.Error()))
+	}
+	if len(lib.functions) == 0 {
+		return "", fmt.Errorf("ERR No functions registered")
+	}
+
+	functions.mu.Lock()
+	defer functions.mu.Unlock()
+	old := functions.libraries[name]
+	if old != nil && !replace {
+		return "", fmt.Errorf("ERR Library '%s' already exists", name)
+	}
+	for _, f := range lib.functions {
+		if other := functions.byName[f.name]; other != nil && other.library != old {
+			return "", fmt.Errorf("ERR Function %s already exists", f.name)
+		}
+	}
+	if old != nil {
+		removeLibraryLocked(old)
+	}
+	functions.libraries[name] = lib
+	for _, f := range lib.functions {
+		functions.byName[f.name] = f
+	}
+	return name, nil
+}
+
+// registeredFunction reads the arguments of redis.register_function: a
+// name and a callback, or a table with function_name, callback and
+// optionally flags and description
+func registeredFunction(s *script.State, args []script.Value) (*scriptFunction, error) {
+	f := &scriptFunction{}
+	var callback script.Value
+	if t, ok := args[0].(*script.Table); ok && len(args) == 1 {
+		for k, v, _ := t.Next(nil); k != nil; k, v, _ = t.Next(k) {
+			switch k {
+			case "function_name":
+				f.name, _ = v.(string)
+			case "callback":
+				callback = v
+			case "description":
+				description, ok := v.(string)
+				if !ok {
+					return nil, s.Errorf("description argument given to redis.register_function must be a string")
+				}
+				f.description = description
+			case "flags":
+				flags, ok := v.(*script.Table)
+				if !ok {
+					return nil, s.Errorf("flags argument to redis.register_function must be a table representing function flags")
+				}
+				for i := 1; i <= flags.Len(); i++ {
+					flag, _ := flags.Get(float64(i)).(string)
+					if !scriptFlags[flag] {
+						return nil, s.Errorf("unknown flag given")
+					}
+					f.flags = append(f.flags, flag)
+				}
+			default:
+				return nil, s.Errorf("unknown argument given to redis.register_function")
+			}
+		}
+	} else if len(args) == 2 {
+		f.name, _ = args[0].(string)
+		callback = args[1]
+	} else {
+		return nil, s.Errorf("wrong number of arguments to redis.register_function")
+	}
+	if !validFunctionName(f.name) {
+		return nil, s.Errorf("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	fn, ok := callback.(*script.Function)
+	if !ok {
+		return nil, s.Errorf("callback must be a function")
+	}
+	f.fn = fn
+	return f, nil
+}
+
+// functionList handles FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+func functionList(w *resp.Writer, args []string) {
+	pattern, withCode := "", false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "withcode") && !withCode:
+			withCode = true
+		case strings.EqualFold(args[i], "libraryname") && pattern == "" && i+1 < len(args):
+			i++
+			pattern = args[i]
+		default:
+			w.WriteError(fmt.Sprintf("ERR Unknown argument %s", args[i]))
+			return
+		}
+	}
+
+	functions.mu.Lock()
+	defer functions.mu.Unlock()
+	var libs []*functionLibrary
+	for _, lib := range functions.libraries {
+		if pattern == "" || utils.StringMatch(pattern, lib.name, false) {
+			libs = append(libs, lib)
+		}
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+
+	w.WriteArrayLen(len(libs))
+	for _, lib := range libs {
+		if withCode {
+			w.WriteMapLen(4)
+		} else {
+			w.WriteMapLen(3)
+		}
+		w.WriteBulkString("library_name")
+		w.WriteBulkString(lib.name)
+		w.WriteBulkString("engine")
+		w.WriteBulkString("LUA")
+		w.WriteBulkString("functions")
+		w.WriteArrayLen(len(lib.functions))
+		for _, f := range lib.functions {
+			w.WriteMapLen(3)
+			w.WriteBulkString("name")
+			w.WriteBulkString(f.name)
+			w.WriteBulkString("description")
+			if f.description == "" {
+				w.WriteNull()
+			} else {
+				w.WriteBulkString(f.description)
+			}
+			w.WriteBulkString("flags")
+			w.WriteSetLen(len(f.flags))
+			for _, flag := range f.flags {
+				w.WriteBulkString(flag)
+			}
+		}
+		if withCode {
+			w.WriteBulkString("library_code")
+			w.WriteBulkString(lib.code)
+		}
+	}
+}
+
+// writeFunctions writes the libraries as FUNCTION LOAD commands, for the
+// snapshot a replica gets
+func writeFunctions(buf *bytes.Buffer) {
+	functions.mu.Lock()
+	defer functions.mu.Unlock()
+	names := make([]string, 0, len(functions.libraries))
+	for name := range functions.libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buf.WriteString(aof.EncodeCommand(&parser.Command{Name: "FUNCTION", Args: []string{"LOAD", functions.libraries[name].code}}))
+	}
+}
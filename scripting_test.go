@@ -0,0 +1,272 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+)
+
+func resetScripting(t *testing.T) {
+	t.Cleanup(func() {
+		scripts.mu.Lock()
+		scripts.bySHA = make(map[string]*cachedScript)
+		scripts.mu.Unlock()
+		flushFunctions()
+		busyReplyThreshold.Store(int64(SCRIPT_BUSY_REPLY_THRESHOLD_DEFAULT))
+	})
+}
+
+func TestEval(t *testing.T) {
+	resetScripting(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("EVAL", "return {KEYS[1], ARGV[1], 3, 3.9, true, false, nil, 'after nil'}", "1", "script:k", "arg")
+	c.expect("*6\r\n$8\r\nscript:k\r\n$3\r\narg\r\n:3\r\n:3\r\n:1\r\n$-1\r\n")
+	c.send("EVAL", "return redis.status_reply('FINE')", "0")
+	c.expect("+FINE\r\n")
+	c.send("EVAL", "return redis.error_reply('MY failure')", "0")
+	c.expect("-MY failure\r\n")
+
+	c.send("EVAL", "redis.call('SET', KEYS[1], ARGV[1]) return redis.call('GET', KEYS[1])", "1", "script:k", "v")
+	c.expect("$1\r\nv\r\n")
+	c.send("EVAL", "return redis.call('SET', KEYS[1], 'w')", "1", "script:k")
+	c.expect("+OK\r\n")
+	c.send("EVAL", "return redis.call('GET', 'script:missing')", "0")
+	c.expect("$-1\r\n")
+	c.send("EVAL", "return type(redis.call('GET', 'script:missing'))", "0")
+	c.expect("$7\r\nboolean\r\n")
+	c.send("EVAL", "redis.call('RPUSH', 'script:list', 'a', 'b') return redis.call('LRANGE', 'script:list', 0, -1)", "0")
+	c.expect("*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+
+	// redis.call raises the errors of the commands, redis.pcall returns them
+	c.send("EVAL", "return redis.call('LPUSH', KEYS[1], 'x')", "1", "script:k")
+	c.expect("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	c.send("EVAL", "local r = redis.pcall('LPUSH', KEYS[1], 'x') return r.err", "1", "script:k")
+	c.expect("$65\r\nWRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	c.send("EVAL", "return redis.call('INCRBY', KEYS[1], 5)", "1", "script:missing")
+	c.expect("-ERR no such key\r\n")
+	c.send("EVAL", "return redis.pcall('INCRBY', KEYS[1], 'x')", "1", "script:k")
+	c.expect("-ERR value is not an integer or out of range\r\n")
+	c.send("EVAL", "local r = redis.pcall('INCRBY', KEYS[1], 'x') return {type(r), r.err}", "1", "script:k")
+	c.expect("*2\r\n$5\r\ntable\r\n$43\r\nERR value is not an integer or out of range\r\n")
+	c.send("EVAL", "local r = redis.pcall('COPY', KEYS[1], KEYS[1]) return r.err", "1", "script:k")
+	c.expect("$47\r\nERR source and destination objects are the same\r\n")
+	c.send("EVAL", "return redis.pcall('NOSUCH')", "0")
+	c.expect("-ERR Unknown Redis command called from script\r\n")
+	c.send("EVAL", "return redis.call('GET')", "0")
+	c.expect("-ERR Wrong number of args calling Redis command from script\r\n")
+	c.send("EVAL", "return redis.call('MULTI')", "0")
+	c.expect("-ERR This Redis command is not allowed from script\r\n")
+	c.send("EVAL", "return redis.call()", "0")
+	c.expect("-ERR Please specify at least one argument for this redis lib call\r\n")
+	c.send("EVAL", "return redis.call('GET', {})", "0")
+	c.expect("-ERR Lua redis lib command arguments must be strings or integers\r\n")
+
+	c.send("EVAL", "\nreturn nosuch", "0")
+	c.expect("-ERR user_script:2: Script attempted to access nonexistent global variable 'nosuch' script: " + sha1hex("\nreturn nosuch") + ", on @user_script:2.\r\n")
+	c.send("EVAL", "x = 1", "0")
+	c.expect("-ERR user_script:1: Attempt to modify a readonly table script: " + sha1hex("x = 1") + ", on @user_script:1.\r\n")
+	c.send("EVAL", "return (", "0")
+	c.expect("-ERR Error compiling script (new function): user_script:1: unexpected symbol near '<eof>'\r\n")
+	c.send("EVAL", "return 1", "2", "a")
+	c.expect("-ERR Number of keys can't be greater than number of args\r\n")
+	c.send("EVAL", "return 1", "-1")
+	c.expect("-ERR Number of keys can't be negative\r\n")
+
+	// read-only scripts
+	c.send("EVAL_RO", "return redis.call('SET', 'script:k', 'x')", "0")
+	c.expect("-ERR Write commands are not allowed from read-only scripts.\r\n")
+	c.send("EVAL", "#!lua flags=no-writes\nreturn redis.call('DEL', 'script:k')", "0")
+	c.expect("-ERR Write commands are not allowed from read-only scripts.\r\n")
+	c.send("EVAL", "#!lua flags=nosuch\nreturn 1", "0")
+	c.expect("-ERR Unexpected flag in script shebang: nosuch\r\n")
+	c.send("EVAL", "#!lua\nreturn redis.call('GET', 'script:k')", "0")
+	c.expect("$1\r\nw\r\n")
+}
+
+func TestScriptCache(t *testing.T) {
+	resetScripting(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	body := "return ARGV[1] .. '!'"
+	sha := sha1hex(body)
+	c.send("EVALSHA", sha, "0", "hi")
+	c.expect("-NOSCRIPT No matching script. Please use EVAL.\r\n")
+	c.send("SCRIPT", "LOAD", body)
+	c.expect("$40\r\n" + sha + "\r\n")
+	c.send("EVALSHA", strings.ToUpper(sha), "0", "hi")
+	c.expect("$3\r\nhi!\r\n")
+	c.send("SCRIPT", "EXISTS", sha, "0000")
+	c.expect("*2\r\n:1\r\n:0\r\n")
+	c.send("SCRIPT", "FLUSH", "ASYNC")
+	c.expect("+OK\r\n")
+	c.send("SCRIPT", "EXISTS", sha)
+	c.expect("*1\r\n:0\r\n")
+
+	// EVAL caches the scripts too
+	c.send("EVAL", body, "0", "a")
+	c.expect("$2\r\na!\r\n")
+	c.send("EVALSHA_RO", sha, "0", "b")
+	c.expect("$2\r\nb!\r\n")
+
+	c.send("SCRIPT", "KILL")
+	c.expect("-NOTBUSY No scripts in execution right now.\r\n")
+	c.send("SCRIPT", "NOSUCH")
+	c.expect("-ERR unknown subcommand or wrong number of arguments for 'nosuch'. Try SCRIPT HELP.\r\n")
+}
+
+func TestFunctions(t *testing.T) {
+	resetScripting(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	lib := "#!lua name=mylib\n" +
+		"redis.register_function('hello', function(keys, args) return 'hello ' .. args[1] end)\n" +
+		"redis.register_function{function_name = 'getkey', callback = function(keys) return redis.call('GET', keys[1]) end, flags = {'no-writes'}, description = 'reads a key'}\n" +
+		"redis.register_function('setkey', function(keys, args) return redis.call('SET', keys[1], args[1]) end)"
+	c.send("FUNCTION", "LOAD", lib)
+	c.expect("$5\r\nmylib\r\n")
+	c.send("FUNCTION", "LOAD", lib)
+	c.expect("-ERR Library 'mylib' already exists\r\n")
+	c.send("FUNCTION", "LOAD", "REPLACE", lib)
+	c.expect("$5\r\nmylib\r\n")
+
+	c.send("FCALL", "hello", "0", "world")
+	c.expect("$11\r\nhello world\r\n")
+	c.send("FCALL", "setkey", "1", "function:k", "v")
+	c.expect("+OK\r\n")
+	c.send("FCALL_RO", "getkey", "1", "function:k")
+	c.expect("$1\r\nv\r\n")
+	c.send("FCALL_RO", "setkey", "1", "function:k", "v")
+	c.expect("-ERR Can not execute a script with write flag using *_ro command.\r\n")
+	c.send("FCALL", "nosuch", "0")
+	c.expect("-ERR Function not found\r\n")
+
+	c.send("FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('hello', function() end)")
+	c.expect("-ERR Function hello already exists\r\n")
+	c.send("FUNCTION", "LOAD", "#!lua name=empty\nlocal x = 1")
+	c.expect("-ERR No functions registered\r\n")
+	c.send("FUNCTION", "LOAD", "return 1")
+	c.expect("-ERR Missing library metadata\r\n")
+	c.send("FUNCTION", "LOAD", "#!js name=x\n")
+	c.expect("-ERR Engine 'js' not found\r\n")
+	c.send("FUNCTION", "LOAD", "#!lua name=bad-name\n")
+	c.expect("-ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long\r\n")
+	c.send("FUNCTION", "LOAD", "#!lua name=loop\nwhile true do end")
+	c.expect("-ERR Error registering functions: FUNCTION LOAD timeout\r\n")
+	// redis.call is not available while loading
+	c.send("FUNCTION", "LOAD", "#!lua name=calls\nredis.call('GET', 'x')")
+	c.expect("-ERR Error registering functions: user_function:2: attempt to call field 'call' (a nil value)\r\n")
+
+	c.send("FUNCTION", "LIST", "LIBRARYNAME", "my*")
+	c.expect("*1\r\n*6\r\n$12\r\nlibrary_name\r\n$5\r\nmylib\r\n$6\r\nengine\r\n$3\r\nLUA\r\n$9\r\nfunctions\r\n*3\r\n" +
+		"*6\r\n$4\r\nname\r\n$5\r\nhello\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*0\r\n" +
+		"*6\r\n$4\r\nname\r\n$6\r\ngetkey\r\n$11\r\ndescription\r\n$11\r\nreads a key\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n" +
+		"*6\r\n$4\r\nname\r\n$6\r\nsetkey\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*0\r\n")
+	c.send("FUNCTION", "LIST", "LIBRARYNAME", "nosuch*")
+	c.expect("*0\r\n")
+
+	c.send("FUNCTION", "DELETE", "mylib")
+	c.expect("+OK\r\n")
+	c.send("FUNCTION", "DELETE", "mylib")
+	c.expect("-ERR Library not found\r\n")
+	c.send("FCALL", "hello", "0")
+	c.expect("-ERR Function not found\r\n")
+	c.send("FUNCTION", "KILL")
+	c.expect("-NOTBUSY No scripts in execution right now.\r\n")
+}
+
+func TestScriptKill(t *testing.T) {
+	resetScripting(t)
+	busyReplyThreshold.Store(int64(100 * time.Millisecond))
+	addr := startServer(t)
+	c := dial(t, addr)
+	other := dial(t, addr)
+
+	c.send("EVAL", "while true do end", "0")
+	time.Sleep(50 * time.Millisecond)
+	// before the threshold the other clients wait, then they're refused
+	start := time.Now()
+	other.send("PING")
+	other.expect("-BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.\r\n")
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("Expected PING to wait for the threshold, refused after %v", elapsed)
+	}
+	other.send("FUNCTION", "KILL")
+	other.expect("-NOTBUSY No scripts in execution right now.\r\n")
+	other.send("SCRIPT", "KILL")
+	other.expect("+OK\r\n")
+	c.expect("-ERR Script killed by user with SCRIPT KILL...\r\n")
+	other.send("PING")
+	other.expect("+PONG\r\n")
+
+	// once it wrote it can't be killed anymore
+	c.send("EVAL", "redis.call('SET', 'script:kill', '1') local n = 0 while n < 1e6 do n = n + 1 end return n", "0")
+	time.Sleep(50 * time.Millisecond)
+	other.send("SCRIPT", "KILL")
+	other.expect("-UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.\r\n")
+	c.expect(":1000000\r\n")
+}
+
+func TestScriptEffects(t *testing.T) {
+	resetACL(t)
+	resetReplication(t)
+	resetScripting(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.send("FUNCTION", "LOAD", "#!lua name=effects\nredis.register_function('incr2', function(keys) redis.call('INCRBY', keys[1], 1) return redis.call('INCRBY', keys[1], 1) end)")
+	c.expect("$7\r\neffects\r\n")
+
+	r := dial(t, addr)
+	r.send("PSYNC", "?", "-1")
+	r.readLine()
+	if !strings.Contains(r.readSnapshot(), "effects") {
+		t.Error("Expected the snapshot to hold the library")
+	}
+
+	// the writes are streamed rather than the script
+	c.send("EVAL", "redis.call('SET', KEYS[1], 'a') redis.call('GET', KEYS[1]) redis.call('DEL', KEYS[1]) return 1", "1", "script:effects")
+	c.expect(":1\r\n")
+	r.expectCommand("MULTI")
+	r.expectCommand("SET", "script:effects", "a")
+	r.expectCommand("DEL", "script:effects")
+	r.expectCommand("EXEC")
+
+	c.send("EVAL", "redis.call('SET', KEYS[1], 'b') return 1", "1", "script:effects")
+	c.expect(":1\r\n")
+	r.expectCommand("SET", "script:effects", "b")
+	c.send("EVAL", "return redis.call('GET', KEYS[1])", "1", "script:effects")
+	c.expect("$1\r\nb\r\n")
+	c.send("SET", "script:counter", "0")
+	c.expect("+OK\r\n")
+	r.expectCommand("SET", "script:counter", "0")
+	c.send("FCALL", "incr2", "1", "script:counter")
+	c.expect(":2\r\n")
+	r.expectCommand("MULTI")
+	r.expectCommand("INCRBY", "script:counter", "1")
+	r.expectCommand("INCRBY", "script:counter", "1")
+	r.expectCommand("EXEC")
+	c.send("FUNCTION", "DELETE", "effects")
+	c.expect("+OK\r\n")
+	r.expect(aof.EncodeCommand(&parser.Command{Name: "FUNCTION", Args: []string{"DELETE", "effects"}}))
+}
+
+func TestScriptPermissions(t *testing.T) {
+	resetACL(t)
+	resetScripting(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.send("ACL", "SETUSER", "scripter", "on", ">pass", "~*", "+@scripting", "+get")
+	c.expect("+OK\r\n")
+	c.send("AUTH", "scripter", "pass")
+	c.expect("+OK\r\n")
+	c.send("EVAL", "return redis.call('GET', 'script:acl')", "0")
+	c.expect("$-1\r\n")
+	c.send("EVAL", "return redis.call('SET', 'script:acl', 'x')", "0")
+	c.expect("-NOPERM User scripter has no permissions to run the 'set' command\r\n")
+}
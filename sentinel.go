@@ -0,0 +1,1333 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+// Flags of the instances a sentinel knows about
+const (
+	SRI_MASTER               = 1 << iota
+	SRI_SLAVE                // a replica of a monitored master
+	SRI_SENTINEL             // another sentinel monitoring the master
+	SRI_S_DOWN               // subjectively down: no valid reply for down-after
+	SRI_O_DOWN               // objectively down: a quorum of sentinels agree
+	SRI_MASTER_DOWN          // a sentinel that told us the master is down
+	SRI_FAILOVER_IN_PROGRESS // a master being failed over
+	SRI_PROMOTED             // the replica chosen to become the master
+	SRI_RECONF_SENT          // a replica told to replicate the promoted one
+	SRI_RECONF_INPROG        // ... that is synchronizing with it
+	SRI_RECONF_DONE          // ... that is done
+	SRI_FORCE_FAILOVER       // failed over by SENTINEL FAILOVER, without agreement
+)
+
+// States of a failover
+const (
+	SENTINEL_FAILOVER_STATE_NONE               = iota
+	SENTINEL_FAILOVER_STATE_WAIT_START         // waiting to be elected leader
+	SENTINEL_FAILOVER_STATE_SELECT_SLAVE       // choosing the replica to promote
+	SENTINEL_FAILOVER_STATE_SEND_SLAVEOF_NOONE // promoting it
+	SENTINEL_FAILOVER_STATE_WAIT_PROMOTION     // waiting for it to report it is a master
+	SENTINEL_FAILOVER_STATE_RECONF_SLAVES      // moving the other replicas to it
+	SENTINEL_FAILOVER_STATE_UPDATE_CONFIG      // done, it is the master from now on
+)
+
+const (
+	SENTINEL_PING_PERIOD              = time.Second
+	SENTINEL_INFO_PERIOD              = 10 * time.Second
+	SENTINEL_HELLO_PERIOD             = 2 * time.Second
+	SENTINEL_ASK_PERIOD               = time.Second
+	SENTINEL_MAX_DESYNC               = time.Second // spreads the failover attempts of the sentinels
+	SENTINEL_ELECTION_TIMEOUT         = 10 * time.Second
+	SENTINEL_SLAVE_RECONF_TIMEOUT     = 10 * time.Second
+	SENTINEL_MAX_PENDING_COMMANDS     = 100
+	SENTINEL_DOWN_AFTER_DEFAULT       = 30 * time.Second
+	SENTINEL_FAILOVER_TIMEOUT_DEFAULT = 3 * time.Minute
+	SENTINEL_HELLO_CHANNEL            = "__sentinel__:hello"
+)
+
+/*
+sentinel holds the state of a server run with -sentinel.
+
+A sentinel monitors masters and their replicas: it pings them every second,
+reads INFO replication every 10 seconds to learn the replicas of a master
+and their offsets, and every 2 seconds publishes a hello message on
+SENTINEL_HELLO_CHANNEL of each of them, so the sentinels monitoring the same
+master discover each other.
+
+A master not replying for down-after is subjectively down (S_DOWN) for the
+sentinel, which then asks the others with SENTINEL IS-MASTER-DOWN-BY-ADDR.
+When a quorum of sentinels agree the master is objectively down (O_DOWN)
+and a failover starts in a new epoch: the sentinel asks the others to vote
+for it, and the one getting a majority of the votes promotes the replica
+with the largest offset, moves the other replicas to it and announces the
+new master, with the epoch as its configuration epoch, in its hello
+messages. The other sentinels switch to the master announced with the
+largest configuration epoch.
+
+The state is only changed with mu held, the commands sent to the instances
+run in the background and handle their reply with mu held.
+*/
+var sentinel = struct {
+	mu              sync.Mutex
+	enabled         bool
+	myid            string
+	currentEpoch    int64
+	masters         map[string]*sentinelInstance
+	downAfter       time.Duration // of the masters monitored from now on
+	failoverTimeout time.Duration
+}{
+	masters:         make(map[string]*sentinelInstance),
+	downAfter:       SENTINEL_DOWN_AFTER_DEFAULT,
+	failoverTimeout: SENTINEL_FAILOVER_TIMEOUT_DEFAULT,
+}
+
+// sentinelInstance is a master, a replica or a sentinel
+type sentinelInstance struct {
+	flags  int
+	name   string // of a master, host:port for the others
+	host   string
+	port   int
+	runid  string            // of a sentinel
+	master *sentinelInstance // of a replica or a sentinel
+	link   *instanceLink
+	hello  chan struct{} // closed to stop receiving the hello messages
+
+	lastAvailable time.Time // last valid reply to a PING
+	pingSent      time.Time // of the oldest PING not replied yet, zero if none
+	lastPingSent  time.Time
+	lastHelloSent time.Time
+	infoRefresh   time.Time
+	infoPending   bool
+	sDownSince    time.Time
+	oDownSince    time.Time
+	role          string // as reported by INFO
+	roleReported  time.Time
+
+	// replicas
+	masterHost   string
+	masterPort   int
+	masterLinkUp bool
+	replOffset   int64
+	reconfSent   time.Time
+
+	// masters
+	quorum              int
+	downAfter           time.Duration
+	failoverTimeout     time.Duration
+	replicas            map[string]*sentinelInstance // by host:port
+	sentinels           map[string]*sentinelInstance // by run ID
+	configEpoch         int64
+	leader              string // the vote of this sentinel, or the last one of a sentinel
+	leaderEpoch         int64
+	failoverState       int
+	failoverEpoch       int64
+	failoverStart       time.Time
+	failoverStateChange time.Time
+	promoted            *sentinelInstance
+
+	// sentinels
+	lastHello           time.Time
+	lastMasterDownAsk   time.Time
+	lastMasterDownReply time.Time
+}
+
+// instanceLink is the connection to an instance, commands are sent on it
+// one at a time and it reconnects on the next command after an error
+type instanceLink struct {
+	mu        sync.Mutex
+	conn      net.Conn
+	reader    *bufio.Reader
+	localIP   atomic.Value // the address others reach this sentinel at
+	pending   atomic.Int32
+	connected atomic.Bool
+	closed    atomic.Bool
+}
+
+// newSentinelInstance starts to monitor an instance at host:port
+func newSentinelInstance(flags int, host string, port int, master *sentinelInstance) *sentinelInstance {
+	ri := &sentinelInstance{
+		flags:         flags,
+		host:          host,
+		port:          port,
+		master:        master,
+		lastAvailable: time.Now(),
+	}
+	ri.name = ri.addr()
+	if flags&SRI_MASTER != 0 {
+		ri.replicas = make(map[string]*sentinelInstance)
+		ri.sentinels = make(map[string]*sentinelInstance)
+	}
+	ri.connect()
+	return ri
+}
+
+func (ri *sentinelInstance) addr() string {
+	return net.JoinHostPort(ri.host, strconv.Itoa(ri.port))
+}
+
+// connect opens a new link to the instance, and subscribes to the hello
+// channel of the masters and replicas
+func (ri *sentinelInstance) connect() {
+	ri.link = &instanceLink{}
+	if ri.flags&(SRI_MASTER|SRI_SLAVE) != 0 {
+		ri.hello = make(chan struct{})
+		go receiveHello(ri.addr(), ri.hello)
+	}
+}
+
+// drop stops monitoring the instance
+func (ri *sentinelInstance) drop() {
+	ri.link.close()
+	if ri.hello != nil {
+		close(ri.hello)
+		ri.hello = nil
+	}
+}
+
+// masterOf returns the master ri belongs to, ri itself for a master
+func (ri *sentinelInstance) masterOf() *sentinelInstance {
+	if ri.flags&SRI_MASTER != 0 {
+		return ri
+	}
+	return ri.master
+}
+
+// timeout bounds the commands sent to the instance
+func (ri *sentinelInstance) timeout() time.Duration {
+	return min(max(ri.masterOf().downAfter, 100*time.Millisecond), 5*time.Second)
+}
+
+// currentAddr is the address of the master, the promoted replica's once
+// the failover reconfigures the replicas
+func (master *sentinelInstance) currentAddr() (string, int) {
+	if master.flags&SRI_FAILOVER_IN_PROGRESS != 0 && master.promoted != nil && master.failoverState >= SENTINEL_FAILOVER_STATE_RECONF_SLAVES {
+		return master.promoted.host, master.promoted.port
+	}
+	return master.host, master.port
+}
+
+// close stops the link, the replies of the commands pending are dropped
+func (l *instanceLink) close() {
+	l.closed.Store(true)
+	go func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.conn != nil {
+			l.conn.Close()
+			l.conn = nil
+		}
+	}()
+}
+
+// command sends a command to the instance at addr and reads its reply
+func (l *instanceLink) command(addr string, timeout time.Duration, words ...string) (*parser.Value, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed.Load() {
+		return nil, net.ErrClosed
+	}
+	if l.conn == nil {
+		conn, reader, err := sentinelDial(addr, timeout)
+		if err != nil {
+			l.connected.Store(false)
+			return nil, err
+		}
+		l.conn, l.reader = conn, reader
+		if host, _, err := net.SplitHostPort(conn.LocalAddr().String()); err == nil {
+			l.localIP.Store(host)
+		}
+		l.connected.Store(true)
+	}
+	v, err := roundTrip(l.conn, l.reader, timeout, words...)
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		l.connected.Store(false)
+	}
+	return v, err
+}
+
+// sentinelDial connects to an instance, authenticating with masterauth
+func sentinelDial(addr string, timeout time.Duration) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	repl.mu.Lock()
+	user, password := repl.masterUser, repl.masterAuth
+	repl.mu.Unlock()
+	if password != "" {
+		words := []string{"AUTH", password}
+		if user != "" {
+			words = []string{"AUTH", user, password}
+		}
+		v, err := roundTrip(conn, reader, timeout, words...)
+		if err == nil && v.Type == parser.SimpleError {
+			err = fmt.Errorf("AUTH failed: %s", v.Str)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, reader, nil
+}
+
+// roundTrip sends a command and reads its reply
+func roundTrip(conn net.Conn, reader *bufio.Reader, timeout time.Duration, words ...string) (*parser.Value, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := io.WriteString(conn, aof.EncodeCommand(&parser.Command{Name: words[0], Args: words[1:]})); err != nil {
+		return nil, err
+	}
+	return parser.ReadValue(reader)
+}
+
+// send runs a command on the instance in the background, handle gets the
+// reply with sentinel.mu held unless the instance was dropped meanwhile
+func (ri *sentinelInstance) send(handle func(*parser.Value, error), words ...string) bool {
+	link := ri.link
+	if link.pending.Load() >= SENTINEL_MAX_PENDING_COMMANDS {
+		return false
+	}
+	link.pending.Add(1)
+	addr, timeout := ri.addr(), ri.timeout()
+	go func() {
+		v, err := link.command(addr, timeout, words...)
+		link.pending.Add(-1)
+		if handle == nil {
+			return
+		}
+		sentinel.mu.Lock()
+		defer sentinel.mu.Unlock()
+		if !link.closed.Load() {
+			handle(v, err)
+		}
+	}()
+	return true
+}
+
+// receiveHello processes the hello messages published on the instance at
+// addr until stop is closed
+func receiveHello(addr string, stop chan struct{}) {
+	for {
+		// an instance that is down is reported by the PINGs
+		receiveHelloOnce(addr, stop)
+		select {
+		case <-stop:
+			return
+		case <-time.After(SENTINEL_PING_PERIOD):
+		}
+	}
+}
+
+func receiveHelloOnce(addr string, stop chan struct{}) error {
+	conn, reader, err := sentinelDial(addr, SENTINEL_HELLO_PERIOD)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	if _, err := roundTrip(conn, reader, SENTINEL_HELLO_PERIOD, "SUBSCRIBE", SENTINEL_HELLO_CHANNEL); err != nil {
+		return err
+	}
+	for {
+		// this sentinel publishes every SENTINEL_HELLO_PERIOD itself
+		conn.SetReadDeadline(time.Now().Add(3 * SENTINEL_HELLO_PERIOD))
+		v, err := parser.ReadValue(reader)
+		if err != nil {
+			return err
+		}
+		if len(v.Elems) == 3 && v.Elems[0].Str == "message" {
+			sentinel.mu.Lock()
+			sentinelProcessHello(v.Elems[2].Str)
+			sentinel.mu.Unlock()
+		}
+	}
+}
+
+// sentinelProcessHello handles a hello message of a sentinel:
+// "ip,port,runid,current_epoch,master_name,master_ip,master_port,master_config_epoch"
+func sentinelProcessHello(payload string) {
+	parts := strings.Split(payload, ",")
+	if len(parts) != 8 || parts[2] == sentinel.myid {
+		return
+	}
+	port, err1 := strconv.Atoi(parts[1])
+	epoch, err2 := strconv.ParseInt(parts[3], 10, 64)
+	masterPort, err3 := strconv.Atoi(parts[6])
+	masterEpoch, err4 := strconv.ParseInt(parts[7], 10, 64)
+	master := sentinel.masters[parts[4]]
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || master == nil {
+		return
+	}
+
+	host, runid := parts[0], parts[2]
+	peer := master.sentinels[runid]
+	if peer == nil {
+		// a sentinel restarted with a new ID replaces the old one
+		for id, s := range master.sentinels {
+			if s.host == host && s.port == port {
+				s.drop()
+				delete(master.sentinels, id)
+				sentinelEvent("-dup-sentinel", s, "#duplicate of %s:%d or %s", host, port, runid)
+			}
+		}
+		peer = newSentinelInstance(SRI_SENTINEL, host, port, master)
+		peer.runid = runid
+		master.sentinels[runid] = peer
+		sentinelEvent("+sentinel", peer, "")
+	} else if peer.host != host || peer.port != port {
+		peer.drop()
+		peer.host, peer.port = host, port
+		peer.name = peer.addr()
+		peer.connect()
+	}
+	peer.lastHello = time.Now()
+
+	if epoch > sentinel.currentEpoch {
+		sentinel.currentEpoch = epoch
+		sentinelEvent("+new-epoch", nil, "%d", epoch)
+	}
+	if masterEpoch > master.configEpoch {
+		master.configEpoch = masterEpoch
+		if master.host != parts[5] || master.port != masterPort {
+			sentinelEvent("+config-update-from", peer, "")
+			sentinelEvent("+switch-master", nil, "%s %s %d %s %d", master.name, master.host, master.port, parts[5], masterPort)
+			sentinelResetMasterAndChangeAddress(master, parts[5], masterPort)
+		}
+	}
+}
+
+// sentinelEvent logs an event and publishes it on the channel named after
+// it, for the clients of the sentinel. The message describes ri, and its
+// master for the replicas and sentinels, followed by the formatted details.
+func sentinelEvent(kind string, ri *sentinelInstance, format string, args ...any) {
+	var msg string
+	if ri != nil {
+		kinds := map[int]string{SRI_MASTER: "master", SRI_SLAVE: "slave", SRI_SENTINEL: "sentinel"}
+		msg = fmt.Sprintf("%s %s %s %d", kinds[ri.flags&(SRI_MASTER|SRI_SLAVE|SRI_SENTINEL)], ri.name, ri.host, ri.port)
+		if ri.flags&SRI_MASTER == 0 {
+			msg += fmt.Sprintf(" @ %s %s %d", ri.master.name, ri.master.host, ri.master.port)
+		}
+	}
+	if format != "" {
+		if msg != "" {
+			msg += " "
+		}
+		msg += fmt.Sprintf(format, args...)
+	}
+	log.Printf("%s %s", kind, msg)
+	pubSub.Publish(kind, msg)
+}
+
+// initSentinel turns the server into a sentinel: it has no dataset and
+// only runs the commands below
+func initSentinel() {
+	table := map[string]*commandSpec{
+		"SENTINEL": {arity: -2, flags: CMD_ADMIN},
+	}
+	for _, name := range []string{"PING", "INFO", "AUTH", "HELLO", "CLIENT", "ACL", "QUIT", "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE"} {
+		table[name] = commandTable[name]
+	}
+	commandTable = table
+	infoSections = []infoSection{
+		{name: "server", write: writeServerInfo},
+		{name: "clients", write: writeClientsInfo},
+		{name: "stats", write: writeStatsInfo},
+		{name: "cpu", write: writeCPUInfo},
+		{name: "sentinel", write: writeSentinelInfo},
+	}
+	// allcommands of the default user means the commands of the table
+	acl.mu.Lock()
+	acl.users["default"] = newDefaultUser()
+	acl.mu.Unlock()
+
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	sentinel.enabled = true
+	sentinel.myid = newReplicationID()
+}
+
+// sentinelEnabled reports if the server runs as a sentinel
+func sentinelEnabled() bool {
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	return sentinel.enabled
+}
+
+// sentinelMonitor starts to monitor the master host:port under name
+func sentinelMonitor(name, host string, port, quorum int) error {
+	if _, ok := sentinel.masters[name]; ok {
+		return fmt.Errorf("ERR Duplicated master name")
+	}
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("ERR Invalid port")
+	}
+	if quorum <= 0 {
+		return fmt.Errorf("ERR Quorum must be 1 or greater.")
+	}
+	master := newSentinelInstance(SRI_MASTER, host, port, nil)
+	master.name = name
+	master.quorum = quorum
+	master.downAfter, master.failoverTimeout = sentinel.downAfter, sentinel.failoverTimeout
+	sentinel.masters[name] = master
+	sentinelEvent("+monitor", master, "quorum %d", quorum)
+	return nil
+}
+
+// sentinelRemove stops monitoring a master, its replicas and sentinels
+func sentinelRemove(master *sentinelInstance) {
+	for _, r := range master.replicas {
+		r.drop()
+	}
+	for _, s := range master.sentinels {
+		s.drop()
+	}
+	master.drop()
+	delete(sentinel.masters, master.name)
+	sentinelEvent("-monitor", master, "")
+}
+
+// sentinelTimer runs the monitoring and the failovers, 10 times per second
+func sentinelTimer() {
+	for range time.Tick(100 * time.Millisecond) {
+		sentinel.mu.Lock()
+		now := time.Now()
+		for _, master := range sentinel.masters {
+			sentinelHandleInstance(master, now)
+			for _, r := range master.replicas {
+				sentinelHandleInstance(r, now)
+			}
+			for _, s := range master.sentinels {
+				sentinelHandleInstance(s, now)
+			}
+			if master.failoverState == SENTINEL_FAILOVER_STATE_UPDATE_CONFIG {
+				sentinelFailoverSwitchToPromotedSlave(master)
+			}
+		}
+		sentinel.mu.Unlock()
+	}
+}
+
+func sentinelHandleInstance(ri *sentinelInstance, now time.Time) {
+	sentinelSendPeriodicCommands(ri, now)
+	sentinelCheckSubjectivelyDown(ri, now)
+	if ri.flags&SRI_MASTER != 0 {
+		sentinelCheckObjectivelyDown(ri, now)
+		if sentinelStartFailoverIfNeeded(ri, now) {
+			sentinelAskMasterStateToOtherSentinels(ri, now, true)
+		}
+		sentinelFailoverStateMachine(ri, now)
+		sentinelAskMasterStateToOtherSentinels(ri, now, false)
+	}
+}
+
+// sentinelSendPeriodicCommands pings the instance, and refreshes the INFO
+// and sends a hello message to the masters and replicas
+func sentinelSendPeriodicCommands(ri *sentinelInstance, now time.Time) {
+	infoPeriod := SENTINEL_INFO_PERIOD
+	if ri.flags&SRI_SLAVE != 0 && ri.master.flags&(SRI_O_DOWN|SRI_FAILOVER_IN_PROGRESS) != 0 {
+		infoPeriod = time.Second // following the failover closely
+	}
+	if ri.flags&SRI_SENTINEL == 0 && !ri.infoPending && now.Sub(ri.infoRefresh) >= infoPeriod {
+		ri.infoPending = ri.send(func(v *parser.Value, err error) {
+			ri.infoPending = false
+			if err == nil && (v.Type == parser.BulkString || v.Type == parser.Verbatim) {
+				sentinelRefreshInstanceInfo(ri, v.Str)
+			}
+		}, "INFO", "replication")
+	}
+
+	if now.Sub(ri.lastPingSent) >= min(SENTINEL_PING_PERIOD, ri.masterOf().downAfter) {
+		sent := ri.send(func(v *parser.Value, err error) {
+			if err != nil {
+				return
+			}
+			if (v.Type == parser.SimpleString && v.Str == "PONG") ||
+				(v.Type == parser.SimpleError && (strings.HasPrefix(v.Str, "LOADING") || strings.HasPrefix(v.Str, "MASTERDOWN"))) {
+				ri.lastAvailable = time.Now()
+				ri.pingSent = time.Time{}
+			}
+		}, "PING")
+		if sent {
+			ri.lastPingSent = now
+			if ri.pingSent.IsZero() {
+				ri.pingSent = now
+			}
+		}
+	}
+
+	if ri.flags&SRI_SENTINEL == 0 && now.Sub(ri.lastHelloSent) >= SENTINEL_HELLO_PERIOD {
+		sentinelSendHello(ri, now)
+	}
+}
+
+// sentinelSendHello publishes this sentinel and its view of the master on
+// the hello channel of the instance
+func sentinelSendHello(ri *sentinelInstance, now time.Time) {
+	ip, _ := ri.link.localIP.Load().(string)
+	if ip == "" {
+		return // not connected yet
+	}
+	master := ri.masterOf()
+	host, port := master.currentAddr()
+	payload := fmt.Sprintf("%s,%d,%s,%d,%s,%s,%d,%d", ip, listeningPort, sentinel.myid, sentinel.currentEpoch,
+		master.name, host, port, master.configEpoch)
+	if ri.send(nil, "PUBLISH", SENTINEL_HELLO_CHANNEL, payload) {
+		ri.lastHelloSent = now
+	}
+}
+
+// sentinelRefreshInstanceInfo updates what is known of an instance from
+// its INFO replication, and acts when its role isn't the expected one
+func sentinelRefreshInstanceInfo(ri *sentinelInstance, info string) {
+	now := time.Now()
+	ri.infoRefresh = now
+	role := ""
+	var replicas []string
+	for _, line := range strings.Split(info, "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "role":
+			role = value
+		case "master_host":
+			ri.masterHost = value
+		case "master_port":
+			ri.masterPort, _ = strconv.Atoi(value)
+		case "master_link_status":
+			ri.masterLinkUp = value == "up"
+		case "slave_repl_offset":
+			ri.replOffset, _ = strconv.ParseInt(value, 10, 64)
+		default:
+			// slave0:ip=127.0.0.1,port=6380,state=online,offset=0,lag=0
+			if _, err := strconv.Atoi(strings.TrimPrefix(key, "slave")); err != nil || !strings.HasPrefix(key, "slave") {
+				continue
+			}
+			var ip, port string
+			for _, field := range strings.Split(value, ",") {
+				if v, ok := strings.CutPrefix(field, "ip="); ok {
+					ip = v
+				} else if v, ok := strings.CutPrefix(field, "port="); ok {
+					port = v
+				}
+			}
+			if ip != "" && port != "" && port != "0" {
+				replicas = append(replicas, net.JoinHostPort(ip, port))
+			}
+		}
+	}
+	if role != ri.role {
+		ri.role, ri.roleReported = role, now
+	}
+
+	if ri.flags&SRI_MASTER != 0 {
+		if role == "master" {
+			for _, addr := range replicas {
+				if _, ok := ri.replicas[addr]; ok {
+					continue
+				}
+				host, port, _ := net.SplitHostPort(addr)
+				portNumber, _ := strconv.Atoi(port)
+				r := newSentinelInstance(SRI_SLAVE, host, portNumber, ri)
+				ri.replicas[addr] = r
+				sentinelEvent("+slave", r, "")
+			}
+		}
+		return
+	}
+	if ri.flags&SRI_SLAVE == 0 {
+		return
+	}
+
+	master := ri.master
+	// a replica that was wrongly configured for a while is fixed, unless
+	// the master can't be trusted to be the master
+	settled := master.failoverState == SENTINEL_FAILOVER_STATE_NONE && master.flags&SRI_S_DOWN == 0 &&
+		master.role == "master" && now.Sub(ri.roleReported) > 4*SENTINEL_HELLO_PERIOD
+	if role == "master" {
+		if ri.flags&SRI_PROMOTED != 0 && master.failoverState == SENTINEL_FAILOVER_STATE_WAIT_PROMOTION {
+			master.configEpoch = master.failoverEpoch
+			master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_RECONF_SLAVES, now
+			sentinelEvent("+promoted-slave", ri, "")
+			sentinelEvent("+failover-state-reconf-slaves", master, "")
+		} else if settled {
+			sentinelEvent("+convert-to-slave", ri, "")
+			ri.send(nil, "REPLICAOF", master.host, strconv.Itoa(master.port))
+		}
+		return
+	}
+	if settled && (ri.masterHost != master.host || ri.masterPort != master.port) {
+		sentinelEvent("+fix-slave-config", ri, "")
+		ri.send(nil, "REPLICAOF", master.host, strconv.Itoa(master.port))
+		return
+	}
+
+	promoted := master.promoted
+	if master.failoverState == SENTINEL_FAILOVER_STATE_RECONF_SLAVES && promoted != nil &&
+		ri.masterHost == promoted.host && ri.masterPort == promoted.port {
+		if ri.flags&SRI_RECONF_SENT != 0 {
+			ri.flags = ri.flags&^SRI_RECONF_SENT | SRI_RECONF_INPROG
+			sentinelEvent("+slave-reconf-inprog", ri, "")
+		}
+		if ri.flags&SRI_RECONF_INPROG != 0 && ri.masterLinkUp {
+			ri.flags = ri.flags&^SRI_RECONF_INPROG | SRI_RECONF_DONE
+			sentinelEvent("+slave-reconf-done", ri, "")
+		}
+	}
+}
+
+// sentinelCheckSubjectivelyDown flags the instance as down when it didn't
+// reply to a PING for down-after
+func sentinelCheckSubjectivelyDown(ri *sentinelInstance, now time.Time) {
+	var elapsed time.Duration
+	if !ri.pingSent.IsZero() {
+		elapsed = now.Sub(ri.pingSent)
+	}
+	if !ri.link.connected.Load() {
+		elapsed = now.Sub(ri.lastAvailable)
+	}
+	if elapsed > ri.masterOf().downAfter {
+		if ri.flags&SRI_S_DOWN == 0 {
+			ri.flags |= SRI_S_DOWN
+			ri.sDownSince = now
+			sentinelEvent("+sdown", ri, "")
+		}
+	} else if ri.flags&SRI_S_DOWN != 0 {
+		ri.flags &^= SRI_S_DOWN
+		sentinelEvent("-sdown", ri, "")
+	}
+}
+
+// sentinelCheckObjectivelyDown flags the master as down when a quorum of
+// sentinels, this one included, think it is
+func sentinelCheckObjectivelyDown(master *sentinelInstance, now time.Time) {
+	votes := 0
+	if master.flags&SRI_S_DOWN != 0 {
+		votes = 1
+		for _, s := range master.sentinels {
+			if s.flags&SRI_MASTER_DOWN != 0 {
+				votes++
+			}
+		}
+	}
+	if votes > 0 && votes >= master.quorum {
+		if master.flags&SRI_O_DOWN == 0 {
+			master.flags |= SRI_O_DOWN
+			master.oDownSince = now
+			sentinelEvent("+odown", master, "#quorum %d/%d", votes, master.quorum)
+		}
+	} else if master.flags&SRI_O_DOWN != 0 {
+		master.flags &^= SRI_O_DOWN
+		sentinelEvent("-odown", master, "")
+	}
+}
+
+// sentinelAskMasterStateToOtherSentinels asks the sentinels if they think
+// the master is down, every SENTINEL_ASK_PERIOD or right away if forced.
+// During a failover the question is a request to vote for this sentinel.
+func sentinelAskMasterStateToOtherSentinels(master *sentinelInstance, now time.Time, forced bool) {
+	for _, s := range master.sentinels {
+		s := s
+		if now.Sub(s.lastMasterDownReply) > 5*SENTINEL_ASK_PERIOD {
+			s.flags &^= SRI_MASTER_DOWN
+			s.leader = ""
+		}
+		if master.flags&SRI_S_DOWN == 0 || (!forced && now.Sub(s.lastMasterDownAsk) < SENTINEL_ASK_PERIOD) {
+			continue
+		}
+		runid := "*"
+		if master.failoverState > SENTINEL_FAILOVER_STATE_NONE {
+			runid = sentinel.myid
+		}
+		s.lastMasterDownAsk = now
+		s.send(func(v *parser.Value, err error) {
+			if err != nil || v.Type != parser.Array || len(v.Elems) != 3 {
+				return
+			}
+			s.lastMasterDownReply = time.Now()
+			if v.Elems[0].Int == 1 {
+				s.flags |= SRI_MASTER_DOWN
+			} else {
+				s.flags &^= SRI_MASTER_DOWN
+			}
+			if leader := v.Elems[1].Str; leader != "*" {
+				s.leader, s.leaderEpoch = leader, v.Elems[2].Int
+			}
+		}, "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", master.host, strconv.Itoa(master.port),
+			strconv.FormatInt(sentinel.currentEpoch, 10), runid)
+	}
+}
+
+// sentinelVoteLeader votes for runid as the leader of the failover of
+// master in epoch, unless this sentinel already voted in that epoch. It
+// returns the vote of this sentinel.
+func sentinelVoteLeader(master *sentinelInstance, epoch int64, runid string) (string, int64) {
+	if epoch > sentinel.currentEpoch {
+		sentinel.currentEpoch = epoch
+		sentinelEvent("+new-epoch", nil, "%d", epoch)
+	}
+	if master.leaderEpoch < epoch && sentinel.currentEpoch <= epoch {
+		master.leader, master.leaderEpoch = runid, sentinel.currentEpoch
+		sentinelEvent("+vote-for-leader", nil, "%s %d", runid, epoch)
+		// leave the failover to the sentinel voted for
+		if runid != sentinel.myid {
+			master.failoverStart = time.Now().Add(time.Duration(rand.Int63n(int64(SENTINEL_MAX_DESYNC))))
+		}
+	}
+	return master.leader, master.leaderEpoch
+}
+
+// sentinelGetLeader returns the leader of the failover of master in epoch,
+// if any. This sentinel votes for the sentinel with the most votes, or for
+// itself. The leader needs the votes of a majority of the sentinels and at
+// least the quorum.
+func sentinelGetLeader(master *sentinelInstance, epoch int64) string {
+	votes := make(map[string]int)
+	for _, s := range master.sentinels {
+		if s.leader != "" && s.leaderEpoch == epoch {
+			votes[s.leader]++
+		}
+	}
+	winner := func() (string, int) {
+		var leader string
+		var most int
+		for runid, n := range votes {
+			if n > most || (n == most && runid < leader) {
+				leader, most = runid, n
+			}
+		}
+		return leader, most
+	}
+
+	candidate, _ := winner()
+	if candidate == "" {
+		candidate = sentinel.myid
+	}
+	if vote, voteEpoch := sentinelVoteLeader(master, epoch, candidate); voteEpoch == epoch {
+		votes[vote]++
+	}
+	leader, most := winner()
+	if voters := len(master.sentinels) + 1; most < voters/2+1 || most < master.quorum {
+		return ""
+	}
+	return leader
+}
+
+// sentinelStartFailoverIfNeeded starts a failover of a master that is
+// objectively down, unless one was tried recently
+func sentinelStartFailoverIfNeeded(master *sentinelInstance, now time.Time) bool {
+	if master.flags&SRI_O_DOWN == 0 || master.flags&SRI_FAILOVER_IN_PROGRESS != 0 {
+		return false
+	}
+	if now.Sub(master.failoverStart) < 2*master.failoverTimeout {
+		return false
+	}
+	sentinelStartFailover(master, now)
+	return true
+}
+
+func sentinelStartFailover(master *sentinelInstance, now time.Time) {
+	sentinel.currentEpoch++
+	master.failoverEpoch = sentinel.currentEpoch
+	master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_WAIT_START, now
+	master.flags |= SRI_FAILOVER_IN_PROGRESS
+	master.failoverStart = now.Add(time.Duration(rand.Int63n(int64(SENTINEL_MAX_DESYNC))))
+	sentinelEvent("+new-epoch", nil, "%d", sentinel.currentEpoch)
+	sentinelEvent("+try-failover", master, "")
+}
+
+// sentinelAbortFailover gives up a failover that didn't promote a replica yet
+func sentinelAbortFailover(master *sentinelInstance, now time.Time) {
+	master.flags &^= SRI_FAILOVER_IN_PROGRESS | SRI_FORCE_FAILOVER
+	master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_NONE, now
+	if master.promoted != nil {
+		master.promoted.flags &^= SRI_PROMOTED
+		master.promoted = nil
+	}
+}
+
+func sentinelFailoverStateMachine(master *sentinelInstance, now time.Time) {
+	if master.flags&SRI_FAILOVER_IN_PROGRESS == 0 {
+		return
+	}
+	switch master.failoverState {
+	case SENTINEL_FAILOVER_STATE_WAIT_START:
+		sentinelFailoverWaitStart(master, now)
+	case SENTINEL_FAILOVER_STATE_SELECT_SLAVE:
+		sentinelFailoverSelectSlave(master, now)
+	case SENTINEL_FAILOVER_STATE_SEND_SLAVEOF_NOONE:
+		sentinelFailoverSendSlaveOfNoOne(master, now)
+	case SENTINEL_FAILOVER_STATE_WAIT_PROMOTION:
+		// the INFO of the promoted replica moves the failover on
+		if now.Sub(master.failoverStateChange) > master.failoverTimeout {
+			sentinelEvent("-failover-abort-slave-timeout", master, "")
+			sentinelAbortFailover(master, now)
+		}
+	case SENTINEL_FAILOVER_STATE_RECONF_SLAVES:
+		sentinelFailoverReconfNextSlave(master, now)
+	}
+}
+
+// sentinelFailoverWaitStart goes on with the failover once this sentinel
+// is elected leader
+func sentinelFailoverWaitStart(master *sentinelInstance, now time.Time) {
+	leader := sentinelGetLeader(master, master.failoverEpoch)
+	if leader != sentinel.myid && master.flags&SRI_FORCE_FAILOVER == 0 {
+		if now.Sub(master.failoverStateChange) > min(SENTINEL_ELECTION_TIMEOUT, master.failoverTimeout) {
+			sentinelEvent("-failover-abort-not-elected", master, "")
+			sentinelAbortFailover(master, now)
+		}
+		return
+	}
+	sentinelEvent("+elected-leader", master, "")
+	master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_SELECT_SLAVE, now
+	sentinelEvent("+failover-state-select-slave", master, "")
+}
+
+// sentinelSelectSlave returns the replica to promote: among the ones that
+// are up and were heard of recently, the one with the largest offset
+func sentinelSelectSlave(master *sentinelInstance, now time.Time) *sentinelInstance {
+	infoValidity := 5 * SENTINEL_INFO_PERIOD
+	if master.flags&SRI_S_DOWN != 0 {
+		infoValidity = 5 * time.Second // refreshed every second
+	}
+	var best *sentinelInstance
+	for _, r := range master.replicas {
+		if r.flags&(SRI_S_DOWN|SRI_O_DOWN) != 0 || !r.link.connected.Load() || r.role != "slave" ||
+			now.Sub(r.lastAvailable) > 5*SENTINEL_PING_PERIOD || now.Sub(r.infoRefresh) > infoValidity {
+			continue
+		}
+		if best == nil || r.replOffset > best.replOffset || (r.replOffset == best.replOffset && r.name < best.name) {
+			best = r
+		}
+	}
+	return best
+}
+
+func sentinelFailoverSelectSlave(master *sentinelInstance, now time.Time) {
+	r := sentinelSelectSlave(master, now)
+	if r == nil {
+		sentinelEvent("-failover-abort-no-good-slave", master, "")
+		sentinelAbortFailover(master, now)
+		return
+	}
+	sentinelEvent("+selected-slave", r, "")
+	r.flags |= SRI_PROMOTED
+	master.promoted = r
+	master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_SEND_SLAVEOF_NOONE, now
+	sentinelEvent("+failover-state-send-slaveof-noone", r, "")
+}
+
+func sentinelFailoverSendSlaveOfNoOne(master *sentinelInstance, now time.Time) {
+	r := master.promoted
+	if !r.link.connected.Load() {
+		if now.Sub(master.failoverStateChange) > master.failoverTimeout {
+			sentinelEvent("-failover-abort-slave-timeout", r, "")
+			sentinelAbortFailover(master, now)
+		}
+		return
+	}
+	r.send(nil, "REPLICAOF", "NO", "ONE")
+	master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_WAIT_PROMOTION, now
+	sentinelEvent("+failover-state-wait-promotion", r, "")
+}
+
+// sentinelFailoverReconfNextSlave tells the replicas to replicate the
+// promoted one, the failover ends once they all do or after the timeout
+func sentinelFailoverReconfNextSlave(master *sentinelInstance, now time.Time) {
+	promoted := master.promoted
+	for _, r := range master.replicas {
+		if r == promoted || r.flags&SRI_RECONF_DONE != 0 {
+			continue
+		}
+		// one not moving on is considered done, it gets fixed later
+		if r.flags&SRI_RECONF_SENT != 0 && now.Sub(r.reconfSent) > SENTINEL_SLAVE_RECONF_TIMEOUT {
+			sentinelEvent("-slave-reconf-sent-timeout", r, "")
+			r.flags = r.flags&^SRI_RECONF_SENT | SRI_RECONF_DONE
+			continue
+		}
+		if r.flags&(SRI_RECONF_SENT|SRI_RECONF_INPROG|SRI_S_DOWN) != 0 || !r.link.connected.Load() {
+			continue
+		}
+		if r.send(nil, "REPLICAOF", promoted.host, strconv.Itoa(promoted.port)) {
+			r.flags |= SRI_RECONF_SENT
+			r.reconfSent = now
+			sentinelEvent("+slave-reconf-sent", r, "")
+		}
+	}
+
+	pending := 0
+	for _, r := range master.replicas {
+		if r != promoted && r.flags&(SRI_RECONF_DONE|SRI_S_DOWN) == 0 {
+			pending++
+		}
+	}
+	timedOut := now.Sub(master.failoverStateChange) > master.failoverTimeout
+	if timedOut {
+		sentinelEvent("+failover-end-for-timeout", master, "")
+	}
+	if pending == 0 || timedOut {
+		sentinelEvent("+failover-end", master, "")
+		master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_UPDATE_CONFIG, now
+	}
+}
+
+// sentinelFailoverSwitchToPromotedSlave makes the promoted replica the master
+func sentinelFailoverSwitchToPromotedSlave(master *sentinelInstance) {
+	r := master.promoted
+	sentinelEvent("+switch-master", nil, "%s %s %d %s %d", master.name, master.host, master.port, r.host, r.port)
+	sentinelResetMasterAndChangeAddress(master, r.host, r.port)
+}
+
+// sentinelResetMasterAndChangeAddress monitors the master at host:port
+// from now on. Its replicas are the ones of the old master, and the old
+// master itself, which is made a replica if it comes back.
+func sentinelResetMasterAndChangeAddress(master *sentinelInstance, host string, port int) {
+	newAddr := net.JoinHostPort(host, strconv.Itoa(port))
+	var addrs []string
+	for addr, r := range master.replicas {
+		if addr != newAddr {
+			addrs = append(addrs, addr)
+		}
+		r.drop()
+	}
+	if oldAddr := master.addr(); oldAddr != newAddr && !slices.Contains(addrs, oldAddr) {
+		addrs = append(addrs, oldAddr)
+	}
+
+	master.drop()
+	master.host, master.port = host, port
+	master.flags = SRI_MASTER
+	master.failoverState, master.failoverStateChange = SENTINEL_FAILOVER_STATE_NONE, time.Now()
+	master.promoted = nil
+	master.lastAvailable, master.pingSent, master.lastPingSent = time.Now(), time.Time{}, time.Time{}
+	master.infoRefresh, master.infoPending, master.lastHelloSent = time.Time{}, false, time.Time{}
+	master.role, master.roleReported = "", time.Time{}
+	master.connect()
+	master.replicas = make(map[string]*sentinelInstance)
+	for _, addr := range addrs {
+		h, p, _ := net.SplitHostPort(addr)
+		portNumber, _ := strconv.Atoi(p)
+		master.replicas[addr] = newSentinelInstance(SRI_SLAVE, h, portNumber, master)
+	}
+	for _, s := range master.sentinels {
+		s.flags &^= SRI_MASTER_DOWN
+	}
+}
+
+// sentinelFlags describes the flags of an instance, for SENTINEL MASTERS
+func sentinelFlags(ri *sentinelInstance) string {
+	names := []struct {
+		flag int
+		name string
+	}{
+		{SRI_MASTER, "master"}, {SRI_SLAVE, "slave"}, {SRI_SENTINEL, "sentinel"},
+		{SRI_S_DOWN, "s_down"}, {SRI_O_DOWN, "o_down"}, {SRI_MASTER_DOWN, "master_down"},
+		{SRI_FAILOVER_IN_PROGRESS, "failover_in_progress"}, {SRI_PROMOTED, "promoted"},
+		{SRI_RECONF_SENT, "reconf_sent"}, {SRI_RECONF_INPROG, "reconf_inprog"}, {SRI_RECONF_DONE, "reconf_done"},
+	}
+	var flags []string
+	for _, n := range names {
+		if ri.flags&n.flag != 0 {
+			flags = append(flags, n.name)
+		}
+	}
+	if !ri.link.connected.Load() {
+		flags = append(flags, "disconnected")
+	}
+	return strings.Join(flags, ",")
+}
+
+// writeSentinelInstance writes an instance as a map of its fields
+func writeSentinelInstance(w *resp.Writer, ri *sentinelInstance, now time.Time) {
+	ms := func(t time.Time) string {
+		if t.IsZero() {
+			return "0"
+		}
+		return strconv.FormatInt(now.Sub(t).Milliseconds(), 10)
+	}
+	fields := []string{
+		"name", ri.name,
+		"ip", ri.host,
+		"port", strconv.Itoa(ri.port),
+		"runid", ri.runid,
+		"flags", sentinelFlags(ri),
+		"link-pending-commands", strconv.Itoa(int(ri.link.pending.Load())),
+		"last-ping-sent", ms(ri.pingSent),
+		"last-ok-ping-reply", ms(ri.lastAvailable),
+		"down-after-milliseconds", strconv.FormatInt(ri.masterOf().downAfter.Milliseconds(), 10),
+	}
+	if ri.flags&SRI_S_DOWN != 0 {
+		fields = append(fields, "s-down-time", ms(ri.sDownSince))
+	}
+	if ri.flags&SRI_O_DOWN != 0 {
+		fields = append(fields, "o-down-time", ms(ri.oDownSince))
+	}
+	if ri.flags&SRI_SENTINEL == 0 {
+		fields = append(fields, "info-refresh", ms(ri.infoRefresh), "role-reported", ri.role, "role-reported-time", ms(ri.roleReported))
+	}
+	switch {
+	case ri.flags&SRI_MASTER != 0:
+		fields = append(fields,
+			"config-epoch", strconv.FormatInt(ri.configEpoch, 10),
+			"num-slaves", strconv.Itoa(len(ri.replicas)),
+			"num-other-sentinels", strconv.Itoa(len(ri.sentinels)),
+			"quorum", strconv.Itoa(ri.quorum),
+			"failover-timeout", strconv.FormatInt(ri.failoverTimeout.Milliseconds(), 10),
+		)
+		if ri.flags&SRI_FAILOVER_IN_PROGRESS != 0 {
+			fields = append(fields, "failover-state", sentinelFailoverStateName(ri.failoverState))
+		}
+	case ri.flags&SRI_SLAVE != 0:
+		status := "err"
+		if ri.masterLinkUp {
+			status = "ok"
+		}
+		fields = append(fields,
+			"master-link-status", status,
+			"master-host", ri.masterHost,
+			"master-port", strconv.Itoa(ri.masterPort),
+			"slave-repl-offset", strconv.FormatInt(ri.replOffset, 10),
+		)
+	case ri.flags&SRI_SENTINEL != 0:
+		fields = append(fields, "last-hello-message", ms(ri.lastHello))
+		if ri.leader != "" {
+			fields = append(fields, "voted-leader", ri.leader, "voted-leader-epoch", strconv.FormatInt(ri.leaderEpoch, 10))
+		}
+	}
+	w.WriteMapLen(len(fields) / 2)
+	for _, field := range fields {
+		w.WriteBulkString(field)
+	}
+}
+
+func sentinelFailoverStateName(state int) string {
+	return [...]string{"none", "wait_start", "select_slave", "send_slaveof_noone", "wait_promotion", "reconf_slaves", "update_config"}[state]
+}
+
+// sortedInstances returns the instances sorted by name
+func sortedInstances(instances map[string]*sentinelInstance) []*sentinelInstance {
+	sorted := make([]*sentinelInstance, 0, len(instances))
+	for _, ri := range instances {
+		sorted = append(sorted, ri)
+	}
+	slices.SortFunc(sorted, func(a, b *sentinelInstance) int { return strings.Compare(a.name, b.name) })
+	return sorted
+}
+
+// writeSentinelInfo writes the sentinel section of INFO
+func writeSentinelInfo(b *strings.Builder) {
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	b.WriteString("# Sentinel\r\n")
+	fmt.Fprintf(b, "sentinel_masters:%d\r\n", len(sentinel.masters))
+	fmt.Fprintf(b, "sentinel_current_epoch:%d\r\n", sentinel.currentEpoch)
+	for i, master := range sortedInstances(sentinel.masters) {
+		status := "ok"
+		if master.flags&SRI_O_DOWN != 0 {
+			status = "odown"
+		} else if master.flags&SRI_S_DOWN != 0 {
+			status = "sdown"
+		}
+		host, port := master.currentAddr()
+		fmt.Fprintf(b, "master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+			i, master.name, status, net.JoinHostPort(host, strconv.Itoa(port)), len(master.replicas), len(master.sentinels)+1)
+	}
+}
+
+// sentinelCommand handles SENTINEL <subcommand>
+func (c *Client) sentinelCommand(args []string) {
+	w := c.writer()
+	sub := strings.ToUpper(args[0])
+	args = args[1:]
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	now := time.Now()
+
+	named := func() *sentinelInstance {
+		master := sentinel.masters[args[0]]
+		if master == nil {
+			w.WriteError("ERR No such master with that name")
+		}
+		return master
+	}
+	switch {
+	case sub == "MYID" && len(args) == 0:
+		w.WriteBulkString(sentinel.myid)
+	case sub == "MASTERS" && len(args) == 0:
+		masters := sortedInstances(sentinel.masters)
+		w.WriteArrayLen(len(masters))
+		for _, master := range masters {
+			writeSentinelInstance(w, master, now)
+		}
+	case sub == "MASTER" && len(args) == 1:
+		if master := named(); master != nil {
+			writeSentinelInstance(w, master, now)
+		}
+	case (sub == "REPLICAS" || sub == "SLAVES" || sub == "SENTINELS") && len(args) == 1:
+		master := named()
+		if master == nil {
+			return
+		}
+		instances := sortedInstances(master.replicas)
+		if sub == "SENTINELS" {
+			instances = sortedInstances(master.sentinels)
+		}
+		w.WriteArrayLen(len(instances))
+		for _, ri := range instances {
+			writeSentinelInstance(w, ri, now)
+		}
+	case (sub == "GET-MASTER-ADDR-BY-NAME" || sub == "GET-PRIMARY-ADDR-BY-NAME") && len(args) == 1:
+		master := sentinel.masters[args[0]]
+		if master == nil {
+			w.WriteNullArray()
+			return
+		}
+		host, port := master.currentAddr()
+		w.WriteArrayLen(2)
+		w.WriteBulkString(host)
+		w.WriteBulkString(strconv.Itoa(port))
+	case sub == "IS-MASTER-DOWN-BY-ADDR" && len(args) == 4:
+		port, err1 := strconv.Atoi(args[1])
+		epoch, err2 := strconv.ParseInt(args[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		var master *sentinelInstance
+		for _, m := range sentinel.masters {
+			if m.host == args[0] && m.port == port {
+				master = m
+			}
+		}
+		down := master != nil && master.flags&SRI_S_DOWN != 0
+		leader, leaderEpoch := "*", int64(0)
+		if master != nil && args[3] != "*" {
+			leader, leaderEpoch = sentinelVoteLeader(master, epoch, args[3])
+		}
+		w.WriteArrayLen(3)
+		if down {
+			w.WriteInteger(1)
+		} else {
+			w.WriteInteger(0)
+		}
+		w.WriteBulkString(leader)
+		w.WriteInteger(leaderEpoch)
+	case sub == "FAILOVER" && len(args) == 1:
+		master := named()
+		if master == nil {
+			return
+		}
+		if master.flags&SRI_FAILOVER_IN_PROGRESS != 0 {
+			w.WriteError("INPROG Failover already in progress")
+			return
+		}
+		if sentinelSelectSlave(master, now) == nil {
+			w.WriteError("NOGOODSLAVE No suitable replica to promote")
+			return
+		}
+		sentinelStartFailover(master, now)
+		master.flags |= SRI_FORCE_FAILOVER
+		w.WriteSimpleString("OK")
+	case sub == "CKQUORUM" && len(args) == 1:
+		master := named()
+		if master == nil {
+			return
+		}
+		usable := 1
+		for _, s := range master.sentinels {
+			if s.flags&(SRI_S_DOWN|SRI_O_DOWN) == 0 {
+				usable++
+			}
+		}
+		voters := len(master.sentinels) + 1
+		switch {
+		case usable < master.quorum:
+			w.WriteError(fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable))
+		case usable < voters/2+1:
+			w.WriteError(fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable))
+		default:
+			w.WriteSimpleString(fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable))
+		}
+	case sub == "MONITOR" && len(args) == 4:
+		port, err1 := strconv.Atoi(args[2])
+		quorum, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		if err := sentinelMonitor(args[0], args[1], port, quorum); err != nil {
+			w.WriteError(err.Error())
+			return
+		}
+		w.WriteSimpleString("OK")
+	case sub == "REMOVE" && len(args) == 1:
+		if master := named(); master != nil {
+			sentinelRemove(master)
+			w.WriteSimpleString("OK")
+		}
+	case sub == "HELP" && len(args) == 0:
+		lines := []string{
+			"SENTINEL <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CKQUORUM <master-name>",
+			"    Check if the current Sentinel configuration is able to reach the quorum",
+			"    needed to failover a master and the majority needed to authorize the",
+			"    failover.",
+			"FAILOVER <master-name>",
+			"    Manually failover a master node without asking for agreement from other",
+			"    Sentinels.",
+			"GET-MASTER-ADDR-BY-NAME <master-name>",
+			"    Return the ip and port number of the master with that name.",
+			"IS-MASTER-DOWN-BY-ADDR <ip> <port> <current-epoch> <runid>",
+			"    Check if the master specified by ip:port is down from current Sentinel's",
+			"    point of view, and vote for <runid> as the leader of the failover.",
+			"MASTER <master-name>",
+			"    Show the state and info of the specified master.",
+			"MASTERS",
+			"    Show a list of monitored masters and their state.",
+			"MONITOR <name> <ip> <port> <quorum>",
+			"    Start monitoring a new master with the specified name, ip, port and quorum.",
+			"MYID",
+			"    Return the ID of the Sentinel instance.",
+			"REMOVE <master-name>",
+			"    Remove a master from Sentinel's monitor list.",
+			"REPLICAS <master-name>",
+			"    Show a list of replicas for this master and their state.",
+			"SENTINELS <master-name>",
+			"    Show a list of Sentinel instances for this master and their state.",
+		}
+		w.WriteArrayLen(len(lines))
+		for _, line := range lines {
+			w.WriteSimpleString(line)
+		}
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SENTINEL HELP.", strings.ToLower(sub)))
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetSentinel makes this sentinel "me" in epoch 0 for the test
+func resetSentinel(t *testing.T) {
+	sentinel.mu.Lock()
+	sentinel.myid, sentinel.currentEpoch = "me", 0
+	sentinel.mu.Unlock()
+	t.Cleanup(func() {
+		sentinel.mu.Lock()
+		defer sentinel.mu.Unlock()
+		for _, master := range sentinel.masters {
+			sentinelRemove(master)
+		}
+		sentinel.myid, sentinel.currentEpoch = "", 0
+	})
+}
+
+// testMaster returns a master with sentinels that voted for the given
+// run IDs, nothing is connected to
+func testMaster(quorum int, votes ...string) *sentinelInstance {
+	master := &sentinelInstance{
+		flags:           SRI_MASTER,
+		name:            "mymaster",
+		host:            "127.0.0.1",
+		port:            1,
+		link:            &instanceLink{},
+		quorum:          quorum,
+		downAfter:       time.Second,
+		failoverTimeout: time.Minute,
+		replicas:        make(map[string]*sentinelInstance),
+		sentinels:       make(map[string]*sentinelInstance),
+	}
+	for i, vote := range votes {
+		runid := "sentinel" + strconv.Itoa(i)
+		master.sentinels[runid] = &sentinelInstance{flags: SRI_SENTINEL, runid: runid, master: master, link: &instanceLink{}, leader: vote, leaderEpoch: 1}
+	}
+	return master
+}
+
+func TestSentinelLeaderElection(t *testing.T) {
+	resetSentinel(t)
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+
+	master := testMaster(2, "sentinel0", "sentinel0")
+	if leader := sentinelGetLeader(master, 1); leader != "sentinel0" {
+		t.Errorf("Expected sentinel0 to be elected with every vote, got %q", leader)
+	}
+	if master.leader != "sentinel0" || master.leaderEpoch != 1 || sentinel.currentEpoch != 1 {
+		t.Errorf("Expected to vote for sentinel0 in epoch 1, voted for %q in %d", master.leader, master.leaderEpoch)
+	}
+	if leader, epoch := sentinelVoteLeader(master, 1, "sentinel1"); leader != "sentinel0" || epoch != 1 {
+		t.Errorf("Expected a single vote per epoch, got %q %d", leader, epoch)
+	}
+
+	// every sentinel votes for itself
+	master = testMaster(2, "sentinel0", "sentinel1")
+	if leader := sentinelGetLeader(master, 2); leader != "" {
+		t.Errorf("Expected no majority, got %q", leader)
+	}
+	if master.leader != "me" || master.leaderEpoch != 2 {
+		t.Errorf("Expected to vote for itself, voted for %q in %d", master.leader, master.leaderEpoch)
+	}
+
+	// a majority below the quorum isn't enough
+	master = testMaster(3, "me")
+	if leader := sentinelGetLeader(master, 3); leader != "" {
+		t.Errorf("Expected the quorum to be needed, got %q", leader)
+	}
+	master = testMaster(2, "me")
+	if leader := sentinelGetLeader(master, 4); leader != "" {
+		t.Errorf("Expected votes of a past epoch not to count, got %q", leader)
+	}
+}
+
+func TestSentinelHello(t *testing.T) {
+	resetSentinel(t)
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	master := testMaster(2)
+	sentinel.masters[master.name] = master
+
+	sentinelProcessHello("127.0.0.1,26380,peer,3,mymaster,127.0.0.1,1,0")
+	peer := master.sentinels["peer"]
+	if peer == nil || peer.port != 26380 || peer.lastHello.IsZero() {
+		t.Fatalf("Expected the sentinel to be discovered, got %v", master.sentinels)
+	}
+	if sentinel.currentEpoch != 3 {
+		t.Errorf("Expected the epoch of the sentinel to be adopted, got %d", sentinel.currentEpoch)
+	}
+	sentinelProcessHello("127.0.0.1,26380,restarted,3,mymaster,127.0.0.1,1,0")
+	if _, ok := master.sentinels["peer"]; ok || master.sentinels["restarted"] == nil {
+		t.Errorf("Expected the restarted sentinel to replace the old one, got %v", master.sentinels)
+	}
+	sentinelProcessHello("127.0.0.1,26381,me,3,mymaster,127.0.0.1,1,0")
+	sentinelProcessHello("bad")
+	if len(master.sentinels) != 1 {
+		t.Errorf("Expected its own and invalid hellos to be ignored, got %v", master.sentinels)
+	}
+
+	// a failover happened elsewhere
+	sentinelProcessHello("127.0.0.1,26380,restarted,4,mymaster,127.0.0.1,2,4")
+	if master.host != "127.0.0.1" || master.port != 2 || master.configEpoch != 4 {
+		t.Errorf("Expected the master to switch to port 2, got %s:%d in epoch %d", master.host, master.port, master.configEpoch)
+	}
+	if _, ok := master.replicas["127.0.0.1:1"]; !ok || len(master.replicas) != 1 {
+		t.Errorf("Expected the old master to be a replica, got %v", master.replicas)
+	}
+	sentinelProcessHello("127.0.0.1,26380,restarted,4,mymaster,127.0.0.1,3,3")
+	if master.port != 2 {
+		t.Errorf("Expected an older configuration to be ignored, got port %d", master.port)
+	}
+}
+
+func TestSentinelRefreshInfo(t *testing.T) {
+	resetSentinel(t)
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	master := testMaster(1)
+	sentinel.masters[master.name] = master
+
+	sentinelRefreshInstanceInfo(master, "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n"+
+		"slave0:ip=127.0.0.1,port=3,state=online,offset=10,lag=0\r\nslave1:ip=127.0.0.1,port=0,state=online,offset=0,lag=0\r\n")
+	r := master.replicas["127.0.0.1:3"]
+	if r == nil || len(master.replicas) != 1 || master.role != "master" {
+		t.Fatalf("Expected the replica with a port to be discovered, got %v", master.replicas)
+	}
+	sentinelRefreshInstanceInfo(r, "# Replication\r\nrole:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:1\r\nmaster_link_status:up\r\nslave_repl_offset:42\r\n")
+	if r.role != "slave" || r.masterPort != 1 || !r.masterLinkUp || r.replOffset != 42 {
+		t.Errorf("Unexpected replica state %+v", r)
+	}
+
+	// the replica being promoted reports it is a master
+	master.flags |= SRI_FAILOVER_IN_PROGRESS
+	master.failoverState, master.failoverEpoch = SENTINEL_FAILOVER_STATE_WAIT_PROMOTION, 5
+	r.flags |= SRI_PROMOTED
+	master.promoted = r
+	sentinelRefreshInstanceInfo(r, "# Replication\r\nrole:master\r\nconnected_slaves:0\r\n")
+	if master.failoverState != SENTINEL_FAILOVER_STATE_RECONF_SLAVES || master.configEpoch != 5 {
+		t.Errorf("Expected the promotion to be noticed, got state %d epoch %d", master.failoverState, master.configEpoch)
+	}
+	if host, port := master.currentAddr(); host != "127.0.0.1" || port != 3 {
+		t.Errorf("Expected the promoted replica to be the master now, got %s:%d", host, port)
+	}
+}
+
+// readStrings reads an array of bulk strings
+func (c *testConn) readStrings() []string {
+	c.t.Helper()
+	header := c.readLine()
+	n, err := strconv.Atoi(strings.TrimPrefix(header, "*"))
+	if err != nil || !strings.HasPrefix(header, "*") {
+		c.t.Fatalf("Expected an array, got %q", header)
+	}
+	words := make([]string, n)
+	for i := range words {
+		words[i] = c.readBulk()
+	}
+	return words
+}
+
+// sentinelMaster returns the fields of SENTINEL MASTER
+func (c *testConn) sentinelMaster(name string) map[string]string {
+	c.t.Helper()
+	c.send("SENTINEL", "MASTER", name)
+	words := c.readStrings()
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(words); i += 2 {
+		fields[words[i]] = words[i+1]
+	}
+	return fields
+}
+
+// masterAddr returns what a sentinel answers to GET-MASTER-ADDR-BY-NAME
+func (c *testConn) masterAddr(name string) string {
+	c.t.Helper()
+	c.send("SENTINEL", "GET-MASTER-ADDR-BY-NAME", name)
+	words := c.readStrings()
+	return net.JoinHostPort(words[0], words[1])
+}
+
+func TestSentinelFailover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts server processes")
+	}
+	master, masterProcess := startProcess(t)
+	m := dial(t, master)
+	m.send("SET", "key", "before")
+	m.expect("+OK\r\n")
+	host, port, _ := net.SplitHostPort(master)
+	var replicas []*testConn
+	for i := 0; i < 2; i++ {
+		addr, _ := startProcess(t, "-replicaof", host+" "+port)
+		replicas = append(replicas, dial(t, addr))
+	}
+	eventually(t, "the replicas to be up", func() bool {
+		return m.info("replication")["connected_slaves"] == "2"
+	})
+
+	var sentinels []*testConn
+	for i := 0; i < 3; i++ {
+		addr, _ := startProcess(t, "-sentinel", "-sentinel-monitor", "mymaster "+host+" "+port+" 2",
+			"-sentinel-down-after-milliseconds", "500", "-sentinel-failover-timeout", "3000")
+		sentinels = append(sentinels, dial(t, addr))
+	}
+	for _, s := range sentinels {
+		eventuallyWithin(t, 20*time.Second, "the replicas and sentinels to be discovered", func() bool {
+			fields := s.sentinelMaster("mymaster")
+			return fields["num-slaves"] == "2" && fields["num-other-sentinels"] == "2"
+		})
+	}
+	s := sentinels[0]
+	if addr := s.masterAddr("mymaster"); addr != master {
+		t.Errorf("Expected the master to be %s, got %s", master, addr)
+	}
+	s.send("SENTINEL", "CKQUORUM", "mymaster")
+	s.expect("+OK 3 usable Sentinels. Quorum and failover authorization can be reached\r\n")
+	s.send("SENTINEL", "MASTER", "nosuch")
+	s.expect("-ERR No such master with that name\r\n")
+	s.send("SET", "key", "value")
+	s.expect("-ERR unknown command 'SET'\r\n")
+	if info := s.info("sentinel"); info["sentinel_masters"] != "1" || !strings.Contains(info["master0"], "status=ok,address="+master+",slaves=2,sentinels=3") {
+		t.Errorf("Unexpected sentinel info %v", info)
+	}
+
+	masterProcess.Kill()
+	var promoted string
+	eventuallyWithin(t, 30*time.Second, "the sentinels to agree on a new master", func() bool {
+		promoted = sentinels[0].masterAddr("mymaster")
+		for _, s := range sentinels[1:] {
+			if s.masterAddr("mymaster") != promoted {
+				return false
+			}
+		}
+		return promoted != master
+	})
+
+	var newMaster, replica *testConn
+	for _, r := range replicas {
+		if r.conn.RemoteAddr().String() == promoted {
+			newMaster = r
+		} else {
+			replica = r
+		}
+	}
+	if newMaster == nil {
+		t.Fatalf("Expected a replica to be promoted, got %s", promoted)
+	}
+	eventually(t, "the other replica to follow the new master", func() bool {
+		info := replica.info("replication")
+		return info["master_port"] == strings.Split(promoted, ":")[1] && info["master_link_status"] == "up"
+	})
+	if role := newMaster.info("replication")["role"]; role != "master" {
+		t.Errorf("Expected the promoted replica to be a master, got %s", role)
+	}
+	newMaster.send("SET", "key", "after")
+	newMaster.expect("+OK\r\n")
+	eventually(t, "the write to be replicated", func() bool {
+		replica.send("GET", "key")
+		return replica.readBulkOrNull() == "after"
+	})
+	if fields := s.sentinelMaster("mymaster"); fields["config-epoch"] == "0" || fields["num-slaves"] != "2" {
+		t.Errorf("Expected a new configuration with the old master as a replica, got %v", fields)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+)
+
+// serve accepts connections until the listener is closed, every connection
+// is served by its own client
+func serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go handleConnection(conn)
+	}
+}
+
+// handleConnection runs the commands of a connection in order
+func handleConnection(conn net.Conn) {
+	out := newConnWriter(conn)
+	client := NewClient(kvStore, aofManager, out)
+	client.conn = out
+	registerClient(client)
+	defer client.Close()
+
+	requests := make(chan request)
+	gone := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	client.gone = gone
+	authenticated := func() bool { return !client.authRequired() }
+	go readRequests(conn, requests, gone, done, &client.queryBuf, authenticated)
+
+	for req := range requests {
+		if req.err != nil {
+			if errors.Is(req.err, parser.ErrProtocol) {
+				fmt.Fprintf(out, "-ERR %v\r\n", req.err)
+			}
+			return
+		}
+		if req.cmd.Name != "" { // empty arrays are ignored
+			client.ProcessCommand(req.cmd)
+		}
+		if req.processed != nil {
+			close(req.processed)
+		}
+		if client.flags&CLIENT_CLOSE_AFTER_REPLY != 0 {
+			return
+		}
+	}
+}
+
+// request is a command read from a connection, or the error ending it.
+// processed, when set, is closed once the command ran.
+type request struct {
+	cmd       *parser.Command
+	err       error
+	processed chan struct{}
+}
+
+// readRequests reads the commands of a connection while they are run, so a
+// client blocked by BLPOP and the like notices when its connection is gone.
+// The error ending the connection is the last request, gone is closed as
+// soon as it is read. done stops the reading when the client goes first.
+// queryBuf is kept to the number of bytes read but not parsed yet.
+//
+// Until the client authenticates its commands are read with the limits of
+// parser.ReadUnauthenticatedCommand, each one once the previous one ran, so
+// the command following AUTH gets the limits of the authenticated clients.
+// authenticated is only called while no command runs.
+func readRequests(conn net.Conn, requests chan<- request, gone chan<- struct{}, done <-chan struct{}, queryBuf *atomic.Int64, authenticated func() bool) {
+	defer close(requests)
+	reader := bufio.NewReader(conn)
+	authed := authenticated()
+	for {
+		var cmd *parser.Command
+		var err error
+		if authed {
+			cmd, err = parser.ReadCommand(reader)
+		} else {
+			cmd, err = parser.ReadUnauthenticatedCommand(reader)
+		}
+		queryBuf.Store(int64(reader.Buffered()))
+		if err != nil {
+			close(gone)
+		}
+		req := request{cmd: cmd, err: err}
+		if !authed && err == nil {
+			req.processed = make(chan struct{})
+		}
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+		if req.processed != nil {
+			select {
+			case <-req.processed:
+				authed = authenticated()
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// connWriter queues what is sent to a connection and writes it from its own
+// goroutine, so writers like publishers never wait on a slow reader
+type connWriter struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []byte
+	closing bool // close once pending is written
+	closed  bool
+}
+
+func newConnWriter(conn net.Conn) *connWriter {
+	w := &connWriter{conn: conn}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// Write queues p, it never blocks on the connection
+func (w *connWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.closing {
+		return 0, net.ErrClosed
+	}
+	w.pending = append(w.pending, p...)
+	w.cond.Signal()
+	return len(p), nil
+}
+
+// Pending returns the number of bytes queued but not written yet
+func (w *connWriter) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+func (w *connWriter) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+func (w *connWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+// Close closes the connection right away, dropping what is still queued
+func (w *connWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.pending = nil
+	w.cond.Signal()
+	w.mu.Unlock()
+	return w.conn.Close()
+}
+
+// CloseWhenDrained closes the connection once everything queued is written
+func (w *connWriter) CloseWhenDrained() {
+	w.mu.Lock()
+	w.closing = true
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+func (w *connWriter) run() {
+	var buf []byte
+	for {
+		w.mu.Lock()
+		for len(w.pending) == 0 && !w.closed && !w.closing {
+			w.cond.Wait()
+		}
+		if w.closed || (w.closing && len(w.pending) == 0) {
+			w.mu.Unlock()
+			w.conn.Close()
+			return
+		}
+		buf, w.pending = w.pending, buf[:0]
+		w.mu.Unlock()
+
+		if _, err := w.conn.Write(buf); err != nil {
+			log.Printf("Error writing to client %s: %v", w.conn.RemoteAddr(), err)
+			w.Close()
+			return
+		}
+	}
+}
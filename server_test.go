@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// startServer serves kvStore on a random port, without AOF
+func startServer(t *testing.T) string {
+	t.Helper()
+	savedAOF := aofManager
+	aofManager = nil
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	go serve(ln)
+	t.Cleanup(func() {
+		ln.Close()
+		aofManager = savedAOF
+	})
+	return ln.Addr().String()
+}
+
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes the words as a RESP array
+func (c *testConn) send(words ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(words))
+	for _, word := range words {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(word), word)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatalf("Error writing: %v", err)
+	}
+}
+
+// expect reads len(expected) bytes and compares them
+func (c *testConn) expect(expected string) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(c.reader, buf); err != nil {
+		c.t.Fatalf("Error reading %q: %v", expected, err)
+	}
+	if string(buf) != expected {
+		c.t.Fatalf("Expected %q, got %q", expected, buf)
+	}
+}
+
+func TestServer(t *testing.T) {
+	addr := startServer(t)
+
+	t.Run("commands", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("SET", "srv:key", "hello world")
+		c.expect("+OK\r\n")
+		c.send("GET", "srv:key")
+		c.expect("$11\r\nhello world\r\n")
+		c.send("GET")
+		c.expect("-ERR wrong number of arguments for 'get' command\r\n")
+	})
+
+	t.Run("command errors", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("SET", "srv:str", "hello")
+		c.expect("+OK\r\n")
+		c.send("COPY", "srv:str", "srv:str")
+		c.expect("-ERR source and destination objects are the same\r\n")
+		c.send("INCRBY", "srv:str", "1")
+		c.expect("-ERR value is not an integer or out of range\r\n")
+		c.send("INCRBY", "srv:str", "x")
+		c.expect("-ERR value is not an integer or out of range\r\n")
+		c.send("DECRBY", "srv:missing", "1")
+		c.expect("-ERR no such key\r\n")
+		c.send("EXPIRE", "srv:str", "soon")
+		c.expect("-ERR value is not an integer or out of range\r\n")
+		c.send("PUBSUB", "NOSUCH")
+		c.expect("-ERR unknown subcommand or wrong number of arguments for 'nosuch'. Try PUBSUB HELP.\r\n")
+		c.send("OBJECT", "NOSUCH", "srv:str")
+		c.expect("-ERR unknown subcommand or wrong number of arguments for 'nosuch'. Try OBJECT HELP.\r\n")
+		c.send("OBJECT", "FREQ", "srv:str")
+		c.expect("-ERR " + store.ErrLFUNotSelected.Error() + "\r\n")
+		c.send("MEMORY", "USAGE", "srv:str", "SAMPLES", "-1")
+		c.expect("-ERR value is not an integer or out of range\r\n")
+	})
+
+	t.Run("pub/sub across connections", func(t *testing.T) {
+		subscriber := dial(t, addr)
+		publisher := dial(t, addr)
+		subscriber.send("SUBSCRIBE", "srv:news")
+		subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$8\r\nsrv:news\r\n:1\r\n")
+		publisher.send("PUBLISH", "srv:news", "hi")
+		publisher.expect(":1\r\n")
+		subscriber.expect("*3\r\n$7\r\nmessage\r\n$8\r\nsrv:news\r\n$2\r\nhi\r\n")
+	})
+
+	t.Run("inline commands", func(t *testing.T) {
+		c := dial(t, addr)
+		c.conn.Write([]byte("PING\r\n\r\nSET \"srv:inline key\" 'it\\'s'\nGET \"srv:inline key\"\r\nNOSUCH arg\r\n"))
+		c.expect("+PONG\r\n+OK\r\n$4\r\nit's\r\n-ERR unknown command 'NOSUCH'\r\n")
+		c.conn.Write([]byte("GET \"srv:unbalanced\r\n"))
+		c.expect("-ERR Protocol error: unbalanced quotes in request\r\n")
+		if _, err := c.reader.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the connection to be closed, got %v", err)
+		}
+	})
+
+	t.Run("QUIT closes the connection", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("QUIT")
+		c.expect("+OK\r\n")
+		if _, err := c.reader.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the connection to be closed, got %v", err)
+		}
+	})
+
+	t.Run("protocol errors close the connection", func(t *testing.T) {
+		c := dial(t, addr)
+		c.conn.Write([]byte("*1\r\n:1\r\n"))
+		c.expect("-ERR Protocol error: expected '$', got ':'\r\n")
+		if _, err := c.reader.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the connection to be closed, got %v", err)
+		}
+	})
+
+	t.Run("slow subscribers are disconnected", func(t *testing.T) {
+		saved := pubsubOutputBufferLimit.Load()
+		pubsubOutputBufferLimit.Store(64 * 1024)
+		defer pubsubOutputBufferLimit.Store(saved)
+
+		subscriber := dial(t, addr)
+		publisher := dial(t, addr)
+		subscriber.send("SUBSCRIBE", "srv:firehose")
+		subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$12\r\nsrv:firehose\r\n:1\r\n")
+
+		// the subscriber never reads: once the socket buffers are full the
+		// messages pile up in its output buffer until it goes over the limit
+		payload := strings.Repeat("x", 64*1024)
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			publisher.send("PUBLISH", "srv:firehose", payload)
+			publisher.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			line, err := publisher.reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Error reading: %v", err)
+			}
+			if line == ":0\r\n" {
+				break // the subscriber is gone
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the subscriber to be disconnected")
+			}
+		}
+	})
+}
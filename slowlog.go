@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+const (
+	SLOWLOG_LOG_SLOWER_THAN_DEFAULT = 10000 // microseconds
+	SLOWLOG_MAX_LEN_DEFAULT         = 128
+	SLOWLOG_ENTRY_MAX_ARGC          = 32  // arguments kept per entry, the last one tells how many more there were
+	SLOWLOG_ENTRY_MAX_STRING        = 128 // bytes kept per argument
+	SLOWLOG_GET_DEFAULT_COUNT       = 10
+)
+
+// slowlogSlowerThan is the time in microseconds a command runs before it
+// is logged, a negative one disables the log
+var slowlogSlowerThan atomic.Int64
+
+// slowlogMaxLen is the number of entries kept, the oldest ones are dropped
+var slowlogMaxLen atomic.Int64
+
+func init() {
+	slowlogSlowerThan.Store(SLOWLOG_LOG_SLOWER_THAN_DEFAULT)
+	slowlogMaxLen.Store(SLOWLOG_MAX_LEN_DEFAULT)
+}
+
+// slowlogEntry is a command that ran for longer than slowlogSlowerThan
+type slowlogEntry struct {
+	id       int64
+	time     int64 // unix time the command ran at
+	duration int64 // microseconds
+	args     []string
+	addr     string // of the client, empty for the prompt
+	name     string // of the client
+}
+
+var slowlog = struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry // newest first
+	nextID  int64
+}{}
+
+// slowlogPushEntryIfNeeded logs the command c ran if it was slow enough
+func (c *Client) slowlogPushEntryIfNeeded(cmd []string, duration time.Duration) {
+	threshold := slowlogSlowerThan.Load()
+	if threshold < 0 || duration.Microseconds() < threshold {
+		return
+	}
+	entry := &slowlogEntry{
+		time:     time.Now().Unix(),
+		duration: duration.Microseconds(),
+		args:     slowlogArgs(redactArgs(cmd)),
+		name:     c.getName(),
+	}
+	if c.conn != nil {
+		entry.addr = c.conn.RemoteAddr().String()
+	}
+
+	slowlog.mu.Lock()
+	defer slowlog.mu.Unlock()
+	entry.id = slowlog.nextID
+	slowlog.nextID++
+	slowlog.entries = append([]*slowlogEntry{entry}, slowlog.entries...)
+	slowlogTrimLocked()
+}
+
+// slowlogTrim drops the oldest entries past slowlogMaxLen
+func slowlogTrim() {
+	slowlog.mu.Lock()
+	defer slowlog.mu.Unlock()
+	slowlogTrimLocked()
+}
+
+func slowlogTrimLocked() {
+	if maxLen := int(slowlogMaxLen.Load()); len(slowlog.entries) > maxLen {
+		slowlog.entries = slowlog.entries[:maxLen]
+	}
+}
+
+// slowlogArgs shortens the arguments of a command to what an entry keeps
+func slowlogArgs(cmd []string) []string {
+	argc := min(len(cmd), SLOWLOG_ENTRY_MAX_ARGC)
+	args := make([]string, argc)
+	for i := 0; i < argc; i++ {
+		if i == argc-1 && argc < len(cmd) {
+			args[i] = fmt.Sprintf("... (%d more arguments)", len(cmd)-argc+1)
+		} else if len(cmd[i]) > SLOWLOG_ENTRY_MAX_STRING {
+			args[i] = fmt.Sprintf("%s... (%d more bytes)", cmd[i][:SLOWLOG_ENTRY_MAX_STRING], len(cmd[i])-SLOWLOG_ENTRY_MAX_STRING)
+		} else {
+			args[i] = cmd[i]
+		}
+	}
+	return args
+}
+
+// redactArgs returns a copy of a command, name included, with the
+// passwords it holds replaced, for the logs showing commands
+func redactArgs(cmd []string) []string {
+	args := append([]string(nil), cmd...)
+	redact := func(i int) {
+		if i < len(args) {
+			args[i] = "(redacted)"
+		}
+	}
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "AUTH":
+		for i := 1; i < len(args); i++ {
+			redact(i)
+		}
+	case "HELLO", "MIGRATE":
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if name == "HELLO" {
+					redact(i + 2) // AUTH username password
+				} else {
+					redact(i + 1) // AUTH password
+				}
+			case "AUTH2":
+				redact(i + 2) // AUTH2 username password
+			}
+		}
+	case "CONFIG":
+		if len(args) > 1 && strings.EqualFold(args[1], "SET") {
+			for i := 2; i+1 < len(args); i += 2 {
+				if param := strings.ToLower(args[i]); param == "requirepass" || param == "masterauth" {
+					redact(i + 1)
+				}
+			}
+		}
+	case "ACL":
+		if len(args) > 1 && strings.EqualFold(args[1], "SETUSER") {
+			for i := 3; i < len(args); i++ {
+				if rule := args[i]; rule != "" && strings.ContainsRune("<>#!", rune(rule[0])) {
+					redact(i)
+				}
+			}
+		}
+	}
+	return args
+}
+
+// slowlogCommand handles SLOWLOG GET [count], LEN, RESET and HELP
+func slowlogCommand(w *resp.Writer, args []string) {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if len(args) > 2 {
+			break
+		}
+		count := SLOWLOG_GET_DEFAULT_COUNT
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < -1 {
+				w.WriteError("ERR count should be greater than or equal to -1")
+				return
+			}
+			count = n
+		}
+		slowlog.mu.Lock()
+		entries := slowlog.entries
+		if count >= 0 && count < len(entries) {
+			entries = entries[:count]
+		}
+		entries = append([]*slowlogEntry(nil), entries...)
+		slowlog.mu.Unlock()
+		w.WriteArrayLen(len(entries))
+		for _, entry := range entries {
+			w.WriteArrayLen(6)
+			w.WriteInteger(entry.id)
+			w.WriteInteger(entry.time)
+			w.WriteInteger(entry.duration)
+			w.WriteArrayLen(len(entry.args))
+			for _, arg := range entry.args {
+				w.WriteBulkString(arg)
+			}
+			w.WriteBulkString(entry.addr)
+			w.WriteBulkString(entry.name)
+		}
+		return
+	case "LEN":
+		if len(args) != 1 {
+			break
+		}
+		slowlog.mu.Lock()
+		defer slowlog.mu.Unlock()
+		w.WriteInteger(int64(len(slowlog.entries)))
+		return
+	case "RESET":
+		if len(args) != 1 {
+			break
+		}
+		slowlog.mu.Lock()
+		defer slowlog.mu.Unlock()
+		slowlog.entries = nil
+		w.WriteSimpleString("OK")
+		return
+	case "HELP":
+		if len(args) != 1 {
+			break
+		}
+		help := []string{
+			"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET [<count>]",
+			"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+			"    Entries are made of:",
+			"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+			"    client name",
+			"LEN",
+			"    Return the length of the slowlog.",
+			"RESET",
+			"    Reset the slowlog.",
+			"HELP",
+			"    Prints this help.",
+		}
+		w.WriteArrayLen(len(help))
+		for _, line := range help {
+			w.WriteSimpleString(line)
+		}
+		return
+	}
+	w.WriteError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SLOWLOG HELP.", strings.ToLower(args[0])))
+}
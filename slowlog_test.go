@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readSlowlogEntry reads an entry of SLOWLOG GET, and returns its id, its
+// arguments, the client address and name
+func (c *testConn) readSlowlogEntry() (int64, []string, string, string) {
+	c.t.Helper()
+	if line := c.readLine(); line != "*6" {
+		c.t.Fatalf("Expected an entry, got %q", line)
+	}
+	id, _ := strconv.ParseInt(strings.TrimPrefix(c.readLine(), ":"), 10, 64)
+	c.readLine() // time
+	c.readLine() // duration
+	argc, _ := strconv.Atoi(strings.TrimPrefix(c.readLine(), "*"))
+	args := make([]string, argc)
+	for i := range args {
+		args[i] = c.readBulk()
+	}
+	return id, args, c.readBulk(), c.readBulk()
+}
+
+func TestSlowlogArgs(t *testing.T) {
+	tests := []struct {
+		cmd      []string
+		expected []string
+	}{
+		{[]string{"AUTH", "secret"}, []string{"AUTH", "(redacted)"}},
+		{[]string{"auth", "user", "secret"}, []string{"auth", "(redacted)", "(redacted)"}},
+		{[]string{"HELLO", "3", "AUTH", "user", "secret", "SETNAME", "name"}, []string{"HELLO", "3", "AUTH", "user", "(redacted)", "SETNAME", "name"}},
+		{[]string{"MIGRATE", "h", "1", "", "0", "10", "AUTH", "secret", "KEYS", "k"}, []string{"MIGRATE", "h", "1", "", "0", "10", "AUTH", "(redacted)", "KEYS", "k"}},
+		{[]string{"MIGRATE", "h", "1", "k", "0", "10", "AUTH2", "user", "secret"}, []string{"MIGRATE", "h", "1", "k", "0", "10", "AUTH2", "user", "(redacted)"}},
+		{[]string{"CONFIG", "SET", "maxmemory", "1mb", "requirepass", "secret"}, []string{"CONFIG", "SET", "maxmemory", "1mb", "requirepass", "(redacted)"}},
+		{[]string{"ACL", "SETUSER", "alice", "on", ">secret", "~*", "#abc"}, []string{"ACL", "SETUSER", "alice", "on", "(redacted)", "~*", "(redacted)"}},
+		{[]string{"SET", "auth", "value"}, []string{"SET", "auth", "value"}},
+	}
+	for _, test := range tests {
+		if args := redactArgs(test.cmd); !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.cmd, test.expected, args)
+		}
+	}
+
+	long := strings.Repeat("x", SLOWLOG_ENTRY_MAX_STRING+10)
+	args := slowlogArgs([]string{"SET", "key", long})
+	if args[2] != long[:SLOWLOG_ENTRY_MAX_STRING]+"... (10 more bytes)" {
+		t.Errorf("Expected the long argument shortened, got %q", args[2])
+	}
+	many := make([]string, SLOWLOG_ENTRY_MAX_ARGC+5)
+	args = slowlogArgs(many)
+	if len(args) != SLOWLOG_ENTRY_MAX_ARGC || args[len(args)-1] != "... (6 more arguments)" {
+		t.Errorf("Expected %d arguments ending with the number of the others, got %d ending with %q", SLOWLOG_ENTRY_MAX_ARGC, len(args), args[len(args)-1])
+	}
+}
+
+func TestSlowlog(t *testing.T) {
+	resetConfig(t)
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("SLOWLOG", "RESET")
+	c.expect("+OK\r\n")
+	c.send("CONFIG", "SET", "slowlog-log-slower-than", "0")
+	c.expect("+OK\r\n")
+	c.send("CLIENT", "SETNAME", "slow")
+	c.expect("+OK\r\n")
+	c.send("SET", "slowlog:key", "value")
+	c.expect("+OK\r\n")
+	c.send("AUTH", "secret")
+	c.expect("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n")
+
+	c.send("SLOWLOG", "GET", "2")
+	c.expect("*2\r\n")
+	localAddr := c.conn.LocalAddr().String()
+	id, args, clientAddr, name := c.readSlowlogEntry()
+	if !reflect.DeepEqual(args, []string{"AUTH", "(redacted)"}) || clientAddr != localAddr || name != "slow" {
+		t.Errorf("Unexpected entry %d %q %s %s", id, args, clientAddr, name)
+	}
+	previous, args, _, _ := c.readSlowlogEntry()
+	if previous != id-1 || !reflect.DeepEqual(args, []string{"SET", "slowlog:key", "value"}) {
+		t.Errorf("Expected the SET before, got %d %q", previous, args)
+	}
+
+	// CONFIG SET, CLIENT SETNAME, SET, AUTH and SLOWLOG GET
+	c.send("SLOWLOG", "LEN")
+	c.expect(":5\r\n")
+	c.send("CONFIG", "SET", "slowlog-max-len", "2")
+	c.expect("+OK\r\n")
+	c.send("SLOWLOG", "LEN")
+	c.expect(":2\r\n")
+
+	c.send("CONFIG", "SET", "slowlog-log-slower-than", "-1")
+	c.expect("+OK\r\n")
+	c.send("SLOWLOG", "RESET")
+	c.expect("+OK\r\n")
+	c.send("GET", "slowlog:key")
+	c.expect("$5\r\nvalue\r\n")
+	c.send("SLOWLOG", "GET")
+	c.expect("*0\r\n")
+
+	c.send("SLOWLOG", "GET", "-2")
+	c.expect("-ERR count should be greater than or equal to -1\r\n")
+	c.send("SLOWLOG", "NOSUCH")
+	c.expect("-ERR unknown subcommand or wrong number of arguments for 'nosuch'. Try SLOWLOG HELP.\r\n")
+}
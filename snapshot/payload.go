@@ -0,0 +1,91 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"strconv"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+// DUMP_VERSION is the version of the payload format of DUMP, a payload of
+// another version is refused by RESTORE
+const DUMP_VERSION = 1
+
+// ErrBadPayload is returned for a payload DUMP didn't produce
+var ErrBadPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// EncodeValue returns the payload DUMP replies with for the value of a key:
+// its type and its elements encoded like a command, followed by the version
+// of the format and a CRC64 of the whole, as Redis does
+func EncodeValue(dump store.KeyDump) string {
+	var elements []string
+	switch dump.Type {
+	case "string":
+		elements = []string{dump.String}
+	case "list":
+		elements = dump.List
+	case "zset":
+		for _, m := range dump.Zset {
+			elements = append(elements, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+		}
+	}
+	payload := []byte(aof.EncodeCommand(&parser.Command{Name: dump.Type, Args: elements}))
+	payload = binary.LittleEndian.AppendUint16(payload, DUMP_VERSION)
+	return string(binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload, crc64Table)))
+}
+
+// DecodeValue returns the value of a payload of EncodeValue as a dump of
+// key, without an expire
+func DecodeValue(key string, payload string) (store.KeyDump, error) {
+	if len(payload) < 10 {
+		return store.KeyDump{}, ErrBadPayload
+	}
+	body, footer := []byte(payload[:len(payload)-8]), []byte(payload[len(payload)-10:])
+	if binary.LittleEndian.Uint16(footer) != DUMP_VERSION ||
+		binary.LittleEndian.Uint64(footer[2:]) != crc64.Checksum(body, crc64Table) {
+		return store.KeyDump{}, ErrBadPayload
+	}
+	v, err := parser.NewStreamingParser(body[:len(body)-2]).ParseValue()
+	if err != nil || v.Type != parser.Array {
+		return store.KeyDump{}, ErrBadPayload
+	}
+	words := v.Words()
+	if len(words) == 0 {
+		return store.KeyDump{}, ErrBadPayload
+	}
+	cmd := &parser.Command{Name: words[0], Args: words[1:]}
+
+	dump := store.KeyDump{Key: key, Type: cmd.Name}
+	switch cmd.Name {
+	case "string":
+		if len(cmd.Args) != 1 {
+			return store.KeyDump{}, ErrBadPayload
+		}
+		dump.String = cmd.Args[0]
+	case "list":
+		if len(cmd.Args) == 0 {
+			return store.KeyDump{}, ErrBadPayload
+		}
+		dump.List = cmd.Args
+	case "zset":
+		if len(cmd.Args) == 0 || len(cmd.Args)%2 != 0 {
+			return store.KeyDump{}, ErrBadPayload
+		}
+		for i := 0; i < len(cmd.Args); i += 2 {
+			score, err := strconv.ParseFloat(cmd.Args[i], 64)
+			if err != nil {
+				return store.KeyDump{}, ErrBadPayload
+			}
+			dump.Zset = append(dump.Zset, store.ZMember{Member: cmd.Args[i+1], Score: score})
+		}
+	default:
+		return store.KeyDump{}, ErrBadPayload
+	}
+	return dump, nil
+}
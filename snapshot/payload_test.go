@@ -0,0 +1,33 @@
+package snapshot
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+func TestValuePayload(t *testing.T) {
+	dumps := []store.KeyDump{
+		{Key: "k", Type: "string", String: "with\r\nnewline"},
+		{Key: "k", Type: "list", List: []string{"a", "b"}},
+		{Key: "k", Type: "zset", Zset: []store.ZMember{{Member: "m", Score: 1.5}, {Member: "top", Score: 2}}},
+	}
+	for _, dump := range dumps {
+		decoded, err := DecodeValue("k", EncodeValue(dump))
+		if err != nil {
+			t.Fatalf("Error decoding the %s payload: %v", dump.Type, err)
+		}
+		if decoded.Type != dump.Type || decoded.String != dump.String || !slices.Equal(decoded.List, dump.List) || !slices.Equal(decoded.Zset, dump.Zset) {
+			t.Errorf("Expected %+v, got %+v", dump, decoded)
+		}
+	}
+
+	payload := []byte(EncodeValue(dumps[0]))
+	payload[5] ^= 1
+	for _, bad := range []string{"", "short", string(payload)} {
+		if _, err := DecodeValue("k", bad); err != ErrBadPayload {
+			t.Errorf("Expected %q to be refused, got %v", bad, err)
+		}
+	}
+}
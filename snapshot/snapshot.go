@@ -2,8 +2,59 @@ package snapshot
 
 import (
 	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
 )
 
 func Start() {
 	fmt.Println("Snapshotting started")
 }
+
+// Write writes the dataset of s as the commands recreating it, in the AOF
+// format: a SET, RPUSH or ZADD per key followed by an EXPIREAT for the keys
+// with an expire. Nothing may modify s meanwhile, see store.Dump.
+func Write(w io.Writer, s *store.Store) error {
+	var err error
+	write := func(cmd *parser.Command) {
+		if err == nil {
+			_, err = io.WriteString(w, aof.EncodeCommand(cmd))
+		}
+	}
+	s.Dump(func(dump store.KeyDump) {
+		switch dump.Type {
+		case "string":
+			write(&parser.Command{Name: "SET", Args: []string{dump.Key, dump.String}})
+		case "list":
+			write(&parser.Command{Name: "RPUSH", Args: append([]string{dump.Key}, dump.List...)})
+		case "zset":
+			args := []string{dump.Key}
+			for _, m := range dump.Zset {
+				args = append(args, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+			}
+			write(&parser.Command{Name: "ZADD", Args: args})
+		}
+		if dump.ExpireAt != 0 {
+			write(&parser.Command{Name: "EXPIREAT", Args: []string{dump.Key, strconv.FormatInt(dump.ExpireAt, 10)}})
+		}
+	})
+	return err
+}
+
+// Read runs every command of a snapshot written by Write
+func Read(data []byte, execute func(*parser.Command)) error {
+	p := parser.NewStreamingParser(data)
+	for {
+		cmd, err := p.ParseCommand()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error parsing snapshot: %w", err)
+		}
+		execute(cmd)
+	}
+}
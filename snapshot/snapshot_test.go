@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/store"
+)
+
+func TestWriteRead(t *testing.T) {
+	s := store.NewStore()
+	s.SetValue("str", "hello world")
+	s.Push("list", []string{"a", "b"}, store.LIST_TAIL)
+	s.ZAdd("zset", []store.ZMember{{Member: "m", Score: 1.5}, {Member: "top", Score: 2}})
+	s.SetTTL("str", 4102444800)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, s); err != nil {
+		t.Fatalf("Error writing the snapshot: %v", err)
+	}
+	var commands []string
+	err := Read(buf.Bytes(), func(cmd *parser.Command) {
+		commands = append(commands, strings.Join(append([]string{cmd.Name}, cmd.Args...), " "))
+	})
+	if err != nil {
+		t.Fatalf("Error reading the snapshot: %v", err)
+	}
+	slices.Sort(commands)
+	expected := []string{
+		"EXPIREAT str 4102444800",
+		"RPUSH list a b",
+		"SET str hello world",
+		"ZADD zset 1.5 m 2 top",
+	}
+	if !slices.Equal(commands, expected) {
+		t.Errorf("Expected %q, got %q", expected, commands)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	err := Read([]byte("!oops\r\n"), func(*parser.Command) {})
+	if err == nil {
+		t.Error("Expected an error for a snapshot that isn't made of commands")
+	}
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests are meant to be run with the race detector:
+//
+//	go test -race ./store
+
+const (
+	hammerGoroutines = 16
+	hammerOps        = 2000
+)
+
+func TestConcurrentIncrBy(t *testing.T) {
+	s := NewStore()
+	s.SetValue("counter", "0")
+
+	var wg sync.WaitGroup
+	for g := 0; g < hammerGoroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < hammerOps; i++ {
+				if _, err := s.IncreBy("counter", 2); err != nil {
+					t.Errorf("INCRBY failed: %v", err)
+					return
+				}
+				s.DecreBy("counter", 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value := s.GetValue("counter"); value != hammerGoroutines*hammerOps {
+		t.Errorf("Expected counter to be %d, got %v", hammerGoroutines*hammerOps, value)
+	}
+}
+
+func TestConcurrentMixedCommands(t *testing.T) {
+	s := NewStore()
+
+	var wg sync.WaitGroup
+	for g := 0; g < hammerGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < hammerOps; i++ {
+				key := fmt.Sprintf("key:%d", i%64)
+				switch (g + i) % 8 {
+				case 0:
+					s.SetValue(key, fmt.Sprintf("value-%d", i))
+				case 1:
+					s.SetValue(key, fmt.Sprintf("%d", i%20000))
+				case 2:
+					s.GetValue(key)
+				case 3:
+					s.IncreBy(key, 1)
+				case 4:
+					s.SetTTL(key, time.Now().Unix()+int64(i%3)-1)
+				case 5:
+					s.GetTTL(key)
+				case 6:
+					s.Copy(key, fmt.Sprintf("key:%d", (i+1)%64), true)
+				case 7:
+					s.DeleteValue(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// every object must still be accounted exactly once
+	for i := 0; i < 64; i++ {
+		s.DeleteValue(fmt.Sprintf("key:%d", i))
+	}
+	if s.UsedMemory() != 0 {
+		t.Errorf("Expected used memory to be 0 after deleting everything, got %d", s.UsedMemory())
+	}
+}
+
+func TestConcurrentEviction(t *testing.T) {
+	s := NewStore()
+	s.SetMaxMemoryPolicy("allkeys-lru")
+	s.SetMaxMemory(16 * 1024)
+
+	var wg sync.WaitGroup
+	for g := 0; g < hammerGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < hammerOps; i++ {
+				if err := s.FreeMemoryIfNeeded(); err != nil {
+					t.Errorf("Expected eviction to succeed, got %v", err)
+					return
+				}
+				key := fmt.Sprintf("key:%d:%d", g, i)
+				s.SetValue(key, "some value that takes a bit of room")
+				s.GetValue(fmt.Sprintf("key:%d:%d", g, i/2))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := s.FreeMemoryIfNeeded(); err != nil {
+		t.Errorf("Expected eviction to succeed, got %v", err)
+	}
+	if s.UsedMemory() > s.GetMaxMemory() {
+		t.Errorf("Expected used memory %d to be under %d", s.UsedMemory(), s.GetMaxMemory())
+	}
+	if s.EvictedKeys() == 0 {
+		t.Error("Expected keys to be evicted")
+	}
+}
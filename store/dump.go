@@ -0,0 +1,128 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBusyKey is returned when restoring a key that exists without replacing it
+var ErrBusyKey = errors.New("BUSYKEY Target key name already exists.")
+
+// KeyDump is a key of the dataset with a copy of its value, see Dump
+type KeyDump struct {
+	Key      string
+	Type     string    // "string", "list" or "zset"
+	String   string    // value of a string
+	List     []string  // elements of a list, head first
+	Zset     []ZMember // members of a sorted set, in order
+	ExpireAt int64     // unix time the key expires at, 0 if it doesn't
+}
+
+// Dump calls fn for every key of the dataset, the expired ones excepted. The
+// shards are locked one after the other, so the keys are only consistent
+// with each other if nothing modifies the dataset meanwhile.
+func (s *Store) Dump(fn func(KeyDump)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key := range sh.Dict {
+			obj, exists := s.lookupNoTouch(sh, key)
+			if !exists {
+				continue
+			}
+			fn(dumpObj(sh, key, obj))
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// dumpObj returns a copy of the object stored at key in the (locked) shard
+func dumpObj(sh *shard, key string, obj *kvObj) KeyDump {
+	dump := KeyDump{Key: key, Type: typeNames[obj.getType()], ExpireAt: sh.Expiry[key]}
+	switch obj.getType() {
+	case OBJ_STRING:
+		dump.String = obj.stringValue()
+	case OBJ_LIST:
+		dump.List = make([]string, 0, obj.list.Len())
+		for e := obj.list.Front(); e != nil; e = e.Next() {
+			dump.List = append(dump.List, e.Value.(string))
+		}
+	case OBJ_ZSET:
+		dump.Zset = append([]ZMember(nil), obj.zset.sorted...)
+	}
+	return dump
+}
+
+// DumpKey returns a copy of the value of a key, false if there is no key
+func (s *Store) DumpKey(key string) (KeyDump, bool) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return KeyDump{}, false
+	}
+	return dumpObj(sh, key, obj), true
+}
+
+// Restore creates the key of a dump with its value and expire. Without
+// replace it fails with ErrBusyKey if the key exists. A dump that already
+// expired deletes the key.
+func (s *Store) Restore(dump KeyDump, replace bool) error {
+	sh := s.lockShard(dump.Key)
+	defer sh.mu.Unlock()
+
+	if _, exists := s.lookupNoTouch(sh, dump.Key); exists && !replace {
+		return ErrBusyKey
+	}
+	if dump.ExpireAt != 0 && time.Now().Unix() > dump.ExpireAt {
+		if _, exists := sh.Dict[dump.Key]; exists {
+			s.deleteKey(sh, dump.Key)
+			s.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", dump.Key)
+		}
+		return nil
+	}
+
+	var obj *kvObj
+	switch dump.Type {
+	case "string":
+		obj = createStringObj(dump.String)
+		if obj.tryIntEncoding() {
+			obj = s.newIntObj(obj.num)
+		}
+	case "list":
+		obj = createListObj()
+		for _, value := range dump.List {
+			obj.list.PushBack(value)
+		}
+	case "zset":
+		obj = createZsetObj()
+		for _, m := range dump.Zset {
+			obj.zset.add(m)
+		}
+	default:
+		return fmt.Errorf("ERR Bad data format")
+	}
+	s.putObj(sh, dump.Key, obj)
+	if dump.ExpireAt != 0 {
+		s.setExpiry(sh, dump.Key, dump.ExpireAt)
+	} else {
+		s.removeExpiry(sh, dump.Key)
+	}
+	s.notifyKeyspaceEvent(NOTIFY_GENERIC, "restore", dump.Key)
+	return nil
+}
+
+// Flush deletes every key
+func (s *Store) Flush() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key := range sh.Dict {
+			s.deleteKey(sh, key)
+		}
+		sh.mu.Unlock()
+	}
+	s.avgTTL.Store(0)
+}
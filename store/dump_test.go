@@ -0,0 +1,99 @@
+package store
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDump(t *testing.T) {
+	s := NewStore()
+	s.SetValue("str", "hello")
+	s.SetValue("num", "42")
+	s.Push("list", []string{"a", "b", "c"}, LIST_TAIL)
+	s.ZAdd("zset", []ZMember{{Member: "y", Score: 2}, {Member: "x", Score: 1}})
+	expireAt := time.Now().Unix() + 100
+	s.SetTTL("str", expireAt)
+	s.SetValue("expired", "v")
+	s.SetTTL("expired", time.Now().Unix()-10)
+
+	dumps := make(map[string]KeyDump)
+	s.Dump(func(dump KeyDump) { dumps[dump.Key] = dump })
+	if len(dumps) != 4 {
+		t.Fatalf("Expected 4 keys, the expired one excepted, got %v", dumps)
+	}
+	if d := dumps["str"]; d.Type != "string" || d.String != "hello" || d.ExpireAt != expireAt {
+		t.Errorf("Unexpected dump of a string with an expire: %+v", d)
+	}
+	if d := dumps["num"]; d.Type != "string" || d.String != "42" || d.ExpireAt != 0 {
+		t.Errorf("Unexpected dump of an int encoded string: %+v", d)
+	}
+	if d := dumps["list"]; d.Type != "list" || !slices.Equal(d.List, []string{"a", "b", "c"}) {
+		t.Errorf("Unexpected dump of a list: %+v", d)
+	}
+	if d := dumps["zset"]; d.Type != "zset" || !slices.Equal(d.Zset, []ZMember{{"x", 1}, {"y", 2}}) {
+		t.Errorf("Unexpected dump of a sorted set: %+v", d)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	s := NewStore()
+	s.SetValue("a", "1")
+	s.Push("list", []string{"a"}, LIST_HEAD)
+	s.SetTTL("a", time.Now().Unix()+100)
+	w := NewWatcher()
+	s.Watch("a", w)
+
+	s.Flush()
+	if s.Exists("a") || s.Exists("list") {
+		t.Error("Expected no key left")
+	}
+	if !w.IsDirty() {
+		t.Error("Expected the watched key to be flagged")
+	}
+	if used := s.UsedMemory(); used != 0 {
+		t.Errorf("Expected no memory used, got %d", used)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s := NewStore()
+	s.Push("list", []string{"a", "b"}, LIST_TAIL)
+	s.SetTTL("list", time.Now().Unix()+100)
+	dump, ok := s.DumpKey("list")
+	if !ok || !slices.Equal(dump.List, []string{"a", "b"}) {
+		t.Fatalf("Unexpected dump %+v", dump)
+	}
+	if _, ok := s.DumpKey("nosuch"); ok {
+		t.Error("Expected no dump for a missing key")
+	}
+
+	if err := s.Restore(dump, false); err != ErrBusyKey {
+		t.Errorf("Expected an existing key not to be replaced, got %v", err)
+	}
+	dump.Key = "copy"
+	if err := s.Restore(dump, false); err != nil {
+		t.Fatalf("Error restoring: %v", err)
+	}
+	if values, _ := s.ListRange("copy", 0, -1); !slices.Equal(values, []string{"a", "b"}) || s.GetTTL("copy") <= 0 {
+		t.Errorf("Expected the list to be restored with its expire, got %v with TTL %d", values, s.GetTTL("copy"))
+	}
+	s.Push("copy", []string{"c"}, LIST_TAIL)
+	if n, _ := s.ListLen("list"); n != 2 {
+		t.Errorf("Expected the restored list to be independent, got %d elements", n)
+	}
+
+	if err := s.Restore(KeyDump{Key: "copy", Type: "string", String: "7"}, true); err != nil {
+		t.Fatalf("Error replacing: %v", err)
+	}
+	if v := s.GetValue("copy"); v != 7 || s.GetTTL("copy") != -1 {
+		t.Errorf("Expected a persistent integer, got %v with TTL %d", v, s.GetTTL("copy"))
+	}
+	if err := s.Restore(KeyDump{Key: "copy", Type: "string", String: "v", ExpireAt: 1}, true); err != nil || s.Exists("copy") {
+		t.Errorf("Expected an expired dump to delete the key, got %v", err)
+	}
+	s.DeleteValue("list")
+	if used := s.UsedMemory(); used != 0 {
+		t.Errorf("Expected no memory used once everything is deleted, got %d", used)
+	}
+}
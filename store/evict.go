@@ -0,0 +1,420 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Maxmemory policies are built from flags the same way Redis does it, so the
+// eviction code can ask "is this an LFU policy?" or "may I evict any key?"
+// without switching on every policy.
+const (
+	MAXMEMORY_FLAG_LRU     = 1 << 0
+	MAXMEMORY_FLAG_LFU     = 1 << 1
+	MAXMEMORY_FLAG_ALLKEYS = 1 << 2
+
+	MAXMEMORY_VOLATILE_LRU    = (0 << 8) | MAXMEMORY_FLAG_LRU
+	MAXMEMORY_VOLATILE_LFU    = (1 << 8) | MAXMEMORY_FLAG_LFU
+	MAXMEMORY_VOLATILE_TTL    = (2 << 8)
+	MAXMEMORY_VOLATILE_RANDOM = (3 << 8)
+	MAXMEMORY_ALLKEYS_LRU     = (4 << 8) | MAXMEMORY_FLAG_LRU | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_ALLKEYS_LFU     = (5 << 8) | MAXMEMORY_FLAG_LFU | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_ALLKEYS_RANDOM  = (6 << 8) | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_NO_EVICTION     = (7 << 8)
+)
+
+const (
+	EVPOOL_SIZE               = 16 // entries kept in the eviction pool
+	MAXMEMORY_SAMPLES_DEFAULT = 5  // keys sampled per pool refill
+	LFU_LOG_FACTOR_DEFAULT    = 10
+	LFU_DECAY_TIME_DEFAULT    = 1 // minutes
+)
+
+// ErrOOM is returned when memory is above maxmemory and nothing can be evicted.
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+var maxMemoryPolicies = map[string]int{
+	"volatile-lru":    MAXMEMORY_VOLATILE_LRU,
+	"volatile-lfu":    MAXMEMORY_VOLATILE_LFU,
+	"volatile-random": MAXMEMORY_VOLATILE_RANDOM,
+	"volatile-ttl":    MAXMEMORY_VOLATILE_TTL,
+	"allkeys-lru":     MAXMEMORY_ALLKEYS_LRU,
+	"allkeys-lfu":     MAXMEMORY_ALLKEYS_LFU,
+	"allkeys-random":  MAXMEMORY_ALLKEYS_RANDOM,
+	"noeviction":      MAXMEMORY_NO_EVICTION,
+}
+
+// evictionPoolEntry is a candidate key with its idle score. The pool is kept
+// sorted by ascending idle so the best candidate is always the last entry.
+type evictionPoolEntry struct {
+	idle uint64
+	key  string
+}
+
+// SetMaxMemory sets the memory limit in bytes, 0 disables the limit
+func (s *Store) SetMaxMemory(bytes int64) {
+	s.maxMemory.Store(bytes)
+}
+
+func (s *Store) GetMaxMemory() int64 {
+	return s.maxMemory.Load()
+}
+
+// SetMaxMemoryPolicy sets the eviction policy by its Redis name (e.g. "allkeys-lru")
+func (s *Store) SetMaxMemoryPolicy(name string) error {
+	policy, ok := maxMemoryPolicies[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("invalid maxmemory policy: %s", name)
+	}
+	s.maxMemoryPolicy.Store(int32(policy))
+	return nil
+}
+
+func (s *Store) GetMaxMemoryPolicy() string {
+	current := int(s.maxMemoryPolicy.Load())
+	for name, policy := range maxMemoryPolicies {
+		if policy == current {
+			return name
+		}
+	}
+	return "noeviction"
+}
+
+// SetMaxMemorySamples sets how many keys are sampled per eviction round
+func (s *Store) SetMaxMemorySamples(samples int) {
+	if samples > 0 {
+		s.maxMemorySamples.Store(int32(samples))
+	}
+}
+
+// GetMaxMemorySamples returns how many keys are sampled per eviction round
+func (s *Store) GetMaxMemorySamples() int {
+	return int(s.maxMemorySamples.Load())
+}
+
+// UsedMemory returns the estimated number of bytes used by the keyspace
+func (s *Store) UsedMemory() int64 {
+	return s.usedMemory.Load()
+}
+
+// PeakMemory returns the highest value UsedMemory has reached
+func (s *Store) PeakMemory() int64 {
+	return s.peakMemory.Load()
+}
+
+func (s *Store) updatePeakMemory() {
+	used := s.usedMemory.Load()
+	for {
+		peak := s.peakMemory.Load()
+		if used <= peak || s.peakMemory.CompareAndSwap(peak, used) {
+			return
+		}
+	}
+}
+
+// SetEvictionLatencyHook sets a function called with the time every round
+// of evictions took. It must be set before the store is used concurrently.
+func (s *Store) SetEvictionLatencyHook(hook func(latency time.Duration)) {
+	s.evictionLatencyHook = hook
+}
+
+// SetEvictedKeyHook sets a function called with every key evicted: the
+// server uses it to propagate the evictions as DELs. It is called with no
+// shard locked, but must not evict. It must be set before the store is used
+// concurrently.
+func (s *Store) SetEvictedKeyHook(hook func(key string)) {
+	s.evictedKeyHook = hook
+}
+
+// FreeMemoryIfNeeded evicts keys according to the maxmemory policy until the
+// used memory is back under the limit. It returns ErrOOM if the limit is
+// exceeded and the policy doesn't allow (or can't find) anything to evict.
+func (s *Store) FreeMemoryIfNeeded() error {
+	maxMemory := s.maxMemory.Load()
+	if maxMemory == 0 || s.usedMemory.Load() <= maxMemory {
+		return nil
+	}
+	if s.maxMemoryPolicy.Load() == MAXMEMORY_NO_EVICTION {
+		return ErrOOM
+	}
+
+	// a single goroutine evicts at a time, the others wait for it and
+	// usually find memory back under the limit
+	s.evictionMu.Lock()
+	defer s.evictionMu.Unlock()
+	if s.evictionLatencyHook != nil {
+		start := time.Now()
+		defer func() { s.evictionLatencyHook(time.Since(start)) }()
+	}
+
+	for s.usedMemory.Load() > maxMemory {
+		key, evicted := s.evictOne()
+		if !evicted {
+			return ErrOOM
+		}
+		s.evictedKeys.Add(1)
+		if s.evictedKeyHook != nil {
+			s.evictedKeyHook(key)
+		}
+	}
+	return nil
+}
+
+// evictOne picks a key according to the policy and deletes it. It returns
+// the key, or false if there is nothing left that may be evicted.
+func (s *Store) evictOne() (string, bool) {
+	policy := s.maxMemoryPolicy.Load()
+	allKeys := policy&MAXMEMORY_FLAG_ALLKEYS != 0
+
+	if policy == MAXMEMORY_ALLKEYS_RANDOM || policy == MAXMEMORY_VOLATILE_RANDOM {
+		// start from a random shard and delete the first key found
+		start := rand.Intn(STORE_SHARDS)
+		for i := 0; i < STORE_SHARDS; i++ {
+			sh := &s.shards[(start+i)%STORE_SHARDS]
+			sh.mu.Lock()
+			key, found := "", false
+			if allKeys {
+				for k := range sh.Dict {
+					key, found = k, true
+					break
+				}
+			} else {
+				for k := range sh.Expiry {
+					key, found = k, true
+					break
+				}
+			}
+			if found {
+				s.evictKey(sh, key)
+				sh.mu.Unlock()
+				return key, true
+			}
+			sh.mu.Unlock()
+		}
+		return "", false
+	}
+
+	// LRU, LFU and volatile-ttl all go through the eviction pool
+	for {
+		if !s.evictionPoolPopulate(allKeys) {
+			return "", false
+		}
+
+		// walk the pool from the best candidate down, skipping ghosts:
+		// keys that were deleted or lost their TTL since they were sampled
+		for i := EVPOOL_SIZE - 1; i >= 0; i-- {
+			entry := s.evictionPool[i]
+			if entry.key == "" {
+				continue
+			}
+			s.evictionPoolRemove(i)
+
+			sh := s.lockShard(entry.key)
+			_, exists := sh.Dict[entry.key]
+			if !allKeys {
+				_, exists = sh.Expiry[entry.key]
+			}
+			if exists {
+				s.evictKey(sh, entry.key)
+			}
+			sh.mu.Unlock()
+			if exists {
+				return entry.key, true
+			}
+		}
+	}
+}
+
+// evictKey deletes a key of the (locked) shard to free memory
+func (s *Store) evictKey(sh *shard, key string) {
+	s.deleteKey(sh, key)
+	s.notifyKeyspaceEvent(NOTIFY_EVICTED, "evicted", key)
+}
+
+// evictionPoolPopulate samples maxmemory-samples keys, starting from a
+// random shard, and inserts the ones that are better candidates than what
+// is already in the pool. It returns false if there was no key to sample.
+func (s *Store) evictionPoolPopulate(allKeys bool) bool {
+	samples := int(s.maxMemorySamples.Load())
+	sampled := 0
+	start := rand.Intn(STORE_SHARDS)
+	for i := 0; i < STORE_SHARDS && sampled < samples; i++ {
+		sh := &s.shards[(start+i)%STORE_SHARDS]
+		sh.mu.Lock()
+		if allKeys {
+			for key, obj := range sh.Dict {
+				if sampled >= samples {
+					break
+				}
+				s.evictionPoolInsert(key, s.evictionScore(sh, key, obj))
+				sampled++
+			}
+		} else {
+			for key := range sh.Expiry {
+				if sampled >= samples {
+					break
+				}
+				if obj, exists := sh.Dict[key]; exists {
+					s.evictionPoolInsert(key, s.evictionScore(sh, key, obj))
+				}
+				sampled++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return sampled > 0
+}
+
+// evictionScore returns how good a candidate the key is, higher is better
+func (s *Store) evictionScore(sh *shard, key string, obj *kvObj) uint64 {
+	policy := s.maxMemoryPolicy.Load()
+	switch {
+	case policy&MAXMEMORY_FLAG_LRU != 0:
+		return s.estimateObjectIdleTime(obj)
+	case policy&MAXMEMORY_FLAG_LFU != 0:
+		// invert the frequency so that a higher value is a better candidate
+		return 255 - uint64(s.lfuDecrAndReturn(obj))
+	case policy == MAXMEMORY_VOLATILE_TTL:
+		// sooner expire is a better candidate
+		return math.MaxUint64 - uint64(sh.Expiry[key])
+	}
+	return 0
+}
+
+func (s *Store) evictionPoolInsert(key string, idle uint64) {
+	pool := s.evictionPool
+	for i := range pool {
+		if pool[i].key == key {
+			// already a candidate, just drop the stale score
+			s.evictionPoolRemove(i)
+			break
+		}
+	}
+
+	// find the first slot that is empty or has a higher idle score
+	k := 0
+	for k < EVPOOL_SIZE && pool[k].key != "" && pool[k].idle < idle {
+		k++
+	}
+	if k == 0 && pool[EVPOOL_SIZE-1].key != "" {
+		// worse than every candidate in a full pool
+		return
+	}
+	if k < EVPOOL_SIZE && pool[k].key == "" {
+		// empty slot, insert in place
+	} else if pool[EVPOOL_SIZE-1].key == "" {
+		// free space on the right, shift everything from k to the right
+		copy(pool[k+1:], pool[k:EVPOOL_SIZE-1])
+	} else {
+		// no free space, drop the worst candidate on the left
+		k--
+		copy(pool[:k], pool[1:k+1])
+	}
+	pool[k] = evictionPoolEntry{idle: idle, key: key}
+}
+
+func (s *Store) evictionPoolRemove(i int) {
+	pool := s.evictionPool
+	copy(pool[i:], pool[i+1:])
+	pool[EVPOOL_SIZE-1] = evictionPoolEntry{}
+}
+
+// getLRUClock returns the current LRU clock with LRU_CLOCK_RESOLUTION precision
+func getLRUClock() uint32 {
+	return uint32(time.Now().UnixMilli()/LRU_CLOCK_RESOLUTION) & LRU_CLOCK_MAX
+}
+
+// estimateObjectIdleTime returns the idle time in milliseconds, handling the
+// wrap around of the 24 bit clock
+func (s *Store) estimateObjectIdleTime(obj *kvObj) uint64 {
+	clock := getLRUClock()
+	lru := obj.getLRU()
+	if clock >= lru {
+		return uint64(clock-lru) * LRU_CLOCK_RESOLUTION
+	}
+	return uint64(clock+(LRU_CLOCK_MAX-lru)) * LRU_CLOCK_RESOLUTION
+}
+
+/*
+With an LFU policy the 24 lru bits are split in two:
+
+	16 bits      8 bits
+	+----------+---------+
+	| minutes  | counter |
+	+----------+---------+
+
+minutes is the last time the counter was decremented and counter is a
+logarithmic access frequency.
+*/
+func lfuGetTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 65535
+}
+
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuGetTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return 65535 - ldt + now
+}
+
+// lfuLogIncr increments the counter with a probability that gets lower the
+// higher the counter already is
+func (s *Store) lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return 255
+	}
+	baseval := float64(counter) - LFU_INIT_VAL
+	if baseval < 0 {
+		baseval = 0
+	}
+	p := 1.0 / (baseval*float64(s.lfuLogFactor.Load()) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn decays the counter by one for every lfuDecayTime minutes
+// elapsed since the last decrement
+func (s *Store) lfuDecrAndReturn(obj *kvObj) uint8 {
+	lru := obj.getLRU()
+	ldt := lru >> 8
+	counter := lru & 255
+	if decayTime := s.lfuDecayTime.Load(); decayTime > 0 {
+		periods := lfuTimeElapsed(ldt) / uint32(decayTime)
+		if periods > counter {
+			return 0
+		}
+		return uint8(counter - periods)
+	}
+	return uint8(counter)
+}
+
+// initLRU sets the lru bits of a freshly created object
+func (s *Store) initLRU(obj *kvObj) {
+	if s.maxMemoryPolicy.Load()&MAXMEMORY_FLAG_LFU != 0 {
+		obj.setLRU(lfuGetTimeInMinutes()<<8 | LFU_INIT_VAL)
+	} else {
+		obj.setLRU(getLRUClock())
+	}
+}
+
+// touch updates the access time (or frequency) of the object stored at key.
+// Shared objects have no lru bits of their own and are left alone.
+func (s *Store) touch(obj *kvObj) {
+	if obj.isShared() {
+		return
+	}
+	if s.maxMemoryPolicy.Load()&MAXMEMORY_FLAG_LFU != 0 {
+		counter := s.lfuDecrAndReturn(obj)
+		counter = s.lfuLogIncr(counter)
+		obj.setLRU(lfuGetTimeInMinutes()<<8 | uint32(counter))
+	} else {
+		obj.setLRU(getLRUClock())
+	}
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUsedMemoryAccounting(t *testing.T) {
+	s := NewStore()
+
+	s.SetValue("str", "hello")
+	s.SetValue("num", "42")
+	s.SetTTL("str", time.Now().Unix()+3600)
+	if s.UsedMemory() <= 0 {
+		t.Fatalf("Expected used memory to grow, got %d", s.UsedMemory())
+	}
+
+	// overwriting a key must not double count it
+	before := s.UsedMemory()
+	s.SetValue("num", "43")
+	if s.UsedMemory() != before {
+		t.Errorf("Expected used memory to stay %d after overwrite, got %d", before, s.UsedMemory())
+	}
+
+	s.DeleteValue("str")
+	s.DeleteValue("num")
+	if s.UsedMemory() != 0 {
+		t.Errorf("Expected used memory to be 0 after deleting everything, got %d", s.UsedMemory())
+	}
+}
+
+func TestSetMaxMemoryPolicy(t *testing.T) {
+	s := NewStore()
+	if s.GetMaxMemoryPolicy() != "noeviction" {
+		t.Errorf("Expected default policy noeviction, got %s", s.GetMaxMemoryPolicy())
+	}
+	if err := s.SetMaxMemoryPolicy("ALLKEYS-LRU"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if s.GetMaxMemoryPolicy() != "allkeys-lru" {
+		t.Errorf("Expected allkeys-lru, got %s", s.GetMaxMemoryPolicy())
+	}
+	if err := s.SetMaxMemoryPolicy("invalid"); err == nil {
+		t.Error("Expected error for invalid policy, got nil")
+	}
+}
+
+func TestFreeMemoryIfNeeded(t *testing.T) {
+	fill := func(s *Store, n int) {
+		for i := 0; i < n; i++ {
+			s.SetValue(fmt.Sprintf("key:%d", i), fmt.Sprintf("value-%d", i))
+		}
+	}
+
+	t.Run("no limit", func(t *testing.T) {
+		s := NewStore()
+		fill(s, 100)
+		if err := s.FreeMemoryIfNeeded(); err != nil {
+			t.Errorf("Expected no error without maxmemory, got %v", err)
+		}
+	})
+
+	t.Run("noeviction returns OOM", func(t *testing.T) {
+		s := NewStore()
+		fill(s, 100)
+		s.SetMaxMemory(s.UsedMemory() / 2)
+		if err := s.FreeMemoryIfNeeded(); err != ErrOOM {
+			t.Errorf("Expected ErrOOM, got %v", err)
+		}
+		if s.KeyCount() != 100 {
+			t.Errorf("Expected no keys to be evicted, got %d keys", s.KeyCount())
+		}
+	})
+
+	for _, policy := range []string{"allkeys-lru", "allkeys-lfu", "allkeys-random"} {
+		t.Run(policy, func(t *testing.T) {
+			s := NewStore()
+			s.SetMaxMemoryPolicy(policy)
+			fill(s, 100)
+			s.SetMaxMemory(s.UsedMemory() / 2)
+			if err := s.FreeMemoryIfNeeded(); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if s.UsedMemory() > s.GetMaxMemory() {
+				t.Errorf("Expected used memory %d to be under %d", s.UsedMemory(), s.GetMaxMemory())
+			}
+			if s.EvictedKeys() == 0 {
+				t.Error("Expected some keys to be evicted")
+			}
+		})
+	}
+
+	t.Run("volatile policy without expiring keys returns OOM", func(t *testing.T) {
+		s := NewStore()
+		s.SetMaxMemoryPolicy("volatile-lru")
+		fill(s, 100)
+		s.SetMaxMemory(s.UsedMemory() / 2)
+		if err := s.FreeMemoryIfNeeded(); err != ErrOOM {
+			t.Errorf("Expected ErrOOM, got %v", err)
+		}
+	})
+
+	t.Run("volatile policies only evict keys with a TTL", func(t *testing.T) {
+		for _, policy := range []string{"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl"} {
+			s := NewStore()
+			s.SetMaxMemoryPolicy(policy)
+			fill(s, 100)
+			for i := 0; i < 100; i += 2 {
+				s.SetTTL(fmt.Sprintf("key:%d", i), time.Now().Unix()+int64(3600+i))
+			}
+			s.SetMaxMemory(s.UsedMemory() - 1)
+			if err := s.FreeMemoryIfNeeded(); err != nil {
+				t.Errorf("%s: expected no error, got %v", policy, err)
+			}
+			for i := 1; i < 100; i += 2 {
+				if !s.Exists(fmt.Sprintf("key:%d", i)) {
+					t.Errorf("%s: key:%d has no TTL and must not be evicted", policy, i)
+				}
+			}
+		}
+	})
+}
+
+func TestEvictionPicksBestCandidate(t *testing.T) {
+	t.Run("allkeys-lru evicts the idlest key", func(t *testing.T) {
+		s := NewStore()
+		s.SetMaxMemoryPolicy("allkeys-lru")
+		s.SetMaxMemorySamples(10)
+		for i := 0; i < 10; i++ {
+			s.SetValue(fmt.Sprintf("key:%d", i), "value")
+		}
+		// make key:3 look like it was last accessed an hour ago
+		obj := s.objectAt("key:3")
+		obj.setLRU(getLRUClock() - 3600)
+
+		s.SetMaxMemory(s.UsedMemory() - 1)
+		s.FreeMemoryIfNeeded()
+		if s.Exists("key:3") {
+			t.Error("Expected key:3 to be evicted first")
+		}
+		if s.KeyCount() != 9 {
+			t.Errorf("Expected exactly one key to be evicted, got %d keys left", s.KeyCount())
+		}
+	})
+
+	t.Run("volatile-ttl evicts the key closest to expiring", func(t *testing.T) {
+		s := NewStore()
+		s.SetMaxMemoryPolicy("volatile-ttl")
+		s.SetMaxMemorySamples(10)
+		now := time.Now().Unix()
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			s.SetValue(key, "value")
+			s.SetTTL(key, now+int64(1000+i))
+		}
+		s.SetTTL("key:7", now+10)
+
+		s.SetMaxMemory(s.UsedMemory() - 1)
+		s.FreeMemoryIfNeeded()
+		if s.Exists("key:7") {
+			t.Error("Expected key:7 to be evicted first")
+		}
+	})
+}
+
+func TestLFUCounter(t *testing.T) {
+	s := NewStore()
+	s.SetMaxMemoryPolicy("allkeys-lfu")
+	s.SetValue("hot", "value")
+
+	obj := s.objectAt("hot")
+	if s.lfuDecrAndReturn(obj) != LFU_INIT_VAL {
+		t.Errorf("Expected new object counter to be %d, got %d", LFU_INIT_VAL, s.lfuDecrAndReturn(obj))
+	}
+	for i := 0; i < 1000; i++ {
+		s.GetValue("hot")
+	}
+	obj = s.objectAt("hot")
+	if s.lfuDecrAndReturn(obj) <= LFU_INIT_VAL {
+		t.Errorf("Expected counter to grow with accesses, got %d", s.lfuDecrAndReturn(obj))
+	}
+}
+
+// objectAt returns the object stored at key, for tests that need to look
+// at or tweak the object itself
+func (s *Store) objectAt(key string) *kvObj {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+	return sh.Dict[key]
+}
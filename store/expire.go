@@ -0,0 +1,67 @@
+package store
+
+import "time"
+
+const (
+	ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP    = 20                    // keys with an expire sampled per shard and loop
+	ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE = 10                    // % of expired keys in a sample to move to the next shard
+	ACTIVE_EXPIRE_CYCLE_TIME_LIMIT       = 25 * time.Millisecond // time budget of a cycle
+)
+
+// expireKey deletes a key of the (locked) shard whose expire is in the past
+func (s *Store) expireKey(sh *shard, key string) {
+	s.deleteKey(sh, key)
+	s.expiredKeys.Add(1)
+	s.notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key)
+}
+
+// ActiveExpireCycle deletes expired keys nobody accesses anymore, which lazy
+// expiration would keep forever. Like Redis it samples keys with an expire
+// in every shard, and samples the same shard again while many of the sampled
+// keys were expired, within a time budget. The TTLs of the keys left in the
+// samples update the average TTL, which is back to 0 once no key has an
+// expire. It returns the number of keys deleted and
+// is meant to be called periodically.
+func (s *Store) ActiveExpireCycle() int {
+	start := time.Now()
+	now := start.Unix()
+	expired, withTTL := 0, int64(0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		for {
+			sampled, expiredInSample := 0, 0
+			var ttlSum, ttlSamples int64
+			sh.mu.Lock()
+			for key, expireAt := range sh.Expiry {
+				if sampled == ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP {
+					break
+				}
+				sampled++
+				if now > expireAt {
+					s.expireKey(sh, key)
+					expiredInSample++
+				} else {
+					ttlSum += expireAt*1000 - start.UnixMilli()
+					ttlSamples++
+				}
+			}
+			sh.mu.Unlock()
+			expired += expiredInSample
+			if ttlSamples > 0 {
+				s.updateAvgTTL(ttlSum / ttlSamples)
+				withTTL += ttlSamples
+			}
+
+			if time.Since(start) > ACTIVE_EXPIRE_CYCLE_TIME_LIMIT {
+				return expired
+			}
+			if sampled == 0 || expiredInSample*100/sampled <= ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE {
+				break
+			}
+		}
+	}
+	if withTTL == 0 {
+		s.avgTTL.Store(0)
+	}
+	return expired
+}
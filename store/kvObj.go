@@ -1,6 +1,10 @@
 package store
 
 import (
+	"container/list"
+	"math"
+	"strconv"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -14,73 +18,238 @@ const (
 )
 
 const (
-	OBJ_ENCODING_RAW    = 0
-	OBJ_ENCODING_INT    = 1
-	OBJ_ENCODING_HT     = 2
-	OBJ_ENCODING_ZIPMAP = 3
+	OBJ_ENCODING_RAW        = 0
+	OBJ_ENCODING_INT        = 1
+	OBJ_ENCODING_HT         = 2
+	OBJ_ENCODING_ZIPMAP     = 3
+	OBJ_ENCODING_LINKEDLIST = 4 // lists, see list.go
+	OBJ_ENCODING_SKIPLIST   = 7 // sorted sets, see zset.go
 	// ... etc
 )
 
+const (
+	LRU_BITS             = 24
+	LRU_CLOCK_MAX        = (1 << LRU_BITS) - 1 // max value of the lru field
+	LRU_CLOCK_RESOLUTION = 1000                // lru clock resolution in ms
+	LFU_INIT_VAL         = 5                   // initial counter of a new object under LFU
+)
+
+/*
+kvObj is the object stored for every key.
+
+Like robj in Redis the type, the encoding and the lru clock are packed in a
+single 32 bit word:
+
+	4 bits   4 bits     24 bits
+	+------+----------+-------------+
+	| type | encoding | lru         |
+	+------+----------+-------------+
+
+The value lives in the field matching the encoding, so no pointer casts are
+needed to read it and an int doesn't need an allocation of its own.
+
+An object can be referenced by keys living in different shards (COPY, shared
+integers), so meta and refcount are only accessed atomically. The value
+itself is only modified in place while a single key references it.
+*/
 type kvObj struct {
-	/* 
-	In Go, we can't use bitfields like C, so we need to pack these manually
-	The first byte contains: type (4 bits) and encoding (4 bits)
-	*/
-	typeAndEncoding uint8
-	/*
-	 lru is 24 bits (3 bytes) in C, we'll use uint32 and manually handle it
-	 only lower 24 bits used
-	*/
-	lru uint32 
+	meta     uint32
 	refcount int32
-	ptr      unsafe.Pointer
+	num      int        // value of OBJ_ENCODING_INT objects
+	str      string     // value of OBJ_ENCODING_RAW objects
+	list     *list.List // value of OBJ_ENCODING_LINKEDLIST objects
+	zset     *zset      // value of OBJ_ENCODING_SKIPLIST objects
+}
+
+// setMeta replaces the bits selected by mask with bits
+func (r *kvObj) setMeta(mask uint32, bits uint32) {
+	for {
+		old := atomic.LoadUint32(&r.meta)
+		if atomic.CompareAndSwapUint32(&r.meta, old, (old&^mask)|(bits&mask)) {
+			return
+		}
+	}
 }
 
 // Helper methods to get/set the bitfield values
 func (r *kvObj) getType() uint8 {
-	return r.typeAndEncoding >> 4
+	return uint8(atomic.LoadUint32(&r.meta) >> 28)
 }
 
 func (r *kvObj) setType(t uint8) {
-	r.typeAndEncoding = (r.typeAndEncoding & 0x0F) | (t << 4)
+	r.setMeta(0xF0000000, uint32(t)<<28)
 }
 
 func (r *kvObj) getEncoding() uint8 {
-	return r.typeAndEncoding & 0x0F
+	return uint8(atomic.LoadUint32(&r.meta)>>24) & 0x0F
 }
 
 func (r *kvObj) setEncoding(e uint8) {
-	r.typeAndEncoding = (r.typeAndEncoding & 0xF0) | (e & 0x0F)
+	r.setMeta(0x0F000000, uint32(e)<<24)
 }
 
 func (r *kvObj) getLRU() uint32 {
-	return r.lru & 0xFFFFFF // mask to 24 bits
+	return atomic.LoadUint32(&r.meta) & LRU_CLOCK_MAX // mask to 24 bits
 }
 
 func (r *kvObj) setLRU(lru uint32) {
-	r.lru = lru & 0xFFFFFF // ensure only 24 bits
+	r.setMeta(LRU_CLOCK_MAX, lru) // ensure only 24 bits
+}
+
+func (r *kvObj) getRefCount() int32 {
+	return atomic.LoadInt32(&r.refcount)
+}
+
+// intValue returns the value of an int encoded object
+func (r *kvObj) intValue() (int, bool) {
+	if r.getEncoding() != OBJ_ENCODING_INT {
+		return 0, false
+	}
+	return r.num, true
+}
+
+// stringValue returns the value as a string whatever the string encoding is
+func (r *kvObj) stringValue() string {
+	if r.getEncoding() == OBJ_ENCODING_INT {
+		return strconv.Itoa(r.num)
+	}
+	return r.str
+}
+
+// value returns the Go value matching the encoding: int for int encoded
+// objects, string for raw encoded ones
+func (r *kvObj) value() interface{} {
+	switch r.getEncoding() {
+	case OBJ_ENCODING_INT:
+		return r.num
+	case OBJ_ENCODING_RAW:
+		return r.str
+	}
+	return nil
+}
+
+// tryIntEncoding converts a raw string holding a canonical integer ("12"
+// but not "012" or "+12", so GET returns exactly what SET was given) to
+// the int encoding. It returns true if the object is int encoded afterwards.
+func (r *kvObj) tryIntEncoding() bool {
+	if r.getType() != OBJ_STRING {
+		return false
+	}
+	if r.getEncoding() == OBJ_ENCODING_INT {
+		return true
+	}
+	n, err := strconv.Atoi(r.str)
+	var buf [20]byte
+	if err != nil || string(strconv.AppendInt(buf[:0], int64(n), 10)) != r.str {
+		return false
+	}
+	r.setEncoding(OBJ_ENCODING_INT)
+	r.num = n
+	r.str = ""
+	return true
+}
+
+// toRawEncoding converts an int encoded string object to the raw encoding,
+// for operations that need the bytes rather than the number
+func (r *kvObj) toRawEncoding() {
+	if r.getType() != OBJ_STRING || r.getEncoding() == OBJ_ENCODING_RAW {
+		return
+	}
+	r.str = strconv.Itoa(r.num)
+	r.num = 0
+	r.setEncoding(OBJ_ENCODING_RAW)
+}
+
+const (
+	OBJ_SHARED_INTEGERS = 10000         // integers in [0, OBJ_SHARED_INTEGERS) are shared
+	OBJ_SHARED_REFCOUNT = math.MaxInt32 // refcount of objects that are never freed
+)
+
+// sharedIntegers are immutable objects reused by every key holding a small
+// integer, so a million counters at 0 cost a million dict entries, not a
+// million objects
+var sharedIntegers [OBJ_SHARED_INTEGERS]*kvObj
+
+func init() {
+	for i := range sharedIntegers {
+		obj := createIntObj(i)
+		obj.refcount = OBJ_SHARED_REFCOUNT
+		sharedIntegers[i] = obj
+	}
+}
+
+func (r *kvObj) isShared() bool {
+	return r.getRefCount() == OBJ_SHARED_REFCOUNT
+}
+
+// incrRefCount adds a reference to the object, shared objects are left alone
+func (r *kvObj) incrRefCount() {
+	if !r.isShared() {
+		atomic.AddInt32(&r.refcount, 1)
+	}
+}
+
+// decrRefCount drops a reference and frees the value with the last one.
+// It returns true if the object was freed.
+func (r *kvObj) decrRefCount() bool {
+	if r.isShared() {
+		return false
+	}
+	if atomic.AddInt32(&r.refcount, -1) <= 0 {
+		atomic.StoreInt32(&r.refcount, 0)
+		r.str = ""
+		r.list = nil
+		r.zset = nil
+		return true
+	}
+	return false
 }
 
 func createIntObj(value int) *kvObj {
 	obj := &kvObj{
 		refcount: 1,
-		lru: 0,
 	}
-	
+
 	obj.setType(OBJ_STRING)
 	obj.setEncoding(OBJ_ENCODING_INT)
-	obj.ptr = unsafe.Pointer(&value)
+	obj.num = value
 	return obj
 }
 
 func createStringObj(value string) *kvObj {
 	obj := &kvObj{
 		refcount: 1,
-		lru: 0,
 	}
-	
+
 	obj.setType(OBJ_STRING)
 	obj.setEncoding(OBJ_ENCODING_RAW)
-	obj.ptr = unsafe.Pointer(&value)
+	obj.str = value
 	return obj
 }
+
+// sizes used to estimate how many bytes a key costs
+const (
+	DICT_ENTRY_OVERHEAD = 32 // map slot, tophash and bookkeeping per entry
+	STRING_HEADER_SIZE  = 16 // data pointer + length
+)
+
+// memoryUsage returns an estimate of the bytes held by the object and its value
+func (r *kvObj) memoryUsage() int64 {
+	if r.getRefCount() <= 0 {
+		return 0
+	}
+	size := int64(unsafe.Sizeof(*r))
+	switch r.getEncoding() {
+	case OBJ_ENCODING_RAW:
+		size += int64(len(r.str))
+	case OBJ_ENCODING_LINKEDLIST:
+		for e := r.list.Front(); e != nil; e = e.Next() {
+			size += listElementMemory(e.Value.(string))
+		}
+	case OBJ_ENCODING_SKIPLIST:
+		for _, m := range r.zset.sorted {
+			size += zsetEntryMemory(m.Member)
+		}
+	}
+	return size
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSharedIntegers(t *testing.T) {
+	s := NewStore()
+	s.SetValue("a", "100")
+	s.SetValue("b", "100")
+
+	if s.objectAt("a") != s.objectAt("b") {
+		t.Error("Expected small integers to share the same object")
+	}
+	if refcount, _ := s.ObjectRefCount("a"); refcount != OBJ_SHARED_REFCOUNT {
+		t.Errorf("Expected shared refcount %d, got %d", OBJ_SHARED_REFCOUNT, refcount)
+	}
+
+	s.SetValue("big", "100000")
+	if refcount, _ := s.ObjectRefCount("big"); refcount != 1 {
+		t.Errorf("Expected refcount 1 for integers outside the shared range, got %d", refcount)
+	}
+
+	// deleting a key must never free a shared object
+	s.DeleteValue("a")
+	if value := s.GetValue("b"); value != 100 {
+		t.Errorf("Expected b to still be 100, got %v", value)
+	}
+	if sharedIntegers[100].refcount != OBJ_SHARED_REFCOUNT {
+		t.Error("Expected the shared object to keep its refcount")
+	}
+}
+
+func TestSharedIntegersDisabledWithLRU(t *testing.T) {
+	s := NewStore()
+	s.SetMaxMemory(1024 * 1024)
+	s.SetMaxMemoryPolicy("allkeys-lru")
+	s.SetValue("a", "100")
+	s.SetValue("b", "100")
+
+	if s.objectAt("a") == s.objectAt("b") {
+		t.Error("Expected integers not to be shared under an LRU policy")
+	}
+}
+
+func TestIncrByCopyOnWrite(t *testing.T) {
+	s := NewStore()
+
+	t.Run("shared integer", func(t *testing.T) {
+		s.SetValue("a", "5")
+		s.SetValue("b", "5")
+		s.IncreBy("a", 1)
+		if value := s.GetValue("b"); value != 5 {
+			t.Errorf("Expected b to stay 5, got %v", value)
+		}
+		if value, _ := sharedIntegers[5].intValue(); value != 5 {
+			t.Error("Expected the shared integer 5 to be untouched")
+		}
+		if value := s.GetValue("a"); value != 6 {
+			t.Errorf("Expected a to be 6, got %v", value)
+		}
+	})
+
+	t.Run("copied value", func(t *testing.T) {
+		s.SetValue("src", "50000")
+		s.Copy("src", "dst", false)
+		if refcount, _ := s.ObjectRefCount("src"); refcount != 2 {
+			t.Errorf("Expected refcount 2 after COPY, got %d", refcount)
+		}
+
+		s.DecreBy("dst", 1)
+		if value := s.GetValue("src"); value != 50000 {
+			t.Errorf("Expected src to stay 50000, got %v", value)
+		}
+		if value := s.GetValue("dst"); value != 49999 {
+			t.Errorf("Expected dst to be 49999, got %v", value)
+		}
+		if refcount, _ := s.ObjectRefCount("src"); refcount != 1 {
+			t.Errorf("Expected refcount back to 1, got %d", refcount)
+		}
+	})
+
+	t.Run("sole owner is updated in place", func(t *testing.T) {
+		s.SetValue("solo", "50000")
+		before := s.objectAt("solo")
+		s.IncreBy("solo", 10)
+		if s.objectAt("solo") != before {
+			t.Error("Expected the object to be updated in place")
+		}
+	})
+}
+
+func TestCopy(t *testing.T) {
+	s := NewStore()
+	s.SetValue("src", "hello")
+	s.SetTTL("src", time.Now().Unix()+3600)
+	used := s.UsedMemory()
+
+	if !s.Copy("src", "dst", false) {
+		t.Fatal("Expected COPY to succeed")
+	}
+	if value := s.GetValue("dst"); value != "hello" {
+		t.Errorf("Expected dst to be hello, got %v", value)
+	}
+	if ttl := s.GetTTL("dst"); ttl < 3599 {
+		t.Errorf("Expected TTL to be copied, got %d", ttl)
+	}
+	// the value is shared, only the new key entries are accounted
+	if s.UsedMemory()-used != entryMemory("dst")+expiryMemory("dst") {
+		t.Errorf("Expected only the dst entries to be accounted, grew by %d", s.UsedMemory()-used)
+	}
+
+	s.SetValue("other", "x")
+	if s.Copy("src", "other", false) {
+		t.Error("Expected COPY without REPLACE to fail on an existing key")
+	}
+	if !s.Copy("src", "other", true) {
+		t.Error("Expected COPY with REPLACE to succeed")
+	}
+	if s.Copy("missing", "dst2", false) {
+		t.Error("Expected COPY of a missing key to fail")
+	}
+
+	s.DeleteValue("src")
+	s.DeleteValue("dst")
+	s.DeleteValue("other")
+	if s.UsedMemory() != 0 {
+		t.Errorf("Expected used memory to be 0, got %d", s.UsedMemory())
+	}
+}
+
+func TestObjectEncodingConversion(t *testing.T) {
+	tests := []struct {
+		input    string
+		isInt    bool
+		expected interface{}
+	}{
+		{"123", true, 123},
+		{"-456", true, -456},
+		{"0", true, 0},
+		{"007", false, "007"},
+		{"+5", false, "+5"},
+		{"-0", false, "-0"},
+		{"12a", false, "12a"},
+		{"", false, ""},
+		{"99999999999999999999999", false, "99999999999999999999999"},
+	}
+	for _, tt := range tests {
+		obj := createStringObj(tt.input)
+		if obj.tryIntEncoding() != tt.isInt {
+			t.Errorf("%q: expected int encoding %v", tt.input, tt.isInt)
+		}
+		if obj.value() != tt.expected {
+			t.Errorf("%q: expected value %v (%T), got %v (%T)", tt.input, tt.expected, tt.expected, obj.value(), obj.value())
+		}
+		// whatever the encoding, the string form is what was stored
+		if obj.stringValue() != tt.input {
+			t.Errorf("%q: expected string value %q, got %q", tt.input, tt.input, obj.stringValue())
+		}
+
+		obj.toRawEncoding()
+		if obj.getEncoding() != OBJ_ENCODING_RAW || obj.value() != tt.input {
+			t.Errorf("%q: expected raw encoding after conversion, got %v", tt.input, obj.value())
+		}
+	}
+}
+
+func TestObjectBitfields(t *testing.T) {
+	obj := createIntObj(42)
+	obj.setLRU(LRU_CLOCK_MAX)
+	obj.setType(OBJ_HASH)
+	obj.setEncoding(OBJ_ENCODING_ZIPMAP)
+
+	if obj.getType() != OBJ_HASH {
+		t.Errorf("Expected type %d, got %d", OBJ_HASH, obj.getType())
+	}
+	if obj.getEncoding() != OBJ_ENCODING_ZIPMAP {
+		t.Errorf("Expected encoding %d, got %d", OBJ_ENCODING_ZIPMAP, obj.getEncoding())
+	}
+	if obj.getLRU() != LRU_CLOCK_MAX {
+		t.Errorf("Expected lru %d, got %d", LRU_CLOCK_MAX, obj.getLRU())
+	}
+
+	obj.setLRU(LRU_CLOCK_MAX + 1) // overflowing the 24 bits must not touch type/encoding
+	if obj.getType() != OBJ_HASH || obj.getEncoding() != OBJ_ENCODING_ZIPMAP || obj.getLRU() != 0 {
+		t.Errorf("Expected lru overflow to be masked, got type %d encoding %d lru %d", obj.getType(), obj.getEncoding(), obj.getLRU())
+	}
+}
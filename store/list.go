@@ -0,0 +1,260 @@
+package store
+
+import (
+	"container/list"
+	"errors"
+)
+
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// List ends, for pushes and pops
+const (
+	LIST_HEAD = 0
+	LIST_TAIL = 1
+)
+
+// LIST_ELEMENT_OVERHEAD is the estimated cost of a list element without its
+// bytes: the element's pointers and value, and the boxed string header
+const LIST_ELEMENT_OVERHEAD = 56
+
+func listElementMemory(value string) int64 {
+	return LIST_ELEMENT_OVERHEAD + int64(len(value))
+}
+
+func createListObj() *kvObj {
+	obj := &kvObj{
+		refcount: 1,
+	}
+
+	obj.setType(OBJ_LIST)
+	obj.setEncoding(OBJ_ENCODING_LINKEDLIST)
+	obj.list = list.New()
+	return obj
+}
+
+// lookupTyped returns the object at key, nil if there is no key. A value
+// of another type than objType is an ErrWrongType.
+func (s *Store) lookupTyped(sh *shard, key string, objType uint8) (*kvObj, error) {
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return nil, nil
+	}
+	if obj.getType() != objType {
+		return nil, ErrWrongType
+	}
+	s.touch(obj)
+	return obj, nil
+}
+
+// lookupRead is lookupTyped for the commands reading the key, counting the
+// keyspace hits and misses
+func (s *Store) lookupRead(sh *shard, key string, objType uint8) (*kvObj, error) {
+	obj, err := s.lookupTyped(sh, key, objType)
+	if obj != nil {
+		s.keyspaceHits.Add(1)
+	} else if err == nil {
+		s.keyspaceMisses.Add(1)
+	}
+	return obj, err
+}
+
+// unshareObj returns an object of the key that can be modified in place:
+// the value of a COPY is duplicated first, so the other keys referencing it
+// don't see the change
+func (s *Store) unshareObj(sh *shard, key string, obj *kvObj) *kvObj {
+	if obj.getRefCount() == 1 {
+		return obj
+	}
+	dup := &kvObj{refcount: 1, meta: obj.meta}
+	switch obj.getEncoding() {
+	case OBJ_ENCODING_LINKEDLIST:
+		dup.list = list.New()
+		dup.list.PushBackList(obj.list)
+	case OBJ_ENCODING_SKIPLIST:
+		dup.zset = obj.zset.duplicate()
+	}
+	s.putObj(sh, key, dup)
+	return dup
+}
+
+// listPush pushes the values one after the other at an end of the list
+// stored at key, creating it if needed
+func (s *Store) listPush(sh *shard, key string, obj *kvObj, values []string, where int) *kvObj {
+	if obj == nil {
+		obj = createListObj()
+		s.putObj(sh, key, obj)
+	} else {
+		obj = s.unshareObj(sh, key, obj)
+		s.signalModifiedKey(sh, key)
+	}
+	for _, value := range values {
+		if where == LIST_HEAD {
+			obj.list.PushFront(value)
+		} else {
+			obj.list.PushBack(value)
+		}
+		s.usedMemory.Add(listElementMemory(value))
+	}
+	s.updatePeakMemory()
+	s.notifyListEvent("push", key, where)
+	return obj
+}
+
+// listPop pops up to count values from an end of the list stored at key,
+// the key is deleted with its last value
+func (s *Store) listPop(sh *shard, key string, obj *kvObj, count int, where int) []string {
+	obj = s.unshareObj(sh, key, obj)
+	s.signalModifiedKey(sh, key)
+	values := make([]string, 0, min(count, obj.list.Len()))
+	for len(values) < count && obj.list.Len() > 0 {
+		e := obj.list.Front()
+		if where == LIST_TAIL {
+			e = obj.list.Back()
+		}
+		value := obj.list.Remove(e).(string)
+		s.usedMemory.Add(-listElementMemory(value))
+		values = append(values, value)
+	}
+	s.notifyListEvent("pop", key, where)
+	if obj.list.Len() == 0 {
+		s.deleteKey(sh, key)
+		s.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key)
+	}
+	return values
+}
+
+// listRotate moves a value from an end of the list stored at key to an end
+// of the same list, the list never gets empty in between
+func (s *Store) listRotate(sh *shard, key string, obj *kvObj, from int, to int) string {
+	obj = s.unshareObj(sh, key, obj)
+	s.signalModifiedKey(sh, key)
+	e := obj.list.Front()
+	if from == LIST_TAIL {
+		e = obj.list.Back()
+	}
+	if to == LIST_HEAD {
+		obj.list.MoveToFront(e)
+	} else {
+		obj.list.MoveToBack(e)
+	}
+	s.notifyListEvent("pop", key, from)
+	s.notifyListEvent("push", key, to)
+	return e.Value.(string)
+}
+
+// notifyListEvent notifies lpush/rpush or lpop/rpop depending on the end
+func (s *Store) notifyListEvent(op string, key string, where int) {
+	if where == LIST_HEAD {
+		s.notifyKeyspaceEvent(NOTIFY_LIST, "l"+op, key)
+	} else {
+		s.notifyKeyspaceEvent(NOTIFY_LIST, "r"+op, key)
+	}
+}
+
+// Push pushes the values at the head (LPUSH) or at the tail (RPUSH) of the
+// list stored at key and returns the length of the list
+func (s *Store) Push(key string, values []string, where int) (int, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupTyped(sh, key, OBJ_LIST)
+	if err != nil {
+		return 0, err
+	}
+	obj = s.listPush(sh, key, obj, values, where)
+	return obj.list.Len(), nil
+}
+
+// Pop removes and returns up to count values from the head (LPOP) or the
+// tail (RPOP) of the list stored at key, nil if there is no key
+func (s *Store) Pop(key string, count int, where int) ([]string, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupTyped(sh, key, OBJ_LIST)
+	if obj == nil || err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return []string{}, nil
+	}
+	return s.listPop(sh, key, obj, count, where), nil
+}
+
+// ListLen returns the length of the list stored at key, 0 if there is no key
+func (s *Store) ListLen(key string) (int, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupRead(sh, key, OBJ_LIST)
+	if obj == nil || err != nil {
+		return 0, err
+	}
+	return obj.list.Len(), nil
+}
+
+// rangeIndexes converts the start and stop indexes of a range, negative
+// ones counting from the end, to the positions of its first and last
+// element. It returns false if the range is empty.
+func rangeIndexes(start int, stop int, length int) (int, int, bool) {
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	stop = min(stop, length-1)
+	return start, stop, start <= stop
+}
+
+// ListRange returns the values of the list stored at key from start to stop
+// included, negative indexes counting from the tail
+func (s *Store) ListRange(key string, start int, stop int) ([]string, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupRead(sh, key, OBJ_LIST)
+	if obj == nil || err != nil {
+		return nil, err
+	}
+	start, stop, ok := rangeIndexes(start, stop, obj.list.Len())
+	if !ok {
+		return []string{}, nil
+	}
+	values := make([]string, 0, stop-start+1)
+	i := 0
+	for e := obj.list.Front(); e != nil && i <= stop; e = e.Next() {
+		if i >= start {
+			values = append(values, e.Value.(string))
+		}
+		i++
+	}
+	return values, nil
+}
+
+// ListMove atomically pops a value from an end of the source list and
+// pushes it at an end of the destination list, which may be the source
+// itself. It returns false if there is no source key.
+func (s *Store) ListMove(source string, destination string, from int, to int) (string, bool, error) {
+	unlock := s.lockShards(source, destination)
+	defer unlock()
+
+	srcShard := &s.shards[shardIndex(source)]
+	dstShard := &s.shards[shardIndex(destination)]
+	src, err := s.lookupTyped(srcShard, source, OBJ_LIST)
+	if src == nil || err != nil {
+		return "", false, err
+	}
+	// the destination type is checked first so nothing is popped in vain
+	dst, err := s.lookupTyped(dstShard, destination, OBJ_LIST)
+	if err != nil {
+		return "", false, err
+	}
+
+	if source == destination {
+		return s.listRotate(srcShard, source, src, from, to), true, nil
+	}
+	value := s.listPop(srcShard, source, src, 1, from)[0]
+	s.listPush(dstShard, destination, dst, []string{value}, to)
+	return value, true, nil
+}
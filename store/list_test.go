@@ -0,0 +1,111 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListPushPop(t *testing.T) {
+	s := NewStore()
+	if n, err := s.Push("list", []string{"a", "b"}, LIST_TAIL); err != nil || n != 2 {
+		t.Fatalf("Expected length 2, got %d (%v)", n, err)
+	}
+	if n, _ := s.Push("list", []string{"c", "d"}, LIST_HEAD); n != 4 {
+		t.Fatalf("Expected length 4, got %d", n)
+	}
+	if values, _ := s.ListRange("list", 0, -1); !reflect.DeepEqual(values, []string{"d", "c", "a", "b"}) {
+		t.Errorf("Expected [d c a b], got %v", values)
+	}
+	if values, _ := s.ListRange("list", -3, 1); !reflect.DeepEqual(values, []string{"c"}) {
+		t.Errorf("Expected [c], got %v", values)
+	}
+	if values, _ := s.ListRange("list", 5, 10); len(values) != 0 {
+		t.Errorf("Expected an empty range, got %v", values)
+	}
+
+	if values, _ := s.Pop("list", 1, LIST_TAIL); !reflect.DeepEqual(values, []string{"b"}) {
+		t.Errorf("Expected [b], got %v", values)
+	}
+	if values, _ := s.Pop("list", 10, LIST_HEAD); !reflect.DeepEqual(values, []string{"d", "c", "a"}) {
+		t.Errorf("Expected [d c a], got %v", values)
+	}
+	if s.Exists("list") {
+		t.Error("Expected the key to be deleted with its last element")
+	}
+	if values, err := s.Pop("list", 1, LIST_HEAD); values != nil || err != nil {
+		t.Errorf("Expected nil for a missing key, got %v (%v)", values, err)
+	}
+	if s.UsedMemory() != 0 {
+		t.Errorf("Expected used memory to be 0 once the list is gone, got %d", s.UsedMemory())
+	}
+}
+
+func TestListWrongType(t *testing.T) {
+	s := NewStore()
+	s.SetValue("str", "value")
+	s.Push("list", []string{"a"}, LIST_TAIL)
+
+	if _, err := s.Push("str", []string{"a"}, LIST_HEAD); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType from Push, got %v", err)
+	}
+	if _, err := s.Pop("str", 1, LIST_HEAD); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType from Pop, got %v", err)
+	}
+	if _, _, err := s.ListMove("list", "str", LIST_HEAD, LIST_HEAD); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType from ListMove, got %v", err)
+	}
+	if n, _ := s.ListLen("list"); n != 1 {
+		t.Error("Expected nothing to be popped when the destination has the wrong type")
+	}
+	if typ := s.Type("list"); typ != "list" {
+		t.Errorf("Expected type list, got %s", typ)
+	}
+	if encoding, _ := s.ObjectEncoding("list"); encoding != "linkedlist" {
+		t.Errorf("Expected encoding linkedlist, got %s", encoding)
+	}
+}
+
+func TestListMove(t *testing.T) {
+	s := NewStore()
+	s.Push("src", []string{"a", "b", "c"}, LIST_TAIL)
+
+	value, moved, err := s.ListMove("src", "dst", LIST_TAIL, LIST_HEAD)
+	if value != "c" || !moved || err != nil {
+		t.Fatalf("Expected c to be moved, got %q %v %v", value, moved, err)
+	}
+	// a rotation keeps the list even with a single element
+	s.Push("single", []string{"x"}, LIST_TAIL)
+	if value, moved, _ := s.ListMove("single", "single", LIST_HEAD, LIST_TAIL); value != "x" || !moved {
+		t.Errorf("Expected x to be rotated, got %q %v", value, moved)
+	}
+	if n, _ := s.ListLen("single"); n != 1 {
+		t.Errorf("Expected the rotated list to keep its element, got length %d", n)
+	}
+	if _, moved, _ := s.ListMove("missing", "dst", LIST_HEAD, LIST_HEAD); moved {
+		t.Error("Expected nothing to be moved from a missing key")
+	}
+	if values, _ := s.ListRange("dst", 0, -1); !reflect.DeepEqual(values, []string{"c"}) {
+		t.Errorf("Expected dst to be [c], got %v", values)
+	}
+}
+
+func TestListCopyIsUnshared(t *testing.T) {
+	s := NewStore()
+	s.Push("original", []string{"a", "b"}, LIST_TAIL)
+	s.Copy("original", "copy", false)
+
+	s.Push("copy", []string{"c"}, LIST_TAIL)
+	s.Pop("original", 1, LIST_HEAD)
+	if values, _ := s.ListRange("original", 0, -1); !reflect.DeepEqual(values, []string{"b"}) {
+		t.Errorf("Expected original to be [b], got %v", values)
+	}
+	if values, _ := s.ListRange("copy", 0, -1); !reflect.DeepEqual(values, []string{"a", "b", "c"}) {
+		t.Errorf("Expected copy to be [a b c], got %v", values)
+	}
+
+	s.DeleteValue("original")
+	s.DeleteValue("copy")
+	if s.UsedMemory() != 0 {
+		t.Errorf("Expected used memory to be 0 once both lists are gone, got %d", s.UsedMemory())
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyspace notification classes, selected with notify-keyspace-events
+const (
+	NOTIFY_KEYSPACE = 1 << iota // K: __keyspace@<db>__:<key> channels
+	NOTIFY_KEYEVENT             // E: __keyevent@<db>__:<event> channels
+	NOTIFY_GENERIC              // g: del, expire, persist, copy_to...
+	NOTIFY_STRING               // $: set, incrby, decrby
+	NOTIFY_LIST                 // l
+	NOTIFY_SET                  // s
+	NOTIFY_HASH                 // h
+	NOTIFY_ZSET                 // z
+	NOTIFY_EXPIRED              // x: a key expired
+	NOTIFY_EVICTED              // e: a key was evicted for maxmemory
+	NOTIFY_STREAM               // t
+	NOTIFY_KEY_MISS             // m: a read found no key
+	NOTIFY_MODULE               // d
+	NOTIFY_NEW                  // n: a key was created
+
+	// A: every class but key misses and new keys, like Redis
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH | NOTIFY_ZSET |
+		NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM | NOTIFY_MODULE
+)
+
+var notifyClassChars = []struct {
+	char  byte
+	class int
+}{
+	{'g', NOTIFY_GENERIC},
+	{'$', NOTIFY_STRING},
+	{'l', NOTIFY_LIST},
+	{'s', NOTIFY_SET},
+	{'h', NOTIFY_HASH},
+	{'z', NOTIFY_ZSET},
+	{'x', NOTIFY_EXPIRED},
+	{'e', NOTIFY_EVICTED},
+	{'t', NOTIFY_STREAM},
+	{'d', NOTIFY_MODULE},
+	{'K', NOTIFY_KEYSPACE},
+	{'E', NOTIFY_KEYEVENT},
+	{'m', NOTIFY_KEY_MISS},
+	{'n', NOTIFY_NEW},
+}
+
+// Publisher publishes keyspace notifications, *pubsub.PubSub is one
+type Publisher interface {
+	Publish(channel string, message string) int
+}
+
+// SetPublisher sets where keyspace notifications are published. It must be
+// called before the store is used concurrently.
+func (s *Store) SetPublisher(p Publisher) {
+	s.publisher = p
+}
+
+// SetNotifyKeyspaceEvents selects the notifications to publish, with the
+// notify-keyspace-events syntax: "Ex" publishes the expired keys on the
+// keyevent channel, "" disables notifications.
+func (s *Store) SetNotifyKeyspaceEvents(classes string) error {
+	flags := 0
+	for i := 0; i < len(classes); i++ {
+		if classes[i] == 'A' {
+			flags |= NOTIFY_ALL
+			continue
+		}
+		found := false
+		for _, c := range notifyClassChars {
+			if c.char == classes[i] {
+				flags |= c.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid notify-keyspace-events class '%c'", classes[i])
+		}
+	}
+	s.notifyKeyspaceEvents.Store(int32(flags))
+	return nil
+}
+
+// GetNotifyKeyspaceEvents returns the selected notifications in the
+// notify-keyspace-events syntax
+func (s *Store) GetNotifyKeyspaceEvents() string {
+	flags := int(s.notifyKeyspaceEvents.Load())
+	var b strings.Builder
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		b.WriteByte('A')
+	}
+	for _, c := range notifyClassChars {
+		if flags&NOTIFY_ALL == NOTIFY_ALL && c.class&NOTIFY_ALL != 0 {
+			continue
+		}
+		if flags&c.class != 0 {
+			b.WriteByte(c.char)
+		}
+	}
+	return b.String()
+}
+
+// notifyKeyspaceEvent publishes event on key if its class is selected:
+// the event on __keyspace@0__:<key> and the key on __keyevent@0__:<event>.
+// It is called with the shard of the key locked, so subscribers see the
+// events of a key in the order they happened.
+func (s *Store) notifyKeyspaceEvent(class int, event string, key string) {
+	flags := int(s.notifyKeyspaceEvents.Load())
+	if flags&class == 0 || s.publisher == nil {
+		return
+	}
+	if flags&NOTIFY_KEYSPACE != 0 {
+		s.publisher.Publish("__keyspace@0__:"+key, event)
+	}
+	if flags&NOTIFY_KEYEVENT != 0 {
+		s.publisher.Publish("__keyevent@0__:"+event, key)
+	}
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakePublisher struct {
+	published []string
+}
+
+func (f *fakePublisher) Publish(channel string, message string) int {
+	f.published = append(f.published, channel+" "+message)
+	return 0
+}
+
+func newNotifyingStore(t *testing.T, classes string) (*Store, *fakePublisher) {
+	s := NewStore()
+	p := &fakePublisher{}
+	s.SetPublisher(p)
+	if err := s.SetNotifyKeyspaceEvents(classes); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return s, p
+}
+
+func TestNotifyKeyspaceEventsConfig(t *testing.T) {
+	s := NewStore()
+	tests := []struct {
+		classes  string
+		expected string
+	}{
+		{"", ""},
+		{"Ex", "xE"},
+		{"KEA", "AKE"},
+		{"g$lshzxetdKE", "AKE"},
+		{"AKEmn", "AKEmn"},
+		{"Kg$", "g$K"},
+	}
+	for _, tt := range tests {
+		if err := s.SetNotifyKeyspaceEvents(tt.classes); err != nil {
+			t.Errorf("%q: expected no error, got %v", tt.classes, err)
+		}
+		if got := s.GetNotifyKeyspaceEvents(); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.classes, tt.expected, got)
+		}
+	}
+	if err := s.SetNotifyKeyspaceEvents("KEw"); err == nil {
+		t.Error("Expected an error for an invalid class")
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	t.Run("write commands", func(t *testing.T) {
+		s, p := newNotifyingStore(t, "KA")
+		s.SetValue("k", "1")
+		s.IncreBy("k", 2)
+		s.DecreBy("k", 1)
+		s.SetTTL("k", time.Now().Unix()+100)
+		s.RemoveExpiry("k")
+		s.RemoveExpiry("k") // no expire anymore, nothing to notify
+		s.Copy("k", "k2", false)
+		s.DeleteValue("k")
+		expected := []string{
+			"__keyspace@0__:k set",
+			"__keyspace@0__:k incrby",
+			"__keyspace@0__:k decrby",
+			"__keyspace@0__:k expire",
+			"__keyspace@0__:k persist",
+			"__keyspace@0__:k2 copy_to",
+			"__keyspace@0__:k del",
+		}
+		if !reflect.DeepEqual(p.published, expected) {
+			t.Errorf("Expected %v, got %v", expected, p.published)
+		}
+	})
+
+	t.Run("keyevent channels", func(t *testing.T) {
+		s, p := newNotifyingStore(t, "E$")
+		s.SetValue("k", "v")
+		s.DeleteValue("k") // generic events are not selected
+		expected := []string{"__keyevent@0__:set k"}
+		if !reflect.DeepEqual(p.published, expected) {
+			t.Errorf("Expected %v, got %v", expected, p.published)
+		}
+	})
+
+	t.Run("new keys and misses", func(t *testing.T) {
+		s, p := newNotifyingStore(t, "Enm")
+		s.SetValue("k", "v")
+		s.SetValue("k", "v2")
+		s.GetValue("k")
+		s.GetValue("missing")
+		expected := []string{"__keyevent@0__:new k", "__keyevent@0__:keymiss missing"}
+		if !reflect.DeepEqual(p.published, expected) {
+			t.Errorf("Expected %v, got %v", expected, p.published)
+		}
+	})
+
+	t.Run("lazy expiration", func(t *testing.T) {
+		s, p := newNotifyingStore(t, "Ex")
+		s.SetValue("session:1", "data")
+		s.SetTTL("session:1", time.Now().Unix()-1)
+		if s.GetValue("session:1") != nil {
+			t.Error("Expected the key to be expired")
+		}
+		expected := []string{"__keyevent@0__:expired session:1"}
+		if !reflect.DeepEqual(p.published, expected) {
+			t.Errorf("Expected %v, got %v", expected, p.published)
+		}
+		if s.ExpiredKeys() != 1 {
+			t.Errorf("Expected 1 expired key, got %d", s.ExpiredKeys())
+		}
+	})
+
+	t.Run("active expiration", func(t *testing.T) {
+		s, p := newNotifyingStore(t, "Ex")
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("session:%d", i)
+			s.SetValue(key, "data")
+			if i%2 == 0 {
+				s.SetTTL(key, time.Now().Unix()-1)
+			} else {
+				s.SetTTL(key, time.Now().Unix()+100)
+			}
+		}
+		for s.ActiveExpireCycle() > 0 {
+		}
+		if s.KeyCount() != 100 {
+			t.Errorf("Expected the 100 expired keys to be deleted, %d keys left", s.KeyCount())
+		}
+		if len(p.published) != 100 {
+			t.Errorf("Expected 100 expired events, got %d", len(p.published))
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		s, p := newNotifyingStore(t, "Ee")
+		s.SetMaxMemoryPolicy("allkeys-random")
+		s.SetValue("k", "v")
+		s.SetMaxMemory(1)
+		s.FreeMemoryIfNeeded()
+		expected := []string{"__keyevent@0__:evicted k"}
+		if !reflect.DeepEqual(p.published, expected) {
+			t.Errorf("Expected %v, got %v", expected, p.published)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		s := NewStore()
+		p := &fakePublisher{}
+		s.SetPublisher(p)
+		s.SetValue("k", "v")
+		s.DeleteValue("k")
+		if len(p.published) != 0 {
+			t.Errorf("Expected no notification, got %v", p.published)
+		}
+	})
+}
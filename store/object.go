@@ -0,0 +1,150 @@
+package store
+
+import (
+	"errors"
+)
+
+var (
+	ErrNoSuchKey      = errors.New("no such key")
+	ErrLFUNotSelected = errors.New("An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+	ErrLFUSelected    = errors.New("An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+)
+
+var encodingNames = map[uint8]string{
+	OBJ_ENCODING_RAW:        "raw",
+	OBJ_ENCODING_INT:        "int",
+	OBJ_ENCODING_HT:         "hashtable",
+	OBJ_ENCODING_ZIPMAP:     "zipmap",
+	OBJ_ENCODING_LINKEDLIST: "linkedlist",
+	OBJ_ENCODING_SKIPLIST:   "skiplist",
+}
+
+var typeNames = map[uint8]string{
+	OBJ_STRING: "string",
+	OBJ_LIST:   "list",
+	OBJ_SET:    "set",
+	OBJ_ZSET:   "zset",
+	OBJ_HASH:   "hash",
+}
+
+// lookupNoTouch returns the object stored at key without updating its
+// access time, so introspection doesn't change eviction decisions
+func (s *Store) lookupNoTouch(sh *shard, key string) (*kvObj, bool) {
+	if s.expireIfNeeded(sh, key) {
+		return nil, false
+	}
+	obj, exists := sh.Dict[key]
+	if !exists || obj.getRefCount() <= 0 {
+		return nil, false
+	}
+	return obj, true
+}
+
+// ObjectEncoding returns the name of the internal encoding of the value at key
+func (s *Store) ObjectEncoding(key string) (string, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return "", ErrNoSuchKey
+	}
+	if name, ok := encodingNames[obj.getEncoding()]; ok {
+		return name, nil
+	}
+	return "unknown", nil
+}
+
+// Type returns the name of the type of the value at key, "none" if there is no key
+func (s *Store) Type(key string) string {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return "none"
+	}
+	return typeNames[obj.getType()]
+}
+
+// ObjectRefCount returns the reference count of the value at key
+func (s *Store) ObjectRefCount(key string) (int, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return 0, ErrNoSuchKey
+	}
+	return int(obj.getRefCount()), nil
+}
+
+// ObjectIdleTime returns the seconds since the value at key was last accessed
+func (s *Store) ObjectIdleTime(key string) (int64, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return 0, ErrNoSuchKey
+	}
+	if s.maxMemoryPolicy.Load()&MAXMEMORY_FLAG_LFU != 0 {
+		return 0, ErrLFUSelected
+	}
+	return int64(s.estimateObjectIdleTime(obj) / 1000), nil
+}
+
+// ObjectFreq returns the logarithmic access frequency counter of the value at key
+func (s *Store) ObjectFreq(key string) (int, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return 0, ErrNoSuchKey
+	}
+	if s.maxMemoryPolicy.Load()&MAXMEMORY_FLAG_LFU == 0 {
+		return 0, ErrLFUNotSelected
+	}
+	return int(s.lfuDecrAndReturn(obj)), nil
+}
+
+// MemoryUsage returns the estimated bytes used by key, its value and its expire entry
+func (s *Store) MemoryUsage(key string) (int64, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := s.lookupNoTouch(sh, key)
+	if !exists {
+		return 0, ErrNoSuchKey
+	}
+	usage := entryMemory(key) + obj.memoryUsage()
+	if _, hasExpiry := sh.Expiry[key]; hasExpiry {
+		usage += expiryMemory(key)
+	}
+	return usage, nil
+}
+
+// KeyCount returns the number of keys in the keyspace
+func (s *Store) KeyCount() int {
+	count := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		count += len(sh.Dict)
+		sh.mu.Unlock()
+	}
+	return count
+}
+
+// ExpiresCount returns the number of keys with an expire set
+func (s *Store) ExpiresCount() int {
+	count := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		count += len(sh.Expiry)
+		sh.mu.Unlock()
+	}
+	return count
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	s := NewStore()
+	s.SetValue("num", "123")
+	s.SetValue("str", "hello")
+
+	tests := []struct {
+		key      string
+		expected string
+		err      error
+	}{
+		{"num", "int", nil},
+		{"str", "raw", nil},
+		{"missing", "", ErrNoSuchKey},
+	}
+	for _, tt := range tests {
+		encoding, err := s.ObjectEncoding(tt.key)
+		if err != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.key, tt.err, err)
+		}
+		if encoding != tt.expected {
+			t.Errorf("%s: expected encoding %q, got %q", tt.key, tt.expected, encoding)
+		}
+	}
+}
+
+func TestObjectIdleTimeAndFreq(t *testing.T) {
+	s := NewStore()
+	s.SetValue("key", "value")
+
+	obj := s.objectAt("key")
+	obj.setLRU(getLRUClock() - 100)
+
+	// OBJECT must not count as an access
+	for i := 0; i < 2; i++ {
+		idle, err := s.ObjectIdleTime("key")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if idle < 100 || idle > 101 {
+			t.Errorf("Expected idle time around 100 seconds, got %d", idle)
+		}
+	}
+	if _, err := s.ObjectFreq("key"); err != ErrLFUNotSelected {
+		t.Errorf("Expected ErrLFUNotSelected, got %v", err)
+	}
+
+	s.SetMaxMemoryPolicy("allkeys-lfu")
+	if _, err := s.ObjectIdleTime("key"); err != ErrLFUSelected {
+		t.Errorf("Expected ErrLFUSelected, got %v", err)
+	}
+	s.SetValue("key", "value")
+	if freq, err := s.ObjectFreq("key"); err != nil || freq != LFU_INIT_VAL {
+		t.Errorf("Expected freq %d, got %d (%v)", LFU_INIT_VAL, freq, err)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	s := NewStore()
+	s.SetValue("key", "value")
+
+	usage, err := s.MemoryUsage("key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if usage != s.UsedMemory() {
+		t.Errorf("Expected usage of the only key to equal used memory %d, got %d", s.UsedMemory(), usage)
+	}
+
+	s.SetTTL("key", time.Now().Unix()+3600)
+	withExpiry, _ := s.MemoryUsage("key")
+	if withExpiry <= usage {
+		t.Errorf("Expected the expire entry to be counted, got %d <= %d", withExpiry, usage)
+	}
+
+	if _, err := s.MemoryUsage("missing"); err != ErrNoSuchKey {
+		t.Errorf("Expected ErrNoSuchKey, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package store
+
+// CLUSTER_SLOTS is the number of hash slots the keyspace of a cluster is
+// split into
+const CLUSTER_SLOTS = 16384
+
+// crc16Table is the table of the CRC16-CCITT (XMODEM) polynomial 0x1021
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc16 returns the CRC16-CCITT (XMODEM) of s, the checksum Redis Cluster
+// hashes keys with
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// KeyHashSlot returns the hash slot of a key. When the key has a non empty
+// {hashtag}, only the hashtag is hashed, so related keys can be put in the
+// same slot.
+func KeyHashSlot(key string) int {
+	for start := 0; start < len(key); start++ {
+		if key[start] != '{' {
+			continue
+		}
+		for end := start + 1; end < len(key); end++ {
+			if key[end] == '}' {
+				if end > start+1 {
+					key = key[start+1 : end]
+				}
+				return int(crc16(key) & (CLUSTER_SLOTS - 1))
+			}
+		}
+		break
+	}
+	return int(crc16(key) & (CLUSTER_SLOTS - 1))
+}
+
+// EnableSlotIndex makes the store index its keys by hash slot, as needed by
+// a cluster node to count, list and delete the keys of a slot. It must be
+// called before the store holds keys.
+func (s *Store) EnableSlotIndex() {
+	for i := range s.shards {
+		s.shards[i].slots = make(map[int]map[string]struct{})
+	}
+}
+
+// indexKey adds a new key of the (locked) shard to the slot index
+func (sh *shard) indexKey(key string) {
+	if sh.slots == nil {
+		return
+	}
+	slot := KeyHashSlot(key)
+	keys, exists := sh.slots[slot]
+	if !exists {
+		keys = make(map[string]struct{})
+		sh.slots[slot] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// unindexKey removes a deleted key of the (locked) shard from the slot index
+func (sh *shard) unindexKey(key string) {
+	if sh.slots == nil {
+		return
+	}
+	slot := KeyHashSlot(key)
+	if keys, exists := sh.slots[slot]; exists {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(sh.slots, slot)
+		}
+	}
+}
+
+// CountKeysInSlot returns the number of keys in a hash slot, the expired
+// ones not deleted yet included
+func (s *Store) CountKeysInSlot(slot int) int {
+	count := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		count += len(sh.slots[slot])
+		sh.mu.Unlock()
+	}
+	return count
+}
+
+// KeysInSlot returns up to count keys of a hash slot
+func (s *Store) KeysInSlot(slot int, count int) []string {
+	var keys []string
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key := range sh.slots[slot] {
+			if len(keys) == count {
+				break
+			}
+			keys = append(keys, key)
+		}
+		sh.mu.Unlock()
+	}
+	return keys
+}
+
+// DeleteKeysInSlot deletes every key of a hash slot and returns them
+func (s *Store) DeleteKeysInSlot(slot int) []string {
+	var deleted []string
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key := range sh.slots[slot] {
+			s.deleteKey(sh, key)
+			s.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key)
+			deleted = append(deleted, key)
+		}
+		sh.mu.Unlock()
+	}
+	return deleted
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	slots := map[string]int{
+		"123456789":    12739, // the CRC16 check value, 0x31C3
+		"foo":          12182,
+		"{foo}bar":     12182,
+		"a{foo}b{bar}": 12182, // only the first hashtag counts
+		"{}foo":        KeyHashSlot("{}foo"),
+		"":             0,
+	}
+	for key, slot := range slots {
+		if got := KeyHashSlot(key); got != slot {
+			t.Errorf("Expected %q to hash to %d, got %d", key, slot, got)
+		}
+	}
+	if KeyHashSlot("{}foo") == KeyHashSlot("foo") || KeyHashSlot("{foo") == KeyHashSlot("foo") {
+		t.Error("Expected an empty or unclosed hashtag to hash the whole key")
+	}
+}
+
+func TestSlotIndex(t *testing.T) {
+	s := NewStore()
+	s.EnableSlotIndex()
+	s.SetValue("{user}:name", "a")
+	s.Push("{user}:list", []string{"x"}, LIST_TAIL)
+	s.SetValue("other", "b")
+	s.SetValue("{user}:name", "c") // overwriting doesn't add a key
+	slot := KeyHashSlot("user")
+
+	if n := s.CountKeysInSlot(slot); n != 2 {
+		t.Errorf("Expected 2 keys in the slot, got %d", n)
+	}
+	keys := s.KeysInSlot(slot, 10)
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"{user}:list", "{user}:name"}) {
+		t.Errorf("Unexpected keys %q", keys)
+	}
+	if keys := s.KeysInSlot(slot, 1); len(keys) != 1 {
+		t.Errorf("Expected the count to be honored, got %q", keys)
+	}
+
+	s.Pop("{user}:list", 1, LIST_HEAD)
+	if n := s.CountKeysInSlot(slot); n != 1 {
+		t.Errorf("Expected the emptied list to leave the slot, got %d keys", n)
+	}
+	if deleted := s.DeleteKeysInSlot(slot); len(deleted) != 1 || s.Exists("{user}:name") || !s.Exists("other") {
+		t.Errorf("Expected only the key of the slot to be deleted, deleted %v", deleted)
+	}
+	if n := s.CountKeysInSlot(slot); n != 0 {
+		t.Errorf("Expected an empty slot, got %d keys", n)
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+// ExpiredKeys returns the number of keys deleted because their expire was reached
+func (s *Store) ExpiredKeys() int64 {
+	return s.expiredKeys.Load()
+}
+
+// EvictedKeys returns the number of keys evicted because of maxmemory
+func (s *Store) EvictedKeys() int64 {
+	return s.evictedKeys.Load()
+}
+
+// KeyspaceHits returns the number of lookups of the reading commands that
+// found their key
+func (s *Store) KeyspaceHits() int64 {
+	return s.keyspaceHits.Load()
+}
+
+// KeyspaceMisses returns the number of lookups of the reading commands that
+// didn't find their key
+func (s *Store) KeyspaceMisses() int64 {
+	return s.keyspaceMisses.Load()
+}
+
+// AvgTTL returns the estimated average TTL of the keys with an expire, in
+// milliseconds, 0 when there are none
+func (s *Store) AvgTTL() int64 {
+	if s.ExpiresCount() == 0 {
+		return 0
+	}
+	return s.avgTTL.Load()
+}
+
+// updateAvgTTL folds the average TTL of a sample into the estimate, each
+// sample weighing 2% like in Redis so the estimate follows slowly
+func (s *Store) updateAvgTTL(sample int64) {
+	for {
+		avg := s.avgTTL.Load()
+		next := sample
+		if avg != 0 {
+			next = avg/50*49 + sample/50
+		}
+		if s.avgTTL.CompareAndSwap(avg, next) {
+			return
+		}
+	}
+}
+
+// ResetStats resets the counts of the keys evicted and expired and of the
+// keyspace hits and misses, and the peak memory to the memory used now
+func (s *Store) ResetStats() {
+	s.evictedKeys.Store(0)
+	s.expiredKeys.Store(0)
+	s.keyspaceHits.Store(0)
+	s.keyspaceMisses.Store(0)
+	s.peakMemory.Store(s.usedMemory.Load())
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyspaceStats(t *testing.T) {
+	s := NewStore()
+	s.SetValue("str", "value")
+	s.Push("list", []string{"a"}, LIST_TAIL)
+	s.GetValue("str")
+	s.GetValue("missing")
+	s.ListRange("list", 0, -1)
+	s.ListLen("missing")
+	s.ZCard("str") // a wrong type is neither
+	// writes don't count
+	s.Push("list", []string{"b"}, LIST_TAIL)
+	if hits, misses := s.KeyspaceHits(), s.KeyspaceMisses(); hits != 2 || misses != 2 {
+		t.Errorf("Expected 2 hits and 2 misses, got %d and %d", hits, misses)
+	}
+
+	if ttl := s.AvgTTL(); ttl != 0 {
+		t.Errorf("Expected no average TTL without expires, got %d", ttl)
+	}
+	s.SetTTL("str", time.Now().Unix()+100)
+	s.SetTTL("list", time.Now().Unix()+100)
+	s.ActiveExpireCycle()
+	if ttl := s.AvgTTL(); ttl <= 98000 || ttl > 100000 {
+		t.Errorf("Expected an average TTL of about 100s, got %dms", ttl)
+	}
+
+	s.ResetStats()
+	if hits, misses := s.KeyspaceHits(), s.KeyspaceMisses(); hits != 0 || misses != 0 {
+		t.Errorf("Expected the stats reset, got %d hits and %d misses", hits, misses)
+	}
+}
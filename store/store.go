@@ -2,17 +2,59 @@ package store
 
 import (
 	"errors"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type KvObjectDict map[string]kvObj
+type KvObjectDict map[string]*kvObj
 
 type ExpiryDict map[string]int64  // Separate expires dictionary: key -> unix_timestamp
 
+// STORE_SHARDS is the number of independently locked slices of the keyspace,
+// it must be a power of two
+const STORE_SHARDS = 64
+
+// shard owns the keys hashing to it. Its lock protects both dictionaries
+// and the values of the objects only referenced from this shard.
+type shard struct {
+	mu      sync.Mutex
+	Dict    KvObjectDict
+	Expiry  ExpiryDict
+	watched map[string]map[*Watcher]struct{} // WATCHed keys, see watch.go
+	slots   map[int]map[string]struct{}      // keys by hash slot in cluster mode, see slots.go
+}
+
+// Store is safe for concurrent use. Single key operations only lock the
+// shard of the key, multi key operations lock their shards in index order.
 type Store struct {
-	Dict   *KvObjectDict
-	Expiry *ExpiryDict
+	shards [STORE_SHARDS]shard
+
+	// memory accounting and eviction, see evict.go
+	usedMemory       atomic.Int64
+	peakMemory       atomic.Int64
+	maxMemory        atomic.Int64 // 0 means no limit
+	maxMemoryPolicy  atomic.Int32
+	maxMemorySamples atomic.Int32
+	lfuLogFactor     atomic.Int32
+	lfuDecayTime     atomic.Int32
+	evictionMu       sync.Mutex // serializes evictions and guards the pool
+	evictionPool     []evictionPoolEntry
+
+	// statistics of INFO, see stats.go
+	evictedKeys    atomic.Int64
+	expiredKeys    atomic.Int64
+	keyspaceHits   atomic.Int64
+	keyspaceMisses atomic.Int64
+	avgTTL         atomic.Int64 // milliseconds, estimated by ActiveExpireCycle
+
+	// keyspace notifications, see notify.go
+	publisher            Publisher
+	notifyKeyspaceEvents atomic.Int32
+
+	modifiedKeyHook     func(key string)            // see watch.go
+	evictionLatencyHook func(latency time.Duration) // see evict.go
+	evictedKeyHook      func(key string)            // see evict.go
 }
 
 type StoreInterface interface {
@@ -28,94 +70,254 @@ type StoreInterface interface {
 }
 
 func NewStore() *Store {
-	dict := make(KvObjectDict, 0)
-	expiry := make(ExpiryDict, 0)
-	return &Store{
-		Dict:   &dict, 
-		Expiry: &expiry,
+	s := &Store{
+		evictionPool: make([]evictionPoolEntry, EVPOOL_SIZE),
+	}
+	for i := range s.shards {
+		s.shards[i].Dict = make(KvObjectDict)
+		s.shards[i].Expiry = make(ExpiryDict)
+	}
+	s.maxMemoryPolicy.Store(MAXMEMORY_NO_EVICTION)
+	s.maxMemorySamples.Store(MAXMEMORY_SAMPLES_DEFAULT)
+	s.lfuLogFactor.Store(LFU_LOG_FACTOR_DEFAULT)
+	s.lfuDecayTime.Store(LFU_DECAY_TIME_DEFAULT)
+	return s
+}
+
+// shardIndex hashes the key with FNV-1a
+func shardIndex(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
 	}
+	return int(hash & (STORE_SHARDS - 1))
+}
+
+// lockShard locks and returns the shard owning key
+func (s *Store) lockShard(key string) *shard {
+	sh := &s.shards[shardIndex(key)]
+	sh.mu.Lock()
+	return sh
+}
+
+// lockShards locks the shards owning the keys in index order, so two
+// goroutines locking the same keys can't deadlock. The returned function
+// unlocks them.
+func (s *Store) lockShards(keys ...string) func() {
+	var locked [STORE_SHARDS]bool
+	for _, key := range keys {
+		locked[shardIndex(key)] = true
+	}
+	for i := range s.shards {
+		if locked[i] {
+			s.shards[i].mu.Lock()
+		}
+	}
+	return func() {
+		for i := len(s.shards) - 1; i >= 0; i-- {
+			if locked[i] {
+				s.shards[i].mu.Unlock()
+			}
+		}
+	}
+}
+
+// entryMemory estimates the bytes used by a key in the dictionary, without
+// its object: objects are accounted once no matter how many keys share them
+func entryMemory(key string) int64 {
+	return DICT_ENTRY_OVERHEAD + STRING_HEADER_SIZE + int64(len(key)) + 8
+}
+
+// expiryMemory estimates the bytes used by a key in the expires dictionary
+func expiryMemory(key string) int64 {
+	return DICT_ENTRY_OVERHEAD + STRING_HEADER_SIZE + int64(len(key)) + 8
+}
+
+// releaseObj drops the reference a key held on obj and unaccounts the
+// object once nobody references it anymore
+func (s *Store) releaseObj(obj *kvObj) {
+	size := obj.memoryUsage()
+	if obj.decrRefCount() {
+		s.usedMemory.Add(-size)
+	}
+}
+
+// deleteKey removes the key from both dictionaries of its (locked) shard
+// and updates the accounting
+func (s *Store) deleteKey(sh *shard, key string) {
+	s.signalModifiedKey(sh, key)
+	if obj, exists := sh.Dict[key]; exists {
+		s.usedMemory.Add(-entryMemory(key))
+		s.releaseObj(obj)
+		delete(sh.Dict, key)
+		sh.unindexKey(key)
+	}
+	if _, exists := sh.Expiry[key]; exists {
+		s.usedMemory.Add(-expiryMemory(key))
+		delete(sh.Expiry, key)
+	}
+}
+
+// putObj stores the object at key, replacing (and releasing) the old one.
+// The key takes over the caller's reference to obj.
+func (s *Store) putObj(sh *shard, key string, obj *kvObj) {
+	s.signalModifiedKey(sh, key)
+	if old, exists := sh.Dict[key]; exists {
+		s.usedMemory.Add(-entryMemory(key))
+		s.releaseObj(old)
+	} else {
+		s.notifyKeyspaceEvent(NOTIFY_NEW, "new", key)
+		sh.indexKey(key)
+	}
+	if obj.getRefCount() == 1 {
+		// a fresh object, the first and only reference
+		s.initLRU(obj)
+		s.usedMemory.Add(obj.memoryUsage())
+	}
+	sh.Dict[key] = obj
+	s.usedMemory.Add(entryMemory(key))
+	s.updatePeakMemory()
+}
+
+// setExpiry sets the expire of a key of the (locked) shard
+func (s *Store) setExpiry(sh *shard, key string, expireAt int64) {
+	s.signalModifiedKey(sh, key)
+	if _, hasExpiry := sh.Expiry[key]; !hasExpiry {
+		s.usedMemory.Add(expiryMemory(key))
+		s.updatePeakMemory()
+	}
+	sh.Expiry[key] = expireAt
+}
+
+// removeExpiry removes the expire of a key of the (locked) shard
+func (s *Store) removeExpiry(sh *shard, key string) {
+	if _, hasExpiry := sh.Expiry[key]; hasExpiry {
+		s.signalModifiedKey(sh, key)
+		s.usedMemory.Add(-expiryMemory(key))
+		delete(sh.Expiry, key)
+	}
+}
+
+// expireIfNeeded deletes the key if its expire is in the past and reports
+// if it did
+func (s *Store) expireIfNeeded(sh *shard, key string) bool {
+	if expireAt, exists := sh.Expiry[key]; exists && time.Now().Unix() > expireAt {
+		s.expireKey(sh, key)
+		return true
+	}
+	return false
+}
+
+// canShareIntegers reports if shared integers may be used. With an LRU or LFU
+// policy every key needs its own lru bits, so objects can't be shared.
+func (s *Store) canShareIntegers() bool {
+	return s.maxMemory.Load() == 0 || s.maxMemoryPolicy.Load()&(MAXMEMORY_FLAG_LRU|MAXMEMORY_FLAG_LFU) == 0
+}
+
+// sharedIntObj returns the shared object for value if it can be used
+func (s *Store) sharedIntObj(value int) (*kvObj, bool) {
+	if value >= 0 && value < OBJ_SHARED_INTEGERS && s.canShareIntegers() {
+		return sharedIntegers[value], true
+	}
+	return nil, false
+}
+
+// newIntObj returns a shared integer object when possible, a new one otherwise
+func (s *Store) newIntObj(value int) *kvObj {
+	if shared, ok := s.sharedIntObj(value); ok {
+		return shared
+	}
+	return createIntObj(value)
 }
 
 // for the given key get the kvObject and return the value
 func (s *Store) GetValue(key string) interface{} {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
 
 	// if it exists in the expiry dictionary, check if it has expired
-	if _, exists := (*s.Expiry)[key]; exists {
-		if time.Now().Unix() > (*s.Expiry)[key] {
-			delete(*s.Expiry, key)
-			delete(*s.Dict, key)
-			return nil // Key has expired
-		}
+	if s.expireIfNeeded(sh, key) {
+		s.keyspaceMisses.Add(1)
+		s.notifyKeyspaceEvent(NOTIFY_KEY_MISS, "keymiss", key)
+		return nil // Key has expired
 	}
 	// only return if the ref count if greater than 0
-	if obj, exists := (*s.Dict)[key]; exists && obj.refcount > 0 {
-		// Handle different encodings based on the object's encoding
-		switch obj.getEncoding() {
-		case OBJ_ENCODING_INT:
-			// For integer encoding, the ptr points to an int
-			return *(*int)(obj.ptr)
-		case OBJ_ENCODING_RAW:
-			// For raw string encoding, the ptr points to a string
-			return *(*string)(obj.ptr)
-		default:
-			// For other encodings, return the pointer as-is
-			return obj.ptr
-		}
+	if obj, exists := sh.Dict[key]; exists && obj.getRefCount() > 0 {
+		s.keyspaceHits.Add(1)
+		s.touch(obj)
+		// int for int encoded values, string for raw ones
+		return obj.value()
 	}
+	s.keyspaceMisses.Add(1)
+	s.notifyKeyspaceEvent(NOTIFY_KEY_MISS, "keymiss", key)
 	return nil
 }
 
 func (s *Store) SetValue(key string, value interface{}) {
+	var kvObj *kvObj
 	if strVal, ok := value.(string); ok {
+		kvObj = createStringObj(strVal)
 		// Try to convert string to integer first
-		if intVal, err := strconv.Atoi(strVal); err == nil {
-			// It's a valid integer, store as int
-			kvObj := createIntObj(intVal)
-			(*s.Dict)[key] = *kvObj
-		} else {
-			// Not a valid integer, store as string
-			kvObj := createStringObj(strVal)
-			(*s.Dict)[key] = *kvObj
+		if kvObj.tryIntEncoding() {
+			// It's a valid integer, store as int (possibly a shared one)
+			if shared, ok := s.sharedIntObj(kvObj.num); ok {
+				kvObj = shared
+			}
 		}
 	} else if intVal, ok := value.(int); ok {
-		kvObj := createIntObj(intVal)
-		(*s.Dict)[key] = *kvObj
-	}	
+		kvObj = s.newIntObj(intVal)
+	} else {
+		return
+	}
+
+	sh := s.lockShard(key)
+	s.putObj(sh, key, kvObj)
+	s.notifyKeyspaceEvent(NOTIFY_STRING, "set", key)
+	sh.mu.Unlock()
 }
 
 
 func (s *Store) DeleteValue(key string) bool {
-	if obj, exists := (*s.Dict)[key]; exists {
-		obj.refcount = 0 // we can remove the key from the dictionary
-		delete(*s.Dict, key)
-		delete(*s.Expiry, key)
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	if _, exists := sh.Dict[key]; exists {
+		// drops the key's reference, the value is freed with its last one
+		s.deleteKey(sh, key)
+		s.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key)
 		return true
 	}
 	return false
 }
 
 func (s *Store) Exists(key string) bool {
-	obj, exists := (*s.Dict)[key]
-	return exists && obj.refcount > 0
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, exists := sh.Dict[key]
+	return exists && obj.getRefCount() > 0
 }
 
 func (s *Store) GetTTL(key string) int {
-	if _, exists := (*s.Dict)[key]; !exists {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	if _, exists := sh.Dict[key]; !exists {
 		return -2 // Key doesn't exist at all
 	}
-	
+
 	// Check if key has expiry set
-	ttl, hasExpiry := (*s.Expiry)[key]
+	ttl, hasExpiry := sh.Expiry[key]
 	if !hasExpiry {
 		return -1 // Key exists but has no expiry
 	}
-	
+
 	// calculate the time difference between the current time and the expiry time
 	timeDiff := time.Until(time.Unix(ttl, 0))
 
 	if timeDiff.Seconds() < 0 {
-		delete(*s.Expiry, key)
-		delete(*s.Dict, key)
+		s.expireKey(sh, key)
 		return -2 // Key has expired
 	}
 
@@ -124,49 +326,117 @@ func (s *Store) GetTTL(key string) int {
 
 // SetTTL sets the time-to-live for a key in seconds
 func (s *Store) SetTTL(key string, ttl int64) bool {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
 	// Check if the key exists in the main dictionary
-	if _, exists := (*s.Dict)[key]; !exists {
+	if _, exists := sh.Dict[key]; !exists {
 		return false // Key doesn't exist
 	}
-	
+
 	// Set the expiry
-	(*s.Expiry)[key] = ttl
+	s.setExpiry(sh, key, ttl)
+	s.notifyKeyspaceEvent(NOTIFY_GENERIC, "expire", key)
 	return true
 }
 
 // RemoveExpiry removes the TTL from a key, making it persistent
 func (s *Store) RemoveExpiry(key string) bool {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
 	// Check if the key exists in the main dictionary
-	if _, exists := (*s.Dict)[key]; !exists {
+	if _, exists := sh.Dict[key]; !exists {
 		return false // Key doesn't exist
 	}
-	
+
 	// Remove from expiry dictionary
-	delete(*s.Expiry, key)
+	if _, hasExpiry := sh.Expiry[key]; hasExpiry {
+		s.removeExpiry(sh, key)
+		s.notifyKeyspaceEvent(NOTIFY_GENERIC, "persist", key)
+	}
 	return true
 }
 
+// ErrNotInteger is returned when incrementing a value that isn't an integer
+var ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+
 func (s *Store) IncreBy(key string, value int) (int, error) {
-	if obj, exists := (*s.Dict)[key]; exists {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	if obj, exists := sh.Dict[key]; exists {
 		// check for the encoding must be int
 		if obj.getEncoding() != OBJ_ENCODING_INT {
-			return 0, errors.New("can't increment other value than value of type Int")
+			return 0, ErrNotInteger
 		}
-
-		*(*int)(obj.ptr) += value
-		return  *(*int)(obj.ptr), nil 
+		newValue := s.addToIntObj(sh, key, obj, value)
+		s.notifyKeyspaceEvent(NOTIFY_STRING, "incrby", key)
+		return newValue, nil
 	}
-	return 0, errors.New("key doesn't exist")
+	return 0, ErrNoSuchKey
 }
 
 func (s *Store) DecreBy(key string, value int) (int, error) {
-	if obj, exists := (*s.Dict)[key]; exists {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	if obj, exists := sh.Dict[key]; exists {
 		 // check for the encoding must be int
 		if obj.getEncoding() != OBJ_ENCODING_INT {
-			return 0 , errors.New("can't decrement other value than value of type Int")
+			return 0 , ErrNotInteger
 		}
-		*(*int)(obj.ptr) -= value
-			return 	*(*int)(obj.ptr) , nil
+		newValue := s.addToIntObj(sh, key, obj, -value)
+		s.notifyKeyspaceEvent(NOTIFY_STRING, "decrby", key)
+		return newValue, nil
+	}
+	return 0, ErrNoSuchKey
+}
+
+// addToIntObj adds delta to the integer stored at key. The object is only
+// updated in place when this key is its sole owner, otherwise (shared
+// integer, COPY) the key gets a new object so other keys don't see the change.
+func (s *Store) addToIntObj(sh *shard, key string, obj *kvObj, delta int) int {
+	current, _ := obj.intValue()
+	newValue := current + delta
+	_, sharable := s.sharedIntObj(newValue)
+	if obj.getRefCount() == 1 && !sharable {
+		s.signalModifiedKey(sh, key)
+		s.touch(obj)
+		obj.num = newValue
+		return newValue
 	}
-	return 0, errors.New("key doesn't exist")
-}
\ No newline at end of file
+	s.putObj(sh, key, s.newIntObj(newValue))
+	return newValue
+}
+
+// Copy makes destination reference the same object as source, including its
+// expire. It returns false if source doesn't exist or destination exists and
+// replace is false.
+func (s *Store) Copy(source string, destination string, replace bool) bool {
+	if source == destination {
+		return false
+	}
+	unlock := s.lockShards(source, destination)
+	defer unlock()
+
+	srcShard := &s.shards[shardIndex(source)]
+	dstShard := &s.shards[shardIndex(destination)]
+	obj, exists := s.lookupNoTouch(srcShard, source)
+	if !exists {
+		return false
+	}
+	if _, exists := s.lookupNoTouch(dstShard, destination); exists && !replace {
+		return false
+	}
+
+	obj.incrRefCount()
+	s.putObj(dstShard, destination, obj)
+	if expireAt, hasExpiry := srcShard.Expiry[source]; hasExpiry {
+		s.setExpiry(dstShard, destination, expireAt)
+	} else {
+		s.removeExpiry(dstShard, destination)
+	}
+	s.notifyKeyspaceEvent(NOTIFY_GENERIC, "copy_to", destination)
+	return true
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+)
+
+const benchKeys = 1 << 16
+
+var benchKeyNames = func() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func BenchmarkSetValueString(b *testing.B) {
+	s := NewStore()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.SetValue(benchKeyNames[i%benchKeys], "some string value")
+	}
+}
+
+func BenchmarkSetValueInt(b *testing.B) {
+	s := NewStore()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.SetValue(benchKeyNames[i%benchKeys], "1234567")
+	}
+}
+
+func BenchmarkGetValue(b *testing.B) {
+	s := NewStore()
+	for _, key := range benchKeyNames {
+		s.SetValue(key, "some string value")
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetValue(benchKeyNames[i%benchKeys])
+	}
+}
+
+func BenchmarkIncreBy(b *testing.B) {
+	s := NewStore()
+	for _, key := range benchKeyNames {
+		s.SetValue(key, "1000000")
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.IncreBy(benchKeyNames[i%benchKeys], 1)
+	}
+}
+
+// BenchmarkMemoryPerKey reports the heap bytes retained per stored key
+func BenchmarkMemoryPerKey(b *testing.B) {
+	for _, value := range []string{"some string value", "1234567"} {
+		b.Run(value, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s := NewStore()
+				for _, key := range benchKeyNames {
+					s.SetValue(key, value)
+				}
+			}
+		})
+	}
+}
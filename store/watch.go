@@ -0,0 +1,79 @@
+package store
+
+import "sync/atomic"
+
+// Watcher backs WATCH for a client: it is flagged as soon as one of the keys
+// it watches is modified, so EXEC knows the transaction must be aborted.
+type Watcher struct {
+	dirty atomic.Bool
+	keys  []string
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{}
+}
+
+// IsDirty reports if a watched key was modified since it was watched
+func (w *Watcher) IsDirty() bool {
+	return w.dirty.Load()
+}
+
+// Watch starts watching key for modifications
+func (s *Store) Watch(key string, w *Watcher) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	// an expired key must be gone before watching starts, otherwise its lazy
+	// deletion would look like a modification
+	s.expireIfNeeded(sh, key)
+
+	if sh.watched == nil {
+		sh.watched = make(map[string]map[*Watcher]struct{})
+	}
+	watchers, exists := sh.watched[key]
+	if !exists {
+		watchers = make(map[*Watcher]struct{})
+		sh.watched[key] = watchers
+	}
+	if _, watching := watchers[w]; !watching {
+		watchers[w] = struct{}{}
+		w.keys = append(w.keys, key)
+	}
+}
+
+// UnwatchAll stops watching every key and clears the dirty flag
+func (s *Store) UnwatchAll(w *Watcher) {
+	for _, key := range w.keys {
+		sh := s.lockShard(key)
+		if watchers, exists := sh.watched[key]; exists {
+			delete(watchers, w)
+			if len(watchers) == 0 {
+				delete(sh.watched, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	w.keys = w.keys[:0]
+	w.dirty.Store(false)
+}
+
+// SetModifiedKeyHook sets a function called with every key modified: the
+// server uses it to wake the clients blocked on the key. It is called with
+// the shard of the key locked, so it must not block nor use the store. It
+// must be set before the store is used concurrently.
+func (s *Store) SetModifiedKeyHook(hook func(key string)) {
+	s.modifiedKeyHook = hook
+}
+
+// signalModifiedKey flags the watchers of a key of the (locked) shard
+func (s *Store) signalModifiedKey(sh *shard, key string) {
+	if s.modifiedKeyHook != nil {
+		s.modifiedKeyHook(key)
+	}
+	if len(sh.watched) == 0 {
+		return
+	}
+	for w := range sh.watched[key] {
+		w.dirty.Store(true)
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	modifications := []struct {
+		name   string
+		modify func(s *Store)
+	}{
+		{"SET", func(s *Store) { s.SetValue("k", "v2") }},
+		{"DEL", func(s *Store) { s.DeleteValue("k") }},
+		{"INCRBY in place", func(s *Store) { s.SetValue("k", "100000"); s.IncreBy("k", 1) }},
+		{"EXPIRE", func(s *Store) { s.SetTTL("k", time.Now().Unix()+100) }},
+		{"COPY onto the key", func(s *Store) { s.SetValue("src", "v"); s.Copy("src", "k", true) }},
+	}
+	for _, m := range modifications {
+		t.Run(m.name, func(t *testing.T) {
+			s := NewStore()
+			s.SetValue("k", "v")
+			w := NewWatcher()
+			s.Watch("k", w)
+			m.modify(s)
+			if !w.IsDirty() {
+				t.Errorf("Expected %s to flag the watcher", m.name)
+			}
+		})
+	}
+
+	t.Run("reads and other keys don't flag the watcher", func(t *testing.T) {
+		s := NewStore()
+		s.SetValue("k", "v")
+		w := NewWatcher()
+		s.Watch("k", w)
+		s.GetValue("k")
+		s.Exists("k")
+		s.SetValue("other", "v")
+		s.RemoveExpiry("k")
+		if w.IsDirty() {
+			t.Error("Expected the watcher not to be flagged")
+		}
+	})
+
+	t.Run("UnwatchAll", func(t *testing.T) {
+		s := NewStore()
+		w := NewWatcher()
+		s.Watch("k", w)
+		s.SetValue("k", "v")
+		s.UnwatchAll(w)
+		if w.IsDirty() {
+			t.Error("Expected UnwatchAll to clear the dirty flag")
+		}
+		s.SetValue("k", "v2")
+		if w.IsDirty() {
+			t.Error("Expected unwatched keys not to flag the watcher")
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"sort"
+)
+
+// ZSET_ENTRY_OVERHEAD is the estimated cost of a sorted set member without
+// its bytes: its dict entry and its slot in the ordered index
+const ZSET_ENTRY_OVERHEAD = DICT_ENTRY_OVERHEAD + STRING_HEADER_SIZE + 8 + 24
+
+func zsetEntryMemory(member string) int64 {
+	return ZSET_ENTRY_OVERHEAD + int64(len(member))
+}
+
+// ZMember is a member of a sorted set with its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// less orders the members by score, then by member
+func (m ZMember) less(other ZMember) bool {
+	if m.Score != other.Score {
+		return m.Score < other.Score
+	}
+	return m.Member < other.Member
+}
+
+// zset holds the scores by member and the members in order. The Redis
+// skiplist encoding is the same idea: a dict for lookups and an ordered
+// index for ranges.
+type zset struct {
+	dict   map[string]float64
+	sorted []ZMember
+}
+
+func newZset() *zset {
+	return &zset{dict: make(map[string]float64)}
+}
+
+func (z *zset) duplicate() *zset {
+	dup := &zset{
+		dict:   make(map[string]float64, len(z.dict)),
+		sorted: make([]ZMember, len(z.sorted)),
+	}
+	for member, score := range z.dict {
+		dup.dict[member] = score
+	}
+	copy(dup.sorted, z.sorted)
+	return dup
+}
+
+// search returns the position of m in the ordered index, or where it goes
+func (z *zset) search(m ZMember) int {
+	return sort.Search(len(z.sorted), func(i int) bool {
+		return !z.sorted[i].less(m)
+	})
+}
+
+// add adds the member or updates its score. It returns true if the member is new.
+func (z *zset) add(m ZMember) bool {
+	score, exists := z.dict[m.Member]
+	if exists {
+		if score == m.Score {
+			return false
+		}
+		i := z.search(ZMember{Member: m.Member, Score: score})
+		z.sorted = append(z.sorted[:i], z.sorted[i+1:]...)
+	}
+	z.dict[m.Member] = m.Score
+	i := z.search(m)
+	z.sorted = append(z.sorted, ZMember{})
+	copy(z.sorted[i+1:], z.sorted[i:])
+	z.sorted[i] = m
+	return !exists
+}
+
+func createZsetObj() *kvObj {
+	obj := &kvObj{
+		refcount: 1,
+	}
+
+	obj.setType(OBJ_ZSET)
+	obj.setEncoding(OBJ_ENCODING_SKIPLIST)
+	obj.zset = newZset()
+	return obj
+}
+
+// ZAdd adds the members to the sorted set stored at key, updating the score
+// of the existing ones. It returns the number of members added.
+func (s *Store) ZAdd(key string, members []ZMember) (int, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupTyped(sh, key, OBJ_ZSET)
+	if err != nil {
+		return 0, err
+	}
+	if obj == nil {
+		obj = createZsetObj()
+		s.putObj(sh, key, obj)
+	} else {
+		obj = s.unshareObj(sh, key, obj)
+		s.signalModifiedKey(sh, key)
+	}
+	added := 0
+	for _, m := range members {
+		if obj.zset.add(m) {
+			s.usedMemory.Add(zsetEntryMemory(m.Member))
+			added++
+		}
+	}
+	s.updatePeakMemory()
+	s.notifyKeyspaceEvent(NOTIFY_ZSET, "zadd", key)
+	return added, nil
+}
+
+// ZPopMin removes and returns up to count members with the lowest scores
+// from the sorted set stored at key, nil if there is no key
+func (s *Store) ZPopMin(key string, count int) ([]ZMember, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupTyped(sh, key, OBJ_ZSET)
+	if obj == nil || err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return []ZMember{}, nil
+	}
+	obj = s.unshareObj(sh, key, obj)
+	s.signalModifiedKey(sh, key)
+
+	n := min(count, len(obj.zset.sorted))
+	popped := make([]ZMember, n)
+	copy(popped, obj.zset.sorted[:n])
+	obj.zset.sorted = append(obj.zset.sorted[:0], obj.zset.sorted[n:]...)
+	for _, m := range popped {
+		delete(obj.zset.dict, m.Member)
+		s.usedMemory.Add(-zsetEntryMemory(m.Member))
+	}
+	s.notifyKeyspaceEvent(NOTIFY_ZSET, "zpopmin", key)
+	if len(obj.zset.sorted) == 0 {
+		s.deleteKey(sh, key)
+		s.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key)
+	}
+	return popped, nil
+}
+
+// ZCard returns the number of members of the sorted set stored at key
+func (s *Store) ZCard(key string) (int, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupRead(sh, key, OBJ_ZSET)
+	if obj == nil || err != nil {
+		return 0, err
+	}
+	return len(obj.zset.sorted), nil
+}
+
+// ZRange returns the members of the sorted set stored at key from rank start
+// to rank stop included, lowest scores first
+func (s *Store) ZRange(key string, start int, stop int) ([]ZMember, error) {
+	sh := s.lockShard(key)
+	defer sh.mu.Unlock()
+
+	obj, err := s.lookupRead(sh, key, OBJ_ZSET)
+	if obj == nil || err != nil {
+		return nil, err
+	}
+	start, stop, ok := rangeIndexes(start, stop, len(obj.zset.sorted))
+	if !ok {
+		return []ZMember{}, nil
+	}
+	members := make([]ZMember, stop-start+1)
+	copy(members, obj.zset.sorted[start:stop+1])
+	return members, nil
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestZset(t *testing.T) {
+	s := NewStore()
+	added, err := s.ZAdd("zset", []ZMember{{"c", 3}, {"a", 1}, {"b", 1}, {"low", math.Inf(-1)}})
+	if added != 4 || err != nil {
+		t.Fatalf("Expected 4 members added, got %d (%v)", added, err)
+	}
+	// updating a score moves the member, it isn't counted as added
+	if added, _ := s.ZAdd("zset", []ZMember{{"c", 0.5}}); added != 0 {
+		t.Errorf("Expected an update not to count as added, got %d", added)
+	}
+	if card, _ := s.ZCard("zset"); card != 4 {
+		t.Errorf("Expected 4 members, got %d", card)
+	}
+
+	expected := []ZMember{{"low", math.Inf(-1)}, {"c", 0.5}, {"a", 1}, {"b", 1}}
+	if members, _ := s.ZRange("zset", 0, -1); !reflect.DeepEqual(members, expected) {
+		t.Errorf("Expected %v, got %v", expected, members)
+	}
+	if members, _ := s.ZPopMin("zset", 2); !reflect.DeepEqual(members, expected[:2]) {
+		t.Errorf("Expected %v, got %v", expected[:2], members)
+	}
+	if members, _ := s.ZPopMin("zset", 5); !reflect.DeepEqual(members, expected[2:]) {
+		t.Errorf("Expected %v, got %v", expected[2:], members)
+	}
+	if s.Exists("zset") {
+		t.Error("Expected the key to be deleted with its last member")
+	}
+	if s.UsedMemory() != 0 {
+		t.Errorf("Expected used memory to be 0 once the sorted set is gone, got %d", s.UsedMemory())
+	}
+
+	s.SetValue("str", "value")
+	if _, err := s.ZAdd("str", []ZMember{{"a", 1}}); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+// StringMatch reports if str matches the glob-style pattern, with the same
+// rules as Redis: a star matches any sequence of characters, ? any single
+// character, [abc] one of the characters ([^abc] any other, [a-z] a range)
+// and a backslash the character after it literally.
+//
+// On a mismatch only the last star seen takes one more character, the ones
+// before it never need to: the matching takes at most len(pattern) *
+// len(str) steps, whatever the pattern.
+func StringMatch(pattern string, str string, nocase bool) bool {
+	p, s := 0, 0
+	star, starS := -1, 0 // the last star of the pattern and where it started
+	for s < len(str) {
+		if p < len(pattern) && pattern[p] == '*' {
+			star, starS = p, s
+			p++
+			continue
+		}
+		if p < len(pattern) {
+			if next, ok := matchByte(pattern, p, str[s], nocase); ok {
+				p, s = next, s+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starS++
+		p, s = star+1, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchByte matches c against the element of the pattern at p, anything
+// but a star. It returns the position of the next element.
+func matchByte(pattern string, p int, c byte, nocase bool) (int, bool) {
+	switch pattern[p] {
+	case '?':
+		return p + 1, true
+	case '[':
+		p++
+		not := p < len(pattern) && pattern[p] == '^'
+		if not {
+			p++
+		}
+		match := false
+		for p < len(pattern) && pattern[p] != ']' {
+			if pattern[p] == '\\' && p+1 < len(pattern) {
+				p++
+				if equalByte(pattern[p], c, nocase) {
+					match = true
+				}
+			} else if p+2 < len(pattern) && pattern[p+1] == '-' {
+				start, end, c := pattern[p], pattern[p+2], c
+				if start > end {
+					start, end = end, start
+				}
+				if nocase {
+					start, end, c = lower(start), lower(end), lower(c)
+				}
+				if c >= start && c <= end {
+					match = true
+				}
+				p += 2
+			} else if equalByte(pattern[p], c, nocase) {
+				match = true
+			}
+			p++
+		}
+		if p < len(pattern) {
+			p++ // the closing bracket, an unclosed class ends with the pattern
+		}
+		return p, match != not
+	case '\\':
+		if p+1 < len(pattern) {
+			p++
+		}
+	}
+	return p + 1, equalByte(pattern[p], c, nocase)
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		nocase  bool
+		match   bool
+	}{
+		{"*", "", false, true},
+		{"*", "anything", false, true},
+		{"news.*", "news.sport", false, true},
+		{"news.*", "news", false, false},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "heeeello", false, true},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-c]llo", "hbllo", false, true},
+		{"h[a-c]llo", "hdllo", false, false},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"HELLO", "hello", false, false},
+		{"HELLO", "hello", true, true},
+		{"a*b*c", "axxbyyc", false, true},
+		{"a*b*c", "axxbyy", false, false},
+		{"*a*b", "aabab", false, true},
+		{"a**", "a", false, true},
+		{"*?", "", false, false},
+		{"[abc", "a", false, true},
+		{"x\\", "x\\", false, true},
+		{"h[a-c]*", "HB", true, true},
+	}
+	for _, tt := range tests {
+		if got := StringMatch(tt.pattern, tt.str, tt.nocase); got != tt.match {
+			t.Errorf("StringMatch(%q, %q, %v) = %v, expected %v", tt.pattern, tt.str, tt.nocase, got, tt.match)
+		}
+	}
+}
+
+func TestStringMatchPathological(t *testing.T) {
+	pattern := strings.Repeat("*a", 12) + "*b"
+	str := strings.Repeat("a", 40)
+	done := make(chan bool)
+	go func() { done <- StringMatch(pattern, str, false) }()
+	select {
+	case match := <-done:
+		if match {
+			t.Errorf("Expected %q not to match %q", pattern, str)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out matching a pattern with many stars")
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -37,62 +38,34 @@ func IsRESPFormat(input string) bool {
 	return false
 }
 
-func ToRESP(command string) (string, error) {
-	parts := strings.Fields(command) // Split command by spaces
-
-	if len(parts) == 0 {
-		return "", fmt.Errorf("empty command")
+// ParseMemory converts a memory size like "100mb" or "1gb" into bytes.
+// Units follow Redis: k/m/g are powers of 1000, kb/mb/gb powers of 1024.
+func ParseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024},
+		{"mb", 1024 * 1024},
+		{"gb", 1024 * 1024 * 1024},
+		{"k", 1000},
+		{"m", 1000 * 1000},
+		{"g", 1000 * 1000 * 1000},
+		{"b", 1},
 	}
 
-	cmd := strings.ToUpper(parts[0]) // Get the command name (e.g., "SET")
-
-	var respBuilder strings.Builder
-
-	switch cmd {
-	case "BGSAVE":
-		if len(parts) > 1 {
-			return "", fmt.Errorf("%s Command doesn't take any additional parameter", cmd)
-		}
-		respBuilder.WriteString(fmt.Sprintf("*%d\r\n", len(parts)))
-		for _, part := range parts {
-			respBuilder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(part), part))
-		}
-		return respBuilder.String(), nil
-	case "EXPIRE", "EXPIREAT":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("%s command requires at least two arguments: key and TTL", cmd)
-		}
-		// The number of arguments in the array will be 1 (command) + key + TTL
-		respBuilder.WriteString(fmt.Sprintf("*%d\r\n", len(parts)))
-		for _, part := range parts {
-			respBuilder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(part), part))
-		}
-		return respBuilder.String(), nil
-	case "SET", "INCRBY", "DECRBY":
-		// SET key value [EX seconds] [PX milliseconds] [NX|XX]
-		if len(parts) < 3 {
-			return "", fmt.Errorf("SET command requires at least a key and a value")
+	value = strings.ToLower(strings.TrimSpace(value))
+	mul := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			mul = unit.mul
+			break
 		}
-		// The number of arguments in the array will be 1 (SET) + key + value + options
-		respBuilder.WriteString(fmt.Sprintf("*%d\r\n", len(parts)))
-		for _, part := range parts {
-			respBuilder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(part), part))
-		}
-		return respBuilder.String(), nil
-
-	// all uppercase commands or all lowercase commands both are valid
-	case "GET", "DEL", "EXISTS", "TTL", "PERSIST":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("%s command requires at least one key", cmd)
-		}
-		// For GET, DEL, EXISTS, TTL, PERSIST, the number of arguments is 1 (command) + number of keys
-		respBuilder.WriteString(fmt.Sprintf("*%d\r\n", len(parts)))
-		for _, part := range parts {
-			respBuilder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(part), part))
-		}
-		return respBuilder.String(), nil
-
-	default:
-		return "", fmt.Errorf("unsupported command: %s", cmd)
 	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size: %s", value)
+	}
+	return n * mul, nil
 }
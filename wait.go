@@ -0,0 +1,267 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+	"github.com/shubhdevelop/YAKVS/resp"
+)
+
+/*
+WAIT and WAITAOF block a client until its last write is durable.
+
+Every client remembers the replication offset the history reached with its
+last write (woff) and the offset the AOF reached (aofOff). WAIT waits for
+replicas to acknowledge an offset at least as large as woff: the replicas
+acknowledge every second, and right away when asked by a REPLCONF GETACK
+in the history, which is sent when a client starts to wait.
+
+WAITAOF waits for the AOF of this server to be fsynced up to aofOff, and
+for replicas to have fsynced their AOF up to woff. A replica tells the
+offset it fsynced at along with the one it reached (REPLCONF ACK offset
+FACK aofoffset), and again after every fsync. With appendfsync always the
+writes are fsynced before the commands reply, with everysec the server
+cron fsyncs every second, and with no only when a client waits for it.
+*/
+
+var waiters = struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced when waiting clients may be done
+	aof     atomic.Int64  // clients waiting for the AOF of this server
+}{
+	changed: make(chan struct{}),
+}
+
+// signalWaiters wakes the clients in WAIT and WAITAOF, an acknowledgement
+// or an fsync may be what they wait for
+func signalWaiters() {
+	waiters.mu.Lock()
+	defer waiters.mu.Unlock()
+	close(waiters.changed)
+	waiters.changed = make(chan struct{})
+}
+
+// waitersChanged returns a channel closed by the next signalWaiters
+func waitersChanged() <-chan struct{} {
+	waiters.mu.Lock()
+	defer waiters.mu.Unlock()
+	return waiters.changed
+}
+
+// aofFsync tracks the fsyncs of the AOF run by the server cron
+var aofFsync = struct {
+	mu         sync.Mutex
+	last       time.Time
+	replOffset int64 // replication offset the AOF was last fsynced at
+}{}
+
+// fsyncAOF fsyncs the AOF, and records the replication offset it covers:
+// the commands are appended to the AOF before they're added to the
+// history, so the offset read before the fsync is covered
+func fsyncAOF() {
+	repl.mu.Lock()
+	offset := repl.offset
+	repl.mu.Unlock()
+	if err := aofManager.Fsync(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	aofFsync.mu.Lock()
+	aofFsync.replOffset = max(aofFsync.replOffset, offset)
+	aofFsync.last = time.Now()
+	aofFsync.mu.Unlock()
+	signalWaiters()
+}
+
+// aofFsyncedReplOffset returns the replication offset the AOF was fsynced
+// at, what a replica acknowledges for WAITAOF
+func aofFsyncedReplOffset() int64 {
+	if aofManager == nil {
+		return 0
+	}
+	if aofManager.FsyncPolicy() == aof.AOF_FSYNC_ALWAYS {
+		repl.mu.Lock()
+		defer repl.mu.Unlock()
+		return repl.offset
+	}
+	aofFsync.mu.Lock()
+	defer aofFsync.mu.Unlock()
+	return aofFsync.replOffset
+}
+
+// aofCron fsyncs the AOF every second with appendfsync everysec, and
+// whenever clients wait for it unless every write is fsynced already.
+// A replica acknowledges what it fsynced right away.
+func aofCron() {
+	if aofManager == nil {
+		return
+	}
+	policy := aofManager.FsyncPolicy()
+	aofFsync.mu.Lock()
+	due := time.Since(aofFsync.last) >= time.Second
+	aofFsync.mu.Unlock()
+	if policy == aof.AOF_FSYNC_ALWAYS || (policy == aof.AOF_FSYNC_NO || !due) && waiters.aof.Load() == 0 {
+		return
+	}
+	before := aofFsyncedReplOffset()
+	fsyncAOF()
+	if aofFsyncedReplOffset() == before {
+		return
+	}
+	repl.mu.Lock()
+	master := repl.master
+	repl.mu.Unlock()
+	if master != nil {
+		master.sendAck()
+	}
+}
+
+// requestAcks asks the replicas to acknowledge their offset now
+func requestAcks() {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog != nil && len(repl.replicas) > 0 {
+		feedLocked([]byte(aof.EncodeCommand(&parser.Command{Name: "REPLCONF", Args: []string{"GETACK", "*"}})))
+	}
+}
+
+// countReplicaAcks returns the number of replicas that acknowledged offset,
+// or fsynced their AOF at offset
+func countReplicaAcks(offset int64, fsynced bool) int {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	n := 0
+	for _, r := range repl.replicas {
+		if !fsynced && r.ackOffset >= offset || fsynced && r.aofAckOffset >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// parseWaitTimeout parses the timeout of WAIT and WAITAOF in milliseconds,
+// 0 means no timeout
+func parseWaitTimeout(w *resp.Writer, arg string) (time.Duration, bool) {
+	timeout, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		w.WriteError("ERR timeout is not an integer or out of range")
+		return 0, false
+	}
+	if timeout < 0 {
+		w.WriteError("ERR timeout is negative")
+		return 0, false
+	}
+	return time.Duration(timeout) * time.Millisecond, true
+}
+
+// waitFor blocks the client until done reports true, the timeout elapses
+// or the connection is gone
+func (c *Client) waitFor(timeout time.Duration, done func() bool) {
+	if done() {
+		return
+	}
+	requestAcks()
+	c.setBlocked(true)
+	defer c.setBlocked(false)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		changed := waitersChanged()
+		if done() {
+			return
+		}
+		select {
+		case <-changed:
+		case <-expired:
+			return
+		case <-c.gone:
+			return
+		}
+	}
+}
+
+// wait handles WAIT numreplicas timeout: it replies with the number of
+// replicas that acknowledged the last write of the client, once there are
+// numreplicas of them or the timeout elapses. It doesn't block in a
+// transaction.
+func (c *Client) wait(args []string, block bool) {
+	w := c.writer()
+	numReplicas, err := strconv.Atoi(args[0])
+	if err != nil {
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	timeout, ok := parseWaitTimeout(w, args[1])
+	if !ok {
+		return
+	}
+	if replicationRole() != "master" {
+		w.WriteError("ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+		return
+	}
+	woff := c.woff
+	if block {
+		c.waitFor(timeout, func() bool {
+			return countReplicaAcks(woff, false) >= numReplicas
+		})
+	}
+	w.WriteInteger(int64(countReplicaAcks(woff, false)))
+}
+
+// waitAOF handles WAITAOF numlocal numreplicas timeout: it replies with
+// whether the AOF of this server was fsynced after the last write of the
+// client, and the number of replicas that fsynced it, once numlocal and
+// numreplicas are reached or the timeout elapses. It doesn't block in a
+// transaction.
+func (c *Client) waitAOF(args []string, block bool) {
+	w := c.writer()
+	numLocal, err1 := strconv.Atoi(args[0])
+	numReplicas, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil {
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	timeout, ok := parseWaitTimeout(w, args[2])
+	if !ok {
+		return
+	}
+	if replicationRole() != "master" {
+		w.WriteError("ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+		return
+	}
+	if numLocal > 0 && c.aof == nil {
+		w.WriteError("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+		return
+	}
+	woff, aofOff := c.woff, c.aofOff
+	local := func() int {
+		if c.aof == nil {
+			return 0
+		}
+		if _, fsynced := c.aof.Offsets(); fsynced < aofOff {
+			return 0
+		}
+		return 1
+	}
+	if block {
+		if numLocal > 0 {
+			waiters.aof.Add(1)
+			defer waiters.aof.Add(-1)
+		}
+		c.waitFor(timeout, func() bool {
+			return local() >= numLocal && countReplicaAcks(woff, true) >= numReplicas
+		})
+	}
+	w.WriteArrayLen(2)
+	w.WriteInteger(int64(local()))
+	w.WriteInteger(int64(countReplicaAcks(woff, true)))
+}
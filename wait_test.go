@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shubhdevelop/YAKVS/aof"
+	"github.com/shubhdevelop/YAKVS/parser"
+)
+
+func TestAOFOffsets(t *testing.T) {
+	manager := aof.NewAOFManager(filepath.Join(t.TempDir(), "appendonly.aof"))
+	if err := manager.Initialize(); err != nil {
+		t.Fatalf("Error opening the AOF: %v", err)
+	}
+	defer manager.Close()
+	if err := manager.SetFsyncPolicy("sometimes"); err == nil {
+		t.Errorf("Expected an unknown fsync policy to be refused")
+	}
+
+	if err := manager.SetFsyncPolicy(aof.AOF_FSYNC_NO); err != nil {
+		t.Fatalf("Error setting the fsync policy: %v", err)
+	}
+	manager.AppendCommand(&parser.Command{Name: "SET", Args: []string{"k", "v"}})
+	written, fsynced := manager.Offsets()
+	if written == 0 || fsynced != 0 {
+		t.Errorf("Expected a write not fsynced yet, got %d written and %d fsynced", written, fsynced)
+	}
+	if err := manager.Fsync(); err != nil {
+		t.Fatalf("Error fsyncing: %v", err)
+	}
+	if _, fsynced := manager.Offsets(); fsynced != written {
+		t.Errorf("Expected %d fsynced, got %d", written, fsynced)
+	}
+
+	manager.SetFsyncPolicy(aof.AOF_FSYNC_ALWAYS)
+	manager.AppendCommand(&parser.Command{Name: "DEL", Args: []string{"k"}})
+	if written, fsynced := manager.Offsets(); fsynced != written {
+		t.Errorf("Expected every write fsynced with always, got %d written and %d fsynced", written, fsynced)
+	}
+}
+
+func TestWait(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.send("WAIT", "0", "0")
+	c.expect(":0\r\n")
+	c.send("WAIT", "one", "0")
+	c.expect("-ERR value is not an integer or out of range\r\n")
+	c.send("WAIT", "1", "-1")
+	c.expect("-ERR timeout is negative\r\n")
+	c.send("WAIT", "1", "soon")
+	c.expect("-ERR timeout is not an integer or out of range\r\n")
+
+	start := time.Now()
+	c.send("WAIT", "1", "100")
+	c.expect(":0\r\n")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected WAIT to block until its timeout, returned after %v", elapsed)
+	}
+
+	c.send("WAITAOF", "0", "0", "0")
+	c.expect("*2\r\n:0\r\n:0\r\n")
+	c.send("WAITAOF", "1", "0", "0")
+	c.expect("-ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.\r\n")
+
+	// in a transaction they reply right away
+	c.send("MULTI")
+	c.expect("+OK\r\n")
+	c.send("WAIT", "1", "0")
+	c.expect("+QUEUED\r\n")
+	c.send("EXEC")
+	c.expect("*1\r\n:0\r\n")
+}
+
+func TestWaitProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts server processes")
+	}
+	master, _ := startProcess(t, "-appendfsync", "no")
+	m := dial(t, master)
+	host, port, _ := net.SplitHostPort(master)
+	replicaAddr, _ := startProcess(t, "-replicaof", host+" "+port, "-appendfsync", "everysec")
+	r := dial(t, replicaAddr)
+	eventually(t, "the link to be up", func() bool {
+		return r.info("replication")["master_link_status"] == "up"
+	})
+
+	m.send("SET", "durable", "yes")
+	m.expect("+OK\r\n")
+	m.send("WAIT", "1", "5000")
+	m.expect(":1\r\n")
+	m.send("WAITAOF", "1", "1", "5000")
+	m.expect("*2\r\n:1\r\n:1\r\n")
+	m.send("WAIT", "2", "100")
+	m.expect(":1\r\n")
+
+	r.send("WAIT", "0", "0")
+	r.expect("-ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.\r\n")
+	r.send("WAITAOF", "0", "0", "0")
+	r.expect("-ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.\r\n")
+}